@@ -2,19 +2,33 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"log/slog"
+	"net/http"
+	_ "net/http/pprof" // registers profiling handlers served by --pprof-addr
 	"os"
+	"path/filepath"
 	"runtime/debug"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/konflux-ci/capo/internal/conformance"
+	"github.com/konflux-ci/capo/internal/explore"
+	"github.com/konflux-ci/capo/internal/sink"
 	"github.com/konflux-ci/capo/pkg"
+	"github.com/konflux-ci/capo/pkg/buildflags"
 	"github.com/konflux-ci/capo/pkg/buildvars"
 	"github.com/konflux-ci/capo/pkg/containerfile"
+	"github.com/konflux-ci/capo/pkg/redact"
+	"github.com/konflux-ci/capo/pkg/stagereport"
+	"github.com/konflux-ci/capo/pkg/storageclient"
 )
 
 type args struct {
@@ -32,15 +46,187 @@ type args struct {
 	buildContexts map[string]string
 	// Cataloger selection expressions for syft (same syntax as syft --select-catalogers)
 	selectCatalogers []string
+	// Permission bits cleared from modes of content extracted for scanning
+	extractPermMask os.FileMode
+	// Number of catalogers run at the same time by syft
+	syftParallelism int
+	// Longest time of the syft scans of a package source
+	scanTimeout time.Duration
+	// Retries of storage operations failing on contention
+	retryPolicy capo.RetryPolicy
+	// Time to wait for builds holding the lock of the storage
+	waitForStore time.Duration
+	// Path to the state file of incremental scans
+	stateFile string
+	// Directory or tar archive to export extracted content to
+	exportContent string
+	// Address to serve net/http/pprof profiles on during the scan
+	pprofAddr string
+	// Path to a file with the buildah command used for the build
+	buildFlagsFile string
+	// Command to pipe the containerfile through before parsing
+	preprocess []string
+	// Stage report of the build, or the build log and iidfile to read it from
+	stageReport string
+	buildLog    string
+	iidFile     string
+	// Provenance of a BuildKit build (--frontend=buildkit) to read the stage
+	// report from
+	provenance string
+	// Built image to attribute content of stages without intermediate images by
+	builtImage string
+	// Fail instead of accessing the network for anything missing locally
+	offline bool
+	// Take packages of external images from SBOMs attached to them
+	referrerSBOMs bool
+	// Print owners of copied files instead of packages ("capo files")
+	files bool
+	// Lint the containerfile instead of scanning ("capo lint")
+	lint bool
+	// Explore the stages and the scan output interactively ("capo explore")
+	explore bool
+	// Check the environment instead of scanning ("capo doctor")
+	doctor bool
+	// Lowest severity of lint findings that fails "capo lint"
+	failOn capo.Severity
+	// Record the final stage base image, and scan it with scanBase
+	includeBase bool
+	scanBase    bool
+	// Platform the images were built for
+	platform storageclient.Platform
+	// Redaction of secret-looking build args in logs and output
+	redactor *redact.Redactor
+	// Fail on build args not declared in the containerfile
+	strictArgs bool
+	// Path to an SBOM of the build context
+	sourceSBOM string
+	// Path to an SBOM of the final stage base image
+	baseSBOM string
+	// Handling of content mounted by RUN --mount=from
+	mountPolicy capo.MountPolicy
+	// Extraction of content of images
+	extractor capo.ExtractorKind
+	// Run without mount privileges or a user namespace
+	unprivileged bool
+	// Path to a syft cataloger configuration file
+	syftConfig string
+	// Patterns of paths syft doesn't walk
+	excludePaths []string
+	// Path to write the output to instead of stdout
+	output string
+	// Log errors only
+	quiet bool
+	// Output format, "json" or "ndjson"
+	format string
+	// Pullspec by digest of the pushed built image to attach partial SBOMs to
+	pushReferrer string
+	// Registry credentials and certificates, as buildah --authfile and
+	// --cert-dir
+	authFile string
+	certDir  string
+	// Path or object store URL to store the output and partial SBOMs at
+	outputURL string
+	// Match the packages against the grype vulnerability DB
+	vulnScan bool
+	// Path to a policy file the output must not violate
+	policy string
+	// Patterns of keys of final stage labels to record
+	labels []string
+	// Record the provenance labels of origin images
+	originMetadata bool
+	// Record the COPY instructions every package was traced through
+	explain bool
+	// Log the source pattern every extracted path matched, or why it didn't
+	traceMatching bool
+	// Path to a hints file declaring content produced by RUN instructions
+	hints string
+	// Fail if copies to a scratch final stage resulted in no packages
+	verifyScratch bool
+	// Handling of images not pinned by digest, if required
+	requirePinned capo.PinPolicy
+	// Path to a file mapping pullspecs of the containerfile to the pullspecs
+	// the images were pulled by
+	pullspecMap string
+	// Skip builder stages the final stage doesn't depend on
+	pruneUnused bool
+	// Subcommand with flags of its own run instead of the scan (see
+	// subcommands) and its arguments
+	command     string
+	commandArgs []string
 }
 
 var ErrBuildContext = errors.New("invalid build context syntax, expected name=value")
 var ErrEnvVar = errors.New("invalid environment variable syntax")
 var ErrNoContainerfile = errors.New("containerfile argument is required")
 var ErrJSONEncode = errors.New("error while encoding package metadata")
+var ErrPermMask = errors.New("invalid permission mask, expected octal value up to 0777")
+var ErrFormat = errors.New("invalid output format, expected json or ndjson")
+var ErrPushReferrerOffline = errors.New("--push-referrer can't be used with --offline")
+var ErrStageReportSource = errors.New("--stage-report can't be used with --build-log")
+var ErrIIDFile = errors.New("--iidfile requires --stage-report, --build-log or --provenance")
+var ErrFrontend = errors.New("invalid frontend, expected buildah or buildkit")
+var ErrProvenance = errors.New("--provenance is required by and only used with --frontend=buildkit")
+var ErrProvenanceSource = errors.New("--provenance can't be used with --stage-report or --build-log")
+var ErrOutputURL = errors.New("--output-url can't be used with --output or --format=ndjson")
+var ErrVulnScanFormat = errors.New("--vuln-scan can't be used with --format=ndjson")
+var ErrDiffArgs = errors.New("diff requires the old and the new output")
+var ErrScanImageArgs = errors.New("scan-image requires the pullspec of the image and --paths")
+var ErrPostScanArgs = errors.New("post-scan requires --image and --containerfile")
+var ErrFilterSBOMArgs = errors.New("filter-sbom requires --sbom and --paths")
+var ErrPolicyMode = errors.New("--policy can't be used with capo files, capo lint, capo explore or capo doctor")
+
+// Subcommands with flags of their own, run instead of the scan. parseArgs
+// dispatches to them by the first argument.
+var subcommands = map[string]func(cmdArgs []string) error{
+	"conformance": runConformance,
+	"diff":        runDiff,
+	"scan-image":  runScanImage,
+	"post-scan":   runPostScan,
+	"filter-sbom": runFilterSBOM,
+	"version":     runVersion,
+}
 
 // Define and parse command line arguments and return an "args" struct or an error.
+// The subcommands with flags of their own (see subcommands) only get their
+// command and arguments set, their flags are parsed when they run.
+// The "files" subcommand (capo files [flags]) takes the same flags, and so
+// does the "lint" subcommand (capo lint [flags]), which ignores the flags of
+// the scan. So does the "explore" subcommand (capo explore [flags]), which
+// reads commands from stdin after the scan instead of printing its output, and
+// the "doctor" subcommand (capo doctor [flags]), for which --containerfile is
+// optional.
 func parseArgs() (args, error) {
+	cmdArgs := os.Args[1:]
+	if len(cmdArgs) > 0 && subcommands[cmdArgs[0]] != nil {
+		return args{command: cmdArgs[0], commandArgs: cmdArgs[1:]}, nil
+	}
+	files := len(cmdArgs) > 0 && cmdArgs[0] == "files"
+	lint := len(cmdArgs) > 0 && cmdArgs[0] == "lint"
+	explore := len(cmdArgs) > 0 && cmdArgs[0] == "explore"
+	doctor := len(cmdArgs) > 0 && cmdArgs[0] == "doctor"
+	if files || lint || explore || doctor {
+		cmdArgs = cmdArgs[1:]
+	}
+
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: %s [files|lint|explore|doctor] [flags]\n", os.Args[0])
+		fmt.Fprintf(out, "       %s conformance|diff|scan-image|post-scan|filter-sbom|version [flags]\n\n", os.Args[0])
+		fmt.Fprintln(out, "Prints packages copied to the final image by origin. With files, prints")
+		fmt.Fprintln(out, "the owning package of every copied file by origin instead. With lint,")
+		fmt.Fprintln(out, "prints patterns of the Containerfile capo can't attribute precisely")
+		fmt.Fprintln(out, "instead, without scanning. With explore, reads questions about the")
+		fmt.Fprintln(out, "attribution (e.g. \"why /usr/bin/app\") from stdin after the scan. With")
+		fmt.Fprintln(out, "doctor, checks the storage and the images of the Containerfile (if passed)")
+		fmt.Fprintln(out, "instead, without scanning. Other subcommands take flags of their own, see")
+		fmt.Fprintln(out, "their -h.")
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, "The JSON output is the only thing written to stdout (or --output), logs")
+		fmt.Fprintln(out, "are written to stderr.")
+		fmt.Fprintln(out)
+		flag.PrintDefaults()
+	}
+
 	cfPath := flag.String(
 		"containerfile",
 		"",
@@ -60,9 +246,9 @@ func parseArgs() (args, error) {
 	var buildArgFiles []string
 	flag.Func(
 		"build-arg-file",
-		"Path to a file of build arguments (one KEY=VALUE per line). " +
-		"Read before --build-arg values. Can be used multiple times.",
-		func (s string) error {
+		"Path to a file of build arguments (one KEY=VALUE per line). "+
+			"Read before --build-arg values. Can be used multiple times.",
+		func(s string) error {
 			buildArgFiles = append(buildArgFiles, s)
 			return nil
 		},
@@ -100,23 +286,457 @@ func parseArgs() (args, error) {
 		"Comma-separated cataloger selection expressions for syft (e.g. \"os,+rpm-db-cataloger,-python\").",
 	)
 
+	labelsFlag := flag.String(
+		"labels",
+		"",
+		"Comma-separated patterns of keys of labels of the final stage to record in the output "+
+			"(e.g. \"org.opencontainers.image.*\"), none by default.",
+	)
+
+	originMetadata := flag.Bool(
+		"origin-metadata",
+		false,
+		"Record the vcs-ref, version and vendor labels of every origin image under origin_metadata.",
+	)
+
+	explain := flag.Bool(
+		"explain",
+		false,
+		"Record for every package the chains of COPY instructions (stage, line, source and destination) "+
+			"through which its origin was traced from the final stage, under provenance.",
+	)
+
+	traceMatching := flag.Bool(
+		"trace-matching",
+		false,
+		"Log for every tar entry and image path considered for extraction the COPY source it matched, "+
+			"or why it was excluded. Rate limited, the number of dropped decisions is logged at the end.",
+	)
+
+	hints := flag.String(
+		"hints",
+		"",
+		"Path to a YAML file of hints declaring content of stages produced by RUN instructions "+
+			"from other content of the stage (e.g. /app/dist/ from /src), which is traced further to its origins.",
+	)
+
+	verifyScratch := flag.Bool(
+		"verify-scratch",
+		false,
+		"For a final stage based on scratch, fail after writing the output if a copied source resulted in "+
+			"no packages or isn't attributed (e.g. a binary the catalogers don't recognize).",
+	)
+
+	pruneUnused := flag.Bool(
+		"prune-unused",
+		false,
+		"Skip scanning builder stages the final stage doesn't depend on, which buildah doesn't build by default. "+
+			"They're reported as unused_stages either way.",
+	)
+
+	var requirePinned capo.PinPolicy
+	flag.Func(
+		"require-pinned",
+		"Require images referenced by FROM, COPY --from and RUN --mount=from to be pinned by digest: "+
+			"fail the scan listing the instructions referencing images by a tag (fail), or only warn about "+
+			"them (warn). Not required by default.",
+		func(s string) error {
+			var err error
+			requirePinned, err = capo.ParsePinPolicy(s)
+			return err
+		},
+	)
+
+	pullspecMap := flag.String(
+		"pullspec-map",
+		"",
+		"Path to a YAML file mapping pullspecs of the containerfile to the pullspecs the images were "+
+			"actually pulled by (e.g. mirrors of registries.conf), which are looked up in storage. "+
+			"Packages of mapped images report both.",
+	)
+
+	output := flag.String(
+		"output",
+		"",
+		"Path to write the JSON output to instead of stdout.",
+	)
+
+	format := flag.String(
+		"format",
+		"json",
+		"Output format: a single JSON document (json), or one JSON object per line for every package, "+
+			"warning and the final stats as soon as they're known (ndjson). "+
+			"ndjson isn't supported by files, lint, explore and doctor.",
+	)
+
+	outputURL := flag.String(
+		"output-url",
+		"",
+		"Path or object store URL (s3://bucket/key or gs://bucket/key) to store the JSON output at "+
+			"instead of printing it, with the packages of every origin stored under KEY.partial/.",
+	)
+
+	pushReferrer := flag.String(
+		"push-referrer",
+		"",
+		"Pullspec of the built image pushed to a registry, by digest (e.g. quay.io/org/app@sha256:...). "+
+			"The packages of every origin are pushed to its repository as an OCI artifact referring to the image.",
+	)
+	authFile := flag.String(
+		"authfile",
+		"",
+		"Path to the registry auth file, as buildah --authfile, for fetching attached SBOMs and pushing referrers. "+
+			"Defaults to the usual containers auth files, including $REGISTRY_AUTH_FILE.",
+	)
+	certDir := flag.String(
+		"cert-dir",
+		"",
+		"Directory of certificates (*.crt) and client certificates (*.cert, *.key) to access registries with, "+
+			"as buildah --cert-dir.",
+	)
+
+	policy := flag.String(
+		"policy",
+		"",
+		"Path to a YAML file with rules denying packages of the output. "+
+			"The output is still written, but capo exits with an error if any package violates a rule.",
+	)
+
+	vulnScan := flag.Bool(
+		"vuln-scan",
+		false,
+		"Match the packages against the vulnerability DB of grype (which must be in PATH) "+
+			"and record their known vulnerabilities. With --offline, the DB isn't updated.",
+	)
+
+	quiet := flag.Bool(
+		"quiet",
+		false,
+		"Only log errors to stderr.",
+	)
+
+	syftConfig := flag.String(
+		"syft-config",
+		"",
+		"Path to a YAML file with the configuration of syft catalogers (e.g. binary classifiers to use). "+
+			"With --offline, its options which access the network are turned off.",
+	)
+
+	var excludePaths []string
+	flag.Func(
+		"exclude-path",
+		"Glob pattern of paths syft doesn't walk in the scanned content, relative to its root and starting "+
+			"with ./, */ or **/ (e.g. ./usr/share/doc/**). Packages under the paths are missing from the output. "+
+			"Can be used multiple times.",
+		func(s string) error {
+			excludePaths = append(excludePaths, s)
+			return nil
+		},
+	)
+
+	extractPermMask := capo.DefaultExtractPermMask
+	flag.Func(
+		"extract-perm-mask",
+		fmt.Sprintf(
+			"Octal mask of permission bits cleared from the original modes of content extracted for scanning, "+
+				"like a umask (default %#o).",
+			capo.DefaultExtractPermMask,
+		),
+		func(s string) error {
+			mask, err := strconv.ParseUint(s, 8, 32)
+			if err != nil || mask > 0o777 {
+				return ErrPermMask
+			}
+			extractPermMask = os.FileMode(mask)
+			return nil
+		},
+	)
+
+	syftParallelism := flag.Int(
+		"syft-parallelism",
+		0,
+		"Number of catalogers syft runs at the same time when scanning content. Syft's default if 0.",
+	)
+
+	scanTimeout := flag.Duration(
+		"scan-timeout",
+		0,
+		"Longest time the syft scans of a package source (a builder stage with its chained stages, or an "+
+			"external image) or of the base image may take. A source exceeding it is reported by a "+
+			"WARN_SCAN_TIMEOUT warning and its packages are missing. Disabled if 0.",
+	)
+
+	storageRetries := flag.Int(
+		"storage-retries",
+		capo.DefaultRetryPolicy.Attempts-1,
+		"Number of retries of storage operations (lookups, mounts, layer diffs) failing on contention, "+
+			"e.g. with parallel build steps using the same storage.",
+	)
+	storageRetryBackoff := flag.Duration(
+		"storage-retry-backoff",
+		capo.DefaultRetryPolicy.Backoff,
+		"Wait before the first retry of a storage operation, doubled for every further retry.",
+	)
+
+	waitForStore := flag.Duration(
+		"wait-for-store",
+		0,
+		"Hold a shared lock of capo.lock in the run root of the storage during the scan, waiting at most "+
+			"this long for builds holding it (e.g. flock <runroot>/capo.lock buildah build ...). Disabled if 0.",
+	)
+
+	stateFile := flag.String(
+		"state-file",
+		"",
+		"Path to a state file to persist the packages of every package source to, and to reuse them from "+
+			"for sources whose images have the same layers as in a previous scan. Disabled if empty.",
+	)
+
+	exportContent := flag.String(
+		"export-content",
+		"",
+		"Export the content extracted for scanning, as scanned with syft, to this directory, or to a tar "+
+			"archive if it ends with .tar, as <origin>/<kind>/. Disabled if empty.",
+	)
+
+	pprofAddr := flag.String(
+		"pprof-addr",
+		"",
+		"Address (e.g. localhost:6060) to serve net/http/pprof profiles on during the scan. Disabled if empty.",
+	)
+
+	buildFlagsFile := flag.String(
+		"build-flags-file",
+		"",
+		"Path to a file with the full buildah command used for the build, as shell words "+
+			"(e.g. written by printf '%q '). Recorded in the output.",
+	)
+
+	preprocess := flag.String(
+		"preprocess",
+		"",
+		"Command to pipe the containerfile through before parsing, as shell words (e.g. 'm4 -DVERSION=1'), "+
+			"for templated containerfiles. Runs in the directory of the containerfile and is recorded "+
+			"in the output with the digest of its executable.",
+	)
+
+	stageReport := flag.String(
+		"stage-report",
+		"",
+		"Path to the stage report of the build (see docs/stage-report.md). Intermediate images of "+
+			"stages are taken from it instead of being found by their stage label.",
+	)
+	buildLog := flag.String(
+		"build-log",
+		"",
+		"Path to the output of buildah build (--logfile) to read the stage report from.",
+	)
+	iidFile := flag.String(
+		"iidfile",
+		"",
+		"Path to the image ID written by buildah build --iidfile, checked against the stage report.",
+	)
+	frontend := flag.String(
+		"frontend",
+		"buildah",
+		"Frontend of the build: buildah, or buildkit (experimental) with --provenance.",
+	)
+	provenance := flag.String(
+		"provenance",
+		"",
+		"Path to the SLSA provenance of a BuildKit build (--provenance=mode=max) to read the snapshots "+
+			"of stages from, with --frontend=buildkit.",
+	)
+	builtImage := flag.String(
+		"built-image",
+		"",
+		"Pullspec or ID of the built image in local storage. Content copied from stages without intermediate "+
+			"images is attributed by the history of its layers.",
+	)
+
+	offline := flag.Bool(
+		"offline",
+		false,
+		"Guarantee no network access during the scan. All origin images must be in local storage, "+
+			"the scan fails listing the missing ones otherwise.",
+	)
+
+	referrerSBOMs := flag.Bool(
+		"referrer-sboms",
+		false,
+		"Take the packages of content copied from external images from SBOMs attached to the images in their "+
+			"registry (referrers tag or cosign), scanning the content only if there is none. Ignored with --offline.",
+	)
+
+	includeBase := flag.Bool(
+		"include-base",
+		false,
+		"Record the final stage base image and its digest in the output.",
+	)
+
+	scanBase := flag.Bool(
+		"scan-base",
+		false,
+		"Also scan the whole final stage base image and add its packages with origin type \"base\". "+
+			"Implies --include-base.",
+	)
+
+	var platform storageclient.Platform
+	flag.Func(
+		"platform",
+		"Platform (os/arch[/variant]) passed to buildah, if any. Selects the image of manifest lists. "+
+			"Defaults to the platform capo runs on.",
+		func(value string) error {
+			var err error
+			platform, err = storageclient.ParsePlatform(value)
+			return err
+		},
+	)
+
+	var redactPatterns []string
+	flag.Func(
+		"redact-pattern",
+		"Regular expression matching names of build args and environment variables whose values are "+
+			"redacted in logs and output, in addition to the defaults (e.g. TOKEN, PASSWORD, SECRET). "+
+			"Can be used multiple times.",
+		func(s string) error {
+			redactPatterns = append(redactPatterns, s)
+			return nil
+		},
+	)
+
+	strictArgs := flag.Bool(
+		"strict-args",
+		false,
+		"Fail on build args not declared by any ARG instruction in the Containerfile "+
+			"(buildah only warns about them).",
+	)
+
+	sourceSBOM := flag.String(
+		"source-sbom",
+		"",
+		"Path to an SBOM of the build context (e.g. by cachi2 or syft, in syft JSON, CycloneDX or SPDX format). "+
+			"Its packages copied to the final image from the build context are added with origin type \"context\".",
+	)
+	baseSBOM := flag.String(
+		"base-sbom",
+		"",
+		"Path to an SBOM of the final stage base image (in syft JSON, CycloneDX or SPDX format). Copied packages "+
+			"with a purl in it are marked with duplicate_of_base, as are those found by --scan-base.",
+	)
+
+	mountPolicy := capo.MountPolicyFail
+	flag.Func(
+		"mount-policy",
+		"Handling of content of stages and images mounted by RUN --mount=from=..., which the build uses "+
+			"but which isn't in the final image: fail the scan (fail), leave it out (ignore), "+
+			"record the mounts under build_dependencies (report) or also scan the mounted content and "+
+			"add its packages with build_time set (scan). Default fail.",
+		func(s string) error {
+			var err error
+			mountPolicy, err = capo.ParseMountPolicy(s)
+			return err
+		},
+	)
+
+	var extractor capo.ExtractorKind
+	flag.Func(
+		"extractor",
+		"Extraction of content of images: copy from mounted images (mount), or apply the layers of images "+
+			"streamed from the storage (layers), which needs no mount privileges but reads all layers. "+
+			"Default mount.",
+		func(s string) error {
+			var err error
+			extractor, err = capo.ParseExtractorKind(s)
+			return err
+		},
+	)
+
+	unprivileged := flag.Bool(
+		"unprivileged",
+		false,
+		"Run without mount privileges or a user namespace (e.g. outside buildah unshare): extract content "+
+			"with the layers extractor and set up the storage without mounts. Layers must be readable by the "+
+			"current user.",
+	)
+
 	target := flag.String(
 		"target",
 		"",
 		"Build target passed to buildah, if any.",
 	)
 
-	flag.Parse()
+	failOn := capo.SeverityError
+	flag.Func(
+		"fail-on",
+		"Lowest severity (error, warning or info) of findings that fails capo lint (default error).",
+		func(s string) error {
+			var err error
+			failOn, err = capo.ParseSeverity(s)
+			return err
+		},
+	)
+
+	// flag.ExitOnError: exits on invalid flags
+	_ = flag.CommandLine.Parse(cmdArgs)
 
-	if *cfPath == "" {
+	if *cfPath == "" && !doctor {
 		flag.Usage()
 		return args{}, ErrNoContainerfile
 	}
 
+	if *format != "json" && (*format != "ndjson" || files || lint || explore || doctor) {
+		return args{}, fmt.Errorf("%w: %q", ErrFormat, *format)
+	}
+
+	if *policy != "" && (files || lint || explore || doctor) {
+		return args{}, ErrPolicyMode
+	}
+	if *vulnScan && *format == "ndjson" {
+		return args{}, ErrVulnScanFormat
+	}
+	if *pushReferrer != "" && *offline {
+		return args{}, ErrPushReferrerOffline
+	}
+	if *stageReport != "" && *buildLog != "" {
+		return args{}, ErrStageReportSource
+	}
+	if *iidFile != "" && *stageReport == "" && *buildLog == "" && *provenance == "" {
+		return args{}, ErrIIDFile
+	}
+	if *frontend != "buildah" && *frontend != "buildkit" {
+		return args{}, ErrFrontend
+	}
+	if (*frontend == "buildkit") != (*provenance != "") {
+		return args{}, ErrProvenance
+	}
+	if *provenance != "" && (*stageReport != "" || *buildLog != "") {
+		return args{}, ErrProvenanceSource
+	}
+	if *outputURL != "" && (*output != "" || *format == "ndjson") {
+		return args{}, ErrOutputURL
+	}
+
+	redactor, err := redact.New(redactPatterns...)
+	if err != nil {
+		return args{}, err
+	}
+
+	var preprocessCommand []string
+	if *preprocess != "" {
+		if preprocessCommand, err = buildflags.Split(*preprocess); err != nil {
+			return args{}, fmt.Errorf("invalid --preprocess: %w", err)
+		}
+	}
+
 	var selectCatalogers []string
 	if *selectCatalogersFlag != "" {
 		selectCatalogers = strings.Split(*selectCatalogersFlag, ",")
 	}
+	var labels []string
+	if *labelsFlag != "" {
+		labels = strings.Split(*labelsFlag, ",")
+	}
 
 	return args{
 		containerfilePath: *cfPath,
@@ -126,23 +746,80 @@ func parseArgs() (args, error) {
 		envVars:           buildEnvVars,
 		buildContexts:     buildContexts,
 		selectCatalogers:  selectCatalogers,
+		extractPermMask:   extractPermMask,
+		syftParallelism:   *syftParallelism,
+		scanTimeout:       *scanTimeout,
+		retryPolicy: capo.RetryPolicy{
+			Attempts:   *storageRetries + 1,
+			Backoff:    *storageRetryBackoff,
+			MaxBackoff: capo.DefaultRetryPolicy.MaxBackoff,
+		},
+		waitForStore:   *waitForStore,
+		stateFile:      *stateFile,
+		exportContent:  *exportContent,
+		pprofAddr:      *pprofAddr,
+		buildFlagsFile: *buildFlagsFile,
+		preprocess:     preprocessCommand,
+		stageReport:    *stageReport,
+		buildLog:       *buildLog,
+		iidFile:        *iidFile,
+		provenance:     *provenance,
+		builtImage:     *builtImage,
+		offline:        *offline,
+		referrerSBOMs:  *referrerSBOMs,
+		files:          files,
+		lint:           lint,
+		explore:        explore,
+		doctor:         doctor,
+		failOn:         failOn,
+		includeBase:    *includeBase,
+		scanBase:       *scanBase,
+		platform:       platform,
+		redactor:       redactor,
+		strictArgs:     *strictArgs,
+		sourceSBOM:     *sourceSBOM,
+		baseSBOM:       *baseSBOM,
+		mountPolicy:    mountPolicy,
+		extractor:      extractor,
+		unprivileged:   *unprivileged,
+		syftConfig:     *syftConfig,
+		excludePaths:   excludePaths,
+		output:         *output,
+		quiet:          *quiet,
+		format:         *format,
+		pushReferrer:   *pushReferrer,
+		authFile:       *authFile,
+		certDir:        *certDir,
+		outputURL:      *outputURL,
+		vulnScan:       *vulnScan,
+		policy:         *policy,
+		labels:         labels,
+		originMetadata: *originMetadata,
+		explain:        *explain,
+		traceMatching:  *traceMatching,
+		hints:          *hints,
+		verifyScratch:  *verifyScratch,
+		requirePinned:  requirePinned,
+		pullspecMap:    *pullspecMap,
+		pruneUnused:    *pruneUnused,
 	}, nil
 }
 
 // Build buildah-specific arguments from capo commandline arguments.
 // These are used in the containerfile parser.
-func buildOptsFromArgs(args args) (containerfile.BuildOptions, error) {
+func buildOptsFromArgs(args args) ([]containerfile.ParseOption, error) {
 	buildArgs, err := buildvars.ParseAndMerge(args.buildArgFiles, args.buildArgs)
 	if err != nil {
-		return containerfile.BuildOptions{},
-			fmt.Errorf("failed to parse build args: %w", err)
+		return nil, fmt.Errorf("failed to parse build args: %w", err)
 	}
 
-	return containerfile.BuildOptions{
-		Args:          buildArgs,
-		EnvVars:       args.envVars,
-		Target:        args.target,
-		BuildContexts: args.buildContexts,
+	return []containerfile.ParseOption{
+		containerfile.WithArgs(buildArgs),
+		containerfile.WithEnvVars(args.envVars),
+		containerfile.WithTarget(args.target),
+		containerfile.WithBuildContexts(args.buildContexts),
+		containerfile.WithPlatform(args.platform.OS, args.platform.Architecture, args.platform.Variant),
+		containerfile.WithStrictArgs(args.strictArgs),
 	}, nil
 }
 
@@ -162,67 +839,886 @@ func logRevision() {
 }
 
 func main() {
-	logRevision()
-
 	args, err := parseArgs()
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
+	if args.command != "" {
+		if err := subcommands[args.command](args.commandArgs); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+	if !args.quiet {
+		logRevision()
+	}
+	if args.doctor {
+		runDoctor(args)
+		return
+	}
+
+	cf, preprocessor := parseContainerfile(args)
+	if !args.quiet {
+		log.Printf("Parsed stages: %+v", capo.RedactStages(cf.Stages, args.redactor))
+	}
+
+	if args.lint {
+		runLint(cf, args)
+		return
+	}
+
+	// read before the scan, so an invalid file doesn't waste it
+	var invocation *buildflags.Invocation
+	if args.buildFlagsFile != "" {
+		inv, err := buildflags.ReadFile(args.buildFlagsFile)
+		if err != nil {
+			log.Fatalf("Failed to read build flags: %+v", err)
+		}
+		// buildah falls back to the environment when --isolation isn't passed
+		if inv.Isolation == "" {
+			inv.Isolation = os.Getenv("BUILDAH_ISOLATION")
+		}
+		inv.Command = args.redactor.Args(inv.Command)
+		invocation = &inv
+	}
+	report, err := readStageReport(args)
+	if err != nil {
+		log.Fatalf("Failed to read stage report: %+v", err)
+	}
+	var policy *capo.Policy
+	if args.policy != "" {
+		p, err := capo.ReadPolicy(args.policy)
+		if err != nil {
+			log.Fatalf("Failed to read policy: %+v", err)
+		}
+		policy = &p
+	}
+	var hints capo.Hints
+	if args.hints != "" {
+		if hints, err = capo.ReadHints(args.hints); err != nil {
+			log.Fatalf("Failed to read hints: %+v", err)
+		}
+	}
+	var pullspecMap capo.PullspecMap
+	if args.pullspecMap != "" {
+		if pullspecMap, err = capo.ReadPullspecMap(args.pullspecMap); err != nil {
+			log.Fatalf("Failed to read pullspec map: %+v", err)
+		}
+	}
+
+	level := slog.LevelDebug
+	if args.quiet {
+		level = slog.LevelError
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: level,
+	}))
+
+	if args.pprofAddr != "" {
+		servePprof(args.pprofAddr, logger)
+	}
+
+	var events *ndjsonOutput
+	if args.format == "ndjson" {
+		events = &ndjsonOutput{}
+	}
+
+	scanner, err := capo.NewScanner(
+		capo.WithLogger(logger),
+		capo.WithSelectCatalogers(args.selectCatalogers...),
+		capo.WithExtractPermMask(args.extractPermMask),
+		capo.WithSyftParallelism(args.syftParallelism),
+		capo.WithScanTimeout(args.scanTimeout),
+		capo.WithRetryPolicy(args.retryPolicy),
+		capo.WithWaitForStore(args.waitForStore),
+		capo.WithStateFile(args.stateFile),
+		capo.WithExportContent(args.exportContent),
+		capo.WithOffline(args.offline),
+		capo.WithReferrerSBOMs(args.referrerSBOMs),
+		capo.WithAuthFile(args.authFile),
+		capo.WithCertDir(args.certDir),
+		capo.WithFileOwnership(args.files || args.explore),
+		capo.WithIncludeBase(args.includeBase),
+		capo.WithScanBase(args.scanBase),
+		capo.WithPlatform(args.platform),
+		capo.WithRedactor(args.redactor),
+		capo.WithSourceSBOM(args.sourceSBOM),
+		capo.WithBaseSBOM(args.baseSBOM),
+		capo.WithMountPolicy(args.mountPolicy),
+		capo.WithExtractor(args.extractor),
+		capo.WithUnprivileged(args.unprivileged),
+		capo.WithSyftConfig(args.syftConfig),
+		capo.WithExcludePaths(args.excludePaths...),
+		capo.WithLabels(args.labels...),
+		capo.WithOriginMetadata(args.originMetadata),
+		capo.WithExplain(args.explain),
+		capo.WithTraceMatching(args.traceMatching),
+		capo.WithHints(hints),
+		capo.WithVerifyScratch(args.verifyScratch),
+		capo.WithRequirePinned(args.requirePinned),
+		capo.WithPullspecMap(pullspecMap),
+		capo.WithPruneUnused(args.pruneUnused),
+		capo.WithStageReport(report),
+		capo.WithBuiltImage(args.builtImage),
+		capo.WithEventHandler(events.handler()),
+		capo.WithPostScanHooks(postScanHooks(args)...),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create scanner: %+v", err)
+	}
+
+	if events != nil {
+		pkgMetadata, err := events.run(scanner, cf, args.output, invocation, preprocessor)
+		if err != nil {
+			log.Fatalf("Failed to scan stages: %+v", err)
+		}
+		pushReferrers(args, pkgMetadata, logger)
+		enforcePolicy(policy, pkgMetadata, logger)
+		enforceScratchVerification(args, pkgMetadata)
+		return
+	}
+
+	pkgMetadata, err := scanner.Scan(cf)
+	if err != nil {
+		log.Fatalf("Failed to scan stages: %+v", err)
+	}
+	pkgMetadata.Build = invocation
+	pkgMetadata.Preprocessor = preprocessor
+	pushReferrers(args, pkgMetadata, logger)
+
+	if args.explore {
+		if err := explore.New(cf, pkgMetadata).Run(os.Stdin, os.Stdout); err != nil {
+			log.Fatalf("Failed to explore: %+v", err)
+		}
+		return
+	}
+
+	if args.files {
+		err = writeOutput(args, fileOutput{Files: pkgMetadata.Files, Warnings: pkgMetadata.Warnings})
+	} else {
+		err = writeOutput(args, pkgMetadata)
+	}
+	if err != nil {
+		log.Fatalf("Failed to serialize and print output: %+v", err)
+	}
+	enforcePolicy(policy, pkgMetadata, logger)
+	enforceScratchVerification(args, pkgMetadata)
+}
 
-	r, err := os.Open(args.containerfilePath)
+// parseContainerfile reads the containerfile, piped through the --preprocess
+// command if passed, and parses it. Returns the preprocessor, with its
+// command redacted, or nil without --preprocess.
+func parseContainerfile(args args) (containerfile.Containerfile, *capo.Preprocessor) {
+	f, err := os.Open(args.containerfilePath)
 	if err != nil {
 		log.Fatalf("Could not open %s: %+v", args.containerfilePath, err)
 	}
 	defer func() {
-		if r.Close() != nil {
+		if f.Close() != nil {
 			log.Fatalf("Could not close %s", args.containerfilePath)
 		}
 	}()
 
+	var r io.Reader = f
+	var preprocessor *capo.Preprocessor
+	if len(args.preprocess) > 0 {
+		content, p, err := capo.Preprocess(args.preprocess, f, filepath.Dir(args.containerfilePath))
+		if err != nil {
+			log.Fatalf("Failed to preprocess containerfile: %+v", err)
+		}
+		p.Command = args.redactor.Args(p.Command)
+		preprocessor = &p
+		r = bytes.NewReader(content)
+	}
+
 	buildOpts, err := buildOptsFromArgs(args)
 	if err != nil {
 		log.Fatalf("Failed to create build options: %+v", err)
 	}
-
-	cf, err := containerfile.Parse(r, buildOpts)
+	cf, err := containerfile.Parse(r, buildOpts...)
 	if err != nil {
 		log.Fatalf("Failed to parse containerfile %+v", err)
 	}
-	log.Printf("Parsed stages: %+v", cf.Stages)
+	return cf, preprocessor
+}
 
-	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
-		Level: slog.LevelDebug,
-	}))
+// readStageReport reads the stage report of the build from --stage-report,
+// --build-log or --provenance, checked against the image ID of --iidfile if
+// passed. Returns nil if none is passed.
+func readStageReport(args args) (*stagereport.Report, error) {
+	var report stagereport.Report
+	switch {
+	case args.stageReport != "":
+		r, err := stagereport.ReadFile(args.stageReport)
+		if err != nil {
+			return nil, err
+		}
+		report = r
+	case args.buildLog != "":
+		f, err := os.Open(args.buildLog)
+		if err != nil {
+			return nil, err
+		}
+		r, err := stagereport.FromLog(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("in %s: %w", args.buildLog, err)
+		}
+		report = r
+	case args.provenance != "":
+		r, err := stagereport.ReadProvenanceFile(args.provenance)
+		if err != nil {
+			return nil, err
+		}
+		report = r
+	default:
+		return nil, nil
+	}
+
+	if args.iidFile != "" {
+		id, err := stagereport.ReadIIDFile(args.iidFile)
+		if err != nil {
+			return nil, err
+		}
+		if report, err = report.WithImageID(id); err != nil {
+			return nil, err
+		}
+	}
+	return &report, nil
+}
 
+// enforcePolicy logs the violations of the policy, if set, by the scan output
+// and exits with an error if there are any.
+func enforcePolicy(policy *capo.Policy, pkgMetadata capo.PackageMetadata, logger *slog.Logger) {
+	if policy == nil {
+		return
+	}
+	violations := policy.Evaluate(pkgMetadata)
+	for _, v := range violations {
+		logger.Error("policy violation",
+			"rule", v.Rule, "message", v.Message, "purl", v.PackageURL,
+			"origin_type", v.OriginType, "pullspec", v.Pullspec)
+	}
+	if len(violations) > 0 {
+		log.Fatalf("Found %d policy violations", len(violations))
+	}
+}
+
+// enforceScratchVerification exits with an error if --verify-scratch is
+// passed and the scan reported copies to the scratch final stage without
+// packages.
+func enforceScratchVerification(args args, pkgMetadata capo.PackageMetadata) {
+	if !args.verifyScratch {
+		return
+	}
+	if err := capo.ScratchVerificationFailed(pkgMetadata); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+// runDoctor prints the checks of the environment and exits with an error if
+// any failed. The containerfile is parsed if passed, to check its images.
+func runDoctor(args args) {
+	var cf *containerfile.Containerfile
+	if args.containerfilePath != "" {
+		parsed, _ := parseContainerfile(args)
+		cf = &parsed
+	}
+
+	level := slog.LevelDebug
+	if args.quiet {
+		level = slog.LevelError
+	}
+	checks := capo.Doctor(cf,
+		capo.WithLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))),
+		capo.WithRetryPolicy(args.retryPolicy),
+		capo.WithExtractor(args.extractor),
+		capo.WithUnprivileged(args.unprivileged),
+		capo.WithIncludeBase(args.includeBase),
+		capo.WithPlatform(args.platform),
+		capo.WithSyftConfig(args.syftConfig),
+		capo.WithExcludePaths(args.excludePaths...),
+	)
+	if err := writeOutput(args, doctorOutput{Checks: checks}); err != nil {
+		log.Fatalf("Failed to serialize and print output: %+v", err)
+	}
+	if err := capo.DoctorFailed(checks); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+// runLint prints the lint findings of the containerfile and exits with an
+// error if any of them is at least as severe as args.failOn.
+func runLint(cf containerfile.Containerfile, args args) {
+	failOn := args.failOn
+	findings := capo.Lint(cf)
+	if err := writeOutput(args, lintOutput{Findings: findings}); err != nil {
+		log.Fatalf("Failed to serialize and print output: %+v", err)
+	}
+
+	failing := 0
+	for _, f := range findings {
+		if f.Severity.AtLeast(failOn) {
+			failing++
+		}
+	}
+	if failing > 0 {
+		log.Fatalf("Found %d lint findings of severity %s or higher", failing, failOn)
+	}
+}
+
+// runConformance runs the containerfile parser over a corpus of
+// Containerfiles and compares the parsed stages with golden files. It is a
+// development command ("capo conformance [--update] [corpus-dir]").
+func runConformance(cmdArgs []string) error {
+	fs := flag.NewFlagSet("conformance", flag.ExitOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: %s conformance [flags] [corpus-dir]\n\n", os.Args[0])
+		fmt.Fprintln(out, "Parses every Containerfile of the corpus (default testdata/corpus) and")
+		fmt.Fprintln(out, "compares the stages with the golden files.")
+		fmt.Fprintln(out)
+		fs.PrintDefaults()
+	}
+	update := fs.Bool("update", false, "Write the golden files from the parsed stages instead of comparing.")
+	// flag.ExitOnError: exits on invalid flags
+	_ = fs.Parse(cmdArgs)
+
+	corpusDir := filepath.Join("testdata", "corpus")
+	if fs.NArg() > 0 {
+		corpusDir = fs.Arg(0)
+	}
+
+	results, err := conformance.Run(corpusDir, *update)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %v\n", result.Name, result.Err)
+		} else {
+			fmt.Printf("ok   %s\n", result.Name)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d corpus cases failed", failed, len(results))
+	}
+	return nil
+}
+
+// runDiff prints the differences between the packages of two scan outputs by
+// origin ("capo diff [flags] old.json new.json"), and fails if any are of a
+// kind passed to fail on.
+func runDiff(cmdArgs []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: %s diff [flags] old.json new.json\n\n", os.Args[0])
+		fmt.Fprintln(out, "Prints the packages added, removed and changed (another version) by origin")
+		fmt.Fprintln(out, "between two outputs of capo as JSON.")
+		fmt.Fprintln(out)
+		fs.PrintDefaults()
+	}
+	failOnAdded := fs.Bool("fail-on-added", false, "Exit with an error if any package was added.")
+	failOnRemoved := fs.Bool("fail-on-removed", false, "Exit with an error if any package was removed.")
+	failOnChanged := fs.Bool("fail-on-changed", false, "Exit with an error if any package was changed.")
+	// flag.ExitOnError: exits on invalid flags
+	_ = fs.Parse(cmdArgs)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return ErrDiffArgs
+	}
+	old, err := readOutput(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	new, err := readOutput(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	origins := capo.Diff(old, new)
+	if err := printJSON("", diffOutput{Origins: origins}); err != nil {
+		return err
+	}
+
+	var added, removed, changed int
+	for _, origin := range origins {
+		added += len(origin.Added)
+		removed += len(origin.Removed)
+		changed += len(origin.Changed)
+	}
+	if (*failOnAdded && added > 0) || (*failOnRemoved && removed > 0) || (*failOnChanged && changed > 0) {
+		return fmt.Errorf("found %d added, %d removed and %d changed packages", added, removed, changed)
+	}
+	return nil
+}
+
+// runVersion prints the versions of capo, the libraries it scans with and the
+// formats it supports ("capo version [--json]").
+func runVersion(cmdArgs []string) error {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: %s version [flags]\n\n", os.Args[0])
+		fmt.Fprintln(out, "Prints the versions of capo, of the syft and containers/storage libraries")
+		fmt.Fprintln(out, "and of the supported output, stage report and state file formats.")
+		fmt.Fprintln(out)
+		fs.PrintDefaults()
+	}
+	asJSON := fs.Bool("json", false, "Print the versions as JSON.")
+	// flag.ExitOnError: exits on invalid flags
+	_ = fs.Parse(cmdArgs)
+
+	version := capo.Version()
+	if *asJSON {
+		return printJSON("", version)
+	}
+
+	revision := version.Revision
+	if revision == "" {
+		revision = "unknown"
+	} else if version.Modified {
+		revision += " (modified)"
+	}
+	fmt.Printf("capo:               %s\n", version.Version)
+	fmt.Printf("revision:           %s\n", revision)
+	fmt.Printf("go:                 %s\n", version.GoVersion)
+	fmt.Printf("syft:               %s\n", version.Syft)
+	fmt.Printf("containers/storage: %s\n", version.Storage)
+	fmt.Printf("output schemas:     %v\n", version.Schemas.Output)
+	fmt.Printf("stage reports:      %v\n", version.Schemas.StageReport)
+	fmt.Printf("state files:        %v\n", version.Schemas.State)
+	return nil
+}
+
+// runScanImage scans paths of an image in local storage without a
+// containerfile ("capo scan-image [flags] PULLSPEC") and prints the output.
+func runScanImage(cmdArgs []string) error {
+	fs := flag.NewFlagSet("scan-image", flag.ExitOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: %s scan-image [flags] PULLSPEC\n\n", os.Args[0])
+		fmt.Fprintln(out, "Prints packages found in paths of an image in local storage, without a")
+		fmt.Fprintln(out, "Containerfile, as if the paths were copied from the image.")
+		fmt.Fprintln(out)
+		fs.PrintDefaults()
+	}
+	pathsFlag := fs.String(
+		"paths", "", "Comma-separated absolute paths of the image to scan (e.g. \"/usr/bin/helm,/app/\"). Required.",
+	)
+	files := fs.Bool("files", false, "Record the owning package of every scanned file in the output.")
+	selectCatalogers := fs.String(
+		"select-catalogers",
+		"",
+		"Comma-separated cataloger selection expressions for syft (e.g. \"os,+rpm-db-cataloger,-python\").",
+	)
+	syftConfig := fs.String("syft-config", "", "Path to a YAML file with the configuration of syft catalogers.")
+	output := fs.String("output", "", "Path to write the JSON output to instead of stdout.")
+	quiet := fs.Bool("quiet", false, "Only log errors.")
+	var platform storageclient.Platform
+	fs.Func(
+		"platform",
+		"Platform (os/arch[/variant]) of the image. Selects the image of manifest lists. "+
+			"Defaults to the platform capo runs on.",
+		func(value string) error {
+			var err error
+			platform, err = storageclient.ParsePlatform(value)
+			return err
+		},
+	)
+	// flag.ExitOnError: exits on invalid flags. Flags may also follow the
+	// pullspec.
+	_ = fs.Parse(cmdArgs)
+	pullspec := fs.Arg(0)
+	if fs.NArg() > 0 {
+		_ = fs.Parse(fs.Args()[1:])
+	}
+	if pullspec == "" || fs.NArg() > 0 || *pathsFlag == "" {
+		fs.Usage()
+		return ErrScanImageArgs
+	}
+
+	var catalogers []string
+	if *selectCatalogers != "" {
+		catalogers = strings.Split(*selectCatalogers, ",")
+	}
+	level := slog.LevelDebug
+	if *quiet {
+		level = slog.LevelError
+	}
 	scanner, err := capo.NewScanner(
-		capo.WithLogger(logger),
-		capo.WithSelectCatalogers(args.selectCatalogers...),
+		capo.WithLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))),
+		capo.WithSelectCatalogers(catalogers...),
+		capo.WithSyftConfig(*syftConfig),
+		capo.WithPlatform(platform),
+		capo.WithFileOwnership(*files),
 	)
 	if err != nil {
-		log.Fatalf("Failed to create scanner: %+v", err)
+		return fmt.Errorf("failed to create scanner: %w", err)
+	}
+
+	pkgMetadata, err := scanner.ScanImage(pullspec, strings.Split(*pathsFlag, ","))
+	if err != nil {
+		return fmt.Errorf("failed to scan image: %w", err)
+	}
+	return printJSON(*output, pkgMetadata)
+}
+
+// runPostScan scans the final image of a build of a containerfile by the
+// destinations of its copies ("capo post-scan --image IMAGE@sha256:...
+// --containerfile FILE") and prints the output.
+func runPostScan(cmdArgs []string) error {
+	fs := flag.NewFlagSet("post-scan", flag.ExitOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: %s post-scan [flags]\n\n", os.Args[0])
+		fmt.Fprintln(out, "Prints packages copied to the final stage of the Containerfile by origin,")
+		fmt.Fprintln(out, "found at the destinations of the copies in the built image in local storage,")
+		fmt.Fprintln(out, "e.g. of an image built before capo was enabled.")
+		fmt.Fprintln(out)
+		fs.PrintDefaults()
+	}
+	image := fs.String("image", "", "Pullspec of the built image, pinned by digest (IMAGE@sha256:...). Required.")
+	cfPath := fs.String("containerfile", "", "Path to the Containerfile of the build. Required.")
+	var buildArgs []string
+	fs.Func(
+		"build-arg",
+		"Build argument of the build in the form KEY=VALUE. Can be used multiple times.",
+		func(s string) error {
+			buildArgs = append(buildArgs, s)
+			return nil
+		},
+	)
+	target := fs.String("target", "", "Target stage of the build.")
+	files := fs.Bool("files", false, "Record the owning package of every scanned file in the output.")
+	output := fs.String("output", "", "Path to write the JSON output to instead of stdout.")
+	quiet := fs.Bool("quiet", false, "Only log errors.")
+	var platform storageclient.Platform
+	fs.Func(
+		"platform",
+		"Platform (os/arch[/variant]) of the image. Selects the image of manifest lists. "+
+			"Defaults to the platform capo runs on.",
+		func(value string) error {
+			var err error
+			platform, err = storageclient.ParsePlatform(value)
+			return err
+		},
+	)
+	// flag.ExitOnError: exits on invalid flags
+	_ = fs.Parse(cmdArgs)
+	if *image == "" || *cfPath == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return ErrPostScanArgs
+	}
+
+	cf, _ := parseContainerfile(args{
+		containerfilePath: *cfPath,
+		buildArgs:         buildArgs,
+		target:            *target,
+		platform:          platform,
+	})
+	level := slog.LevelDebug
+	if *quiet {
+		level = slog.LevelError
+	}
+	scanner, err := capo.NewScanner(
+		capo.WithLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))),
+		capo.WithPlatform(platform),
+		capo.WithFileOwnership(*files),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create scanner: %w", err)
+	}
+
+	pkgMetadata, err := scanner.PostScan(*image, cf)
+	if err != nil {
+		return fmt.Errorf("failed to post-scan image: %w", err)
+	}
+	return printJSON(*output, pkgMetadata)
+}
+
+// runFilterSBOM prints the packages of an existing SBOM of an image under
+// paths ("capo filter-sbom --sbom FILE --paths PATHS").
+func runFilterSBOM(cmdArgs []string) error {
+	fs := flag.NewFlagSet("filter-sbom", flag.ExitOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: %s filter-sbom [flags]\n\n", os.Args[0])
+		fmt.Fprintln(out, "Prints the packages of an SBOM of an image (SPDX, CycloneDX or syft JSON)")
+		fmt.Fprintln(out, "found in the paths, as if the paths were copied from the image, in the")
+		fmt.Fprintln(out, "same output as a scan.")
+		fmt.Fprintln(out)
+		fs.PrintDefaults()
+	}
+	sbomPath := fs.String("sbom", "", "Path to the SBOM of the image. Required.")
+	pathsFlag := fs.String(
+		"paths", "", "Comma-separated absolute paths of the image (e.g. \"/usr/bin/helm,/app/\"). Required.",
+	)
+	pullspec := fs.String("pullspec", "", "Pullspec of the image to record as the origin of the packages.")
+	output := fs.String("output", "", "Path to write the JSON output to instead of stdout.")
+	// flag.ExitOnError: exits on invalid flags
+	_ = fs.Parse(cmdArgs)
+	if *sbomPath == "" || *pathsFlag == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return ErrFilterSBOMArgs
+	}
+
+	f, err := os.Open(*sbomPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	pkgMetadata, err := capo.FilterSBOM(f, *pullspec, strings.Split(*pathsFlag, ","))
+	if err != nil {
+		return fmt.Errorf("failed to filter %s: %w", *sbomPath, err)
 	}
+	return printJSON(*output, pkgMetadata)
+}
+
+// readOutput reads the JSON output of a scan from the file at path.
+func readOutput(path string) (capo.PackageMetadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return capo.PackageMetadata{}, err
+	}
+	var m capo.PackageMetadata
+	if err := json.Unmarshal(data, &m); err != nil {
+		return capo.PackageMetadata{}, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Output of "capo diff".
+type diffOutput struct {
+	Origins []capo.OriginDiff `json:"origins"`
+}
+
+// Output of "capo files".
+type fileOutput struct {
+	Files    []capo.FileMetadataItem `json:"files"`
+	Warnings []capo.Warning          `json:"warnings,omitempty"`
+}
+
+// Output of "capo doctor".
+type doctorOutput struct {
+	Checks []capo.Check `json:"checks"`
+}
+
+// Output of "capo lint".
+type lintOutput struct {
+	Findings []capo.Finding `json:"findings"`
+}
+
+// Serve net/http/pprof profiles on addr in the background for the lifetime of
+// the process. Failing to serve is logged but doesn't stop the scan.
+func servePprof(addr string, logger *slog.Logger) {
+	logger.Info("serving pprof profiles", "addr", addr)
+	go func() {
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			logger.Error("failed to serve pprof profiles", "addr", addr, "error", err)
+		}
+	}()
+}
+
+// Output of "capo --format=ndjson": every event of the scan as a line of
+// JSON, printed as soon as it's reported.
+type ndjsonOutput struct {
+	encoder *json.Encoder
+	// first error encoding an event, the rest are dropped
+	err error
+}
+
+// Return the event handler of the scanner, nil for a nil output.
+func (o *ndjsonOutput) handler() func(capo.Event) {
+	if o == nil {
+		return nil
+	}
+	return func(e capo.Event) {
+		if o.err == nil {
+			o.err = o.encoder.Encode(e)
+		}
+	}
+}
+
+// buildEvent is printed before the events of the scan with --build-flags-file
+// or --preprocess, carrying the build and preprocessor of the output.
+type buildEvent struct {
+	Type         string                 `json:"type"`
+	Build        *buildflags.Invocation `json:"build,omitempty"`
+	Preprocessor *capo.Preprocessor     `json:"preprocessor,omitempty"`
+}
 
+// Scan the containerfile, printing its events to the file at path, or to
+// stdout if path is empty. The invocation and preprocessor, if any, are
+// printed first as a "build" event and set in the returned output.
+func (o *ndjsonOutput) run(
+	scanner *capo.Scanner,
+	cf containerfile.Containerfile,
+	path string,
+	invocation *buildflags.Invocation,
+	preprocessor *capo.Preprocessor,
+) (_ capo.PackageMetadata, err error) {
+	w, closeOutput, err := createOutput(path)
+	if err != nil {
+		return capo.PackageMetadata{}, err
+	}
+	defer func() {
+		if closeErr := closeOutput(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	o.encoder = json.NewEncoder(w)
+	if invocation != nil || preprocessor != nil {
+		event := buildEvent{Type: "build", Build: invocation, Preprocessor: preprocessor}
+		if err := o.encoder.Encode(event); err != nil {
+			return capo.PackageMetadata{}, fmt.Errorf("%w: %w", ErrJSONEncode, err)
+		}
+	}
 	pkgMetadata, err := scanner.Scan(cf)
 	if err != nil {
-		log.Fatalf("Failed to scan stages: %+v", err)
+		return capo.PackageMetadata{}, err
 	}
+	if o.err != nil {
+		return capo.PackageMetadata{}, fmt.Errorf("%w: %w", ErrJSONEncode, o.err)
+	}
+	pkgMetadata.Build = invocation
+	pkgMetadata.Preprocessor = preprocessor
+	return pkgMetadata, nil
+}
 
-	if err := printPkgMetadata(pkgMetadata); err != nil {
-		log.Fatalf("Failed to serialize and print package metadata")
+// Push the partial SBOMs of the scan output as referrers of --push-referrer,
+// if set.
+func pushReferrers(args args, pkgMetadata capo.PackageMetadata, logger *slog.Logger) {
+	image := args.pushReferrer
+	if image == "" {
+		return
+	}
+	sys := capo.SystemContext(args.authFile, args.certDir)
+	pushed, err := capo.PushReferrers(context.Background(), image, pkgMetadata, sys)
+	if err != nil {
+		log.Fatalf("Failed to push partial SBOMs: %+v", err)
+	}
+	for _, ref := range pushed {
+		logger.Info("pushed partial SBOM", "subject", image, "artifact", ref)
 	}
 }
 
-// Serialize and print package metadata to stdout.
-func printPkgMetadata(pkgMetadata capo.PackageMetadata) error {
+// Open the file at path for the output, or stdout if path is empty. The
+// returned function closes the file.
+func createOutput(path string) (*os.File, func() error, error) {
+	if path == "" {
+		return os.Stdout, func() error { return nil }, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f.Close, nil
+}
+
+// Write the output to --output-url if set, print it otherwise.
+func writeOutput(args args, output any) error {
+	if args.outputURL != "" {
+		return storeJSON(args.outputURL, output)
+	}
+	return printJSON(args.output, output)
+}
+
+// Serialize and print the output (package metadata, file owners or lint
+// findings) to the file at path, or to stdout if path is empty.
+func printJSON(path string, output any) (err error) {
+	w, closeOutput, err := createOutput(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := closeOutput(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	return encodeJSON(w, output)
+}
+
+// Serialize and store the output at the location of rawURL (see sink.Open).
+// For package metadata, the partial SBOM of every origin (see
+// capo.PartialSBOMs) is stored next to it, under KEY.partial/ORIGIN.json with
+// unsafe characters of the origin replaced.
+func storeJSON(rawURL string, output any) (err error) {
+	ctx := context.Background()
+	s, key, err := sink.Open(ctx, rawURL)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := s.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
 	var buf bytes.Buffer
+	if err := encodeJSON(&buf, output); err != nil {
+		return err
+	}
+	if err := s.Put(ctx, key, buf.Bytes()); err != nil {
+		return err
+	}
 
-	encoder := json.NewEncoder(&buf)
-	encoder.SetIndent("", "  ")
-	err := encoder.Encode(pkgMetadata)
+	metadata, ok := output.(capo.PackageMetadata)
+	if !ok {
+		return nil
+	}
+	for _, partial := range capo.PartialSBOMs(metadata) {
+		buf.Reset()
+		if err := encodeJSON(&buf, partial.Metadata); err != nil {
+			return err
+		}
+		if err := s.Put(ctx, partialKey(key, partial.Origin), buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return the key of the partial SBOM of origin stored next to key.
+func partialKey(key string, origin string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, origin)
+	return key + ".partial/" + name + ".json"
+}
+
+// Serialize the output to w. Package metadata is streamed, see
+// capo.PackageMetadata.EncodeJSON.
+func encodeJSON(w io.Writer, output any) error {
+	var err error
+	if metadata, ok := output.(capo.PackageMetadata); ok {
+		err = metadata.EncodeJSON(w)
+	} else {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(output)
+	}
 	if err != nil {
 		return fmt.Errorf("%w: %w", ErrJSONEncode, err)
 	}
-
-	fmt.Println(buf.String())
 	return nil
 }
+
+// Post-scan hooks enabled by the arguments.
+func postScanHooks(args args) []capo.PostScanHook {
+	var hooks []capo.PostScanHook
+	if args.vulnScan {
+		hooks = append(hooks, capo.GrypeHook{Offline: args.offline})
+	}
+	return hooks
+}
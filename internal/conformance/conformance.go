@@ -0,0 +1,232 @@
+// Package conformance runs the Containerfile parser over a corpus of
+// real-world Containerfiles and compares the parsed stages with golden files,
+// so parser gaps are found before they break production builds.
+//
+// Every case of the corpus is a directory with a Containerfile, optionally a
+// build-args file (one KEY=VALUE per line, as --build-arg-file) and the
+// golden file stages.golden.json with the expected stages.
+package conformance
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+
+	"github.com/konflux-ci/capo/pkg/buildvars"
+	"github.com/konflux-ci/capo/pkg/containerfile"
+)
+
+const (
+	containerfileName = "Containerfile"
+	buildArgsName     = "build-args"
+	goldenName        = "stages.golden.json"
+)
+
+// ErrPanic is returned for a case the parser panicked on.
+var ErrPanic = errors.New("parser panicked")
+
+// ErrGoldenMismatch is returned for a case whose parsed stages differ from its
+// golden file.
+var ErrGoldenMismatch = errors.New("parsed stages differ from golden file")
+
+// ErrGoldenMissing is returned for a case without a golden file.
+var ErrGoldenMissing = errors.New("golden file not found")
+
+// Result is the outcome of a single corpus case.
+type Result struct {
+	// Name of the case directory.
+	Name string
+	// Error of the case, nil if it passed.
+	Err error
+}
+
+// Run parses the Containerfile of every case in corpusDir, in the order of
+// the case names. With update, the golden files are (re)written from the
+// parsed stages instead of compared. Returns an error only if the corpus
+// itself can't be read, failures of cases are reported in their Result.
+func Run(corpusDir string, update bool) ([]Result, error) {
+	entries, err := os.ReadDir(corpusDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading corpus: %w", err)
+	}
+
+	res := make([]Result, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		res = append(res, Result{
+			Name: entry.Name(),
+			Err:  runCase(filepath.Join(corpusDir, entry.Name()), update),
+		})
+	}
+	return res, nil
+}
+
+// runCase parses the Containerfile of the case in dir and compares the stages
+// with (or with update writes them to) its golden file.
+func runCase(dir string, update bool) error {
+	var argFiles []string
+	if _, err := os.Stat(filepath.Join(dir, buildArgsName)); err == nil {
+		argFiles = append(argFiles, filepath.Join(dir, buildArgsName))
+	}
+	args, err := buildvars.ParseAndMerge(argFiles, nil)
+	if err != nil {
+		return err
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, containerfileName))
+	if err != nil {
+		return fmt.Errorf("reading containerfile: %w", err)
+	}
+
+	cf, err := parse(content, args)
+	if err != nil {
+		return err
+	}
+
+	actual, err := json.MarshalIndent(goldenStages(cf), "", "  ")
+	if err != nil {
+		return err
+	}
+	actual = append(actual, '\n')
+
+	goldenPath := filepath.Join(dir, goldenName)
+	if update {
+		return os.WriteFile(goldenPath, actual, 0o644)
+	}
+
+	expected, err := os.ReadFile(goldenPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrGoldenMissing, goldenPath)
+	}
+	if err != nil {
+		return fmt.Errorf("reading golden file: %w", err)
+	}
+	if !bytes.Equal(expected, actual) {
+		return fmt.Errorf("%w %s, got:\n%s", ErrGoldenMismatch, goldenPath, actual)
+	}
+	return nil
+}
+
+// parse parses the Containerfile content, turning a panic of the parser into
+// ErrPanic. The platform is fixed, so built-in platform args don't depend on
+// the machine running the corpus.
+func parse(content []byte, args map[string]string) (cf containerfile.Containerfile, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v\n%s", ErrPanic, r, debug.Stack())
+		}
+	}()
+
+	return containerfile.Parse(
+		bytes.NewReader(content),
+		containerfile.WithArgs(args),
+		containerfile.WithPlatform("linux", "amd64", ""),
+	)
+}
+
+// goldenStage is the form of a containerfile.Stage in golden files. Stage.Env
+// is left out, as it includes the built-in args of imagebuilder.
+type goldenStage struct {
+	Alias         string            `json:"alias"`
+	Index         int               `json:"index"`
+	Kind          string            `json:"kind"`
+	Base          string            `json:"base"`
+	BaseRef       string            `json:"base_ref"`
+	Copies        []goldenCopy      `json:"copies,omitempty"`
+	Mounts        []goldenMount     `json:"mounts,omitempty"`
+	Moves         []goldenMove      `json:"moves,omitempty"`
+	ArchiveAdds   []goldenCopy      `json:"archive_adds,omitempty"`
+	ContextCopies []goldenCopy      `json:"context_copies,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+}
+
+type goldenCopy struct {
+	Type        string   `json:"type"`
+	From        string   `json:"from,omitempty"`
+	Sources     []string `json:"sources"`
+	Destination string   `json:"destination"`
+	Workdir     string   `json:"workdir,omitempty"`
+}
+
+type goldenMount struct {
+	Type     string `json:"type"`
+	From     string `json:"from,omitempty"`
+	Pullspec string `json:"pullspec,omitempty"`
+	Source   string `json:"source,omitempty"`
+	Target   string `json:"target,omitempty"`
+	Workdir  string `json:"workdir,omitempty"`
+}
+
+type goldenMove struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Workdir     string `json:"workdir,omitempty"`
+}
+
+var copyTypeNames = map[containerfile.CopyType]string{
+	containerfile.CopyTypeBuilder:  "builder",
+	containerfile.CopyTypeExternal: "external",
+	containerfile.CopyTypeContext:  "context",
+}
+
+var mountTypeNames = map[containerfile.MountType]string{
+	containerfile.MountTypeBind:   "bind",
+	containerfile.MountTypeCache:  "cache",
+	containerfile.MountTypeTmpfs:  "tmpfs",
+	containerfile.MountTypeSecret: "secret",
+	containerfile.MountTypeSSH:    "ssh",
+}
+
+func goldenStages(cf containerfile.Containerfile) []goldenStage {
+	res := make([]goldenStage, 0, len(cf.Stages))
+	for _, stage := range cf.Stages {
+		gs := goldenStage{
+			Alias:         stage.Alias,
+			Index:         stage.Index,
+			Kind:          stage.Kind.String(),
+			Base:          stage.Base,
+			BaseRef:       stage.BaseRef,
+			Copies:        goldenCopies(stage.Copies),
+			ArchiveAdds:   goldenCopies(stage.ArchiveAdds),
+			ContextCopies: goldenCopies(stage.ContextCopies),
+		}
+		if len(stage.Labels) > 0 {
+			gs.Labels = stage.Labels
+		}
+		for _, mv := range stage.Moves {
+			gs.Moves = append(gs.Moves, goldenMove{Source: mv.Source, Destination: mv.Destination, Workdir: mv.Workdir})
+		}
+		for _, m := range stage.Mounts {
+			gs.Mounts = append(gs.Mounts, goldenMount{
+				Type:     mountTypeNames[m.MountType],
+				From:     m.FromRaw,
+				Pullspec: m.Pullspec,
+				Source:   m.Source,
+				Target:   m.Target,
+				Workdir:  m.Workdir,
+			})
+		}
+		res = append(res, gs)
+	}
+	return res
+}
+
+func goldenCopies(copies []containerfile.Copy) []goldenCopy {
+	var res []goldenCopy
+	for _, cp := range copies {
+		res = append(res, goldenCopy{
+			Type:        copyTypeNames[cp.Type],
+			From:        cp.From,
+			Sources:     cp.Sources,
+			Destination: cp.Destination,
+			Workdir:     cp.Workdir,
+		})
+	}
+	return res
+}
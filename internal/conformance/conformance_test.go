@@ -0,0 +1,76 @@
+//go:build unit
+
+package conformance
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCorpus(t *testing.T) {
+	t.Parallel()
+	results, err := Run(filepath.Join("..", "..", "testdata", "corpus"), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) == 0 {
+		t.Fatalf("expected corpus cases")
+	}
+
+	for _, result := range results {
+		t.Run(result.Name, func(t *testing.T) {
+			if result.Err != nil {
+				t.Errorf("%v\nrun \"go run ./cmd/capo conformance --update\" if the change is intended", result.Err)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	corpus := t.TempDir()
+	caseDir := filepath.Join(corpus, "case")
+	writeFile(t, filepath.Join(caseDir, containerfileName), "FROM quay.io/konflux-ci/builder:1 AS builder\n"+
+		"FROM scratch\n"+
+		"COPY --from=builder /usr/bin/app /usr/bin/app\n")
+
+	results, err := Run(corpus, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 1 || !errors.Is(results[0].Err, ErrGoldenMissing) {
+		t.Fatalf("expected a result with %v, got: %+v", ErrGoldenMissing, results)
+	}
+
+	if _, err := Run(corpus, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	results, err = Run(corpus, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if results[0].Err != nil {
+		t.Fatalf("expected the updated golden file to match, got: %v", results[0].Err)
+	}
+
+	writeFile(t, filepath.Join(caseDir, goldenName), "[]\n")
+	results, err = Run(corpus, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !errors.Is(results[0].Err, ErrGoldenMismatch) {
+		t.Fatalf("expected error wrapping %v, got: %v", ErrGoldenMismatch, results[0].Err)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
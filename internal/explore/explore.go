@@ -0,0 +1,425 @@
+// Package explore is an interactive explorer of the parsed stages and the
+// scan output of a build ("capo explore"), which answers questions like why a
+// path of the final image is attributed to an origin.
+package explore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	capo "github.com/konflux-ci/capo/pkg"
+	"github.com/konflux-ci/capo/pkg/containerfile"
+)
+
+// Prompt printed before every command.
+const Prompt = "capo> "
+
+// maxTraceDepth limits tracing of a path through stages copying from each
+// other.
+const maxTraceDepth = 32
+
+const help = `Commands:
+  stages              list the parsed stages
+  stage <alias|index> show the base, copies and masked mount targets of a stage
+  sources             list the sources copied to the final image and their attribution
+  packages [filter]   list packages, optionally only of origins containing filter
+  files [prefix]      list owners of copied files, optionally only under prefix
+  why <path>          trace a path of the final image to its origin
+  help                show this help
+  quit                exit
+`
+
+// Session explores a parsed containerfile and the output of its scan.
+type Session struct {
+	cf  containerfile.Containerfile
+	out capo.PackageMetadata
+}
+
+// New returns a session exploring the containerfile and its scan output. File
+// owners are only known if out was scanned with capo.WithFileOwnership.
+func New(cf containerfile.Containerfile, out capo.PackageMetadata) *Session {
+	return &Session{cf: cf, out: out}
+}
+
+// Run reads commands from r line by line and writes their results to w, until
+// "quit" or the end of r.
+func (s *Session) Run(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	for {
+		if _, err := fmt.Fprint(w, Prompt); err != nil {
+			return err
+		}
+		if !scanner.Scan() {
+			_, err := fmt.Fprintln(w)
+			return errors.Join(scanner.Err(), err)
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "quit" || fields[0] == "exit" {
+			return nil
+		}
+		if err := s.Exec(w, fields[0], fields[1:]...); err != nil {
+			return err
+		}
+	}
+}
+
+// Exec writes the result of a single command to w. Unknown commands and
+// invalid arguments are reported to w, only errors writing to w are
+// returned.
+func (s *Session) Exec(w io.Writer, command string, args ...string) error {
+	var b strings.Builder
+	switch command {
+	case "help":
+		b.WriteString(help)
+	case "stages":
+		s.stages(&b)
+	case "stage":
+		if len(args) != 1 {
+			b.WriteString("usage: stage <alias|index>\n")
+			break
+		}
+		s.stage(&b, args[0])
+	case "sources":
+		s.sources(&b)
+	case "packages":
+		s.packages(&b, strings.Join(args, " "))
+	case "files":
+		s.files(&b, strings.Join(args, " "))
+	case "why":
+		if len(args) != 1 {
+			b.WriteString("usage: why <path>\n")
+			break
+		}
+		s.why(&b, args[0])
+	default:
+		fmt.Fprintf(&b, "unknown command %q, see help\n", command)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (s *Session) stages(b *strings.Builder) {
+	for _, stage := range s.cf.Stages {
+		fmt.Fprintf(b, "%d  %-20s %-8s %s\n", stage.Index, stage.Alias, stage.Kind, stage.Base)
+	}
+}
+
+func (s *Session) stage(b *strings.Builder, ref string) {
+	stage := s.cf.StageByRef(ref)
+	if stage == nil {
+		fmt.Fprintf(b, "no stage %q\n", ref)
+		return
+	}
+	fmt.Fprintf(b, "stage %d %q (%s)\n", stage.Index, stage.Alias, stage.Kind)
+	fmt.Fprintf(b, "  base: %s\n", stage.Base)
+	if stage.BaseRef != stage.Base {
+		fmt.Fprintf(b, "  chained from: %s\n", stage.BaseRef)
+	}
+	if len(stage.PullspecArgs) > 0 {
+		fmt.Fprintf(b, "  base depends on build args: %s\n", strings.Join(stage.PullspecArgs, ", "))
+	}
+	for _, cp := range stage.Copies {
+		fmt.Fprintf(b, "  COPY --from=%s %s %s\n", cp.From, strings.Join(cp.Sources, " "), destination(cp))
+	}
+	for _, cp := range stage.ContextCopies {
+		fmt.Fprintf(b, "  COPY %s %s\n", strings.Join(cp.Sources, " "), destination(cp))
+	}
+	for _, cp := range stage.ArchiveAdds {
+		fmt.Fprintf(b, "  ADD %s %s\n", strings.Join(cp.Sources, " "), destination(cp))
+	}
+	for _, target := range maskedTargets(*stage) {
+		fmt.Fprintf(b, "  masked: %s (cache, secret or ssh mount target, excluded from intermediate content)\n", target)
+	}
+}
+
+func (s *Session) sources(b *strings.Builder) {
+	if s.out.Coverage == nil || len(s.out.Coverage.Sources) == 0 {
+		b.WriteString("no sources copied to the final image\n")
+		return
+	}
+	for _, src := range s.out.Coverage.Sources {
+		from := src.From
+		if from == "" {
+			from = "build context"
+		}
+		fmt.Fprintf(b, "%-12s %s:%s -> %s", src.Status, from, src.Source, src.Destination)
+		if src.Reason != "" {
+			fmt.Fprintf(b, " (%s)", src.Reason)
+		}
+		b.WriteString("\n")
+	}
+}
+
+func (s *Session) packages(b *strings.Builder, filter string) {
+	n := 0
+	for _, item := range s.out.Packages {
+		origin := originOf(item.OriginType, item.StageAlias, item.Pullspec)
+		if filter != "" && !strings.Contains(origin, filter) {
+			continue
+		}
+		fmt.Fprintf(b, "%-14s %-40s %s\n", item.OriginType, origin, item.PackageURL)
+		n++
+	}
+	fmt.Fprintf(b, "%d packages\n", n)
+}
+
+func (s *Session) files(b *strings.Builder, prefix string) {
+	if len(s.out.Files) == 0 {
+		b.WriteString("no file owners recorded\n")
+		return
+	}
+	n := 0
+	for _, f := range s.out.Files {
+		if prefix != "" && !isUnder(f.Path, prefix) {
+			continue
+		}
+		origin := originOf(f.OriginType, f.StageAlias, f.Pullspec)
+		fmt.Fprintf(b, "%-14s %-40s %s %s\n", f.OriginType, origin, f.Path, f.PackageURL)
+		n++
+	}
+	fmt.Fprintf(b, "%d files\n", n)
+}
+
+// why traces the path of the final image through the copies of the stages to
+// the stage or image it is from.
+func (s *Session) why(b *strings.Builder, p string) {
+	final := s.cf.FinalStage()
+	if final == nil {
+		b.WriteString("no stages\n")
+		return
+	}
+	p = path.Clean("/" + p)
+	fmt.Fprintf(b, "%s\n", p)
+	s.trace(b, final, []string{p}, 1)
+}
+
+// trace writes how the candidate paths of the stage got there, indented by
+// depth.
+func (s *Session) trace(b *strings.Builder, stage *containerfile.Stage, paths []string, depth int) {
+	indent := strings.Repeat("  ", depth)
+	if depth > maxTraceDepth {
+		fmt.Fprintf(b, "%s... stopped tracing\n", indent)
+		return
+	}
+
+	for _, p := range paths {
+		for _, target := range maskedTargets(*stage) {
+			if isUnder(p, target) {
+				fmt.Fprintf(b, "%s%s is under the mount target %s of stage %q, its content is excluded\n",
+					indent, p, target, stage.Alias)
+			}
+		}
+	}
+
+	cp, kind, sources := lastCopy(*stage, paths)
+	if cp == nil {
+		s.notCopied(b, stage, paths, indent)
+		return
+	}
+
+	switch {
+	case kind == copyKindArchive:
+		fmt.Fprintf(b, "%sstage %q: ADD %s %s\n", indent, stage.Alias, strings.Join(cp.Sources, " "), destination(*cp))
+		fmt.Fprintf(b, "%s  extracted from an archive of the build context (context-archive)\n", indent)
+	case kind == copyKindContext:
+		fmt.Fprintf(b, "%sstage %q: COPY %s %s\n", indent, stage.Alias, strings.Join(cp.Sources, " "), destination(*cp))
+		fmt.Fprintf(b, "%s  copied from the build context (context)\n", indent)
+	case cp.Type == containerfile.CopyTypeBuilder:
+		fmt.Fprintf(b, "%sstage %q: COPY --from=%s %s %s\n",
+			indent, stage.Alias, cp.From, strings.Join(cp.Sources, " "), destination(*cp))
+		from := s.cf.ResolveRef(cp.From, stage.Index)
+		if from == nil {
+			fmt.Fprintf(b, "%s  stage %q not found\n", indent, cp.From)
+			return
+		}
+		s.trace(b, from, sources, depth+1)
+	case cp.Type == containerfile.CopyTypeExternal:
+		fmt.Fprintf(b, "%sstage %q: COPY --from=%s %s %s\n",
+			indent, stage.Alias, cp.From, strings.Join(cp.Sources, " "), destination(*cp))
+		fmt.Fprintf(b, "%s  copied from the image %s (external)\n", indent, cp.From)
+		s.owners(b, sources, func(f capo.FileMetadataItem) bool {
+			return f.OriginType == "external" && sameRepository(f.Pullspec, cp.From)
+		}, indent+"  ")
+	default:
+		fmt.Fprintf(b, "%sstage %q: COPY --from=%s %s %s\n",
+			indent, stage.Alias, cp.From, strings.Join(cp.Sources, " "), destination(*cp))
+		fmt.Fprintf(b, "%s  copied from the named build context %s\n", indent, cp.From)
+	}
+}
+
+// notCopied writes the origin of paths of the stage not copied by any of its
+// instructions: its base image or its own instructions.
+func (s *Session) notCopied(b *strings.Builder, stage *containerfile.Stage, paths []string, indent string) {
+	if stage.Kind == containerfile.StageKindFinal {
+		fmt.Fprintf(b, "%snot copied to the final stage, so from its base image %s (not attributed)\n", indent, stage.Base)
+		return
+	}
+	fmt.Fprintf(b, "%sstage %q: %s not copied from another stage\n", indent, stage.Alias, strings.Join(paths, " or "))
+	fmt.Fprintf(b, "%s  from its base image %s (builder) or created by its instructions (intermediate)\n",
+		indent, stage.Base)
+	found := s.owners(b, paths, func(f capo.FileMetadataItem) bool {
+		return f.StageAlias == stage.Alias
+	}, indent+"  ")
+	if !found && len(s.out.Files) == 0 {
+		fmt.Fprintf(b, "%s  file owners weren't recorded\n", indent)
+	}
+}
+
+// owners writes the recorded owners of the paths matching the filter and
+// returns whether there were any.
+func (s *Session) owners(
+	b *strings.Builder,
+	paths []string,
+	filter func(capo.FileMetadataItem) bool,
+	indent string,
+) bool {
+	found := false
+	for _, f := range s.out.Files {
+		if !filter(f) || !slices.ContainsFunc(paths, func(p string) bool { return isUnder(f.Path, p) }) {
+			continue
+		}
+		fmt.Fprintf(b, "%s%s: %s, owned by %s (%s)\n", indent, f.Path, f.OriginType, f.PackageURL, f.Pullspec)
+		found = true
+	}
+	return found
+}
+
+// Kinds of copies of a stage.
+const (
+	// COPY --from of another stage, an image or a named context
+	copyKindFrom = iota
+	// COPY from the build context
+	copyKindContext
+	// ADD of an archive of the build context
+	copyKindArchive
+)
+
+// lastCopy returns the last copy of the stage whose destination covers any of
+// the paths, which is the one the content is from, its kind and the paths of
+// the content in its source.
+func lastCopy(stage containerfile.Stage, paths []string) (*containerfile.Copy, int, []string) {
+	// copies of different kinds aren't ordered among each other, prefer
+	// copies from other stages and images
+	for kind, copies := range [][]containerfile.Copy{stage.Copies, stage.ContextCopies, stage.ArchiveAdds} {
+		for i := len(copies) - 1; i >= 0; i-- {
+			var sources []string
+			for _, p := range paths {
+				sources = append(sources, sourcePaths(copies[i], p)...)
+			}
+			if len(sources) > 0 {
+				slices.Sort(sources)
+				return &copies[i], kind, slices.Compact(sources)
+			}
+		}
+	}
+	return nil, 0, nil
+}
+
+// sourcePaths returns the candidate paths in the source of the copy of the
+// path at its destination, or nil if the copy doesn't cover the path.
+func sourcePaths(cp containerfile.Copy, p string) []string {
+	dest := destination(cp)
+	intoDir := strings.HasSuffix(cp.Destination, "/") || len(cp.Sources) > 1
+	var res []string
+	for _, src := range cp.Sources {
+		src = path.Clean("/" + src)
+		if !isUnder(p, dest) {
+			continue
+		}
+		rest := strings.TrimPrefix(strings.TrimPrefix(p, dest), "/")
+
+		// matches of a pattern are copied into the destination
+		if hasMeta(src) {
+			first, _, _ := strings.Cut(rest, "/")
+			if ok, _ := path.Match(src, path.Join(path.Dir(src), first)); ok && rest != "" {
+				res = append(res, path.Join(path.Dir(src), rest))
+			}
+			continue
+		}
+
+		// a directory source copies its content to the destination, a file
+		// source is copied to it
+		res = append(res, path.Join(src, rest))
+		// with a directory destination, a file source is copied into it
+		if first, sub, _ := strings.Cut(rest, "/"); intoDir && first == path.Base(src) {
+			res = append(res, path.Join(src, sub))
+		}
+	}
+	return res
+}
+
+// destination returns the absolute destination of the copy, resolved against
+// its working directory.
+func destination(cp containerfile.Copy) string {
+	if path.IsAbs(cp.Destination) {
+		return path.Clean(cp.Destination)
+	}
+	return path.Join("/", cp.Workdir, cp.Destination)
+}
+
+// maskedTargets returns the absolute targets of the cache, secret and ssh
+// mounts of the stage, whose content capo excludes.
+func maskedTargets(stage containerfile.Stage) []string {
+	var res []string
+	for _, m := range stage.Mounts {
+		if m.MountType != containerfile.MountTypeCache && m.MountType != containerfile.MountTypeSecret &&
+			m.MountType != containerfile.MountTypeSSH {
+			continue
+		}
+		if m.Target == "" {
+			continue
+		}
+		target := m.Target
+		if !path.IsAbs(target) {
+			target = path.Join("/", m.Workdir, target)
+		}
+		res = append(res, path.Clean(target))
+	}
+	return res
+}
+
+// isUnder returns whether p is dir or a path under it.
+func isUnder(p, dir string) bool {
+	dir = path.Clean(dir)
+	return p == dir || dir == "/" || strings.HasPrefix(p, dir+"/")
+}
+
+// hasMeta returns whether the path has glob characters.
+func hasMeta(p string) bool {
+	return strings.ContainsAny(p, `*?[\`)
+}
+
+// sameRepository returns whether the pullspecs are of the same repository.
+func sameRepository(a, b string) bool {
+	return repository(a) == repository(b)
+}
+
+// repository returns the pullspec without its tag and digest.
+func repository(pullspec string) string {
+	name, _, _ := strings.Cut(pullspec, "@")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	return name
+}
+
+// originOf returns the stage alias of stage origins, otherwise the pullspec
+// or the origin type.
+func originOf(originType, stageAlias, pullspec string) string {
+	if stageAlias != "" {
+		return strconv.Quote(stageAlias)
+	}
+	if pullspec != "" {
+		return pullspec
+	}
+	return originType
+}
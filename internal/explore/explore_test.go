@@ -0,0 +1,196 @@
+//go:build unit
+
+package explore
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	capo "github.com/konflux-ci/capo/pkg"
+	"github.com/konflux-ci/capo/pkg/containerfile"
+)
+
+var testContainerfile = containerfile.Containerfile{Stages: []containerfile.Stage{
+	{
+		Alias:   "builder1",
+		Base:    "docker.io/library/golang:1.26",
+		BaseRef: "docker.io/library/golang:1.26",
+		Mounts: []containerfile.Mount{
+			{MountType: containerfile.MountTypeCache, Target: "/root/.cache"},
+		},
+	},
+	{
+		Alias:   "builder2",
+		Base:    "docker.io/library/golang:1.26",
+		BaseRef: "docker.io/library/golang:1.26",
+		Index:   1,
+		Copies: []containerfile.Copy{{
+			Sources: []string{"/src/bin/"}, Destination: "/out/", From: "builder1", Type: containerfile.CopyTypeBuilder,
+		}},
+	},
+	{
+		Alias:   "final",
+		Base:    "registry.access.redhat.com/ubi9/ubi-micro:latest",
+		BaseRef: "registry.access.redhat.com/ubi9/ubi-micro:latest",
+		Index:   2,
+		Kind:    containerfile.StageKindFinal,
+		Copies: []containerfile.Copy{
+			{
+				Sources: []string{"/out/app"}, Destination: "bin/", Workdir: "/usr/local",
+				From: "builder2", Type: containerfile.CopyTypeBuilder,
+			},
+			{
+				Sources: []string{"/usr/bin/tool"}, Destination: "/usr/bin/tool",
+				From: "quay.io/konflux-ci/tools:v1", Type: containerfile.CopyTypeExternal,
+			},
+		},
+		ContextCopies: []containerfile.Copy{{
+			Sources: []string{"config.yaml"}, Destination: "/etc/app/config.yaml", Type: containerfile.CopyTypeContext,
+		}},
+	},
+}}
+
+var testOutput = capo.PackageMetadata{
+	Files: []capo.FileMetadataItem{
+		{
+			Path: "/src/bin/app", PackageURL: "pkg:golang/example.com/app@v1.0.0", OriginType: "intermediate",
+			Pullspec: "docker.io/library/golang@sha256:abc123", StageAlias: "builder1",
+		},
+		{
+			Path: "/usr/bin/tool", PackageURL: "unowned", OriginType: "external",
+			Pullspec: "quay.io/konflux-ci/tools@sha256:def456",
+		},
+	},
+}
+
+func TestWhy(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		path     string
+		expected string
+	}{
+		"traced through stages": {
+			path: "/usr/local/bin/app",
+			expected: `/usr/local/bin/app
+  stage "final": COPY --from=builder2 /out/app /usr/local/bin
+    stage "builder2": COPY --from=builder1 /src/bin/ /out
+      stage "builder1": /src/bin/app or /src/bin/app/app not copied from another stage
+        from its base image docker.io/library/golang:1.26 (builder) or created by its instructions (intermediate)
+        /src/bin/app: intermediate, owned by pkg:golang/example.com/app@v1.0.0 (docker.io/library/golang@sha256:abc123)
+`,
+		},
+		"external image": {
+			path: "/usr/bin/tool",
+			expected: `/usr/bin/tool
+  stage "final": COPY --from=quay.io/konflux-ci/tools:v1 /usr/bin/tool /usr/bin/tool
+    copied from the image quay.io/konflux-ci/tools:v1 (external)
+    /usr/bin/tool: external, owned by unowned (quay.io/konflux-ci/tools@sha256:def456)
+`,
+		},
+		"build context": {
+			path: "/etc/app/config.yaml",
+			expected: `/etc/app/config.yaml
+  stage "final": COPY config.yaml /etc/app/config.yaml
+    copied from the build context (context)
+`,
+		},
+		"base image of the final stage": {
+			path: "/usr/lib64/libc.so.6",
+			expected: `/usr/lib64/libc.so.6
+  not copied to the final stage, so from its base image registry.access.redhat.com/ubi9/ubi-micro:latest (not attributed)
+`,
+		},
+	}
+
+	s := New(testContainerfile, testOutput)
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var b strings.Builder
+			if err := s.Exec(&b, "why", test.path); err != nil {
+				t.Fatalf("Exec returned error: %v", err)
+			}
+			if diff := cmp.Diff(test.expected, b.String()); diff != "" {
+				t.Errorf("why mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSourcePaths(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		cp       containerfile.Copy
+		path     string
+		expected []string
+	}{
+		"file to file": {
+			cp:       containerfile.Copy{Sources: []string{"/src/app"}, Destination: "/usr/bin/app"},
+			path:     "/usr/bin/app",
+			expected: []string{"/src/app"},
+		},
+		"directory content": {
+			cp:       containerfile.Copy{Sources: []string{"/src/bin"}, Destination: "/opt/"},
+			path:     "/opt/app",
+			expected: []string{"/src/bin/app"},
+		},
+		"file into directory": {
+			cp:       containerfile.Copy{Sources: []string{"/src/app"}, Destination: "/opt/"},
+			path:     "/opt/app",
+			expected: []string{"/src/app/app", "/src/app"},
+		},
+		"pattern": {
+			cp:       containerfile.Copy{Sources: []string{"/src/*.so"}, Destination: "/usr/lib/"},
+			path:     "/usr/lib/libfoo.so",
+			expected: []string{"/src/libfoo.so"},
+		},
+		"pattern not matching": {
+			cp:   containerfile.Copy{Sources: []string{"/src/*.so"}, Destination: "/usr/lib/"},
+			path: "/usr/lib/libfoo.a",
+		},
+		"relative destination": {
+			cp:       containerfile.Copy{Sources: []string{"/src/app"}, Destination: "bin/app", Workdir: "/usr/local"},
+			path:     "/usr/local/bin/app",
+			expected: []string{"/src/app"},
+		},
+		"not covered": {
+			cp:   containerfile.Copy{Sources: []string{"/src/app"}, Destination: "/usr/bin/app"},
+			path: "/usr/bin/other",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if diff := cmp.Diff(test.expected, sourcePaths(test.cp, test.path)); diff != "" {
+				t.Errorf("sourcePaths() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	var b strings.Builder
+	input := "stages\n\nstage builder1\nbogus\nquit\nstages\n"
+	if err := New(testContainerfile, testOutput).Run(strings.NewReader(input), &b); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+
+	expected := Prompt +
+		"0  builder1             builder  docker.io/library/golang:1.26\n" +
+		"1  builder2             builder  docker.io/library/golang:1.26\n" +
+		"2  final                final    registry.access.redhat.com/ubi9/ubi-micro:latest\n" +
+		Prompt + Prompt +
+		"stage 0 \"builder1\" (builder)\n" +
+		"  base: docker.io/library/golang:1.26\n" +
+		"  masked: /root/.cache (cache, secret or ssh mount target, excluded from intermediate content)\n" +
+		Prompt +
+		"unknown command \"bogus\", see help\n" +
+		Prompt
+	if diff := cmp.Diff(expected, b.String()); diff != "" {
+		t.Errorf("Run() output mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,118 @@
+// Cataloger configuration read from a file, see ReadConfigFile.
+
+package sbom
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/anchore/syft/syft/cataloging/pkgcataloging"
+	"github.com/anchore/syft/syft/pkg/cataloger/binary"
+	"github.com/anchore/syft/syft/pkg/cataloger/dotnet"
+	"github.com/anchore/syft/syft/pkg/cataloger/golang"
+	"github.com/anchore/syft/syft/pkg/cataloger/java"
+	"github.com/anchore/syft/syft/pkg/cataloger/javascript"
+	"github.com/anchore/syft/syft/pkg/cataloger/kernel"
+	"github.com/anchore/syft/syft/pkg/cataloger/nix"
+	"github.com/anchore/syft/syft/pkg/cataloger/python"
+	"go.yaml.in/yaml/v3"
+)
+
+var ErrConfig = errors.New("invalid syft configuration")
+
+// configFile is the format of a cataloger configuration file. Except for
+// binary, each section has the same keys as the section of the syft
+// configuration (note java-archive instead of java), missing keys keep their
+// defaults.
+type configFile struct {
+	Binary      binaryConfig                      `yaml:"binary"`
+	Dotnet      dotnet.CatalogerConfig            `yaml:"dotnet"`
+	Golang      golang.CatalogerConfig            `yaml:"golang"`
+	JavaArchive java.ArchiveCatalogerConfig       `yaml:"java-archive"`
+	JavaScript  javascript.CatalogerConfig        `yaml:"javascript"`
+	LinuxKernel kernel.LinuxKernelCatalogerConfig `yaml:"linux-kernel"`
+	Nix         nix.Config                        `yaml:"nix"`
+	Python      python.CatalogerConfig            `yaml:"python"`
+}
+
+// binaryConfig selects the classifiers of the binary classifier cataloger.
+// The classifiers are built into syft, so no classifier catalog is ever
+// downloaded.
+type binaryConfig struct {
+	// Classes of the classifiers to use (e.g. "go-binary"), all by default.
+	Classifiers []string `yaml:"classifiers"`
+}
+
+// ReadConfigFile reads the cataloger configuration in the YAML file at path
+// on top of syft's defaults. Unknown keys and classifier classes are an error,
+// so a typo doesn't silently leave the default in place.
+func ReadConfigFile(path string) (pkgcataloging.Config, error) {
+	defaults := pkgcataloging.DefaultConfig()
+	file := configFile{
+		Dotnet:      defaults.Dotnet,
+		Golang:      defaults.Golang,
+		JavaArchive: defaults.JavaArchive,
+		JavaScript:  defaults.JavaScript,
+		LinuxKernel: defaults.LinuxKernel,
+		Nix:         defaults.Nix,
+		Python:      defaults.Python,
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return pkgcataloging.Config{}, err
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	// an empty file keeps all defaults
+	if err := dec.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return pkgcataloging.Config{}, fmt.Errorf("%w: %s: %w", ErrConfig, path, err)
+	}
+
+	classifiers, err := selectClassifiers(defaults.Binary, file.Binary.Classifiers)
+	if err != nil {
+		return pkgcataloging.Config{}, fmt.Errorf("%w: %s: %w", ErrConfig, path, err)
+	}
+
+	return pkgcataloging.Config{
+		Binary:      classifiers,
+		Dotnet:      file.Dotnet,
+		Golang:      file.Golang,
+		JavaArchive: file.JavaArchive,
+		JavaScript:  file.JavaScript,
+		LinuxKernel: file.LinuxKernel,
+		Nix:         file.Nix,
+		Python:      file.Python,
+	}, nil
+}
+
+// selectClassifiers returns the configuration with only the classifiers of
+// the passed classes, or all classifiers if no classes are passed.
+func selectClassifiers(
+	cfg binary.ClassifierCatalogerConfig,
+	classes []string,
+) (binary.ClassifierCatalogerConfig, error) {
+	if len(classes) == 0 {
+		return cfg, nil
+	}
+
+	selected := cfg.Classifiers[:0:0]
+	found := make(map[string]bool)
+	for i := range cfg.Classifiers {
+		if slices.Contains(classes, cfg.Classifiers[i].Class) {
+			selected = append(selected, cfg.Classifiers[i])
+			found[cfg.Classifiers[i].Class] = true
+		}
+	}
+	for _, class := range classes {
+		if !found[class] {
+			return binary.ClassifierCatalogerConfig{}, fmt.Errorf("unknown binary classifier %q", class)
+		}
+	}
+	return binary.ClassifierCatalogerConfig{Classifiers: selected}, nil
+}
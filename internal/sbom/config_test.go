@@ -0,0 +1,98 @@
+//go:build unit
+
+package sbom
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anchore/syft/syft/cataloging/pkgcataloging"
+)
+
+func TestReadConfigFile(t *testing.T) {
+	t.Parallel()
+	defaults := pkgcataloging.DefaultConfig()
+	tests := map[string]struct {
+		content     string
+		check       func(t *testing.T, cfg pkgcataloging.Config)
+		expectedErr error
+	}{
+		"empty file keeps defaults": {
+			content: "",
+			check: func(t *testing.T, cfg pkgcataloging.Config) {
+				if len(cfg.Binary.Classifiers) != len(defaults.Binary.Classifiers) {
+					t.Errorf("expected %d classifiers, got %d", len(defaults.Binary.Classifiers), len(cfg.Binary.Classifiers))
+				}
+				if cfg.JavaArchive.MavenBaseURL != defaults.JavaArchive.MavenBaseURL {
+					t.Errorf("expected default maven base URL, got %q", cfg.JavaArchive.MavenBaseURL)
+				}
+			},
+		},
+		"cataloger options": {
+			content: "golang:\n  search-remote-licenses: true\njava-archive:\n  use-network: true\n",
+			check: func(t *testing.T, cfg pkgcataloging.Config) {
+				if !cfg.Golang.SearchRemoteLicenses || !cfg.JavaArchive.UseNetwork {
+					t.Errorf("expected options from file, got golang %+v, java %+v", cfg.Golang, cfg.JavaArchive)
+				}
+				if cfg.Python.PypiBaseURL != defaults.Python.PypiBaseURL {
+					t.Errorf("expected default pypi base URL, got %q", cfg.Python.PypiBaseURL)
+				}
+			},
+		},
+		"binary classifiers": {
+			content: "binary:\n  classifiers: [go-binary, helm]\n",
+			check: func(t *testing.T, cfg pkgcataloging.Config) {
+				classes := make(map[string]bool)
+				for _, c := range cfg.Binary.Classifiers {
+					classes[c.Class] = true
+				}
+				if len(classes) != 2 || !classes["go-binary"] || !classes["helm"] {
+					t.Errorf("expected go-binary and helm classifiers, got %v", classes)
+				}
+			},
+		},
+		"unknown classifier": {
+			content:     "binary:\n  classifiers: [no-such-binary]\n",
+			expectedErr: ErrConfig,
+		},
+		"unknown key": {
+			content:     "golang:\n  search-remote-license: true\n",
+			expectedErr: ErrConfig,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			path := filepath.Join(t.TempDir(), "syft.yaml")
+			if err := os.WriteFile(path, []byte(test.content), 0o600); err != nil {
+				t.Fatalf("failed to write config: %v", err)
+			}
+
+			cfg, err := ReadConfigFile(path)
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error wrapping %v, got: %v", test.expectedErr, err)
+			}
+			if test.check != nil {
+				test.check(t, cfg)
+			}
+		})
+	}
+}
+
+func TestOfflinePackagesConfig(t *testing.T) {
+	t.Parallel()
+	cfg := pkgcataloging.DefaultConfig()
+	cfg.Golang = cfg.Golang.WithSearchRemoteLicenses(true)
+	cfg.JavaScript = cfg.JavaScript.WithSearchRemoteLicenses(true)
+	cfg.JavaArchive = cfg.JavaArchive.WithUseNetwork(true)
+	cfg.Python = cfg.Python.WithSearchRemoteLicenses(true)
+
+	cfg = offlinePackagesConfig(cfg)
+	if cfg.Golang.SearchRemoteLicenses || cfg.JavaScript.SearchRemoteLicenses ||
+		cfg.JavaArchive.UseNetwork || cfg.Python.SearchRemoteLicenses {
+		t.Errorf("expected all network access turned off, got %+v", cfg)
+	}
+}
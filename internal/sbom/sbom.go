@@ -4,30 +4,52 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
 
 	"github.com/anchore/syft/syft"
 	"github.com/anchore/syft/syft/artifact"
 	"github.com/anchore/syft/syft/cataloging"
+	"github.com/anchore/syft/syft/cataloging/pkgcataloging"
+	"github.com/anchore/syft/syft/format"
 	"github.com/anchore/syft/syft/pkg"
 	"github.com/anchore/syft/syft/sbom"
+	"github.com/anchore/syft/syft/source"
 	"github.com/anchore/syft/syft/source/sourceproviders"
 	_ "modernc.org/sqlite" // required for Syft's RPM cataloguer
 )
 
-var sourceConfig = syft.DefaultGetSourceConfig().WithSources(sourceproviders.DirTag)
-
 type SyftPackage struct {
 	PURL             string
 	DependencyOfPURL string
 	Checksums        []string
+	// Licenses of the package, as SPDX expressions where syft could tell them
+	// and as declared otherwise.
+	Licenses []string
+	// Paths of the files the package was found in, relative to the scanned
+	// root directory with a leading slash.
+	Locations []string
+	// Paths of the files owned by the package according to the rpm, apk or
+	// dpkg database it was found in. Empty for other packages.
+	Files []string
 }
 
 var ErrSyft = errors.New("syft error while scanning content")
+var ErrDecode = errors.New("failed to decode SBOM")
+var ErrExcludePath = errors.New("invalid exclusion pattern, expected a pattern starting with ./, */ or **/")
 
 type SyftScanner struct {
 	config *syft.CreateSBOMConfig
-	selectCatalogers []string
+	// configuration of the directory sources of scans, see WithExcludePaths
+	sourceConfig         *syft.GetSourceConfig
+	excludePaths         []string
+	selectCatalogers     []string
 	defaultCatalogersTag string
+	offline              bool
+	packagesConfig       *pkgcataloging.Config
+	parallelism          int
 }
 
 type Option func(*SyftScanner)
@@ -47,6 +69,51 @@ func WithDefaultCatalogersTag(tag string) Option {
 	}
 }
 
+// WithOffline disables all remote lookups of catalogers, e.g. of Go module or
+// npm package licenses and of Maven artifacts.
+func WithOffline(offline bool) Option {
+	return func(s *SyftScanner) {
+		s.offline = offline
+	}
+}
+
+// WithPackagesConfig sets the configuration of catalogers, e.g. read by
+// ReadConfigFile. Offline (see WithOffline) takes precedence over its options
+// which access the network.
+func WithPackagesConfig(cfg pkgcataloging.Config) Option {
+	return func(s *SyftScanner) {
+		s.packagesConfig = &cfg
+	}
+}
+
+// WithParallelism sets the number of catalogers syft runs at the same time.
+// Syft's default if not positive.
+func WithParallelism(n int) Option {
+	return func(s *SyftScanner) {
+		s.parallelism = n
+	}
+}
+
+// WithExcludePaths sets glob patterns of paths syft doesn't walk, relative to
+// the scanned root, e.g. "./usr/share/doc/**" or "**/*.log". Syft only accepts
+// patterns starting with "./", "*/" or "**/" (see ValidateExcludePaths).
+func WithExcludePaths(patterns ...string) Option {
+	return func(s *SyftScanner) {
+		s.excludePaths = patterns
+	}
+}
+
+// ValidateExcludePaths returns an error wrapping ErrExcludePath for the first
+// pattern syft doesn't accept as an exclusion of a directory source.
+func ValidateExcludePaths(patterns []string) error {
+	for _, p := range patterns {
+		if !strings.HasPrefix(p, "./") && !strings.HasPrefix(p, "*/") && !strings.HasPrefix(p, "**/") {
+			return fmt.Errorf("%w: %q", ErrExcludePath, p)
+		}
+	}
+	return nil
+}
+
 // Create a new SyftScanner with the provided options.
 func NewSyftScanner(opts ...Option) SyftScanner {
 	s := SyftScanner{
@@ -64,15 +131,78 @@ func NewSyftScanner(opts ...Option) SyftScanner {
 				WithExpression(s.selectCatalogers...),
 		)
 
+	if s.packagesConfig != nil {
+		cfg = cfg.WithPackagesConfig(*s.packagesConfig)
+	}
+	if s.offline {
+		cfg = cfg.WithPackagesConfig(offlinePackagesConfig(cfg.Packages))
+	}
+	if s.parallelism > 0 {
+		cfg = cfg.WithParallelism(s.parallelism)
+	}
+
 	s.config = cfg
+	s.sourceConfig = syft.DefaultGetSourceConfig().
+		WithSources(sourceproviders.DirTag).
+		WithExcludeConfig(source.ExcludeConfig{Paths: s.excludePaths})
 	return s
 }
 
+// offlinePackagesConfig returns the cataloger configuration with all options
+// which make catalogers access the network turned off. These are off by
+// default, but may be enabled through syft's environment configuration.
+func offlinePackagesConfig(cfg pkgcataloging.Config) pkgcataloging.Config {
+	cfg.Golang = cfg.Golang.WithSearchRemoteLicenses(false)
+	cfg.JavaScript = cfg.JavaScript.WithSearchRemoteLicenses(false)
+	cfg.JavaArchive = cfg.JavaArchive.WithUseNetwork(false)
+	cfg.Python = cfg.Python.WithSearchRemoteLicenses(false)
+	return cfg
+}
+
 // Performs a syft scan on the root directory and returns a slice of SyftPackage structs.
 func (s *SyftScanner) Scan(root string) ([]SyftPackage, error) {
-	ctx := context.Background()
+	return s.ScanContext(context.Background(), root)
+}
+
+// ScanContext performs a syft scan like Scan, but returns an error wrapping
+// ErrSyft and ctx.Err() as soon as ctx is done. Syft is passed ctx, but not
+// all catalogers check it, so a scan still running is abandoned: it finishes
+// in the background and its result is discarded. The caller may remove root
+// right away, which only makes such a cataloger fail sooner.
+func (s *SyftScanner) ScanContext(ctx context.Context, root string) ([]SyftPackage, error) {
+	return scanWithin(ctx, root, s.scan)
+}
+
+// scanWithin runs scan in a goroutine and returns its result, or an error
+// wrapping ctx.Err() once ctx is done, without waiting for scan to return.
+func scanWithin(
+	ctx context.Context, root string, scan func(context.Context, string) ([]SyftPackage, error),
+) ([]SyftPackage, error) {
+	if err := ctx.Err(); err != nil {
+		return []SyftPackage{}, fmt.Errorf("%w: %w", ErrSyft, err)
+	}
+
+	type result struct {
+		packages []SyftPackage
+		err      error
+	}
+	// buffered, so an abandoned scan doesn't block on sending its result
+	done := make(chan result, 1)
+	go func() {
+		packages, err := scan(ctx, root)
+		done <- result{packages, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.packages, r.err
+	case <-ctx.Done():
+		return []SyftPackage{}, fmt.Errorf("%w: %w", ErrSyft, ctx.Err())
+	}
+}
 
-	src, err := syft.GetSource(ctx, root, sourceConfig)
+func (s *SyftScanner) scan(ctx context.Context, root string) ([]SyftPackage, error) {
+	src, err := syft.GetSource(ctx, root, s.sourceConfig)
 	if err != nil {
 		return []SyftPackage{}, fmt.Errorf("%w: %w", ErrSyft, err)
 	}
@@ -117,7 +247,10 @@ func getTopLevelPackages(sbom *sbom.SBOM) []SyftPackage {
 		packages = append(packages, SyftPackage{
 			PURL:             pkg.PURL,
 			Checksums:        checksums,
+			Licenses:         getPackageLicenses(&pkg),
 			DependencyOfPURL: dependencyOfPurl,
+			Locations:        getPackageLocations(&pkg),
+			Files:            getPackageFiles(&pkg),
 		})
 	}
 
@@ -134,7 +267,95 @@ func getIdToPackageMap(sbom *sbom.SBOM) map[artifact.ID]pkg.Package {
 	return res
 }
 
+func getPackageLocations(p *pkg.Package) []string {
+	locations := make([]string, 0)
+	for _, loc := range p.Locations.ToSlice() {
+		locations = append(locations, loc.RealPath)
+	}
+	return locations
+}
+
+// getPackageLicenses returns the licenses of the package, their SPDX
+// expressions if known and their declared values otherwise.
+func getPackageLicenses(p *pkg.Package) []string {
+	licenses := make([]string, 0)
+	for _, l := range p.Licenses.ToSlice() {
+		if l.SPDXExpression != "" {
+			licenses = append(licenses, l.SPDXExpression)
+		} else if l.Value != "" {
+			licenses = append(licenses, l.Value)
+		}
+	}
+	return licenses
+}
+
+// getPackageFiles returns the paths of files owned by packages found in
+// installed package databases, with a leading slash.
+func getPackageFiles(p *pkg.Package) []string {
+	files := make([]string, 0)
+	switch m := p.Metadata.(type) {
+	case pkg.RpmDBEntry:
+		for _, f := range m.Files {
+			files = append(files, path.Join("/", f.Path))
+		}
+	case pkg.ApkDBEntry:
+		for _, f := range m.Files {
+			files = append(files, path.Join("/", f.Path))
+		}
+	case pkg.DpkgDBEntry:
+		for _, f := range m.Files {
+			files = append(files, path.Join("/", f.Path))
+		}
+	}
+	return files
+}
+
 func getPackageChecksums(sbom *sbom.SBOM, p *pkg.Package) []string {
 	// TODO: implement if we need higher resolution for package matching
 	return []string{}
 }
+
+// ReadFile decodes the SBOM document in the file at path, in any format syft
+// can decode (e.g. syft JSON, CycloneDX or SPDX), and returns all its packages
+// with a PURL, sorted. Locations are as recorded in the document, relative to
+// whatever it was generated from.
+func ReadFile(path string) ([]SyftPackage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	packages, err := Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return packages, nil
+}
+
+// Decode decodes the SBOM document read from r, the same as ReadFile.
+func Decode(r io.Reader) ([]SyftPackage, error) {
+	doc, _, _, err := format.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+	if doc == nil || doc.Artifacts.Packages == nil {
+		return nil, fmt.Errorf("%w: unknown format", ErrDecode)
+	}
+
+	packages := make([]SyftPackage, 0)
+	for _, p := range doc.Artifacts.Packages.Sorted() {
+		if p.PURL == "" {
+			continue
+		}
+		packages = append(packages, SyftPackage{
+			PURL:      p.PURL,
+			Checksums: getPackageChecksums(doc, &p),
+			Licenses:  getPackageLicenses(&p),
+			Locations: getPackageLocations(&p),
+			Files:     getPackageFiles(&p),
+		})
+	}
+
+	return packages, nil
+}
@@ -0,0 +1,115 @@
+//go:build unit
+
+package sbom
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithParallelism(t *testing.T) {
+	t.Parallel()
+	defaults := NewSyftScanner()
+	tests := map[string]struct {
+		parallelism int
+		expected    int
+	}{
+		"default":  {parallelism: 0, expected: defaults.config.Parallelism},
+		"explicit": {parallelism: 3, expected: 3},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			s := NewSyftScanner(WithParallelism(tt.parallelism))
+			if s.config.Parallelism != tt.expected {
+				t.Errorf("expected parallelism %d, got %d", tt.expected, s.config.Parallelism)
+			}
+		})
+	}
+}
+
+func TestScanContextDone(t *testing.T) {
+	t.Parallel()
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithTimeout(context.Background(), -time.Second)
+	defer cancelExpired()
+
+	tests := map[string]struct {
+		ctx         context.Context
+		expectedErr error
+	}{
+		"canceled": {ctx: canceled, expectedErr: context.Canceled},
+		"expired":  {ctx: expired, expectedErr: context.DeadlineExceeded},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			s := NewSyftScanner()
+			packages, err := s.ScanContext(tt.ctx, t.TempDir())
+			if !errors.Is(err, ErrSyft) || !errors.Is(err, tt.expectedErr) {
+				t.Errorf("expected %v and %v, got %v", ErrSyft, tt.expectedErr, err)
+			}
+			if len(packages) != 0 {
+				t.Errorf("expected no packages, got %v", packages)
+			}
+		})
+	}
+}
+
+func TestScanWithinAbandonsBlockedScan(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// a cataloger ignoring ctx, which never returns during the test
+	unblock := make(chan struct{})
+	t.Cleanup(func() { close(unblock) })
+	blocked := func(context.Context, string) ([]SyftPackage, error) {
+		<-unblock
+		return []SyftPackage{{PURL: "pkg:generic/late"}}, nil
+	}
+
+	returned := make(chan error, 1)
+	go func() {
+		_, err := scanWithin(ctx, t.TempDir(), blocked)
+		returned <- err
+	}()
+
+	select {
+	case err := <-returned:
+		if !errors.Is(err, ErrSyft) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected %v and %v, got %v", ErrSyft, context.DeadlineExceeded, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("scan didn't return after its deadline")
+	}
+}
+
+func TestValidateExcludePaths(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		patterns    []string
+		expectedErr error
+	}{
+		"none":           {},
+		"relative":       {patterns: []string{"./usr/share/doc/**"}},
+		"any directory":  {patterns: []string{"*/cache", "**/*.log"}},
+		"absolute":       {patterns: []string{"./proc/**", "/usr/share/doc"}, expectedErr: ErrExcludePath},
+		"without prefix": {patterns: []string{"usr/share/doc"}, expectedErr: ErrExcludePath},
+		"empty":          {patterns: []string{""}, expectedErr: ErrExcludePath},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if err := ValidateExcludePaths(tt.patterns); !errors.Is(err, tt.expectedErr) {
+				t.Errorf("expected error wrapping %v, got: %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
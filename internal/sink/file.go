@@ -0,0 +1,26 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// fileSink stores documents as files, with their path as the key. Parent
+// directories are created as needed.
+type fileSink struct{}
+
+func (fileSink) Put(_ context.Context, key string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(key), 0o755); err != nil {
+		return fmt.Errorf("%w %q: %w", ErrPut, key, err)
+	}
+	if err := os.WriteFile(key, data, 0o644); err != nil {
+		return fmt.Errorf("%w %q: %w", ErrPut, key, err)
+	}
+	return nil
+}
+
+func (fileSink) Close() error {
+	return nil
+}
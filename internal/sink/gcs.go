@@ -0,0 +1,42 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+
+	"cloud.google.com/go/storage"
+)
+
+// gcsSink stores documents as objects of a GCS bucket.
+type gcsSink struct {
+	client *storage.Client
+	bucket string
+}
+
+func newGCSSink(ctx context.Context, bucket string) (Sink, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client: %w", err)
+	}
+	return gcsSink{client: client, bucket: bucket}, nil
+}
+
+// Put uploads the object with its CRC32C checksum, which GCS verifies before
+// storing it.
+func (s gcsSink) Put(ctx context.Context, key string, data []byte) error {
+	w := s.client.Bucket(s.bucket).Object(key).NewWriter(ctx)
+	w.ContentType = "application/json"
+	w.CRC32C = crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))
+	w.SendCRC32C = true
+
+	if _, err := w.Write(data); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (s gcsSink) Close() error {
+	return s.client.Close()
+}
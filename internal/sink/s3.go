@@ -0,0 +1,47 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// s3Sink stores documents as objects of an S3 bucket.
+type s3Sink struct {
+	client *s3.Client
+	bucket string
+}
+
+func newS3Sink(ctx context.Context, bucket string) (Sink, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load S3 configuration: %w", err)
+	}
+	return s3Sink{client: s3.NewFromConfig(cfg), bucket: bucket}, nil
+}
+
+// Put uploads the object with its SHA-256 checksum, which S3 verifies before
+// storing it.
+func (s s3Sink) Put(ctx context.Context, key string, data []byte) error {
+	sum := sha256.Sum256(data)
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:            aws.String(s.bucket),
+		Key:               aws.String(key),
+		Body:              bytes.NewReader(data),
+		ContentType:       aws.String("application/json"),
+		ChecksumAlgorithm: s3types.ChecksumAlgorithmSha256,
+		ChecksumSHA256:    aws.String(base64.StdEncoding.EncodeToString(sum[:])),
+	})
+	return err
+}
+
+func (s s3Sink) Close() error {
+	return nil
+}
@@ -0,0 +1,104 @@
+// Package sink stores output documents in a local directory or an object
+// store, see Open.
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var ErrURL = errors.New("invalid output URL, expected a path, file://, s3:// or gs:// URL")
+var ErrPut = errors.New("failed to store output")
+
+// Sink stores documents by key. Implementations verify the checksum of the
+// stored content where the store supports it.
+type Sink interface {
+	// Put stores data under key, replacing any previous content.
+	Put(ctx context.Context, key string, data []byte) error
+	// Close releases the resources of the sink.
+	Close() error
+}
+
+// Default retries of a failed Put, see WithRetry.
+const (
+	DefaultAttempts = 3
+	DefaultBackoff  = time.Second
+)
+
+// Open returns the sink of the location of rawURL and the key of the URL in
+// it: a local path (or file:// URL) with the path as the key, an S3 URL
+// (s3://bucket/key) or a GCS URL (gs://bucket/key). Object store credentials
+// are read from the environment, the same as by the aws and gcloud CLIs
+// (e.g. AWS_ENDPOINT_URL for S3-compatible stores). Puts to object stores are
+// retried with the defaults of WithRetry.
+func Open(ctx context.Context, rawURL string) (Sink, string, error) {
+	if !strings.Contains(rawURL, "://") {
+		return fileSink{}, rawURL, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %w", ErrURL, err)
+	}
+	if u.Scheme == "file" {
+		return fileSink{}, u.Path, nil
+	}
+	if u.Scheme != "s3" && u.Scheme != "gs" {
+		return nil, "", fmt.Errorf("%w: unknown scheme %q", ErrURL, u.Scheme)
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, "", fmt.Errorf("%w: missing bucket or key in %q", ErrURL, rawURL)
+	}
+
+	var s Sink
+	if u.Scheme == "s3" {
+		s, err = newS3Sink(ctx, u.Host)
+	} else {
+		s, err = newGCSSink(ctx, u.Host)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return WithRetry(s, DefaultAttempts, DefaultBackoff), key, nil
+}
+
+// retrySink retries failed puts of the wrapped sink.
+type retrySink struct {
+	Sink
+	attempts int
+	backoff  time.Duration
+}
+
+// WithRetry returns the sink with failed puts tried up to attempts times in
+// total, waiting backoff before the first retry and twice as long before each
+// following one.
+func WithRetry(s Sink, attempts int, backoff time.Duration) Sink {
+	return retrySink{Sink: s, attempts: max(attempts, 1), backoff: backoff}
+}
+
+func (s retrySink) Put(ctx context.Context, key string, data []byte) error {
+	var errs []error
+	wait := s.backoff
+	for attempt := range s.attempts {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%w %q: %w", ErrPut, key, errors.Join(append(errs, ctx.Err())...))
+			case <-time.After(wait):
+			}
+			wait *= 2
+		}
+
+		err := s.Sink.Put(ctx, key, data)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return fmt.Errorf("%w %q after %d attempts: %w", ErrPut, key, s.attempts, errors.Join(errs...))
+}
@@ -0,0 +1,103 @@
+//go:build unit
+
+package sink
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		url         string
+		expectedKey string
+		expectedErr error
+	}{
+		"path":              {url: "out/capo.json", expectedKey: "out/capo.json"},
+		"file url":          {url: "file:///tmp/capo.json", expectedKey: "/tmp/capo.json"},
+		"unknown scheme":    {url: "ftp://host/capo.json", expectedErr: ErrURL},
+		"s3 without key":    {url: "s3://bucket", expectedErr: ErrURL},
+		"gs without bucket": {url: "gs:///capo.json", expectedErr: ErrURL},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			s, key, err := Open(context.Background(), test.url)
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error wrapping %v, got: %v", test.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			defer s.Close()
+			if key != test.expectedKey {
+				t.Errorf("expected key %q, got %q", test.expectedKey, key)
+			}
+		})
+	}
+}
+
+func TestFileSinkPut(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "out", "capo.json.partial", "origin.json")
+	if err := (fileSink{}).Put(context.Background(), path, []byte("{}\n")); err != nil {
+		t.Fatalf("Put returned error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read stored file: %v", err)
+	}
+	if string(data) != "{}\n" {
+		t.Errorf("expected stored content %q, got %q", "{}\n", data)
+	}
+}
+
+// flakySink fails as many puts as failures before succeeding.
+type flakySink struct {
+	failures int
+	puts     int
+}
+
+func (s *flakySink) Put(context.Context, string, []byte) error {
+	s.puts++
+	if s.puts <= s.failures {
+		return errors.New("connection reset")
+	}
+	return nil
+}
+
+func (s *flakySink) Close() error {
+	return nil
+}
+
+func TestRetrySink(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		failures     int
+		expectedPuts int
+		expectedErr  error
+	}{
+		"no failures":        {failures: 0, expectedPuts: 1},
+		"recovers":           {failures: 2, expectedPuts: 3},
+		"fails all attempts": {failures: 5, expectedPuts: 3, expectedErr: ErrPut},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			flaky := &flakySink{failures: test.failures}
+			err := WithRetry(flaky, 3, 0).Put(context.Background(), "capo.json", []byte("{}"))
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error wrapping %v, got: %v", test.expectedErr, err)
+			}
+			if flaky.puts != test.expectedPuts {
+				t.Errorf("expected %d puts, got %d", test.expectedPuts, flaky.puts)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+//go:build unit
+
+package testutils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GenerateContainerfile returns a containerfile with the passed number of
+// builder stages for benchmarks. Builders use a handful of shared base
+// images, every third builder is chained on the previous one, each builder
+// copies content from its predecessor and the final stage copies from all
+// builders and from an external image.
+func GenerateContainerfile(builders int) string {
+	var b strings.Builder
+
+	for i := range builders {
+		if i > 0 && i%3 == 0 {
+			fmt.Fprintf(&b, "FROM builder%d AS builder%d\n", i-1, i)
+		} else {
+			fmt.Fprintf(&b, "FROM registry.example.com/base-%d:latest AS builder%d\n", i%4, i)
+		}
+		b.WriteString("WORKDIR /src\n")
+		fmt.Fprintf(&b, "RUN make -C /src/app%d install PREFIX=/opt/builder%d\n", i, i)
+		if i > 0 {
+			fmt.Fprintf(&b, "COPY --from=builder%d /opt/builder%d/lib/ /opt/builder%d/lib/\n", i-1, i-1, i)
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("FROM registry.example.com/runtime:latest\n")
+	for i := range builders {
+		fmt.Fprintf(&b, "COPY --from=builder%d /opt/builder%d/bin/app%d /usr/bin/\n", i, i, i)
+	}
+	b.WriteString("COPY --from=registry.example.com/tools:latest /usr/bin/tool* /usr/bin/\n")
+
+	return b.String()
+}
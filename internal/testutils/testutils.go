@@ -16,6 +16,9 @@ type TStorageClient struct {
 	digests map[string]digest.Digest
 	// Mapping of image pullspec to the OCIImageConfig of the image
 	configs map[string]storageclient.OCIImageConfig
+	// Mapping of image pullspec to the digest of the manifest list it was
+	// pulled through
+	indexDigests map[string]digest.Digest
 }
 
 // NewTStorageClient creates a new MockClient with the provided digests and configs.
@@ -36,6 +39,22 @@ func (c *TStorageClient) ResolveDigest(pullspec string) (digest.Digest, error) {
 	return dig, nil
 }
 
+// WithIndexDigests sets digests of manifest lists the images were pulled through.
+func (c *TStorageClient) WithIndexDigests(indexDigests map[string]digest.Digest) *TStorageClient {
+	c.indexDigests = indexDigests
+	return c
+}
+
+// ResolveIndexDigest returns the manifest list digest for the given pullspec,
+// or an empty digest if it has none in the mock data.
+func (c *TStorageClient) ResolveIndexDigest(pullspec string) (digest.Digest, error) {
+	if _, ok := c.digests[pullspec]; !ok {
+		return "", fmt.Errorf("digest for %q not found", pullspec)
+	}
+
+	return c.indexDigests[pullspec], nil
+}
+
 // GetImageConfig returns the config for the given pullspec if it exists in the mock data.
 func (c *TStorageClient) GetImageConfig(pullspec string) (storageclient.OCIImageConfig, error) {
 	cfg, ok := c.configs[pullspec]
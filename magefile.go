@@ -5,6 +5,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/magefile/mage/sh"
@@ -76,6 +77,55 @@ func Test() error {
 	return sh.RunV("go", "test", "-tags=unit,exclude_graphdriver_btrfs", "./...")
 }
 
+// Runs the containerfile parser over the corpus in testdata/corpus and compares
+// the parsed stages with the golden files. Pass "update" to rewrite them:
+// $ mage conformance update
+func Conformance(mode string) error {
+	args := []string{"run", "-tags=exclude_graphdriver_btrfs", CapoPackage, "conformance"}
+	if mode == "update" {
+		args = append(args, "--update")
+	}
+	return sh.RunV("go", args...)
+}
+
+// Runs the fuzz targets of the containerfile parser, each for the passed
+// duration (e.g. "1m").
+func Fuzz(duration string) error {
+	for _, target := range []string{"FuzzParse", "FuzzParseCopy"} {
+		err := sh.RunV(
+			"go", "test",
+			"-run=^$",
+			"-fuzz=^"+target+"$",
+			"-fuzztime="+duration,
+			"-tags=unit,exclude_graphdriver_btrfs",
+			"./pkg/containerfile",
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Runs benchmarks of parsing, tracing and content extraction and writes the
+// results to bench_output.txt for comparison with benchstat.
+func Bench() error {
+	out, err := sh.Output(
+		"go", "test",
+		"-run=^$",
+		"-bench=.",
+		"-benchmem",
+		"-count=5",
+		"-tags=unit,exclude_graphdriver_btrfs",
+		"./...",
+	)
+	fmt.Println(out)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile("bench_output.txt", []byte(out+"\n"), 0o644)
+}
+
 // Runs unit tests with coverage profiling and writes the result to coverage.out.
 func Coverage() error {
 	return sh.RunV("go", "test", "-coverprofile=coverage.out", "-covermode=atomic", "-tags=unit,exclude_graphdriver_btrfs", "./...")
@@ -128,6 +178,73 @@ func IntegrationTest() error {
 	)
 }
 
+// Rewrites the expected outputs of the golden-file integration test cases in
+// testdata/integration from their scans. Review the diff before committing.
+func IntegrationRecord() error {
+	return sh.RunV(
+		"buildah",
+		"unshare",
+		"go",
+		"test",
+		"-v",
+		"-count=1",
+		"-tags=integration,exclude_graphdriver_btrfs",
+		"-run=TestIntegrationGolden",
+		"./pkg",
+		"-record",
+	)
+}
+
+// Runs the integration tests once for every storage driver of the passed
+// comma-separated list, as mounts and layer diffs differ between drivers
+// (e.g. vfs is used in containers without overlay support):
+// $ mage IntegrationDrivers overlay,vfs
+func IntegrationDrivers(drivers string) error {
+	for _, driver := range strings.Split(drivers, ",") {
+		fmt.Printf("Running integration tests with the %s driver\n", driver)
+		if err := integrationTestWithDriver(driver); err != nil {
+			return fmt.Errorf("%s driver: %w", driver, err)
+		}
+	}
+	return nil
+}
+
+// Runs the integration tests in a new temporary store of the driver, set up
+// for both buildah and capo by CONTAINERS_STORAGE_CONF and STORAGE_DRIVER.
+func integrationTestWithDriver(driver string) error {
+	dir, err := os.MkdirTemp("", "capo-"+driver+"-")
+	if err != nil {
+		return err
+	}
+	// rootless, the store has files owned by users of the namespace
+	defer sh.Run("buildah", "unshare", "rm", "-rf", dir)
+
+	conf := filepath.Join(dir, "storage.conf")
+	content := fmt.Sprintf(
+		"[storage]\ndriver = %q\ngraphroot = %q\nrunroot = %q\n",
+		driver, filepath.Join(dir, "graph"), filepath.Join(dir, "run"),
+	)
+	if err := os.WriteFile(conf, []byte(content), 0o644); err != nil {
+		return err
+	}
+
+	env := map[string]string{
+		"CONTAINERS_STORAGE_CONF": conf,
+		"STORAGE_DRIVER":          driver,
+	}
+	return sh.RunWithV(
+		env,
+		"buildah",
+		"unshare",
+		"go",
+		"test",
+		"-v",
+		"-count=1",
+		"-tags=integration,exclude_graphdriver_btrfs",
+		"./pkg",
+	)
+}
+
 // Runs integration tests with coverage profiling and writes coverage-integration.out.
 func IntegrationCoverage() error {
 	return sh.RunV(
@@ -0,0 +1,141 @@
+// Recording and scanning of the base image of the final stage.
+
+package capo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+	"github.com/konflux-ci/capo/pkg/storageclient"
+)
+
+// BaseImage is the base image of the final stage.
+type BaseImage struct {
+	// Pullspec of the base image as used in the Containerfile (resolved
+	// through chained stages).
+	Pullspec string `json:"pullspec"`
+	// Pullspec of the base image as written in the Containerfile, if it was
+	// pulled by Pullspec instead (see WithPullspecMap). Omitted otherwise.
+	OriginalPullspec string `json:"original_pullspec,omitempty"`
+	// Digest of the base image in local storage, of the platform-specific
+	// manifest for images pulled through a manifest list.
+	Digest string `json:"digest"`
+	// Digest of the manifest list the base image was pulled through.
+	// Omitted for images pulled by their manifest directly.
+	IndexDigest string `json:"index_digest,omitempty"`
+}
+
+// getBaseImage resolves the digest of the final stage base image. Returns nil
+// if the final stage has a special base (e.g. scratch), which is no image.
+func getBaseImage(storageClient storageclient.Client, cf containerfile.Containerfile) (*BaseImage, error) {
+	final := cf.FinalStage()
+	if final == nil || storageclient.IsSpecialBase(final.Base) {
+		return nil, nil
+	}
+
+	dig, err := storageClient.ResolveDigest(final.Base)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve pullspec %q: %w: %w", final.Base, err, ErrPullspecResolve)
+	}
+
+	index, err := storageClient.ResolveIndexDigest(final.Base)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve pullspec %q: %w: %w", final.Base, err, ErrPullspecResolve)
+	}
+
+	return &BaseImage{Pullspec: final.Base, Digest: dig.String(), IndexDigest: index.String()}, nil
+}
+
+// scanBaseImage runs a syft scan on the whole mounted base image and returns
+// its packages with the base origin type.
+func (s *Scanner) scanBaseImage(base BaseImage) ([]PackageMetadataItem, error) {
+	digestBase, err := attachDigest(storageclient.StripTransport(base.Pullspec), digest.Digest(base.Digest))
+	if err != nil {
+		return nil, err
+	}
+
+	imgID, err := s.store.Lookup(storageclient.StripTransport(base.Pullspec))
+	if err != nil {
+		imgID, err = s.store.Lookup(digestBase)
+		if err != nil {
+			return nil, fmt.Errorf("could not find image %q in buildah storage: %w", base.Pullspec, ErrImageNotFound)
+		}
+	}
+
+	// the timeout covers the extraction of the image as well
+	ctx, cancel := s.scanContext()
+	defer cancel()
+	timedOut := func() {
+		s.warn(WarnScanTimeout, fmt.Sprintf(
+			"scan of base image %q timed out after %s, its packages are missing", base.Pullspec, s.scanTimeout,
+		))
+	}
+
+	rootPath, release, err := s.imageRoot(ctx, imgID)
+	if errors.Is(err, context.DeadlineExceeded) {
+		timedOut()
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	s.logger.Debug("scanning final stage base image", "pullspec", digestBase)
+	pkgs, err := s.syftScanner.ScanContext(ctx, rootPath)
+	if errors.Is(err, context.DeadlineExceeded) {
+		timedOut()
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan base image %q: %w: %w", base.Pullspec, err, ErrSBOMScan)
+	}
+
+	res := make([]PackageMetadataItem, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		res = append(res, withID(PackageMetadataItem{
+			Pullspec:         digestBase,
+			PackageURL:       pkg.PURL,
+			DependencyOfPURL: pkg.DependencyOfPURL,
+			Checksums:        pkg.Checksums,
+			Licenses:         pkg.Licenses,
+			OriginType:       originTypeBase,
+			IndexDigest:      base.IndexDigest,
+			OriginalPullspec: base.OriginalPullspec,
+		}, pkg.Locations))
+	}
+
+	return res, nil
+}
+
+// imageRoot returns the path of the root filesystem of the image with the ID:
+// its mount or, with ExtractorLayers, the whole image extracted to a content
+// directory. The returned function releases the mount or removes the content.
+func (s *Scanner) imageRoot(ctx context.Context, imgID string) (string, func(), error) {
+	if s.extractorKind != ExtractorLayers {
+		mountPath, err := s.mounts.acquire(imgID)
+		if err != nil {
+			return "", nil, err
+		}
+		return mountPath, func() { s.mounts.release(imgID) }, nil
+	}
+
+	image, err := s.store.Image(imgID)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not find image %q in buildah storage: %w", imgID, ErrImageNotFound)
+	}
+	contentPath, err := s.contentDir(imgID, debugKindBuilder)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp directory: %w: %w", err, ErrIO)
+	}
+	release := func() { _ = s.removeContentDirs(contentPath) }
+	if _, err := s.newExtractor().ExtractPaths(ctx, image, []string{"/"}, contentPath); err != nil {
+		release()
+		return "", nil, err
+	}
+	return contentPath, release, nil
+}
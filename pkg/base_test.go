@@ -0,0 +1,72 @@
+//go:build unit
+
+package capo
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/konflux-ci/capo/internal/testutils"
+	"github.com/konflux-ci/capo/pkg/containerfile"
+)
+
+func TestGetBaseImage(t *testing.T) {
+	t.Parallel()
+	digests := map[string]digest.Digest{
+		"registry.access.redhat.com/ubi9/ubi-minimal:latest": testDigest("a"),
+	}
+
+	tests := map[string]struct {
+		cf          containerfile.Containerfile
+		expected    *BaseImage
+		expectedErr error
+	}{
+		"final stage base": {
+			cf: containerfile.Containerfile{Stages: []containerfile.Stage{
+				{Alias: "builder", Base: "docker.io/library/fedora:latest", Index: 0},
+				{
+					Alias: "1",
+					Base:  "registry.access.redhat.com/ubi9/ubi-minimal:latest",
+					Index: 1,
+					Kind:  containerfile.StageKindFinal,
+				},
+			}},
+			expected: &BaseImage{
+				Pullspec: "registry.access.redhat.com/ubi9/ubi-minimal:latest",
+				Digest:   testDigest("a").String(),
+			},
+		},
+		"scratch": {
+			cf: containerfile.Containerfile{Stages: []containerfile.Stage{
+				{Alias: "0", Base: "scratch", Index: 0, Kind: containerfile.StageKindFinal},
+			}},
+		},
+		"no stages": {
+			cf: containerfile.Containerfile{},
+		},
+		"unresolvable": {
+			cf: containerfile.Containerfile{Stages: []containerfile.Stage{
+				{Alias: "0", Base: "quay.io/missing:latest", Index: 0, Kind: containerfile.StageKindFinal},
+			}},
+			expectedErr: ErrPullspecResolve,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			client := testutils.NewTStorageClient(digests, nil)
+
+			actual, err := getBaseImage(client, test.cf)
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error wrapping %v, got: %v", test.expectedErr, err)
+			}
+			if diff := cmp.Diff(test.expected, actual); diff != "" {
+				t.Errorf("getBaseImage() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
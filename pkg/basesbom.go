@@ -0,0 +1,45 @@
+// Marking of copied packages also in the final stage base image, see
+// WithBaseSBOM.
+
+package capo
+
+import (
+	"fmt"
+
+	"github.com/konflux-ci/capo/internal/sbom"
+)
+
+var ErrBaseSBOM = fmt.Errorf("[ERR_BASE_SBOM] failed to read base SBOM: %w", ErrParse)
+
+// Configure the scanner to mark packages copied to the final image which are
+// also in the final stage base image, by the SBOM of the base image at path,
+// with PackageMetadataItem.DuplicateOfBase. Packages of the base image scan
+// (see WithScanBase) are compared as well, the SBOM isn't needed then.
+func WithBaseSBOM(path string) Option {
+	return func(s *Scanner) {
+		s.baseSBOM = path
+	}
+}
+
+// basePURLs returns the purls of the packages of the base SBOM and of the
+// base image scan.
+func basePURLs(sbomPackages []sbom.SyftPackage, baseItems []PackageMetadataItem) map[string]bool {
+	res := make(map[string]bool, len(sbomPackages)+len(baseItems))
+	for _, pkg := range sbomPackages {
+		res[pkg.PURL] = true
+	}
+	for _, item := range baseItems {
+		res[item.PackageURL] = true
+	}
+	return res
+}
+
+// markDuplicatesOfBase marks the items whose purl is among the purls of the
+// final stage base image.
+func markDuplicatesOfBase(items []PackageMetadataItem, base map[string]bool) {
+	for i := range items {
+		if items[i].OriginType != originTypeBase && base[items[i].PackageURL] {
+			items[i].DuplicateOfBase = true
+		}
+	}
+}
@@ -0,0 +1,36 @@
+//go:build unit
+
+package capo
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/konflux-ci/capo/internal/sbom"
+)
+
+func TestMarkDuplicatesOfBase(t *testing.T) {
+	t.Parallel()
+	base := basePURLs(
+		[]sbom.SyftPackage{{PURL: "pkg:rpm/redhat/openssl@3.0.7"}},
+		[]PackageMetadataItem{{PackageURL: "pkg:rpm/redhat/bash@5.1.8", OriginType: originTypeBase}},
+	)
+	items := []PackageMetadataItem{
+		{PackageURL: "pkg:rpm/redhat/openssl@3.0.7", OriginType: "builder"},
+		{PackageURL: "pkg:rpm/redhat/openssl@3.0.1", OriginType: "builder"},
+		{PackageURL: "pkg:rpm/redhat/bash@5.1.8", OriginType: "external"},
+		{PackageURL: "pkg:rpm/redhat/bash@5.1.8", OriginType: originTypeBase},
+	}
+	expected := []PackageMetadataItem{
+		{PackageURL: "pkg:rpm/redhat/openssl@3.0.7", OriginType: "builder", DuplicateOfBase: true},
+		{PackageURL: "pkg:rpm/redhat/openssl@3.0.1", OriginType: "builder"},
+		{PackageURL: "pkg:rpm/redhat/bash@5.1.8", OriginType: "external", DuplicateOfBase: true},
+		{PackageURL: "pkg:rpm/redhat/bash@5.1.8", OriginType: originTypeBase},
+	}
+
+	markDuplicatesOfBase(items, base)
+	if diff := cmp.Diff(expected, items); diff != "" {
+		t.Errorf("markDuplicatesOfBase() mismatch (-want +got):\n%s", diff)
+	}
+}
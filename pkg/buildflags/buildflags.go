@@ -0,0 +1,174 @@
+// Package buildflags provides functions for reading the buildah command used
+// for the build, so the build configuration can be reported with the scan
+// results.
+package buildflags
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrInvalidBuildFlags is returned when the build flags can't be split into
+// arguments, e.g. because of an unterminated quote.
+var ErrInvalidBuildFlags = errors.New("[ERR_INVALID_BUILD_FLAGS] invalid build flags")
+
+// Invocation describes the buildah command which preceded capo. Isolation and
+// namespace options affect how buildah commits intermediate images, so they
+// are reported separately.
+type Invocation struct {
+	// Arguments of the buildah command, e.g. ["buildah", "build", "--layers", ...].
+	Command []string `json:"command"`
+
+	// Value of the --isolation option. Omitted if not passed.
+	Isolation string `json:"isolation,omitempty"`
+
+	// Values of namespace options (e.g. "userns", "network") by option name.
+	// Omitted if none were passed.
+	Namespaces map[string]string `json:"namespaces,omitempty"`
+}
+
+// buildah build options configuring namespaces of RUN instructions.
+var namespaceOptions = []string{"cgroupns", "ipc", "network", "pid", "userns", "uts"}
+
+// ReadFile reads the buildah command from the file at path. See Parse for the
+// expected format.
+func ReadFile(path string) (Invocation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Invocation{}, fmt.Errorf("opening build-flags-file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	inv, err := Parse(f)
+	if err != nil {
+		return Invocation{}, fmt.Errorf("in %s: %w", path, err)
+	}
+	return inv, nil
+}
+
+// Parse reads a buildah command written as shell words, e.g. by
+// `printf '%q ' buildah build "${BUILDAH_ARGS[@]}"`. Arguments are separated by
+// whitespace including newlines and can be quoted with single or double
+// quotes or escaped with a backslash. A backslash before a newline continues
+// the line.
+func Parse(r io.Reader) (Invocation, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return Invocation{}, fmt.Errorf("reading build flags: %w", err)
+	}
+
+	command, err := splitWords(string(content))
+	if err != nil {
+		return Invocation{}, err
+	}
+
+	inv := Invocation{Command: command}
+	if isolation, ok := optionValue(command, "isolation"); ok {
+		inv.Isolation = isolation
+	}
+	for _, name := range namespaceOptions {
+		if value, ok := optionValue(command, name); ok {
+			if inv.Namespaces == nil {
+				inv.Namespaces = make(map[string]string)
+			}
+			inv.Namespaces[name] = value
+		}
+	}
+
+	return inv, nil
+}
+
+// Split splits s into arguments the way a POSIX shell does, without any
+// expansions, e.g. to run a command passed as a single flag value.
+func Split(s string) ([]string, error) {
+	return splitWords(s)
+}
+
+// optionValue returns the value of the last occurrence of the long option
+// with the passed name in args, passed either as --name=value or --name value.
+// Arguments after "--" are not options.
+func optionValue(args []string, name string) (string, bool) {
+	var value string
+	var found bool
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if v, ok := strings.CutPrefix(arg, "--"+name+"="); ok {
+			value, found = v, true
+		} else if arg == "--"+name && i+1 < len(args) {
+			value, found = args[i+1], true
+			i++
+		}
+	}
+
+	return value, found
+}
+
+// splitWords splits s into words the way a POSIX shell does, without any
+// expansions.
+func splitWords(s string) ([]string, error) {
+	words := make([]string, 0)
+	var word strings.Builder
+	// a word can be empty if quoted, e.g. --build-arg ''
+	inWord := false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\':
+			if i+1 >= len(s) {
+				return nil, fmt.Errorf("%w: trailing backslash", ErrInvalidBuildFlags)
+			}
+			i++
+			if s[i] == '\n' {
+				continue
+			}
+			word.WriteByte(s[i])
+			inWord = true
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated single quote", ErrInvalidBuildFlags)
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				// within double quotes, backslash only escapes these
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`\n", s[i+1]) >= 0 {
+					i++
+					if s[i] == '\n' {
+						continue
+					}
+				}
+				word.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("%w: unterminated double quote", ErrInvalidBuildFlags)
+			}
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
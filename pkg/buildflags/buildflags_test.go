@@ -0,0 +1,126 @@
+//go:build unit
+
+package buildflags
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		content  string
+		expected Invocation
+		wantErr  error
+	}{
+		"single line": {
+			content: "buildah build --layers --isolation=chroot -f Containerfile .\n",
+			expected: Invocation{
+				Command:   []string{"buildah", "build", "--layers", "--isolation=chroot", "-f", "Containerfile", "."},
+				Isolation: "chroot",
+			},
+		},
+		"one argument per line": {
+			content: "buildah\nbuild\n--isolation\noci\n--userns\nauto\n.\n",
+			expected: Invocation{
+				Command:    []string{"buildah", "build", "--isolation", "oci", "--userns", "auto", "."},
+				Isolation:  "oci",
+				Namespaces: map[string]string{"userns": "auto"},
+			},
+		},
+		"line continuations": {
+			content: "buildah build \\\n  --network=none \\\n  --pid host \\\n  .",
+			expected: Invocation{
+				Command:    []string{"buildah", "build", "--network=none", "--pid", "host", "."},
+				Namespaces: map[string]string{"network": "none", "pid": "host"},
+			},
+		},
+		"quoted arguments": {
+			content: `buildah build --build-arg 'LABEL=a b' --build-arg "X=\"y\"" --build-arg Z=a\ b --build-arg '' .`,
+			expected: Invocation{
+				Command: []string{
+					"buildah", "build",
+					"--build-arg", "LABEL=a b",
+					"--build-arg", `X="y"`,
+					"--build-arg", "Z=a b",
+					"--build-arg", "",
+					".",
+				},
+			},
+		},
+		"last occurrence wins": {
+			content: "buildah build --isolation=oci --isolation chroot .",
+			expected: Invocation{
+				Command:   []string{"buildah", "build", "--isolation=oci", "--isolation", "chroot", "."},
+				Isolation: "chroot",
+			},
+		},
+		"options after double dash are ignored": {
+			content: "buildah build -- --isolation=oci",
+			expected: Invocation{
+				Command: []string{"buildah", "build", "--", "--isolation=oci"},
+			},
+		},
+		"option without value": {
+			content: "buildah build --isolation",
+			expected: Invocation{
+				Command: []string{"buildah", "build", "--isolation"},
+			},
+		},
+		"empty": {
+			content:  "",
+			expected: Invocation{Command: []string{}},
+		},
+		"unterminated single quote": {
+			content: "buildah build --build-arg 'A=b",
+			wantErr: ErrInvalidBuildFlags,
+		},
+		"unterminated double quote": {
+			content: `buildah build --build-arg "A=b`,
+			wantErr: ErrInvalidBuildFlags,
+		},
+		"trailing backslash": {
+			content: `buildah build \`,
+			wantErr: ErrInvalidBuildFlags,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			actual, err := Parse(strings.NewReader(tc.content))
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error wrapping %v, got: %v", tc.wantErr, err)
+			}
+			if diff := cmp.Diff(tc.expected, actual); diff != "" {
+				t.Errorf("Parse() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "build-flags")
+	if err := os.WriteFile(path, []byte("buildah build --isolation=chroot ."), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	inv, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if inv.Isolation != "chroot" {
+		t.Errorf("expected isolation chroot, got %q", inv.Isolation)
+	}
+
+	if _, err := ReadFile(filepath.Join(t.TempDir(), "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected error wrapping %v, got: %v", os.ErrNotExist, err)
+	}
+}
@@ -0,0 +1,203 @@
+// Handling of content mounted from other stages and images by RUN
+// --mount=from=..., which the build uses but which doesn't persist in the
+// final image (see MountPolicy).
+
+package capo
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+	"github.com/konflux-ci/capo/pkg/storageclient"
+	"github.com/opencontainers/go-digest"
+)
+
+// MountPolicy controls how content mounted by RUN --mount=from=... is
+// handled, see WithMountPolicy.
+type MountPolicy string
+
+const (
+	// MountPolicyFail fails the scan with ErrMountTypeBind. The default.
+	MountPolicyFail MountPolicy = "fail"
+	// MountPolicyIgnore leaves mounted content out of the output.
+	MountPolicyIgnore MountPolicy = "ignore"
+	// MountPolicyReport records the mounts in
+	// PackageMetadata.BuildDependencies.
+	MountPolicyReport MountPolicy = "report"
+	// MountPolicyScan records the mounts the same as MountPolicyReport and
+	// also scans the mounted content, adding its packages with BuildTime set.
+	MountPolicyScan MountPolicy = "scan"
+)
+
+var ErrInvalidMountPolicy = fmt.Errorf(
+	"[ERR_INVALID_MOUNT_POLICY] invalid mount policy, expected fail, ignore, report or scan: %w", ErrParse,
+)
+
+// ParseMountPolicy returns the mount policy with the passed name.
+func ParseMountPolicy(s string) (MountPolicy, error) {
+	switch policy := MountPolicy(s); policy {
+	case MountPolicyFail, MountPolicyIgnore, MountPolicyReport, MountPolicyScan:
+		return policy, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrInvalidMountPolicy, s)
+}
+
+// BuildDependency is content of a stage or an image mounted by a RUN
+// --mount=from=... instruction. It's used by the build, but not copied to
+// the final image.
+type BuildDependency struct {
+	// Alias of the stage with the RUN instruction.
+	StageAlias string `json:"stage_alias"`
+	// Zero-based index of the stage with the RUN instruction.
+	StageIndex int `json:"stage_index"`
+	// Stage alias or pullspec of the from option of the mount.
+	From string `json:"from"`
+	// Pullspec with digest of the mounted image. Omitted for mounted stages.
+	Pullspec string `json:"pullspec,omitempty"`
+	// Zero-based index of the mounted stage. Omitted for mounted images.
+	FromStageIndex *int `json:"from_stage_index,omitempty"`
+	// Absolute path of the mounted content in the stage or image.
+	Source string `json:"source"`
+	// Path the content was mounted at, as written. Omitted if not specified.
+	Target string `json:"target,omitempty"`
+}
+
+// isBuildMount reports whether the mount makes content of another stage or
+// image available to a RUN instruction.
+func isBuildMount(mount containerfile.Mount) bool {
+	return mount.MountType == containerfile.MountTypeBind && mount.FromRaw != ""
+}
+
+// getMountDigests resolves the digests of images mounted by RUN
+// --mount=from=... instructions and adds them to digests.
+func getMountDigests(
+	storageClient storageclient.Client,
+	cf containerfile.Containerfile,
+	digests map[string]digest.Digest,
+) error {
+	for _, stage := range cf.Stages {
+		for _, mount := range stage.Mounts {
+			if !isBuildMount(mount) || mount.Pullspec == "" {
+				continue
+			}
+			if _, ok := digests[mount.Pullspec]; ok {
+				continue
+			}
+
+			dig, err := storageClient.ResolveDigest(mount.Pullspec)
+			if err != nil {
+				return fmt.Errorf("failed to resolve pullspec %q: %w: %w", mount.Pullspec, err, ErrPullspecResolve)
+			}
+			digests[mount.Pullspec] = dig
+		}
+	}
+	return nil
+}
+
+// getBuildDependencies returns the content mounted by RUN --mount=from=...
+// instructions, in order.
+func getBuildDependencies(
+	cf containerfile.Containerfile,
+	digests map[string]digest.Digest,
+) ([]BuildDependency, error) {
+	res := make([]BuildDependency, 0)
+	for _, stage := range cf.Stages {
+		for _, mount := range stage.Mounts {
+			if !isBuildMount(mount) {
+				continue
+			}
+
+			dep := BuildDependency{
+				StageAlias: stage.Alias,
+				StageIndex: stage.Index,
+				From:       mount.FromRaw,
+				Source:     path.Join("/", mount.Source),
+				Target:     mount.Target,
+			}
+			if mount.Pullspec != "" {
+				dep.Pullspec = mount.Pullspec
+				if dig, ok := digests[mount.Pullspec]; ok {
+					var err error
+					dep.Pullspec, err = attachDigest(storageclient.StripTransport(mount.Pullspec), dig)
+					if err != nil {
+						return nil, err
+					}
+				}
+			} else if from := cf.ResolveRef(mount.FromRaw, stage.Index); from != nil {
+				index := from.Index
+				dep.FromStageIndex = &index
+			}
+			res = append(res, dep)
+		}
+	}
+	return res, nil
+}
+
+// getMountPackageSources traces the content mounted by RUN --mount=from=...
+// instructions to its origins, the same as getPackageSources does for content
+// copied to the final stage.
+func getMountPackageSources(
+	storageClient storageclient.Client,
+	cf containerfile.Containerfile,
+	digests map[string]digest.Digest,
+) ([]packageSource, error) {
+	baseToWorkdir, err := getBaseWorkdirs(storageClient, cf)
+	if err != nil {
+		return nil, err
+	}
+
+	builderStageAcc := make(map[int][]string)
+	externalAcc := make(map[string][]string)
+	for _, stage := range cf.Stages {
+		for _, mount := range stage.Mounts {
+			if !isBuildMount(mount) {
+				continue
+			}
+
+			source := path.Join("/", mount.Source)
+			if mount.Pullspec != "" {
+				externalAcc[mount.Pullspec] = append(externalAcc[mount.Pullspec], source)
+			} else if from := cf.ResolveRef(mount.FromRaw, stage.Index); from != nil {
+				traceSource(source, from.Index, cf, builderStageAcc, externalAcc, baseToWorkdir)
+			}
+		}
+	}
+
+	return packageSourcesFromAcc(cf, builderStageAcc, externalAcc, digests, baseToWorkdir)
+}
+
+// scanBuildMounts scans the content mounted by RUN --mount=from=...
+// instructions and returns its packages with BuildTime set. The packages are
+// reported to the event handler as each mounted source is scanned, like those
+// of the final image (see WithEventHandler). Owners of the mounted files
+// aren't recorded (see WithFileOwnership), as they aren't in the final image.
+func (s *Scanner) scanBuildMounts(
+	cf containerfile.Containerfile,
+	digests map[string]digest.Digest,
+	indexDigests map[string]digest.Digest,
+	originals map[string]string,
+) ([]PackageMetadataItem, error) {
+	packageSources, err := getMountPackageSources(s.sclient, cf, digests)
+	if err != nil {
+		return nil, err
+	}
+	if s.pruneUnused {
+		packageSources = pruneUnused(packageSources, unusedStages(cf))
+	}
+	s.logPackageSources(packageSources)
+
+	files := len(s.files)
+	items, err := s.scanPackageSources(packageSources, func(items []PackageMetadataItem) {
+		setIndexDigests(items, indexDigests)
+		setOriginalPullspecs(items, originals)
+		for i := range items {
+			items[i].BuildTime = true
+		}
+	})
+	s.files = s.files[:files]
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,206 @@
+//go:build unit
+
+package capo
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/konflux-ci/capo/internal/testutils"
+	"github.com/konflux-ci/capo/pkg/containerfile"
+	"github.com/konflux-ci/capo/pkg/storageclient"
+)
+
+// Containerfile with RUN --mount=from=... instructions of an image and of a
+// builder stage, e.g.:
+//
+//	FROM docker.io/library/golang:1.22 AS builder
+//	RUN --mount=type=bind,from=docker.io/library/alpine:3.20,src=/etc/apk,dst=/mnt/apk make
+//	FROM docker.io/library/golang:1.22 AS test
+//	RUN --mount=from=builder,src=out,target=/out /out/app --selftest
+//	FROM scratch
+var buildMountsContainerfile = containerfile.Containerfile{Stages: []containerfile.Stage{
+	{
+		Alias:   "builder",
+		Base:    "docker.io/library/golang:1.22",
+		BaseRef: "docker.io/library/golang:1.22",
+		Index:   0,
+		Mounts: []containerfile.Mount{
+			{
+				FromRaw:   "docker.io/library/alpine:3.20",
+				Pullspec:  "docker.io/library/alpine:3.20",
+				MountType: containerfile.MountTypeBind,
+				Source:    "/etc/apk",
+				Target:    "/mnt/apk",
+			},
+			{MountType: containerfile.MountTypeCache, Target: "/root/.cache"},
+		},
+	},
+	{
+		Alias:   "test",
+		Base:    "docker.io/library/golang:1.22",
+		BaseRef: "docker.io/library/golang:1.22",
+		Index:   1,
+		Mounts: []containerfile.Mount{
+			{FromRaw: "builder", MountType: containerfile.MountTypeBind, Source: "out", Target: "/out"},
+		},
+	},
+	{
+		Alias:   "2",
+		Base:    "scratch",
+		BaseRef: "scratch",
+		Index:   2,
+		Kind:    containerfile.StageKindFinal,
+	},
+}}
+
+func TestGetBuildDependencies(t *testing.T) {
+	t.Parallel()
+	digests := map[string]digest.Digest{
+		"docker.io/library/golang:1.22": testDigest("aaa111"),
+		"docker.io/library/alpine:3.20": testDigest("bbb222"),
+	}
+	builderIndex := 0
+	expected := []BuildDependency{
+		{
+			StageAlias: "builder",
+			StageIndex: 0,
+			From:       "docker.io/library/alpine:3.20",
+			Pullspec:   "docker.io/library/alpine@" + string(testDigest("bbb222")),
+			Source:     "/etc/apk",
+			Target:     "/mnt/apk",
+		},
+		{
+			StageAlias:     "test",
+			StageIndex:     1,
+			From:           "builder",
+			FromStageIndex: &builderIndex,
+			Source:         "/out",
+			Target:         "/out",
+		},
+	}
+
+	actual, err := getBuildDependencies(buildMountsContainerfile, digests)
+	if err != nil {
+		t.Fatalf("getBuildDependencies returned error: %v", err)
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("getBuildDependencies() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestGetMountDigests(t *testing.T) {
+	t.Parallel()
+	digests := map[string]digest.Digest{
+		"docker.io/library/golang:1.22": testDigest("aaa111"),
+	}
+	client := testutils.NewTStorageClient(map[string]digest.Digest{
+		"docker.io/library/golang:1.22": testDigest("aaa111"),
+		"docker.io/library/alpine:3.20": testDigest("bbb222"),
+	}, nil)
+
+	if err := getMountDigests(client, buildMountsContainerfile, digests); err != nil {
+		t.Fatalf("getMountDigests returned error: %v", err)
+	}
+	expected := map[string]digest.Digest{
+		"docker.io/library/golang:1.22": testDigest("aaa111"),
+		"docker.io/library/alpine:3.20": testDigest("bbb222"),
+	}
+	if diff := cmp.Diff(expected, digests); diff != "" {
+		t.Errorf("getMountDigests() mismatch (-want +got):\n%s", diff)
+	}
+
+	missing := testutils.NewTStorageClient(nil, nil)
+	err := getMountDigests(missing, buildMountsContainerfile, map[string]digest.Digest{})
+	if !errors.Is(err, ErrPullspecResolve) {
+		t.Errorf("expected error wrapping %v, got: %v", ErrPullspecResolve, err)
+	}
+}
+
+func TestGetMountPackageSources(t *testing.T) {
+	t.Parallel()
+	digests := map[string]digest.Digest{
+		"docker.io/library/golang:1.22": testDigest("aaa111"),
+		"docker.io/library/alpine:3.20": testDigest("bbb222"),
+	}
+	client := testutils.NewTStorageClient(digests, map[string]storageclient.OCIImageConfig{
+		"docker.io/library/golang:1.22": configWithWorkdir("/go"),
+	})
+
+	expected := []packageSource{
+		{
+			kind:         containerfile.StageKindBuilder,
+			index:        0,
+			alias:        "builder",
+			pullspec:     "docker.io/library/golang:1.22",
+			digestBase:   "docker.io/library/golang@" + string(testDigest("aaa111")),
+			sources:      []string{"/out"},
+			cacheTargets: []string{"/root/.cache"},
+		},
+		{
+			kind:       containerfile.StageKindBuilder,
+			index:      1,
+			alias:      "test",
+			pullspec:   "docker.io/library/golang:1.22",
+			digestBase: "docker.io/library/golang@" + string(testDigest("aaa111")),
+		},
+		{
+			kind:       containerfile.StageKindExternal,
+			pullspec:   "docker.io/library/alpine:3.20",
+			digestBase: "docker.io/library/alpine@" + string(testDigest("bbb222")),
+			sources:    []string{"/etc/apk"},
+		},
+	}
+
+	actual, err := getMountPackageSources(client, buildMountsContainerfile, digests)
+	if err != nil {
+		t.Fatalf("getMountPackageSources returned error: %v", err)
+	}
+	diff := cmp.Diff(
+		expected, actual,
+		cmp.AllowUnexported(packageSource{}, packageSourceDescendant{}),
+		cmpopts.SortSlices(func(a, b packageSource) bool {
+			if a.kind != b.kind {
+				return a.kind < b.kind
+			}
+			return a.index < b.index
+		}),
+		cmpopts.EquateEmpty(),
+	)
+	if diff != "" {
+		t.Errorf("getMountPackageSources() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseMountPolicy(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		value       string
+		expected    MountPolicy
+		expectedErr error
+	}{
+		"fail":    {value: "fail", expected: MountPolicyFail},
+		"ignore":  {value: "ignore", expected: MountPolicyIgnore},
+		"report":  {value: "report", expected: MountPolicyReport},
+		"scan":    {value: "scan", expected: MountPolicyScan},
+		"unknown": {value: "skip", expectedErr: ErrInvalidMountPolicy},
+		"empty":   {value: "", expectedErr: ErrInvalidMountPolicy},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			actual, err := ParseMountPolicy(test.value)
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error wrapping %v, got: %v", test.expectedErr, err)
+			}
+			if actual != test.expected {
+				t.Errorf("ParseMountPolicy(%q) = %q, want %q", test.value, actual, test.expected)
+			}
+		})
+	}
+}
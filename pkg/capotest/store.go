@@ -0,0 +1,173 @@
+// Package capotest provides fakes of the dependencies of capo, so code using
+// the containers/storage store can be unit tested without buildah or root.
+package capotest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.podman.io/storage"
+)
+
+// Store is an in-memory fake of the subset of storage.Store capo uses: Lookup,
+// Image, Images, Layer, Diff, MountImage and UnmountImage. Calling any other
+// method panics. Images are mounted at directories set up by the test. Safe
+// for concurrent use.
+type Store struct {
+	storage.Store
+
+	mu sync.Mutex
+	// Images by ID, and IDs of images by name.
+	images map[string]storage.Image
+	names  map[string]string
+	layers map[string]storage.Layer
+	// Uncompressed tar diffs by the IDs of the layers they are between.
+	diffs map[[2]string][]byte
+	// Directories images are mounted at by image ID, and the number of
+	// mounts held.
+	roots  map[string]string
+	mounts map[string]int
+}
+
+// NewStore creates an empty Store.
+func NewStore() *Store {
+	return &Store{
+		images: make(map[string]storage.Image),
+		names:  make(map[string]string),
+		layers: make(map[string]storage.Layer),
+		diffs:  make(map[[2]string][]byte),
+		roots:  make(map[string]string),
+		mounts: make(map[string]int),
+	}
+}
+
+// AddImage adds the image, found by its ID and names, mounted at the root
+// directory. root may be empty if the image isn't mounted by the test.
+func (s *Store) AddImage(image storage.Image, root string) *Store {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.images[image.ID] = image
+	for _, name := range image.Names {
+		s.names[name] = image.ID
+	}
+	if root != "" {
+		s.roots[image.ID] = root
+	}
+	return s
+}
+
+// AddLayer adds the layer. Its parent doesn't have to be added, to fake a
+// broken layer chain.
+func (s *Store) AddLayer(layer storage.Layer) *Store {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.layers[layer.ID] = layer
+	return s
+}
+
+// SetDiff sets the uncompressed tar returned by Diff between the layers.
+func (s *Store) SetDiff(from, to string, diff []byte) *Store {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.diffs[[2]string{from, to}] = diff
+	return s
+}
+
+// Mounts returns the number of mounts of the image currently held.
+func (s *Store) Mounts(id string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mounts[id]
+}
+
+// Lookup returns the ID of the image with the name or ID. A "sha256:" prefix
+// of the ID is ignored, like by containers/storage.
+func (s *Store) Lookup(name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.names[name]; ok {
+		return id, nil
+	}
+	if id := strings.TrimPrefix(name, "sha256:"); s.images[id].ID != "" {
+		return id, nil
+	}
+	return "", fmt.Errorf("%s: %w", name, storage.ErrImageUnknown)
+}
+
+// Image returns the image with the ID or name.
+func (s *Store) Image(id string) (*storage.Image, error) {
+	id, err := s.Lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	image := s.images[id]
+	return &image, nil
+}
+
+// Images returns all images, sorted by ID.
+func (s *Store) Images() ([]storage.Image, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]storage.Image, 0, len(s.images))
+	for _, image := range s.images {
+		res = append(res, image)
+	}
+	slices.SortFunc(res, func(a, b storage.Image) int { return strings.Compare(a.ID, b.ID) })
+	return res, nil
+}
+
+// Layer returns the layer with the ID.
+func (s *Store) Layer(id string) (*storage.Layer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	layer, ok := s.layers[id]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", id, storage.ErrLayerUnknown)
+	}
+	return &layer, nil
+}
+
+// Diff returns the tar set by SetDiff between the layers. The options are
+// ignored, the tar is always uncompressed.
+func (s *Store) Diff(from, to string, _ *storage.DiffOptions) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	diff, ok := s.diffs[[2]string{from, to}]
+	if !ok {
+		return nil, fmt.Errorf("diff from %q to %s: %w", from, to, storage.ErrLayerUnknown)
+	}
+	return io.NopCloser(bytes.NewReader(diff)), nil
+}
+
+// MountImage returns the root directory of the image set by AddImage.
+func (s *Store) MountImage(id string, _ []string, _ string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	root, ok := s.roots[id]
+	if !ok {
+		return "", fmt.Errorf("image %s has no root directory: %w", id, storage.ErrImageUnknown)
+	}
+	s.mounts[id]++
+	return root, nil
+}
+
+// UnmountImage releases a mount of the image, or all of them with force.
+// Returns whether the image is still mounted.
+func (s *Store) UnmountImage(id string, force bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mounts[id] == 0 {
+		return false, fmt.Errorf("image %s is not mounted: %w", id, storage.ErrLayerNotMounted)
+	}
+	s.mounts[id]--
+	if force {
+		s.mounts[id] = 0
+	}
+	return s.mounts[id] > 0, nil
+}
@@ -0,0 +1,105 @@
+//go:build unit
+
+package capotest
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"go.podman.io/storage"
+)
+
+func TestStoreImages(t *testing.T) {
+	t.Parallel()
+	s := NewStore().
+		AddImage(storage.Image{ID: "b2", Names: []string{"quay.io/org/app:latest"}, TopLayer: "l2"}, "/mnt/b2").
+		AddImage(storage.Image{ID: "a1", TopLayer: "l1"}, "")
+
+	for _, name := range []string{"quay.io/org/app:latest", "b2", "sha256:b2"} {
+		id, err := s.Lookup(name)
+		if err != nil || id != "b2" {
+			t.Errorf("Lookup(%q) = %q, %v, expected b2", name, id, err)
+		}
+	}
+	if _, err := s.Lookup("quay.io/org/other:latest"); !errors.Is(err, storage.ErrImageUnknown) {
+		t.Errorf("expected ErrImageUnknown, got %v", err)
+	}
+
+	image, err := s.Image("quay.io/org/app:latest")
+	if err != nil || image.TopLayer != "l2" {
+		t.Errorf("Image() = %+v, %v, expected top layer l2", image, err)
+	}
+
+	images, err := s.Images()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ids := make([]string, 0, len(images))
+	for _, img := range images {
+		ids = append(ids, img.ID)
+	}
+	if diff := cmp.Diff([]string{"a1", "b2"}, ids); diff != "" {
+		t.Errorf("Images() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestStoreLayers(t *testing.T) {
+	t.Parallel()
+	s := NewStore().
+		AddLayer(storage.Layer{ID: "base"}).
+		AddLayer(storage.Layer{ID: "top", Parent: "base", UncompressedSize: 42}).
+		SetDiff("base", "top", []byte("tar"))
+
+	layer, err := s.Layer("top")
+	if err != nil || layer.Parent != "base" || layer.UncompressedSize != 42 {
+		t.Errorf("Layer() = %+v, %v", layer, err)
+	}
+	if _, err := s.Layer("missing"); !errors.Is(err, storage.ErrLayerUnknown) {
+		t.Errorf("expected ErrLayerUnknown, got %v", err)
+	}
+
+	diff, err := s.Diff("base", "top", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	content, err := io.ReadAll(diff)
+	if err != nil || string(content) != "tar" {
+		t.Errorf("Diff() content = %q, %v", content, err)
+	}
+	if _, err := s.Diff("", "top", nil); err == nil {
+		t.Error("expected an error for a diff not set")
+	}
+}
+
+func TestStoreMounts(t *testing.T) {
+	t.Parallel()
+	s := NewStore().
+		AddImage(storage.Image{ID: "mounted"}, "/mnt/mounted").
+		AddImage(storage.Image{ID: "unmountable"}, "")
+
+	for range 2 {
+		root, err := s.MountImage("mounted", nil, "")
+		if err != nil || root != "/mnt/mounted" {
+			t.Errorf("MountImage() = %q, %v", root, err)
+		}
+	}
+	if _, err := s.MountImage("unmountable", nil, ""); err == nil {
+		t.Error("expected an error mounting an image without a root directory")
+	}
+
+	if still, err := s.UnmountImage("mounted", false); err != nil || !still {
+		t.Errorf("UnmountImage() = %v, %v, expected still mounted", still, err)
+	}
+	if still, err := s.UnmountImage("mounted", true); err != nil || still {
+		t.Errorf("UnmountImage(force) = %v, %v, expected unmounted", still, err)
+	}
+	if s.Mounts("mounted") != 0 {
+		t.Errorf("expected no mounts, got %d", s.Mounts("mounted"))
+	}
+	if _, err := s.UnmountImage("mounted", false); err == nil {
+		t.Error("expected an error unmounting an image not mounted")
+	}
+}
@@ -0,0 +1,151 @@
+// Analysis of the build args instructions of stages depend on.
+
+package containerfile
+
+import (
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/openshift/imagebuilder"
+	"github.com/openshift/imagebuilder/dockerfile/parser"
+)
+
+// varRefPattern matches references of variables in a word ($NAME or
+// ${NAME...}), including references nested in the default of another one.
+var varRefPattern = regexp.MustCompile(`\\?\$\{?([A-Za-z_][A-Za-z0-9_]*)`)
+
+// varRefs returns the names of the variables referenced in the word, except
+// escaped references (\$NAME).
+func varRefs(word string) []string {
+	res := make([]string, 0)
+	for _, m := range varRefPattern.FindAllStringSubmatch(word, -1) {
+		if !strings.HasPrefix(m[0], `\`) {
+			res = append(res, m[1])
+		}
+	}
+	return res
+}
+
+// argDeps maps variables in scope to the build args their values depend on.
+type argDeps map[string][]string
+
+// resolve returns the build args the words depend on, sorted.
+func (d argDeps) resolve(words ...string) []string {
+	res := make([]string, 0)
+	for _, word := range words {
+		for _, ref := range varRefs(word) {
+			res = append(res, d[ref]...)
+		}
+	}
+	slices.Sort(res)
+	return slices.Compact(res)
+}
+
+// declareArgs records the build args declared by an ARG instruction, which
+// depend on themselves and on the variables in their defaults. An ARG without
+// a default keeps the value, and so the dependencies, it already had.
+func (d argDeps) declareArgs(node *parser.Node) {
+	for n := node.Next; n != nil; n = n.Next {
+		name, def, hasDefault := strings.Cut(n.Value, "=")
+		deps := []string{name}
+		if hasDefault {
+			deps = append(deps, d.resolve(def)...)
+		} else {
+			deps = append(deps, d[name]...)
+		}
+		slices.Sort(deps)
+		d[name] = slices.Compact(deps)
+	}
+}
+
+// declareEnv records the variables set by an ENV instruction, which depend
+// on the variables in their values. All values are expanded with the
+// variables in scope before the instruction, the same as by buildah.
+func (d argDeps) declareEnv(node *parser.Node) {
+	set := make(argDeps)
+	for curr := node.Next; curr != nil && curr.Next != nil; curr = curr.Next.Next {
+		set[curr.Value] = d.resolve(curr.Next.Value)
+	}
+	maps.Copy(d, set)
+}
+
+// words returns the flags and arguments of an instruction.
+func words(node *parser.Node) []string {
+	res := slices.Clone(node.Flags)
+	for n := node.Next; n != nil; n = n.Next {
+		res = append(res, n.Value)
+	}
+	return res
+}
+
+// headingArgDeps returns the dependencies of the variables in scope of all
+// stages: the passed build args and the ARGs before the first FROM.
+func headingArgDeps(node *parser.Node, args map[string]string) argDeps {
+	deps := make(argDeps)
+	for name := range args {
+		deps[name] = []string{name}
+	}
+	for _, child := range node.Children {
+		if child.Value == "from" {
+			break
+		}
+		if child.Value == "arg" {
+			deps.declareArgs(child)
+		}
+	}
+	return deps
+}
+
+// stageArgs are the build args instructions of a stage depend on.
+type stageArgs struct {
+	// Build args the FROM pullspec depends on.
+	pullspec []string
+	// Build args COPY, ADD, WORKDIR and RUN --mount instructions depend on.
+	copies []string
+	// Build args any instruction depends on or declares.
+	used []string
+}
+
+// analyzeStageArgs returns the build args the instructions of the stage
+// depend on, with the passed heading dependencies in scope.
+func analyzeStageArgs(s imagebuilder.Stage, heading argDeps) stageArgs {
+	deps := maps.Clone(heading)
+	res := stageArgs{}
+	if len(s.Node.Children) > 0 && s.Node.Children[0].Next != nil {
+		res.pullspec = heading.resolve(s.Node.Children[0].Next.Value)
+	}
+	used := slices.Clone(res.pullspec)
+
+	for _, child := range s.Node.Children {
+		switch child.Value {
+		case "copy", "add", "workdir":
+			res.copies = append(res.copies, deps.resolve(words(child)...)...)
+		case "run":
+			for _, fl := range child.Flags {
+				if strings.HasPrefix(fl, "--mount=") {
+					res.copies = append(res.copies, deps.resolve(fl)...)
+				}
+			}
+		}
+		used = append(used, deps.resolve(words(child)...)...)
+
+		switch child.Value {
+		case "arg":
+			deps.declareArgs(child)
+			for n := child.Next; n != nil; n = n.Next {
+				name, _, _ := strings.Cut(n.Value, "=")
+				used = append(used, name)
+			}
+		case "env":
+			deps.declareEnv(child)
+		}
+	}
+
+	slices.Sort(res.copies)
+	res.copies = slices.Compact(res.copies)
+	slices.Sort(used)
+	res.used = slices.Compact(used)
+	return res
+}
@@ -3,22 +3,48 @@
 package containerfile
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"maps"
-	"path/filepath"
+	"path"
+	"runtime"
 	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/openshift/imagebuilder"
 	"github.com/openshift/imagebuilder/dockerfile/parser"
+	"go.podman.io/image/v5/docker/reference"
 )
 
-// FinalStage is the sentinel alias assigned to the last stage in a parsed
-// Containerfile, distinguishing it from named builder stages.
-var FinalStage string = ""
+// StageKind classifies a stage (or a package source) by its role in the build.
+type StageKind int
+
+const (
+	// StageKindBuilder indicates a builder stage, any stage before the final.
+	StageKindBuilder StageKind = iota
+	// StageKindFinal indicates the final (output) stage of the build.
+	StageKindFinal
+	// StageKindExternal indicates an external image referenced directly by
+	// COPY --from. Parsed stages never have this kind, it is used for package
+	// sources that are not stages of the Containerfile.
+	StageKindExternal
+)
+
+func (k StageKind) String() string {
+	switch k {
+	case StageKindBuilder:
+		return "builder"
+	case StageKindFinal:
+		return "final"
+	case StageKindExternal:
+		return "external"
+	default:
+		return fmt.Sprintf("StageKind(%d)", int(k))
+	}
+}
 
 // CopyType classifies a COPY command by its source origin.
 type CopyType int
@@ -41,7 +67,7 @@ type Copy struct {
 	// Destination in the command.
 	Destination string
 	// Alias of the stage the command is copying from when Copy.Type==CopyTypeBuilder
-	// or a pullspec when Copy.Type==CopyTypeExternal
+	// or a normalized pullspec when Copy.Type==CopyTypeExternal
 	From string
 	// Type of the COPY. Specifies whether it is a copy from a builder stage
 	// or an external image directly.
@@ -54,6 +80,33 @@ type Copy struct {
 	// If it's relative, it's always relative to the base working directory in
 	// the stage the COPY command appeared in.
 	Workdir string
+
+	// One-based line of the instruction in the containerfile. Zero if
+	// unknown.
+	Line int
+
+	// Whether the instruction has the --link flag. Buildah commits the
+	// content of linked copies as layers independent of the previous ones,
+	// which may re-create the layers of the base image with other IDs.
+	Link bool
+}
+
+// RemoteAdd is a source of an ADD instruction fetched from the network (a URL
+// or a git repository), e.g. "ADD --checksum=sha256:... https://... /opt/".
+// Buildah doesn't extract fetched archives, the content is saved as is.
+type RemoteAdd struct {
+	// URL of the source, after expansion of build args.
+	URL string
+	// Value of the --checksum flag (e.g. "sha256:..."), which buildah verifies
+	// the fetched content with. Empty if the flag isn't passed.
+	Checksum string
+	// Destination of the instruction, with the same semantics as
+	// Copy.Destination and Copy.Workdir.
+	Destination string
+	Workdir     string
+	// One-based line of the instruction in the containerfile. Zero if
+	// unknown.
+	Line int
 }
 
 // A mount reference from a RUN --mount instruction in a Containerfile stage.
@@ -61,11 +114,21 @@ type Mount struct {
 	// Value of the --from field in the RUN command for bind and cache mount types.
 	FromRaw string
 	// Pullspec of an image used in the --from field, if the value is a reference
-	// to an image, empty otherwise. Note that the pullspec may be incomplete,
-	// it is directly copied from the FROM instruction in the stage where relevant.
+	// to an image, empty otherwise. Normalized the same as stage bases.
 	Pullspec string
 	// Type of the mount as specified in the RUN --mount instruction.
 	MountType MountType
+	// Path in the --from stage or image which is mounted (the source or src
+	// option), as written. Empty if not specified, which is its root.
+	Source string
+	// Target path of the mount (the target, dst or destination option). For
+	// secret and ssh mounts without a target, the default target of buildah
+	// (/run/secrets/<id> and /run/buildkit/ssh_agent.<n>), otherwise empty if
+	// not specified.
+	Target string
+	// Current working directory for resolving a relative Target, with the
+	// same semantics as Copy.Workdir.
+	Workdir string
 }
 
 // MountType classifies a RUN --mount instruction by its type.
@@ -86,25 +149,67 @@ type Containerfile struct {
 	// Stages in the containerfile, in order. The last stage is the final
 	// (output) stage.
 	Stages []Stage
+	// Build args passed to Parse which no stage declares by an ARG
+	// instruction or depends on in any instruction (see WithArgs), sorted.
+	// Nil if there are none.
+	UnusedArgs []string
 }
 
 // Return a stage by its name (alias) or numerical (index) reference. Return
-// nil if it was not found.
+// nil if it was not found. If more than one stage has the alias, the last one
+// is returned.
 func (c Containerfile) StageByRef(ref string) *Stage {
+	return c.ResolveRef(ref, len(c.Stages))
+}
+
+// Return the stage that a reference made in the stage with the passed index
+// points to, by numerical (index) reference or by alias. An alias resolves to
+// the last stage with the alias defined before the referencing stage, so when
+// an alias is defined more than once, the later definition wins for all
+// references following it. Return nil if it was not found.
+func (c Containerfile) ResolveRef(ref string, index int) *Stage {
 	i, err := strconv.Atoi(ref)
 	if err == nil {
 		return c.StageByIndex(i)
 	}
 
-	for _, st := range c.Stages {
-		if st.Alias == ref {
-			return &st
+	for i := min(index, len(c.Stages)) - 1; i >= 0; i-- {
+		if c.Stages[i].Alias == ref {
+			return &c.Stages[i]
 		}
 	}
 
 	return nil
 }
 
+// DuplicateAlias is a stage alias defined by more than one stage.
+type DuplicateAlias struct {
+	Alias string
+	// Indexes of the stages with the alias, in order.
+	Indexes []int
+}
+
+// Return all aliases defined by more than one stage, ordered by the index of
+// their first definition.
+func (c Containerfile) DuplicateAliases() []DuplicateAlias {
+	indexes := make(map[string][]int)
+	order := make([]string, 0)
+	for _, st := range c.Stages {
+		if _, ok := indexes[st.Alias]; !ok {
+			order = append(order, st.Alias)
+		}
+		indexes[st.Alias] = append(indexes[st.Alias], st.Index)
+	}
+
+	res := make([]DuplicateAlias, 0)
+	for _, alias := range order {
+		if len(indexes[alias]) > 1 {
+			res = append(res, DuplicateAlias{Alias: alias, Indexes: indexes[alias]})
+		}
+	}
+	return res
+}
+
 // Return a stage by its index or nil if the index is out of bounds.
 func (c Containerfile) StageByIndex(index int) *Stage {
 	if index >= 0 && index < len(c.Stages) {
@@ -121,118 +226,335 @@ func (c Containerfile) BuilderStages() []Stage {
 	return c.Stages[:len(c.Stages)-1]
 }
 
+// Return the final stage or nil if the containerfile has no stages.
+func (c Containerfile) FinalStage() *Stage {
+	if len(c.Stages) == 0 {
+		return nil
+	}
+	return &c.Stages[len(c.Stages)-1]
+}
+
 // A builder or final stage in a Containerfile.
 type Stage struct {
-	// Alias of the builder stage or equal to FinalStage if final.
+	// Alias of the stage as referenced by other stages. Unnamed stages are
+	// aliased by their index, the same as in buildah.
 	Alias string
 	// Base image pullspec for this stage. For chained stages (FROM parent AS child),
 	// this is resolved through the chain to the ultimate builder base image pullspec.
+	// Pullspecs are normalized, e.g. "fedora" is "docker.io/library/fedora:latest".
 	Base string
-	// Raw FROM reference as it appears in the Containerfile. Can be a pullspec
-	// or a stage alias. For non-chained stages, BaseRef == Base.
+	// FROM reference of the stage. Can be a normalized pullspec or a stage
+	// alias. For non-chained stages, BaseRef == Base.
 	BaseRef string
-	// Zero-based index of this builder stage. Final stage has Index -1.
+	// Platform of the FROM --platform flag after expansion of build args,
+	// e.g. "linux/amd64" for --platform=$BUILDPLATFORM, with which buildah
+	// pulls the base image from a manifest list. Empty if the flag isn't
+	// passed, then the base image is of the platform of the build.
+	Platform string
+	// Zero-based index of this stage in the containerfile (after applying
+	// the build target).
 	Index int
+	// Kind of the stage, StageKindFinal for the last stage and
+	// StageKindBuilder for all others.
+	Kind StageKind
 	// Builder copies in this stage in order (top to bottom in the containerfile).
 	Copies []Copy
 	// Mount references in this stage.
 	Mounts []Mount
+	// Moves of files by simple RUN mv and cp commands in this stage, in
+	// order. Nil if there are none.
+	Moves []Move
+	// ADD instructions of local archives from the build context in this stage,
+	// in order. Buildah extracts such archives at the destination, so the
+	// packages in them can't be traced to an image. One per archive source,
+	// with Type CopyTypeContext and an empty From.
+	ArchiveAdds []Copy
+	// Sources of ADD instructions fetched from the network in this stage, in
+	// order. Nil if there are none.
+	RemoteAdds []RemoteAdd
+	// COPY instructions from the build context (without --from) in this
+	// stage, in order. Type is CopyTypeContext, From is empty and Sources
+	// are as written, relative to the root of the build context.
+	ContextCopies []Copy
 	// Labels set via LABEL instructions in this stage.
 	Labels map[string]string
+	// Effective ARG and ENV values at the end of the stage, as used for
+	// expanding its instructions: heading ARGs, build args, environment
+	// variables passed to the build and ENV instructions of the stage.
+	Env map[string]string
+	// Build args the base image pullspec depends on, directly or through the
+	// defaults of other ARGs, sorted. Nil if none.
+	PullspecArgs []string
+	// Build args the sources and destinations of COPY and ADD instructions
+	// and RUN --mount options of the stage depend on, directly or through
+	// WORKDIR, ARG defaults and ENV values, sorted. Nil if none.
+	CopyArgs []string
+	// PullspecArgs not passed as build args, so the pullspec depends on their
+	// defaults in the containerfile. Nil if none.
+	UnpinnedArgs []string
 }
 
-// BuildOptions controls how a Containerfile is parsed.
-type BuildOptions struct {
+// parseOpts controls how a Containerfile is parsed, set by ParseOption.
+type parseOpts struct {
 	// Build arguments passed to buildah for the build.
-	// Environment variable resolution for bare KEY args (without =) must be
-	// done before passing args here (see buildvars.ParseAndMerge).
-	Args map[string]string
-
+	args map[string]string
 	// Environment variables passed to the build.
-	EnvVars map[string]string
-
+	envVars map[string]string
 	// Target stage of the buildah build
-	Target string
-
+	target string
 	// Build contexts passed to the build.
-	BuildContexts map[string]string
+	buildContexts map[string]string
+	// Platform-specific built-in args (TARGETOS, TARGETARCH, ...) of the
+	// build platform, if set.
+	platformArgs map[string]string
+	// Fail on build args not declared by any ARG instruction.
+	strictArgs bool
+}
+
+// ParseOption configures Parse.
+type ParseOption func(*parseOpts)
+
+// Set the build arguments passed to buildah for the build.
+// Environment variable resolution for bare KEY args (without =) must be done
+// before passing args here (see buildvars.ParseAndMerge).
+func WithArgs(args map[string]string) ParseOption {
+	return func(opts *parseOpts) {
+		opts.args = args
+	}
+}
+
+// Set the environment variables passed to the build.
+func WithEnvVars(envVars map[string]string) ParseOption {
+	return func(opts *parseOpts) {
+		opts.envVars = envVars
+	}
+}
+
+// Set the target stage of the build. If unset, all stages are parsed.
+func WithTarget(target string) ParseOption {
+	return func(opts *parseOpts) {
+		opts.target = target
+	}
+}
+
+// Set the named build contexts passed to the build.
+func WithBuildContexts(buildContexts map[string]string) ParseOption {
+	return func(opts *parseOpts) {
+		opts.buildContexts = buildContexts
+	}
 }
 
-// ErrTargetNotFound is returned when the target stage specified in
-// BuildOptions does not exist in the Containerfile.
+// Set the platform the build is for (buildah --platform), which determines
+// the TARGETPLATFORM, TARGETOS, TARGETARCH and TARGETVARIANT built-in args.
+// If unset, they are of the platform capo runs on. An empty platformOS
+// keeps the default.
+func WithPlatform(platformOS, arch, variant string) ParseOption {
+	return func(opts *parseOpts) {
+		if platformOS == "" {
+			opts.platformArgs = nil
+			return
+		}
+		platform := platformOS + "/" + arch
+		if variant != "" {
+			platform += "/" + variant
+		}
+		opts.platformArgs = map[string]string{
+			"TARGETPLATFORM": platform,
+			"TARGETOS":       platformOS,
+			"TARGETARCH":     arch,
+			"TARGETVARIANT":  variant,
+		}
+	}
+}
+
+// Make Parse fail with ErrUndeclaredArgs on build args not declared by any
+// ARG instruction in the Containerfile, which buildah only warns about. Such
+// args are usually a typo and the Containerfile is then parsed with defaults
+// different from the build.
+func WithStrictArgs(strictArgs bool) ParseOption {
+	return func(opts *parseOpts) {
+		opts.strictArgs = strictArgs
+	}
+}
+
+// ErrTargetNotFound is returned with ErrParse when the target stage set by
+// WithTarget does not exist in the Containerfile.
 var ErrTargetNotFound = errors.New("specified target stage was not found in the containerfile")
 
 // ErrParse is returned when the Containerfile cannot be parsed.
 var ErrParse = errors.New("error while parsing containerfile")
 
-// Parse reads a Containerfile from the passed reader and uses the passed
-// BuildOptions to parse the Containerfile into stages.
-func Parse(reader io.Reader, opts BuildOptions) (Containerfile, error) {
+// ErrUndeclaredArgs is returned with ErrParse and WithStrictArgs when build
+// args are not declared by any ARG instruction in the Containerfile.
+var ErrUndeclaredArgs = errors.New("build args not declared in the containerfile")
+
+// Build args buildah accepts without an ARG instruction.
+// See https://docs.docker.com/reference/dockerfile/#predefined-args
+var predefinedArgs = []string{
+	"HTTP_PROXY", "http_proxy", "HTTPS_PROXY", "https_proxy", "FTP_PROXY", "ftp_proxy",
+	"NO_PROXY", "no_proxy", "ALL_PROXY", "all_proxy",
+}
+
+// Parse reads a Containerfile from the passed reader and parses it into
+// stages, configured by the passed options. Paths are container paths with
+// forward slashes, independent of the OS capo runs on: paths of Windows
+// Containerfiles (e.g. "C:\app", or "app\bin" with the backtick escape
+// character) are parsed as "/app" and "app/bin".
+func Parse(reader io.Reader, options ...ParseOption) (Containerfile, error) {
+	opts := parseOpts{}
+	for _, o := range options {
+		o(&opts)
+	}
+
 	res := make([]Stage, 0)
 
-	node, err := imagebuilder.ParseDockerfile(reader)
+	content, err := io.ReadAll(reader)
 	if err != nil {
 		return Containerfile{}, fmt.Errorf("%w: %w", ErrParse, err)
 	}
+	node, err := imagebuilder.ParseDockerfile(bytes.NewReader(content))
+	if err != nil {
+		return Containerfile{}, fmt.Errorf("%w: %w", ErrParse, err)
+	}
+	// paths are container paths with forward slashes, also in Windows
+	// Containerfiles and whatever the OS capo runs on
+	normalizeWindowsPaths(node, backtickEscape(content))
 
-	// TODO: At this stage, Buildah code takes into account OS and ARCH CLI args
-	// and overrides the built-in TARGETOS and TARGETARCH args (and others).
-	// The imagebuilder automatically injects these args when evaluating args.
-	// In Konflux build, target and platform overriding is currently not supported
-	// but I'm keeping this here as a guideline.
+	if opts.strictArgs {
+		if undeclared := undeclaredArgs(node, opts.args); len(undeclared) > 0 {
+			return Containerfile{}, fmt.Errorf("%w: %w: %s", ErrParse, ErrUndeclaredArgs, strings.Join(undeclared, ", "))
+		}
+	}
+
+	// The same as buildah, the platform overrides the built-in TARGETOS and
+	// TARGETARCH args (and others), which imagebuilder otherwise injects for
+	// the platform capo runs on.
 	// https://github.com/containers/buildah/blob/main/imagebuildah/build.go#L431
+	args := make(map[string]string, len(opts.args)+len(opts.platformArgs))
+	maps.Copy(args, opts.args)
+	maps.Copy(args, opts.platformArgs)
 
-	builder := imagebuilder.NewBuilder(opts.Args)
+	builder := imagebuilder.NewBuilder(args)
 	rawStages, err := imagebuilder.NewStages(node, builder)
 	if err != nil {
 		return Containerfile{}, fmt.Errorf("%w: %w", ErrParse, err)
 	}
 
-	if opts.Target != "" {
-		stagesTargeted, ok := rawStages.ThroughTarget(opts.Target)
+	if opts.target != "" {
+		stagesTargeted, ok := rawStages.ThroughTarget(opts.target)
 		if !ok {
-			return Containerfile{}, fmt.Errorf("%w: %s", ErrTargetNotFound, opts.Target)
+			return Containerfile{}, fmt.Errorf("%w: %w: %s", ErrParse, ErrTargetNotFound, opts.target)
 		}
 		rawStages = stagesTargeted
 	}
 
-	pullspecs, err := resolvePullspecs(rawStages)
+	pullspecs, platforms, err := resolvePullspecs(rawStages)
 	if err != nil {
 		return Containerfile{}, err
 	}
+	headingDeps := headingArgDeps(node, opts.args)
+	usedArgs := make(map[string]bool)
 	stageNames := make([]string, 0)
 	// maps stage alias to root base pullspec (resolved through chain)
 	aliasToBase := make(map[string]string)
 
 	for index, s := range rawStages {
-		stageNames = append(stageNames, s.Name)
-
+		// Stages without AS get their index as a synthetic alias, the same
+		// identity buildah uses for them in COPY --from references and in the
+		// io.buildah.stage.name label. imagebuilder already names them so,
+		// this only guards against collapsing unnamed stages onto one alias.
 		alias := s.Name
-		stageIndex := index
+		if alias == "" {
+			alias = strconv.Itoa(index)
+		}
+
+		kind := StageKindBuilder
 		if index == len(rawStages)-1 {
-			alias = FinalStage
-			stageIndex = -1
+			kind = StageKindFinal
 		}
 
+		// resolve chained stages: if the FROM value, after ARG expansion
+		// (e.g. ARG BASE=builder + FROM ${BASE}), refers to a previous stage by
+		// name or index, use its already-resolved root base pullspec
 		baseRef := pullspecs[index]
 		base := baseRef
-		// resolve chained stages: if baseRef is an alias of a previous stage,
-		// use its already-resolved root base pullspec
-		if resolvedBase, isChained := aliasToBase[baseRef]; isChained {
-			base = resolvedBase
+		if isStageRef(baseRef, stageNames) {
+			base = aliasToBase[stageAlias(baseRef, stageNames)]
+		} else {
+			baseRef = NormalizePullspec(baseRef)
+			base = baseRef
 		}
 		aliasToBase[alias] = base
+		stageNames = append(stageNames, alias)
 
-		contextNames := slices.Collect(maps.Keys(opts.BuildContexts))
-		stage, err := parseStage(s, alias, base, baseRef, stageIndex, stageNames, opts.EnvVars, contextNames)
+		contextNames := slices.Collect(maps.Keys(opts.buildContexts))
+		stage, err := parseStage(s, alias, base, baseRef, index, kind, stageNames, opts.envVars, contextNames)
 		if err != nil {
 			return Containerfile{Stages: res}, err
 		}
+		stage.Platform = platforms[index]
+
+		stageArgs := analyzeStageArgs(s, headingDeps)
+		stage.PullspecArgs = nilIfEmpty(stageArgs.pullspec)
+		stage.CopyArgs = nilIfEmpty(stageArgs.copies)
+		stage.UnpinnedArgs = nilIfEmpty(slices.DeleteFunc(slices.Clone(stageArgs.pullspec), func(name string) bool {
+			_, passed := opts.args[name]
+			return passed
+		}))
+		for _, name := range stageArgs.used {
+			usedArgs[name] = true
+		}
 
 		res = append(res, stage)
 	}
 
-	return Containerfile{Stages: res}, nil
+	return Containerfile{Stages: res, UnusedArgs: unusedArgs(opts.args, usedArgs)}, nil
+}
+
+// undeclaredArgs returns the names of the passed build args which are not
+// declared by any ARG instruction in the Containerfile AST and are not
+// predefined, sorted.
+func undeclaredArgs(node *parser.Node, args map[string]string) []string {
+	declared := make(map[string]bool)
+	for _, child := range node.Children {
+		if child.Value != "arg" {
+			continue
+		}
+		for n := child.Next; n != nil; n = n.Next {
+			name, _, _ := strings.Cut(n.Value, "=")
+			declared[name] = true
+		}
+	}
+
+	res := make([]string, 0)
+	for name := range args {
+		if !declared[name] && !slices.Contains(predefinedArgs, name) {
+			res = append(res, name)
+		}
+	}
+	slices.Sort(res)
+	return res
+}
+
+// unusedArgs returns the names of the passed build args which are not used
+// and not predefined, sorted. Nil if there are none.
+func unusedArgs(args map[string]string, used map[string]bool) []string {
+	var res []string
+	for name := range args {
+		if !used[name] && !slices.Contains(predefinedArgs, name) {
+			res = append(res, name)
+		}
+	}
+	slices.Sort(res)
+	return res
+}
+
+// nilIfEmpty returns s, or nil if it is empty.
+func nilIfEmpty(s []string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+	return s
 }
 
 // argsMapToSlice returns the contents of a map[string]string as a slice of keys
@@ -245,24 +567,108 @@ func argsMapToSlice(m map[string]string) []string {
 	return s
 }
 
-// resolvePullspecs returns the base image pullspec for each stage, in order.
-func resolvePullspecs(stages []imagebuilder.Stage) ([]string, error) {
+// Prefixes of transports which can precede an image reference in buildah.
+// References with a transport are not normalized, as the part after the prefix
+// is not always an image name (e.g. a path for oci-archive:).
+// See https://github.com/containers/image/blob/main/docs/containers-transports.5.md
+var transportPrefixes = []string{
+	"docker://", "docker-daemon:", "docker-archive:", "oci-archive:", "oci:", "dir:", "containers-storage:",
+}
+
+// NormalizePullspec returns the canonical form of an image pullspec, so an
+// image written differently in the Containerfile (e.g. "fedora" and
+// "docker.io/library/fedora:latest") is treated as a single origin. Short
+// names are expanded to docker.io (and docker.io/library for single-component
+// names), the "latest" tag is added to references without a tag or digest and
+// the registry is lowercased.
+// Scratch, references with a transport and values which are not valid image
+// references are returned unchanged.
+func NormalizePullspec(pullspec string) string {
+	if pullspec == "scratch" {
+		return pullspec
+	}
+	for _, prefix := range transportPrefixes {
+		if strings.HasPrefix(pullspec, prefix) {
+			return pullspec
+		}
+	}
+
+	named, err := reference.ParseNormalizedNamed(pullspec)
+	if err != nil {
+		return pullspec
+	}
+	named = reference.TagNameOnly(named)
+
+	normalized := named.String()
+	domain := reference.Domain(named)
+	return strings.ToLower(domain) + normalized[len(domain):]
+}
+
+// resolvePullspecs returns the base image pullspec and the value of the
+// --platform flag (empty if not passed) of FROM for each stage, in order.
+func resolvePullspecs(stages []imagebuilder.Stage) ([]string, []string, error) {
 	res := make([]string, 0, len(stages))
+	platforms := make([]string, 0, len(stages))
 
 	for _, s := range stages {
 		headingEnv := argsMapToSlice(s.Builder.HeadingArgs)
 		userEnv := argsMapToSlice(s.Builder.Args)
 		env := append(headingEnv, userEnv...)
 
+		if len(s.Node.Children) == 0 || s.Node.Children[0].Next == nil {
+			return nil, nil, fmt.Errorf("%w: FROM requires a base image", ErrParse)
+		}
 		fromNode := s.Node.Children[0]
 		pullspec, err := imagebuilder.ProcessWord(fromNode.Next.Value, env)
 		if err != nil {
-			return nil, fmt.Errorf("%w: %w", ErrParse, err)
+			return nil, nil, fmt.Errorf("%w: %w", ErrParse, err)
 		}
 		res = append(res, pullspec)
+
+		platformEnv := buildPlatformArgs()
+		maps.Copy(platformEnv, s.Builder.HeadingArgs)
+		maps.Copy(platformEnv, s.Builder.Args)
+		platform, err := fromPlatform(fromNode, argsMapToSlice(platformEnv))
+		if err != nil {
+			return nil, nil, err
+		}
+		platforms = append(platforms, platform)
 	}
 
-	return res, nil
+	return res, platforms, nil
+}
+
+// fromPlatform returns the value of the last --platform flag of the FROM
+// instruction, expanded with the passed env, or an empty string if none was
+// passed. Returns ErrParse for --platform without a value.
+func fromPlatform(node *parser.Node, env []string) (string, error) {
+	platform := ""
+	for _, raw := range node.Flags {
+		fl, err := imagebuilder.ProcessWord(raw, env)
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ErrParse, err)
+		}
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(fl, "--"), "=")
+		if name != "platform" {
+			continue
+		}
+		if !hasValue || value == "" {
+			return "", fmt.Errorf("%w: missing value of flag %q in %s", ErrParse, fl, node.Original)
+		}
+		platform = value
+	}
+	return platform, nil
+}
+
+// buildPlatformArgs returns the built-in BUILDPLATFORM, BUILDOS and BUILDARCH
+// args of the platform capo runs on, which buildah sets to the platform of
+// the build host. Build args override them.
+func buildPlatformArgs() map[string]string {
+	return map[string]string{
+		"BUILDPLATFORM": runtime.GOOS + "/" + runtime.GOARCH,
+		"BUILDOS":       runtime.GOOS,
+		"BUILDARCH":     runtime.GOARCH,
+	}
 }
 
 // parseStage parses the AST for the passed imagebuilder.Stage and returns a
@@ -275,12 +681,17 @@ func parseStage(
 	s imagebuilder.Stage,
 	alias, base, baseRef string,
 	index int,
+	kind StageKind,
 	stageNames []string,
 	envVars map[string]string,
 	contextNames []string,
 ) (Stage, error) {
 	copies := make([]Copy, 0)
+	archiveAdds := make([]Copy, 0)
+	var remoteAdds []RemoteAdd
+	contextCopies := make([]Copy, 0)
 	mounts := make([]Mount, 0)
+	var moves []Move
 	labels := make(map[string]string)
 	workdir := ""
 	// populate ENV, keep a map for keeping track of overrides
@@ -295,14 +706,17 @@ func parseStage(
 	for _, child := range s.Node.Children {
 		switch child.Value {
 		case "workdir":
+			if child.Next == nil {
+				return Stage{}, fmt.Errorf("%w: WORKDIR requires a path", ErrParse)
+			}
 			newWorkdir, err := imagebuilder.ProcessWord(child.Next.Value, env)
 			if err != nil {
 				return Stage{}, fmt.Errorf("%w: %w", ErrParse, err)
 			}
-			if filepath.IsAbs(newWorkdir) {
+			if path.IsAbs(newWorkdir) {
 				workdir = newWorkdir
 			} else {
-				workdir = filepath.Join(workdir, newWorkdir)
+				workdir = path.Join(workdir, newWorkdir)
 			}
 
 		case "copy":
@@ -313,14 +727,37 @@ func parseStage(
 
 			if cp != nil {
 				copies = append(copies, *cp)
+				continue
+			}
+
+			contextCopy, err := parseContextCopy(child, workdir, env)
+			if err != nil {
+				return Stage{}, err
+			}
+			if contextCopy != nil {
+				contextCopies = append(contextCopies, *contextCopy)
+			}
+
+		case "add":
+			adds, err := parseArchiveAdds(child, workdir, env)
+			if err != nil {
+				return Stage{}, err
+			}
+			archiveAdds = append(archiveAdds, adds...)
+
+			fetched, err := parseRemoteAdds(child, workdir, env)
+			if err != nil {
+				return Stage{}, err
 			}
+			remoteAdds = append(remoteAdds, fetched...)
 
 		case "run":
-			runMounts, err := parseMounts(child, env, stageNames)
+			runMounts, err := parseMounts(child, workdir, env, stageNames)
 			if err != nil {
 				return Stage{}, err
 			}
 			mounts = append(mounts, runMounts...)
+			moves = append(moves, parseMoves(child, workdir, env)...)
 
 		case "label":
 			parsed, err := parseLabels(child, env)
@@ -348,14 +785,24 @@ func parseStage(
 		Base:    base,
 		BaseRef: baseRef,
 		Index:   index,
+		Kind:    kind,
 		Copies:  copies,
 		Mounts:  mounts,
+		Moves:   moves,
 		Labels:  labels,
+
+		ArchiveAdds:   archiveAdds,
+		RemoteAdds:    remoteAdds,
+		ContextCopies: contextCopies,
+		Env:           envMap,
 	}, nil
 }
 
 // isStageRef returns true if ref matches a known stage, either by name or by
-// numeric index.
+// numeric index. Stage names take precedence over image names, as in buildah
+// and Docker: a ref equal to the name of a previous stage is the stage even if
+// an image has the same name (e.g. "helm" after FROM fedora AS helm), any
+// other ref is an image (e.g. "helm" before it, or docker.io/alpine/helm).
 func isStageRef(ref string, stageNames []string) bool {
 	if slices.Contains(stageNames, ref) {
 		return true
@@ -366,11 +813,23 @@ func isStageRef(ref string, stageNames []string) bool {
 	return false
 }
 
+// stageAlias returns the alias of the stage ref refers to, by name or by
+// numeric index. ref must be a stage reference, see isStageRef.
+func stageAlias(ref string, stageNames []string) string {
+	if slices.Contains(stageNames, ref) {
+		return ref
+	}
+	i, _ := strconv.Atoi(ref)
+	return stageNames[i]
+}
+
 // parseMounts extracts Mount references from a RUN instruction's --mount flags.
 // Only mounts with a "from" option are returned. Uses the passed previous stage
 // names to classify whether the mount references a builder stage or an external image.
-func parseMounts(node *parser.Node, env []string, stageNames []string) ([]Mount, error) {
+// Sets the workdir of the mounts to the passed workdir.
+func parseMounts(node *parser.Node, workdir string, env []string, stageNames []string) ([]Mount, error) {
 	mounts := make([]Mount, 0)
+	sshMounts := 0
 	for _, fl := range node.Flags {
 		if !strings.HasPrefix(fl, "--mount=") {
 			continue
@@ -380,6 +839,15 @@ func parseMounts(node *parser.Node, env []string, stageNames []string) ([]Mount,
 			return nil, err
 		}
 		if mount != nil {
+			if mount.MountType == MountTypeSSH {
+				// buildah numbers the default targets of the ssh mounts of
+				// an instruction
+				if mount.Target == "" {
+					mount.Target = fmt.Sprintf("/run/buildkit/ssh_agent.%d", sshMounts)
+				}
+				sshMounts++
+			}
+			mount.Workdir = workdir
 			mounts = append(mounts, *mount)
 		}
 	}
@@ -389,7 +857,7 @@ func parseMounts(node *parser.Node, env []string, stageNames []string) ([]Mount,
 // parseMount parses a single --mount option string (without the --mount= prefix)
 // and returns a Mount if it is a bind mount with a from reference, or nil otherwise.
 func parseMount(mountOpts string, env []string, stageNames []string) (*Mount, error) {
-	var from, buildahMountTypeStr, pullspec string
+	var from, buildahMountTypeStr, pullspec, target, source, id string
 	for opt := range strings.SplitSeq(mountOpts, ",") {
 		if from == "" {
 			if val, ok := strings.CutPrefix(opt, "from="); ok {
@@ -407,11 +875,43 @@ func parseMount(mountOpts string, env []string, stageNames []string) (*Mount, er
 				continue
 			}
 		}
+		if target == "" {
+			name, val, _ := strings.Cut(opt, "=")
+			if name == "target" || name == "dst" || name == "destination" {
+				var err error
+				target, err = imagebuilder.ProcessWord(val, env)
+				if err != nil {
+					return nil, fmt.Errorf("%w: %w", ErrParse, err)
+				}
+				continue
+			}
+		}
+		if source == "" {
+			name, val, _ := strings.Cut(opt, "=")
+			if name == "source" || name == "src" {
+				var err error
+				source, err = imagebuilder.ProcessWord(val, env)
+				if err != nil {
+					return nil, fmt.Errorf("%w: %w", ErrParse, err)
+				}
+				continue
+			}
+		}
+		if id == "" {
+			if val, ok := strings.CutPrefix(opt, "id="); ok {
+				var err error
+				id, err = imagebuilder.ProcessWord(val, env)
+				if err != nil {
+					return nil, fmt.Errorf("%w: %w", ErrParse, err)
+				}
+				continue
+			}
+		}
 	}
 
 	if !isStageRef(from, stageNames) {
 		// populate pullspec only if it is not a stage reference
-		pullspec = from
+		pullspec = NormalizePullspec(from)
 	}
 
 	var mountType MountType
@@ -424,6 +924,9 @@ func parseMount(mountOpts string, env []string, stageNames []string) (*Mount, er
 		mountType = MountTypeTmpfs
 	case "secret":
 		mountType = MountTypeSecret
+		if target == "" && id != "" {
+			target = "/run/secrets/" + id
+		}
 	case "ssh":
 		mountType = MountTypeSSH
 	default:
@@ -434,6 +937,8 @@ func parseMount(mountOpts string, env []string, stageNames []string) (*Mount, er
 		FromRaw:   from,
 		Pullspec:  pullspec,
 		MountType: mountType,
+		Source:    source,
+		Target:    target,
 	}, nil
 }
 
@@ -446,7 +951,7 @@ func normalizeSources(sources []string, env []string) ([]string, error) {
 		// In COPY --from, even if the source path looks relative,
 		// it is resolved from '/' workdir. To make the path resolution
 		// unambiguous we prepend it with the slash. Join also cleans the path.
-		s = filepath.Join("/", s)
+		s = path.Join("/", s)
 		if isDir {
 			s += "/"
 		}
@@ -459,6 +964,53 @@ func normalizeSources(sources []string, env []string) ([]string, error) {
 	return normalizedPaths, nil
 }
 
+// copyFlags are the flags of a COPY or ADD instruction used by capo.
+type copyFlags struct {
+	// Value of the last --from flag, empty if none was passed.
+	from string
+	// Value of the last --link flag, true if passed without a value.
+	link bool
+	// Value of the last --checksum flag of ADD, empty if none was passed.
+	checksum string
+}
+
+// parseCopyFlags evaluates the flags of a COPY or ADD instruction the same as
+// imagebuilder: each flag is expanded with the passed env as a whole and the
+// last occurrence of a flag wins. Flags are only recognized before the
+// sources, a "--from=..." argument after them is a source path. Other flags
+// (e.g. --chown, --chmod, and --exclude and --parents of newer Dockerfile
+// syntax) are ignored, so Containerfiles buildah accepts aren't rejected.
+// Returns ErrParse for --from without a value (e.g. "--from builder"), which
+// buildah rejects, and for --link with a value other than a boolean.
+func parseCopyFlags(node *parser.Node, env []string) (copyFlags, error) {
+	res := copyFlags{}
+	for _, raw := range node.Flags {
+		fl, err := imagebuilder.ProcessWord(raw, env)
+		if err != nil {
+			return copyFlags{}, fmt.Errorf("%w: %w", ErrParse, err)
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(fl, "--"), "=")
+		switch name {
+		case "from":
+			if !hasValue {
+				return copyFlags{}, fmt.Errorf("%w: missing value of flag %q in %s", ErrParse, fl, node.Original)
+			}
+			res.from = value
+		case "link":
+			res.link = true
+			if hasValue {
+				if res.link, err = strconv.ParseBool(value); err != nil {
+					return copyFlags{}, fmt.Errorf("%w: invalid value of flag %q in %s", ErrParse, fl, node.Original)
+				}
+			}
+		case "checksum":
+			res.checksum = value
+		}
+	}
+	return res, nil
+}
+
 // parseCopy takes a raw dockerfile parser Node and optionally returns a pointer
 // to a parsed Copy struct.
 // Returns (nil, nil) if the COPY command is not builder-type, but copies from a context.
@@ -468,55 +1020,217 @@ func normalizeSources(sources []string, env []string) ([]string, error) {
 // a builder stage or directly from an external image.
 // Uses the passed build context names to determine if the COPY command is
 // copying from a named build context.
+// Both the shell form and the JSON (exec) form of the instruction are
+// supported. The same as in buildah, flags are only recognized before the
+// JSON array, a "--from=..." element of the array is a source path.
 func parseCopy(node *parser.Node, workdir string, env []string,
 	stageNames []string, contextNames []string) (*Copy, error) {
-	for _, fl := range node.Flags {
-		if !strings.HasPrefix(fl, "--from=") {
+	flags, err := parseCopyFlags(node, env)
+	if err != nil {
+		return nil, err
+	}
+	from := flags.from
+	if from == "" {
+		return nil, nil
+	}
+
+	// aggregate the COPY arguments by iterating the nodes, in the JSON form
+	// every array element is a single node, so paths with spaces are kept
+	// whole
+	args := make([]string, 0)
+	for curr := node.Next; curr != nil; curr = curr.Next {
+		args = append(args, curr.Value)
+	}
+	if len(args) < 2 {
+		return nil, fmt.Errorf("%w: COPY requires at least one source and a destination: %s", ErrParse, node.Original)
+	}
+
+	sources, err := normalizeSources(args[:len(args)-1], env)
+	if err != nil {
+		return nil, err
+	}
+
+	destination, err := imagebuilder.ProcessWord(args[len(args)-1], env)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrParse, err)
+	}
+	if destination == "" {
+		return nil, fmt.Errorf("%w: COPY destination is empty: %s", ErrParse, node.Original)
+	}
+
+	// Determine if copying from a builder stage, an external image, or a
+	// named context
+	cpType := CopyTypeExternal
+	if slices.Contains(contextNames, from) {
+		cpType = CopyTypeContext
+	} else if isStageRef(from, stageNames) {
+		cpType = CopyTypeBuilder
+	} else {
+		from = NormalizePullspec(from)
+	}
+
+	return &Copy{
+		From:        from,
+		Sources:     sources,
+		Destination: destination,
+		Type:        cpType,
+		Workdir:     workdir,
+		Line:        node.StartLine,
+		Link:        flags.link,
+	}, nil
+}
+
+// parseContextCopy takes a raw dockerfile parser Node of a COPY instruction
+// without --from and returns a Copy of its sources from the build context.
+// Returns nil if the instruction has no sources.
+func parseContextCopy(node *parser.Node, workdir string, env []string) (*Copy, error) {
+	flags, err := parseCopyFlags(node, env)
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, 0)
+	for curr := node.Next; curr != nil; curr = curr.Next {
+		// content of heredocs is inline in the Containerfile, not a file of
+		// the build context
+		if curr.Next != nil && isHeredoc(curr.Value) {
 			continue
 		}
-		from, err := imagebuilder.ProcessWord(strings.TrimPrefix(fl, "--from="), env)
+		arg, err := imagebuilder.ProcessWord(curr.Value, env)
 		if err != nil {
 			return nil, fmt.Errorf("%w: %w", ErrParse, err)
 		}
+		args = append(args, arg)
+	}
+	if len(args) < 2 {
+		return nil, nil
+	}
 
-		// aggregate the COPY arguments by iterating the nodes
-		args := make([]string, 0)
-		curr := node.Next
-		for curr != nil {
-			args = append(args, curr.Value)
-			curr = curr.Next
-		}
+	return &Copy{
+		Sources:     args[:len(args)-1],
+		Destination: args[len(args)-1],
+		Type:        CopyTypeContext,
+		Workdir:     workdir,
+		Line:        node.StartLine,
+		Link:        flags.link,
+	}, nil
+}
+
+// isHeredoc returns true if the source of a COPY or ADD instruction is a
+// heredoc (e.g. "<<EOF" or "<<-'EOF'"), whose content follows the
+// instruction. Supported by buildah and by the Dockerfile frontend since
+// syntax docker/dockerfile:1.4.
+func isHeredoc(source string) bool {
+	name, ok := strings.CutPrefix(source, "<<")
+	name = strings.TrimPrefix(name, "-")
+	return ok && strings.Trim(name, `"'`) != ""
+}
+
+// Extensions of archives which buildah extracts when added from the build
+// context. Buildah detects archives by their content, which capo can't read,
+// so archives are recognized by their names instead.
+var archiveExtensions = []string{
+	".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2", ".tar.xz", ".txz", ".tar.zst", ".tzst",
+}
+
+// parseArchiveAdds takes a raw dockerfile parser Node of an ADD instruction
+// and returns a Copy for each local archive source in it. ADD --from and
+// remote sources (URLs and git repositories) are not extracted by buildah
+// and are ignored.
+func parseArchiveAdds(node *parser.Node, workdir string, env []string) ([]Copy, error) {
+	flags, err := parseCopyFlags(node, env)
+	if err != nil {
+		return nil, err
+	}
+	if flags.from != "" {
+		return nil, nil
+	}
 
-		sources := args[:len(args)-1]
-		sources, err = normalizeSources(sources, env)
+	args := make([]string, 0)
+	for curr := node.Next; curr != nil; curr = curr.Next {
+		arg, err := imagebuilder.ProcessWord(curr.Value, env)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("%w: %w", ErrParse, err)
 		}
+		args = append(args, arg)
+	}
+	if len(args) < 2 {
+		return nil, nil
+	}
+
+	destination := args[len(args)-1]
+	adds := make([]Copy, 0)
+	for _, src := range args[:len(args)-1] {
+		if isRemoteSource(src) || !isArchiveName(src) {
+			continue
+		}
+		adds = append(adds, Copy{
+			Sources:     []string{src},
+			Destination: destination,
+			Type:        CopyTypeContext,
+			Workdir:     workdir,
+			Line:        node.StartLine,
+			Link:        flags.link,
+		})
+	}
+
+	return adds, nil
+}
 
-		destination, err := imagebuilder.ProcessWord(args[len(args)-1], env)
+// parseRemoteAdds takes a raw dockerfile parser Node of an ADD instruction
+// and returns a RemoteAdd for each source fetched from the network in it.
+// Returns nil for ADD --from and instructions without remote sources.
+func parseRemoteAdds(node *parser.Node, workdir string, env []string) ([]RemoteAdd, error) {
+	flags, err := parseCopyFlags(node, env)
+	if err != nil {
+		return nil, err
+	}
+	if flags.from != "" {
+		return nil, nil
+	}
+
+	args := make([]string, 0)
+	for curr := node.Next; curr != nil; curr = curr.Next {
+		arg, err := imagebuilder.ProcessWord(curr.Value, env)
 		if err != nil {
 			return nil, fmt.Errorf("%w: %w", ErrParse, err)
 		}
+		args = append(args, arg)
+	}
+	if len(args) < 2 {
+		return nil, nil
+	}
 
-		// Determine if copying from a builder stage, an external image, or a
-		// named context
-		cpType := CopyTypeExternal
-		if slices.Contains(contextNames, from) {
-			cpType = CopyTypeContext
-		} else if isStageRef(from, stageNames) {
-			cpType = CopyTypeBuilder
+	var adds []RemoteAdd
+	for _, src := range args[:len(args)-1] {
+		if !isRemoteSource(src) {
+			continue
 		}
-
-		return &Copy{
-			From:        from,
-			Sources:     sources,
-			Destination: destination,
-			Type:        cpType,
+		adds = append(adds, RemoteAdd{
+			URL:         src,
+			Checksum:    flags.checksum,
+			Destination: args[len(args)-1],
 			Workdir:     workdir,
-		}, nil
+			Line:        node.StartLine,
+		})
 	}
+	return adds, nil
+}
+
+// isRemoteSource returns true if the source of an ADD instruction is fetched
+// from the network: a URL or a git repository.
+func isRemoteSource(src string) bool {
+	return strings.Contains(src, "://") || strings.HasPrefix(src, "git@")
+}
 
-	return nil, nil
+func isArchiveName(name string) bool {
+	name = strings.ToLower(name)
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
 }
 
 // parseKeyValue is a helper function that parses key-value pairs from a parent node.
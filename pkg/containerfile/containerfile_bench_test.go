@@ -0,0 +1,25 @@
+//go:build unit
+
+package containerfile
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/konflux-ci/capo/internal/testutils"
+)
+
+func BenchmarkParse(b *testing.B) {
+	for _, builders := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("builders=%d", builders), func(b *testing.B) {
+			content := testutils.GenerateContainerfile(builders)
+
+			for b.Loop() {
+				if _, err := Parse(strings.NewReader(content)); err != nil {
+					b.Fatalf("unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+//go:build unit
+
+package containerfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/openshift/imagebuilder"
+)
+
+// seedContainerfiles adds the Containerfiles of the conformance corpus and a
+// few edge cases to the seed corpus of f.
+func seedContainerfiles(f *testing.F) {
+	paths, err := filepath.Glob(filepath.Join("..", "..", "testdata", "corpus", "*", "Containerfile"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(content), "")
+	}
+
+	f.Add("FROM ${BASE}\nCOPY --from=${BASE} /a /b\n", "quay.io/base:1")
+	f.Add("ARG STAGE=0\nFROM scratch AS a\nFROM a\nCOPY --from=$STAGE a b\n", "")
+	f.Add("FROM scratch\nWORKDIR ${DIR}\nCOPY --from=a --from=b x y\n", "rel")
+	f.Add("FROM scratch\nRUN --mount=type=bind,from=${BASE},src=/,dst=/mnt true\n", "quay.io/tools")
+	f.Add("FROM scratch\nADD --from=img a.tar.gz /\nADD a.tar.gz b.tgz ${DIR}/\n", "/opt")
+	f.Add("FROM scratch\nLABEL a=b c=\"d e\"\nENV A=1 B=${A}\n", "")
+}
+
+// FuzzParse asserts that Parse doesn't panic on arbitrary Containerfiles and
+// build args and that parsed stages keep the invariants capo relies on.
+func FuzzParse(f *testing.F) {
+	seedContainerfiles(f)
+
+	f.Fuzz(func(t *testing.T, content string, arg string) {
+		args := map[string]string{"BASE": arg, "DIR": arg, "STAGE": arg}
+		cf, err := Parse(strings.NewReader(content), WithArgs(args))
+		if err != nil {
+			return
+		}
+		checkInvariants(t, cf)
+	})
+}
+
+// FuzzParseCopy asserts that parseCopy doesn't panic on arbitrary COPY
+// instructions and that parsed copies keep the invariants capo relies on.
+func FuzzParseCopy(f *testing.F) {
+	f.Add("--from=builder /usr/bin/app /usr/bin/app")
+	f.Add("--from=builder --from=quay.io/tools:1 /a /b/")
+	f.Add("--chown=1001 --from=\"builder\" [\"/a b\", \"c\"]")
+	f.Add("--from=${STAGE} a ${DIR}")
+	f.Add("--from builder a b")
+	f.Add("--from=builder a")
+
+	stageNames := []string{"builder"}
+	env := []string{"STAGE=builder", "DIR=/opt"}
+	f.Fuzz(func(t *testing.T, instruction string) {
+		node, err := imagebuilder.ParseDockerfile(strings.NewReader("COPY " + instruction + "\n"))
+		if err != nil {
+			return
+		}
+
+		for _, child := range node.Children {
+			if child.Value != "copy" {
+				continue
+			}
+			cp, err := parseCopy(child, "", env, stageNames, nil)
+			if err != nil || cp == nil {
+				continue
+			}
+			checkCopy(t, *cp)
+		}
+	})
+}
+
+// checkInvariants fails t if the parsed cf violates an invariant: stages are
+// indexed by their position with only the last one final, and copies are
+// valid (see checkCopy).
+func checkInvariants(t *testing.T, cf Containerfile) {
+	t.Helper()
+	for i, stage := range cf.Stages {
+		if stage.Index != i {
+			t.Errorf("stage %q has index %d at position %d", stage.Alias, stage.Index, i)
+		}
+		if final := i == len(cf.Stages)-1; (stage.Kind == StageKindFinal) != final {
+			t.Errorf("stage %q at position %d has kind %v", stage.Alias, i, stage.Kind)
+		}
+		if stage.Alias == "" {
+			t.Errorf("stage at position %d has no alias", i)
+		}
+		for _, cp := range stage.Copies {
+			checkCopy(t, cp)
+		}
+		for _, add := range stage.ArchiveAdds {
+			if len(add.Sources) != 1 {
+				t.Errorf("archive add has %d sources, expected 1: %+v", len(add.Sources), add)
+			}
+		}
+	}
+}
+
+// checkCopy fails t if cp has no sources, a source path which is not absolute
+// or an empty destination. Destinations can be relative, they are resolved
+// against Workdir and the working directory of the base image.
+func checkCopy(t *testing.T, cp Copy) {
+	t.Helper()
+	if len(cp.Sources) == 0 {
+		t.Errorf("copy has no sources: %+v", cp)
+	}
+	for _, source := range cp.Sources {
+		if !strings.HasPrefix(source, "/") {
+			t.Errorf("copy source %q is not absolute: %+v", source, cp)
+		}
+	}
+	if cp.Destination == "" {
+		t.Errorf("copy has an empty destination: %+v", cp)
+	}
+}
@@ -3,6 +3,7 @@
 package containerfile
 
 import (
+	"errors"
 	"fmt"
 	"runtime"
 	"strings"
@@ -12,6 +13,24 @@ import (
 	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
+// ignoreEnv ignores Stage.Env in Parse results, which is checked by
+// TestParseEnv.
+var ignoreEnv = cmpopts.IgnoreFields(Stage{}, "Env")
+
+// ignoreArgUsage ignores the build args stages depend on in Parse results,
+// which are checked by TestParseArgUsage.
+var ignoreArgUsage = cmp.Options{
+	cmpopts.IgnoreFields(Stage{}, "PullspecArgs", "CopyArgs", "UnpinnedArgs"),
+	cmpopts.IgnoreFields(Containerfile{}, "UnusedArgs"),
+}
+
+// ignoreLines ignores the lines of instructions in Parse results, which are
+// checked by TestParseCopyLines.
+var ignoreLines = cmp.Options{
+	cmpopts.IgnoreFields(Copy{}, "Line"),
+	cmpopts.IgnoreFields(RemoteAdd{}, "Line"),
+}
+
 func TestParseBuiltinArgs(t *testing.T) {
 	t.Parallel()
 	containerfile := `FROM docker.io/library/alpine:${TARGETARCH} as builder
@@ -30,10 +49,11 @@ func TestParseBuiltinArgs(t *testing.T) {
 			Mounts:  []Mount{},
 		},
 		{
-			Alias:   FinalStage,
+			Alias:   "1",
 			Base:    "scratch",
 			BaseRef: "scratch",
-			Index:   -1,
+			Index:   1,
+			Kind:    StageKindFinal,
 			Copies: []Copy{
 				{
 					From:        "builder",
@@ -46,13 +66,13 @@ func TestParseBuiltinArgs(t *testing.T) {
 	}}
 
 	reader := strings.NewReader(containerfile)
-	actual, err := Parse(reader, BuildOptions{})
+	actual, err := Parse(reader)
 
 	if err != nil {
 		t.Fatalf("Parsing failed: %v", err)
 	}
 
-	if diff := cmp.Diff(expected, actual, cmpopts.EquateEmpty()); diff != "" {
+	if diff := cmp.Diff(expected, actual, cmpopts.EquateEmpty(), ignoreEnv, ignoreArgUsage, ignoreLines); diff != "" {
 		t.Errorf("Parse() result mismatch (-want +got):\n%s", diff)
 	}
 }
@@ -61,7 +81,7 @@ func TestParse(t *testing.T) {
 	t.Parallel()
 	tests := map[string]struct {
 		containerfile string
-		buildOptions  BuildOptions
+		options       []ParseOption
 		expected      Containerfile
 	}{
 		"arg evaluation": {
@@ -71,10 +91,10 @@ func TestParse(t *testing.T) {
 							FROM scratch
 							COPY --from=${FEDORA_REPO}:${FEDORA_TAG} /usr/bin/oras /usr/bin/oras
 							COPY --from=builder /usr/bin/binary /usr/bin/binary`,
-			buildOptions: BuildOptions{
-				Args: map[string]string{
+			options: []ParseOption{
+				WithArgs(map[string]string{
 					"FEDORA_TAG": "latest",
-				},
+				}),
 			},
 			expected: Containerfile{Stages: []Stage{
 				{
@@ -86,10 +106,11 @@ func TestParse(t *testing.T) {
 					Mounts:  []Mount{},
 				},
 				{
-					Alias:   FinalStage,
+					Alias:   "1",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   1,
+					Kind:    StageKindFinal,
 					Copies: []Copy{
 						{
 							From:        "docker.io/library/fedora:latest",
@@ -119,8 +140,8 @@ func TestParse(t *testing.T) {
 			expected: Containerfile{Stages: []Stage{
 				{
 					Alias:   "builder0",
-					Base:    "docker.io/library/fedora",
-					BaseRef: "docker.io/library/fedora",
+					Base:    "docker.io/library/fedora:latest",
+					BaseRef: "docker.io/library/fedora:latest",
 					Index:   0,
 					Copies:  []Copy{},
 					Mounts:  []Mount{},
@@ -150,27 +171,243 @@ func TestParse(t *testing.T) {
 						{
 							FromRaw:   "builder1",
 							MountType: MountTypeBind,
+							Source:    "/usr/bin/binary",
+							Target:    "/usr/bin/binary",
 						},
 					},
 				},
-				{Alias: FinalStage, Base: "scratch", BaseRef: "scratch", Index: -1, Copies: []Copy{}, Mounts: []Mount{}},
+				{Alias: "3", Base: "scratch", BaseRef: "scratch", Index: 3, Kind: StageKindFinal, Copies: []Copy{}, Mounts: []Mount{}},
 			},
 			},
 		},
+		"unnamed intermediate stages": {
+			containerfile: `FROM docker.io/library/golang:1.22
+							FROM docker.io/library/fedora:latest AS tools
+							FROM docker.io/library/alpine:latest
+							RUN --mount=type=cache,from=0,target=/root/.cache ls
+							FROM scratch
+							COPY --from=0 /go/bin/app /usr/bin/app
+							COPY --from=2 /bin/busybox /bin/busybox
+							COPY --from=tools /usr/bin/oras /usr/bin/oras`,
+			expected: Containerfile{Stages: []Stage{
+				{
+					Alias:   "0",
+					Base:    "docker.io/library/golang:1.22",
+					BaseRef: "docker.io/library/golang:1.22",
+					Index:   0,
+					Copies:  []Copy{},
+					Mounts:  []Mount{},
+				},
+				{
+					Alias:   "tools",
+					Base:    "docker.io/library/fedora:latest",
+					BaseRef: "docker.io/library/fedora:latest",
+					Index:   1,
+					Copies:  []Copy{},
+					Mounts:  []Mount{},
+				},
+				{
+					Alias:   "2",
+					Base:    "docker.io/library/alpine:latest",
+					BaseRef: "docker.io/library/alpine:latest",
+					Index:   2,
+					Copies:  []Copy{},
+					Mounts: []Mount{
+						{
+							FromRaw:   "0",
+							MountType: MountTypeCache,
+							Target:    "/root/.cache",
+						},
+					},
+				},
+				{
+					Alias:   "3",
+					Base:    "scratch",
+					BaseRef: "scratch",
+					Index:   3,
+					Kind:    StageKindFinal,
+					Copies: []Copy{
+						{
+							From:        "0",
+							Sources:     []string{"/go/bin/app"},
+							Destination: "/usr/bin/app",
+						},
+						{
+							From:        "2",
+							Sources:     []string{"/bin/busybox"},
+							Destination: "/bin/busybox",
+						},
+						{
+							From:        "tools",
+							Sources:     []string{"/usr/bin/oras"},
+							Destination: "/usr/bin/oras",
+						},
+					},
+					Mounts: []Mount{},
+				},
+			}},
+		},
+		"named final stage": {
+			containerfile: `FROM docker.io/library/fedora:latest AS builder
+							FROM scratch AS final
+							COPY --from=builder /usr/bin/binary /usr/bin/binary`,
+			expected: Containerfile{Stages: []Stage{
+				{
+					Alias:   "builder",
+					Base:    "docker.io/library/fedora:latest",
+					BaseRef: "docker.io/library/fedora:latest",
+					Index:   0,
+					Copies:  []Copy{},
+					Mounts:  []Mount{},
+				},
+				{
+					Alias:   "final",
+					Base:    "scratch",
+					BaseRef: "scratch",
+					Index:   1,
+					Kind:    StageKindFinal,
+					Copies: []Copy{
+						{
+							From:        "builder",
+							Sources:     []string{"/usr/bin/binary"},
+							Destination: "/usr/bin/binary",
+						},
+					},
+					Mounts: []Mount{},
+				},
+			}},
+		},
+		"pullspec normalization": {
+			containerfile: `FROM fedora AS short
+							FROM docker.io/library/fedora:latest AS full
+							FROM Quay.IO/konflux-ci/tools AS registry
+							FROM short AS chained
+							RUN --mount=type=bind,from=alpine,src=/bin,dst=/mnt ls /mnt
+							FROM oci-archive:/images/base.tar
+							COPY --from=golang:1.22 /usr/local/go/bin/go /usr/bin/go`,
+			expected: Containerfile{Stages: []Stage{
+				{
+					Alias:   "short",
+					Base:    "docker.io/library/fedora:latest",
+					BaseRef: "docker.io/library/fedora:latest",
+					Index:   0,
+				},
+				{
+					Alias:   "full",
+					Base:    "docker.io/library/fedora:latest",
+					BaseRef: "docker.io/library/fedora:latest",
+					Index:   1,
+				},
+				{
+					Alias:   "registry",
+					Base:    "quay.io/konflux-ci/tools:latest",
+					BaseRef: "quay.io/konflux-ci/tools:latest",
+					Index:   2,
+				},
+				{
+					Alias:   "chained",
+					Base:    "docker.io/library/fedora:latest",
+					BaseRef: "short",
+					Index:   3,
+					Mounts: []Mount{
+						{
+							FromRaw:   "alpine",
+							Pullspec:  "docker.io/library/alpine:latest",
+							MountType: MountTypeBind,
+							Source:    "/bin",
+							Target:    "/mnt",
+						},
+					},
+				},
+				{
+					Alias:   "4",
+					Base:    "oci-archive:/images/base.tar",
+					BaseRef: "oci-archive:/images/base.tar",
+					Index:   4,
+					Kind:    StageKindFinal,
+					Copies: []Copy{
+						{
+							From:        "docker.io/library/golang:1.22",
+							Sources:     []string{"/usr/local/go/bin/go"},
+							Destination: "/usr/bin/go",
+							Type:        CopyTypeExternal,
+						},
+					},
+				},
+			}},
+		},
+		"archive adds": {
+			containerfile: `ARG VERSION=1.2
+							FROM docker.io/library/fedora:latest AS builder
+							WORKDIR /src
+							ADD --chown=1000 vendor.tar.gz deps.TGZ notes.txt /opt/
+							ADD app-${VERSION}.tar.xz .
+							ADD https://example.com/remote.tar.gz /tmp/
+							ADD --from=docker.io/library/alpine:latest /image.tar /tmp/
+							FROM scratch
+							COPY --from=builder /opt/ /opt/`,
+			expected: Containerfile{Stages: []Stage{
+				{
+					Alias:   "builder",
+					Base:    "docker.io/library/fedora:latest",
+					BaseRef: "docker.io/library/fedora:latest",
+					Index:   0,
+					Copies:  []Copy{},
+					Mounts:  []Mount{},
+					ArchiveAdds: []Copy{
+						{
+							Sources:     []string{"vendor.tar.gz"},
+							Destination: "/opt/",
+							Type:        CopyTypeContext,
+							Workdir:     "/src",
+						},
+						{
+							Sources:     []string{"deps.TGZ"},
+							Destination: "/opt/",
+							Type:        CopyTypeContext,
+							Workdir:     "/src",
+						},
+						{
+							Sources:     []string{"app-1.2.tar.xz"},
+							Destination: ".",
+							Type:        CopyTypeContext,
+							Workdir:     "/src",
+						},
+					},
+					RemoteAdds: []RemoteAdd{
+						{URL: "https://example.com/remote.tar.gz", Destination: "/tmp/", Workdir: "/src"},
+					},
+				},
+				{
+					Alias:   "1",
+					Base:    "scratch",
+					BaseRef: "scratch",
+					Index:   1,
+					Kind:    StageKindFinal,
+					Copies: []Copy{
+						{
+							From:        "builder",
+							Sources:     []string{"/opt/"},
+							Destination: "/opt/",
+						},
+					},
+					Mounts: []Mount{},
+				},
+			}},
+		},
 		"build target": {
 			containerfile: `FROM docker.io/library/fedora:latest AS builder
 							COPY --from=docker.io/library/alpine:latest /usr/bin/binary /usr/bin/binary
 							FROM scratch
 							COPY --from=docker.io/library/fedora:latest /usr/bin/oras /usr/bin/oras`,
-			buildOptions: BuildOptions{
-				Target: "builder",
-			},
+			options: []ParseOption{WithTarget("builder")},
 			expected: Containerfile{Stages: []Stage{
 				{
-					Alias:   FinalStage,
+					Alias:   "builder",
 					Base:    "docker.io/library/fedora:latest",
 					BaseRef: "docker.io/library/fedora:latest",
-					Index:   -1,
+					Index:   0,
+					Kind:    StageKindFinal,
 					Copies: []Copy{
 						{
 							From:        "docker.io/library/alpine:latest",
@@ -207,10 +444,11 @@ func TestParse(t *testing.T) {
 					Mounts:  []Mount{},
 				},
 				{
-					Alias:   FinalStage,
+					Alias:   "2",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   2,
+					Kind:    StageKindFinal,
 					Copies: []Copy{
 						{
 							From:        "builder1",
@@ -260,10 +498,11 @@ func TestParse(t *testing.T) {
 					Mounts: []Mount{},
 				},
 				{
-					Alias:   FinalStage,
+					Alias:   "2",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   2,
+					Kind:    StageKindFinal,
 					Copies: []Copy{
 						{
 							From:        "builder2",
@@ -293,10 +532,11 @@ func TestParse(t *testing.T) {
 					Mounts:  []Mount{},
 				},
 				{
-					Alias:   FinalStage,
+					Alias:   "1",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   1,
+					Kind:    StageKindFinal,
 					Copies: []Copy{
 						{
 							From:        "builder",
@@ -341,10 +581,11 @@ func TestParse(t *testing.T) {
 					Mounts:  []Mount{},
 				},
 				{
-					Alias:   FinalStage,
+					Alias:   "1",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   1,
+					Kind:    StageKindFinal,
 					Copies: []Copy{
 						{
 							From:        "builder",
@@ -438,10 +679,11 @@ func TestParse(t *testing.T) {
 					Mounts: []Mount{},
 				},
 				{
-					Alias:   FinalStage,
+					Alias:   "2",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   2,
+					Kind:    StageKindFinal,
 					Copies: []Copy{
 						{
 							From:        "builder2",
@@ -492,10 +734,11 @@ func TestParse(t *testing.T) {
 					Mounts: []Mount{},
 				},
 				{
-					Alias:   FinalStage,
+					Alias:   "2",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   2,
+					Kind:    StageKindFinal,
 					Copies: []Copy{
 						{
 							From:        "builder2",
@@ -522,10 +765,11 @@ func TestParse(t *testing.T) {
 					Mounts:  []Mount{},
 				},
 				{
-					Alias:   FinalStage,
+					Alias:   "1",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   1,
+					Kind:    StageKindFinal,
 					Copies: []Copy{
 						{
 							From:        "0",
@@ -552,10 +796,11 @@ func TestParse(t *testing.T) {
 					Mounts:  []Mount{},
 				},
 				{
-					Alias:   FinalStage,
+					Alias:   "1",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   1,
+					Kind:    StageKindFinal,
 					Copies: []Copy{
 						{
 							From:        "0",
@@ -573,10 +818,11 @@ func TestParse(t *testing.T) {
 							COPY --from=5 /usr/bin/binary /usr/bin/binary`,
 			expected: Containerfile{Stages: []Stage{
 				{
-					Alias:   FinalStage,
+					Alias:   "0",
 					Base:    "quay.io/rhel:9",
 					BaseRef: "quay.io/rhel:9",
-					Index:   -1,
+					Index:   0,
+					Kind:    StageKindFinal,
 					Copies: []Copy{
 						{
 							From:        "5",
@@ -594,13 +840,14 @@ func TestParse(t *testing.T) {
 							RUN --mount=type=bind,from=quay.io/tools:1,src=/bin/tool,dst=/tmp/tool /tmp/tool --version`,
 			expected: Containerfile{Stages: []Stage{
 				{
-					Alias:   FinalStage,
+					Alias:   "0",
 					Base:    "quay.io/rhel:9",
 					BaseRef: "quay.io/rhel:9",
-					Index:   -1,
+					Index:   0,
+					Kind:    StageKindFinal,
 					Copies:  []Copy{},
 					Mounts: []Mount{
-						{FromRaw: "quay.io/tools:1", Pullspec: "quay.io/tools:1"},
+						{FromRaw: "quay.io/tools:1", Pullspec: "quay.io/tools:1", Source: "/bin/tool", Target: "/tmp/tool"},
 					},
 				},
 			}},
@@ -619,13 +866,14 @@ func TestParse(t *testing.T) {
 					Mounts:  []Mount{},
 				},
 				{
-					Alias:   FinalStage,
+					Alias:   "1",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   1,
+					Kind:    StageKindFinal,
 					Copies:  []Copy{},
 					Mounts: []Mount{
-						{FromRaw: "builder"},
+						{FromRaw: "builder", Source: "/app", Target: "/app"},
 					},
 				},
 			}},
@@ -644,13 +892,14 @@ func TestParse(t *testing.T) {
 					Mounts:  []Mount{},
 				},
 				{
-					Alias:   FinalStage,
+					Alias:   "1",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   1,
+					Kind:    StageKindFinal,
 					Copies:  []Copy{},
 					Mounts: []Mount{
-						{FromRaw: "0"},
+						{FromRaw: "0", Source: "/app", Target: "/app"},
 					},
 				},
 			}},
@@ -663,7 +912,7 @@ func TestParse(t *testing.T) {
 			expected: Containerfile{Stages: []Stage{
 				{Alias: "builder", Base: "quay.io/rhel:9", BaseRef: "quay.io/rhel:9", Index: 0, Copies: []Copy{}, Mounts: []Mount{}},
 				{Alias: "builder", Base: "quay.io/fedora:42", BaseRef: "quay.io/fedora:42", Index: 1, Copies: []Copy{}, Mounts: []Mount{}},
-				{Alias: FinalStage, Base: "scratch", BaseRef: "scratch", Index: -1, Copies: []Copy{
+				{Alias: "2", Base: "scratch", BaseRef: "scratch", Index: 2, Kind: StageKindFinal, Copies: []Copy{
 					{From: "builder", Sources: []string{"/app"}, Destination: "/app", Type: CopyTypeBuilder},
 				}, Mounts: []Mount{}},
 			}},
@@ -684,10 +933,11 @@ func TestParse(t *testing.T) {
 					Mounts:  []Mount{},
 				},
 				{
-					Alias:   FinalStage,
+					Alias:   "1",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   1,
+					Kind:    StageKindFinal,
 					Copies: []Copy{
 						{
 							From:        "builder",
@@ -717,10 +967,11 @@ func TestParse(t *testing.T) {
 							LABEL version=1.0`,
 			expected: Containerfile{Stages: []Stage{
 				{
-					Alias:   FinalStage,
+					Alias:   "0",
 					Base:    "quay.io/rhel:9",
 					BaseRef: "quay.io/rhel:9",
-					Index:   -1,
+					Index:   0,
+					Kind:    StageKindFinal,
 					Copies:  []Copy{},
 					Mounts:  []Mount{},
 					Labels:  map[string]string{"version": "1.0"},
@@ -732,10 +983,11 @@ func TestParse(t *testing.T) {
 							LABEL "version"=1.0 vendor="Red Hat"`,
 			expected: Containerfile{Stages: []Stage{
 				{
-					Alias:   FinalStage,
+					Alias:   "0",
 					Base:    "quay.io/rhel:9",
 					BaseRef: "quay.io/rhel:9",
-					Index:   -1,
+					Index:   0,
+					Kind:    StageKindFinal,
 					Copies:  []Copy{},
 					Mounts:  []Mount{},
 					Labels:  map[string]string{"version": "1.0", "vendor": "Red Hat"},
@@ -748,10 +1000,11 @@ func TestParse(t *testing.T) {
 label test"`,
 			expected: Containerfile{Stages: []Stage{
 				{
-					Alias:   FinalStage,
+					Alias:   "0",
 					Base:    "quay.io/rhel:9",
 					BaseRef: "quay.io/rhel:9",
-					Index:   -1,
+					Index:   0,
+					Kind:    StageKindFinal,
 					Copies:  []Copy{},
 					Mounts:  []Mount{},
 					Labels:  map[string]string{"description": "multi-line label test"},
@@ -764,10 +1017,11 @@ label test"`,
 							LABEL vendor="Red Hat"`,
 			expected: Containerfile{Stages: []Stage{
 				{
-					Alias:   FinalStage,
+					Alias:   "0",
 					Base:    "quay.io/rhel:9",
 					BaseRef: "quay.io/rhel:9",
-					Index:   -1,
+					Index:   0,
+					Kind:    StageKindFinal,
 					Copies:  []Copy{},
 					Mounts:  []Mount{},
 					Labels:  map[string]string{"version": "1.0", "vendor": "Red Hat"},
@@ -778,13 +1032,13 @@ label test"`,
 			containerfile: `ARG VER=2.0
 							FROM quay.io/rhel:9
 							LABEL version=$VER`,
-			buildOptions: BuildOptions{},
 			expected: Containerfile{Stages: []Stage{
 				{
-					Alias:   FinalStage,
+					Alias:   "0",
 					Base:    "quay.io/rhel:9",
 					BaseRef: "quay.io/rhel:9",
-					Index:   -1,
+					Index:   0,
+					Kind:    StageKindFinal,
 					Copies:  []Copy{},
 					Mounts:  []Mount{},
 					Labels:  map[string]string{"version": "2.0"},
@@ -797,10 +1051,11 @@ label test"`,
 							LABEL version=2.0`,
 			expected: Containerfile{Stages: []Stage{
 				{
-					Alias:   FinalStage,
+					Alias:   "0",
 					Base:    "quay.io/rhel:9",
 					BaseRef: "quay.io/rhel:9",
-					Index:   -1,
+					Index:   0,
+					Kind:    StageKindFinal,
 					Copies:  []Copy{},
 					Mounts:  []Mount{},
 					Labels:  map[string]string{"version": "2.0"},
@@ -823,10 +1078,11 @@ label test"`,
 					Labels:  map[string]string{"stage": "builder"},
 				},
 				{
-					Alias:   FinalStage,
+					Alias:   "1",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   1,
+					Kind:    StageKindFinal,
 					Copies:  []Copy{},
 					Mounts:  []Mount{},
 					Labels:  map[string]string{"stage": "final"},
@@ -866,10 +1122,11 @@ label test"`,
 					Mounts: []Mount{},
 				},
 				{
-					Alias:   FinalStage,
+					Alias:   "2",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   2,
+					Kind:    StageKindFinal,
 					Copies: []Copy{
 						{
 							From:        "builder1",
@@ -926,10 +1183,11 @@ label test"`,
 					Mounts:  []Mount{},
 				},
 				{
-					Alias:   FinalStage,
+					Alias:   "3",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   3,
+					Kind:    StageKindFinal,
 					Copies: []Copy{
 						{From: "grandchild", Sources: []string{"/app"}, Destination: "/app", Type: CopyTypeBuilder},
 					},
@@ -937,6 +1195,24 @@ label test"`,
 				},
 			}},
 		},
+		"stage base from arg resolves to a previous stage": {
+			containerfile: `ARG BASE=builder1
+							FROM quay.io/fedora:41 AS builder1
+							FROM ${BASE} AS builder2`,
+			expected: Containerfile{Stages: []Stage{
+				{Alias: "builder1", Base: "quay.io/fedora:41", BaseRef: "quay.io/fedora:41", Index: 0, Copies: []Copy{}, Mounts: []Mount{}},
+				{Alias: "builder2", Base: "quay.io/fedora:41", BaseRef: "builder1", Index: 1, Kind: StageKindFinal, Copies: []Copy{}, Mounts: []Mount{}},
+			}},
+		},
+		"stage base from arg resolves to a previous stage index": {
+			containerfile: `ARG BASE=0
+							FROM quay.io/fedora:41 AS builder1
+							FROM ${BASE}`,
+			expected: Containerfile{Stages: []Stage{
+				{Alias: "builder1", Base: "quay.io/fedora:41", BaseRef: "quay.io/fedora:41", Index: 0, Copies: []Copy{}, Mounts: []Mount{}},
+				{Alias: "1", Base: "quay.io/fedora:41", BaseRef: "0", Index: 1, Kind: StageKindFinal, Copies: []Copy{}, Mounts: []Mount{}},
+			}},
+		},
 		"run with mount is parsed correctly": {
 			containerfile: `FROM quay.io/rhel:9 AS builder
 							FROM scratch
@@ -944,15 +1220,19 @@ label test"`,
 							RUN --mount=type=cache,from=quay.io/builder,src=/cache,dst=/cache ls /cache`,
 			expected: Containerfile{Stages: []Stage{
 				{Alias: "builder", Base: "quay.io/rhel:9", BaseRef: "quay.io/rhel:9", Index: 0, Copies: []Copy{}, Mounts: []Mount{}},
-				{Alias: FinalStage, Base: "scratch", BaseRef: "scratch", Index: -1, Copies: []Copy{}, Mounts: []Mount{
+				{Alias: "1", Base: "scratch", BaseRef: "scratch", Index: 1, Kind: StageKindFinal, Copies: []Copy{}, Mounts: []Mount{
 					{
 						FromRaw:   "builder",
 						MountType: MountTypeBind,
+						Source:    "/app",
+						Target:    "/app",
 					},
 					{
 						FromRaw:   "quay.io/builder",
-						Pullspec:  "quay.io/builder",
+						Pullspec:  "quay.io/builder:latest",
 						MountType: MountTypeCache,
+						Source:    "/cache",
+						Target:    "/cache",
 					},
 				}},
 			},
@@ -996,10 +1276,11 @@ label test"`,
 					},
 				},
 				{
-					Alias:   FinalStage,
+					Alias:   "2",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   2,
+					Kind:    StageKindFinal,
 					Copies: []Copy{
 						{
 							From:        "secondary",
@@ -1017,22 +1298,23 @@ label test"`,
 							COPY --from=reldir /usr/bin/binary /usr/bin/binary
 							COPY --from=https /usr/bin/binary /usr/bin/binary
 							COPY --from=image /usr/bin/binary /usr/bin/binary`,
-			buildOptions: BuildOptions{
-				Args: map[string]string{
+			options: []ParseOption{
+				WithArgs(map[string]string{
 					"FEDORA_TAG": "latest",
-				},
-				BuildContexts: map[string]string{
+				}),
+				WithBuildContexts(map[string]string{
 					"reldir": "../dir",
-					"https": "https://example.org/releases/src.tar",
-					"image": "container-image://alpine:3.15",
-				},
+					"https":  "https://example.org/releases/src.tar",
+					"image":  "container-image://alpine:3.15",
+				}),
 			},
 			expected: Containerfile{Stages: []Stage{
 				{
-					Alias:   FinalStage,
+					Alias:   "0",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   0,
+					Kind:    StageKindFinal,
 					Copies: []Copy{
 						{
 							From:        "reldir",
@@ -1063,92 +1345,650 @@ label test"`,
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
 			reader := strings.NewReader(test.containerfile)
-			actual, err := Parse(reader, test.buildOptions)
+			actual, err := Parse(reader, test.options...)
 			if err != nil {
 				t.Fatalf("Parsing failed: %v", err)
 			}
 
-			if diff := cmp.Diff(test.expected, actual, cmpopts.EquateEmpty()); diff != "" {
+			if diff := cmp.Diff(test.expected, actual, cmpopts.EquateEmpty(), ignoreEnv, ignoreArgUsage, ignoreLines); diff != "" {
 				t.Errorf("Parse() result mismatch (-want +got):\n%s", diff)
 			}
 		})
 	}
 }
 
-func TestStageByRef(t *testing.T) {
+func TestParseEnv(t *testing.T) {
 	t.Parallel()
+	containerfile := `ARG BASE_IMAGE=registry.access.redhat.com/ubi9/ubi:latest
+						ARG VERSION=1.0
+						FROM ${BASE_IMAGE} AS builder
+						ENV APP_HOME=/opt/app VERSION=2.0
+						ENV APP_BIN=${APP_HOME}/bin
+						FROM scratch
+						COPY --from=builder /opt/app /opt/app`
 
-	stages := []Stage{
-		{Alias: "builder", Base: "docker.io/library/fedora:latest", Index: 0},
-		{Alias: "tools", Base: "docker.io/library/alpine:latest", Index: 1},
-		{Alias: FinalStage, Base: "scratch", Index: -1},
-	}
-	cf := Containerfile{Stages: stages}
-
-	tests := map[string]struct {
-		ref      string
-		expected *Stage
-	}{
-		"numeric index": {
-			ref:      "0",
-			expected: &cf.Stages[0],
-		},
-		"alias match": {
-			ref:      "tools",
-			expected: &cf.Stages[1],
-		},
-		"alias not found": {
-			ref:      "nonexistent",
-			expected: nil,
-		},
-		"numeric index out of bounds": {
-			ref:      "99",
-			expected: nil,
-		},
-		"negative numeric string": {
-			ref:      "-1",
-			expected: nil,
+	// only the keys set by the test are compared, imagebuilder can add
+	// built-in args
+	keys := []string{"BASE_IMAGE", "VERSION", "APP_HOME", "APP_BIN", "GOFLAGS", "REVISION"}
+	expected := []map[string]string{
+		{
+			"BASE_IMAGE": "quay.io/konflux-ci/ubi:9",
+			"VERSION":    "2.0",
+			"APP_HOME":   "/opt/app",
+			"APP_BIN":    "/opt/app/bin",
+			"GOFLAGS":    "-mod=vendor",
+			"REVISION":   "abc123",
 		},
-		"numeric ref prefers index over alias": {
-			ref:      "1",
-			expected: &cf.Stages[1],
+		{
+			"BASE_IMAGE": "quay.io/konflux-ci/ubi:9",
+			"VERSION":    "1.0",
+			"GOFLAGS":    "-mod=vendor",
+			"REVISION":   "abc123",
 		},
 	}
 
-	for name, test := range tests {
-		t.Run(name, func(t *testing.T) {
-			t.Parallel()
-			actual := cf.StageByRef(test.ref)
-			if diff := cmp.Diff(test.expected, actual); diff != "" {
-				t.Errorf("StageByRef(%q) mismatch (-want +got):\n%s", test.ref, diff)
+	reader := strings.NewReader(containerfile)
+	actual, err := Parse(reader,
+		WithArgs(map[string]string{"BASE_IMAGE": "quay.io/konflux-ci/ubi:9", "REVISION": "abc123"}),
+		WithEnvVars(map[string]string{"GOFLAGS": "-mod=vendor"}),
+	)
+	if err != nil {
+		t.Fatalf("Parsing failed: %v", err)
+	}
+
+	if len(actual.Stages) != len(expected) {
+		t.Fatalf("expected %d stages, got %d", len(expected), len(actual.Stages))
+	}
+	for i, stage := range actual.Stages {
+		env := make(map[string]string)
+		for _, key := range keys {
+			if value, ok := stage.Env[key]; ok {
+				env[key] = value
 			}
-		})
+		}
+		if diff := cmp.Diff(expected[i], env); diff != "" {
+			t.Errorf("stage %q Env mismatch (-want +got):\n%s", stage.Alias, diff)
+		}
 	}
 }
 
-func TestStageByIndex(t *testing.T) {
+func TestParseArgUsage(t *testing.T) {
 	t.Parallel()
+	containerfile := `ARG REGISTRY=quay.io/konflux-ci
+						ARG BASE_IMAGE=${REGISTRY}/ubi:9
+						ARG TOOLS_TAG
+						FROM ${BASE_IMAGE} AS builder
+						ARG VERSION=1.0
+						ENV APP_HOME=/opt/app-${VERSION}
+						WORKDIR ${APP_HOME}
+						RUN echo \$REGISTRY && make build
+						FROM registry.access.redhat.com/ubi9/ubi-minimal:latest
+						ARG BIN_DIR=/usr/local/bin
+						COPY --from=builder /opt/app/bin ${BIN_DIR}/
+						COPY --from=quay.io/konflux-ci/tools:${TOOLS_TAG} /usr/bin/tool ${BIN_DIR}/tool`
 
-	stages := []Stage{
-		{Alias: "builder", Base: "docker.io/library/fedora:latest", Index: 0},
-		{Alias: "tools", Base: "docker.io/library/alpine:latest", Index: 1},
-		{Alias: FinalStage, Base: "scratch", Index: -1},
+	type argUsage struct {
+		PullspecArgs []string
+		CopyArgs     []string
+		UnpinnedArgs []string
 	}
-	cf := Containerfile{Stages: stages}
-
-	tests := map[string]struct {
-		cf       Containerfile
-		index    int
-		expected *Stage
-	}{
-		"first stage": {
-			cf:       cf,
-			index:    0,
-			expected: &cf.Stages[0],
+	expected := []argUsage{
+		{
+			PullspecArgs: []string{"BASE_IMAGE", "REGISTRY"},
+			CopyArgs:     []string{"VERSION"},
+			UnpinnedArgs: []string{"BASE_IMAGE"},
 		},
-		"last stage": {
-			cf:       cf,
-			index:    2,
+		{
+			CopyArgs: []string{"BIN_DIR", "TOOLS_TAG"},
+		},
+	}
+
+	reader := strings.NewReader(containerfile)
+	actual, err := Parse(reader, WithArgs(map[string]string{
+		"REGISTRY":   "quay.io/org",
+		"TOOLS_TAG":  "v1.2.0",
+		"UNUSED_ARG": "value",
+		"HTTP_PROXY": "http://proxy:3128",
+	}))
+	if err != nil {
+		t.Fatalf("Parsing failed: %v", err)
+	}
+
+	usage := make([]argUsage, 0, len(actual.Stages))
+	for _, stage := range actual.Stages {
+		usage = append(usage, argUsage{
+			PullspecArgs: stage.PullspecArgs,
+			CopyArgs:     stage.CopyArgs,
+			UnpinnedArgs: stage.UnpinnedArgs,
+		})
+	}
+	if diff := cmp.Diff(expected, usage); diff != "" {
+		t.Errorf("stage arg usage mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"UNUSED_ARG"}, actual.UnusedArgs); diff != "" {
+		t.Errorf("UnusedArgs mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseCopyLines(t *testing.T) {
+	t.Parallel()
+	containerfile := `FROM docker.io/library/golang:1.22 AS builder
+						# build
+						COPY . /src
+						RUN make build
+						FROM registry.access.redhat.com/ubi9/ubi-minimal:latest
+						COPY --from=builder \
+							/src/bin/app /usr/bin/app
+						ADD vendor.tar.gz /opt/
+						ADD https://example.com/tool /usr/bin/`
+
+	reader := strings.NewReader(containerfile)
+	actual, err := Parse(reader)
+	if err != nil {
+		t.Fatalf("Parsing failed: %v", err)
+	}
+
+	lines := map[string]int{
+		"builder context copy": actual.Stages[0].ContextCopies[0].Line,
+		"final copy":           actual.Stages[1].Copies[0].Line,
+		"final archive add":    actual.Stages[1].ArchiveAdds[0].Line,
+		"final remote add":     actual.Stages[1].RemoteAdds[0].Line,
+	}
+	expected := map[string]int{
+		"builder context copy": 3,
+		"final copy":           6,
+		"final archive add":    8,
+		"final remote add":     9,
+	}
+	if diff := cmp.Diff(expected, lines); diff != "" {
+		t.Errorf("Copy.Line mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParsePlatform(t *testing.T) {
+	t.Parallel()
+	containerfile := `FROM docker.io/library/alpine:${TARGETARCH} AS builder
+						FROM scratch
+						LABEL platform=${TARGETPLATFORM}
+						COPY --from=builder /usr/bin/binary /usr/bin/binary`
+
+	reader := strings.NewReader(containerfile)
+	actual, err := Parse(reader, WithPlatform("linux", "arm64", "v8"))
+	if err != nil {
+		t.Fatalf("Parsing failed: %v", err)
+	}
+
+	if len(actual.Stages) != 2 {
+		t.Fatalf("expected 2 stages, got %d", len(actual.Stages))
+	}
+	if base := actual.Stages[0].Base; base != "docker.io/library/alpine:arm64" {
+		t.Errorf("expected base for the arm64 platform, got %q", base)
+	}
+	if platform := actual.Stages[1].Labels["platform"]; platform != "linux/arm64/v8" {
+		t.Errorf("expected TARGETPLATFORM linux/arm64/v8, got %q", platform)
+	}
+}
+
+func TestParseFromPlatform(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		containerfile string
+		args          map[string]string
+		expected      []string
+		expectedErr   error
+	}{
+		"build platform": {
+			containerfile: `FROM --platform=$BUILDPLATFORM docker.io/library/golang:1.26 AS builder
+							FROM scratch
+							COPY --from=builder /app /app`,
+			expected: []string{runtime.GOOS + "/" + runtime.GOARCH, ""},
+		},
+		"literal platform": {
+			containerfile: `FROM --platform=linux/arm64 docker.io/library/alpine:3 AS builder
+							FROM --platform=linux/amd64 registry.access.redhat.com/ubi9/ubi-micro:latest`,
+			expected: []string{"linux/arm64", "linux/amd64"},
+		},
+		"build arg": {
+			containerfile: `ARG BUILDER_PLATFORM=linux/s390x
+							FROM --platform=${BUILDER_PLATFORM} docker.io/library/golang:1.26`,
+			args:     map[string]string{"BUILDER_PLATFORM": "linux/ppc64le"},
+			expected: []string{"linux/ppc64le"},
+		},
+		"missing value": {
+			containerfile: `FROM --platform docker.io/library/golang:1.26`,
+			expectedErr:   ErrParse,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			actual, err := Parse(strings.NewReader(tc.containerfile), WithArgs(tc.args))
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			platforms := make([]string, 0, len(actual.Stages))
+			for _, stage := range actual.Stages {
+				platforms = append(platforms, stage.Platform)
+			}
+			if diff := cmp.Diff(tc.expected, platforms); diff != "" {
+				t.Errorf("Stage.Platform mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseStrictArgs(t *testing.T) {
+	t.Parallel()
+	containerfile := `ARG BASE_IMAGE=docker.io/library/fedora:latest
+						FROM ${BASE_IMAGE} AS builder
+						ARG VERSION REVISION=main
+						FROM scratch
+						COPY --from=builder /usr/bin/binary /usr/bin/binary`
+
+	tests := map[string]struct {
+		args        map[string]string
+		strict      bool
+		expectedErr error
+	}{
+		"declared args": {
+			args:   map[string]string{"BASE_IMAGE": "quay.io/fedora:41", "VERSION": "1.0", "REVISION": "abc"},
+			strict: true,
+		},
+		"predefined args": {
+			args:   map[string]string{"HTTPS_PROXY": "http://proxy.example.com:3128"},
+			strict: true,
+		},
+		"undeclared arg": {
+			args:        map[string]string{"VERSON": "1.0"},
+			strict:      true,
+			expectedErr: ErrUndeclaredArgs,
+		},
+		"undeclared arg without strict args": {
+			args: map[string]string{"VERSON": "1.0"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			reader := strings.NewReader(containerfile)
+			_, err := Parse(reader, WithArgs(test.args), WithStrictArgs(test.strict))
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error wrapping %v, got: %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestParseCopyFlags(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		copyInstruction string
+		options         []ParseOption
+		expected        []Copy
+		expectedErr     error
+	}{
+		"repeated --from, last wins": {
+			copyInstruction: "COPY --from=other --from=builder /usr/bin/binary /usr/bin/binary",
+			expected: []Copy{
+				{From: "builder", Sources: []string{"/usr/bin/binary"}, Destination: "/usr/bin/binary"},
+			},
+		},
+		"--from after other flags": {
+			copyInstruction: "COPY --chown=1001:0 --chmod=755 --link --from=builder /usr/bin/binary /usr/bin/",
+			expected: []Copy{
+				{From: "builder", Sources: []string{"/usr/bin/binary"}, Destination: "/usr/bin/", Link: true},
+			},
+		},
+		"--from before other flags": {
+			copyInstruction: "COPY --from=builder --exclude=*.md --parents /usr/share/doc/ /docs/",
+			expected: []Copy{
+				{From: "builder", Sources: []string{"/usr/share/doc/"}, Destination: "/docs/"},
+			},
+		},
+		"quoted --from value": {
+			copyInstruction: `COPY --from="builder" /usr/bin/binary /usr/bin/binary`,
+			expected: []Copy{
+				{From: "builder", Sources: []string{"/usr/bin/binary"}, Destination: "/usr/bin/binary"},
+			},
+		},
+		"--from from a build arg": {
+			copyInstruction: "COPY --from=${STAGE} /usr/bin/binary /usr/bin/binary",
+			options:         []ParseOption{WithArgs(map[string]string{"STAGE": "builder"})},
+			expected: []Copy{
+				{From: "builder", Sources: []string{"/usr/bin/binary"}, Destination: "/usr/bin/binary"},
+			},
+		},
+		"flag after sources is a source": {
+			copyInstruction: "COPY --from=builder /usr/bin/binary --from=other /usr/bin/",
+			expected: []Copy{
+				{From: "builder", Sources: []string{"/usr/bin/binary", "/--from=other"}, Destination: "/usr/bin/"},
+			},
+		},
+		"JSON form": {
+			copyInstruction: `COPY --link --from=builder ["/usr/bin/my binary", "/usr/bin/"]`,
+			expected: []Copy{
+				{From: "builder", Sources: []string{"/usr/bin/my binary"}, Destination: "/usr/bin/", Link: true},
+			},
+		},
+		"--link with a boolean value, last wins": {
+			copyInstruction: "COPY --link=true --link=false --from=builder /usr/bin/binary /usr/bin/binary",
+			expected: []Copy{
+				{From: "builder", Sources: []string{"/usr/bin/binary"}, Destination: "/usr/bin/binary"},
+			},
+		},
+		"invalid --link value": {
+			copyInstruction: "COPY --link=yes-please --from=builder /usr/bin/binary /usr/bin/binary",
+			expectedErr:     ErrParse,
+		},
+		"empty --from copies from the context": {
+			copyInstruction: "COPY --from= binary /usr/bin/binary",
+		},
+		"no --from copies from the context": {
+			copyInstruction: "COPY --chown=1001:0 binary /usr/bin/binary",
+		},
+		"--from without value": {
+			copyInstruction: "COPY --from builder /usr/bin/binary /usr/bin/binary",
+			expectedErr:     ErrParse,
+		},
+		"missing destination": {
+			copyInstruction: "COPY --from=builder /usr/bin/binary",
+			expectedErr:     ErrParse,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			containerfile := "FROM docker.io/library/fedora:latest AS builder\n" +
+				"FROM docker.io/library/fedora:latest AS other\n" +
+				"FROM scratch\n" +
+				test.copyInstruction + "\n"
+
+			actual, err := Parse(strings.NewReader(containerfile), test.options...)
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error wrapping %v, got: %v", test.expectedErr, err)
+			}
+			if test.expectedErr != nil {
+				return
+			}
+
+			final := actual.FinalStage()
+			if final == nil {
+				t.Fatalf("expected a final stage")
+			}
+			if diff := cmp.Diff(test.expected, final.Copies, cmpopts.EquateEmpty(), ignoreLines); diff != "" {
+				t.Errorf("Parse() copies mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseCopyJSONForm(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		copyInstruction string
+		expected        []Copy
+		expectedErr     error
+	}{
+		"paths with spaces": {
+			copyInstruction: `COPY --from=builder ["/a b/file", "/opt/my app/"]`,
+			expected: []Copy{
+				{From: "builder", Sources: []string{"/a b/file"}, Destination: "/opt/my app/"},
+			},
+		},
+		"multiple sources": {
+			copyInstruction: `COPY --from=builder ["/usr/bin/app", "lib/", "/opt/"]`,
+			expected: []Copy{
+				{From: "builder", Sources: []string{"/usr/bin/app", "/lib/"}, Destination: "/opt/"},
+			},
+		},
+		"variables are expanded": {
+			copyInstruction: `COPY --from=builder ["/opt/${APP}", "${PREFIX}/bin/"]`,
+			expected: []Copy{
+				{From: "builder", Sources: []string{"/opt/app"}, Destination: "/usr/local/bin/"},
+			},
+		},
+		"flag in the array is a source": {
+			copyInstruction: `COPY ["--from=builder", "/a b/file", "/dest/"]`,
+		},
+		"quoted path with spaces in shell form": {
+			copyInstruction: `COPY --from=builder "/a b/file" /dest/`,
+			expectedErr:     ErrParse,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			containerfile := "FROM docker.io/library/fedora:latest AS builder\n" +
+				"FROM scratch\n" +
+				test.copyInstruction + "\n"
+
+			actual, err := Parse(strings.NewReader(containerfile),
+				WithArgs(map[string]string{"APP": "app", "PREFIX": "/usr/local"}))
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error wrapping %v, got: %v", test.expectedErr, err)
+			}
+			if test.expectedErr != nil {
+				return
+			}
+
+			if diff := cmp.Diff(test.expected, actual.FinalStage().Copies, cmpopts.EquateEmpty(), ignoreLines); diff != "" {
+				t.Errorf("Parse() copies mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseMountTargets(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		instructions string
+		expected     []Mount
+	}{
+		"cache mount target": {
+			instructions: `RUN --mount=type=cache,target=/root/.cache/go-build go build`,
+			expected: []Mount{
+				{MountType: MountTypeCache, Target: "/root/.cache/go-build"},
+			},
+		},
+		"target aliases": {
+			instructions: `RUN --mount=type=cache,dst=/var/cache/dnf --mount=type=cache,destination=/root/.npm make`,
+			expected: []Mount{
+				{MountType: MountTypeCache, Target: "/var/cache/dnf"},
+				{MountType: MountTypeCache, Target: "/root/.npm"},
+			},
+		},
+		"variables are expanded": {
+			instructions: `RUN --mount=type=cache,target=${CACHE_DIR} make`,
+			expected: []Mount{
+				{MountType: MountTypeCache, Target: "/cache"},
+			},
+		},
+		"relative target after WORKDIR": {
+			instructions: "WORKDIR /src\nRUN --mount=type=cache,target=node_modules npm ci",
+			expected: []Mount{
+				{MountType: MountTypeCache, Target: "node_modules", Workdir: "/src"},
+			},
+		},
+		"bind mount source": {
+			instructions: "RUN --mount=from=docker.io/library/alpine:3.20,src=/etc/apk,target=/mnt " +
+				"--mount=type=bind,from=quay.io/tools:1,source=${CACHE_DIR},dst=/out make",
+			expected: []Mount{
+				{
+					FromRaw:   "docker.io/library/alpine:3.20",
+					Pullspec:  "docker.io/library/alpine:3.20",
+					MountType: MountTypeBind,
+					Source:    "/etc/apk",
+					Target:    "/mnt",
+				},
+				{
+					FromRaw:   "quay.io/tools:1",
+					Pullspec:  "quay.io/tools:1",
+					MountType: MountTypeBind,
+					Source:    "/cache",
+					Target:    "/out",
+				},
+			},
+		},
+		"mount without target": {
+			instructions: `RUN --mount=type=tmpfs make`,
+			expected: []Mount{
+				{MountType: MountTypeTmpfs},
+			},
+		},
+		"secret mount default target": {
+			instructions: `RUN --mount=type=secret,id=token --mount=type=secret,id=npmrc,target=/root/.npmrc make`,
+			expected: []Mount{
+				{MountType: MountTypeSecret, Target: "/run/secrets/token"},
+				{MountType: MountTypeSecret, Target: "/root/.npmrc"},
+			},
+		},
+		"ssh mount default targets": {
+			instructions: "RUN --mount=type=ssh --mount=type=ssh,target=/root/agent.sock --mount=type=ssh git fetch\n" +
+				"RUN --mount=type=ssh git pull",
+			expected: []Mount{
+				{MountType: MountTypeSSH, Target: "/run/buildkit/ssh_agent.0"},
+				{MountType: MountTypeSSH, Target: "/root/agent.sock"},
+				{MountType: MountTypeSSH, Target: "/run/buildkit/ssh_agent.2"},
+				{MountType: MountTypeSSH, Target: "/run/buildkit/ssh_agent.0"},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			containerfile := "FROM docker.io/library/fedora:latest\n" + test.instructions + "\n"
+
+			actual, err := Parse(strings.NewReader(containerfile), WithArgs(map[string]string{"CACHE_DIR": "/cache"}))
+			if err != nil {
+				t.Fatalf("Parsing failed: %v", err)
+			}
+			if diff := cmp.Diff(test.expected, actual.FinalStage().Mounts); diff != "" {
+				t.Errorf("Parse() mounts mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNormalizePullspec(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		pullspec string
+		expected string
+	}{
+		"short name":              {pullspec: "fedora", expected: "docker.io/library/fedora:latest"},
+		"short name with tag":     {pullspec: "fedora:42", expected: "docker.io/library/fedora:42"},
+		"docker hub organization": {pullspec: "anchore/syft", expected: "docker.io/anchore/syft:latest"},
+		"fully qualified":         {pullspec: "quay.io/org/image:1.0", expected: "quay.io/org/image:1.0"},
+		"uppercase registry":      {pullspec: "Quay.IO/org/image", expected: "quay.io/org/image:latest"},
+		"registry with port":      {pullspec: "localhost:5000/image", expected: "localhost:5000/image:latest"},
+		"localhost":               {pullspec: "localhost/image", expected: "localhost/image:latest"},
+		"digest": {
+			pullspec: "fedora@sha256:" + strings.Repeat("a", 64),
+			expected: "docker.io/library/fedora@sha256:" + strings.Repeat("a", 64),
+		},
+		"tag and digest": {
+			pullspec: "quay.io/org/image:1.0@sha256:" + strings.Repeat("a", 64),
+			expected: "quay.io/org/image:1.0@sha256:" + strings.Repeat("a", 64),
+		},
+		"scratch":              {pullspec: "scratch", expected: "scratch"},
+		"filesystem transport": {pullspec: "oci:layout", expected: "oci:layout"},
+		"docker transport":     {pullspec: "docker://fedora", expected: "docker://fedora"},
+		"daemon transport":     {pullspec: "docker-daemon:fedora:latest", expected: "docker-daemon:fedora:latest"},
+		"invalid reference":    {pullspec: "Fedora", expected: "Fedora"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if actual := NormalizePullspec(test.pullspec); actual != test.expected {
+				t.Errorf("NormalizePullspec(%q) = %q, want %q", test.pullspec, actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestStageByRef(t *testing.T) {
+	t.Parallel()
+
+	stages := []Stage{
+		{Alias: "builder", Base: "docker.io/library/fedora:latest", Index: 0},
+		{Alias: "tools", Base: "docker.io/library/alpine:latest", Index: 1},
+		{Alias: "2", Base: "scratch", Index: 2, Kind: StageKindFinal},
+	}
+	cf := Containerfile{Stages: stages}
+
+	tests := map[string]struct {
+		ref      string
+		expected *Stage
+	}{
+		"numeric index": {
+			ref:      "0",
+			expected: &cf.Stages[0],
+		},
+		"alias match": {
+			ref:      "tools",
+			expected: &cf.Stages[1],
+		},
+		"alias not found": {
+			ref:      "nonexistent",
+			expected: nil,
+		},
+		"numeric index out of bounds": {
+			ref:      "99",
+			expected: nil,
+		},
+		"negative numeric string": {
+			ref:      "-1",
+			expected: nil,
+		},
+		"numeric ref prefers index over alias": {
+			ref:      "1",
+			expected: &cf.Stages[1],
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			actual := cf.StageByRef(test.ref)
+			if diff := cmp.Diff(test.expected, actual); diff != "" {
+				t.Errorf("StageByRef(%q) mismatch (-want +got):\n%s", test.ref, diff)
+			}
+		})
+	}
+}
+
+func TestStageByIndex(t *testing.T) {
+	t.Parallel()
+
+	stages := []Stage{
+		{Alias: "builder", Base: "docker.io/library/fedora:latest", Index: 0},
+		{Alias: "tools", Base: "docker.io/library/alpine:latest", Index: 1},
+		{Alias: "2", Base: "scratch", Index: 2, Kind: StageKindFinal},
+	}
+	cf := Containerfile{Stages: stages}
+
+	tests := map[string]struct {
+		cf       Containerfile
+		index    int
+		expected *Stage
+	}{
+		"first stage": {
+			cf:       cf,
+			index:    0,
+			expected: &cf.Stages[0],
+		},
+		"last stage": {
+			cf:       cf,
+			index:    2,
 			expected: &cf.Stages[2],
 		},
 		"out of bounds": {
@@ -1178,3 +2018,365 @@ func TestStageByIndex(t *testing.T) {
 		})
 	}
 }
+
+func TestFinalStage(t *testing.T) {
+	t.Parallel()
+
+	cf := Containerfile{Stages: []Stage{
+		{Alias: "builder", Base: "docker.io/library/fedora:latest", Index: 0},
+		{Alias: "1", Base: "scratch", Index: 1, Kind: StageKindFinal},
+	}}
+
+	tests := map[string]struct {
+		cf       Containerfile
+		expected *Stage
+	}{
+		"multiple stages": {
+			cf:       cf,
+			expected: &cf.Stages[1],
+		},
+		"empty stages": {
+			cf:       Containerfile{},
+			expected: nil,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			actual := test.cf.FinalStage()
+			if diff := cmp.Diff(test.expected, actual); diff != "" {
+				t.Errorf("FinalStage() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestStageKindString(t *testing.T) {
+	t.Parallel()
+	tests := map[StageKind]string{
+		StageKindBuilder:  "builder",
+		StageKindFinal:    "final",
+		StageKindExternal: "external",
+		StageKind(42):     "StageKind(42)",
+	}
+
+	for kind, expected := range tests {
+		if actual := kind.String(); actual != expected {
+			t.Errorf("StageKind(%d).String() = %q, expected %q", int(kind), actual, expected)
+		}
+	}
+}
+
+func TestResolveRef(t *testing.T) {
+	t.Parallel()
+
+	cf := Containerfile{Stages: []Stage{
+		{Alias: "builder", Base: "docker.io/library/golang:1.22", Index: 0},
+		{Alias: "child", Base: "docker.io/library/golang:1.22", BaseRef: "builder", Index: 1},
+		{Alias: "builder", Base: "docker.io/library/node:20", Index: 2},
+		{Alias: "3", Base: "scratch", Index: 3, Kind: StageKindFinal},
+	}}
+
+	tests := map[string]struct {
+		ref      string
+		index    int
+		expected *Stage
+	}{
+		"alias defined once": {
+			ref:      "child",
+			index:    3,
+			expected: &cf.Stages[1],
+		},
+		"duplicate alias before redefinition": {
+			ref:      "builder",
+			index:    1,
+			expected: &cf.Stages[0],
+		},
+		"duplicate alias after redefinition": {
+			ref:      "builder",
+			index:    3,
+			expected: &cf.Stages[2],
+		},
+		"alias defined later": {
+			ref:      "child",
+			index:    1,
+			expected: nil,
+		},
+		"numeric index": {
+			ref:      "0",
+			index:    3,
+			expected: &cf.Stages[0],
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			actual := cf.ResolveRef(test.ref, test.index)
+			if diff := cmp.Diff(test.expected, actual); diff != "" {
+				t.Errorf("ResolveRef(%q, %d) mismatch (-want +got):\n%s", test.ref, test.index, diff)
+			}
+		})
+	}
+}
+
+func TestDuplicateAliases(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		stages   []Stage
+		expected []DuplicateAlias
+	}{
+		"no duplicates": {
+			stages: []Stage{
+				{Alias: "builder", Index: 0},
+				{Alias: "1", Index: 1, Kind: StageKindFinal},
+			},
+			expected: nil,
+		},
+		"duplicates in order of first definition": {
+			stages: []Stage{
+				{Alias: "tools", Index: 0},
+				{Alias: "builder", Index: 1},
+				{Alias: "builder", Index: 2},
+				{Alias: "tools", Index: 3},
+				{Alias: "builder", Index: 4, Kind: StageKindFinal},
+			},
+			expected: []DuplicateAlias{
+				{Alias: "tools", Indexes: []int{0, 3}},
+				{Alias: "builder", Indexes: []int{1, 2, 4}},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			actual := Containerfile{Stages: test.stages}.DuplicateAliases()
+			if diff := cmp.Diff(test.expected, actual, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("DuplicateAliases() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseStageNamePrecedence(t *testing.T) {
+	t.Parallel()
+	containerfile := `FROM docker.io/library/fedora:latest AS tools
+COPY --from=helm /usr/bin/helm /usr/bin/helm
+FROM docker.io/library/fedora:latest AS helm
+FROM helm AS chained
+FROM scratch
+COPY --from=helm /a /a
+COPY --from=docker.io/alpine/helm /b /b
+COPY --from=alpine/helm /c /c
+RUN --mount=type=bind,from=helm,target=/mnt true
+`
+	actual, err := Parse(strings.NewReader(containerfile))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// a reference before the stage is defined is to the image
+	expectedTools := []Copy{{
+		From: "docker.io/library/helm:latest", Sources: []string{"/usr/bin/helm"}, Destination: "/usr/bin/helm",
+		Type: CopyTypeExternal,
+	}}
+	if diff := cmp.Diff(expectedTools, actual.Stages[0].Copies, ignoreLines); diff != "" {
+		t.Errorf("copies of the first stage mismatch (-want +got):\n%s", diff)
+	}
+	if chained := actual.Stages[2]; chained.BaseRef != "helm" || chained.Base != "docker.io/library/fedora:latest" {
+		t.Errorf("expected a stage chained to helm, got base %q (ref %q)", chained.Base, chained.BaseRef)
+	}
+
+	expectedFinal := []Copy{
+		{From: "helm", Sources: []string{"/a"}, Destination: "/a", Type: CopyTypeBuilder},
+		{From: "docker.io/alpine/helm:latest", Sources: []string{"/b"}, Destination: "/b", Type: CopyTypeExternal},
+		{From: "docker.io/alpine/helm:latest", Sources: []string{"/c"}, Destination: "/c", Type: CopyTypeExternal},
+	}
+	final := actual.FinalStage()
+	if diff := cmp.Diff(expectedFinal, final.Copies, ignoreLines); diff != "" {
+		t.Errorf("copies of the final stage mismatch (-want +got):\n%s", diff)
+	}
+	if len(final.Mounts) != 1 || final.Mounts[0].Pullspec != "" {
+		t.Errorf("expected a mount of the helm stage, got %+v", final.Mounts)
+	}
+}
+
+func TestParseContextCopies(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		instructions string
+		expected     []Copy
+	}{
+		"copies from the build context": {
+			instructions: "WORKDIR /src\nCOPY go.mod go.sum ./\nCOPY . .",
+			expected: []Copy{
+				{Sources: []string{"go.mod", "go.sum"}, Destination: "./", Type: CopyTypeContext, Workdir: "/src"},
+				{Sources: []string{"."}, Destination: ".", Type: CopyTypeContext, Workdir: "/src"},
+			},
+		},
+		"variables are expanded": {
+			instructions: "ENV APP=web\nCOPY --chown=1001:0 ${APP}/ /srv/${APP}/",
+			expected: []Copy{
+				{Sources: []string{"web/"}, Destination: "/srv/web/", Type: CopyTypeContext},
+			},
+		},
+		"JSON form": {
+			instructions: `COPY ["my app/", "/opt/my app/"]`,
+			expected: []Copy{
+				{Sources: []string{"my app/"}, Destination: "/opt/my app/", Type: CopyTypeContext},
+			},
+		},
+		"linked copy": {
+			instructions: "COPY --link config.yaml /etc/app/",
+			expected: []Copy{
+				{Sources: []string{"config.yaml"}, Destination: "/etc/app/", Type: CopyTypeContext, Link: true},
+			},
+		},
+		"empty --from copies from the context": {
+			instructions: "COPY --from= config.yaml /etc/app/",
+			expected: []Copy{
+				{Sources: []string{"config.yaml"}, Destination: "/etc/app/", Type: CopyTypeContext},
+			},
+		},
+		"heredocs are not context files": {
+			instructions: "COPY <<EOF /etc/app.conf\nkey=value\nEOF\nCOPY <<-'END' app.yaml /etc/app/\n\tname: app\n\tEND",
+			expected: []Copy{
+				{Sources: []string{"app.yaml"}, Destination: "/etc/app/", Type: CopyTypeContext},
+			},
+		},
+		"flags of newer syntax are ignored": {
+			instructions: "COPY --parents --exclude=*.md --chmod=0755 ./bin/ /usr/local/",
+			expected: []Copy{
+				{Sources: []string{"./bin/"}, Destination: "/usr/local/", Type: CopyTypeContext},
+			},
+		},
+		"remote adds are not context copies": {
+			instructions: "ADD https://example.com/app.tar.gz /opt/",
+			expected:     nil,
+		},
+		"copies from stages and named contexts are not context copies": {
+			instructions: "COPY --from=builder /out/app /usr/bin/app\nCOPY --from=vendor /deps /deps",
+			expected:     nil,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			containerfile := "FROM docker.io/library/fedora:latest AS builder\n" +
+				"FROM scratch\n" +
+				test.instructions + "\n"
+
+			actual, err := Parse(strings.NewReader(containerfile),
+				WithBuildContexts(map[string]string{"vendor": "../vendor"}))
+			if err != nil {
+				t.Fatalf("Parsing failed: %v", err)
+			}
+			if diff := cmp.Diff(test.expected, actual.FinalStage().ContextCopies, cmpopts.EquateEmpty(), ignoreLines); diff != "" {
+				t.Errorf("Stage.ContextCopies mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseSyntaxDirective(t *testing.T) {
+	t.Parallel()
+	containerfile := `# syntax=docker/dockerfile:1.7-labs
+# check=skip=all
+FROM docker.io/library/golang:1.26 AS builder
+RUN --network=none <<EOF
+set -e
+go build -o /out/app .
+EOF
+FROM scratch
+COPY --from=builder --exclude=*.debug --parents /out/ /
+ADD --checksum=sha256:24454f830cdb571e2c4ad15481119c43b3cafd48dd869a9b2945d1036d1dc68d \
+    https://example.com/tools.tar.gz /opt/
+`
+	expected := Containerfile{Stages: []Stage{
+		{
+			Alias:   "builder",
+			Base:    "docker.io/library/golang:1.26",
+			BaseRef: "docker.io/library/golang:1.26",
+			Index:   0,
+		},
+		{
+			Alias:   "1",
+			Base:    "scratch",
+			BaseRef: "scratch",
+			Index:   1,
+			Kind:    StageKindFinal,
+			Copies: []Copy{
+				{From: "builder", Sources: []string{"/out/"}, Destination: "/", Type: CopyTypeBuilder},
+			},
+			RemoteAdds: []RemoteAdd{{
+				URL:         "https://example.com/tools.tar.gz",
+				Checksum:    "sha256:24454f830cdb571e2c4ad15481119c43b3cafd48dd869a9b2945d1036d1dc68d",
+				Destination: "/opt/",
+			}},
+		},
+	}}
+
+	actual, err := Parse(strings.NewReader(containerfile))
+	if err != nil {
+		t.Fatalf("Parsing failed: %v", err)
+	}
+	if diff := cmp.Diff(expected, actual, cmpopts.EquateEmpty(), ignoreEnv, ignoreArgUsage, ignoreLines); diff != "" {
+		t.Errorf("Parse() result mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseRemoteAdds(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		instructions string
+		expected     []RemoteAdd
+	}{
+		"url": {
+			instructions: "ADD https://example.com/tool /usr/bin/",
+			expected:     []RemoteAdd{{URL: "https://example.com/tool", Destination: "/usr/bin/"}},
+		},
+		"checksum and variables": {
+			instructions: "ARG VERSION=1.2\nWORKDIR /opt\n" +
+				"ADD --checksum=sha256:24454f830cdb571e2c4ad15481119c43b3cafd48dd869a9b2945d1036d1dc68d " +
+				"https://example.com/tool-${VERSION}.tar.gz tools/",
+			expected: []RemoteAdd{{
+				URL:         "https://example.com/tool-1.2.tar.gz",
+				Checksum:    "sha256:24454f830cdb571e2c4ad15481119c43b3cafd48dd869a9b2945d1036d1dc68d",
+				Destination: "tools/",
+				Workdir:     "/opt",
+			}},
+		},
+		"git repositories": {
+			instructions: "ADD git@github.com:konflux-ci/capo.git https://github.com/konflux-ci/capo.git#v1.0 /src/",
+			expected: []RemoteAdd{
+				{URL: "git@github.com:konflux-ci/capo.git", Destination: "/src/"},
+				{URL: "https://github.com/konflux-ci/capo.git#v1.0", Destination: "/src/"},
+			},
+		},
+		"local sources and ADD --from are not remote": {
+			instructions: "ADD vendor.tar.gz notes.txt /opt/\nADD --from=builder https://example.com/tool /usr/bin/",
+			expected:     nil,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			containerfile := "FROM docker.io/library/fedora:latest AS builder\n" +
+				"FROM scratch\n" +
+				test.instructions + "\n"
+
+			actual, err := Parse(strings.NewReader(containerfile))
+			if err != nil {
+				t.Fatalf("Parsing failed: %v", err)
+			}
+			if diff := cmp.Diff(test.expected, actual.FinalStage().RemoteAdds, cmpopts.EquateEmpty(), ignoreLines); diff != "" {
+				t.Errorf("Stage.RemoteAdds mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
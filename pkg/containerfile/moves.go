@@ -0,0 +1,188 @@
+package containerfile
+
+import (
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/openshift/imagebuilder"
+	"github.com/openshift/imagebuilder/dockerfile/parser"
+)
+
+// Move is a file move or copy within a stage by a simple RUN mv or cp
+// command, e.g. "RUN mv /build/out /app/bin". Content found at Destination
+// in the stage was at Source before the command.
+type Move struct {
+	// Path of the moved file or directory.
+	Source string
+	// Path the file or directory was moved to.
+	Destination string
+	// Current working directory for resolving relative paths of the move,
+	// with the same semantics as Copy.Workdir.
+	Workdir string
+	// Whether the move is declared by a hint of the user instead of parsed
+	// from a RUN command, see capo.Hints.
+	Declared bool
+}
+
+// Characters of shell syntax capo doesn't evaluate. Commands containing them
+// (pipes, redirections, subshells and command substitutions) are ignored.
+const unsupportedShellChars = "|<>&()`"
+
+// Characters of glob patterns. Moves of patterns are ignored, as the moved
+// paths are only known to the shell at build time.
+const globChars = "*?["
+
+// parseMoves returns the moves of files by the mv and cp commands of a RUN
+// instruction. Only simple commands are recognized: commands of the shell form
+// joined by "&&" or ";" (following "cd" commands), and the exec form.
+// Commands with other shell syntax or with wildcards in their paths are
+// ignored, the same as commands that can't be parsed, so moves never fail the
+// parse of a Containerfile.
+// If a move destination may be either the new path of the source or an
+// existing directory the source is moved into, both moves are returned.
+func parseMoves(node *parser.Node, workdir string, env []string) []Move {
+	if node.Next == nil {
+		return nil
+	}
+
+	if node.Attributes["json"] {
+		args := make([]string, 0)
+		for curr := node.Next; curr != nil; curr = curr.Next {
+			args = append(args, curr.Value)
+		}
+		return parseMoveCommand(args, workdir)
+	}
+
+	var moves []Move
+	script := strings.ReplaceAll(node.Next.Value, "&&", ";")
+	for _, command := range strings.FieldsFunc(script, func(r rune) bool { return r == ';' || r == '\n' }) {
+		if strings.ContainsAny(command, unsupportedShellChars) {
+			continue
+		}
+		args, err := imagebuilder.ProcessWords(command, env)
+		if err != nil || len(args) == 0 {
+			continue
+		}
+
+		if args[0] == "cd" && len(args) == 2 && args[1] != "-" {
+			workdir = joinWorkdir(workdir, args[1])
+			continue
+		}
+		moves = append(moves, parseMoveCommand(args, workdir)...)
+	}
+	return moves
+}
+
+// parseMoveCommand returns the moves of an mv or cp command with the passed
+// arguments, nil for other commands.
+func parseMoveCommand(args []string, workdir string) []Move {
+	if len(args) == 0 {
+		return nil
+	}
+	if name := path.Base(args[0]); name != "mv" && name != "cp" {
+		return nil
+	}
+
+	var operands []string
+	targetDir := ""
+	noTargetDir := false
+	options := true
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case !options || arg == "-" || !strings.HasPrefix(arg, "-"):
+			operands = append(operands, arg)
+		case arg == "--":
+			options = false
+		case strings.HasPrefix(arg, "--"):
+			name, value, hasValue := strings.Cut(arg[2:], "=")
+			switch name {
+			case "target-directory":
+				if !hasValue && i+1 < len(args) {
+					i++
+					value = args[i]
+				}
+				targetDir = value
+			case "no-target-directory":
+				noTargetDir = true
+			case "suffix":
+				if !hasValue {
+					i++
+				}
+			}
+		default:
+			// a cluster of short options, e.g. "-rf" or "-rt/app"
+			for j := 1; j < len(arg); j++ {
+				if arg[j] == 'T' {
+					noTargetDir = true
+					continue
+				}
+				if arg[j] != 't' && arg[j] != 'S' {
+					continue
+				}
+				value := arg[j+1:]
+				if value == "" && i+1 < len(args) {
+					i++
+					value = args[i]
+				}
+				if arg[j] == 't' {
+					targetDir = value
+				}
+				break
+			}
+		}
+	}
+
+	if strings.ContainsAny(targetDir, globChars) || slices.ContainsFunc(operands, func(op string) bool {
+		return strings.ContainsAny(op, globChars)
+	}) {
+		return nil
+	}
+
+	if targetDir != "" {
+		return movesInto(operands, targetDir, workdir)
+	}
+	if len(operands) < 2 {
+		return nil
+	}
+	sources, destination := operands[:len(operands)-1], operands[len(operands)-1]
+	if noTargetDir {
+		if len(sources) != 1 {
+			return nil
+		}
+		return []Move{{Source: sources[0], Destination: destination, Workdir: workdir}}
+	}
+	if len(sources) > 1 || strings.HasSuffix(destination, "/") {
+		return movesInto(sources, destination, workdir)
+	}
+
+	// the destination is either the new path of the source or an existing
+	// directory, which is only known at build time
+	return append(
+		[]Move{{Source: sources[0], Destination: destination, Workdir: workdir}},
+		movesInto(sources, destination, workdir)...,
+	)
+}
+
+// movesInto returns the moves of the sources into the directory dir.
+func movesInto(sources []string, dir string, workdir string) []Move {
+	moves := make([]Move, 0, len(sources))
+	for _, src := range sources {
+		name := path.Base(path.Clean(src))
+		if name == "." || name == ".." || name == "/" {
+			continue
+		}
+		moves = append(moves, Move{Source: src, Destination: path.Join(dir, name), Workdir: workdir})
+	}
+	return moves
+}
+
+// joinWorkdir returns the working directory after changing to dir from
+// workdir.
+func joinWorkdir(workdir, dir string) string {
+	if path.IsAbs(dir) {
+		return dir
+	}
+	return path.Join(workdir, dir)
+}
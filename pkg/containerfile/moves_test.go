@@ -0,0 +1,126 @@
+//go:build unit
+
+package containerfile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestParseMoves(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		// instructions of the builder stage
+		instructions string
+		expected     []Move
+	}{
+		"rename or move into directory": {
+			instructions: `RUN mv /build/out /app/bin`,
+			expected: []Move{
+				{Source: "/build/out", Destination: "/app/bin"},
+				{Source: "/build/out", Destination: "/app/bin/out"},
+			},
+		},
+		"multiple sources": {
+			instructions: `RUN cp -r /build/out /build/lib /app`,
+			expected: []Move{
+				{Source: "/build/out", Destination: "/app/out"},
+				{Source: "/build/lib", Destination: "/app/lib"},
+			},
+		},
+		"destination with trailing slash": {
+			instructions: `RUN mv /build/out /app/`,
+			expected: []Move{
+				{Source: "/build/out", Destination: "/app/out"},
+			},
+		},
+		"target directory": {
+			instructions: `RUN cp -a -t /app /build/out && mv --target-directory=/opt /build/lib`,
+			expected: []Move{
+				{Source: "/build/out", Destination: "/app/out"},
+				{Source: "/build/lib", Destination: "/opt/lib"},
+			},
+		},
+		"no target directory": {
+			instructions: `RUN mv -fT /build/out /app/bin`,
+			expected: []Move{
+				{Source: "/build/out", Destination: "/app/bin"},
+			},
+		},
+		"end of options": {
+			instructions: `RUN mv -- -out /app/`,
+			expected: []Move{
+				{Source: "-out", Destination: "/app/-out"},
+			},
+		},
+		"chained commands with cd": {
+			instructions: `RUN make && cd /build && mv out /app/ ; cd sub; cp lib /app/`,
+			expected: []Move{
+				{Source: "out", Destination: "/app/out", Workdir: "/build"},
+				{Source: "lib", Destination: "/app/lib", Workdir: "/build/sub"},
+			},
+		},
+		"relative paths after WORKDIR": {
+			instructions: "WORKDIR /src\nRUN mv out bin/",
+			expected: []Move{
+				{Source: "out", Destination: "bin/out", Workdir: "/src"},
+			},
+		},
+		"variables": {
+			instructions: "ENV OUT=/build/out\nRUN mv \"$OUT\" /app/",
+			expected: []Move{
+				{Source: "/build/out", Destination: "/app/out"},
+			},
+		},
+		"line continuation": {
+			instructions: "RUN mkdir -p /app && \\\n    mv /build/out /app/",
+			expected: []Move{
+				{Source: "/build/out", Destination: "/app/out"},
+			},
+		},
+		"exec form": {
+			instructions: `RUN ["/usr/bin/mv", "/build/out", "/app/"]`,
+			expected: []Move{
+				{Source: "/build/out", Destination: "/app/out"},
+			},
+		},
+		"moves in multiple RUN instructions": {
+			instructions: "RUN mv /build/out /tmp/\nRUN mv /tmp/out /app/",
+			expected: []Move{
+				{Source: "/build/out", Destination: "/tmp/out"},
+				{Source: "/tmp/out", Destination: "/app/out"},
+			},
+		},
+		"unsupported commands are ignored": {
+			instructions: `RUN mv /build/* /app/ && cp /a /b | tee log; mv $(ls) /app/; echo mv /a /b/; mv /a`,
+			expected:     nil,
+		},
+		"unparseable command is ignored": {
+			instructions: `RUN mv "/build/out /app/; mv /a /b/`,
+			expected: []Move{
+				{Source: "/a", Destination: "/b/a"},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			containerfile := "FROM docker.io/library/fedora:latest AS builder\n" +
+				test.instructions + "\n" +
+				"FROM scratch\n" +
+				"COPY --from=builder /app /app\n"
+
+			actual, err := Parse(strings.NewReader(containerfile))
+			if err != nil {
+				t.Fatalf("Parsing failed: %v", err)
+			}
+			if diff := cmp.Diff(test.expected, actual.Stages[0].Moves, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("Stage.Moves mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
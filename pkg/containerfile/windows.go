@@ -0,0 +1,89 @@
+// Paths of Windows Containerfiles, which the parser turns into the forward
+// slash container paths of Linux Containerfiles.
+
+package containerfile
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/openshift/imagebuilder/dockerfile/parser"
+)
+
+// drivePattern matches a drive letter at the start of a Windows path, e.g.
+// "C:\" or "c:/".
+var drivePattern = regexp.MustCompile(`^[A-Za-z]:([\\/]|$)`)
+
+// directivePattern matches a parser directive, e.g. "# escape=`".
+var directivePattern = regexp.MustCompile(`^#\s*([A-Za-z]+)\s*=\s*(\S+)$`)
+
+// Options of RUN --mount with a path value.
+var mountPathOptions = []string{"target", "dst", "destination", "source", "src"}
+
+// backtickEscape reports whether the escape parser directive at the top of
+// the containerfile content sets the backtick escape character, as Windows
+// Containerfiles do to use backslashes as path separators.
+func backtickEscape(content []byte) bool {
+	for line := range strings.Lines(string(content)) {
+		m := directivePattern.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			// directives are only recognized before anything else
+			return false
+		}
+		if strings.EqualFold(m[1], "escape") {
+			return m[2] == "`"
+		}
+	}
+	return false
+}
+
+// containerPath returns the path p as written in a containerfile as a
+// container path with forward slashes. A path with a drive letter (e.g.
+// "C:\app") is a Windows path, its drive is dropped, as an image has a single
+// root, and its backslashes are separators. With the backtick escape
+// character, backslashes of other paths are separators as well. Other paths
+// are returned unchanged, their backslashes are escapes.
+func containerPath(p string, backtick bool) string {
+	if drivePattern.MatchString(p) {
+		return "/" + strings.TrimLeft(strings.ReplaceAll(p[2:], `\`, "/"), "/")
+	}
+	if backtick {
+		return strings.ReplaceAll(p, `\`, "/")
+	}
+	return p
+}
+
+// normalizeWindowsPaths rewrites the paths of the COPY, ADD and WORKDIR
+// instructions and of the RUN --mount options of the parsed containerfile
+// with containerPath. It runs before their words are processed, which would
+// take the backslashes as escapes.
+func normalizeWindowsPaths(root *parser.Node, backtick bool) {
+	for _, child := range root.Children {
+		switch child.Value {
+		case "copy", "add", "workdir":
+			for n := child.Next; n != nil; n = n.Next {
+				n.Value = containerPath(n.Value, backtick)
+			}
+		case "run":
+			for i, fl := range child.Flags {
+				if opts, ok := strings.CutPrefix(fl, "--mount="); ok {
+					child.Flags[i] = "--mount=" + mountOptionPaths(opts, backtick)
+				}
+			}
+		}
+	}
+}
+
+// mountOptionPaths returns the RUN --mount options (without the --mount=
+// prefix) with their path values rewritten with containerPath.
+func mountOptionPaths(opts string, backtick bool) string {
+	res := strings.Split(opts, ",")
+	for i, opt := range res {
+		name, val, ok := strings.Cut(opt, "=")
+		if ok && slices.Contains(mountPathOptions, name) {
+			res[i] = name + "=" + containerPath(val, backtick)
+		}
+	}
+	return strings.Join(res, ",")
+}
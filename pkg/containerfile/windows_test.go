@@ -0,0 +1,98 @@
+//go:build unit
+
+package containerfile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestContainerPath(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		path     string
+		backtick bool
+		expected string
+	}{
+		"linux path":                  {path: "/usr/bin/app", expected: "/usr/bin/app"},
+		"linux escape kept":           {path: `/app/\$HOME`, expected: `/app/\$HOME`},
+		"drive letter":                {path: `C:\app\bin`, expected: "/app/bin"},
+		"drive letter with slashes":   {path: "c:/app/bin/", expected: "/app/bin/"},
+		"drive root":                  {path: `C:\`, expected: "/"},
+		"drive only":                  {path: "D:", expected: "/"},
+		"directory with trailing sep": {path: `C:\app\`, expected: "/app/"},
+		"relative with backtick":      {path: `bin\app.exe`, backtick: true, expected: "bin/app.exe"},
+		"relative without backtick":   {path: `bin\app.exe`, expected: `bin\app.exe`},
+		"url":                         {path: "https://example.com/a.tar.gz", expected: "https://example.com/a.tar.gz"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if actual := containerPath(test.path, test.backtick); actual != test.expected {
+				t.Errorf("containerPath(%q) = %q, expected %q", test.path, actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestBacktickEscape(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		content  string
+		expected bool
+	}{
+		"backtick":           {content: "# escape=`\nFROM scratch\n", expected: true},
+		"after other":        {content: "# syntax=docker/dockerfile:1\n#escape = `\nFROM scratch\n", expected: true},
+		"backslash":          {content: "# escape=\\\nFROM scratch\n", expected: false},
+		"no directive":       {content: "FROM scratch\n", expected: false},
+		"after a comment":    {content: "# a comment\n# escape=`\nFROM scratch\n", expected: false},
+		"after instructions": {content: "FROM scratch\n# escape=`\n", expected: false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if actual := backtickEscape([]byte(test.content)); actual != test.expected {
+				t.Errorf("backtickEscape() = %v, expected %v", actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestParseWindowsPaths(t *testing.T) {
+	t.Parallel()
+	containerfile := "# escape=`\n" +
+		"FROM mcr.microsoft.com/windows/servercore:ltsc2022 AS builder\n" +
+		"WORKDIR C:\\src\n" +
+		"RUN --mount=type=cache,target=C:\\cache build.cmd\n" +
+		"FROM mcr.microsoft.com/windows/nanoserver:ltsc2022\n" +
+		"COPY --from=builder C:\\src\\bin\\app.exe C:\\app\\\n" +
+		"COPY --from=builder bin\\tool.exe tools\\\n"
+
+	actual, err := Parse(strings.NewReader(containerfile))
+	if err != nil {
+		t.Fatalf("Parsing failed: %v", err)
+	}
+
+	expectedCopies := []Copy{
+		{
+			Sources: []string{"/src/bin/app.exe"}, Destination: "/app/", From: "builder",
+			Type: CopyTypeBuilder, Line: 6,
+		},
+		{
+			Sources: []string{"/bin/tool.exe"}, Destination: "tools/", From: "builder",
+			Type: CopyTypeBuilder, Line: 7,
+		},
+	}
+	if diff := cmp.Diff(expectedCopies, actual.FinalStage().Copies); diff != "" {
+		t.Errorf("Stage.Copies mismatch (-want +got):\n%s", diff)
+	}
+
+	mounts := actual.Stages[0].Mounts
+	if len(mounts) != 1 || mounts[0].Target != "/cache" || mounts[0].Workdir != "/src" {
+		t.Errorf("expected a cache mount at /cache in /src, got %+v", mounts)
+	}
+}
@@ -5,65 +5,87 @@ and store partial content from the build, for later syft scanning.
 package capo
 
 import (
-	"archive/tar"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"syscall"
 
 	"github.com/Masterminds/semver/v3"
 	"github.com/konflux-ci/capo/pkg/storageclient"
+	"github.com/opencontainers/go-digest"
 	"go.podman.io/storage"
 	"go.podman.io/storage/pkg/archive"
+	"golang.org/x/sys/unix"
 )
 
 const MinBuildahVersion = "1.44.0"
 
-var ErrImageNotFound = errors.New("[ERR_IMAGE_NOT_FOUND] image not found in buildah storage")
-var ErrImageMount = errors.New("[ERR_IMAGE_MOUNT] failed to mount image")
+var ErrImageNotFound = fmt.Errorf("[ERR_IMAGE_NOT_FOUND] image not found in buildah storage: %w", ErrResolve)
+var ErrImageMount = fmt.Errorf("[ERR_IMAGE_MOUNT] failed to mount image: %w", ErrStorage)
 var ErrIO = errors.New("[ERR_IO] I/O operation failed")
-var ErrStorage = errors.New("[ERR_STORAGE] container storage error")
-var ErrUnsupportedBuildahVersion = errors.New("[ERR_UNSUPPORTED_BUILDAH_VERSION] unsupported buildah version")
-var ErrMissingStageLabel = errors.New("[ERR_MISSING_STAGE_LABEL] intermediate image is missing stage label")
+var ErrUnsupportedBuildahVersion = fmt.Errorf(
+	"[ERR_UNSUPPORTED_BUILDAH_VERSION] unsupported buildah version: %w", ErrStorage,
+)
+var ErrMissingStageLabel = fmt.Errorf(
+	"[ERR_MISSING_STAGE_LABEL] intermediate image is missing stage label: %w", ErrStorage,
+)
+
+// savedContent describes content saved by getContent.
+type savedContent struct {
+	// Paths of saved builder/external content matching the sources.
+	builder []string
+	// Paths of saved intermediate content matching the sources.
+	intermediate []string
+	// Whether the intermediate image is squashed. All its content is then
+	// saved as intermediate content and no builder content is saved, as the
+	// two can't be told apart.
+	squashed bool
+}
 
 // getContent extracts builder base content and intermediate content for the
 // specified stage from buildah storage for later syft scanning.
 // Uses buildah stage labels (io.buildah.stage.name) to identify the
 // intermediate image for the given stage alias.
 // If the intermediateContentPath is empty, only builder/external content will
-// be saved.
+// be saved. If builder/external content is found, the package databases of
+// its image are saved to packageDBPath (see getPackageDBContent).
+// Content under cacheTargets is excluded from intermediate content, content
+// under secretTargets from all content (see removeMountContent).
 func (s *Scanner) getContent(
+	ctx context.Context,
 	pullspec string,
 	digestBase string,
 	stageAlias string,
 	sources []string,
+	cacheTargets []string,
+	secretTargets []string,
 	builderContentPath string,
 	intermediateContentPath string,
-) error {
+	packageDBPath string,
+) (savedContent, error) {
 	isSpecialBase := storageclient.IsSpecialBase(pullspec)
 	var builderImage *storage.Image
 
 	if !isSpecialBase {
 		// Special bases are not pullable or resolvable with Lookup
-		imgId, err := s.store.Lookup(storageclient.StripTransport(pullspec))
-		if err != nil {
-			imgId, err = s.store.Lookup(storageclient.StripTransport(digestBase))
-			if err != nil {
-				return fmt.Errorf("could not find image %q in buildah storage: %w", pullspec, ErrImageNotFound)
-			}
-		}
-		builderImage, err = s.store.Image(imgId)
+		var err error
+		builderImage, err = s.lookupBaseImage(pullspec, digestBase)
 		if err != nil {
-			return fmt.Errorf("could not find image %q in buildah storage: %w", pullspec, ErrImageNotFound)
+			return savedContent{}, err
 		}
 	}
 
+	var saved savedContent
 	if intermediateContentPath != "" {
 		// Special bases will have builderImage set as nil
-		intermediate, err := s.getIntermediateContent(
+		intermediate, isSquashed, err := s.getIntermediateContent(
+			ctx,
 			builderImage,
 			stageAlias,
 			sources,
@@ -71,21 +93,42 @@ func (s *Scanner) getContent(
 		)
 
 		if err != nil {
-			return err
+			return savedContent{}, err
 		}
+		intermediate, err = s.removeMountContent(
+			intermediateContentPath, intermediate, slices.Concat(cacheTargets, secretTargets),
+		)
+		if err != nil {
+			return savedContent{}, err
+		}
+		saved.squashed = isSquashed
+		saved.intermediate = intermediate
 		s.logContent("intermediate", intermediate, pullspec)
 	}
 
-	if !isSpecialBase {
+	if !isSpecialBase && !saved.squashed {
 		// Only standard bases have builder content. All content in special bases is treated as intermediate.
-		builderContent, err := s.getImageContent(builderImage, sources, builderContentPath)
+		builderContent, err := s.getImageContent(ctx, builderImage, sources, builderContentPath)
 		if err != nil {
-			return err
+			return savedContent{}, err
 		}
+		builderContent, err = s.removeMountContent(builderContentPath, builderContent, secretTargets)
+		if err != nil {
+			return savedContent{}, err
+		}
+		saved.builder = builderContent
 		s.logContent("builder", builderContent, pullspec)
+		if err := s.copyOSRelease(ctx, builderImage, builderContentPath); err != nil {
+			return savedContent{}, err
+		}
+		if len(builderContent) > 0 {
+			if err := s.getPackageDBContent(ctx, builderImage, packageDBPath); err != nil {
+				return savedContent{}, err
+			}
+		}
 	}
 
-	return nil
+	return saved, nil
 }
 
 func (s *Scanner) logContent(kind string, content []string, pullspec string) {
@@ -96,114 +139,214 @@ func (s *Scanner) logContent(kind string, content []string, pullspec string) {
 	}
 }
 
+// lookupBaseImage returns the base image of a package source from the store,
+// by its pullspec as written or, if buildah stored it by digest only, by its
+// pullspec with digest.
+func (s *Scanner) lookupBaseImage(pullspec string, digestBase string) (*storage.Image, error) {
+	imgId, err := s.store.Lookup(storageclient.StripTransport(pullspec))
+	if err != nil {
+		imgId, err = s.store.Lookup(storageclient.StripTransport(digestBase))
+		if err != nil {
+			return nil, fmt.Errorf("could not find image %q in buildah storage: %w", pullspec, ErrImageNotFound)
+		}
+	}
+	image, err := s.store.Image(imgId)
+	if err != nil {
+		return nil, fmt.Errorf("could not find image %q in buildah storage: %w", pullspec, ErrImageNotFound)
+	}
+	return image, nil
+}
+
 // getDescendantContent extracts intermediate content for a chained stage (node)
 // by diffing its intermediate image against the provided diff base image.
-// Returns the node's intermediate image and the list of extracted paths.
+// Returns the node's intermediate image, the list of extracted paths and
+// whether the intermediate image is squashed, in which case all its content
+// is extracted instead of the diff.
 // The returned image is passed as diff base to further descendants in the chain.
 // If the node has no intermediate image (empty stage), returns diffBase unchanged
 // so it propagates through to the next descendant.
+// The os-release file of the intermediate image is copied to the content for
+// distro context (see copyOSRelease).
 func (s *Scanner) getDescendantContent(
+	ctx context.Context,
 	stageAlias string,
 	diffBase *storage.Image,
 	sources []string,
 	contentPath string,
-) (*storage.Image, []string, error) {
+) (_ *storage.Image, _ []string, _ bool, err error) {
 	intermediateImage, found, err := s.findIntermediateImage(stageAlias)
 	if err != nil {
-		return nil, nil, fmt.Errorf("%w: failed to find intermediate image for %q: %w", ErrStorage, stageAlias, err)
+		return nil, nil, false, fmt.Errorf("%w: failed to find intermediate image for %q: %w", ErrStorage, stageAlias, err)
 	}
 	if !found {
 		// no intermediate image found for node - pass diffBase through unchanged
 		s.logger.Debug("no intermediate image found for chained stage, skipping", "stage", stageAlias)
-		return diffBase, nil, nil
+		return diffBase, nil, false, nil
+	}
+	defer func() {
+		if err == nil {
+			err = s.copyOSRelease(ctx, intermediateImage, contentPath)
+		}
+	}()
+
+	if !hasLayers(diffBase) {
+		// nothing to diff against, all content is of the stage
+		included, err := s.getImageContent(ctx, intermediateImage, sources, contentPath)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		return intermediateImage, included, false, nil
 	}
 
-	diffBaseLayer, err := s.store.Layer(diffBase.TopLayer)
+	diffBaseLayer, err := s.diffParent(intermediateImage, diffBase, stageAlias)
 	if err != nil {
-		return nil, nil, fmt.Errorf("%w: failed to get diff base layer: %w", ErrStorage, err)
+		return nil, nil, false, err
+	}
+	if diffBaseLayer == "" {
+		included, err := s.getImageContent(ctx, intermediateImage, sources, contentPath)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		return intermediateImage, included, true, nil
 	}
 
 	interLayer, err := s.store.Layer(intermediateImage.TopLayer)
 	if err != nil {
-		return nil, nil, fmt.Errorf("%w: failed to get intermediate layer: %w", ErrStorage, err)
+		return nil, nil, false, fmt.Errorf("%w: failed to get intermediate layer: %w", ErrStorage, err)
 	}
 
-	included, err := s.saveDiff(contentPath, interLayer.ID, diffBaseLayer.ID, sources)
+	included, err := s.saveDiff(ctx, contentPath, interLayer.ID, diffBaseLayer, sources)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, false, err
 	}
 
-	return intermediateImage, included, nil
+	return intermediateImage, included, false, nil
 }
 
-// IsPathUnderPattern reports whether path matches pattern exactly (via filepath.Match)
-// or is a descendant of a directory matching pattern.
-func isPathUnderPattern(pattern, path string) bool {
-	pattern = filepath.Clean(pattern)
-	path = filepath.Clean(path)
-
-	if pattern == "/" {
-		return true
+// isSquashed reports whether the layers of the intermediate image don't build
+// on top of the layers of its base image, which is the case for images built
+// with buildah build --squash. Layer diffs can't separate content added in
+// the stage from base content then, so a warning is recorded for the scan.
+func (s *Scanner) isSquashed(intermediate *storage.Image, base *storage.Image, stageAlias string) (bool, error) {
+	if !hasLayers(base) {
+		return false, nil
 	}
+	parent, err := s.diffParent(intermediate, base, stageAlias)
+	return parent == "" && err == nil, err
+}
 
-	if matched, _ := filepath.Match(pattern, path); matched {
-		return true
+// hasLayers reports whether the image has content. Images built FROM scratch
+// by stages without instructions adding content have no layers, nor do
+// special bases, which have no image (nil).
+func hasLayers(image *storage.Image) bool {
+	return image != nil && image.TopLayer != ""
+}
+
+// diffParent returns the layer of the intermediate image holding the content
+// of its base image, which the content added in the stage is diffed against:
+// the top layer of the base image if the intermediate image builds on it.
+// Committing layers of COPY --link and ADD --link, buildah may re-create the
+// layers of the base image with other IDs, so layers with the same
+// uncompressed digests as the layers of the base image, from the bottom up,
+// are matched as well. Returns "" with a warning if the intermediate image is
+// squashed, see isSquashed.
+func (s *Scanner) diffParent(intermediate *storage.Image, base *storage.Image, stageAlias string) (string, error) {
+	// layers of the intermediate image, from the top layer down
+	var chain []*storage.Layer
+	for layerID := intermediate.TopLayer; layerID != ""; {
+		if layerID == base.TopLayer {
+			return layerID, nil
+		}
+		layer, err := s.store.Layer(layerID)
+		if err != nil {
+			return "", fmt.Errorf("failed to get layer %s: %w: %w", layerID, err, ErrStorage)
+		}
+		chain = append(chain, layer)
+		layerID = layer.Parent
 	}
 
-	patternParts := strings.Split(pattern, "/")
-	pathParts := strings.Split(path, "/")
-	if len(pathParts) > len(patternParts) {
-		prefix := strings.Join(pathParts[:len(patternParts)], "/")
-		if matched, _ := filepath.Match(pattern, prefix); matched {
-			return true
+	var baseChain []*storage.Layer
+	for layerID := base.TopLayer; layerID != ""; {
+		layer, err := s.store.Layer(layerID)
+		if err != nil {
+			return "", fmt.Errorf("failed to get layer %s: %w: %w", layerID, err, ErrStorage)
 		}
+		baseChain = append(baseChain, layer)
+		layerID = layer.Parent
+	}
+	if parent := matchLayerDigests(chain, baseChain); parent != "" {
+		s.logger.Debug("matched base layers by digest, the stage may have linked copies",
+			"stage", stageAlias, "intermediate", intermediate.ID, "base", base.ID, "layer", parent)
+		return parent, nil
 	}
 
-	return false
+	s.warn(WarnSquashedImage, fmt.Sprintf(
+		"intermediate image %s of stage %q does not contain the layers of its base image (built with --squash?), "+
+			"all its content is attributed with origin type %q",
+		intermediate.ID, stageAlias, originTypeSquashed,
+	))
+	return "", nil
 }
 
-func includes(sources []string, path string) bool {
-	if !filepath.IsAbs(path) {
-		path = "/" + path
-	}
-
-	for _, src := range sources {
-		if isPathUnderPattern(src, path) {
-			return true
+// matchLayerDigests returns the ID of the layer of chain whose layers up to
+// the bottom have the uncompressed digests of the layers of baseChain, or ""
+// if there is none. Both chains are from the top layer down. Layers without
+// a known digest never match.
+func matchLayerDigests(chain []*storage.Layer, baseChain []*storage.Layer) string {
+	if len(baseChain) == 0 || len(chain) < len(baseChain) {
+		return ""
+	}
+	// the base chain must be the bottom of the chain
+	offset := len(chain) - len(baseChain)
+	for i, baseLayer := range baseChain {
+		layer := chain[offset+i]
+		if baseLayer.UncompressedDigest == "" || layer.UncompressedDigest != baseLayer.UncompressedDigest {
+			return ""
 		}
 	}
-
-	return false
+	return chain[offset].ID
 }
 
+// getImageContent saves the content of the image at the paths matching the
+// sources to contentPath with the extractor of the scanner (see
+// WithExtractor). Images without layers have no content to save.
 func (s *Scanner) getImageContent(
+	ctx context.Context,
+	image *storage.Image,
+	sources []string,
+	contentPath string,
+) ([]string, error) {
+	if !hasLayers(image) {
+		return []string{}, nil
+	}
+	return s.newExtractor().ExtractPaths(ctx, image, sources, contentPath)
+}
+
+// copyMountedContent copies the content of the mounted image at the paths
+// matching the sources to contentPath, see mountExtractor.
+func (s *Scanner) copyMountedContent(
+	ctx context.Context,
 	image *storage.Image,
 	sources []string,
 	contentPath string,
 ) (included []string, err error) {
-	mountPath, err := s.store.MountImage(image.ID, []string{}, "")
+	mountPath, err := s.mounts.acquire(image.ID)
 	if err != nil {
-		return included, fmt.Errorf("could not mount image: %w: %w", err, ErrImageMount)
+		return included, err
 	}
-
-	defer func() {
-		if _, unmountErr := s.store.UnmountImage(image.ID, false); unmountErr != nil {
-			err = fmt.Errorf("failed to unmount image: %w: %w", unmountErr, ErrStorage)
-		}
-	}()
+	defer s.mounts.release(image.ID)
 
 	for _, src := range sources {
-		full := path.Join(mountPath, src)
-		matches, err := filepath.Glob(full)
+		imagePaths, err := s.globImage(image.ID, mountPath, src)
 		if err != nil {
-			return included, fmt.Errorf("failed to glob pattern %q: %w: %w", src, err, ErrIO)
+			return included, err
 		}
 
-		if len(matches) == 0 {
-			continue
-		}
-
-		for _, match := range matches {
+		for _, imagePath := range imagePaths {
+			if err := ctx.Err(); err != nil {
+				return included, fmt.Errorf("copy of %q canceled: %w", imagePath, err)
+			}
+			match := filepath.Join(mountPath, filepath.FromSlash(imagePath))
 			fInfo, err := os.Stat(match)
 			if err != nil {
 				return included, fmt.Errorf("failed to stat %q: %w: %w", match, err, ErrIO)
@@ -213,16 +356,23 @@ func (s *Scanner) getImageContent(
 			if err != nil {
 				return included, fmt.Errorf("failed to get relative path for %q: %w: %w", match, err, ErrIO)
 			}
-			dest := path.Join(contentPath, relPath)
+			dest, err := secureJoin(contentPath, relPath)
+			if err != nil {
+				if errors.Is(err, ErrPathTraversal) {
+					s.matchTrace.excluded(imagePath, traceExcludedTraversal)
+					s.logger.Warn("skipping image content outside of content root", "path", imagePath, "error", err)
+					continue
+				}
+				return included, err
+			}
+			s.matchTrace.matched(imagePath, src)
 
 			if fInfo.IsDir() {
-				// CopyFS also copies and follows symlinks even if they're outside the specified source,
-				// This is not a problem for us because Syft ignores symbolic links.
-				if err := os.CopyFS(dest, os.DirFS(match)); err != nil {
-					return included, fmt.Errorf("failed to copy directory %q to %q: %w: %w", match, dest, err, ErrIO)
+				if err := s.copyTree(ctx, match, dest); err != nil {
+					return included, fmt.Errorf("failed to copy directory %q to %q: %w", match, dest, err)
 				}
 			} else if fInfo.Mode().IsRegular() {
-				if err := copyFile(match, dest); err != nil {
+				if err := s.copyFile(match, dest, fInfo.Mode()); err != nil {
 					return included, err
 				}
 			}
@@ -234,81 +384,338 @@ func (s *Scanner) getImageContent(
 	return included, err
 }
 
-func copyFile(src string, dest string) (err error) {
+// globImage returns absolute paths in the mounted image that match the source
+// pattern, with the same semantics as filepath.Glob over the mount.
+// Literal sources are checked directly, patterns with wildcards are matched
+// against the image content index, so the image is walked at most once
+// regardless of the number of wildcard sources.
+// A file whose name equals the source is matched as well, so names containing
+// glob metacharacters (e.g. "/app/[id].js") are found even though the pattern
+// doesn't match them (or is malformed).
+func (s *Scanner) globImage(imageID string, mountPath string, src string) ([]string, error) {
+	pattern := path.Clean("/" + src)
+	// like filepath.Glob, ignore file system errors and report no match
+	_, err := os.Lstat(filepath.Join(mountPath, filepath.FromSlash(pattern)))
+	literalExists := err == nil
+
+	if !strings.ContainsAny(pattern, `*?[\`) {
+		if !literalExists {
+			return nil, nil
+		}
+		return []string{pattern}, nil
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		if literalExists {
+			return []string{pattern}, nil
+		}
+		return nil, fmt.Errorf("failed to glob pattern %q: %w: %w", src, err, ErrIO)
+	}
+
+	index, err := s.mounts.index(imageID, pathDepth(pattern))
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]string, 0)
+	for _, imagePath := range index {
+		// the image root itself is only matched by a literal "/" source
+		if imagePath == "/" {
+			continue
+		}
+		if matched, _ := path.Match(pattern, imagePath); matched {
+			matches = append(matches, imagePath)
+		}
+	}
+
+	if literalExists && !slices.Contains(matches, pattern) {
+		matches = append(matches, pattern)
+	}
+
+	return matches, nil
+}
+
+// pathDepth returns the number of segments in a clean absolute path.
+func pathDepth(p string) int {
+	if p == "/" {
+		return 0
+	}
+	return strings.Count(p, "/")
+}
+
+// indexTree lists all paths under root up to the passed depth as absolute
+// slash-separated paths relative to root, including root itself as "/".
+// Symbolic links are listed but not followed. Unreadable directories are
+// skipped, mirroring filepath.Glob.
+func indexTree(root string, depth int) ([]string, error) {
+	paths := make([]string, 0, 1024)
+	err := filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			if p == root {
+				return err
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path for %q: %w", p, err)
+		}
+		imagePath := path.Clean("/" + filepath.ToSlash(rel))
+		paths = append(paths, imagePath)
+
+		if d.IsDir() && pathDepth(imagePath) >= depth {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to index %q: %w: %w", root, err, ErrIO)
+	}
+
+	return paths, nil
+}
+
+// copyTree copies the directory tree at src to dest with modes bounded by
+// extractMode. Symbolic links are copied as links even if they point outside
+// of src, which is not a problem because Syft ignores them. Special files are
+// skipped. Every destination is checked with secureJoin, so links copied from
+// the image can't redirect later writes outside of dest. The copy stops
+// between entries when ctx is done.
+func (s *Scanner) copyTree(ctx context.Context, src string, dest string) error {
+	return filepath.WalkDir(src, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to walk %q: %w: %w", p, err, ErrIO)
+		}
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("copy of %q canceled: %w", src, err)
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path for %q: %w: %w", p, err, ErrIO)
+		}
+		target, err := secureJoin(dest, rel)
+		if err != nil {
+			if !errors.Is(err, ErrPathTraversal) {
+				return err
+			}
+			s.logger.Warn("skipping image content outside of content root", "path", p, "error", err)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		switch {
+		case d.IsDir():
+			info, err := d.Info()
+			if err != nil {
+				return fmt.Errorf("failed to stat %q: %w: %w", p, err, ErrIO)
+			}
+			return s.mkdir(target, info.Mode())
+		case d.Type().IsRegular():
+			info, err := d.Info()
+			if err != nil {
+				return fmt.Errorf("failed to stat %q: %w: %w", p, err, ErrIO)
+			}
+			return s.copyFile(p, target, info.Mode())
+		case d.Type()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return fmt.Errorf("failed to read symbolic link %q: %w: %w", p, err, ErrIO)
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return fmt.Errorf("failed to create symbolic link %q: %w: %w", target, err, ErrIO)
+			}
+			if s.fileOwners != nil {
+				if info, err := d.Info(); err == nil {
+					s.fileOwners.recordStat(target, info)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// copyFile copies the regular file at src to dest with the original mode
+// bounded by extractMode, creating missing parent directories. The file is
+// cloned if the filesystem supports it (see cloneFile), and copied otherwise.
+func (s *Scanner) copyFile(src string, dest string, mode os.FileMode) (err error) {
 	reader, err := os.Open(src)
 	if err != nil {
 		return fmt.Errorf("failed to open file %q: %w: %w", src, err, ErrIO)
 	}
 	defer func() {
-		err = reader.Close()
+		_ = reader.Close()
 	}()
 
-	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(dest), s.extractMode(0o755, true)); err != nil {
 		return fmt.Errorf("failed to create directory %q: %w: %w", filepath.Dir(dest), err, ErrIO)
 	}
-	writer, err := os.Create(dest)
+	mode = s.extractMode(mode, false)
+	writer, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
 	if err != nil {
 		return fmt.Errorf("failed to create file %q: %w: %w", dest, err, ErrIO)
 	}
 	defer func() {
-		err = writer.Close()
+		if closeErr := writer.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close file %q: %w: %w", dest, closeErr, ErrIO)
+		}
 	}()
 
-	if _, err = io.Copy(writer, reader); err != nil {
-		return fmt.Errorf("failed to copy file content: %w: %w", err, ErrIO)
+	if cloneErr := cloneFile(writer, reader); cloneErr != nil {
+		if _, err = io.Copy(writer, reader); err != nil {
+			return fmt.Errorf("failed to copy file content: %w: %w", err, ErrIO)
+		}
+	}
+	// the mode passed to OpenFile is subject to the process umask and
+	// ignored for existing files
+	if err := os.Chmod(dest, mode); err != nil {
+		return fmt.Errorf("failed to set mode of %q: %w: %w", dest, err, ErrIO)
+	}
+	if s.fileOwners != nil {
+		if info, err := reader.Stat(); err == nil {
+			s.fileOwners.recordStat(dest, info)
+		}
 	}
 	return nil
 }
 
+// errCrossDevice is returned by cloneFile for files on different devices.
+var errCrossDevice = errors.New("files are on different devices")
+
+// cloneFile makes dest share the data blocks of src (a reflink), so the
+// content isn't copied until either is written. Only filesystems supporting
+// it (e.g. btrfs and XFS) can clone files, and only within the filesystem, so
+// files on another device than dest (e.g. of images mounted by overlay) aren't
+// cloned and errCrossDevice is returned. dest must be empty.
+func cloneFile(dest *os.File, src *os.File) error {
+	srcInfo, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	destInfo, err := dest.Stat()
+	if err != nil {
+		return err
+	}
+	srcStat, srcOK := srcInfo.Sys().(*syscall.Stat_t)
+	destStat, destOK := destInfo.Sys().(*syscall.Stat_t)
+	if !srcOK || !destOK || srcStat.Dev != destStat.Dev {
+		return errCrossDevice
+	}
+	return unix.IoctlFileClone(int(dest.Fd()), int(src.Fd()))
+}
+
 // Stores intermediate content for the specified image to the path directory.
 // Uses buildah stage labels (io.buildah.stage.name) to find the intermediate
 // image for the given stage, then calculates a diff between the intermediate
 // top layer and the builder base image top layer.
 //
-// When builderImage is nil (special bases like scratch or oci-archive), mounts
-// the intermediate image and reads all matching content. Filesystem-transport
-// bases are local files with non-pullable identifiers, so all their content
-// is treated uniformly as intermediate.
+// When builderImage is nil (special bases like scratch or oci-archive) or has
+// no layers (e.g. an image built FROM scratch without content), reads all
+// matching content of the intermediate image. Filesystem-transport bases are
+// local files with non-pullable identifiers, so all their content is treated
+// uniformly as intermediate.
+//
+// Returns true if the intermediate image is squashed (see isSquashed), all
+// its matching content is read then as well.
+//
+// The os-release file of the intermediate image is copied to the content for
+// distro context (see copyOSRelease).
 func (s *Scanner) getIntermediateContent(
+	ctx context.Context,
 	builderImage *storage.Image,
 	stageAlias string,
 	sources []string,
 	path string,
-) ([]string, error) {
+) (_ []string, _ bool, err error) {
 	// Find intermediate image using buildah stage labels
 	intermediateImage, found, err := s.findIntermediateImage(stageAlias)
 	if err != nil {
-		return []string{}, fmt.Errorf("failed to find intermediate image: %w: %w", err, ErrStorage)
+		return []string{}, false, fmt.Errorf("failed to find intermediate image: %w: %w", err, ErrStorage)
 	}
 	if !found {
 		// No intermediate image for this stage
-		return []string{}, nil
+		return []string{}, false, nil
 	}
+	defer func() {
+		if err == nil {
+			err = s.copyOSRelease(ctx, intermediateImage, path)
+		}
+	}()
 
-	if builderImage == nil {
-		// Scratch or unresolvable (special) bases
-		return s.getImageContent(intermediateImage, sources, path)
+	if !hasLayers(builderImage) {
+		// Scratch or unresolvable (special) bases, and bases without content
+		included, err := s.getImageContent(ctx, intermediateImage, sources, path)
+		return included, false, err
 	}
 
-	builderLayer, err := s.store.Layer(builderImage.TopLayer)
+	builderLayer, err := s.diffParent(intermediateImage, builderImage, stageAlias)
 	if err != nil {
-		return []string{}, fmt.Errorf("failed to get builder layer: %w: %w", err, ErrStorage)
+		return []string{}, false, err
+	}
+	if builderLayer == "" {
+		included, err := s.getImageContent(ctx, intermediateImage, sources, path)
+		return included, true, err
 	}
 
 	interLayer, err := s.store.Layer(intermediateImage.TopLayer)
 	if err != nil {
-		return []string{}, fmt.Errorf("failed to get intermediate layer: %w: %w", err, ErrStorage)
+		return []string{}, false, fmt.Errorf("failed to get intermediate layer: %w: %w", err, ErrStorage)
 	}
 
-	included, err := s.saveDiff(path, interLayer.ID, builderLayer.ID, sources)
+	included, err := s.saveDiff(ctx, path, interLayer.ID, builderLayer, sources)
 	if err != nil {
-		return []string{}, err
+		return []string{}, false, err
+	}
+
+	return included, false, nil
+}
+
+// removeMountContent removes the content under the targets of RUN mounts
+// from the content saved to contentPath and returns the included paths without
+// those under the targets. Cache mount contents never ship in the image, so
+// nothing found at their targets is attributed to the stage. Secrets and ssh
+// agent sockets are removed as a defense in depth, in case a RUN command
+// leaked them into the image.
+func (s *Scanner) removeMountContent(contentPath string, included []string, mountTargets []string) ([]string, error) {
+	// a mount can't cover the whole image
+	targets := slices.DeleteFunc(slices.Clone(mountTargets), func(target string) bool {
+		return path.Clean(target) == "/"
+	})
+	if len(targets) == 0 || len(included) == 0 {
+		return included, nil
+	}
+
+	for _, target := range targets {
+		// the target is a container path, the saved content a host path
+		targetPath, err := secureJoin(contentPath, filepath.FromSlash(strings.TrimPrefix(path.Clean(target), "/")))
+		if errors.Is(err, ErrPathTraversal) {
+			// content under a symbolic link isn't saved under the target
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := os.RemoveAll(targetPath); err != nil {
+			return nil, fmt.Errorf("failed to remove mount content %q: %w: %w", targetPath, err, ErrIO)
+		}
 	}
 
-	return included, nil
+	res := make([]string, 0, len(included))
+	for _, p := range included {
+		if Includes(targets, p) {
+			s.logger.Debug("excluded mount content", "path", p)
+			continue
+		}
+		res = append(res, p)
+	}
+	return res, nil
 }
 
 func (s *Scanner) saveDiff(
+	ctx context.Context,
 	dest string,
 	layerId string,
 	parentId string,
@@ -324,63 +731,40 @@ func (s *Scanner) saveDiff(
 		return []string{}, fmt.Errorf("failed to compute layer diff: %w: %w", err, ErrStorage)
 	}
 	defer func() {
-		err = diff.Close()
-	}()
-
-	included = make([]string, 0, 16)
-	reader := tar.NewReader(diff)
-	for {
-		header, err := reader.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return []string{}, fmt.Errorf("failed to read tar header: %w: %w", err, ErrIO)
-		}
-
-		if !includes(sources, header.Name) {
-			continue
+		if closeErr := diff.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close layer diff: %w: %w", closeErr, ErrStorage)
 		}
+	}()
 
-		included = append(included, header.Name)
-
-		target := filepath.Join(dest, header.Name)
-
-		switch header.Typeflag {
-		case tar.TypeDir:
-			if err := os.MkdirAll(target, 0755); err != nil {
-				return []string{}, fmt.Errorf("failed to create directory %q: %w: %w", target, err, ErrIO)
-			}
-		case tar.TypeReg:
-			// sometimes the archive does not have headers for directories
-			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
-				return []string{}, fmt.Errorf("failed to create directory %q: %w: %w", filepath.Dir(target), err, ErrIO)
-			}
-			f, err := os.Create(target)
-			if err != nil {
-				return []string{}, fmt.Errorf("failed to create file %q: %w: %w", target, err, ErrIO)
-			}
-
-			if _, err := io.Copy(f, reader); err != nil {
-				_ = f.Close()
-				return []string{}, fmt.Errorf("failed to copy file content: %w: %w", err, ErrIO)
-			}
-			_ = f.Close()
-		}
-	}
-
-	return included, nil
+	return s.extractTar(ctx, diff, dest, sources)
 }
 
-// findIntermediateImage looks up an intermediate image by stage alias.
-// Iterates all unnamed images in the store, validates buildah version and
+// findIntermediateImage looks up an intermediate image by stage alias, in the
+// stage report if configured (see WithStageReport).
+// Otherwise, or if the report has no image for the stage, iterates all
+// unnamed images in the store, validates buildah version and
 // stage label presence on each, but defers errors until the full iteration
 // completes, so a valid match is returned even if other images in the
 // store are invalid. Returns all accumulated errors only if no match
-// is found.
+// is found. If more images match, the most recently created one is returned.
 func (s *Scanner) findIntermediateImage(
 	stageAlias string,
 ) (*storage.Image, bool, error) {
+	if s.stageReport != nil {
+		if id, ok := s.stageReport.StageImage(stageAlias); ok {
+			image, err := s.store.Image(id)
+			if err != nil {
+				return nil, false, fmt.Errorf(
+					"image %s of stage %q in the stage report: %w: %w", id, stageAlias, err, ErrStorage,
+				)
+			}
+			s.logger.Debug("found intermediate image in stage report", "imageID", image.ID, "stage", stageAlias)
+			return image, true, nil
+		}
+		if layer, ok := s.stageReport.StageTopLayer(stageAlias); ok {
+			return s.findImageByTopLayer(stageAlias, layer)
+		}
+	}
 
 	images, err := s.store.Images()
 	if err != nil {
@@ -388,6 +772,7 @@ func (s *Scanner) findIntermediateImage(
 	}
 
 	var errs []error
+	var found *storage.Image
 	for i := range images {
 		if len(images[i].Names) != 0 {
 			continue
@@ -422,12 +807,18 @@ func (s *Scanner) findIntermediateImage(
 			continue
 		}
 
-		if stageName == stageAlias {
-			s.logger.Debug("found intermediate image", "imageID", images[i].ID, "stage", stageAlias)
-			return &images[i], true, nil
+		// A stage alias defined more than once labels the intermediate images
+		// of all its definitions the same, the last definition is built last.
+		if stageName == stageAlias && (found == nil || images[i].Created.After(found.Created)) {
+			found = &images[i]
 		}
 	}
 
+	if found != nil {
+		s.logger.Debug("found intermediate image", "imageID", found.ID, "stage", stageAlias)
+		return found, true, nil
+	}
+
 	if len(errs) > 0 {
 		return nil, false, fmt.Errorf(
 			"no intermediate image found for stage %q; encountered %d problematic image(s) in storage:\n%w: %w",
@@ -442,6 +833,53 @@ func (s *Scanner) findIntermediateImage(
 	return nil, false, nil
 }
 
+// findImageByTopLayer looks up the snapshot of a stage of a build committing
+// no images for stages (see stagereport.FromProvenance): the most recently
+// created image in the store with a top layer of the compressed or
+// uncompressed digest. The image has no buildah labels to check.
+func (s *Scanner) findImageByTopLayer(stageAlias string, layerDigest string) (*storage.Image, bool, error) {
+	dig, err := digest.Parse(layerDigest)
+	if err != nil {
+		return nil, false, fmt.Errorf(
+			"top layer %q of stage %q in the stage report: %w: %w", layerDigest, stageAlias, err, ErrStorage,
+		)
+	}
+
+	topLayers := make(map[string]bool)
+	for _, lookup := range []func(digest.Digest) ([]storage.Layer, error){
+		s.store.LayersByCompressedDigest, s.store.LayersByUncompressedDigest,
+	} {
+		layers, err := lookup(dig)
+		if err != nil && !errors.Is(err, storage.ErrLayerUnknown) {
+			return nil, false, fmt.Errorf("failed to look up layer %s: %w: %w", dig, err, ErrStorage)
+		}
+		for _, layer := range layers {
+			topLayers[layer.ID] = true
+		}
+	}
+
+	images, err := s.store.Images()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to list images: %w: %w", err, ErrStorage)
+	}
+	var found *storage.Image
+	for i := range images {
+		if topLayers[images[i].TopLayer] && (found == nil || images[i].Created.After(found.Created)) {
+			found = &images[i]
+		}
+	}
+	if found == nil {
+		s.logger.Debug("no image with the top layer of the stage in the stage report",
+			"stage", stageAlias, "layer", dig,
+			"hint", "pull or load the snapshot of the stage, e.g. built with --target, into the storage",
+		)
+		return nil, false, nil
+	}
+	s.logger.Debug("found intermediate image by top layer in stage report",
+		"imageID", found.ID, "stage", stageAlias, "layer", dig)
+	return found, true, nil
+}
+
 func checkBuildahVersionFromImage(labels map[string]string) error {
 	buildahVersionStr, ok := labels["io.buildah.version"]
 	if !ok {
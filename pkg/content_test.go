@@ -3,215 +3,125 @@
 package capo
 
 import (
+	"archive/tar"
+	"bytes"
+	"context"
 	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/konflux-ci/capo/pkg/capotest"
+	"github.com/konflux-ci/capo/pkg/stagereport"
+
+	"go.podman.io/storage"
 )
 
-func TestIncludes(t *testing.T) {
+func TestRemoveMountContent(t *testing.T) {
 	t.Parallel()
+	contentFiles := []string{
+		"app/main.js",
+		"app/node_modules/left-pad/package.json",
+		"app/node_modules_backup/package.json",
+		"root/.cache/go-build/00/abc",
+		"run/secrets/other",
+		"run/secrets/token",
+	}
 	tests := map[string]struct {
-		sources []string
-		path    string
-		want    bool
+		included         []string
+		mountTargets     []string
+		expectedIncluded []string
+		expectedFiles    []string
 	}{
-		// Exact matches
-		"exact match absolute path": {
-			sources: []string{"/opt"},
-			path:    "/opt",
-			want:    true,
-		},
-		"exact match file": {
-			sources: []string{"/opt/go.mod"},
-			path:    "/opt/go.mod",
-			want:    true,
-		},
-		"no match different path": {
-			sources: []string{"/opt"},
-			path:    "/usr",
-			want:    false,
-		},
-
-		// Subdirectory matching (path is under source)
-		"path under source directory": {
-			sources: []string{"/opt"},
-			path:    "/opt/go.mod",
-			want:    true,
-		},
-		"path deeply nested under source": {
-			sources: []string{"/opt"},
-			path:    "/opt/app/sub/go.mod",
-			want:    true,
-		},
-		"path under nested source": {
-			sources: []string{"/opt/app"},
-			path:    "/opt/app/go.mod",
-			want:    true,
-		},
-
-		// Prefix collision (bug #1: /opt must NOT match /optional)
-		"prefix collision opt vs optional": {
-			sources: []string{"/opt"},
-			path:    "/optional",
-			want:    false,
-		},
-		"prefix collision opt vs optional nested": {
-			sources: []string{"/opt"},
-			path:    "/optional/go.mod",
-			want:    false,
-		},
-		"prefix collision app vs application": {
-			sources: []string{"/app"},
-			path:    "/application/go.mod",
-			want:    false,
-		},
-		"prefix collision content vs contentful": {
-			sources: []string{"/content"},
-			path:    "/contentful/data",
-			want:    false,
-		},
-
-		// Relative path handling (tar entries may lack leading /)
-		"relative path matches absolute source": {
-			sources: []string{"/opt"},
-			path:    "opt/go.mod",
-			want:    true,
-		},
-		"relative path exact match": {
-			sources: []string{"/opt"},
-			path:    "opt",
-			want:    true,
-		},
-		"relative path prefix collision": {
-			sources: []string{"/opt"},
-			path:    "optional/go.mod",
-			want:    false,
-		},
-
-		// Wildcard exact match (same depth, no directory crossing)
-		"wildcard match single level": {
-			sources: []string{"/app*"},
-			path:    "/app1",
-			want:    true,
-		},
-		"wildcard no match": {
-			sources: []string{"/app*"},
-			path:    "/other",
-			want:    false,
-		},
-
-		// Wildcard across directory separators (bug #2)
-		"wildcard matches child path across separator": {
-			sources: []string{"/app*"},
-			path:    "/app1/go.mod",
-			want:    true,
-		},
-		"wildcard matches deeply nested child": {
-			sources: []string{"/app*"},
-			path:    "/app1/sub/go.mod",
-			want:    true,
-		},
-		"wildcard does not match unrelated nested path": {
-			sources: []string{"/app*"},
-			path:    "/other/go.mod",
-			want:    false,
-		},
-		"wildcard relative path across separator": {
-			sources: []string{"/app*"},
-			path:    "app1/go.mod",
-			want:    true,
-		},
-		"wildcard question mark matches child": {
-			sources: []string{"/app?"},
-			path:    "/app1/go.mod",
-			want:    true,
-		},
-		"wildcard question mark no match extra chars": {
-			sources: []string{"/app?"},
-			path:    "/app12/go.mod",
-			want:    false,
-		},
-
-		// Trailing slash source
-		"trailing slash source matches child": {
-			sources: []string{"/opt/"},
-			path:    "/opt/go.mod",
-			want:    true,
-		},
-		"trailing slash source prefix collision": {
-			sources: []string{"/opt/"},
-			path:    "/optional/go.mod",
-			want:    false,
-		},
-
-		// Multiple sources
-		"multiple sources first matches": {
-			sources: []string{"/opt", "/usr"},
-			path:    "/opt/go.mod",
-			want:    true,
-		},
-		"multiple sources second matches": {
-			sources: []string{"/opt", "/usr"},
-			path:    "/usr/lib",
-			want:    true,
-		},
-		"multiple sources none match": {
-			sources: []string{"/opt", "/usr"},
-			path:    "/var/log",
-			want:    false,
-		},
-		"multiple sources with wildcard": {
-			sources: []string{"/base", "/app*"},
-			path:    "/app1/go.mod",
-			want:    true,
-		},
-
-		// Empty sources
-		"empty sources": {
-			sources: []string{},
-			path:    "/opt",
-			want:    false,
-		},
-
-		// Path sanitization (tar entry paths may have "..", ".", double slashes)
-		"dotdot in path resolved": {
-			sources: []string{"/foo/bar"},
-			path:    "/foo/bar/../bar/file.txt",
-			want:    true,
-		},
-		"double slashes in path normalized": {
-			sources: []string{"/foo/bar"},
-			path:    "/foo//bar/file.txt",
-			want:    true,
-		},
-		"dot in path normalized": {
-			sources: []string{"/foo/bar"},
-			path:    "/foo/./bar/file.txt",
-			want:    true,
-		},
-		// Root source
-		"root source matches any path": {
-			sources: []string{"/"},
-			path:    "/etc/passwd",
-			want:    true,
-		},
-		"root source wildcard matches any path": {
-			sources: []string{"/*"},
-			path:    "/etc/passwd",
-			want:    true,
-		},
-		"root source matches deeply nested path": {
-			sources: []string{"/"},
-			path:    "/opt/app/sub/go.mod",
-			want:    true,
+		"no mounts": {
+			included:         []string{"app/", "root/.cache/go-build/"},
+			expectedIncluded: []string{"app/", "root/.cache/go-build/"},
+			expectedFiles:    contentFiles,
+		},
+		"cache mount under included path": {
+			included:         []string{"app/", "app/main.js", "app/node_modules/left-pad/package.json"},
+			mountTargets:     []string{"/app/node_modules"},
+			expectedIncluded: []string{"app/", "app/main.js"},
+			expectedFiles: []string{
+				"app/main.js",
+				"app/node_modules_backup/package.json",
+				"root/.cache/go-build/00/abc",
+				"run/secrets/other",
+				"run/secrets/token",
+			},
+		},
+		"cache mount covering included path": {
+			included:         []string{"/app", "/root/.cache/go-build/00/abc"},
+			mountTargets:     []string{"/root/.cache"},
+			expectedIncluded: []string{"/app"},
+			expectedFiles: []string{
+				"app/main.js",
+				"app/node_modules/left-pad/package.json",
+				"app/node_modules_backup/package.json",
+				"run/secrets/other",
+				"run/secrets/token",
+			},
+		},
+		"secret mount target": {
+			included:         []string{"/run", "/app"},
+			mountTargets:     []string{"/run/secrets/token", "/app/node_modules"},
+			expectedIncluded: []string{"/run", "/app"},
+			expectedFiles: []string{
+				"app/main.js",
+				"app/node_modules_backup/package.json",
+				"root/.cache/go-build/00/abc",
+				"run/secrets/other",
+			},
+		},
+		"root mount is ignored": {
+			included:         []string{"/app"},
+			mountTargets:     []string{"/"},
+			expectedIncluded: []string{"/app"},
+			expectedFiles:    contentFiles,
 		},
 	}
 
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
-			got := includes(tc.sources, tc.path)
-			if got != tc.want {
-				t.Errorf("includes(%v, %q) = %v, want %v", tc.sources, tc.path, got, tc.want)
+			contentPath := t.TempDir()
+			for _, f := range contentFiles {
+				full := filepath.Join(contentPath, f)
+				if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(full, []byte(f), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			s := &Scanner{logger: slog.Default()}
+			included, err := s.removeMountContent(contentPath, tc.included, tc.mountTargets)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.expectedIncluded, included); diff != "" {
+				t.Errorf("removeMountContent() included mismatch (-want +got):\n%s", diff)
+			}
+
+			var files []string
+			err = filepath.WalkDir(contentPath, func(p string, d os.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				rel, err := filepath.Rel(contentPath, p)
+				files = append(files, rel)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.expectedFiles, files); diff != "" {
+				t.Errorf("content files mismatch (-want +got):\n%s", diff)
 			}
 		})
 	}
@@ -285,3 +195,518 @@ func TestCheckBuildahVersionFromImage(t *testing.T) {
 		})
 	}
 }
+
+func TestCopyTree(t *testing.T) {
+	t.Parallel()
+	src := t.TempDir()
+	for _, dir := range []string{"bin", "lib/private"} {
+		if err := os.MkdirAll(filepath.Join(src, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(filepath.Join(src, "lib/private"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]os.FileMode{
+		"bin/tool":           0o755,
+		"lib/private/secret": 0o640,
+	}
+	for name, mode := range files {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(name), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(filepath.Join(src, name), mode|os.ModeSetuid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("/etc", filepath.Join(src, "lib/etc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(filepath.Join(src, "bin/fifo"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Scanner{logger: slog.Default(), permMask: 0o027}
+	dest := filepath.Join(t.TempDir(), "content")
+	if err := s.copyTree(context.Background(), src, dest); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]os.FileMode{
+		".":                  os.ModeDir | 0o750,
+		"bin":                os.ModeDir | 0o750,
+		"bin/tool":           0o750,
+		"lib":                os.ModeDir | 0o750,
+		"lib/etc":            os.ModeSymlink,
+		"lib/private":        os.ModeDir | 0o750,
+		"lib/private/secret": 0o640,
+	}
+	modes := make(map[string]os.FileMode)
+	err := filepath.WalkDir(dest, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dest, p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			// symlink permissions are platform dependent
+			modes[rel] = os.ModeSymlink
+		} else {
+			modes[rel] = info.Mode()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(expected, modes); diff != "" {
+		t.Errorf("copied content mismatch (-want +got):\n%s", diff)
+	}
+
+	link, err := os.Readlink(filepath.Join(dest, "lib/etc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link != "/etc" {
+		t.Errorf("expected symbolic link to /etc, got %q", link)
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	t.Parallel()
+	src := filepath.Join(t.TempDir(), "tool")
+	content := bytes.Repeat([]byte("tool"), 4096)
+	if err := os.WriteFile(src, content, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// cloned where the filesystem supports it, copied otherwise
+	s := &Scanner{logger: slog.Default()}
+	dest := filepath.Join(t.TempDir(), "bin", "tool")
+	if err := s.copyFile(src, dest, 0o755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	copied, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(copied, content) {
+		t.Errorf("copied content mismatch, got %d bytes, expected %d", len(copied), len(content))
+	}
+}
+
+func TestCloneFile(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		// directory of the destination, skipped if empty
+		destDir     func(t *testing.T, srcDir string) string
+		expectClone bool
+	}{
+		"same device": {
+			destDir:     func(t *testing.T, srcDir string) string { return srcDir },
+			expectClone: true,
+		},
+		"other device": {
+			destDir: func(t *testing.T, srcDir string) string {
+				var srcStat, shmStat syscall.Stat_t
+				if syscall.Stat(srcDir, &srcStat) != nil || syscall.Stat("/dev/shm", &shmStat) != nil ||
+					srcStat.Dev == shmStat.Dev {
+					return ""
+				}
+				dir, err := os.MkdirTemp("/dev/shm", "capo-clone-")
+				if err != nil {
+					return ""
+				}
+				t.Cleanup(func() { _ = os.RemoveAll(dir) })
+				return dir
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			srcDir := t.TempDir()
+			destDir := tc.destDir(t, srcDir)
+			if destDir == "" {
+				t.Skip("no directory on another device")
+			}
+			content := bytes.Repeat([]byte("tool"), 4096)
+			if err := os.WriteFile(filepath.Join(srcDir, "tool"), content, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			src, err := os.Open(filepath.Join(srcDir, "tool"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer src.Close()
+			dest, err := os.Create(filepath.Join(destDir, "clone"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dest.Close()
+
+			err = cloneFile(dest, src)
+			if !tc.expectClone {
+				if !errors.Is(err, errCrossDevice) {
+					t.Fatalf("expected error wrapping %v, got: %v", errCrossDevice, err)
+				}
+				return
+			}
+			// the clone is attempted, filesystems without reflinks fail the
+			// ioctl with an errno
+			if err != nil {
+				var errno syscall.Errno
+				if !errors.As(err, &errno) {
+					t.Fatalf("expected the clone ioctl to be attempted, got: %v", err)
+				}
+				return
+			}
+			cloned, err := os.ReadFile(dest.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(cloned, content) {
+				t.Errorf("cloned content mismatch, got %d bytes, expected %d", len(cloned), len(content))
+			}
+		})
+	}
+}
+
+func TestCopyTreeCanceled(t *testing.T) {
+	t.Parallel()
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "tool"), []byte("tool"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s := &Scanner{logger: slog.Default()}
+	dest := filepath.Join(t.TempDir(), "content")
+	if err := s.copyTree(ctx, src, dest); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected error wrapping %v, got: %v", context.Canceled, err)
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "tool")); !os.IsNotExist(err) {
+		t.Errorf("expected no content copied after cancellation, got: %v", err)
+	}
+}
+
+// newLayerStore returns a store with layers with the parent layer IDs and
+// uncompressed sizes (zero if missing).
+func newLayerStore(parents map[string]string, sizes map[string]int64) *capotest.Store {
+	store := capotest.NewStore()
+	for id, parent := range parents {
+		store.AddLayer(storage.Layer{ID: id, Parent: parent, UncompressedSize: sizes[id]})
+	}
+	return store
+}
+
+func TestIsSquashed(t *testing.T) {
+	t.Parallel()
+	store := newLayerStore(map[string]string{
+		"base1":    "",
+		"base2":    "base1",
+		"stage1":   "base2",
+		"stage2":   "stage1",
+		"squashed": "",
+		"dangling": "missing",
+	}, nil)
+
+	tests := map[string]struct {
+		intermediateTop  string
+		baseTop          string
+		expected         bool
+		expectedErr      error
+		expectedWarnings int
+	}{
+		"layers on top of base": {
+			intermediateTop: "stage2",
+			baseTop:         "base2",
+			expected:        false,
+		},
+		"same top layer as base": {
+			intermediateTop: "base2",
+			baseTop:         "base2",
+			expected:        false,
+		},
+		"squashed into a single layer": {
+			intermediateTop:  "squashed",
+			baseTop:          "base2",
+			expected:         true,
+			expectedWarnings: 1,
+		},
+		"missing layer in chain": {
+			intermediateTop: "dangling",
+			baseTop:         "base2",
+			expectedErr:     ErrStorage,
+		},
+		"base without layers": {
+			intermediateTop: "stage1",
+			baseTop:         "",
+			expected:        false,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			s := &Scanner{logger: slog.Default(), store: store}
+			squashed, err := s.isSquashed(
+				&storage.Image{ID: "intermediate", TopLayer: tc.intermediateTop},
+				&storage.Image{ID: "base", TopLayer: tc.baseTop},
+				"builder",
+			)
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Fatalf("expected error wrapping %v, got: %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if squashed != tc.expected {
+				t.Errorf("expected squashed %v, got %v", tc.expected, squashed)
+			}
+			if len(s.warnings) != tc.expectedWarnings {
+				t.Errorf("expected %d warnings, got %v", tc.expectedWarnings, s.warnings)
+			}
+			for _, w := range s.warnings {
+				if w.Code != WarnSquashedImage {
+					t.Errorf("expected warning code %s, got %s", WarnSquashedImage, w.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestDiffParent(t *testing.T) {
+	t.Parallel()
+	// the base layers re-created with other IDs for a linked copy
+	store := capotest.NewStore()
+	for _, layer := range []storage.Layer{
+		{ID: "base1", UncompressedDigest: "sha256:b1"},
+		{ID: "base2", Parent: "base1", UncompressedDigest: "sha256:b2"},
+		{ID: "stage1", Parent: "base2", UncompressedDigest: "sha256:s1"},
+		{ID: "relinked1", UncompressedDigest: "sha256:b1"},
+		{ID: "relinked2", Parent: "relinked1", UncompressedDigest: "sha256:b2"},
+		{ID: "linked1", Parent: "relinked2", UncompressedDigest: "sha256:l1"},
+		{ID: "linked2", Parent: "linked1", UncompressedDigest: "sha256:l2"},
+		{ID: "other1", UncompressedDigest: "sha256:o1"},
+		{ID: "other2", Parent: "other1", UncompressedDigest: "sha256:b2"},
+		{ID: "other3", Parent: "other2", UncompressedDigest: "sha256:s1"},
+		{ID: "unknown1"},
+		{ID: "unknown2", Parent: "unknown1"},
+		{ID: "unknown3", Parent: "unknown2"},
+		{ID: "unknownBase1"},
+		{ID: "unknownBase2", Parent: "unknownBase1"},
+	} {
+		store.AddLayer(layer)
+	}
+
+	tests := map[string]struct {
+		intermediateTop  string
+		baseTop          string
+		expected         string
+		expectedWarnings int
+	}{
+		"layers on top of base": {
+			intermediateTop: "stage1",
+			baseTop:         "base2",
+			expected:        "base2",
+		},
+		"re-created base layers": {
+			intermediateTop: "linked2",
+			baseTop:         "base2",
+			expected:        "relinked2",
+		},
+		"different bottom layer": {
+			intermediateTop:  "other3",
+			baseTop:          "base2",
+			expectedWarnings: 1,
+		},
+		"unknown digests": {
+			intermediateTop:  "unknown3",
+			baseTop:          "unknownBase2",
+			expectedWarnings: 1,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			s := &Scanner{logger: slog.Default(), store: store}
+			parent, err := s.diffParent(
+				&storage.Image{ID: "intermediate", TopLayer: tc.intermediateTop},
+				&storage.Image{ID: "base", TopLayer: tc.baseTop},
+				"builder",
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if parent != tc.expected {
+				t.Errorf("expected diff parent %q, got %q", tc.expected, parent)
+			}
+			if len(s.warnings) != tc.expectedWarnings {
+				t.Errorf("expected %d warnings, got %v", tc.expectedWarnings, s.warnings)
+			}
+		})
+	}
+}
+
+func TestSaveDiff(t *testing.T) {
+	t.Parallel()
+	store := capotest.NewStore().SetDiff("base", "stage", buildTar(t, []tarEntry{
+		{name: "app/", typeflag: tar.TypeDir},
+		{name: "app/go.mod", typeflag: tar.TypeReg, content: []byte("module app")},
+		{name: "tmp/cache", typeflag: tar.TypeReg, content: []byte("cache")},
+	}))
+	s := newExtractScanner(DefaultMaxFileBytes, DefaultMaxExtractBytes)
+	s.store = store
+
+	dest := t.TempDir()
+	included, err := s.saveDiff(context.Background(), dest, "stage", "base", []string{"/app"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"app/", "app/go.mod"}, included); diff != "" {
+		t.Errorf("included mismatch (-want +got):\n%s", diff)
+	}
+	if content, err := os.ReadFile(filepath.Join(dest, "app/go.mod")); err != nil || string(content) != "module app" {
+		t.Errorf("expected extracted app/go.mod, got %q, %v", content, err)
+	}
+
+	if _, err := s.saveDiff(context.Background(), dest, "stage", "other", []string{"/app"}); !errors.Is(err, ErrStorage) {
+		t.Errorf("expected error wrapping %v, got %v", ErrStorage, err)
+	}
+}
+
+func TestEmptyBaseContent(t *testing.T) {
+	t.Parallel()
+	store := capotest.NewStore().
+		AddImage(storage.Image{ID: "empty-base", Names: []string{"localhost/empty:latest"}}, "").
+		AddImage(storage.Image{ID: "stage-image", TopLayer: "l1"}, "").
+		AddLayer(storage.Layer{ID: "l1"}).
+		SetDiff("", "l1", buildTar(t, []tarEntry{
+			{name: "opt/", typeflag: tar.TypeDir},
+			{name: "opt/app", typeflag: tar.TypeReg, content: []byte("app")},
+		}))
+	report := &stagereport.Report{Version: stagereport.Version, Stages: []stagereport.Stage{
+		{Index: 0, Name: "builder", ImageID: "stage-image"},
+	}}
+	emptyBase := &storage.Image{ID: "empty-base"}
+
+	tests := map[string]struct {
+		extract func(s *Scanner, dir string) ([]string, bool, error)
+	}{
+		"base without layers": {
+			extract: func(s *Scanner, dir string) ([]string, bool, error) {
+				return s.getIntermediateContent(context.Background(), emptyBase, "builder", []string{"/opt"}, dir)
+			},
+		},
+		"special base": {
+			extract: func(s *Scanner, dir string) ([]string, bool, error) {
+				return s.getIntermediateContent(context.Background(), nil, "builder", []string{"/opt"}, dir)
+			},
+		},
+		"chained stage of base without layers": {
+			extract: func(s *Scanner, dir string) ([]string, bool, error) {
+				_, included, squashed, err := s.getDescendantContent(
+					context.Background(), "builder", emptyBase, []string{"/opt"}, dir,
+				)
+				return included, squashed, err
+			},
+		},
+		"chained stage of special base": {
+			extract: func(s *Scanner, dir string) ([]string, bool, error) {
+				_, included, squashed, err := s.getDescendantContent(context.Background(), "builder", nil, []string{"/opt"}, dir)
+				return included, squashed, err
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			s := newExtractScanner(DefaultMaxFileBytes, DefaultMaxExtractBytes)
+			s.store = store
+			s.stageReport = report
+			s.extractorKind = ExtractorLayers
+
+			included, squashed, err := tc.extract(s, t.TempDir())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff([]string{"/opt", "/opt/app"}, included); diff != "" {
+				t.Errorf("included content mismatch (-want +got):\n%s", diff)
+			}
+			if squashed || len(s.warnings) > 0 {
+				t.Errorf("expected content of the stage, got squashed %v, warnings %v", squashed, s.warnings)
+			}
+		})
+	}
+
+	t.Run("builder content", func(t *testing.T) {
+		t.Parallel()
+		s := newExtractScanner(DefaultMaxFileBytes, DefaultMaxExtractBytes)
+		s.store = store
+		included, err := s.getImageContent(context.Background(), emptyBase, []string{"/"}, t.TempDir())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(included) > 0 {
+			t.Errorf("expected no builder content, got %v", included)
+		}
+	})
+}
+
+func TestFindIntermediateImageFromStageReport(t *testing.T) {
+	t.Parallel()
+	store := capotest.NewStore().AddImage(storage.Image{ID: "9f2c1c5a8b1"}, "")
+	report := &stagereport.Report{Version: stagereport.Version, Stages: []stagereport.Stage{
+		{Index: 0, Name: "builder", ImageID: "9f2c1c5a8b1"},
+		{Index: 1, Name: "stale", ImageID: "0123456789a"},
+	}}
+
+	tests := map[string]struct {
+		stageAlias    string
+		expectedID    string
+		expectedFound bool
+		expectedErr   error
+	}{
+		"stage in report": {
+			stageAlias:    "builder",
+			expectedID:    "9f2c1c5a8b1",
+			expectedFound: true,
+		},
+		"image of report not in storage": {
+			stageAlias:  "stale",
+			expectedErr: ErrStorage,
+		},
+		// falls back to stage labels, no unnamed image in storage has one
+		"stage not in report": {
+			stageAlias: "other",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			s := &Scanner{logger: slog.Default(), store: store, stageReport: report}
+			image, found, err := s.findIntermediateImage(tc.stageAlias)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error wrapping %v, got: %v", tc.expectedErr, err)
+			}
+			if found != tc.expectedFound || (found && image.ID != tc.expectedID) {
+				t.Errorf("expected image %q (found %v), got %+v (found %v)", tc.expectedID, tc.expectedFound, image, found)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+// Summary of how precisely the content copied to the final image was
+// attributed, recorded in the scan output.
+
+package capo
+
+import "slices"
+
+// CoverageStatus is how precisely the content of a copied source was
+// attributed to its origin.
+type CoverageStatus string
+
+const (
+	// CoverageAttributed is content traced to its origin image or stage.
+	CoverageAttributed CoverageStatus = "attributed"
+	// CoverageBestEffort is content traced with heuristics, which may
+	// attribute it to the wrong origin.
+	CoverageBestEffort CoverageStatus = "best-effort"
+	// CoverageSkipped is content capo doesn't attribute.
+	CoverageSkipped CoverageStatus = "skipped"
+)
+
+const (
+	// CoverageReasonRunMove is content traced through RUN mv or cp commands
+	// (see containerfile.Move).
+	CoverageReasonRunMove = "run-move"
+	// CoverageReasonNamedContext is content copied from a named build
+	// context.
+	CoverageReasonNamedContext = "named-context"
+	// CoverageReasonContextArchive is an archive added from the build
+	// context, which buildah extracts.
+	CoverageReasonContextArchive = "context-archive"
+	// CoverageReasonContext is content copied from the build context.
+	CoverageReasonContext = "context"
+	// CoverageReasonSourceSBOM is content copied from the build context,
+	// attributed to packages of the SBOM of the build context by their
+	// locations (see WithSourceSBOM).
+	CoverageReasonSourceSBOM = "source-sbom"
+)
+
+// Coverage summarizes how precisely the sources of COPY instructions and of
+// ADD instructions of archives in the final stage were attributed.
+type Coverage struct {
+	// Number of sources by status.
+	Attributed int `json:"attributed"`
+	BestEffort int `json:"best_effort"`
+	Skipped    int `json:"skipped"`
+	// Fraction of the sources which were attributed, 1 if there are none.
+	AttributedFraction float64 `json:"attributed_fraction"`
+	// Every source: of COPY --from, COPY from the build context and ADD
+	// instructions, each in the order of the instructions.
+	Sources []CoverageSource `json:"sources"`
+}
+
+// CoverageSource is the attribution of a source of a COPY or ADD instruction
+// in the final stage.
+type CoverageSource struct {
+	// Source path as it appeared in the containerfile.
+	Source string `json:"source"`
+	// Stage alias, pullspec or named context the source is copied from.
+	// Omitted for copies from the build context.
+	From string `json:"from,omitempty"`
+	// Destination of the instruction.
+	Destination string         `json:"destination"`
+	Status      CoverageStatus `json:"status"`
+	// Why the source was not attributed precisely, e.g.
+	// CoverageReasonRunMove. Omitted for attributed sources.
+	Reason string `json:"reason,omitempty"`
+}
+
+// newCoverage returns the coverage of the passed sources.
+func newCoverage(sources []CoverageSource) *Coverage {
+	res := &Coverage{
+		AttributedFraction: 1,
+		Sources:            append(make([]CoverageSource, 0, len(sources)), sources...),
+	}
+	for _, src := range sources {
+		switch src.Status {
+		case CoverageAttributed:
+			res.Attributed++
+		case CoverageBestEffort:
+			res.BestEffort++
+		case CoverageSkipped:
+			res.Skipped++
+		}
+	}
+	if len(sources) > 0 {
+		res.AttributedFraction = float64(res.Attributed) / float64(len(sources))
+	}
+	return res
+}
+
+// withSourceSBOM returns the coverage with the copies from the build context
+// attributed to packages of the SBOM of the build context. As the SBOM was
+// generated separately from the build, they are best-effort.
+func (c *Coverage) withSourceSBOM() *Coverage {
+	sources := slices.Clone(c.Sources)
+	for i := range sources {
+		if sources[i].Reason == CoverageReasonContext {
+			sources[i].Status, sources[i].Reason = CoverageBestEffort, CoverageReasonSourceSBOM
+		}
+	}
+	return newCoverage(sources)
+}
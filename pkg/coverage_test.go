@@ -0,0 +1,78 @@
+//go:build unit
+
+package capo
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewCoverage(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		sources  []CoverageSource
+		expected *Coverage
+	}{
+		"no sources": {
+			sources:  nil,
+			expected: &Coverage{AttributedFraction: 1, Sources: []CoverageSource{}},
+		},
+		"mixed statuses": {
+			sources: []CoverageSource{
+				{Source: "/a", Status: CoverageAttributed},
+				{Source: "/b", Status: CoverageBestEffort, Reason: CoverageReasonRunMove},
+				{Source: "/c", Status: CoverageAttributed},
+				{Source: "d.tar", Status: CoverageSkipped, Reason: CoverageReasonContextArchive},
+			},
+			expected: &Coverage{
+				Attributed:         2,
+				BestEffort:         1,
+				Skipped:            1,
+				AttributedFraction: 0.5,
+				Sources: []CoverageSource{
+					{Source: "/a", Status: CoverageAttributed},
+					{Source: "/b", Status: CoverageBestEffort, Reason: CoverageReasonRunMove},
+					{Source: "/c", Status: CoverageAttributed},
+					{Source: "d.tar", Status: CoverageSkipped, Reason: CoverageReasonContextArchive},
+				},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if diff := cmp.Diff(test.expected, newCoverage(test.sources)); diff != "" {
+				t.Errorf("newCoverage() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCoverageWithSourceSBOM(t *testing.T) {
+	t.Parallel()
+	coverage := newCoverage([]CoverageSource{
+		{Source: "/a", From: "builder", Status: CoverageAttributed},
+		{Source: ".", Status: CoverageSkipped, Reason: CoverageReasonContext},
+		{Source: "d.tar", Status: CoverageSkipped, Reason: CoverageReasonContextArchive},
+	})
+
+	expected := &Coverage{
+		Attributed:         1,
+		BestEffort:         1,
+		Skipped:            1,
+		AttributedFraction: 1.0 / 3,
+		Sources: []CoverageSource{
+			{Source: "/a", From: "builder", Status: CoverageAttributed},
+			{Source: ".", Status: CoverageBestEffort, Reason: CoverageReasonSourceSBOM},
+			{Source: "d.tar", Status: CoverageSkipped, Reason: CoverageReasonContextArchive},
+		},
+	}
+	if diff := cmp.Diff(expected, coverage.withSourceSBOM()); diff != "" {
+		t.Errorf("withSourceSBOM() mismatch (-want +got):\n%s", diff)
+	}
+	if coverage.Sources[1].Status != CoverageSkipped {
+		t.Errorf("withSourceSBOM() modified the coverage")
+	}
+}
@@ -0,0 +1,142 @@
+// Layout of content extracted for scanning which is kept for debugging, see
+// debugEnv.
+
+package capo
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// debugEnv is the environment variable which, when set, keeps the content
+// extracted for scanning in a directory per origin instead of removing it.
+const debugEnv = "CAPO_DEBUG"
+
+// Kinds of directories of extracted content of an origin.
+const (
+	debugKindBuilder      = "builder"
+	debugKindIntermediate = "intermediate"
+	debugKindPackageDB    = "package-db"
+)
+
+// debugLayout creates the directories of extracted content of a scan under a
+// single root, as <root>/<origin>/<kind>, where origin is the stage alias or
+// the digest of the pullspec of an external image.
+type debugLayout struct {
+	root string
+
+	mu    sync.Mutex
+	paths []debugPath
+}
+
+// debugPath is a directory of extracted content of an origin.
+type debugPath struct {
+	origin string
+	kind   string
+	path   string
+}
+
+// newDebugLayout creates the root directory of a debug layout.
+func newDebugLayout() (*debugLayout, error) {
+	root, err := os.MkdirTemp("", "capo-debug-")
+	if err != nil {
+		return nil, err
+	}
+	return &debugLayout{root: root}, nil
+}
+
+// dir creates and returns the directory of content of the kind of origin.
+// Origins with the same directory name get a numbered suffix.
+func (l *debugLayout) dir(origin, kind string) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	name := debugDirName(origin)
+	path := filepath.Join(l.root, name, kind)
+	for i := 2; ; i++ {
+		_, err := os.Stat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(l.root, fmt.Sprintf("%s-%d", name, i), kind)
+	}
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return "", err
+	}
+
+	l.paths = append(l.paths, debugPath{origin: origin, kind: kind, path: path})
+	return path, nil
+}
+
+// writeSummary writes a table of the directories of all origins, sorted by
+// origin and kind.
+func (l *debugLayout) writeSummary(w io.Writer) error {
+	l.mu.Lock()
+	paths := slices.Clone(l.paths)
+	l.mu.Unlock()
+	slices.SortFunc(paths, func(a, b debugPath) int {
+		return cmp.Or(cmp.Compare(a.origin, b.origin), cmp.Compare(a.kind, b.kind))
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Extracted content kept in %s:\n", l.root)
+	fmt.Fprintln(tw, "ORIGIN\tKIND\tPATH")
+	for _, p := range paths {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", p.origin, p.kind, p.path)
+	}
+	return tw.Flush()
+}
+
+var unsafeDirChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// debugDirName returns the directory name of an origin: the digest of a
+// pullspec with a digest (e.g. "sha256-abc123"), otherwise the origin with
+// characters unsafe in file names replaced.
+func debugDirName(origin string) string {
+	if _, dgst, ok := strings.Cut(origin, "@"); ok {
+		origin = strings.Replace(dgst, ":", "-", 1)
+	}
+	name := unsafeDirChars.ReplaceAllString(origin, "_")
+	if name == "" || name == "." || name == ".." {
+		name = "_" + name
+	}
+	return name
+}
+
+// contentDir creates a directory for content of the kind of origin: in the
+// debug layout in debug mode, otherwise a temporary directory.
+func (s *Scanner) contentDir(origin, kind string) (string, error) {
+	if s.debug != nil {
+		return s.debug.dir(origin, kind)
+	}
+	return os.MkdirTemp("", "")
+}
+
+// removeContentDirs removes directories created by contentDir, unless in
+// debug mode. Empty paths are skipped.
+func (s *Scanner) removeContentDirs(paths ...string) error {
+	if s.debug != nil {
+		return nil
+	}
+	var errs []error
+	for _, p := range paths {
+		if p != "" {
+			errs = append(errs, os.RemoveAll(p))
+			s.fileOwners.forget(p)
+		}
+	}
+	return errors.Join(errs...)
+}
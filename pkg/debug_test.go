@@ -0,0 +1,102 @@
+//go:build unit
+
+package capo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDebugDirName(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		origin   string
+		expected string
+	}{
+		"stage alias":       {origin: "builder", expected: "builder"},
+		"numeric alias":     {origin: "0", expected: "0"},
+		"pullspec":          {origin: "quay.io/konflux-ci/tools@sha256:abc123", expected: "sha256-abc123"},
+		"unsafe characters": {origin: "my stage/x", expected: "my_stage_x"},
+		"dot dot":           {origin: "..", expected: "_.."},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if actual := debugDirName(test.origin); actual != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestDebugLayout(t *testing.T) {
+	t.Parallel()
+	l := &debugLayout{root: t.TempDir()}
+	s := &Scanner{debug: l}
+
+	paths := make([]string, 0)
+	for _, dir := range []struct{ origin, kind string }{
+		{"builder", debugKindBuilder},
+		{"builder", debugKindIntermediate},
+		{"quay.io/konflux-ci/tools@sha256:abc123", debugKindBuilder},
+		// another origin with the same directory name
+		{"quay.io/other/tools@sha256:abc123", debugKindBuilder},
+	} {
+		path, err := s.contentDir(dir.origin, dir.kind)
+		if err != nil {
+			t.Fatalf("contentDir returned error: %v", err)
+		}
+		paths = append(paths, path)
+	}
+
+	expected := []string{
+		filepath.Join(l.root, "builder", "builder"),
+		filepath.Join(l.root, "builder", "intermediate"),
+		filepath.Join(l.root, "sha256-abc123", "builder"),
+		filepath.Join(l.root, "sha256-abc123-2", "builder"),
+	}
+	for i, path := range paths {
+		if path != expected[i] {
+			t.Errorf("expected directory %q, got %q", expected[i], path)
+		}
+	}
+
+	if err := s.removeContentDirs(paths...); err != nil {
+		t.Fatalf("removeContentDirs returned error: %v", err)
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected %q to be kept in debug mode: %v", path, err)
+		}
+	}
+
+	var summary strings.Builder
+	if err := l.writeSummary(&summary); err != nil {
+		t.Fatalf("writeSummary returned error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(summary.String()), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("expected a header and 4 rows, got:\n%s", summary.String())
+	}
+	if fields := strings.Fields(lines[2]); len(fields) != 3 || fields[0] != "builder" || fields[1] != "builder" {
+		t.Errorf("unexpected first row %q", lines[2])
+	}
+}
+
+func TestRemoveContentDirs(t *testing.T) {
+	t.Parallel()
+	s := &Scanner{}
+	path, err := s.contentDir("builder", debugKindBuilder)
+	if err != nil {
+		t.Fatalf("contentDir returned error: %v", err)
+	}
+	if err := s.removeContentDirs(path, ""); err != nil {
+		t.Fatalf("removeContentDirs returned error: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected %q to be removed, got: %v", path, err)
+	}
+}
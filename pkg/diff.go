@@ -0,0 +1,121 @@
+// Differences between the output of two scans, see Diff.
+
+package capo
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// OriginDiff is the difference between the packages of an origin in two scan
+// outputs.
+type OriginDiff struct {
+	// Repository of the pullspec of the origin, without the digest, or the
+	// origin type of packages without a pullspec (e.g. "context").
+	Origin string `json:"origin"`
+	// Pullspecs of the origin in the old and new output, omitted if the
+	// origin has no pullspec or isn't in that output.
+	OldPullspec string `json:"old_pullspec,omitempty"`
+	NewPullspec string `json:"new_pullspec,omitempty"`
+	// Package URLs only in the new output.
+	Added []string `json:"added,omitempty"`
+	// Package URLs only in the old output.
+	Removed []string `json:"removed,omitempty"`
+	// Packages in both outputs with another version.
+	Changed []PackageChange `json:"changed,omitempty"`
+}
+
+// PackageChange is a package of an origin in two scan outputs with another
+// package URL, e.g. another version.
+type PackageChange struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// Diff returns the differences between the packages of the old and the new
+// scan output by origin, sorted by origin. Origins are identified by the
+// repository of their pullspec, so a rebuilt base image with a new digest is
+// the same origin. A package with another version of the same origin is
+// changed if it is the only version of its package in both outputs. Origins
+// without differences are omitted.
+func Diff(old, new PackageMetadata) []OriginDiff {
+	origins := make(map[string]*OriginDiff)
+	oldPurls := make(map[string]map[string][]string)
+	newPurls := make(map[string]map[string][]string)
+	group := func(m PackageMetadata, purls map[string]map[string][]string, isNew bool) {
+		for _, item := range m.Packages {
+			origin, _, _ := strings.Cut(item.Pullspec, "@")
+			if origin == "" {
+				origin = item.OriginType
+			}
+			d, ok := origins[origin]
+			if !ok {
+				d = &OriginDiff{Origin: origin}
+				origins[origin] = d
+			}
+			if purls[origin] == nil {
+				purls[origin] = make(map[string][]string)
+			}
+			if item.Pullspec != "" {
+				if isNew {
+					d.NewPullspec = item.Pullspec
+				} else {
+					d.OldPullspec = item.Pullspec
+				}
+			}
+			name := packageName(item.PackageURL)
+			if !slices.Contains(purls[origin][name], item.PackageURL) {
+				purls[origin][name] = append(purls[origin][name], item.PackageURL)
+			}
+		}
+	}
+	group(old, oldPurls, false)
+	group(new, newPurls, true)
+
+	res := make([]OriginDiff, 0, len(origins))
+	for origin, d := range origins {
+		names := make(map[string]struct{})
+		for name := range oldPurls[origin] {
+			names[name] = struct{}{}
+		}
+		for name := range newPurls[origin] {
+			names[name] = struct{}{}
+		}
+
+		for name := range names {
+			removed := slices.DeleteFunc(slices.Clone(oldPurls[origin][name]), func(purl string) bool {
+				return slices.Contains(newPurls[origin][name], purl)
+			})
+			added := slices.DeleteFunc(slices.Clone(newPurls[origin][name]), func(purl string) bool {
+				return slices.Contains(oldPurls[origin][name], purl)
+			})
+			if len(removed) == 1 && len(added) == 1 &&
+				len(oldPurls[origin][name]) == 1 && len(newPurls[origin][name]) == 1 {
+				d.Changed = append(d.Changed, PackageChange{Old: removed[0], New: added[0]})
+				continue
+			}
+			d.Removed = append(d.Removed, removed...)
+			d.Added = append(d.Added, added...)
+		}
+
+		if len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 && d.OldPullspec == d.NewPullspec {
+			continue
+		}
+		slices.Sort(d.Added)
+		slices.Sort(d.Removed)
+		slices.SortFunc(d.Changed, func(a, b PackageChange) int { return cmp.Compare(a.Old, b.Old) })
+		res = append(res, *d)
+	}
+	slices.SortFunc(res, func(a, b OriginDiff) int { return cmp.Compare(a.Origin, b.Origin) })
+	return res
+}
+
+// packageName returns the package URL without its version, qualifiers and
+// subpath, which identifies the package across versions.
+func packageName(purl string) string {
+	name, _, _ := strings.Cut(purl, "@")
+	name, _, _ = strings.Cut(name, "?")
+	name, _, _ = strings.Cut(name, "#")
+	return name
+}
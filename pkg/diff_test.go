@@ -0,0 +1,71 @@
+//go:build unit
+
+package capo
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiff(t *testing.T) {
+	t.Parallel()
+	const (
+		oldUBI = "registry.access.redhat.com/ubi9/ubi@sha256:abc123"
+		newUBI = "registry.access.redhat.com/ubi9/ubi@sha256:def456"
+		syft   = "ghcr.io/anchore/syft@sha256:789fed"
+	)
+	old := PackageMetadata{Packages: []PackageMetadataItem{
+		{PackageURL: "pkg:rpm/rhel/glibc@2.34-83.el9?arch=x86_64", OriginType: "builder", Pullspec: oldUBI},
+		{PackageURL: "pkg:rpm/rhel/make@4.3-8.el9?arch=x86_64", OriginType: "builder", Pullspec: oldUBI},
+		{PackageURL: "pkg:golang/golang.org/x/net@v0.30.0", OriginType: "builder", Pullspec: oldUBI},
+		{PackageURL: "pkg:golang/golang.org/x/net@v0.31.0", OriginType: "builder", Pullspec: oldUBI},
+		{PackageURL: "pkg:golang/github.com/anchore/syft@v1.32.0", OriginType: "external", Pullspec: syft},
+		{PackageURL: "pkg:pypi/requests@2.32.3", OriginType: "context"},
+	}}
+	new := PackageMetadata{Packages: []PackageMetadataItem{
+		{PackageURL: "pkg:rpm/rhel/glibc@2.34-100.el9?arch=x86_64", OriginType: "builder", Pullspec: newUBI},
+		{PackageURL: "pkg:rpm/rhel/gcc@11.4.1-3.el9?arch=x86_64", OriginType: "builder", Pullspec: newUBI},
+		{PackageURL: "pkg:golang/golang.org/x/net@v0.31.0", OriginType: "builder", Pullspec: newUBI},
+		{PackageURL: "pkg:golang/golang.org/x/net@v0.32.0", OriginType: "builder", Pullspec: newUBI},
+		{PackageURL: "pkg:golang/github.com/anchore/syft@v1.32.0", OriginType: "external", Pullspec: syft},
+		{PackageURL: "pkg:pypi/requests@2.32.4", OriginType: "context"},
+	}}
+
+	expected := []OriginDiff{
+		{
+			Origin:  "context",
+			Changed: []PackageChange{{Old: "pkg:pypi/requests@2.32.3", New: "pkg:pypi/requests@2.32.4"}},
+		},
+		{
+			Origin:      "registry.access.redhat.com/ubi9/ubi",
+			OldPullspec: oldUBI,
+			NewPullspec: newUBI,
+			Added: []string{
+				"pkg:golang/golang.org/x/net@v0.32.0",
+				"pkg:rpm/rhel/gcc@11.4.1-3.el9?arch=x86_64",
+			},
+			Removed: []string{
+				"pkg:golang/golang.org/x/net@v0.30.0",
+				"pkg:rpm/rhel/make@4.3-8.el9?arch=x86_64",
+			},
+			Changed: []PackageChange{{
+				Old: "pkg:rpm/rhel/glibc@2.34-83.el9?arch=x86_64",
+				New: "pkg:rpm/rhel/glibc@2.34-100.el9?arch=x86_64",
+			}},
+		},
+	}
+	if diff := cmp.Diff(expected, Diff(old, new)); diff != "" {
+		t.Errorf("Diff() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDiffIdentical(t *testing.T) {
+	t.Parallel()
+	m := PackageMetadata{Packages: []PackageMetadataItem{
+		{PackageURL: "pkg:rpm/rhel/glibc@2.34-83.el9", OriginType: "builder", Pullspec: "quay.io/org/builder@sha256:abc123"},
+	}}
+	if diff := cmp.Diff([]OriginDiff{}, Diff(m, m)); diff != "" {
+		t.Errorf("Diff() mismatch (-want +got):\n%s", diff)
+	}
+}
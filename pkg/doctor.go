@@ -0,0 +1,206 @@
+// Checks of the environment capo runs in, reported by "capo doctor" before a
+// scan so problems surface as a clear list instead of errors deep in a scan.
+
+package capo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"go.podman.io/storage"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+	"github.com/konflux-ci/capo/pkg/storageclient"
+)
+
+// ErrDoctor is returned by "capo doctor" when any check of the environment
+// failed.
+var ErrDoctor = errors.New("[ERR_DOCTOR] environment checks failed")
+
+// CheckStatus is the result of a check of the environment.
+type CheckStatus string
+
+const (
+	CheckOK      CheckStatus = "ok"
+	CheckWarning CheckStatus = "warning"
+	CheckFailed  CheckStatus = "failed"
+	// The check was not run, as a check it depends on failed.
+	CheckSkipped CheckStatus = "skipped"
+)
+
+// Names of the checks run by Doctor, in order.
+const (
+	CheckStorage = "storage"
+	CheckDriver  = "driver"
+	CheckMount   = "mount"
+	CheckLayers  = "layers"
+	CheckImages  = "images"
+	CheckTempDir = "temp-dir"
+)
+
+// Check is the result of a single check of the environment.
+type Check struct {
+	Name    string      `json:"name"`
+	Status  CheckStatus `json:"status"`
+	Message string      `json:"message,omitempty"`
+}
+
+// Free space in the temp dir below which the temp-dir check warns.
+const doctorMinFreeBytes = 1 << 30
+
+// Doctor checks that a scan with the options can run in the environment: the
+// container storage can be opened, its driver is supported, images can be
+// mounted in the current user namespace (or, with ExtractorLayers, layers can
+// be read), the origin images of the containerfile (if not nil) are present in
+// the storage, and the temp dir has free space for extracted content. Every check is reported, checks depending
+// on a failed one are skipped.
+func Doctor(cf *containerfile.Containerfile, opts ...Option) []Check {
+	checks := make([]Check, 0, 6)
+	s, err := NewScanner(opts...)
+	if err != nil {
+		checks = append(checks,
+			Check{Name: CheckStorage, Status: CheckFailed, Message: err.Error()},
+			Check{Name: CheckDriver, Status: CheckSkipped},
+			Check{Name: CheckMount, Status: CheckSkipped},
+			Check{Name: CheckLayers, Status: CheckSkipped},
+			Check{Name: CheckImages, Status: CheckSkipped},
+		)
+		return append(checks, checkTempDir())
+	}
+
+	checks = append(checks,
+		Check{
+			Name:   CheckStorage,
+			Status: CheckOK,
+			Message: fmt.Sprintf(
+				"graph root %s, run root %s", s.store.GraphRoot(), s.store.RunRoot(),
+			),
+		},
+		s.checkDriver(),
+		s.checkMount(cf),
+		s.checkLayers(cf),
+		s.checkImages(cf),
+		checkTempDir(),
+	)
+	return checks
+}
+
+// checkDriver checks that the storage driver is overlay, the only one capo is
+// tested with.
+func (s *Scanner) checkDriver() Check {
+	driver := s.store.GraphDriverName()
+	if driver != "overlay" {
+		return Check{
+			Name:    CheckDriver,
+			Status:  CheckWarning,
+			Message: fmt.Sprintf("driver %q is not guaranteed to work, use overlay", driver),
+		}
+	}
+	return Check{Name: CheckDriver, Status: CheckOK, Message: driver}
+}
+
+// checkMount mounts and unmounts an image, the base of the first stage of the
+// containerfile if present in the storage or else any image, to check that
+// images can be mounted in the current user namespace (e.g. that capo runs in
+// "buildah unshare" when rootless). Skipped with ExtractorLayers.
+func (s *Scanner) checkMount(cf *containerfile.Containerfile) Check {
+	if s.extractorKind == ExtractorLayers {
+		return Check{Name: CheckMount, Status: CheckSkipped, Message: "images aren't mounted by the layers extractor"}
+	}
+	image, err := s.doctorImage(cf)
+	if err != nil {
+		return Check{Name: CheckMount, Status: CheckFailed, Message: err.Error()}
+	}
+	if image == nil {
+		return Check{Name: CheckMount, Status: CheckWarning, Message: "no image in storage to mount"}
+	}
+	imageID := image.ID
+
+	mountPath, err := s.store.MountImage(imageID, []string{}, "")
+	if err != nil {
+		return Check{
+			Name:   CheckMount,
+			Status: CheckFailed,
+			Message: fmt.Sprintf(
+				"could not mount image %s (run capo in buildah unshare when rootless): %v", imageID, err,
+			),
+		}
+	}
+	if _, err := s.store.UnmountImage(imageID, false); err != nil {
+		return Check{
+			Name:    CheckMount,
+			Status:  CheckFailed,
+			Message: fmt.Sprintf("could not unmount image %s from %s: %v", imageID, mountPath, err),
+		}
+	}
+	return Check{Name: CheckMount, Status: CheckOK, Message: fmt.Sprintf("mounted image %s", imageID)}
+}
+
+// doctorImage returns the image checks of the storage use: the base of the
+// first stage of the containerfile if present in the storage, or else any
+// image, nil if the storage has none.
+func (s *Scanner) doctorImage(cf *containerfile.Containerfile) (*storage.Image, error) {
+	if cf != nil && len(cf.Stages) > 0 {
+		if img, err := s.store.Image(cf.Stages[0].Base); err == nil {
+			return img, nil
+		}
+	}
+	images, err := s.store.Images()
+	if err != nil {
+		return nil, err
+	}
+	if len(images) == 0 {
+		return nil, nil
+	}
+	return &images[0], nil
+}
+
+// checkImages checks that all origin images of the containerfile are present
+// in the storage, see checkOfflineOrigins.
+func (s *Scanner) checkImages(cf *containerfile.Containerfile) Check {
+	if cf == nil {
+		return Check{Name: CheckImages, Status: CheckSkipped, Message: "no containerfile"}
+	}
+	platforms, err := stagePlatforms(*cf)
+	if err != nil {
+		return Check{Name: CheckImages, Status: CheckFailed, Message: err.Error()}
+	}
+	sclient := storageclient.WithRefPlatforms(s.sclient, platforms)
+	if err := checkOfflineOrigins(sclient, *cf, s.includeBase); err != nil {
+		return Check{Name: CheckImages, Status: CheckFailed, Message: err.Error()}
+	}
+	return Check{Name: CheckImages, Status: CheckOK}
+}
+
+// checkTempDir checks the free space in the temp dir content is extracted to
+// for scanning, and warns below doctorMinFreeBytes.
+func checkTempDir() Check {
+	dir := os.TempDir()
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return Check{Name: CheckTempDir, Status: CheckFailed, Message: fmt.Sprintf("%s: %v", dir, err)}
+	}
+	free := st.Bavail * uint64(st.Bsize)
+
+	msg := fmt.Sprintf("%d MiB free in %s", free>>20, dir)
+	if free < doctorMinFreeBytes {
+		return Check{Name: CheckTempDir, Status: CheckWarning, Message: msg}
+	}
+	return Check{Name: CheckTempDir, Status: CheckOK, Message: msg}
+}
+
+// DoctorFailed returns ErrDoctor listing the failed checks, if any.
+func DoctorFailed(checks []Check) error {
+	var failed []string
+	for _, c := range checks {
+		if c.Status == CheckFailed {
+			failed = append(failed, c.Name)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %v", ErrDoctor, failed)
+}
@@ -0,0 +1,49 @@
+//go:build unit
+
+package capo
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckTempDir(t *testing.T) {
+	t.Parallel()
+	check := checkTempDir()
+	if check.Name != CheckTempDir || (check.Status != CheckOK && check.Status != CheckWarning) {
+		t.Errorf("expected %s check %s or %s, got %+v", CheckTempDir, CheckOK, CheckWarning, check)
+	}
+}
+
+func TestDoctorFailed(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		checks   []Check
+		expected error
+	}{
+		"all ok": {
+			checks: []Check{{Name: CheckStorage, Status: CheckOK}, {Name: CheckDriver, Status: CheckOK}},
+		},
+		"warnings and skipped": {
+			checks: []Check{{Name: CheckDriver, Status: CheckWarning}, {Name: CheckImages, Status: CheckSkipped}},
+		},
+		"failed": {
+			checks: []Check{
+				{Name: CheckStorage, Status: CheckOK},
+				{Name: CheckMount, Status: CheckFailed},
+				{Name: CheckImages, Status: CheckFailed},
+			},
+			expected: ErrDoctor,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			err := DoctorFailed(test.checks)
+			if !errors.Is(err, test.expected) || (test.expected == nil) != (err == nil) {
+				t.Errorf("expected error %v, got %v", test.expected, err)
+			}
+		})
+	}
+}
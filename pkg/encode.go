@@ -0,0 +1,76 @@
+// Streaming JSON encoding of the scan output, which can have tens of
+// thousands of packages.
+
+package capo
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// EncodeJSON writes the package metadata to w as indented JSON, the same as
+// json.Encoder with two space indentation would. Packages are encoded one at
+// a time, so the whole document is never buffered in memory. Errors of
+// encoding and writing match ErrEncode.
+func (m PackageMetadata) EncodeJSON(w io.Writer) error {
+	if err := m.encodeJSON(w); err != nil {
+		return fmt.Errorf("%w: package metadata: %w", ErrEncode, err)
+	}
+	return nil
+}
+
+func (m PackageMetadata) encodeJSON(w io.Writer) error {
+	// the rest of the fields, a nil Packages field shadows the embedded one
+	rest, err := json.MarshalIndent(struct {
+		*PackageMetadata
+		Packages json.RawMessage `json:"packages,omitempty"`
+	}{PackageMetadata: &m}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString("{\n  \"packages\": ["); err != nil {
+		return err
+	}
+	for i, item := range m.Packages {
+		sep := ",\n    "
+		if i == 0 {
+			sep = "\n    "
+		}
+		b, err := json.MarshalIndent(item, "    ", "  ")
+		if err != nil {
+			return err
+		}
+		if _, err := bw.WriteString(sep); err != nil {
+			return err
+		}
+		if _, err := bw.Write(b); err != nil {
+			return err
+		}
+	}
+	if len(m.Packages) > 0 {
+		if _, err := bw.WriteString("\n  "); err != nil {
+			return err
+		}
+	}
+	if _, err := bw.WriteString("]"); err != nil {
+		return err
+	}
+
+	// rest is either "{}" or "{\n  ...\n}", continue the object with its fields
+	if len(rest) > 2 {
+		if _, err := bw.WriteString(","); err != nil {
+			return err
+		}
+		if _, err := bw.Write(rest[1 : len(rest)-2]); err != nil {
+			return err
+		}
+	}
+	if _, err := bw.WriteString("\n}\n"); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
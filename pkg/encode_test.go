@@ -0,0 +1,75 @@
+//go:build unit
+
+package capo
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEncodeJSON(t *testing.T) {
+	t.Parallel()
+	stageIndex := 0
+	tests := map[string]struct {
+		metadata PackageMetadata
+	}{
+		"no packages": {
+			metadata: PackageMetadata{Packages: []PackageMetadataItem{}},
+		},
+		"packages only": {
+			metadata: PackageMetadata{Packages: []PackageMetadataItem{
+				{
+					PackageURL: "pkg:rpm/rhel/python3@3.9.18-3.el9",
+					OriginType: "intermediate",
+					Pullspec:   "registry.access.redhat.com/ubi9/ubi-minimal@sha256:def456",
+					StageAlias: "builder",
+					StageIndex: &stageIndex,
+				},
+				{
+					PackageURL: "pkg:golang/github.com/anchore/syft@v1.32.0?a=<b>",
+					OriginType: "builder",
+					Pullspec:   "ghcr.io/anchore/syft@sha256:789fed",
+				},
+			}},
+		},
+		"packages and other fields": {
+			metadata: PackageMetadata{
+				Packages: []PackageMetadataItem{{PackageURL: "pkg:rpm/rhel/glibc@2.34-83.el9", OriginType: "builder"}},
+				Tools:    Version().Tools(),
+				Warnings: []Warning{{Code: WarnDuplicateAlias, Message: "stage alias defined twice"}},
+				Coverage: newCoverage([]CoverageSource{
+					{Source: "/app", From: "builder", Destination: "/app", Status: CoverageAttributed},
+				}),
+			},
+		},
+		"other fields without packages": {
+			metadata: PackageMetadata{
+				Packages: []PackageMetadataItem{},
+				Warnings: []Warning{{Code: WarnDuplicateAlias, Message: "stage alias defined twice"}},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var expected bytes.Buffer
+			encoder := json.NewEncoder(&expected)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(test.metadata); err != nil {
+				t.Fatalf("failed to encode expected output: %v", err)
+			}
+
+			var actual bytes.Buffer
+			if err := test.metadata.EncodeJSON(&actual); err != nil {
+				t.Fatalf("EncodeJSON returned error: %v", err)
+			}
+			if diff := cmp.Diff(expected.String(), actual.String()); diff != "" {
+				t.Errorf("EncodeJSON() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
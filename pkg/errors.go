@@ -0,0 +1,39 @@
+// Categories of the errors of capo, for consumers telling errors worth a
+// retry from fatal ones with errors.Is. Sentinels of a category wrap it, e.g.
+// fmt.Errorf("[ERR_HINTS] invalid hints: %w", ErrParse).
+
+package capo
+
+import (
+	"errors"
+)
+
+// ErrParse is the category of errors of invalid input: containerfiles with
+// features capo doesn't support, options and the files they read, e.g. hints,
+// policies and pullspec maps. Retrying with the same input fails the same way.
+// Errors of parsing the containerfile itself are containerfile.ErrParse.
+var ErrParse = errors.New("[ERR_PARSE] invalid input")
+
+// ErrResolve is the category of errors of finding images: resolving pullspecs
+// to digests, reading image configs and fetching from registries. Images
+// missing from the store may be pulled and registries may recover, so the
+// errors are worth a retry.
+var ErrResolve = errors.New("[ERR_RESOLVE] failed to resolve image")
+
+// ErrStorage is the category of errors of the container storage, e.g. failed
+// lookups of layers and contention with concurrent builds. Worth a retry,
+// unless ErrStorageSetup or ErrUnsupportedBuildahVersion.
+var ErrStorage = errors.New("[ERR_STORAGE] container storage error")
+
+// ErrExtract is the category of errors of extracting content of images for
+// the scan, with the stage or pullspec of the content. Content exceeding
+// limits (ErrExtractLimit) is fatal.
+var ErrExtract = errors.New("[ERR_EXTRACT] failed to extract content")
+
+// ErrScan is the category of errors of scanning extracted content and of
+// checking the result, e.g. by post-scan hooks.
+var ErrScan = errors.New("[ERR_SCAN] scan failed")
+
+// ErrEncode is the category of errors of encoding the result or the state of
+// a scan.
+var ErrEncode = errors.New("[ERR_ENCODE] failed to encode")
@@ -0,0 +1,77 @@
+//go:build unit
+
+package capo
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorCategories(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		err      error
+		category error
+		message  string
+	}{
+		"parse": {
+			err:      ErrHints,
+			category: ErrParse,
+			message:  "[ERR_HINTS] invalid hints: [ERR_PARSE] invalid input",
+		},
+		"resolve": {
+			err:      ErrPullspecResolve,
+			category: ErrResolve,
+			message:  "[ERR_PULLSPEC_RESOLVE] failed to resolve pullspec: [ERR_RESOLVE] failed to resolve image",
+		},
+		"storage": {
+			err:      ErrStorageContention,
+			category: ErrStorage,
+			message: "[ERR_STORAGE_CONTENTION] storage operation failed on contention: " +
+				"[ERR_STORAGE] container storage error",
+		},
+		"extract": {
+			err:      ErrExtractLimit,
+			category: ErrExtract,
+			message: "[ERR_EXTRACT_LIMIT] extracted content exceeds size limit: " +
+				"[ERR_EXTRACT] failed to extract content",
+		},
+		"scan": {
+			err:      ErrSBOMScan,
+			category: ErrScan,
+			message:  "[ERR_SBOM_SCAN] SBOM scan failed: [ERR_SCAN] scan failed",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			wrapped := fmt.Errorf("failed to scan source %q: %w", "builder", tc.err)
+			if !errors.Is(wrapped, tc.category) {
+				t.Errorf("expected %v to match %v", wrapped, tc.category)
+			}
+			if !errors.Is(wrapped, tc.err) {
+				t.Errorf("expected %v to match %v", wrapped, tc.err)
+			}
+			if tc.err.Error() != tc.message {
+				t.Errorf("expected message %q, got %q", tc.message, tc.err.Error())
+			}
+		})
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, ErrIO
+}
+
+func TestEncodeJSONError(t *testing.T) {
+	t.Parallel()
+	err := PackageMetadata{}.EncodeJSON(failingWriter{})
+	if !errors.Is(err, ErrEncode) {
+		t.Fatalf("expected error wrapping %v, got: %v", ErrEncode, err)
+	}
+}
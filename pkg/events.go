@@ -0,0 +1,69 @@
+// Events reported while a scan is running, for consumers which ingest results
+// incrementally instead of waiting for the PackageMetadata (see
+// WithEventHandler).
+
+package capo
+
+// EventType is the kind of an Event.
+type EventType string
+
+const (
+	// EventPackage is a package found by the scan.
+	EventPackage EventType = "package"
+	// EventWarning is a warning recorded by the scan.
+	EventWarning EventType = "warning"
+	// EventStats summarizes a successful scan. It's the last event of a scan.
+	EventStats EventType = "stats"
+)
+
+// Event is reported to the event handler of a Scanner as soon as it's known.
+// Exactly one of Package, Warning and Stats is set, according to Type.
+type Event struct {
+	Type    EventType            `json:"type"`
+	Package *PackageMetadataItem `json:"package,omitempty"`
+	Warning *Warning             `json:"warning,omitempty"`
+	Stats   *ScanStats           `json:"stats,omitempty"`
+}
+
+// ScanStats are the totals of a successful scan.
+type ScanStats struct {
+	// Number of reported packages and warnings.
+	Packages int `json:"packages"`
+	Warnings int `json:"warnings"`
+	// Number of images and stages the content of the final stage was traced
+	// to and scanned.
+	PackageSources int `json:"package_sources"`
+	// Number of package sources whose packages were reused from the state
+	// file of a previous scan (see WithStateFile).
+	ReusedSources int `json:"reused_sources,omitempty"`
+	// Wall time of the scan.
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+// Configure the scanner to report packages, warnings and the stats of a scan
+// to handler as soon as they're known. Packages are reported once their
+// package source is scanned, in the order of the sources. If the scan fails,
+// the events reported so far are incomplete and no EventStats is reported.
+// The handler is never called concurrently.
+func WithEventHandler(handler func(Event)) Option {
+	return func(s *Scanner) {
+		s.eventHandler = handler
+	}
+}
+
+// emit reports the event to the event handler, if any.
+func (s *Scanner) emit(event Event) {
+	if s.eventHandler == nil {
+		return
+	}
+	s.eventsMu.Lock()
+	defer s.eventsMu.Unlock()
+	s.eventHandler(event)
+}
+
+// emitPackages reports every item as an EventPackage.
+func (s *Scanner) emitPackages(items []PackageMetadataItem) {
+	for i := range items {
+		s.emit(Event{Type: EventPackage, Package: &items[i]})
+	}
+}
@@ -0,0 +1,44 @@
+//go:build unit
+
+package capo
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEmitEvents(t *testing.T) {
+	t.Parallel()
+	var events []Event
+	s := &Scanner{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	WithEventHandler(func(e Event) { events = append(events, e) })(s)
+
+	s.warn(WarnDuplicateAlias, "stage alias defined twice")
+	s.emitPackages([]PackageMetadataItem{
+		{PackageURL: "pkg:rpm/rhel/glibc@2.34-83.el9", OriginType: "builder"},
+		{PackageURL: "pkg:rpm/rhel/python3@3.9.18-3.el9", OriginType: "intermediate"},
+	})
+
+	expected := []Event{
+		{Type: EventWarning, Warning: &Warning{Code: WarnDuplicateAlias, Message: "stage alias defined twice"}},
+		{Type: EventPackage, Package: &PackageMetadataItem{PackageURL: "pkg:rpm/rhel/glibc@2.34-83.el9", OriginType: "builder"}},
+		{Type: EventPackage, Package: &PackageMetadataItem{PackageURL: "pkg:rpm/rhel/python3@3.9.18-3.el9", OriginType: "intermediate"}},
+	}
+	if diff := cmp.Diff(expected, events); diff != "" {
+		t.Errorf("emitted events mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]Warning{{Code: WarnDuplicateAlias, Message: "stage alias defined twice"}}, s.warnings); diff != "" {
+		t.Errorf("recorded warnings mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEmitWithoutHandler(t *testing.T) {
+	t.Parallel()
+	s := &Scanner{}
+	// must not panic
+	s.emitPackages([]PackageMetadataItem{{PackageURL: "pkg:rpm/rhel/glibc@2.34-83.el9"}})
+	s.emit(Event{Type: EventStats, Stats: &ScanStats{}})
+}
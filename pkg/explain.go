@@ -0,0 +1,204 @@
+// Provenance of the attribution of packages to their origins, see
+// WithExplain.
+
+package capo
+
+import (
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+	"github.com/konflux-ci/capo/pkg/storageclient"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// Configure the scanner to record, for every package copied to the final
+// image, the chains of COPY instructions through which content of its origin
+// was traced from the final stage (PackageMetadataItem.Provenance). Disabled
+// by default, as the chains repeat for every package of an origin.
+func WithExplain(explain bool) Option {
+	return func(s *Scanner) {
+		s.explain = explain
+	}
+}
+
+// CopyStep is a COPY instruction through which content was copied on its way
+// from its origin to the final image.
+type CopyStep struct {
+	// Alias and index of the stage of the instruction.
+	StageAlias string `json:"stage_alias"`
+	StageIndex int    `json:"stage_index"`
+	// One-based line of the instruction in the containerfile. Omitted if
+	// unknown.
+	Line int `json:"line,omitempty"`
+	// Stage alias or image pullspec the instruction copies from.
+	From string `json:"from"`
+	// Source of the instruction the content was traced to, and the
+	// destination of the instruction, as written.
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	// Whether the step is a hint declaring that the content at Destination
+	// is produced from the content at Source in the stage (see Hints) rather
+	// than a COPY instruction. From is the stage itself.
+	Hint bool `json:"hint,omitempty"`
+}
+
+// provenance holds the chains of COPY instructions through which sources
+// copied to the final stage were traced to their origins, the same way as by
+// traceSource. Chains start with the instruction of the final stage.
+type provenance struct {
+	cf            containerfile.Containerfile
+	baseToWorkdir map[string]string
+	// chains by the index of the stage they end in
+	stages map[int][][]CopyStep
+	// chains by the digest pullspec of the external image they end in
+	externals map[string][][]CopyStep
+}
+
+// explainSources traces the sources copied to the final stage of the
+// containerfile and returns the chains of instructions they were copied
+// through. digests are used to match external images to the pullspecs of
+// their packages.
+func explainSources(
+	cf containerfile.Containerfile,
+	baseToWorkdir map[string]string,
+	digests map[string]digest.Digest,
+) *provenance {
+	p := &provenance{
+		cf:            cf,
+		baseToWorkdir: baseToWorkdir,
+		stages:        make(map[int][][]CopyStep),
+		externals:     make(map[string][][]CopyStep),
+	}
+
+	final := cf.FinalStage()
+	if final == nil {
+		return p
+	}
+	for _, cp := range final.Copies {
+		// named contexts aren't traced, see getPackageSources
+		if cp.Type == containerfile.CopyTypeContext {
+			continue
+		}
+		for _, source := range cp.Sources {
+			p.trace(source, cp, final, nil)
+		}
+	}
+
+	// packages of external images have their digest pullspec
+	externals := make(map[string][][]CopyStep, len(p.externals))
+	for pullspec, chains := range p.externals {
+		key := pullspec
+		if dig, ok := digests[pullspec]; ok {
+			if withDigest, err := attachDigest(storageclient.StripTransport(pullspec), dig); err == nil {
+				key = withDigest
+			}
+		}
+		externals[key] = append(externals[key], chains...)
+	}
+	p.externals = externals
+	return p
+}
+
+// trace records the chain extended by the copy of the source in the stage and
+// follows the source to the stage or image the copy is from.
+func (p *provenance) trace(source string, cp containerfile.Copy, stage *containerfile.Stage, chain []CopyStep) {
+	chain = append(slices.Clone(chain), CopyStep{
+		StageAlias:  stage.Alias,
+		StageIndex:  stage.Index,
+		Line:        cp.Line,
+		From:        cp.From,
+		Source:      source,
+		Destination: cp.Destination,
+	})
+
+	from := p.cf.ResolveRef(cp.From, stage.Index)
+	if from == nil {
+		p.externals[cp.From] = appendChain(p.externals[cp.From], chain)
+		return
+	}
+	p.traceStage(source, from, chain)
+}
+
+// traceStage follows the source in the stage through its copies from other
+// stages and images, and records the chain for the stage if the source can
+// contain content of the stage itself, with the same rules as traceSource.
+func (p *provenance) traceStage(source string, stage *containerfile.Stage, chain []CopyStep) {
+	coversMultipleFiles := strings.HasSuffix(source, "/") || strings.ContainsAny(source, "*?[]")
+	baseWorkdir, ok := p.baseToWorkdir[stage.Base]
+	if !ok {
+		baseWorkdir = "/"
+	}
+	candidates := movedSources(source, stage, baseWorkdir)
+
+	foundAncestor := false
+	for _, cp := range stage.Copies {
+		dest := cp.Destination
+		if !path.IsAbs(dest) {
+			dest = resolveRelativeDestination(cp, baseWorkdir)
+		}
+
+		for _, candidate := range candidates {
+			sourceCoversDestination := isPathUnderPattern(candidate, dest)
+			if !sourceCoversDestination && !isPathUnderPattern(dest, candidate) {
+				continue
+			}
+			foundAncestor = true
+			if sourceCoversDestination && candidate != dest {
+				coversMultipleFiles = true
+			}
+			for _, s := range cp.Sources {
+				p.trace(s, cp, stage, hintChain(chain, source, candidate, stage))
+			}
+			break
+		}
+	}
+
+	if coversMultipleFiles || !foundAncestor {
+		p.stages[stage.Index] = appendChain(p.stages[stage.Index], chain)
+	}
+
+	// chained stage, its content is also from its parent
+	if parent := p.cf.ResolveRef(stage.BaseRef, stage.Index); parent != nil {
+		for _, candidate := range candidates {
+			p.traceStage(candidate, parent, hintChain(chain, source, candidate, stage))
+		}
+	}
+}
+
+// apply sets the provenance of the packages by the stage or external image
+// of their origin. Packages of content mounted at build time are skipped, as
+// they weren't copied. Does nothing if p is nil.
+func (p *provenance) apply(items []PackageMetadataItem) {
+	if p == nil {
+		return
+	}
+	for i := range items {
+		items[i].Provenance = p.chains(items[i])
+	}
+}
+
+// chains returns the chains of instructions the content of the origin of the
+// package was copied through, nil for packages of content mounted at build
+// time and of other origins than stages and external images.
+func (p *provenance) chains(item PackageMetadataItem) [][]CopyStep {
+	switch {
+	case item.BuildTime:
+		return nil
+	case item.StageIndex != nil:
+		return p.stages[*item.StageIndex]
+	case item.OriginType == "external":
+		return p.externals[item.Pullspec]
+	}
+	return nil
+}
+
+// appendChain appends the chain to chains, unless it's already there.
+func appendChain(chains [][]CopyStep, chain []CopyStep) [][]CopyStep {
+	if slices.ContainsFunc(chains, func(c []CopyStep) bool { return slices.Equal(c, chain) }) {
+		return chains
+	}
+	return append(chains, chain)
+}
@@ -0,0 +1,103 @@
+//go:build unit
+
+package capo
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+)
+
+func TestExplainSources(t *testing.T) {
+	t.Parallel()
+	const tools = "quay.io/konflux-ci/tools:v1"
+	toolsDigest := digest.Digest("sha256:" + "ab12cd34ef56ab12cd34ef56ab12cd34ef56ab12cd34ef56ab12cd34ef56ab12")
+	cf := containerfile.Containerfile{Stages: []containerfile.Stage{
+		{
+			Alias:   "builder",
+			Base:    "docker.io/library/golang:1.26",
+			BaseRef: "docker.io/library/golang:1.26",
+		},
+		{
+			Alias:   "assembler",
+			Base:    "registry.access.redhat.com/ubi9/ubi:latest",
+			BaseRef: "registry.access.redhat.com/ubi9/ubi:latest",
+			Index:   1,
+			Copies: []containerfile.Copy{
+				{
+					Sources: []string{"/app/bin/app"}, Destination: "/out/app",
+					From: "builder", Type: containerfile.CopyTypeBuilder, Line: 5,
+				},
+				{
+					Sources: []string{"/usr/bin/tool"}, Destination: "tool", Workdir: "/out",
+					From: tools, Type: containerfile.CopyTypeExternal, Line: 7,
+				},
+			},
+		},
+		{
+			Alias:   "2",
+			Base:    "registry.access.redhat.com/ubi9/ubi-micro:latest",
+			BaseRef: "registry.access.redhat.com/ubi9/ubi-micro:latest",
+			Index:   2,
+			Kind:    containerfile.StageKindFinal,
+			Copies: []containerfile.Copy{{
+				Sources: []string{"/out/"}, Destination: "/usr/local/bin/",
+				From: "assembler", Type: containerfile.CopyTypeBuilder, Line: 9,
+			}},
+		},
+	}}
+
+	finalStep := CopyStep{
+		StageAlias: "2", StageIndex: 2, Line: 9, From: "assembler", Source: "/out/", Destination: "/usr/local/bin/",
+	}
+	index := func(i int) *int { return &i }
+	items := []PackageMetadataItem{
+		{PackageURL: "pkg:golang/example.com/app@v1.0.0", OriginType: "builder", StageIndex: index(0)},
+		{PackageURL: "pkg:rpm/redhat/bash@5.1", OriginType: "intermediate", StageIndex: index(1)},
+		{
+			PackageURL: "pkg:generic/tool@1.0", OriginType: "external",
+			Pullspec: "quay.io/konflux-ci/tools@" + toolsDigest.String(),
+		},
+		{PackageURL: "pkg:golang/example.com/cache@v0.1.0", OriginType: "builder", StageIndex: index(0), BuildTime: true},
+		{PackageURL: "pkg:rpm/redhat/glibc@2.34", OriginType: "base"},
+	}
+
+	explainSources(cf, nil, map[string]digest.Digest{tools: toolsDigest}).apply(items)
+
+	expected := [][][]CopyStep{
+		{{
+			finalStep,
+			{
+				StageAlias: "assembler", StageIndex: 1, Line: 5, From: "builder",
+				Source: "/app/bin/app", Destination: "/out/app",
+			},
+		}},
+		{{finalStep}},
+		{{
+			finalStep,
+			{StageAlias: "assembler", StageIndex: 1, Line: 7, From: tools, Source: "/usr/bin/tool", Destination: "tool"},
+		}},
+		nil,
+		nil,
+	}
+	actual := make([][][]CopyStep, 0, len(items))
+	for _, item := range items {
+		actual = append(actual, item.Provenance)
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("Provenance mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestProvenanceApplyNil(t *testing.T) {
+	t.Parallel()
+	items := []PackageMetadataItem{{PackageURL: "pkg:rpm/redhat/bash@5.1", OriginType: "builder"}}
+	var p *provenance
+	p.apply(items)
+	if items[0].Provenance != nil {
+		t.Errorf("expected no provenance, got %v", items[0].Provenance)
+	}
+}
@@ -0,0 +1,165 @@
+// Export of the content extracted for scanning, see WithExportContent.
+
+package capo
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var ErrExport = fmt.Errorf("[ERR_EXPORT] failed to export extracted content: %w", ErrExtract)
+
+// Configure the scanner to export the content extracted for scanning, exactly
+// as it's scanned with syft, to dest in the layout of debug mode (see
+// debugEnv): <origin>/<kind>/, where kind is builder, intermediate or
+// package-db. If dest ends with ".tar", the content is written to a tar
+// archive at dest, otherwise to the directory dest, created if missing. The
+// base image scanned with WithScanBase isn't exported, as its content is the
+// whole image, nor are package sources reused from the state file (see
+// WithStateFile), which aren't extracted. Disabled if empty.
+func WithExportContent(dest string) Option {
+	return func(s *Scanner) {
+		s.exportContent = dest
+	}
+}
+
+// contentExport writes directories of extracted content to the destination
+// of WithExportContent. Safe for concurrent use by scans of package sources.
+type contentExport struct {
+	dest string
+
+	mu sync.Mutex
+	// the archive if dest is a tar archive, nil for a directory
+	file *os.File
+	tw   *tar.Writer
+	// exported <origin>/<kind> directories
+	dirs map[string]bool
+}
+
+// newContentExport creates the archive or directory at dest.
+func newContentExport(dest string) (*contentExport, error) {
+	e := &contentExport{dest: dest, dirs: make(map[string]bool)}
+	if !strings.HasSuffix(dest, ".tar") {
+		if err := os.MkdirAll(dest, 0o755); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrExport, err)
+		}
+		return e, nil
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrExport, err)
+	}
+	e.file = f
+	e.tw = tar.NewWriter(f)
+	return e, nil
+}
+
+// dir returns the directory of content of the kind of origin, relative to
+// the destination. Origins with the same directory name get a numbered
+// suffix, as in the debug layout.
+func (e *contentExport) dir(origin, kind string) string {
+	name := debugDirName(origin)
+	dir := filepath.Join(name, kind)
+	for i := 2; e.dirs[dir]; i++ {
+		dir = filepath.Join(fmt.Sprintf("%s-%d", name, i), kind)
+	}
+	e.dirs[dir] = true
+	return dir
+}
+
+// export exports the content at contentPath as content of the kind of origin.
+func (s *Scanner) export(ctx context.Context, origin, kind, contentPath string) error {
+	e := s.contentExport
+	if e == nil || contentPath == "" {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	dir := e.dir(origin, kind)
+	if e.tw == nil {
+		if err := s.copyTree(ctx, contentPath, filepath.Join(e.dest, dir)); err != nil {
+			return fmt.Errorf("%w: %w", ErrExport, err)
+		}
+		return nil
+	}
+	if err := writeTarTree(e.tw, contentPath, dir); err != nil {
+		return fmt.Errorf("%w: %w", ErrExport, err)
+	}
+	return nil
+}
+
+// writeTarTree writes the tree at src to the archive under the directory
+// prefix. Other files than directories, regular files and symbolic links are
+// skipped.
+func writeTarTree(tw *tar.Writer, src string, prefix string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		var link string
+		switch {
+		case d.IsDir(), d.Type().IsRegular():
+		case d.Type()&fs.ModeSymlink != 0:
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(filepath.Join(prefix, rel))
+		if d.IsDir() {
+			header.Name += "/"
+		}
+		// ownership of the extracting user is meaningless in the archive
+		header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
+
+// close finishes the archive, if the destination is one.
+func (e *contentExport) close() error {
+	if e.tw == nil {
+		return nil
+	}
+	err := errors.Join(e.tw.Close(), e.file.Close())
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrExport, err)
+	}
+	return nil
+}
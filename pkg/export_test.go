@@ -0,0 +1,125 @@
+//go:build unit
+
+package capo
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// writeContent creates a content directory with a file and a symbolic link.
+func writeContent(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "usr", "bin"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "usr", "bin", "app"), []byte("app"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("app", filepath.Join(dir, "usr", "bin", "link")); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestExportContentDir(t *testing.T) {
+	t.Parallel()
+	dest := filepath.Join(t.TempDir(), "export")
+	e, err := newContentExport(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Scanner{logger: slog.Default(), contentExport: e}
+
+	content := writeContent(t)
+	if err := s.export(context.Background(), "builder", debugKindIntermediate, content); err != nil {
+		t.Fatal(err)
+	}
+	// the same alias defined twice
+	if err := s.export(context.Background(), "builder", debugKindIntermediate, content); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dir := range []string{"builder", "builder-2"} {
+		data, err := os.ReadFile(filepath.Join(dest, dir, debugKindIntermediate, "usr", "bin", "app"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "app" {
+			t.Errorf("unexpected content of %s: %q", dir, data)
+		}
+		link, err := os.Readlink(filepath.Join(dest, dir, debugKindIntermediate, "usr", "bin", "link"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if link != "app" {
+			t.Errorf("unexpected link target in %s: %q", dir, link)
+		}
+	}
+}
+
+func TestExportContentTar(t *testing.T) {
+	t.Parallel()
+	dest := filepath.Join(t.TempDir(), "content.tar")
+	e, err := newContentExport(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Scanner{logger: slog.Default(), contentExport: e}
+
+	content := writeContent(t)
+	if err := s.export(context.Background(), "quay.io/org/app@sha256:abc", debugKindBuilder, content); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var names []string
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+	}
+	expected := []string{
+		"sha256-abc/builder/",
+		"sha256-abc/builder/usr/",
+		"sha256-abc/builder/usr/bin/",
+		"sha256-abc/builder/usr/bin/app",
+		"sha256-abc/builder/usr/bin/link",
+	}
+	if diff := cmp.Diff(expected, names); diff != "" {
+		t.Errorf("unexpected archive entries (-want +got):\n%s", diff)
+	}
+}
+
+func TestExportDisabled(t *testing.T) {
+	t.Parallel()
+	s := &Scanner{logger: slog.Default()}
+	if err := s.export(context.Background(), "builder", debugKindBuilder, t.TempDir()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
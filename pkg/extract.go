@@ -0,0 +1,296 @@
+// Extraction of layer diff tar streams into content directories for syft
+// scanning. Layer content comes from untrusted third-party builder images, so
+// the extractor bounds the amount of data it writes and skips entries it has
+// no use for instead of failing on them.
+
+package capo
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// DefaultMaxFileBytes is the default limit of the size of a single file
+	// extracted from a layer diff.
+	DefaultMaxFileBytes int64 = 4 << 30
+	// DefaultMaxExtractBytes is the default limit of bytes written for all
+	// files extracted from a single layer diff.
+	DefaultMaxExtractBytes int64 = 32 << 30
+)
+
+// DefaultExtractPermMask is the default mask of permission bits cleared from
+// the modes of extracted files and directories, like a umask.
+const DefaultExtractPermMask os.FileMode = 0o022
+
+const (
+	// Blocks of zeros of this size are left as holes in extracted files, so
+	// sparse files keep their layout on disk.
+	sparseBlockSize = 4096
+	// Maximum length of a single path component on Linux (NAME_MAX).
+	maxNameLength = 255
+	// Maximum length of a path on Linux (PATH_MAX).
+	maxPathLength = 4096
+)
+
+var ErrExtractLimit = fmt.Errorf("[ERR_EXTRACT_LIMIT] extracted content exceeds size limit: %w", ErrExtract)
+var ErrPathTraversal = fmt.Errorf("[ERR_PATH_TRAVERSAL] path escapes extraction root: %w", ErrExtract)
+
+// extractTar reads a layer diff tar stream and writes the entries matching
+// sources to dest. Returns the names of all matching entries.
+//
+// Only directories and regular files (including sparse ones) are written.
+// Device nodes and FIFOs are skipped and not reported, entries with names
+// escaping dest (see secureJoin) or exceeding filesystem name limits are
+// skipped with a warning.
+// Modes of extracted entries are preserved within the bounds of extractMode.
+// Extraction fails with ErrExtractLimit when the size of a single file exceeds
+// the per-file limit or when the bytes written for the whole stream exceed the
+// total limit. Holes in sparse files don't count towards the total limit.
+// Extraction stops between entries when ctx is done.
+func (s *Scanner) extractTar(ctx context.Context, r io.Reader, dest string, sources []string) ([]string, error) {
+	return s.extractTarWhiteouts(ctx, r, dest, sources, nil)
+}
+
+// extractTarWhiteouts extracts the tar stream like extractTar, but passes the
+// names of whiteout files (see applyWhiteout) to whiteout instead, if not nil.
+func (s *Scanner) extractTarWhiteouts(
+	ctx context.Context, r io.Reader, dest string, sources []string, whiteout func(name string) error,
+) ([]string, error) {
+	included := make([]string, 0, 16)
+	var total int64
+
+	reader := tar.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return []string{}, fmt.Errorf("extraction to %q canceled: %w", dest, err)
+		}
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return []string{}, fmt.Errorf("failed to read tar header: %w: %w", err, ErrIO)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			s.matchTrace.excluded(header.Name, traceExcludedDevice)
+			continue
+		}
+
+		if whiteout != nil && isWhiteout(header.Name) {
+			s.matchTrace.excluded(header.Name, traceExcludedWhiteout)
+			if err := whiteout(header.Name); err != nil {
+				return []string{}, err
+			}
+			continue
+		}
+
+		pattern, ok := matchingPattern(sources, header.Name)
+		if !ok {
+			s.matchTrace.excluded(header.Name, traceExcludedNoSource)
+			continue
+		}
+
+		target, err := secureJoin(dest, header.Name)
+		if err != nil {
+			if errors.Is(err, ErrPathTraversal) {
+				s.matchTrace.excluded(header.Name, traceExcludedTraversal)
+				s.logger.Warn("skipping tar entry outside of extraction root", "name", header.Name, "error", err)
+				continue
+			}
+			return []string{}, err
+		}
+		if exceedsPathLimits(target) {
+			s.matchTrace.excluded(header.Name, traceExcludedTooLong)
+			s.logger.Warn("skipping tar entry with too long name", "name", header.Name)
+			continue
+		}
+
+		s.matchTrace.matched(header.Name, pattern)
+		included = append(included, header.Name)
+
+		// layers applied on top of each other may replace a file of a lower
+		// layer with a directory or the other way around
+		if whiteout != nil {
+			if info, err := os.Lstat(target); err == nil && info.IsDir() != (header.Typeflag == tar.TypeDir) {
+				if err := os.RemoveAll(target); err != nil {
+					return []string{}, fmt.Errorf("failed to remove %q: %w: %w", target, err, ErrIO)
+				}
+			}
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := s.mkdir(target, header.FileInfo().Mode()); err != nil {
+				return []string{}, err
+			}
+		case tar.TypeReg, tar.TypeGNUSparse:
+			// sometimes the archive does not have headers for directories
+			if err := os.MkdirAll(filepath.Dir(target), s.extractMode(0o755, true)); err != nil {
+				return []string{}, fmt.Errorf("failed to create directory %q: %w: %w", filepath.Dir(target), err, ErrIO)
+			}
+			// the logical size is checked, so hostile sparse headers can't
+			// make the extractor spin on gigabytes of holes
+			if header.Size > s.maxFileBytes {
+				return []string{}, fmt.Errorf(
+					"file %q of %d bytes exceeds the limit of %d bytes per file: %w",
+					header.Name, header.Size, s.maxFileBytes, ErrExtractLimit,
+				)
+			}
+
+			mode := s.extractMode(header.FileInfo().Mode(), false)
+			f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+			if err != nil {
+				return []string{}, fmt.Errorf("failed to create file %q: %w: %w", target, err, ErrIO)
+			}
+
+			written, err := writeSparse(f, reader, s.maxExtractBytes-total)
+			total += written
+			_ = f.Close()
+			if err != nil {
+				return []string{}, fmt.Errorf(
+					"failed to extract %q within the limit of %d bytes in total: %w",
+					header.Name, s.maxExtractBytes, err,
+				)
+			}
+			// the mode passed to OpenFile is subject to the process umask and
+			// ignored for existing files
+			if err := os.Chmod(target, mode); err != nil {
+				return []string{}, fmt.Errorf("failed to set mode of %q: %w: %w", target, err, ErrIO)
+			}
+			s.fileOwners.record(target, header.Uid, header.Gid)
+		}
+	}
+
+	return included, nil
+}
+
+// extractMode bounds the original mode of extracted content. Only permission
+// bits are kept (no setuid, setgid or sticky bits), bits set in the scanner
+// permission mask are cleared and the owner can always read and write files
+// and traverse directories, so capo can scan and clean up the content.
+func (s *Scanner) extractMode(mode os.FileMode, dir bool) os.FileMode {
+	perm := mode.Perm() &^ s.permMask
+	if dir {
+		return perm | 0o700
+	}
+	return perm | 0o600
+}
+
+// mkdir creates the directory at path with the bounded mode, including
+// missing parents, and sets the mode of an already existing directory.
+func (s *Scanner) mkdir(path string, mode os.FileMode) error {
+	mode = s.extractMode(mode, true)
+	if err := os.MkdirAll(path, mode); err != nil {
+		return fmt.Errorf("failed to create directory %q: %w: %w", path, err, ErrIO)
+	}
+	// the mode passed to MkdirAll is subject to the process umask
+	if err := os.Chmod(path, mode); err != nil {
+		return fmt.Errorf("failed to set mode of %q: %w: %w", path, err, ErrIO)
+	}
+	return nil
+}
+
+// writeSparse copies r to f block by block, seeking over blocks of zeros
+// instead of writing them. Returns the number of bytes actually written.
+// Fails with ErrExtractLimit if more than limit bytes would be written.
+func writeSparse(f *os.File, r io.Reader, limit int64) (int64, error) {
+	buf := make([]byte, 32*sparseBlockSize)
+	var offset, written int64
+
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		for start := 0; start < n; start += sparseBlockSize {
+			block := buf[start:min(start+sparseBlockSize, n)]
+			if !isZeroBlock(block) {
+				if written+int64(len(block)) > limit {
+					return written, ErrExtractLimit
+				}
+				if _, err := f.WriteAt(block, offset); err != nil {
+					return written, fmt.Errorf("failed to copy file content: %w: %w", err, ErrIO)
+				}
+				written += int64(len(block))
+			}
+			offset += int64(len(block))
+		}
+
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return written, fmt.Errorf("failed to copy file content: %w: %w", readErr, ErrIO)
+		}
+	}
+
+	// extends the file over a trailing hole
+	if err := f.Truncate(offset); err != nil {
+		return written, fmt.Errorf("failed to set file size: %w: %w", err, ErrIO)
+	}
+
+	return written, nil
+}
+
+func isZeroBlock(block []byte) bool {
+	for _, b := range block {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// secureJoin joins the untrusted relative name to root and returns the result
+// only if it stays within root. Fails with ErrPathTraversal for absolute names,
+// names escaping root through ".." and names whose existing components in root
+// are symbolic links, since writing through those could reach outside of root.
+// Components that don't exist yet are fine, as the caller creates them.
+func secureJoin(root string, name string) (string, error) {
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("name %q is not local: %w", name, ErrPathTraversal)
+	}
+
+	target := filepath.Join(root, name)
+	rel, err := filepath.Rel(root, target)
+	if err != nil {
+		return "", fmt.Errorf("failed to get relative path for %q: %w: %w", target, err, ErrIO)
+	}
+
+	current := root
+	for part := range strings.SplitSeq(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to stat %q: %w: %w", current, err, ErrIO)
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("name %q resolves through symbolic link %q: %w", name, current, ErrPathTraversal)
+		}
+	}
+
+	return target, nil
+}
+
+// exceedsPathLimits reports whether the path or any of its components is too
+// long to be created on Linux filesystems.
+func exceedsPathLimits(p string) bool {
+	if len(p) >= maxPathLength {
+		return true
+	}
+	for part := range strings.SplitSeq(p, string(filepath.Separator)) {
+		if len(part) > maxNameLength {
+			return true
+		}
+	}
+	return false
+}
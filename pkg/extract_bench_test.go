@@ -0,0 +1,89 @@
+//go:build unit
+
+package capo
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// benchmarkLayer returns a layer diff fixture with the passed number of
+// directories, each holding a binary, a sparse file and small text files,
+// and the sources matching half of the directories.
+func benchmarkLayer(b *testing.B, dirs int) ([]byte, []string) {
+	b.Helper()
+	binary := bytes.Repeat([]byte("\x7fELF"), 64*1024)
+	sparse := append(make([]byte, 256*sparseBlockSize), []byte("tail")...)
+
+	var entries []tarEntry
+	var sources []string
+	for i := range dirs {
+		dir := fmt.Sprintf("opt/app%d", i)
+		entries = append(entries,
+			tarEntry{name: dir + "/", typeflag: tar.TypeDir},
+			tarEntry{name: dir + "/bin/app", typeflag: tar.TypeReg, content: binary, mode: 0o755},
+			tarEntry{name: dir + "/data.img", typeflag: tar.TypeReg, content: sparse},
+		)
+		for j := range 20 {
+			entries = append(entries, tarEntry{
+				name:     fmt.Sprintf("%s/share/doc%d.txt", dir, j),
+				typeflag: tar.TypeReg,
+				content:  []byte("documentation"),
+			})
+		}
+		if i%2 == 0 {
+			sources = append(sources, "/"+dir)
+		}
+	}
+
+	return buildTar(b, entries), sources
+}
+
+func BenchmarkExtractTar(b *testing.B) {
+	for _, dirs := range []int{10, 100} {
+		b.Run(fmt.Sprintf("dirs=%d", dirs), func(b *testing.B) {
+			layer, sources := benchmarkLayer(b, dirs)
+			s := newExtractScanner(DefaultMaxFileBytes, DefaultMaxExtractBytes)
+			b.SetBytes(int64(len(layer)))
+
+			dest := filepath.Join(b.TempDir(), "content")
+
+			for b.Loop() {
+				if _, err := s.extractTar(context.Background(), bytes.NewReader(layer), dest, sources); err != nil {
+					b.Fatalf("unexpected error: %v", err)
+				}
+
+				// keep the scratch space of the benchmark bounded
+				b.StopTimer()
+				if err := os.RemoveAll(dest); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func BenchmarkIncludes(b *testing.B) {
+	for _, patterns := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("patterns=%d", patterns), func(b *testing.B) {
+			sources := make([]string, 0, patterns)
+			for i := range patterns {
+				sources = append(sources, fmt.Sprintf("/opt/app%d/*/lib*", i))
+			}
+			// matches only the last pattern, so all of them are evaluated
+			path := fmt.Sprintf("opt/app%d/x86_64/libfoo/foo.so", patterns-1)
+
+			for b.Loop() {
+				if !Includes(sources, path) {
+					b.Fatal("expected path to be included")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,551 @@
+//go:build unit
+
+package capo
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	content  []byte
+	// Defaults to 0644 if not set.
+	mode     int64
+	uid, gid int
+}
+
+func buildTar(t testing.TB, entries []tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Mode:     0644,
+			Size:     int64(len(e.content)),
+			Uid:      e.uid,
+			Gid:      e.gid,
+		}
+		if e.mode != 0 {
+			hdr.Mode = e.mode
+		}
+		if e.typeflag != tar.TypeReg {
+			hdr.Size = 0
+		}
+		if err := w.WriteHeader(hdr); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		if hdr.Size > 0 {
+			if _, err := w.Write(e.content); err != nil {
+				t.Fatalf("failed to write tar content: %v", err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func newExtractScanner(maxFileBytes, maxExtractBytes int64) *Scanner {
+	return &Scanner{
+		logger:          slog.Default(),
+		maxFileBytes:    maxFileBytes,
+		maxExtractBytes: maxExtractBytes,
+	}
+}
+
+func TestExtractTar(t *testing.T) {
+	t.Parallel()
+	sparseContent := append(append([]byte("head"), make([]byte, 64*sparseBlockSize)...), []byte("tail")...)
+	longName := "opt/" + strings.Repeat("a", maxNameLength+1)
+
+	tests := map[string]struct {
+		entries          []tarEntry
+		sources          []string
+		links            map[string]string
+		maxFileBytes     int64
+		maxExtractBytes  int64
+		expectedIncluded []string
+		expectedFiles    map[string][]byte
+		expectedErr      error
+	}{
+		"regular files and directories": {
+			entries: []tarEntry{
+				{name: "usr/", typeflag: tar.TypeDir},
+				{name: "usr/bin/", typeflag: tar.TypeDir},
+				{name: "usr/bin/helm", typeflag: tar.TypeReg, content: []byte("helm")},
+				{name: "etc/passwd", typeflag: tar.TypeReg, content: []byte("root")},
+			},
+			sources:          []string{"/usr"},
+			expectedIncluded: []string{"usr/", "usr/bin/", "usr/bin/helm"},
+			expectedFiles:    map[string][]byte{"usr/bin/helm": []byte("helm")},
+		},
+		"names with special characters": {
+			entries: []tarEntry{
+				{name: "opt/my app/go.mod", typeflag: tar.TypeReg, content: []byte("space")},
+				{name: "opt/données/go.mod", typeflag: tar.TypeReg, content: []byte("unicode")},
+				{name: "opt/[id]/go.mod", typeflag: tar.TypeReg, content: []byte("brackets")},
+				{name: "opt/other/go.mod", typeflag: tar.TypeReg, content: []byte("other")},
+			},
+			sources:          []string{"/opt/my app", "/opt/données", "/opt/[id]"},
+			expectedIncluded: []string{"opt/my app/go.mod", "opt/données/go.mod", "opt/[id]/go.mod"},
+			expectedFiles: map[string][]byte{
+				"opt/my app/go.mod":  []byte("space"),
+				"opt/données/go.mod": []byte("unicode"),
+				"opt/[id]/go.mod":    []byte("brackets"),
+			},
+		},
+		"zero blocks are preserved": {
+			entries: []tarEntry{
+				{name: "opt/sparse", typeflag: tar.TypeReg, content: sparseContent},
+			},
+			sources:          []string{"/opt"},
+			expectedIncluded: []string{"opt/sparse"},
+			expectedFiles:    map[string][]byte{"opt/sparse": sparseContent},
+		},
+		"zero blocks do not count towards total limit": {
+			entries: []tarEntry{
+				{name: "opt/sparse", typeflag: tar.TypeReg, content: sparseContent},
+			},
+			sources:          []string{"/opt"},
+			maxExtractBytes:  2 * sparseBlockSize,
+			expectedIncluded: []string{"opt/sparse"},
+			expectedFiles:    map[string][]byte{"opt/sparse": sparseContent},
+		},
+		"device nodes and fifos are skipped": {
+			entries: []tarEntry{
+				{name: "dev/null", typeflag: tar.TypeChar},
+				{name: "dev/sda", typeflag: tar.TypeBlock},
+				{name: "dev/fifo", typeflag: tar.TypeFifo},
+				{name: "dev/file", typeflag: tar.TypeReg, content: []byte("file")},
+			},
+			sources:          []string{"/dev"},
+			expectedIncluded: []string{"dev/file"},
+			expectedFiles:    map[string][]byte{"dev/file": []byte("file")},
+		},
+		"names escaping the root are skipped": {
+			entries: []tarEntry{
+				{name: "../escaped", typeflag: tar.TypeReg, content: []byte("escaped")},
+				{name: "opt/../../escaped", typeflag: tar.TypeReg, content: []byte("escaped")},
+				{name: "/abs", typeflag: tar.TypeReg, content: []byte("abs")},
+				{name: "opt/file", typeflag: tar.TypeReg, content: []byte("file")},
+			},
+			sources:          []string{"/"},
+			expectedIncluded: []string{"opt/file"},
+			expectedFiles:    map[string][]byte{"opt/file": []byte("file")},
+		},
+		"names resolving through symlinks are skipped": {
+			entries: []tarEntry{
+				{name: "opt/link/escaped", typeflag: tar.TypeReg, content: []byte("escaped")},
+				{name: "opt/file", typeflag: tar.TypeReg, content: []byte("file")},
+			},
+			sources:          []string{"/opt"},
+			links:            map[string]string{"opt/link": "/tmp"},
+			expectedIncluded: []string{"opt/file"},
+			expectedFiles:    map[string][]byte{"opt/file": []byte("file")},
+		},
+		"too long names are skipped": {
+			entries: []tarEntry{
+				{name: longName, typeflag: tar.TypeReg, content: []byte("long")},
+				{name: "opt/short", typeflag: tar.TypeReg, content: []byte("short")},
+			},
+			sources:          []string{"/opt"},
+			expectedIncluded: []string{"opt/short"},
+			expectedFiles:    map[string][]byte{"opt/short": []byte("short")},
+		},
+		"per-file limit": {
+			entries: []tarEntry{
+				{name: "opt/big", typeflag: tar.TypeReg, content: bytes.Repeat([]byte("x"), 3*sparseBlockSize)},
+			},
+			sources:      []string{"/opt"},
+			maxFileBytes: 2 * sparseBlockSize,
+			expectedErr:  ErrExtractLimit,
+		},
+		"total limit": {
+			entries: []tarEntry{
+				{name: "opt/a", typeflag: tar.TypeReg, content: bytes.Repeat([]byte("a"), sparseBlockSize)},
+				{name: "opt/b", typeflag: tar.TypeReg, content: bytes.Repeat([]byte("b"), sparseBlockSize)},
+			},
+			sources:         []string{"/opt"},
+			maxExtractBytes: sparseBlockSize + 1,
+			expectedErr:     ErrExtractLimit,
+		},
+		"limits only apply to included content": {
+			entries: []tarEntry{
+				{name: "var/big", typeflag: tar.TypeReg, content: bytes.Repeat([]byte("x"), 3*sparseBlockSize)},
+				{name: "opt/small", typeflag: tar.TypeReg, content: []byte("small")},
+			},
+			sources:          []string{"/opt"},
+			maxFileBytes:     sparseBlockSize,
+			expectedIncluded: []string{"opt/small"},
+			expectedFiles:    map[string][]byte{"opt/small": []byte("small")},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			maxFileBytes, maxExtractBytes := DefaultMaxFileBytes, DefaultMaxExtractBytes
+			if tc.maxFileBytes > 0 {
+				maxFileBytes = tc.maxFileBytes
+			}
+			if tc.maxExtractBytes > 0 {
+				maxExtractBytes = tc.maxExtractBytes
+			}
+			s := newExtractScanner(maxFileBytes, maxExtractBytes)
+
+			dest := t.TempDir()
+			for link, target := range tc.links {
+				if err := os.MkdirAll(filepath.Dir(filepath.Join(dest, link)), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Symlink(target, filepath.Join(dest, link)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			included, err := s.extractTar(context.Background(), bytes.NewReader(buildTar(t, tc.entries)), dest, tc.sources)
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Fatalf("expected error wrapping %v, got: %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := cmp.Diff(tc.expectedIncluded, included, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("extractTar() included mismatch (-want +got):\n%s", diff)
+			}
+
+			files := make(map[string][]byte)
+			err = filepath.WalkDir(dest, func(p string, d os.DirEntry, err error) error {
+				if err != nil || !d.Type().IsRegular() {
+					return err
+				}
+				rel, err := filepath.Rel(dest, p)
+				if err != nil {
+					return err
+				}
+				files[rel], err = os.ReadFile(p)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.expectedFiles, files, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("extracted files mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExtractMode(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		mode     os.FileMode
+		dir      bool
+		permMask os.FileMode
+		expected os.FileMode
+	}{
+		"file mode is preserved": {
+			mode:     0o640,
+			expected: 0o640,
+		},
+		"executable file": {
+			mode:     0o755,
+			expected: 0o755,
+		},
+		"special bits are dropped": {
+			mode:     0o755 | os.ModeSetuid | os.ModeSetgid | os.ModeSticky,
+			expected: 0o755,
+		},
+		"owner can read and write files": {
+			mode:     0o044,
+			expected: 0o644,
+		},
+		"owner can traverse directories": {
+			mode:     0o055,
+			dir:      true,
+			expected: 0o755,
+		},
+		"mask clears bits": {
+			mode:     0o777,
+			permMask: 0o077,
+			expected: 0o700,
+		},
+		"mask does not clear owner bits": {
+			mode:     0o644,
+			permMask: 0o777,
+			expected: 0o600,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			s := &Scanner{permMask: tc.permMask}
+			if got := s.extractMode(tc.mode, tc.dir); got != tc.expected {
+				t.Errorf("extractMode(%v, %v) = %#o, expected %#o", tc.mode, tc.dir, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestExtractTarModes(t *testing.T) {
+	t.Parallel()
+	entries := []tarEntry{
+		{name: "opt/", typeflag: tar.TypeDir, mode: 0o777},
+		{name: "opt/bin/tool", typeflag: tar.TypeReg, content: []byte("tool"), mode: 0o4777},
+		{name: "opt/secret", typeflag: tar.TypeReg, content: []byte("secret"), mode: 0o400},
+	}
+	expected := map[string]os.FileMode{
+		"opt":          os.ModeDir | 0o755,
+		"opt/bin":      os.ModeDir | 0o755,
+		"opt/bin/tool": 0o755,
+		"opt/secret":   0o600,
+	}
+
+	s := newExtractScanner(DefaultMaxFileBytes, DefaultMaxExtractBytes)
+	s.permMask = DefaultExtractPermMask
+	dest := t.TempDir()
+	r := bytes.NewReader(buildTar(t, entries))
+	if _, err := s.extractTar(context.Background(), r, dest, []string{"/"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	modes := make(map[string]os.FileMode)
+	for name := range expected {
+		info, err := os.Lstat(filepath.Join(dest, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		modes[name] = info.Mode()
+	}
+	if diff := cmp.Diff(expected, modes); diff != "" {
+		t.Errorf("extracted modes mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestExtractTarOwners(t *testing.T) {
+	t.Parallel()
+	entries := []tarEntry{
+		{name: "opt/", typeflag: tar.TypeDir, uid: 1001, gid: 1001},
+		{name: "opt/app", typeflag: tar.TypeReg, content: []byte("app"), uid: 1001, gid: 0},
+		{name: "opt/config", typeflag: tar.TypeReg, content: []byte("config")},
+	}
+
+	s := newExtractScanner(DefaultMaxFileBytes, DefaultMaxExtractBytes)
+	s.fileOwners = newFileOwners()
+	dest := t.TempDir()
+	r := bytes.NewReader(buildTar(t, entries))
+	if _, err := s.extractTar(context.Background(), r, dest, []string{"/"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string][2]int{
+		filepath.Join(dest, "opt/app"):    {1001, 0},
+		filepath.Join(dest, "opt/config"): {0, 0},
+	}
+	if diff := cmp.Diff(expected, s.fileOwners.owners); diff != "" {
+		t.Errorf("recorded owners mismatch (-want +got):\n%s", diff)
+	}
+
+	s.fileOwners.forget(filepath.Join(dest, "opt"))
+	if _, _, ok := s.fileOwners.lookup(filepath.Join(dest, "opt/app")); ok {
+		t.Errorf("expected owners of removed content to be forgotten")
+	}
+}
+
+func TestExtractTarCanceled(t *testing.T) {
+	t.Parallel()
+	entries := []tarEntry{
+		{name: "opt/tool", typeflag: tar.TypeReg, content: []byte("tool"), mode: 0o755},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s := newExtractScanner(DefaultMaxFileBytes, DefaultMaxExtractBytes)
+	dest := t.TempDir()
+	_, err := s.extractTar(ctx, bytes.NewReader(buildTar(t, entries)), dest, []string{"/"})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected error wrapping %v, got: %v", context.Canceled, err)
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "opt/tool")); !os.IsNotExist(err) {
+		t.Errorf("expected no content extracted after cancellation, got: %v", err)
+	}
+}
+
+func TestSecureJoin(t *testing.T) {
+	t.Parallel()
+	root := t.TempDir()
+	for _, dir := range []string{"usr/lib", "opt"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("/etc", filepath.Join(root, "usr/lib/abs")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../opt", filepath.Join(root, "usr/lib/rel")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		name        string
+		expected    string
+		expectedErr error
+	}{
+		"existing path": {
+			name:     "usr/lib",
+			expected: filepath.Join(root, "usr/lib"),
+		},
+		"new path": {
+			name:     "usr/lib/new/file",
+			expected: filepath.Join(root, "usr/lib/new/file"),
+		},
+		"root itself": {
+			name:     ".",
+			expected: root,
+		},
+		"dot dot within root": {
+			name:     "usr/../opt/file",
+			expected: filepath.Join(root, "opt/file"),
+		},
+		"dot dot escaping root": {
+			name:        "usr/../../etc/passwd",
+			expectedErr: ErrPathTraversal,
+		},
+		"absolute name": {
+			name:        "/etc/passwd",
+			expectedErr: ErrPathTraversal,
+		},
+		"empty name": {
+			name:        "",
+			expectedErr: ErrPathTraversal,
+		},
+		"absolute symlink in parent": {
+			name:        "usr/lib/abs/passwd",
+			expectedErr: ErrPathTraversal,
+		},
+		"relative symlink in parent": {
+			name:        "usr/lib/rel/file",
+			expectedErr: ErrPathTraversal,
+		},
+		"symlink as target": {
+			name:        "usr/lib/abs",
+			expectedErr: ErrPathTraversal,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := secureJoin(root, tc.name)
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Fatalf("expected error wrapping %v, got: %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.expected {
+				t.Errorf("secureJoin(%q) = %q, expected %q", tc.name, got, tc.expected)
+			}
+		})
+	}
+}
+
+// FuzzExtractTar feeds arbitrary tar streams to the extractor and checks that
+// it neither panics, writes outside of dest nor writes more than the configured
+// limits.
+func FuzzExtractTar(f *testing.F) {
+	f.Add(buildTar(f, []tarEntry{
+		{name: "usr/bin/helm", typeflag: tar.TypeReg, content: []byte("helm")},
+	}))
+	f.Add(buildTar(f, []tarEntry{
+		{name: "../../../../escaped", typeflag: tar.TypeReg, content: []byte("escaped")},
+		{name: "usr/../../escaped", typeflag: tar.TypeReg, content: []byte("escaped")},
+		{name: "/escaped", typeflag: tar.TypeReg, content: []byte("escaped")},
+	}))
+	f.Add(buildTar(f, []tarEntry{
+		{name: "dev/fifo", typeflag: tar.TypeFifo},
+		{name: "usr/lib/", typeflag: tar.TypeDir},
+		{name: "usr/lib/" + strings.Repeat("long/", 100) + "file", typeflag: tar.TypeReg, content: []byte("long")},
+	}))
+	f.Add([]byte{})
+
+	const maxFileBytes, maxExtractBytes = 8 * sparseBlockSize, 16 * sparseBlockSize
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		root := t.TempDir()
+		dest := filepath.Join(root, "dest")
+		if err := os.Mkdir(dest, 0755); err != nil {
+			t.Fatal(err)
+		}
+		s := newExtractScanner(maxFileBytes, maxExtractBytes)
+		_, _ = s.extractTar(context.Background(), bytes.NewReader(data), dest, []string{"/"})
+
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Errorf("extraction wrote outside of dest: %v", entries)
+		}
+
+		var total int64
+		err = filepath.WalkDir(dest, func(p string, d os.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			allocated, err := allocatedBytes(p)
+			if err != nil {
+				return err
+			}
+			// allow for one partially used filesystem block per file
+			if allocated > maxFileBytes+sparseBlockSize {
+				t.Errorf("file %q exceeds per-file limit: %d bytes allocated", p, allocated)
+			}
+			total += allocated
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total > maxExtractBytes+maxFileBytes {
+			t.Errorf("extracted content exceeds total limit: %d bytes allocated", total)
+		}
+	})
+}
+
+// allocatedBytes returns the disk space allocated for the file, which
+// excludes holes of sparse files.
+func allocatedBytes(p string) (int64, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return 0, err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.Size(), nil
+	}
+	return stat.Blocks * 512, nil
+}
@@ -0,0 +1,220 @@
+// Extraction of content of images from the store for scanning, see
+// WithExtractor.
+
+package capo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"go.podman.io/storage"
+	"go.podman.io/storage/pkg/archive"
+)
+
+// Extractor saves content of images from the store for scanning.
+type Extractor interface {
+	// ExtractPaths saves the content of the image at the paths matching the
+	// sources (see Includes) to dest, and returns the extracted paths,
+	// absolute in the image. Extraction stops when ctx is done.
+	ExtractPaths(ctx context.Context, image *storage.Image, sources []string, dest string) ([]string, error)
+}
+
+// ExtractorKind selects the Extractor of a scanner, see WithExtractor.
+type ExtractorKind string
+
+const (
+	// ExtractorMount mounts images and copies content from the mount. The
+	// default.
+	ExtractorMount ExtractorKind = "mount"
+	// ExtractorLayers streams the layers of images from the store and applies
+	// them to the extracted content, so no mount privileges are needed. All
+	// layers of an image are read, which is slower than a mount.
+	ExtractorLayers ExtractorKind = "layers"
+)
+
+var ErrInvalidExtractor = fmt.Errorf(
+	"[ERR_INVALID_EXTRACTOR] invalid content extractor, expected mount or layers: %w", ErrParse,
+)
+
+// ParseExtractorKind returns the extractor kind with the passed name.
+func ParseExtractorKind(s string) (ExtractorKind, error) {
+	switch kind := ExtractorKind(s); kind {
+	case ExtractorMount, ExtractorLayers:
+		return kind, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrInvalidExtractor, s)
+}
+
+// Configure the scanner to extract content of images with the extractor of
+// the kind. With ExtractorLayers, images are never mounted: the base image
+// scanned with WithScanBase is extracted whole, and os-release files are
+// extracted instead of read from the mount. Layer diffs of intermediate
+// images are streamed from the store with either kind. ExtractorMount by
+// default.
+func WithExtractor(kind ExtractorKind) Option {
+	return func(s *Scanner) {
+		s.extractorKind = kind
+	}
+}
+
+// newExtractor returns the extractor of the configured kind.
+func (s *Scanner) newExtractor() Extractor {
+	if s.extractorKind == ExtractorLayers {
+		return layerExtractor{s: s}
+	}
+	return mountExtractor{s: s}
+}
+
+// mountExtractor copies content from images mounted for the scan (see
+// mountManager).
+type mountExtractor struct {
+	s *Scanner
+}
+
+func (e mountExtractor) ExtractPaths(
+	ctx context.Context, image *storage.Image, sources []string, dest string,
+) ([]string, error) {
+	return e.s.copyMountedContent(ctx, image, sources, dest)
+}
+
+// layerExtractor applies the layer diffs of images from the store, from the
+// bottom layer up, to the extracted content.
+type layerExtractor struct {
+	s *Scanner
+}
+
+func (e layerExtractor) ExtractPaths(
+	ctx context.Context, image *storage.Image, sources []string, dest string,
+) ([]string, error) {
+	s := e.s
+	layers, err := s.imageLayers(image)
+	if err != nil {
+		return nil, err
+	}
+
+	included := make(map[string]bool)
+	for _, layerID := range layers {
+		var removed []string
+		whiteout := func(name string) error {
+			p, err := applyWhiteout(dest, name)
+			if err != nil {
+				if errors.Is(err, ErrPathTraversal) {
+					s.logger.Warn("skipping whiteout outside of extraction root", "name", name, "error", err)
+					return nil
+				}
+				return err
+			}
+			removed = append(removed, p)
+			return nil
+		}
+		names, err := s.extractLayer(ctx, layerID, dest, sources, whiteout)
+		if err != nil {
+			return nil, err
+		}
+
+		// whiteouts of a layer only remove content of the layers below it
+		if len(removed) > 0 {
+			maps.DeleteFunc(included, func(p string, _ bool) bool {
+				return slices.ContainsFunc(removed, func(r string) bool {
+					return p == r || strings.HasPrefix(p, strings.TrimSuffix(r, "/")+"/")
+				})
+			})
+		}
+		for _, name := range names {
+			included[path.Clean("/"+name)] = true
+		}
+	}
+	return slices.Sorted(maps.Keys(included)), nil
+}
+
+// imageLayers returns the IDs of the layers of the image, from the bottom
+// layer up.
+func (s *Scanner) imageLayers(image *storage.Image) ([]string, error) {
+	var layers []string
+	for layerID := image.TopLayer; layerID != ""; {
+		layer, err := s.store.Layer(layerID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get layer %s: %w: %w", layerID, err, ErrStorage)
+		}
+		layers = append(layers, layer.ID)
+		layerID = layer.Parent
+	}
+	slices.Reverse(layers)
+	return layers, nil
+}
+
+// extractLayer extracts the entries of the diff of the layer against its
+// parent matching sources to dest, passing whiteouts to whiteout.
+func (s *Scanner) extractLayer(
+	ctx context.Context, layerID string, dest string, sources []string, whiteout func(name string) error,
+) (included []string, err error) {
+	compression := archive.Uncompressed
+	diff, err := s.store.Diff("", layerID, &storage.DiffOptions{Compression: &compression})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read layer %s: %w: %w", layerID, err, ErrStorage)
+	}
+	defer func() {
+		if closeErr := diff.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close layer diff: %w: %w", closeErr, ErrStorage)
+		}
+	}()
+
+	return s.extractTarWhiteouts(ctx, diff, dest, sources, whiteout)
+}
+
+// Prefix of the names of whiteout files, and the name of the whiteout file
+// marking a directory opaque, in layer diffs.
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// applyWhiteout removes the content of lower layers extracted to dest which
+// the whiteout file of the name hides: the whiteout's sibling of the same
+// name without the prefix, or all content in the directory of an opaque
+// whiteout. Returns the removed path, absolute in the image, with a trailing
+// slash for the content of an opaque directory.
+func applyWhiteout(dest string, name string) (string, error) {
+	dir, base := path.Split(path.Clean("/" + name))
+	if base == whiteoutOpaque {
+		target := dest
+		if rel := strings.TrimPrefix(path.Clean(dir), "/"); rel != "" {
+			var err error
+			if target, err = secureJoin(dest, rel); err != nil {
+				return "", err
+			}
+		}
+		entries, err := os.ReadDir(target)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("failed to read directory %q: %w: %w", target, err, ErrIO)
+		}
+		for _, entry := range entries {
+			if err := os.RemoveAll(filepath.Join(target, entry.Name())); err != nil {
+				return "", fmt.Errorf("failed to remove %q: %w: %w", entry.Name(), err, ErrIO)
+			}
+		}
+		return dir, nil
+	}
+
+	hidden := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+	target, err := secureJoin(dest, strings.TrimPrefix(hidden, "/"))
+	if err != nil {
+		return "", err
+	}
+	if err := os.RemoveAll(target); err != nil {
+		return "", fmt.Errorf("failed to remove %q: %w: %w", target, err, ErrIO)
+	}
+	return hidden, nil
+}
+
+// isWhiteout reports whether the name of a tar entry is a whiteout file.
+func isWhiteout(name string) bool {
+	return strings.HasPrefix(path.Base(name), whiteoutPrefix)
+}
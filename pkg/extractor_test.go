@@ -0,0 +1,125 @@
+//go:build unit
+
+package capo
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.podman.io/storage"
+
+	"github.com/konflux-ci/capo/pkg/capotest"
+)
+
+func TestParseExtractorKind(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		expected    ExtractorKind
+		expectedErr error
+	}{
+		"mount":  {expected: ExtractorMount},
+		"layers": {expected: ExtractorLayers},
+		"skopeo": {expectedErr: ErrInvalidExtractor},
+		"":       {expectedErr: ErrInvalidExtractor},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			kind, err := ParseExtractorKind(name)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tt.expectedErr, err)
+			}
+			if kind != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, kind)
+			}
+		})
+	}
+}
+
+func TestLayerExtractor(t *testing.T) {
+	t.Parallel()
+	// three layers: the base, a layer removing and replacing content, and a
+	// layer making a directory opaque
+	store := capotest.NewStore().
+		AddImage(storage.Image{ID: "image", TopLayer: "l3"}, "").
+		AddLayer(storage.Layer{ID: "l1"}).
+		AddLayer(storage.Layer{ID: "l2", Parent: "l1"}).
+		AddLayer(storage.Layer{ID: "l3", Parent: "l2"}).
+		SetDiff("", "l1", buildTar(t, []tarEntry{
+			{name: "usr/", typeflag: tar.TypeDir},
+			{name: "usr/bin/", typeflag: tar.TypeDir},
+			{name: "usr/bin/app", typeflag: tar.TypeReg, content: []byte("v1")},
+			{name: "usr/bin/removed", typeflag: tar.TypeReg, content: []byte("removed")},
+			{name: "usr/lib/", typeflag: tar.TypeDir},
+			{name: "usr/lib/old.so", typeflag: tar.TypeReg, content: []byte("old")},
+			{name: "usr/share/doc", typeflag: tar.TypeReg, content: []byte("file")},
+			{name: "etc/passwd", typeflag: tar.TypeReg, content: []byte("root")},
+		})).
+		SetDiff("", "l2", buildTar(t, []tarEntry{
+			{name: "usr/bin/app", typeflag: tar.TypeReg, content: []byte("v2")},
+			{name: "usr/bin/.wh.removed", typeflag: tar.TypeReg},
+			{name: "usr/share/doc/", typeflag: tar.TypeDir},
+			{name: "usr/share/doc/README", typeflag: tar.TypeReg, content: []byte("readme")},
+		})).
+		SetDiff("", "l3", buildTar(t, []tarEntry{
+			{name: "usr/lib/", typeflag: tar.TypeDir},
+			{name: "usr/lib/.wh..wh..opq", typeflag: tar.TypeReg},
+			{name: "usr/lib/new.so", typeflag: tar.TypeReg, content: []byte("new")},
+		}))
+
+	s := newExtractScanner(DefaultMaxFileBytes, DefaultMaxExtractBytes)
+	s.store = store
+	dest := t.TempDir()
+	image, err := store.Image("image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	included, err := layerExtractor{s: s}.ExtractPaths(context.Background(), image, []string{"/usr/"}, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedIncluded := []string{
+		"/usr",
+		"/usr/bin",
+		"/usr/bin/app",
+		"/usr/lib",
+		"/usr/lib/new.so",
+		"/usr/share/doc",
+		"/usr/share/doc/README",
+	}
+	if diff := cmp.Diff(expectedIncluded, included); diff != "" {
+		t.Errorf("unexpected included paths (-want +got):\n%s", diff)
+	}
+
+	expectedFiles := map[string]string{
+		"usr/bin/app":          "v2",
+		"usr/lib/new.so":       "new",
+		"usr/share/doc/README": "readme",
+	}
+	files := make(map[string]string)
+	err = filepath.WalkDir(dest, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dest, p)
+		files[filepath.ToSlash(rel)] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(expectedFiles, files); diff != "" {
+		t.Errorf("unexpected extracted files (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,252 @@
+// Ownership of single files copied to the final image.
+
+package capo
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/konflux-ci/capo/internal/sbom"
+)
+
+// Unowned is the PURL of files no package was found to own.
+const Unowned = "unowned"
+
+// FileMetadataItem describes the owner of a file copied to the final image,
+// for answering e.g. "which package shipped /usr/bin/helm in image X?".
+type FileMetadataItem struct {
+	// Path of the file in its origin image, e.g. "/usr/bin/helm".
+	Path string `json:"path"`
+
+	// PURL of the package owning the file, or "unowned". Owners from package
+	// databases of the origin image (see FoundBy of PackageMetadataItem) take
+	// precedence over packages found in the copied content.
+	PackageURL string `json:"purl"`
+
+	// Type of origin of the file, the same as for packages (see
+	// PackageMetadataItem).
+	OriginType string `json:"origin_type"`
+
+	// Pullspec of the image with digest which is this file's origin.
+	Pullspec string `json:"pullspec"`
+
+	// Alias of the stage of this file's origin.
+	// Omitted if this file is from an external image.
+	StageAlias string `json:"stage_alias,omitempty"`
+
+	// Numeric user and group owning the file in its origin image, from the
+	// layer or the mounted image. COPY --chown changes the owners in the
+	// final image. Omitted if unknown.
+	UID *int `json:"uid,omitempty"`
+	GID *int `json:"gid,omitempty"`
+}
+
+// fileOwners holds the owners of files in their origin images by the paths
+// they were extracted to, while file ownership is recorded. Safe for
+// concurrent use.
+type fileOwners struct {
+	mu     sync.Mutex
+	owners map[string][2]int
+}
+
+func newFileOwners() *fileOwners {
+	return &fileOwners{owners: make(map[string][2]int)}
+}
+
+// record records the uid and gid of the file extracted to p. Does nothing on
+// a nil receiver, when file ownership isn't recorded.
+func (o *fileOwners) record(p string, uid, gid int) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.owners[p] = [2]int{uid, gid}
+}
+
+// recordStat records the owners of the file extracted to p from the file
+// info of its origin, if the platform reports them.
+func (o *fileOwners) recordStat(p string, info fs.FileInfo) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		o.record(p, int(st.Uid), int(st.Gid))
+	}
+}
+
+// lookup returns the uid and gid of the file extracted to p.
+func (o *fileOwners) lookup(p string) (uid, gid int, ok bool) {
+	if o == nil {
+		return 0, 0, false
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	ids, ok := o.owners[p]
+	return ids[0], ids[1], ok
+}
+
+// forget forgets the files extracted under the directory, which is removed.
+func (o *fileOwners) forget(dir string) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	prefix := filepath.Clean(dir) + string(filepath.Separator)
+	maps.DeleteFunc(o.owners, func(p string, _ [2]int) bool {
+		return strings.HasPrefix(p, prefix)
+	})
+}
+
+// recordFiles records owners of copied files for the output of the current
+// Scan. Safe for concurrent use by scans of package sources.
+func (s *Scanner) recordFiles(items []FileMetadataItem) {
+	s.filesMu.Lock()
+	defer s.filesMu.Unlock()
+	s.files = append(s.files, items...)
+}
+
+// sortFileMetadata sorts file owners by origin and path, as package sources
+// record them in the order they're scanned.
+func sortFileMetadata(items []FileMetadataItem) {
+	slices.SortStableFunc(items, func(a, b FileMetadataItem) int {
+		return cmp.Or(
+			cmp.Compare(a.Pullspec, b.Pullspec),
+			cmp.Compare(a.StageAlias, b.StageAlias),
+			cmp.Compare(a.Path, b.Path),
+		)
+	})
+}
+
+// listContentFiles returns the paths of files (including symbolic links)
+// saved to contentPath under any of the included paths. Files added to the
+// content for scanning only (see copyOSRelease) are not included.
+func listContentFiles(contentPath string, included []string) ([]string, error) {
+	// included paths are concrete (already matched against the sources), with
+	// or without a leading slash depending on how the content was saved
+	includedSet := make(map[string]bool, len(included))
+	for _, p := range included {
+		includedSet[path.Clean("/"+p)] = true
+	}
+
+	files := make([]string, 0)
+	err := filepath.WalkDir(contentPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to walk %q: %w: %w", p, err, ErrIO)
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(contentPath, p)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path for %q: %w: %w", p, err, ErrIO)
+		}
+		file := "/" + filepath.ToSlash(rel)
+		for dir := file; ; dir = path.Dir(dir) {
+			if includedSet[dir] {
+				files = append(files, file)
+				break
+			}
+			if dir == "/" {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// getFileMetadata maps files to FileMetadataItem structs with the owning
+// package and the given origin information. Owners are looked up in the
+// package lists in order, by the files a package owns according to its
+// package database and then by the locations it was found in. Files under
+// archiveDests are attributed as context-archive (see archiveOriginType).
+func getFileMetadata(
+	stageAlias string,
+	digestBase string,
+	originType string,
+	archiveDests []string,
+	files []string,
+	pkgLists ...[]sbom.SyftPackage,
+) []FileMetadataItem {
+	owners := make(map[string]string)
+	for _, pkgs := range pkgLists {
+		for _, pkg := range pkgs {
+			for _, file := range pkg.Files {
+				if _, ok := owners[file]; !ok {
+					owners[file] = pkg.PURL
+				}
+			}
+		}
+	}
+	for _, pkgs := range pkgLists {
+		for _, pkg := range pkgs {
+			for _, loc := range pkg.Locations {
+				if _, ok := owners[loc]; !ok {
+					owners[loc] = pkg.PURL
+				}
+			}
+		}
+	}
+
+	res := make([]FileMetadataItem, 0, len(files))
+	for _, file := range files {
+		owner, ok := owners[file]
+		if !ok {
+			owner = Unowned
+		}
+		fileOriginType := originType
+		if underArchiveDest(file, archiveDests) {
+			fileOriginType = originTypeContextArchive
+		}
+		res = append(res, FileMetadataItem{
+			Path:       file,
+			PackageURL: owner,
+			OriginType: fileOriginType,
+			Pullspec:   digestBase,
+			StageAlias: stageAlias,
+		})
+	}
+
+	return res
+}
+
+// recordContentFiles records owners of the files saved to contentPath under
+// the included paths, if file ownership is recorded by the Scanner.
+func (s *Scanner) recordContentFiles(
+	contentPath string,
+	included []string,
+	stageAlias string,
+	digestBase string,
+	originType string,
+	archiveDests []string,
+	pkgLists ...[]sbom.SyftPackage,
+) error {
+	if !s.fileOwnership || len(included) == 0 {
+		return nil
+	}
+
+	files, err := listContentFiles(contentPath, included)
+	if err != nil {
+		return err
+	}
+	items := getFileMetadata(stageAlias, digestBase, originType, archiveDests, files, pkgLists...)
+	for i := range items {
+		if uid, gid, ok := s.fileOwners.lookup(filepath.Join(contentPath, filepath.FromSlash(items[i].Path))); ok {
+			items[i].UID, items[i].GID = &uid, &gid
+		}
+	}
+	s.recordFiles(items)
+	return nil
+}
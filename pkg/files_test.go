@@ -0,0 +1,132 @@
+//go:build unit
+
+package capo
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/konflux-ci/capo/internal/sbom"
+)
+
+func TestListContentFiles(t *testing.T) {
+	t.Parallel()
+	content := t.TempDir()
+	writeTestFile(t, filepath.Join(content, "usr/bin/helm"), "helm")
+	writeTestFile(t, filepath.Join(content, "usr/share/helm/README"), "readme")
+	symlinkTestFile(t, "helm", filepath.Join(content, "usr/bin/kubectl-helm"))
+	// added for distro context, not copied
+	writeTestFile(t, filepath.Join(content, "etc/os-release"), ubiOSRelease)
+
+	tests := map[string]struct {
+		included []string
+		expected []string
+	}{
+		"files from image content": {
+			included: []string{"/usr/bin/helm", "/usr/bin/kubectl-helm"},
+			expected: []string{"/usr/bin/helm", "/usr/bin/kubectl-helm"},
+		},
+		"directory from layer diff": {
+			included: []string{"usr/", "usr/bin/helm"},
+			expected: []string{"/usr/bin/helm", "/usr/bin/kubectl-helm", "/usr/share/helm/README"},
+		},
+		"nothing included": {
+			included: []string{},
+			expected: []string{},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			actual, err := listContentFiles(content, tc.included)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, actual); diff != "" {
+				t.Errorf("listContentFiles() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGetFileMetadata(t *testing.T) {
+	t.Parallel()
+	dbPkgs := []sbom.SyftPackage{{
+		PURL:  "pkg:rpm/redhat/helm@3.14.2-1.el9?arch=x86_64&distro=rhel-9.4",
+		Files: []string{"/usr/bin/helm"},
+	}}
+	contentPkgs := []sbom.SyftPackage{
+		{
+			PURL:      "pkg:golang/helm.sh/helm/v3@v3.14.2",
+			Locations: []string{"/usr/bin/helm"},
+		},
+		{
+			PURL:      "pkg:golang/example.com/tool@v1.0.0",
+			Locations: []string{"/opt/tool/bin/tool"},
+		},
+	}
+	files := []string{"/usr/bin/helm", "/opt/tool/bin/tool", "/opt/tool/LICENSE", "/usr/share/data"}
+
+	expected := []FileMetadataItem{
+		{
+			Path:       "/usr/bin/helm",
+			PackageURL: "pkg:rpm/redhat/helm@3.14.2-1.el9?arch=x86_64&distro=rhel-9.4",
+			OriginType: "builder",
+			Pullspec:   "quay.io/ubi@sha256:abc",
+			StageAlias: "builder",
+		},
+		{
+			Path:       "/opt/tool/bin/tool",
+			PackageURL: "pkg:golang/example.com/tool@v1.0.0",
+			OriginType: originTypeContextArchive,
+			Pullspec:   "quay.io/ubi@sha256:abc",
+			StageAlias: "builder",
+		},
+		{
+			Path:       "/opt/tool/LICENSE",
+			PackageURL: Unowned,
+			OriginType: originTypeContextArchive,
+			Pullspec:   "quay.io/ubi@sha256:abc",
+			StageAlias: "builder",
+		},
+		{
+			Path:       "/usr/share/data",
+			PackageURL: Unowned,
+			OriginType: "builder",
+			Pullspec:   "quay.io/ubi@sha256:abc",
+			StageAlias: "builder",
+		},
+	}
+
+	actual := getFileMetadata(
+		"builder", "quay.io/ubi@sha256:abc", "builder", []string{"/opt/tool"}, files,
+		dbPkgs, contentPkgs,
+	)
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("getFileMetadata() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSortFileMetadata(t *testing.T) {
+	t.Parallel()
+	items := []FileMetadataItem{
+		{Path: "/b", Pullspec: "quay.io/b@sha256:1"},
+		{Path: "/b", Pullspec: "quay.io/a@sha256:1", StageAlias: "two"},
+		{Path: "/a", Pullspec: "quay.io/a@sha256:1", StageAlias: "two"},
+		{Path: "/c", Pullspec: "quay.io/a@sha256:1", StageAlias: "one"},
+	}
+	expected := []FileMetadataItem{
+		{Path: "/c", Pullspec: "quay.io/a@sha256:1", StageAlias: "one"},
+		{Path: "/a", Pullspec: "quay.io/a@sha256:1", StageAlias: "two"},
+		{Path: "/b", Pullspec: "quay.io/a@sha256:1", StageAlias: "two"},
+		{Path: "/b", Pullspec: "quay.io/b@sha256:1"},
+	}
+
+	sortFileMetadata(items)
+	if diff := cmp.Diff(expected, items); diff != "" {
+		t.Errorf("sortFileMetadata() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,85 @@
+// Selection of the packages of an existing SBOM copied by paths, see
+// FilterSBOM.
+
+package capo
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"slices"
+
+	"github.com/konflux-ci/capo/internal/sbom"
+)
+
+var ErrFilterSBOM = fmt.Errorf("[ERR_FILTER_SBOM] failed to filter SBOM: %w", ErrParse)
+
+// foundByFilteredSBOM marks packages of an SBOM selected by FilterSBOM.
+const foundByFilteredSBOM = "filtered-sbom"
+
+// FilterSBOM returns the packages of the SBOM document of an image read from r
+// (in any format syft can decode, e.g. SPDX, CycloneDX or syft JSON) with a
+// location under one of the paths, as if the paths were copied from the image
+// (COPY --from=image), e.g. to trim the SBOM of a whole base image to the
+// content copied from it. The paths are absolute and may be directories or
+// contain wildcards, as sources of COPY instructions. Packages have the
+// external origin type, image as their pullspec (may be empty if unknown) and
+// FoundBy "filtered-sbom". An SBOM without package locations is an error, as
+// which of its packages are copied is unknown.
+func FilterSBOM(r io.Reader, image string, paths []string) (PackageMetadata, error) {
+	if len(paths) == 0 {
+		return PackageMetadata{}, ErrNoPaths
+	}
+	for _, p := range paths {
+		if !path.IsAbs(p) {
+			return PackageMetadata{}, fmt.Errorf("%w: path %q isn't absolute", ErrFilterSBOM, p)
+		}
+	}
+
+	packages, err := sbom.Decode(r)
+	if err != nil {
+		return PackageMetadata{}, fmt.Errorf("%w: %w", ErrFilterSBOM, err)
+	}
+	items, ok := copiedPackages(packages, image, paths, foundByFilteredSBOM)
+	if !ok {
+		return PackageMetadata{}, fmt.Errorf("%w: no package has locations", ErrFilterSBOM)
+	}
+	return PackageMetadata{Packages: items, Tools: Version().Tools()}, nil
+}
+
+// copiedPackages returns the packages of the SBOM of the image (a pullspec
+// with digest) with a location under one of the copied sources, with the
+// external origin type and foundBy, once per PURL. Returns false if no
+// package of the SBOM has locations, then which are copied is unknown.
+func copiedPackages(
+	packages []sbom.SyftPackage, image string, sources []string, foundBy string,
+) ([]PackageMetadataItem, bool) {
+	if !slices.ContainsFunc(packages, func(pkg sbom.SyftPackage) bool { return len(pkg.Locations) > 0 }) {
+		return nil, false
+	}
+
+	res := make([]PackageMetadataItem, 0)
+	seen := make(map[string]bool)
+	for _, pkg := range packages {
+		if seen[pkg.PURL] {
+			continue
+		}
+		locations := slices.DeleteFunc(slices.Clone(pkg.Locations), func(loc string) bool {
+			return !Includes(sources, loc)
+		})
+		if len(locations) == 0 {
+			continue
+		}
+		seen[pkg.PURL] = true
+		res = append(res, withID(PackageMetadataItem{
+			PackageURL:       pkg.PURL,
+			DependencyOfPURL: pkg.DependencyOfPURL,
+			Checksums:        pkg.Checksums,
+			Licenses:         pkg.Licenses,
+			OriginType:       "external",
+			Pullspec:         image,
+			FoundBy:          foundBy,
+		}, locations))
+	}
+	return res, true
+}
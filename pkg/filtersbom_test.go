@@ -0,0 +1,92 @@
+//go:build unit
+
+package capo
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/konflux-ci/capo/internal/sbom"
+)
+
+func TestCopiedPackages(t *testing.T) {
+	t.Parallel()
+	packages := []sbom.SyftPackage{
+		{PURL: "pkg:golang/tool", Locations: []string{"/usr/bin/tool"}},
+		{PURL: "pkg:rpm/fedora/bash", Locations: []string{"/usr/bin/bash", "/var/lib/rpm/rpmdb.sqlite"}},
+		{PURL: "pkg:rpm/fedora/glibc", Locations: []string{"/var/lib/rpm/rpmdb.sqlite"}},
+		{PURL: "pkg:golang/tool", Locations: []string{"/opt/tool"}},
+	}
+
+	const tool = "quay.io/konflux-ci/tools@" + fedoraDigest
+
+	tests := map[string]struct {
+		packages   []sbom.SyftPackage
+		sources    []string
+		expected   []PackageMetadataItem
+		expectedOK bool
+	}{
+		"copied": {
+			packages: packages,
+			sources:  []string{"/usr/bin/"},
+			expected: []PackageMetadataItem{
+				withID(PackageMetadataItem{
+					PackageURL: "pkg:golang/tool", OriginType: "external", Pullspec: tool, FoundBy: foundByReferrerSBOM,
+				}, []string{"/usr/bin/tool"}),
+				withID(PackageMetadataItem{
+					PackageURL: "pkg:rpm/fedora/bash", OriginType: "external", Pullspec: tool, FoundBy: foundByReferrerSBOM,
+				}, []string{"/usr/bin/bash"}),
+			},
+			expectedOK: true,
+		},
+		"nothing copied": {
+			packages:   packages,
+			sources:    []string{"/etc/"},
+			expectedOK: true,
+		},
+		"no locations": {
+			packages: []sbom.SyftPackage{{PURL: "pkg:golang/tool"}},
+			sources:  []string{"/usr/bin/"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			actual, ok := copiedPackages(test.packages, tool, test.sources, foundByReferrerSBOM)
+			if ok != test.expectedOK {
+				t.Fatalf("copiedPackages() ok = %v, want %v", ok, test.expectedOK)
+			}
+			if diff := cmp.Diff(test.expected, actual, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("copiedPackages() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFilterSBOMError(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		content     string
+		paths       []string
+		expectedErr error
+	}{
+		"no paths":      {content: "{}", expectedErr: ErrNoPaths},
+		"relative path": {content: "{}", paths: []string{"usr/bin"}, expectedErr: ErrFilterSBOM},
+		"not an SBOM":   {content: "not an SBOM", paths: []string{"/usr/bin"}, expectedErr: ErrFilterSBOM},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			_, err := FilterSBOM(strings.NewReader(test.content), "", test.paths)
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error wrapping %v, got: %v", test.expectedErr, err)
+			}
+		})
+	}
+}
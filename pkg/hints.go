@@ -0,0 +1,209 @@
+// Hints declaring the content RUN instructions produce from other content of
+// their stage, see ReadHints.
+
+package capo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+)
+
+var ErrHints = fmt.Errorf("[ERR_HINTS] invalid hints: %w", ErrParse)
+var ErrHint = fmt.Errorf("[ERR_HINT] invalid hint: %w", ErrParse)
+
+// Hints declare content of stages produced by RUN instructions capo can't
+// model (e.g. builds, or extraction of tar and zip archives), so it's traced
+// further to the origins of the content it's produced from, e.g.:
+//
+//	hints:
+//	  - stage: builder
+//	    path: /app/dist/
+//	    from: /src
+//
+// attributes the packages of content copied from /app/dist/ of the builder
+// stage to the origins /src of the stage was copied from.
+type Hints struct {
+	Hints []Hint `yaml:"hints"`
+}
+
+// Hint declares that the content at Path in the stage is produced from the
+// content at From in the same stage.
+type Hint struct {
+	// Alias or index of the stage.
+	Stage string `yaml:"stage"`
+	// Absolute path of the produced content.
+	Path string `yaml:"path"`
+	// Absolute path of the content it's produced from.
+	From string `yaml:"from"`
+}
+
+// ReadHints reads the hints in the YAML file at path. Unknown keys, hints
+// without a stage, relative paths, paths with wildcards and hints producing
+// content from itself are an error.
+func ReadHints(path string) (Hints, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Hints{}, err
+	}
+	defer f.Close()
+
+	var hints Hints
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&hints); err != nil && !errors.Is(err, io.EOF) {
+		return Hints{}, fmt.Errorf("%w: %s: %w", ErrHints, path, err)
+	}
+
+	for i, h := range hints.Hints {
+		if err := h.validate(); err != nil {
+			return Hints{}, fmt.Errorf("%w: %s: hint %d: %w", ErrHints, path, i, err)
+		}
+	}
+	return hints, nil
+}
+
+// validate returns an error if the hint can't be applied.
+func (h Hint) validate() error {
+	if h.Stage == "" {
+		return fmt.Errorf("%w: missing stage", ErrHint)
+	}
+	for _, p := range []string{h.Path, h.From} {
+		if !path.IsAbs(p) {
+			return fmt.Errorf("%w: path %q isn't absolute", ErrHint, p)
+		}
+		if strings.ContainsAny(p, globChars) {
+			return fmt.Errorf("%w: path %q has wildcards", ErrHint, p)
+		}
+	}
+	// content can't be traced to itself
+	if isUnderPath(h.Path, h.From) || isUnderPath(h.From, h.Path) {
+		return fmt.Errorf("%w: %q and %q overlap", ErrHint, h.Path, h.From)
+	}
+	return nil
+}
+
+// Characters of glob patterns, which hints don't support.
+const globChars = "*?["
+
+// isUnderPath returns whether p is parent or under it.
+func isUnderPath(p, parent string) bool {
+	rest, ok := strings.CutPrefix(path.Clean(p), path.Clean(parent))
+	return ok && (rest == "" || rest[0] == '/' || parent == "/")
+}
+
+// Configure the scanner to trace content through the hints (see Hints): the
+// content copied from a hinted path of a stage is traced as if it were copied
+// from the path it's produced from. Hints whose paths don't exist in the
+// intermediate image of their stage are skipped with WarnHintPathMissing.
+// With WithExplain, applied hints are recorded as steps of the provenance
+// (see CopyStep.Hint).
+func WithHints(hints Hints) Option {
+	return func(s *Scanner) {
+		s.hints = hints
+	}
+}
+
+// applyHints returns cf with the hints of the scanner added to the moves of
+// their stages (see containerfile.Move.Declared), which trace content the same
+// way as RUN mv commands. Hints of stages not in the containerfile are an
+// error. cf isn't modified.
+func (s *Scanner) applyHints(cf containerfile.Containerfile) (containerfile.Containerfile, error) {
+	if len(s.hints.Hints) == 0 {
+		return cf, nil
+	}
+
+	stages := slices.Clone(cf.Stages)
+	for _, h := range s.hints.Hints {
+		stage := cf.ResolveRef(h.Stage, len(cf.Stages))
+		if stage == nil {
+			return containerfile.Containerfile{}, fmt.Errorf(
+				"%w: stage %q of the hint of %s not found", ErrHints, h.Stage, h.Path,
+			)
+		}
+		missing, err := s.missingHintPaths(context.Background(), stage.Alias, h)
+		if err != nil {
+			return containerfile.Containerfile{}, err
+		}
+		if len(missing) > 0 {
+			s.warn(WarnHintPathMissing, fmt.Sprintf(
+				"hint of %s in stage %q skipped, %s not found in the stage", h.Path, stage.Alias, strings.Join(missing, " and "),
+			))
+			continue
+		}
+
+		hinted := &stages[stage.Index]
+		hinted.Moves = append(slices.Clone(hinted.Moves), containerfile.Move{
+			Source:      h.From,
+			Destination: h.Path,
+			Workdir:     "/",
+			Declared:    true,
+		})
+	}
+	cf.Stages = stages
+	return cf, nil
+}
+
+// missingHintPaths returns the paths of the hint which don't exist in the
+// intermediate image of the stage, all of them if the stage has none. The
+// paths are extracted with the extractor of the scanner to check them.
+func (s *Scanner) missingHintPaths(ctx context.Context, stageAlias string, h Hint) ([]string, error) {
+	image, found, err := s.findIntermediateImage(stageAlias)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find intermediate image: %w: %w", err, ErrStorage)
+	}
+	if !found {
+		return []string{h.Path, h.From}, nil
+	}
+
+	var missing []string
+	for _, p := range []string{h.Path, h.From} {
+		dir, err := os.MkdirTemp("", "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temp directory: %w: %w", err, ErrIO)
+		}
+		included, err := s.newExtractor().ExtractPaths(ctx, image, []string{p}, dir)
+		if removeErr := os.RemoveAll(dir); err == nil && removeErr != nil {
+			err = fmt.Errorf("failed to remove temp directory: %w: %w", removeErr, ErrIO)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(included) == 0 {
+			missing = append(missing, p)
+		}
+	}
+	return missing, nil
+}
+
+// hintChain returns the chain extended by the step of the hint of the stage
+// the candidate path was traced to from source, or chain if it wasn't traced
+// through a hint.
+func hintChain(chain []CopyStep, source string, candidate string, stage *containerfile.Stage) []CopyStep {
+	if candidate == source {
+		return chain
+	}
+	for _, mv := range slices.Backward(stage.Moves) {
+		if !mv.Declared || !isUnderPath(candidate, mv.Source) {
+			continue
+		}
+		return append(slices.Clone(chain), CopyStep{
+			StageAlias:  stage.Alias,
+			StageIndex:  stage.Index,
+			From:        stage.Alias,
+			Source:      mv.Source,
+			Destination: mv.Destination,
+			Hint:        true,
+		})
+	}
+	return chain
+}
@@ -0,0 +1,180 @@
+//go:build unit
+
+package capo
+
+import (
+	"archive/tar"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.podman.io/storage"
+
+	"github.com/konflux-ci/capo/pkg/capotest"
+	"github.com/konflux-ci/capo/pkg/containerfile"
+	"github.com/konflux-ci/capo/pkg/stagereport"
+)
+
+func TestReadHints(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		content     string
+		expected    Hints
+		expectedErr error
+	}{
+		"valid": {
+			content:  "hints:\n  - stage: builder\n    path: /app/dist/\n    from: /src\n",
+			expected: Hints{Hints: []Hint{{Stage: "builder", Path: "/app/dist/", From: "/src"}}},
+		},
+		"empty file":    {content: ""},
+		"unknown key":   {content: "hints:\n  - stage: builder\n    to: /app\n", expectedErr: ErrHints},
+		"missing stage": {content: "hints:\n  - path: /app\n    from: /src\n", expectedErr: ErrHints},
+		"relative path": {content: "hints:\n  - stage: builder\n    path: dist\n    from: /src\n", expectedErr: ErrHints},
+		"wildcard":      {content: "hints:\n  - stage: builder\n    path: /app\n    from: /src/*\n", expectedErr: ErrHints},
+		"overlapping":   {content: "hints:\n  - stage: builder\n    path: /src/dist\n    from: /src/\n", expectedErr: ErrHints},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			path := filepath.Join(t.TempDir(), "hints.yaml")
+			if err := os.WriteFile(path, []byte(test.content), 0o644); err != nil {
+				t.Fatalf("failed to write hints: %v", err)
+			}
+			hints, err := ReadHints(path)
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error wrapping %v, got: %v", test.expectedErr, err)
+			}
+			if diff := cmp.Diff(test.expected, hints); diff != "" {
+				t.Errorf("Hints mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestApplyHints(t *testing.T) {
+	t.Parallel()
+	cf := containerfile.Containerfile{Stages: []containerfile.Stage{
+		{Alias: "builder", Base: "docker.io/library/node:24", BaseRef: "docker.io/library/node:24"},
+		{Alias: "1", Base: "scratch", BaseRef: "scratch", Index: 1, Kind: containerfile.StageKindFinal},
+	}}
+	store := capotest.NewStore().
+		AddImage(storage.Image{ID: "builder-image", Names: []string{"localhost/builder:latest"}, TopLayer: "l1"}, "").
+		AddLayer(storage.Layer{ID: "l1"}).
+		SetDiff("", "l1", buildTar(t, []tarEntry{
+			{name: "src/", typeflag: tar.TypeDir},
+			{name: "src/index.ts", typeflag: tar.TypeReg, content: []byte("export {}")},
+			{name: "app/dist/", typeflag: tar.TypeDir},
+			{name: "app/dist/index.js", typeflag: tar.TypeReg, content: []byte("export {}")},
+		}))
+	report := &stagereport.Report{Version: stagereport.Version, Stages: []stagereport.Stage{
+		{Index: 0, Name: "builder", ImageID: "builder-image"},
+	}}
+
+	tests := map[string]struct {
+		hints            []Hint
+		expectedMoves    []containerfile.Move
+		expectedWarnings []string
+		expectedErr      error
+	}{
+		"no hints": {},
+		"applied": {
+			hints:         []Hint{{Stage: "builder", Path: "/app/dist/", From: "/src"}},
+			expectedMoves: []containerfile.Move{{Source: "/src", Destination: "/app/dist/", Workdir: "/", Declared: true}},
+		},
+		"stage by index": {
+			hints:         []Hint{{Stage: "0", Path: "/app/dist/", From: "/src"}},
+			expectedMoves: []containerfile.Move{{Source: "/src", Destination: "/app/dist/", Workdir: "/", Declared: true}},
+		},
+		"missing path": {
+			hints:            []Hint{{Stage: "builder", Path: "/app/build/", From: "/src"}},
+			expectedWarnings: []string{WarnHintPathMissing},
+		},
+		"no intermediate image": {
+			hints:            []Hint{{Stage: "1", Path: "/app/dist/", From: "/src"}},
+			expectedWarnings: []string{WarnHintPathMissing},
+		},
+		"unknown stage": {
+			hints:       []Hint{{Stage: "web", Path: "/app/dist/", From: "/src"}},
+			expectedErr: ErrHints,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			s := newExtractScanner(DefaultMaxFileBytes, DefaultMaxExtractBytes)
+			s.store = store
+			s.stageReport = report
+			s.extractorKind = ExtractorLayers
+			s.hints = Hints{Hints: tc.hints}
+
+			actual, err := s.applyHints(cf)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error wrapping %v, got: %v", tc.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tc.expectedMoves, actual.Stages[0].Moves); diff != "" {
+				t.Errorf("Moves mismatch (-want +got):\n%s", diff)
+			}
+			if cf.Stages[0].Moves != nil {
+				t.Errorf("expected the containerfile to be unchanged, got moves %v", cf.Stages[0].Moves)
+			}
+			var codes []string
+			for _, w := range s.warnings {
+				codes = append(codes, w.Code)
+			}
+			if diff := cmp.Diff(tc.expectedWarnings, codes); diff != "" {
+				t.Errorf("Warnings mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExplainSourcesHint(t *testing.T) {
+	t.Parallel()
+	cf := containerfile.Containerfile{Stages: []containerfile.Stage{
+		{Alias: "deps", Base: "docker.io/library/node:24", BaseRef: "docker.io/library/node:24"},
+		{
+			Alias:   "builder",
+			Base:    "docker.io/library/node:24",
+			BaseRef: "docker.io/library/node:24",
+			Index:   1,
+			Copies: []containerfile.Copy{{
+				Sources: []string{"/node_modules/"}, Destination: "/src/node_modules/",
+				From: "deps", Type: containerfile.CopyTypeBuilder, Line: 4,
+			}},
+			Moves: []containerfile.Move{{Source: "/src", Destination: "/app/dist/", Workdir: "/", Declared: true}},
+		},
+		{
+			Alias:   "2",
+			Base:    "scratch",
+			BaseRef: "scratch",
+			Index:   2,
+			Kind:    containerfile.StageKindFinal,
+			Copies: []containerfile.Copy{{
+				Sources: []string{"/app/dist/"}, Destination: "/app/",
+				From: "builder", Type: containerfile.CopyTypeBuilder, Line: 8,
+			}},
+		},
+	}}
+	index := func(i int) *int { return &i }
+	items := []PackageMetadataItem{
+		{PackageURL: "pkg:npm/left-pad@1.3.0", OriginType: "builder", StageIndex: index(0)},
+	}
+
+	explainSources(cf, nil, nil).apply(items)
+
+	expected := [][]CopyStep{{
+		{StageAlias: "2", StageIndex: 2, Line: 8, From: "builder", Source: "/app/dist/", Destination: "/app/"},
+		{StageAlias: "builder", StageIndex: 1, From: "builder", Source: "/src", Destination: "/app/dist/", Hint: true},
+		{StageAlias: "builder", StageIndex: 1, Line: 4, From: "deps", Source: "/node_modules/", Destination: "/src/node_modules/"},
+	}}
+	if diff := cmp.Diff(expected, items[0].Provenance); diff != "" {
+		t.Errorf("Provenance mismatch (-want +got):\n%s", diff)
+	}
+}
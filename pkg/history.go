@@ -0,0 +1,260 @@
+// Attribution of content copied from stages without intermediate images by
+// the history of the built image, see WithBuiltImage.
+
+package capo
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+	"github.com/konflux-ci/capo/pkg/storageclient"
+)
+
+// WarnHistoryFallback is reported for every stage without an intermediate
+// image whose content is attributed by the history of the built image (see
+// WithBuiltImage).
+const WarnHistoryFallback = "WARN_HISTORY_FALLBACK"
+
+// originTypeHistory is the origin type of packages found in the layers of
+// the built image copied from a stage without an intermediate image. Their
+// content is of the stage, but whether it was added by the stage or is of
+// its base image is unknown.
+const originTypeHistory = "history"
+
+// COPY --from instructions in the created_by field of history entries, as
+// written by buildah ("/bin/sh -c #(nop) COPY --from=builder ...") and
+// BuildKit ("COPY --from=builder /app /app # buildkit").
+var historyCopyFrom = regexp.MustCompile(`(?:^|\s)COPY(?:\s+--\S+)*?\s+--from=("?)([^\s"]+)`)
+
+// Configure the scanner to fall back to the history of the built image, in
+// local storage by the pullspec or ID, for stages without intermediate
+// images, e.g. of an image pulled after it was pushed by another build. The
+// layers of the built image created by COPY --from a stage are scanned and
+// their packages attributed to the stage with the history origin type, if
+// not found in the content of the stage otherwise. Disabled if empty.
+func WithBuiltImage(pullspec string) Option {
+	return func(s *Scanner) {
+		s.builtImage = pullspec
+	}
+}
+
+// historyLayer is a layer of the built image created by a COPY --from
+// instruction, see historyCopies.
+type historyLayer struct {
+	// Stage reference of the --from flag, an alias or an index.
+	from string
+	// ID of the layer and of its parent layer, empty for the bottom layer.
+	layer  string
+	parent string
+}
+
+// historyCopies returns the layers created by COPY --from instructions, by
+// the history of an image and the IDs of its layers from the bottom up.
+// History entries creating layers are of the layers in order. The first
+// entries and layers are inherited from the base image of the final stage,
+// with the history baseHistory (empty if none), and skipped, as their COPY
+// --from instructions are of the build of the base image. Returns false if
+// the history doesn't match the layers, e.g. of a squashed image.
+func historyCopies(
+	history []storageclient.OCIHistory, layers []string, baseHistory []storageclient.OCIHistory,
+) ([]historyLayer, bool) {
+	inherited := 0
+	for _, entry := range baseHistory {
+		if !entry.EmptyLayer {
+			inherited++
+		}
+	}
+
+	var res []historyLayer
+	n := 0
+	for _, entry := range history {
+		if entry.EmptyLayer {
+			continue
+		}
+		if n == len(layers) {
+			return nil, false
+		}
+		if m := historyCopyFrom.FindStringSubmatch(entry.CreatedBy); m != nil && n >= inherited {
+			copied := historyLayer{from: m[2], layer: layers[n]}
+			if n > 0 {
+				copied.parent = layers[n-1]
+			}
+			res = append(res, copied)
+		}
+		n++
+	}
+	return res, n == len(layers)
+}
+
+// historyStage is a stage content is copied from, see historyStages.
+type historyStage struct {
+	index      int
+	alias      string
+	digestBase string
+}
+
+// historyStages returns the builder stages content is copied from which have
+// no intermediate image, by their alias and their index.
+func (s *Scanner) historyStages(sources []packageSource) map[string]historyStage {
+	res := make(map[string]historyStage)
+	add := func(stage historyStage) {
+		if _, found, _ := s.findIntermediateImage(stage.alias); found {
+			return
+		}
+		res[stage.alias] = stage
+		res[strconv.Itoa(stage.index)] = stage
+	}
+	var walk func(nodes []*packageSourceDescendant, digestBase string)
+	walk = func(nodes []*packageSourceDescendant, digestBase string) {
+		for _, node := range nodes {
+			if len(node.sources) > 0 {
+				add(historyStage{index: node.index, alias: node.alias, digestBase: digestBase})
+			}
+			walk(node.descendants, digestBase)
+		}
+	}
+	for _, source := range sources {
+		if source.kind == containerfile.StageKindExternal {
+			continue
+		}
+		if len(source.sources) > 0 {
+			add(historyStage{index: source.index, alias: source.alias, digestBase: source.digestBase})
+		}
+		walk(source.descendants, source.digestBase)
+	}
+	return res
+}
+
+// scanHistoryCopies scans the layers of the built image copied from stages
+// without intermediate images (see WithBuiltImage). Layers of base, the base
+// image of the final stage, are skipped. Packages already in items for the
+// stage are skipped.
+func (s *Scanner) scanHistoryCopies(
+	ctx context.Context,
+	sources []packageSource,
+	items []PackageMetadataItem,
+	base string,
+) ([]PackageMetadataItem, error) {
+	stages := s.historyStages(sources)
+	if len(stages) == 0 {
+		return nil, nil
+	}
+
+	imageID, err := s.store.Lookup(storageclient.StripTransport(s.builtImage))
+	if err != nil {
+		return nil, fmt.Errorf("could not find built image %q in buildah storage: %w", s.builtImage, ErrImageNotFound)
+	}
+	image, err := s.store.Image(imageID)
+	if err != nil {
+		return nil, fmt.Errorf("could not find built image %q in buildah storage: %w", s.builtImage, ErrImageNotFound)
+	}
+	cfg, err := s.sclient.GetImageConfig(image.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get OCI image config for %q: %w: %w", s.builtImage, err, ErrOCIConfig)
+	}
+	var layers []string
+	for layerID := image.TopLayer; layerID != ""; {
+		layer, err := s.store.Layer(layerID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get layer %s: %w: %w", layerID, err, ErrStorage)
+		}
+		layers = append(layers, layer.ID)
+		layerID = layer.Parent
+	}
+	slices.Reverse(layers)
+
+	var baseHistory []storageclient.OCIHistory
+	if base != "" && !storageclient.IsSpecialBase(base) {
+		baseCfg, err := s.sclient.GetImageConfig(base)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get OCI image config for %q: %w: %w", base, err, ErrOCIConfig)
+		}
+		baseHistory = baseCfg.History
+	}
+
+	copies, ok := historyCopies(cfg.History, layers, baseHistory)
+	if !ok {
+		s.logger.Warn("history of the built image doesn't match its layers, skipping the fallback",
+			"image", s.builtImage, "layers", len(layers))
+		return nil, nil
+	}
+
+	// packages found for every stage, so only missing ones are added
+	reported := make(map[string]map[string]bool)
+	for _, item := range items {
+		if reported[item.StageAlias] == nil {
+			reported[item.StageAlias] = make(map[string]bool)
+		}
+		reported[item.StageAlias][item.PackageURL] = true
+	}
+
+	res := make([]PackageMetadataItem, 0)
+	warned := make(map[string]bool)
+	for _, copied := range copies {
+		stage, ok := stages[copied.from]
+		if !ok {
+			continue
+		}
+		if !warned[stage.alias] {
+			warned[stage.alias] = true
+			s.warn(WarnHistoryFallback, fmt.Sprintf(
+				"stage %q has no intermediate image, content copied from it is attributed by the history "+
+					"of the built image with origin type %q",
+				stage.alias, originTypeHistory,
+			))
+		}
+		stageItems, err := s.scanHistoryLayer(ctx, stage, copied)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range stageItems {
+			if !reported[stage.alias][item.PackageURL] {
+				res = append(res, item)
+			}
+		}
+	}
+	return res, nil
+}
+
+// scanHistoryLayer scans the content of the layer copied from the stage.
+func (s *Scanner) scanHistoryLayer(
+	ctx context.Context,
+	stage historyStage,
+	copied historyLayer,
+) (_ []PackageMetadataItem, err error) {
+	contentPath, err := s.contentDir(stage.alias, debugKindIntermediate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w: %w", err, ErrIO)
+	}
+	defer func() {
+		if removeErr := s.removeContentDirs(contentPath); err == nil {
+			err = removeErr
+		}
+	}()
+
+	s.logger.Debug("scanning layer of the built image copied from stage",
+		"stage", stage.alias, "layer", copied.layer)
+	included, err := s.saveDiff(ctx, contentPath, copied.layer, copied.parent, []string{"/"})
+	if err != nil {
+		return nil, fmt.Errorf("%w: content of %q in the built image: %w", ErrExtract, stage.alias, err)
+	}
+	if len(included) == 0 {
+		return nil, nil
+	}
+	pkgs, err := s.scanContent(ctx, s.syftScanner, scanKindContent, contentPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan content of %q in the built image: %w: %w", stage.alias, err, ErrSBOMScan)
+	}
+	err = s.recordContentFiles(contentPath, included, stage.alias, stage.digestBase, originTypeHistory, nil, pkgs)
+	if err != nil {
+		return nil, err
+	}
+
+	res := getPackageMetadata(stage.alias, stage.digestBase, "", originTypeHistory, nil, nil, pkgs)
+	setStageIndex(res, stage.index)
+	return res, nil
+}
@@ -0,0 +1,88 @@
+//go:build unit
+
+package capo
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/konflux-ci/capo/pkg/storageclient"
+)
+
+func TestHistoryCopies(t *testing.T) {
+	t.Parallel()
+	base := storageclient.OCIHistory{CreatedBy: "/bin/sh -c #(nop) ADD file:4b1c in / "}
+	tests := map[string]struct {
+		history     []storageclient.OCIHistory
+		layers      []string
+		baseHistory []storageclient.OCIHistory
+		expected    []historyLayer
+		ok          bool
+	}{
+		"buildah": {
+			history: []storageclient.OCIHistory{
+				base,
+				{CreatedBy: "/bin/sh -c #(nop) LABEL name=app", EmptyLayer: true},
+				{CreatedBy: "/bin/sh -c #(nop) COPY --from=builder dir:9f2c in /app "},
+				{CreatedBy: "/bin/sh -c #(nop) COPY file:7e6d in /etc/app.conf "},
+				{CreatedBy: "/bin/sh -c #(nop) COPY --chown=1001 --from=1 file:3c1d in /usr/bin/tool "},
+			},
+			layers: []string{"base", "app", "conf", "tool"},
+			expected: []historyLayer{
+				{from: "builder", layer: "app", parent: "base"},
+				{from: "1", layer: "tool", parent: "conf"},
+			},
+			ok: true,
+		},
+		"buildkit": {
+			history: []storageclient.OCIHistory{
+				{CreatedBy: `COPY --from="builder" /app /app # buildkit`},
+				{CreatedBy: "RUN /bin/sh -c make # buildkit"},
+			},
+			layers:   []string{"app", "make"},
+			expected: []historyLayer{{from: "builder", layer: "app"}},
+			ok:       true,
+		},
+		"copies of the base image": {
+			history: []storageclient.OCIHistory{
+				base,
+				{CreatedBy: "/bin/sh -c #(nop) COPY --from=builder dir:1a2b in /opt/base "},
+				{CreatedBy: "/bin/sh -c #(nop) CMD [\"/bin/sh\"]", EmptyLayer: true},
+				{CreatedBy: "/bin/sh -c #(nop) COPY --from=builder dir:9f2c in /app "},
+			},
+			layers: []string{"base", "opt", "app"},
+			baseHistory: []storageclient.OCIHistory{
+				base,
+				{CreatedBy: "/bin/sh -c #(nop) COPY --from=builder dir:1a2b in /opt/base "},
+				{CreatedBy: "/bin/sh -c #(nop) CMD [\"/bin/sh\"]", EmptyLayer: true},
+			},
+			expected: []historyLayer{{from: "builder", layer: "app", parent: "opt"}},
+			ok:       true,
+		},
+		"more history entries than layers": {
+			history: []storageclient.OCIHistory{base, {CreatedBy: "COPY --from=builder /app /app # buildkit"}},
+			layers:  []string{"squashed"},
+		},
+		"fewer history entries than layers": {
+			history: []storageclient.OCIHistory{base},
+			layers:  []string{"base", "app"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			actual, ok := historyCopies(tc.history, tc.layers, tc.baseHistory)
+			if ok != tc.ok {
+				t.Fatalf("historyCopies() ok = %v, expected %v", ok, tc.ok)
+			}
+			if !ok {
+				return
+			}
+			if diff := cmp.Diff(tc.expected, actual, cmp.AllowUnexported(historyLayer{})); diff != "" {
+				t.Errorf("historyCopies() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
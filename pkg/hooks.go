@@ -0,0 +1,40 @@
+// Hooks run on the output of a successful scan, see WithPostScanHooks.
+
+package capo
+
+import (
+	"context"
+	"fmt"
+)
+
+var ErrPostScanHook = fmt.Errorf("[ERR_POST_SCAN_HOOK] post-scan hook failed: %w", ErrScan)
+
+// PostScanHook processes the output of a successful scan, e.g. to enrich its
+// packages with data of other tools.
+type PostScanHook interface {
+	// Name identifies the hook in errors and logs.
+	Name() string
+	// Run modifies the scan output in place.
+	Run(ctx context.Context, m *PackageMetadata) error
+}
+
+// Configure the scanner to run the hooks, in order, on the output of every
+// successful Scan. A failing hook fails the scan with ErrPostScanHook. Events
+// (see WithEventHandler) are reported before the hooks run, so they don't
+// include their changes.
+func WithPostScanHooks(hooks ...PostScanHook) Option {
+	return func(s *Scanner) {
+		s.postScanHooks = append(s.postScanHooks, hooks...)
+	}
+}
+
+// runPostScanHooks runs the post-scan hooks of the scanner on the output.
+func (s *Scanner) runPostScanHooks(ctx context.Context, m *PackageMetadata) error {
+	for _, hook := range s.postScanHooks {
+		s.logger.Debug("running post-scan hook", "hook", hook.Name())
+		if err := hook.Run(ctx, m); err != nil {
+			return fmt.Errorf("hook %q: %w: %w", hook.Name(), err, ErrPostScanHook)
+		}
+	}
+	return nil
+}
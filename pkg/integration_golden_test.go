@@ -0,0 +1,153 @@
+//go:build integration
+
+package capo
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var record = flag.Bool(
+	"record", false, "Write the expected outputs of TestIntegrationGolden from the scans instead of comparing.",
+)
+
+// Directory of the golden-file test cases of TestIntegrationGolden. Every
+// case is a directory with:
+//   - Containerfile: the test image, built with --save-stages --stage-labels
+//   - images/<name>/Containerfile: fixture images the test image is built
+//     from, tagged localhost/<name>:latest and built in lexical order
+//   - expected.json: the expected packages, written with -record
+//
+// All images are built with testdata/image_content as the context and never
+// pulled, so fixtures have to be built from scratch or from other fixtures.
+const goldenCasesDir = "../testdata/integration"
+
+// Build context of the images of golden-file test cases.
+const goldenContextDir = "../testdata/image_content"
+
+// TestIntegrationGolden builds the images of the cases in goldenCasesDir,
+// scans them and compares the packages with the expected.json of the case.
+// To add a case or update the expected packages after a change, run with
+// -record and review the diff of the written files.
+func TestIntegrationGolden(t *testing.T) {
+	entries, err := os.ReadDir(goldenCasesDir)
+	if err != nil {
+		t.Fatalf("Failed to read golden cases: %v", err)
+	}
+	buildahBinary := getBuildahBinary(t)
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(goldenCasesDir, entry.Name())
+
+		t.Run(entry.Name(), func(t *testing.T) {
+			tc, err := readGoldenCase(dir, entry.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			scanner, err := createTestScanner([]string{})
+			if err != nil {
+				t.Fatalf("Failed to create scanner: %+v", err)
+			}
+			result, err := tc.scan(t, scanner, buildahBinary)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expectedPath := filepath.Join(dir, "expected.json")
+			if *record {
+				if err := writeGoldenPackages(expectedPath, result.Packages); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			expected, err := readGoldenPackages(expectedPath)
+			if err != nil {
+				t.Fatalf("Failed to read expected packages (run with -record to write them): %v", err)
+			}
+			if diff := diffPackages(expected, result.Packages); diff != "" {
+				t.Errorf("package comparison mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// readGoldenCase reads the images of the golden-file test case in dir.
+func readGoldenCase(dir, name string) (TestCase, error) {
+	content, err := os.ReadFile(filepath.Join(dir, "Containerfile"))
+	if err != nil {
+		return TestCase{}, err
+	}
+	tc := TestCase{
+		TestImage: BuildDefinition{
+			Tag:                  normalizeTag("golden-" + name),
+			ContainerfileContent: string(content),
+			ContextDirectory:     goldenContextDir,
+		},
+	}
+
+	images, err := os.ReadDir(filepath.Join(dir, "images"))
+	if err != nil && !os.IsNotExist(err) {
+		return TestCase{}, err
+	}
+	for _, image := range images {
+		if !image.IsDir() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, "images", image.Name(), "Containerfile"))
+		if err != nil {
+			return TestCase{}, err
+		}
+		tc.BuilderImages = append(tc.BuilderImages, BuildDefinition{
+			Tag:                  normalizeTag(image.Name()),
+			ContainerfileContent: string(content),
+			ContextDirectory:     goldenContextDir,
+		})
+	}
+	return tc, nil
+}
+
+// readGoldenPackages reads the expected packages from the golden file at path.
+func readGoldenPackages(path string) ([]PackageMetadataItem, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var expected PackageMetadata
+	if err := json.Unmarshal(data, &expected); err != nil {
+		return nil, err
+	}
+	return expected.Packages, nil
+}
+
+// writeGoldenPackages writes the packages to the golden file at path, sorted
+// and without what varies between builds and is ignored by diffPackages:
+// IDs, stage indexes and digests of pullspecs.
+func writeGoldenPackages(path string, packages []PackageMetadataItem) error {
+	golden := make([]PackageMetadataItem, 0, len(packages))
+	for _, item := range packages {
+		item.ID = ""
+		item.StageIndex = nil
+		item.Pullspec = normalizePullspec(item.Pullspec)
+		golden = append(golden, item)
+	}
+	slices.SortFunc(golden, func(a, b PackageMetadataItem) int {
+		if c := strings.Compare(a.PackageURL, b.PackageURL); c != 0 {
+			return c
+		}
+		return strings.Compare(a.DependencyOfPURL, b.DependencyOfPURL)
+	})
+
+	data, err := json.MarshalIndent(PackageMetadata{Packages: golden}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
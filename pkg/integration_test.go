@@ -80,7 +80,7 @@ func createTestScanner(selectionRequest []string) (*Scanner, error) {
 			slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})),
 		),
 		WithSelectCatalogers(
-			selectionRequest...
+			selectionRequest...,
 		),
 	)
 }
@@ -121,6 +121,8 @@ type BuildDefinition struct {
 	// Build contexts to pass to the build. Values representing local directory
 	// paths are relative to pkg/
 	BuildContexts map[string]string
+	// Additional arguments passed to buildah build (e.g. "--squash").
+	BuildahArgs []string
 }
 
 // TestCase describes a single integration test: build images, scan, compare results.
@@ -172,32 +174,49 @@ func (testCase *TestCase) build(store storage.Store, buildahBinary string) error
 }
 
 func (testCase *TestCase) run(t *testing.T, scanner *Scanner, buildahBinary string) error {
+	result, err := testCase.scan(t, scanner, buildahBinary)
+	if err != nil {
+		return err
+	}
+
+	if diff := diffPackages(testCase.ExpectedResult.Packages, result.Packages); diff != "" {
+		t.Errorf("package comparison mismatch (-want +got):\n%s", diff)
+		return errors.New("package comparison failed")
+	}
+	return nil
+}
+
+// scan builds the images of the test case, scans the test image and removes
+// the images again.
+func (testCase *TestCase) scan(t *testing.T, scanner *Scanner, buildahBinary string) (PackageMetadata, error) {
 	defer testCase.cleanUp(t, scanner.store)
 	if err := testCase.build(scanner.store, buildahBinary); err != nil {
-		return err
+		return PackageMetadata{}, err
 	}
 
 	cf, err := containerfile.Parse(
 		strings.NewReader(testCase.TestImage.ContainerfileContent),
-		containerfile.BuildOptions{
-			BuildContexts: testCase.TestImage.BuildContexts,
-		},
+		containerfile.WithBuildContexts(testCase.TestImage.BuildContexts),
 	)
 	if err != nil {
-		return err
+		return PackageMetadata{}, err
 	}
 
-	result, err := scanner.Scan(cf)
-	if err != nil {
-		return err
-	}
+	return scanner.Scan(cf)
+}
 
-	// Compare packages order-independently using go-cmp:
-	// - SortSlices: ensures comparison is order-independent by sorting on PackageURL
-	// - EquateEmpty: treats nil and empty slices as equal
-	// - FilterPath on Pullspec: strips @sha256: digests before comparing pullspecs,
-	//   since actual digests vary between builds and should not cause test failures
-	diff := cmp.Diff(testCase.ExpectedResult.Packages, result.Packages,
+// diffPackages compares the expected and the scanned packages and returns
+// their differences, if any:
+//   - SortSlices: ensures comparison is order-independent by sorting on PackageURL
+//   - EquateEmpty: treats nil and empty slices as equal
+//   - IgnoreFields StageIndex: stages are identified by StageAlias in test
+//     cases, stage indexes are covered by unit tests
+//   - IgnoreFields ID: IDs are hashes including the pullspec with digest,
+//     they are covered by unit tests
+//   - FilterPath on Pullspec: strips @sha256: digests before comparing pullspecs,
+//     since actual digests vary between builds and should not cause test failures
+func diffPackages(expected, actual []PackageMetadataItem) string {
+	return cmp.Diff(expected, actual,
 		cmpopts.SortSlices(func(a, b PackageMetadataItem) bool {
 			if a.PackageURL != b.PackageURL {
 				return a.PackageURL < b.PackageURL
@@ -205,6 +224,7 @@ func (testCase *TestCase) run(t *testing.T, scanner *Scanner, buildahBinary stri
 			return a.DependencyOfPURL < b.DependencyOfPURL
 		}),
 		cmpopts.EquateEmpty(),
+		cmpopts.IgnoreFields(PackageMetadataItem{}, "StageIndex", "ID"),
 		cmp.FilterPath(func(p cmp.Path) bool {
 			return p.String() == "Pullspec"
 		}, cmp.Comparer(func(a, b string) bool {
@@ -219,11 +239,6 @@ func (testCase *TestCase) run(t *testing.T, scanner *Scanner, buildahBinary stri
 			return normalizeStdlibPURL(a) == normalizeStdlibPURL(b)
 		})),
 	)
-	if diff != "" {
-		t.Errorf("package comparison mismatch (-want +got):\n%s", diff)
-		return errors.New("package comparison failed")
-	}
-	return nil
 }
 
 // buildImage builds a container image from a containerfile using buildah.
@@ -304,6 +319,9 @@ func (buildDef *BuildDefinition) runBuildah(buildahBinary, tag string, saveStage
 		tmpFile.Name(),
 		"--tag",
 		tag,
+		// all images are built locally, never pulled, so the tests don't
+		// depend on a registry
+		"--pull=never",
 	}
 	if saveStages {
 		args = append(args, "--save-stages", "--stage-labels")
@@ -314,6 +332,7 @@ func (buildDef *BuildDefinition) runBuildah(buildahBinary, tag string, saveStage
 		}
 	}
 
+	args = append(args, buildDef.BuildahArgs...)
 	args = append(args, buildDef.ContextDirectory)
 
 	var buf bytes.Buffer
@@ -1425,6 +1444,38 @@ func TestIntegration(t *testing.T) {
 				),
 			},
 		},
+		"[Squash] Builder stage built with --squash": {
+			TestImage: BuildDefinition{
+				Tag: "test-squash",
+				ContainerfileContent: `FROM localhost/builder-base:latest AS builder
+										COPY uuider /content/uuider
+
+										FROM scratch
+										COPY --from=builder /base /base
+										COPY --from=builder /content /content`,
+				ContextDirectory: "../testdata/image_content",
+				BuildahArgs:      []string{"--squash"},
+			},
+			BuilderImages: []BuildDefinition{
+				{
+					Tag: "localhost/builder-base:latest",
+					ContainerfileContent: `FROM scratch
+											COPY syfter /base/syfter
+											COPY go2 /untracked/base/go2`,
+					ContextDirectory: "../testdata/image_content",
+				},
+			},
+			ExpectedResult: PackageMetadata{
+				Packages: slices.Concat(
+					syfterBuilder.ExpectedPullspec("localhost/builder-base@sha256:dummy").
+						ExpectedOriginType("squashed").
+						ExpectedStageAlias("builder").Build(),
+					uuiderBuilder.ExpectedPullspec("localhost/builder-base@sha256:dummy").
+						ExpectedOriginType("squashed").
+						ExpectedStageAlias("builder").Build(),
+				),
+			},
+		},
 		"[External content] External COPY in final stage": {
 			TestImage: BuildDefinition{
 				Tag: "test-external-copy-final",
@@ -1518,7 +1569,8 @@ func TestIntegration(t *testing.T) {
 			},
 		},
 		"[Pullspec normalization] Pullspec is missing registry and tag/digest": {
-			SkipTestReason: "[Priority: medium/low] pullspec normalization not supported - store.Lookup does exact match with registry and tag/digest",
+			SkipTestReason: "[Priority: medium/low] short names are normalized to docker.io/library, " +
+				"but buildah stores locally built short-named images under localhost/",
 			TestImage: BuildDefinition{
 				Tag: "test-simple-pullspec",
 				ContainerfileContent: `FROM image AS builder
@@ -1627,6 +1679,58 @@ func TestIntegration(t *testing.T) {
 				),
 			},
 		},
+		"[Numeric index COPY --from] Unnamed stage between named stages": {
+			TestImage: BuildDefinition{
+				Tag: "test-unnamed-between-named",
+				ContainerfileContent: `FROM localhost/base1:latest AS first
+										COPY uuider /opt/app0/uuider
+
+										FROM localhost/base2:latest
+										COPY exp /opt/app1/exp
+										COPY texter /untracked/s1/texter
+
+										FROM localhost/base1:latest AS last
+										COPY texter /opt/app2/texter
+
+										FROM scratch
+										COPY --from=first /opt/app0 /opt/app0
+										COPY --from=1 /opt/ /opt/
+										COPY --from=last /opt/app2 /opt/app2`,
+				ContextDirectory: "../testdata/image_content",
+			},
+			BuilderImages: []BuildDefinition{
+				{
+					Tag: "localhost/base1:latest",
+					ContainerfileContent: `FROM scratch
+											COPY syfter /opt/base1/syfter
+											COPY go2 /untracked/base1/go2`,
+					ContextDirectory: "../testdata/image_content",
+				},
+				{
+					Tag: "localhost/base2:latest",
+					ContainerfileContent: `FROM scratch
+											COPY syncer /opt/base2/syncer
+											COPY go2 /untracked/base2/go2`,
+					ContextDirectory: "../testdata/image_content",
+				},
+			},
+			ExpectedResult: PackageMetadata{
+				Packages: slices.Concat(
+					uuiderBuilder.ExpectedPullspec("localhost/base1@sha256:dummy").
+						ExpectedOriginType("intermediate").
+						ExpectedStageAlias("first").Build(),
+					syncerBuilder.ExpectedPullspec("localhost/base2@sha256:dummy").
+						ExpectedOriginType("builder").
+						ExpectedStageAlias("1").Build(),
+					expBuilder.ExpectedPullspec("localhost/base2@sha256:dummy").
+						ExpectedOriginType("intermediate").
+						ExpectedStageAlias("1").Build(),
+					texterBuilder.ExpectedPullspec("localhost/base1@sha256:dummy").
+						ExpectedOriginType("intermediate").
+						ExpectedStageAlias("last").Build(),
+				),
+			},
+		},
 		"[Numeric index COPY --from] COPY --from with numeric index in final stage (stage has alias)": {
 			TestImage: BuildDefinition{
 				Tag: "test-numeric-copy-from-final",
@@ -2127,6 +2231,43 @@ COPY --from=builder /opt/syfter /opt/syfter`, tagDigestRef),
 	})
 }
 
+// TestIntegrationStorageDriver checks that the scan reports the driver of the
+// store, the one set by STORAGE_DRIVER when run by mage IntegrationDrivers.
+func TestIntegrationStorageDriver(t *testing.T) {
+	scanner, err := createTestScanner([]string{})
+	if err != nil {
+		t.Fatalf("Failed to create scanner: %+v", err)
+	}
+	expected := os.Getenv(storageclient.StorageDriverEnv)
+	if expected == "" {
+		expected = scanner.store.GraphDriverName()
+	}
+
+	tc := TestCase{
+		TestImage: BuildDefinition{
+			ContainerfileContent: `FROM localhost/capo-driver-test-builder:latest as builder
+									FROM scratch
+									COPY --from=builder /opt/syfter /opt/syfter`,
+			ContextDirectory: "../testdata/image_content",
+		},
+		BuilderImages: []BuildDefinition{
+			{
+				Tag:                  "localhost/capo-driver-test-builder:latest",
+				ContainerfileContent: "FROM scratch\nCOPY syfter /opt/syfter",
+				ContextDirectory:     "../testdata/image_content",
+			},
+		},
+	}
+	normalizeTestCaseTags(&tc)
+	result, err := tc.scan(t, scanner, getBuildahBinary(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StorageDriver != expected {
+		t.Errorf("expected storage driver %q, got %q", expected, result.StorageDriver)
+	}
+}
+
 type ErrorTestCase struct {
 	ContainerfileContent string
 	ExpectedError        error
@@ -2149,7 +2290,7 @@ func TestIntegrationScanErrors(t *testing.T) {
 
 	for name, tc := range testCases {
 		t.Run(name, func(t *testing.T) {
-			cf, err := containerfile.Parse(strings.NewReader(tc.ContainerfileContent), containerfile.BuildOptions{})
+			cf, err := containerfile.Parse(strings.NewReader(tc.ContainerfileContent))
 			if err != nil {
 				t.Fatalf("Failed to parse containerfile: %v", err)
 			}
@@ -0,0 +1,50 @@
+// Recording of labels of the final stage in the scan output, see WithLabels.
+
+package capo
+
+import (
+	"maps"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+)
+
+// Configure the scanner to record the labels of the final stage set by LABEL
+// instructions, including those of the stages it is chained from, with keys
+// matching any of the patterns (e.g. "org.opencontainers.image.*", with "*"
+// matching any characters) in PackageMetadata.Labels. Labels of the base
+// image aren't recorded. No labels are recorded by default.
+func WithLabels(patterns ...string) Option {
+	return func(s *Scanner) {
+		s.labelPatterns = compilePatterns(patterns)
+	}
+}
+
+// getFinalLabels returns the labels of the final stage with keys matching any
+// of the label patterns of the scanner, redacted like environment variables
+// (see WithRedactor). Returns nil if there are none.
+func (s *Scanner) getFinalLabels(cf containerfile.Containerfile) map[string]string {
+	stage := cf.FinalStage()
+	if stage == nil || len(s.labelPatterns) == 0 {
+		return nil
+	}
+
+	// chained stages inherit the labels of their parent, apply from the root
+	chain := []*containerfile.Stage{stage}
+	for {
+		parent := cf.ResolveRef(stage.BaseRef, stage.Index)
+		if parent == nil || parent.Index >= stage.Index {
+			break
+		}
+		chain = append(chain, parent)
+		stage = parent
+	}
+	labels := make(map[string]string)
+	for i := len(chain) - 1; i >= 0; i-- {
+		maps.Copy(labels, chain[i].Labels)
+	}
+
+	maps.DeleteFunc(labels, func(key, _ string) bool {
+		return !matchesAny(s.labelPatterns, key)
+	})
+	return s.redactor.Env(labels)
+}
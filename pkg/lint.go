@@ -0,0 +1,174 @@
+// Linting of containerfiles for patterns capo can't attribute precisely.
+
+package capo
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+)
+
+// Severity of a lint finding.
+type Severity string
+
+const (
+	// SeverityError is a pattern capo fails the scan on.
+	SeverityError Severity = "error"
+	// SeverityWarning is a pattern for which content may be attributed to the
+	// wrong origin or not at all.
+	SeverityWarning Severity = "warning"
+	// SeverityInfo is a pattern capo only attributes heuristically.
+	SeverityInfo Severity = "info"
+)
+
+var ErrInvalidSeverity = fmt.Errorf(
+	"[ERR_INVALID_SEVERITY] invalid severity, expected error, warning or info: %w", ErrParse,
+)
+
+// ParseSeverity returns the severity with the passed name.
+func ParseSeverity(s string) (Severity, error) {
+	switch sev := Severity(s); sev {
+	case SeverityError, SeverityWarning, SeverityInfo:
+		return sev, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrInvalidSeverity, s)
+}
+
+// AtLeast reports whether the severity is the same as or more severe than
+// other.
+func (s Severity) AtLeast(other Severity) bool {
+	return s.rank() >= other.rank()
+}
+
+func (s Severity) rank() int {
+	switch s {
+	case SeverityError:
+		return 2
+	case SeverityWarning:
+		return 1
+	default:
+		return 0
+	}
+}
+
+const (
+	// LintUnsupportedFeature is a feature that fails the scan (see
+	// preflightCheck).
+	LintUnsupportedFeature = "unsupported-feature"
+	// LintRelativeCopyDestination is a COPY to a relative destination
+	// without a WORKDIR, which depends on the working directory of the base
+	// image.
+	LintRelativeCopyDestination = "relative-copy-destination"
+	// LintDuplicateBuilderBase is a builder base image used by more than one
+	// stage, whose packages can't be told apart by the stage they were
+	// copied from.
+	LintDuplicateBuilderBase = "duplicate-builder-base"
+	// LintCopyFromIndex is a COPY --from referring to a stage by its index,
+	// which silently changes meaning when stages are added or removed.
+	LintCopyFromIndex = "copy-from-index"
+	// LintRunMove is a RUN mv or cp command, which capo traces only for
+	// simple commands.
+	LintRunMove = "run-move"
+)
+
+// Finding is a pattern found by Lint.
+type Finding struct {
+	// Rule that found the pattern, e.g. LintCopyFromIndex.
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	// Index of the stage the pattern is in, nil if not specific to a stage.
+	StageIndex *int `json:"stage_index,omitempty"`
+	// Human-readable description of the pattern.
+	Message string `json:"message"`
+}
+
+// Lint returns the patterns in the containerfile which capo can't attribute
+// precisely, in the order of the rules and the stages.
+func Lint(cf containerfile.Containerfile) []Finding {
+	res := make([]Finding, 0)
+	for _, err := range []error{checkDuplicateAlias(cf), checkRunMountTypeBind(cf)} {
+		if err != nil {
+			res = append(res, Finding{
+				Rule:     LintUnsupportedFeature,
+				Severity: SeverityError,
+				Message:  err.Error(),
+			})
+		}
+	}
+
+	for _, stage := range cf.Stages {
+		for _, cp := range stage.Copies {
+			if cp.Workdir == "" && !path.IsAbs(cp.Destination) {
+				res = append(res, stageFinding(LintRelativeCopyDestination, SeverityWarning, stage, fmt.Sprintf(
+					"COPY to relative destination %q without WORKDIR depends on the working directory of the base image",
+					cp.Destination,
+				)))
+			}
+		}
+	}
+
+	res = append(res, duplicateBuilderBaseFindings(cf)...)
+
+	for _, stage := range cf.Stages {
+		for _, cp := range stage.Copies {
+			if cp.Type != containerfile.CopyTypeBuilder {
+				continue
+			}
+			if _, err := strconv.Atoi(cp.From); err == nil {
+				res = append(res, stageFinding(LintCopyFromIndex, SeverityWarning, stage, fmt.Sprintf(
+					"COPY --from=%s refers to a stage by index, name the stage with FROM ... AS instead",
+					cp.From,
+				)))
+			}
+		}
+	}
+
+	for _, stage := range cf.Stages {
+		for _, mv := range stage.Moves {
+			res = append(res, stageFinding(LintRunMove, SeverityInfo, stage, fmt.Sprintf(
+				"RUN moves %q to %q, content is traced through simple mv and cp commands only",
+				mv.Source, mv.Destination,
+			)))
+		}
+	}
+
+	return res
+}
+
+// duplicateBuilderBaseFindings returns a finding for every builder base image
+// used by more than one non-chained builder stage.
+func duplicateBuilderBaseFindings(cf containerfile.Containerfile) []Finding {
+	stages := make(map[string][]int)
+	order := make([]string, 0)
+	for _, stage := range cf.BuilderStages() {
+		if stage.BaseRef != stage.Base || stage.Base == "scratch" {
+			continue
+		}
+		if _, ok := stages[stage.Base]; !ok {
+			order = append(order, stage.Base)
+		}
+		stages[stage.Base] = append(stages[stage.Base], stage.Index)
+	}
+
+	res := make([]Finding, 0)
+	for _, base := range order {
+		if indexes := stages[base]; len(indexes) > 1 {
+			res = append(res, Finding{
+				Rule:     LintDuplicateBuilderBase,
+				Severity: SeverityWarning,
+				Message: fmt.Sprintf(
+					"base image %q is used by stages %v, packages of the image can't be told apart by stage",
+					base, indexes,
+				),
+			})
+		}
+	}
+	return res
+}
+
+func stageFinding(rule string, severity Severity, stage containerfile.Stage, message string) Finding {
+	index := stage.Index
+	return Finding{Rule: rule, Severity: severity, StageIndex: &index, Message: message}
+}
@@ -0,0 +1,155 @@
+//go:build unit
+
+package capo
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+)
+
+func TestLint(t *testing.T) {
+	t.Parallel()
+	intPtr := func(i int) *int { return &i }
+	final := func(index int, copies ...containerfile.Copy) containerfile.Stage {
+		return containerfile.Stage{
+			Alias:   "final",
+			Base:    "registry.access.redhat.com/ubi9/ubi-micro:latest",
+			BaseRef: "registry.access.redhat.com/ubi9/ubi-micro:latest",
+			Index:   index,
+			Kind:    containerfile.StageKindFinal,
+			Copies:  copies,
+		}
+	}
+
+	tests := map[string]struct {
+		stages   []containerfile.Stage
+		expected []Finding
+	}{
+		"no findings": {
+			stages: []containerfile.Stage{
+				{Alias: "builder", Base: "docker.io/library/golang:1.26", BaseRef: "docker.io/library/golang:1.26"},
+				final(1, containerfile.Copy{
+					Sources: []string{"/app"}, Destination: "/app", From: "builder", Type: containerfile.CopyTypeBuilder,
+				}),
+			},
+			expected: []Finding{},
+		},
+		"relative copy destination without workdir": {
+			stages: []containerfile.Stage{
+				{Alias: "builder", Base: "docker.io/library/golang:1.26", BaseRef: "docker.io/library/golang:1.26"},
+				final(1,
+					containerfile.Copy{
+						Sources: []string{"/app"}, Destination: "app", From: "builder", Type: containerfile.CopyTypeBuilder,
+					},
+					containerfile.Copy{
+						Sources: []string{"/bin"}, Destination: "bin", From: "builder", Type: containerfile.CopyTypeBuilder,
+						Workdir: "/srv",
+					},
+				),
+			},
+			expected: []Finding{{
+				Rule:       LintRelativeCopyDestination,
+				Severity:   SeverityWarning,
+				StageIndex: intPtr(1),
+				Message:    `COPY to relative destination "app" without WORKDIR depends on the working directory of the base image`,
+			}},
+		},
+		"same builder base in multiple stages": {
+			stages: []containerfile.Stage{
+				{Alias: "a", Base: "docker.io/library/golang:1.26", BaseRef: "docker.io/library/golang:1.26"},
+				{Alias: "b", Base: "docker.io/library/golang:1.26", BaseRef: "docker.io/library/golang:1.26", Index: 1},
+				{Alias: "c", Base: "docker.io/library/golang:1.26", BaseRef: "a", Index: 2},
+				{Alias: "d", Base: "scratch", BaseRef: "scratch", Index: 3},
+				{Alias: "e", Base: "scratch", BaseRef: "scratch", Index: 4},
+				final(5),
+			},
+			expected: []Finding{{
+				Rule:     LintDuplicateBuilderBase,
+				Severity: SeverityWarning,
+				Message:  `base image "docker.io/library/golang:1.26" is used by stages [0 1], packages of the image can't be told apart by stage`,
+			}},
+		},
+		"copy from unnamed stage by index": {
+			stages: []containerfile.Stage{
+				{Alias: "0", Base: "docker.io/library/golang:1.26", BaseRef: "docker.io/library/golang:1.26"},
+				final(1, containerfile.Copy{
+					Sources: []string{"/app"}, Destination: "/app", From: "0", Type: containerfile.CopyTypeBuilder,
+				}),
+			},
+			expected: []Finding{{
+				Rule:       LintCopyFromIndex,
+				Severity:   SeverityWarning,
+				StageIndex: intPtr(1),
+				Message:    "COPY --from=0 refers to a stage by index, name the stage with FROM ... AS instead",
+			}},
+		},
+		"run moves": {
+			stages: []containerfile.Stage{
+				{
+					Alias: "builder", Base: "docker.io/library/golang:1.26", BaseRef: "docker.io/library/golang:1.26",
+					Moves: []containerfile.Move{{Source: "/build/out", Destination: "/app/bin"}},
+				},
+				final(1, containerfile.Copy{
+					Sources: []string{"/app"}, Destination: "/app", From: "builder", Type: containerfile.CopyTypeBuilder,
+				}),
+			},
+			expected: []Finding{{
+				Rule:       LintRunMove,
+				Severity:   SeverityInfo,
+				StageIndex: intPtr(0),
+				Message:    `RUN moves "/build/out" to "/app/bin", content is traced through simple mv and cp commands only`,
+			}},
+		},
+		"bind mount from stage": {
+			stages: []containerfile.Stage{
+				{Alias: "builder", Base: "docker.io/library/golang:1.26", BaseRef: "docker.io/library/golang:1.26"},
+				{
+					Alias: "final", Base: "scratch", BaseRef: "scratch", Index: 1, Kind: containerfile.StageKindFinal,
+					Mounts: []containerfile.Mount{{FromRaw: "builder", MountType: containerfile.MountTypeBind}},
+				},
+			},
+			expected: []Finding{{
+				Rule:     LintUnsupportedFeature,
+				Severity: SeverityError,
+				Message:  ErrMountTypeBind.Error(),
+			}},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			actual := Lint(containerfile.Containerfile{Stages: test.stages})
+			if diff := cmp.Diff(test.expected, actual); diff != "" {
+				t.Errorf("Lint() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSeverityAtLeast(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		severity Severity
+		other    Severity
+		expected bool
+	}{
+		"error at least warning":   {severity: SeverityError, other: SeverityWarning, expected: true},
+		"warning at least warning": {severity: SeverityWarning, other: SeverityWarning, expected: true},
+		"info at least warning":    {severity: SeverityInfo, other: SeverityWarning, expected: false},
+		"warning at least error":   {severity: SeverityWarning, other: SeverityError, expected: false},
+		"info at least info":       {severity: SeverityInfo, other: SeverityInfo, expected: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if actual := test.severity.AtLeast(test.other); actual != test.expected {
+				t.Errorf("%q.AtLeast(%q) = %v, want %v", test.severity, test.other, actual, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+// Matching of image paths against COPY sources, COPY destinations and other
+// path patterns. All path comparisons of the scan go through isPathUnderPattern,
+// so they share a single semantics:
+//
+//   - Paths and patterns are cleaned first, so "/opt/", "/opt/." and "//opt"
+//     are all "/opt".
+//   - A path matches a pattern it equals or is a descendant of. Descendants
+//     are matched at path segment boundaries only: "/app" covers
+//     "/app/main.go" but not "/application".
+//   - Wildcards have the semantics of path.Match, as in buildah COPY
+//     sources. A wildcard never crosses a "/", and a path matches if it or
+//     one of its ancestors at the depth of the pattern matches: "/opt/app*"
+//     covers "/opt/app1/go.mod" but not "/opt/other/app1".
+//   - The literal comparison comes first, so names containing glob
+//     metacharacters (e.g. "/app/[id].js") match themselves, even when the
+//     pattern is malformed.
+//   - The root "/" covers every path.
+//   - Paths are container paths, always with forward slashes, so matching
+//     doesn't depend on the OS capo runs on (see containerfile.Parse for
+//     paths of Windows Containerfiles).
+
+package capo
+
+import (
+	"path"
+	"strings"
+)
+
+// isPathUnderPattern reports whether p matches pattern or is a descendant
+// of a directory matching pattern.
+func isPathUnderPattern(pattern, p string) bool {
+	pattern = path.Clean(pattern)
+	p = path.Clean(p)
+
+	if pattern == "/" {
+		return true
+	}
+
+	if p == pattern || strings.HasPrefix(p, pattern+"/") {
+		return true
+	}
+
+	if matched, _ := path.Match(pattern, p); matched {
+		return true
+	}
+
+	patternParts := strings.Split(pattern, "/")
+	pathParts := strings.Split(p, "/")
+	if len(pathParts) > len(patternParts) {
+		prefix := strings.Join(pathParts[:len(patternParts)], "/")
+		if matched, _ := path.Match(pattern, prefix); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Includes reports whether p is one of the paths matched by the patterns
+// (e.g. COPY sources) or under one of them. Paths are compared at path segment
+// boundaries, so "/usr/bin/go" includes "/usr/bin/go" and "/usr/bin/go/..."
+// but not "/usr/bin/gofmt". Wildcards have the semantics of path.Match.
+// Relative paths, e.g. names of tar entries, are taken as relative to the
+// root.
+func Includes(patterns []string, p string) bool {
+	_, ok := matchingPattern(patterns, p)
+	return ok
+}
+
+// matchingPattern returns the first of the patterns p is included by, see
+// Includes.
+func matchingPattern(patterns []string, p string) (string, bool) {
+	if !path.IsAbs(p) {
+		p = "/" + p
+	}
+
+	for _, pattern := range patterns {
+		if isPathUnderPattern(pattern, p) {
+			return pattern, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,342 @@
+//go:build unit
+
+package capo
+
+import "testing"
+
+func TestIncludes(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		sources []string
+		path    string
+		want    bool
+	}{
+		// Exact matches
+		"exact match absolute path": {
+			sources: []string{"/opt"},
+			path:    "/opt",
+			want:    true,
+		},
+		"exact match file": {
+			sources: []string{"/opt/go.mod"},
+			path:    "/opt/go.mod",
+			want:    true,
+		},
+		"no match different path": {
+			sources: []string{"/opt"},
+			path:    "/usr",
+			want:    false,
+		},
+
+		// Subdirectory matching (path is under source)
+		"path under source directory": {
+			sources: []string{"/opt"},
+			path:    "/opt/go.mod",
+			want:    true,
+		},
+		"path deeply nested under source": {
+			sources: []string{"/opt"},
+			path:    "/opt/app/sub/go.mod",
+			want:    true,
+		},
+		"path under nested source": {
+			sources: []string{"/opt/app"},
+			path:    "/opt/app/go.mod",
+			want:    true,
+		},
+
+		// Prefix collision (bug #1: /opt must NOT match /optional)
+		"prefix collision go vs gofmt": {
+			sources: []string{"/usr/bin/go"},
+			path:    "/usr/bin/gofmt",
+			want:    false,
+		},
+		"go binary directory": {
+			sources: []string{"/usr/bin/go"},
+			path:    "/usr/bin/go/bin/go",
+			want:    true,
+		},
+		"prefix collision opt vs optional": {
+			sources: []string{"/opt"},
+			path:    "/optional",
+			want:    false,
+		},
+		"prefix collision opt vs optional nested": {
+			sources: []string{"/opt"},
+			path:    "/optional/go.mod",
+			want:    false,
+		},
+		"prefix collision app vs application": {
+			sources: []string{"/app"},
+			path:    "/application/go.mod",
+			want:    false,
+		},
+		"prefix collision content vs contentful": {
+			sources: []string{"/content"},
+			path:    "/contentful/data",
+			want:    false,
+		},
+
+		// Relative path handling (tar entries may lack leading /)
+		"relative path matches absolute source": {
+			sources: []string{"/opt"},
+			path:    "opt/go.mod",
+			want:    true,
+		},
+		"relative path exact match": {
+			sources: []string{"/opt"},
+			path:    "opt",
+			want:    true,
+		},
+		"relative path prefix collision": {
+			sources: []string{"/opt"},
+			path:    "optional/go.mod",
+			want:    false,
+		},
+
+		// Wildcard exact match (same depth, no directory crossing)
+		"wildcard match single level": {
+			sources: []string{"/app*"},
+			path:    "/app1",
+			want:    true,
+		},
+		"wildcard no match": {
+			sources: []string{"/app*"},
+			path:    "/other",
+			want:    false,
+		},
+
+		// Wildcard across directory separators (bug #2)
+		"wildcard matches child path across separator": {
+			sources: []string{"/app*"},
+			path:    "/app1/go.mod",
+			want:    true,
+		},
+		"wildcard matches deeply nested child": {
+			sources: []string{"/app*"},
+			path:    "/app1/sub/go.mod",
+			want:    true,
+		},
+		"wildcard does not match unrelated nested path": {
+			sources: []string{"/app*"},
+			path:    "/other/go.mod",
+			want:    false,
+		},
+		"wildcard relative path across separator": {
+			sources: []string{"/app*"},
+			path:    "app1/go.mod",
+			want:    true,
+		},
+		"wildcard question mark matches child": {
+			sources: []string{"/app?"},
+			path:    "/app1/go.mod",
+			want:    true,
+		},
+		"wildcard question mark no match extra chars": {
+			sources: []string{"/app?"},
+			path:    "/app12/go.mod",
+			want:    false,
+		},
+
+		// Trailing slash source
+		"trailing slash source matches child": {
+			sources: []string{"/opt/"},
+			path:    "/opt/go.mod",
+			want:    true,
+		},
+		"trailing slash source prefix collision": {
+			sources: []string{"/opt/"},
+			path:    "/optional/go.mod",
+			want:    false,
+		},
+
+		// Multiple sources
+		"multiple sources first matches": {
+			sources: []string{"/opt", "/usr"},
+			path:    "/opt/go.mod",
+			want:    true,
+		},
+		"multiple sources second matches": {
+			sources: []string{"/opt", "/usr"},
+			path:    "/usr/lib",
+			want:    true,
+		},
+		"multiple sources none match": {
+			sources: []string{"/opt", "/usr"},
+			path:    "/var/log",
+			want:    false,
+		},
+		"multiple sources with wildcard": {
+			sources: []string{"/base", "/app*"},
+			path:    "/app1/go.mod",
+			want:    true,
+		},
+
+		// Empty sources
+		"empty sources": {
+			sources: []string{},
+			path:    "/opt",
+			want:    false,
+		},
+
+		// Path sanitization (tar entry paths may have "..", ".", double slashes)
+		"dotdot in path resolved": {
+			sources: []string{"/foo/bar"},
+			path:    "/foo/bar/../bar/file.txt",
+			want:    true,
+		},
+		"double slashes in path normalized": {
+			sources: []string{"/foo/bar"},
+			path:    "/foo//bar/file.txt",
+			want:    true,
+		},
+		"dot in path normalized": {
+			sources: []string{"/foo/bar"},
+			path:    "/foo/./bar/file.txt",
+			want:    true,
+		},
+		// Root source
+		"root source matches any path": {
+			sources: []string{"/"},
+			path:    "/etc/passwd",
+			want:    true,
+		},
+		"root source wildcard matches any path": {
+			sources: []string{"/*"},
+			path:    "/etc/passwd",
+			want:    true,
+		},
+		"root source matches deeply nested path": {
+			sources: []string{"/"},
+			path:    "/opt/app/sub/go.mod",
+			want:    true,
+		},
+
+		// Special characters
+		"spaces in path": {
+			sources: []string{"/opt/my app"},
+			path:    "/opt/my app/go.mod",
+			want:    true,
+		},
+		"spaces prefix collision": {
+			sources: []string{"/opt/my"},
+			path:    "/opt/my app/go.mod",
+			want:    false,
+		},
+		"unicode in path": {
+			sources: []string{"/opt/données/*"},
+			path:    "/opt/données/模块/go.mod",
+			want:    true,
+		},
+		"glob metacharacters match literally": {
+			sources: []string{"/app/[id]"},
+			path:    "/app/[id]/page.js",
+			want:    true,
+		},
+		"glob metacharacters still match as pattern": {
+			sources: []string{"/app/[id]"},
+			path:    "/app/i/page.js",
+			want:    true,
+		},
+		"literal wildcard character": {
+			sources: []string{"/opt/*"},
+			path:    "/opt/*",
+			want:    true,
+		},
+		"malformed pattern matches literally": {
+			sources: []string{"/opt/a[b"},
+			path:    "/opt/a[b/go.mod",
+			want:    true,
+		},
+		"malformed pattern does not match other paths": {
+			sources: []string{"/opt/a[b"},
+			path:    "/opt/ab/go.mod",
+			want:    false,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got := Includes(tc.sources, tc.path)
+			if got != tc.want {
+				t.Errorf("Includes(%v, %q) = %v, want %v", tc.sources, tc.path, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIsPathUnderPattern(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		"equal paths": {
+			pattern: "/app",
+			path:    "/app",
+			want:    true,
+		},
+		"descendant": {
+			pattern: "/app",
+			path:    "/app/main.go",
+			want:    true,
+		},
+		"ancestor is not covered": {
+			pattern: "/app/main.go",
+			path:    "/app",
+			want:    false,
+		},
+		"sibling with common prefix": {
+			pattern: "/app",
+			path:    "/application",
+			want:    false,
+		},
+		"descendant of sibling with common prefix": {
+			pattern: "/app",
+			path:    "/application/main.go",
+			want:    false,
+		},
+		"pattern with trailing slash": {
+			pattern: "/app/",
+			path:    "/application",
+			want:    false,
+		},
+		"pattern with trailing slash covers descendant": {
+			pattern: "/app/",
+			path:    "/app/main.go",
+			want:    true,
+		},
+		"wildcard covers sibling with common prefix": {
+			pattern: "/app*",
+			path:    "/application/main.go",
+			want:    true,
+		},
+		"wildcard does not cross segments": {
+			pattern: "/opt/*.mod",
+			path:    "/opt/app/go.mod",
+			want:    false,
+		},
+		"wildcard matches at pattern depth only": {
+			pattern: "/opt/app*",
+			path:    "/opt/other/app1",
+			want:    false,
+		},
+		"relative pattern does not match absolute path": {
+			pattern: "app",
+			path:    "/app",
+			want:    false,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got := isPathUnderPattern(tc.pattern, tc.path)
+			if got != tc.want {
+				t.Errorf("isPathUnderPattern(%q, %q) = %v, want %v", tc.pattern, tc.path, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+// Reference-counted image mounts shared by all package sources of a scan.
+
+package capo
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"go.podman.io/storage"
+)
+
+// mountManager mounts images from a containers/storage Store at most once per
+// Scan. Package sources referencing the same image (e.g. a builder base that
+// is also copied from directly as an external image) share a single mount
+// instead of setting up the overlay again for each source.
+//
+// Mounts are reference-counted per image ID, but stay mounted after the last
+// reference is released: package sources are scanned one after the other, so
+// later sources of the image reuse the mount and its index (see index). close,
+// deferred by the scan, unmounts all images at its end.
+type mountManager struct {
+	store  storage.Store
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	mounts map[string]*imageMount
+}
+
+type imageMount struct {
+	// Mount point of the image root filesystem.
+	path string
+	// Number of currently held references.
+	refs int
+	// Total number of times the mount was handed out.
+	uses int
+	// Listing of paths in the mounted image, built on demand.
+	index []string
+	// Depth (number of path segments) covered by index.
+	indexDepth int
+}
+
+func newMountManager(store storage.Store, logger *slog.Logger) *mountManager {
+	return &mountManager{
+		store:  store,
+		logger: logger,
+		mounts: make(map[string]*imageMount),
+	}
+}
+
+// acquire returns the mount point of the image with the passed ID, mounting
+// it on first use. Every acquire must be paired with a release.
+func (m *mountManager) acquire(imageID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if mnt, ok := m.mounts[imageID]; ok {
+		mnt.refs++
+		mnt.uses++
+		m.logger.Debug("reusing image mount", "imageID", imageID, "path", mnt.path, "refs", mnt.refs)
+		return mnt.path, nil
+	}
+
+	mountPath, err := m.store.MountImage(imageID, []string{}, "")
+	if err != nil {
+		return "", fmt.Errorf("could not mount image: %w: %w", err, ErrImageMount)
+	}
+
+	m.mounts[imageID] = &imageMount{path: mountPath, refs: 1, uses: 1}
+	m.logger.Debug("mounted image", "imageID", imageID, "path", mountPath)
+	return mountPath, nil
+}
+
+// release drops a reference to the mount of the image with the passed ID.
+// The image stays mounted until close.
+func (m *mountManager) release(imageID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if mnt, ok := m.mounts[imageID]; ok && mnt.refs > 0 {
+		mnt.refs--
+	}
+}
+
+// index returns a listing of absolute paths in the mounted image with the
+// passed ID, covering paths of at least the passed depth. The listing is built
+// with a single walk of the mount and reused by later sources of the same
+// image; it is only rebuilt when a deeper listing is requested.
+// The image must be acquired by the caller. The mount is walked without
+// holding the lock, so other mounts and releases don't wait for the walk.
+func (m *mountManager) index(imageID string, depth int) ([]string, error) {
+	m.mu.Lock()
+	mnt, ok := m.mounts[imageID]
+	if !ok {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("image %s is not mounted: %w", imageID, ErrStorage)
+	}
+	if mnt.index != nil && mnt.indexDepth >= depth {
+		index := mnt.index
+		m.mu.Unlock()
+		return index, nil
+	}
+	mountPath := mnt.path
+	m.mu.Unlock()
+
+	index, err := indexTree(mountPath, depth)
+	if err != nil {
+		return nil, err
+	}
+	m.logger.Debug("indexed image content", "imageID", imageID, "depth", depth, "paths", len(index))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// another source may have indexed the image deeper in the meantime
+	if mnt.indexDepth < depth || mnt.index == nil {
+		mnt.index = index
+		mnt.indexDepth = depth
+	}
+	return index, nil
+}
+
+// close unmounts all images mounted by the manager. References still held at
+// this point are reported, as they indicate a missing release.
+func (m *mountManager) close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var errs []error
+	for imageID, mnt := range m.mounts {
+		if mnt.refs > 0 {
+			m.logger.Warn("unmounting image with references still held", "imageID", imageID, "refs", mnt.refs)
+		}
+		m.logger.Debug("unmounting image", "imageID", imageID, "uses", mnt.uses)
+
+		if _, err := m.store.UnmountImage(imageID, false); err != nil {
+			errs = append(errs, fmt.Errorf("failed to unmount image %s: %w: %w", imageID, err, ErrStorage))
+		}
+		delete(m.mounts, imageID)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,252 @@
+//go:build unit
+
+package capo
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"go.podman.io/storage"
+)
+
+// mountCountingStore implements the mount subset of storage.Store and counts
+// mount and unmount calls per image ID.
+type mountCountingStore struct {
+	storage.Store
+	// Directory containing root filesystems of images, one per image ID.
+	root     string
+	mounts   map[string]int
+	unmounts map[string]int
+	mountErr error
+}
+
+func newMountCountingStore(root string) *mountCountingStore {
+	return &mountCountingStore{
+		root:     root,
+		mounts:   make(map[string]int),
+		unmounts: make(map[string]int),
+	}
+}
+
+func (s *mountCountingStore) MountImage(id string, _ []string, _ string) (string, error) {
+	if s.mountErr != nil {
+		return "", s.mountErr
+	}
+	s.mounts[id]++
+	return filepath.Join(s.root, id), nil
+}
+
+func (s *mountCountingStore) UnmountImage(id string, _ bool) (bool, error) {
+	s.unmounts[id]++
+	return false, nil
+}
+
+func TestMountManagerReusesMounts(t *testing.T) {
+	t.Parallel()
+	store := newMountCountingStore("/mnt")
+	m := newMountManager(store, slog.Default())
+
+	// the first reference is held while the others are acquired and released
+	for range 3 {
+		path, err := m.acquire("builder")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if path != "/mnt/builder" {
+			t.Errorf("expected mount path /mnt/builder, got %q", path)
+		}
+	}
+	m.release("builder")
+	m.release("builder")
+
+	if _, err := m.acquire("external"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if store.mounts["builder"] != 1 || store.mounts["external"] != 1 {
+		t.Errorf("expected each image to be mounted once, got %v", store.mounts)
+	}
+	if len(store.unmounts) != 0 {
+		t.Errorf("expected no unmounts while references are held, got %v", store.unmounts)
+	}
+
+	// releasing the last reference keeps the mount for later sources
+	m.release("builder")
+	if len(store.unmounts) != 0 {
+		t.Errorf("expected no unmounts before close, got %v", store.unmounts)
+	}
+	if _, err := m.acquire("builder"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m.release("builder")
+	if store.mounts["builder"] != 1 {
+		t.Errorf("expected released image mount to be reused, got %d mounts", store.mounts["builder"])
+	}
+
+	// close unmounts all images, including ones with references still held
+	if err := m.close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if store.unmounts["builder"] != 1 || store.unmounts["external"] != 1 {
+		t.Errorf("expected each image to be unmounted once, got %v", store.unmounts)
+	}
+
+	// an unmounted image is mounted again on next use
+	if _, err := m.acquire("builder"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if store.mounts["builder"] != 2 {
+		t.Errorf("expected image to be remounted after close, got %d mounts", store.mounts["builder"])
+	}
+}
+
+func TestMountManagerMountError(t *testing.T) {
+	t.Parallel()
+	store := newMountCountingStore("/mnt")
+	store.mountErr = errors.New("overlay unavailable")
+	m := newMountManager(store, slog.Default())
+
+	if _, err := m.acquire("builder"); !errors.Is(err, ErrImageMount) {
+		t.Fatalf("expected error wrapping %v, got: %v", ErrImageMount, err)
+	}
+
+	if err := m.close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(store.unmounts) != 0 {
+		t.Errorf("expected no unmounts for failed mounts, got %v", store.unmounts)
+	}
+}
+
+func TestGetImageContent(t *testing.T) {
+	t.Parallel()
+	imageFiles := []string{
+		"usr/bin/helm",
+		"usr/bin/go",
+		"usr/bin/gofmt",
+		"usr/lib/go/pkg/mod/example.com/a/go.mod",
+		"usr/lib/go/pkg/mod/example.com/b/go.mod",
+		"opt/app1/go.mod",
+		"opt/app2/go.mod",
+		"opt/other/go.mod",
+		"srv/my app/go.mod",
+		"srv/données/go.mod",
+		"srv/[id]/go.mod",
+		"srv/i/go.mod",
+		"srv/a[b/go.mod",
+	}
+	tests := map[string]struct {
+		sources          []string
+		expectedIncluded []string
+		expectedFiles    []string
+	}{
+		"literal file": {
+			sources:          []string{"/usr/bin/helm"},
+			expectedIncluded: []string{"/usr/bin/helm"},
+			expectedFiles:    []string{"usr/bin/helm"},
+		},
+		"literal directory": {
+			sources:          []string{"/usr/lib/go/"},
+			expectedIncluded: []string{"/usr/lib/go"},
+			expectedFiles: []string{
+				"usr/lib/go/pkg/mod/example.com/a/go.mod",
+				"usr/lib/go/pkg/mod/example.com/b/go.mod",
+			},
+		},
+		"missing literal": {
+			sources:          []string{"/usr/bin/missing"},
+			expectedIncluded: nil,
+			expectedFiles:    nil,
+		},
+		"wildcard files": {
+			sources:          []string{"/usr/bin/go*"},
+			expectedIncluded: []string{"/usr/bin/go", "/usr/bin/gofmt"},
+			expectedFiles:    []string{"usr/bin/go", "usr/bin/gofmt"},
+		},
+		"wildcard directories": {
+			sources:          []string{"/opt/app?"},
+			expectedIncluded: []string{"/opt/app1", "/opt/app2"},
+			expectedFiles:    []string{"opt/app1/go.mod", "opt/app2/go.mod"},
+		},
+		"deep wildcard after shallow wildcard": {
+			sources:          []string{"/opt/app*", "/usr/lib/go/pkg/mod/*/b"},
+			expectedIncluded: []string{"/opt/app1", "/opt/app2", "/usr/lib/go/pkg/mod/example.com/b"},
+			expectedFiles: []string{
+				"opt/app1/go.mod",
+				"opt/app2/go.mod",
+				"usr/lib/go/pkg/mod/example.com/b/go.mod",
+			},
+		},
+		"literal with spaces": {
+			sources:          []string{"/srv/my app"},
+			expectedIncluded: []string{"/srv/my app"},
+			expectedFiles:    []string{"srv/my app/go.mod"},
+		},
+		"wildcard with unicode": {
+			sources:          []string{"/srv/don*"},
+			expectedIncluded: []string{"/srv/données"},
+			expectedFiles:    []string{"srv/données/go.mod"},
+		},
+		"glob metacharacters match literally and as pattern": {
+			sources:          []string{"/srv/[id]"},
+			expectedIncluded: []string{"/srv/i", "/srv/[id]"},
+			expectedFiles:    []string{"srv/[id]/go.mod", "srv/i/go.mod"},
+		},
+		"malformed pattern matches literally": {
+			sources:          []string{"/srv/a[b"},
+			expectedIncluded: []string{"/srv/a[b"},
+			expectedFiles:    []string{"srv/a[b/go.mod"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			root := t.TempDir()
+			for _, f := range imageFiles {
+				full := filepath.Join(root, "image", f)
+				if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(full, []byte(f), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			s := &Scanner{
+				logger: slog.Default(),
+				mounts: newMountManager(newMountCountingStore(root), slog.Default()),
+			}
+			dest := t.TempDir()
+			included, err := s.getImageContent(context.Background(), &storage.Image{ID: "image"}, tc.sources, dest)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.expectedIncluded, included); diff != "" {
+				t.Errorf("getImageContent() included mismatch (-want +got):\n%s", diff)
+			}
+
+			var files []string
+			err = filepath.WalkDir(dest, func(p string, d os.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				rel, err := filepath.Rel(dest, p)
+				files = append(files, rel)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.expectedFiles, files); diff != "" {
+				t.Errorf("extracted files mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+// Checks for scanning in offline mode, where all origins of the build have to
+// be present in local container storage.
+
+package capo
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+	"github.com/konflux-ci/capo/pkg/storageclient"
+)
+
+// ErrOriginMissing is returned in offline mode when origin images of the build
+// are not present in local container storage. The error lists all missing
+// pullspecs.
+var ErrOriginMissing = fmt.Errorf("[ERR_ORIGIN_MISSING] origin image not found in local storage: %w", ErrResolve)
+
+// checkOfflineOrigins checks that all images capo scans content of (bases of
+// builder stages, images copied from directly and with includeBase the final
+// stage base) are present in local storage. Returns ErrOriginMissing listing
+// every missing pullspec, so all of them can be fixed at once.
+func checkOfflineOrigins(storageClient storageclient.Client, cf containerfile.Containerfile, includeBase bool) error {
+	missing := make([]string, 0)
+	checked := make(map[string]bool)
+
+	check := func(pullspec string) {
+		if checked[pullspec] || storageclient.IsSpecialBase(pullspec) {
+			return
+		}
+		checked[pullspec] = true
+		if _, err := storageClient.ResolveDigest(pullspec); err != nil {
+			missing = append(missing, pullspec)
+		}
+	}
+
+	for _, stage := range cf.BuilderStages() {
+		check(stage.Base)
+	}
+	if final := cf.FinalStage(); includeBase && final != nil {
+		check(final.Base)
+	}
+	for _, stage := range cf.Stages {
+		for _, cp := range stage.Copies {
+			if cp.Type == containerfile.CopyTypeExternal {
+				check(cp.From)
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%w (offline mode): %s", ErrOriginMissing, strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+//go:build unit
+
+package capo
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/konflux-ci/capo/internal/testutils"
+	"github.com/konflux-ci/capo/pkg/containerfile"
+)
+
+func TestCheckOfflineOrigins(t *testing.T) {
+	t.Parallel()
+	cf := containerfile.Containerfile{Stages: []containerfile.Stage{
+		{
+			Alias: "builder",
+			Base:  "docker.io/library/fedora:latest",
+			Index: 0,
+		},
+		{
+			Alias: "archive",
+			Base:  "oci-archive:/tmp/base.tar",
+			Index: 1,
+		},
+		{
+			Alias: "2",
+			Base:  "registry.access.redhat.com/ubi9/ubi-minimal:latest",
+			Index: 2,
+			Kind:  containerfile.StageKindFinal,
+			Copies: []containerfile.Copy{
+				{From: "builder", Type: containerfile.CopyTypeBuilder},
+				{From: "quay.io/konflux-ci/tool:1.0", Type: containerfile.CopyTypeExternal},
+				{From: "quay.io/konflux-ci/other:2.0", Type: containerfile.CopyTypeExternal},
+			},
+		},
+	}}
+
+	tests := map[string]struct {
+		digests         map[string]digest.Digest
+		includeBase     bool
+		expectedMissing []string
+	}{
+		"all present": {
+			digests: map[string]digest.Digest{
+				"docker.io/library/fedora:latest": testDigest("a"),
+				"quay.io/konflux-ci/tool:1.0":     testDigest("b"),
+				"quay.io/konflux-ci/other:2.0":    testDigest("c"),
+			},
+		},
+		"all missing origins are listed": {
+			digests: map[string]digest.Digest{
+				"quay.io/konflux-ci/tool:1.0": testDigest("b"),
+			},
+			expectedMissing: []string{
+				"docker.io/library/fedora:latest",
+				"quay.io/konflux-ci/other:2.0",
+			},
+		},
+		"final stage base with include base": {
+			digests: map[string]digest.Digest{
+				"docker.io/library/fedora:latest": testDigest("a"),
+				"quay.io/konflux-ci/tool:1.0":     testDigest("b"),
+				"quay.io/konflux-ci/other:2.0":    testDigest("c"),
+			},
+			includeBase:     true,
+			expectedMissing: []string{"registry.access.redhat.com/ubi9/ubi-minimal:latest"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			client := testutils.NewTStorageClient(test.digests, nil)
+
+			err := checkOfflineOrigins(client, cf, test.includeBase)
+			if len(test.expectedMissing) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, ErrOriginMissing) {
+				t.Fatalf("expected error wrapping %v, got: %v", ErrOriginMissing, err)
+			}
+			for _, pullspec := range test.expectedMissing {
+				if !strings.Contains(err.Error(), pullspec) {
+					t.Errorf("expected error to list %q, got: %v", pullspec, err)
+				}
+			}
+		})
+	}
+}
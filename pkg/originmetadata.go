@@ -0,0 +1,90 @@
+// Provenance of origin images read from the labels of their configs, see
+// WithOriginMetadata.
+
+package capo
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/konflux-ci/capo/pkg/storageclient"
+)
+
+// OriginMetadata is the provenance of an origin image recorded by the labels
+// of its config.
+type OriginMetadata struct {
+	// Pullspec of the image with digest, the same as of its packages.
+	Pullspec string `json:"pullspec"`
+	// Revision of the sources the image was built from, by the vcs-ref or
+	// org.opencontainers.image.revision label.
+	VCSRef string `json:"vcs_ref,omitempty"`
+	// Version of the image, by the version or org.opencontainers.image.version
+	// label.
+	Version string `json:"version,omitempty"`
+	// Vendor of the image, by the vendor or org.opencontainers.image.vendor
+	// label.
+	Vendor string `json:"vendor,omitempty"`
+}
+
+// Keys of the labels of OriginMetadata fields, in order of precedence.
+var (
+	vcsRefLabels  = []string{"vcs-ref", "org.opencontainers.image.revision"}
+	versionLabels = []string{"version", "org.opencontainers.image.version"}
+	vendorLabels  = []string{"vendor", "org.opencontainers.image.vendor"}
+)
+
+// Configure the scanner to record the provenance of the origin images (the
+// base images of builder stages, images copied from by COPY --from and, if
+// recorded, mounted images and the final stage base image) from the labels of
+// their configs in PackageMetadata.OriginMetadata. Images without any of the
+// labels are left out. Not recorded by default.
+func WithOriginMetadata(originMetadata bool) Option {
+	return func(s *Scanner) {
+		s.originMetadata = originMetadata
+	}
+}
+
+// getOriginMetadata returns the provenance of the images with the digests
+// (by pullspec), sorted by pullspec. Returns nil if no image has any of the
+// labels.
+func getOriginMetadata(
+	storageClient storageclient.Client, digests map[string]digest.Digest,
+) ([]OriginMetadata, error) {
+	var res []OriginMetadata
+	for _, pullspec := range slices.Sorted(maps.Keys(digests)) {
+		cfg, err := storageClient.GetImageConfig(pullspec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get OCI image config for %q: %w: %w", pullspec, err, ErrOCIConfig)
+		}
+		labels := cfg.Config.Labels
+		origin := OriginMetadata{
+			VCSRef:  firstLabel(labels, vcsRefLabels),
+			Version: firstLabel(labels, versionLabels),
+			Vendor:  firstLabel(labels, vendorLabels),
+		}
+		if origin == (OriginMetadata{}) {
+			continue
+		}
+
+		origin.Pullspec, err = attachDigest(storageclient.StripTransport(pullspec), digests[pullspec])
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, origin)
+	}
+	return res, nil
+}
+
+// firstLabel returns the value of the first of the keys set in labels, or an
+// empty string if none is.
+func firstLabel(labels map[string]string, keys []string) string {
+	for _, key := range keys {
+		if value := labels[key]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
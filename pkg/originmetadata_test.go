@@ -0,0 +1,90 @@
+//go:build unit
+
+package capo
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/konflux-ci/capo/internal/testutils"
+	"github.com/konflux-ci/capo/pkg/storageclient"
+)
+
+// labeledConfig returns an image config with the labels.
+func labeledConfig(labels map[string]string) storageclient.OCIImageConfig {
+	var cfg storageclient.OCIImageConfig
+	cfg.Config.Labels = labels
+	return cfg
+}
+
+func TestGetOriginMetadata(t *testing.T) {
+	t.Parallel()
+	const (
+		ubi    = "registry.access.redhat.com/ubi9:latest"
+		fedora = "docker.io/library/fedora:latest"
+		tools  = "quay.io/konflux-ci/tools:latest"
+	)
+	digests := map[string]digest.Digest{ubi: fedoraDigest, fedora: fedoraDigest, tools: fedoraDigest}
+
+	tests := map[string]struct {
+		configs     map[string]storageclient.OCIImageConfig
+		expected    []OriginMetadata
+		expectedErr error
+	}{
+		"labels": {
+			configs: map[string]storageclient.OCIImageConfig{
+				ubi: labeledConfig(map[string]string{
+					"vcs-ref": "3f1c", "version": "9.6", "vendor": "Red Hat, Inc.", "name": "ubi9",
+				}),
+				fedora: labeledConfig(map[string]string{
+					"org.opencontainers.image.revision": "a1b2",
+					"org.opencontainers.image.version":  "42",
+					"vendor":                            "Fedora Project",
+					"org.opencontainers.image.vendor":   "Fedora",
+				}),
+				tools: labeledConfig(map[string]string{"name": "tools"}),
+			},
+			expected: []OriginMetadata{
+				{
+					Pullspec: "docker.io/library/fedora@" + fedoraDigest,
+					VCSRef:   "a1b2",
+					Version:  "42",
+					Vendor:   "Fedora Project",
+				},
+				{
+					Pullspec: "registry.access.redhat.com/ubi9@" + fedoraDigest,
+					VCSRef:   "3f1c",
+					Version:  "9.6",
+					Vendor:   "Red Hat, Inc.",
+				},
+			},
+		},
+		"no labels": {
+			configs: map[string]storageclient.OCIImageConfig{
+				ubi: {}, fedora: {}, tools: {},
+			},
+		},
+		"missing config": {
+			configs:     map[string]storageclient.OCIImageConfig{ubi: {}},
+			expectedErr: ErrOCIConfig,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			client := testutils.NewTStorageClient(digests, test.configs)
+			actual, err := getOriginMetadata(client, digests)
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error wrapping %v, got: %v", test.expectedErr, err)
+			}
+			if diff := cmp.Diff(test.expected, actual, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("getOriginMetadata() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+// Distro context for scans of partial image content.
+
+package capo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go.podman.io/storage"
+)
+
+// Paths of the os-release file syft identifies the distro of scanned content
+// from, in order of precedence.
+var osReleasePaths = []string{"etc/os-release", "usr/lib/os-release"}
+
+// Upper bound of the os-release file size, the file is read from the image
+// before any extraction limits apply.
+const maxOSReleaseBytes = 64 * 1024
+
+// copyOSRelease copies the os-release file of the image into contentPath, so
+// syft derives the distro qualifiers of package URLs (e.g.
+// pkg:rpm/redhat/bash@5.1.8-9.el9?distro=rhel-9.4) for partial content the
+// same as for a scan of the whole image. Empty content and content which
+// already has an os-release file, e.g. copied from the stage, are left as is.
+func (s *Scanner) copyOSRelease(ctx context.Context, image *storage.Image, contentPath string) error {
+	entries, err := os.ReadDir(contentPath)
+	if err != nil {
+		return fmt.Errorf("failed to read content directory %q: %w: %w", contentPath, err, ErrIO)
+	}
+	if len(entries) == 0 || hasOSRelease(contentPath) {
+		return nil
+	}
+
+	data, err := s.imageOSRelease(ctx, image)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		s.logger.Debug("image has no os-release file, scanning content without distro context", "imageID", image.ID)
+		return nil
+	}
+
+	return s.writeOSRelease(contentPath, data)
+}
+
+// imageOSRelease reads the os-release file of the image from its mount or,
+// with ExtractorLayers, from the os-release files extracted to a temporary
+// directory (see readOSRelease).
+func (s *Scanner) imageOSRelease(ctx context.Context, image *storage.Image) ([]byte, error) {
+	if s.extractorKind != ExtractorLayers {
+		mountPath, err := s.mounts.acquire(image.ID)
+		if err != nil {
+			return nil, err
+		}
+		defer s.mounts.release(image.ID)
+		return readOSRelease(mountPath)
+	}
+
+	dir, err := os.MkdirTemp("", "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w: %w", err, ErrIO)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	sources := make([]string, 0, len(osReleasePaths))
+	for _, p := range osReleasePaths {
+		sources = append(sources, "/"+p)
+	}
+	if _, err := s.newExtractor().ExtractPaths(ctx, image, sources, dir); err != nil {
+		return nil, err
+	}
+	return readOSRelease(dir)
+}
+
+// hasOSRelease reports whether the content at contentPath has an os-release
+// file (or a symbolic link in its place).
+func hasOSRelease(contentPath string) bool {
+	for _, p := range osReleasePaths {
+		if _, err := os.Lstat(filepath.Join(contentPath, p)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// readOSRelease reads the os-release file of the image mounted at mountPath.
+// Symbolic links are resolved within the mount, links leading outside of it
+// (including absolute links) are not followed. Returns nil if the image has
+// no readable os-release file.
+func readOSRelease(mountPath string) ([]byte, error) {
+	root, err := os.OpenRoot(mountPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image root %q: %w: %w", mountPath, err, ErrIO)
+	}
+	defer func() { _ = root.Close() }()
+
+	for _, p := range osReleasePaths {
+		f, err := root.Open(p)
+		if err != nil {
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(f, maxOSReleaseBytes))
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %q: %w: %w", p, err, ErrIO)
+		}
+		return data, nil
+	}
+
+	return nil, nil
+}
+
+// writeOSRelease writes the os-release file data to the content at
+// contentPath. Content with symbolic links on the way is skipped, see
+// secureJoin.
+func (s *Scanner) writeOSRelease(contentPath string, data []byte) error {
+	dest, err := secureJoin(contentPath, osReleasePaths[0])
+	if err != nil {
+		if errors.Is(err, ErrPathTraversal) {
+			s.logger.Warn("skipping os-release file outside of content root", "error", err)
+			return nil
+		}
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), s.extractMode(0o755, true)); err != nil {
+		return fmt.Errorf("failed to create directory %q: %w: %w", filepath.Dir(dest), err, ErrIO)
+	}
+	if err := os.WriteFile(dest, data, s.extractMode(0o644, false)); err != nil {
+		return fmt.Errorf("failed to write os-release file %q: %w: %w", dest, err, ErrIO)
+	}
+	return nil
+}
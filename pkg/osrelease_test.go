@@ -0,0 +1,117 @@
+//go:build unit
+
+package capo
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const ubiOSRelease = "NAME=\"Red Hat Enterprise Linux\"\nID=\"rhel\"\nVERSION_ID=\"9.4\"\n"
+
+func TestReadOSRelease(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		setup    func(t *testing.T, root string)
+		expected string
+	}{
+		"etc file": {
+			setup: func(t *testing.T, root string) {
+				writeTestFile(t, filepath.Join(root, "etc/os-release"), ubiOSRelease)
+				writeTestFile(t, filepath.Join(root, "usr/lib/os-release"), "ID=other\n")
+			},
+			expected: ubiOSRelease,
+		},
+		"relative link": {
+			setup: func(t *testing.T, root string) {
+				writeTestFile(t, filepath.Join(root, "usr/lib/os-release"), ubiOSRelease)
+				symlinkTestFile(t, "../usr/lib/os-release", filepath.Join(root, "etc/os-release"))
+			},
+			expected: ubiOSRelease,
+		},
+		"absolute link is not followed": {
+			setup: func(t *testing.T, root string) {
+				writeTestFile(t, filepath.Join(root, "usr/lib/os-release"), ubiOSRelease)
+				symlinkTestFile(t, "/etc/os-release", filepath.Join(root, "etc/os-release"))
+			},
+			expected: ubiOSRelease,
+		},
+		"missing": {
+			setup: func(t *testing.T, root string) {
+				writeTestFile(t, filepath.Join(root, "etc/hostname"), "host\n")
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			root := t.TempDir()
+			tc.setup(t, root)
+
+			data, err := readOSRelease(root)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(data) != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, string(data))
+			}
+		})
+	}
+}
+
+func TestWriteOSRelease(t *testing.T) {
+	t.Parallel()
+	s := &Scanner{logger: slog.Default()}
+
+	content := t.TempDir()
+	if hasOSRelease(content) {
+		t.Fatal("expected no os-release file in empty content")
+	}
+	if err := s.writeOSRelease(content, []byte(ubiOSRelease)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(content, "etc/os-release"))
+	if err != nil {
+		t.Fatalf("failed to read written os-release file: %v", err)
+	}
+	if string(data) != ubiOSRelease {
+		t.Errorf("expected %q, got %q", ubiOSRelease, string(data))
+	}
+	if !hasOSRelease(content) {
+		t.Error("expected os-release file in content")
+	}
+
+	// content with a link in place of etc can't be written through
+	linked := t.TempDir()
+	outside := t.TempDir()
+	symlinkTestFile(t, outside, filepath.Join(linked, "etc"))
+	if err := s.writeOSRelease(linked, []byte(ubiOSRelease)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "os-release")); err == nil {
+		t.Error("expected no os-release file written outside of content")
+	}
+}
+
+func writeTestFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func symlinkTestFile(t *testing.T, target string, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, path); err != nil {
+		t.Fatal(err)
+	}
+}
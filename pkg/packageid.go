@@ -0,0 +1,35 @@
+// Stable identifiers of packages in the scan output.
+
+package capo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+)
+
+// packageIDLen is the number of hex characters of a package ID, half of a
+// SHA-256 hash, which is plenty to avoid collisions among the packages of
+// builds.
+const packageIDLen = 32
+
+// withID returns the item with its ID set to a hash of its purl, pullspec,
+// origin (origin type and stage alias) and locations, the paths of the files
+// the package was found in. It only depends on what was found where, so the
+// same package instance gets the same ID in every scan of an unchanged
+// origin, and duplicates found through different copies share it.
+func withID(item PackageMetadataItem, locations []string) PackageMetadataItem {
+	locations = slices.Compact(slices.Sorted(slices.Values(locations)))
+
+	h := sha256.New()
+	for _, field := range slices.Concat(
+		[]string{item.PackageURL, item.Pullspec, item.OriginType, item.StageAlias},
+		locations,
+	) {
+		// fields can't contain NUL, so the encoding is unambiguous
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	item.ID = hex.EncodeToString(h.Sum(nil))[:packageIDLen]
+	return item
+}
@@ -0,0 +1,94 @@
+//go:build unit
+
+package capo
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestWithID(t *testing.T) {
+	t.Parallel()
+	item := PackageMetadataItem{
+		PackageURL: "pkg:golang/example.com/app@v1.0.0",
+		Pullspec:   "docker.io/library/golang@sha256:abc123",
+		OriginType: "builder",
+		StageAlias: "builder",
+	}
+	locations := []string{"/app/go.mod", "/app/bin/app"}
+	id := withID(item, locations).ID
+
+	if !regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(id) {
+		t.Fatalf("unexpected ID format %q", id)
+	}
+
+	same := map[string]struct {
+		item      PackageMetadataItem
+		locations []string
+	}{
+		"same input": {item: item, locations: locations},
+		"locations in another order": {
+			item: item, locations: []string{"/app/bin/app", "/app/go.mod"},
+		},
+		"duplicate locations": {
+			item: item, locations: []string{"/app/go.mod", "/app/bin/app", "/app/go.mod"},
+		},
+		"fields not identifying the instance": {
+			item: PackageMetadataItem{
+				PackageURL: item.PackageURL, Pullspec: item.Pullspec, OriginType: item.OriginType,
+				StageAlias: item.StageAlias, Checksums: []string{"sha256:def456"}, BuildTime: true,
+			},
+			locations: locations,
+		},
+	}
+	for name, test := range same {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if actual := withID(test.item, test.locations).ID; actual != id {
+				t.Errorf("expected ID %q, got %q", id, actual)
+			}
+		})
+	}
+
+	changed := func(f func(*PackageMetadataItem)) PackageMetadataItem {
+		res := item
+		f(&res)
+		return res
+	}
+	different := map[string]struct {
+		item      PackageMetadataItem
+		locations []string
+	}{
+		"purl": {
+			item:      changed(func(i *PackageMetadataItem) { i.PackageURL = "pkg:golang/example.com/app@v1.0.1" }),
+			locations: locations,
+		},
+		"pullspec": {
+			item:      changed(func(i *PackageMetadataItem) { i.Pullspec = "docker.io/library/golang@sha256:fed987" }),
+			locations: locations,
+		},
+		"origin type": {
+			item:      changed(func(i *PackageMetadataItem) { i.OriginType = "intermediate" }),
+			locations: locations,
+		},
+		"stage alias": {
+			item:      changed(func(i *PackageMetadataItem) { i.StageAlias = "other" }),
+			locations: locations,
+		},
+		"locations": {item: item, locations: []string{"/app/go.mod"}},
+		"fields shifted": {
+			item: changed(func(i *PackageMetadataItem) {
+				i.StageAlias = ""
+			}),
+			locations: append([]string{item.StageAlias}, locations...),
+		},
+	}
+	for name, test := range different {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if actual := withID(test.item, test.locations).ID; actual == id {
+				t.Errorf("expected an ID other than %q", id)
+			}
+		})
+	}
+}
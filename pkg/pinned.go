@@ -0,0 +1,108 @@
+// Enforcement of images referenced by digest rather than by a mutable tag, see
+// WithRequirePinned.
+
+package capo
+
+import (
+	"fmt"
+	"strings"
+
+	"go.podman.io/image/v5/docker/reference"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+	"github.com/konflux-ci/capo/pkg/storageclient"
+)
+
+// ErrUnpinnedImage is returned with PinPolicyFail when the containerfile
+// references images by a tag. The error lists all such instructions.
+var ErrUnpinnedImage = fmt.Errorf("[ERR_UNPINNED_IMAGE] image not pinned by digest: %w", ErrResolve)
+
+// PinPolicy is the handling of images referenced by a tag rather than a
+// digest, see WithRequirePinned.
+type PinPolicy string
+
+const (
+	// PinPolicyFail fails the scan with ErrUnpinnedImage.
+	PinPolicyFail PinPolicy = "fail"
+	// PinPolicyWarn reports every unpinned image as a WarnUnpinnedImage
+	// warning.
+	PinPolicyWarn PinPolicy = "warn"
+)
+
+var ErrInvalidPinPolicy = fmt.Errorf("[ERR_INVALID_PIN_POLICY] invalid pin policy, expected fail or warn: %w", ErrParse)
+
+// ParsePinPolicy returns the pin policy with the passed name.
+func ParsePinPolicy(s string) (PinPolicy, error) {
+	switch policy := PinPolicy(s); policy {
+	case PinPolicyFail, PinPolicyWarn:
+		return policy, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrInvalidPinPolicy, s)
+}
+
+// Configure the scanner to require the images referenced by FROM, COPY
+// --from and RUN --mount=from to be pinned by digest (e.g.
+// fedora@sha256:...), as a tag may point to another image by the next build.
+// Unpinned images are checked before anything is scanned and handled by the
+// policy. Special bases (e.g. scratch) are exempt. Not required by default.
+func WithRequirePinned(policy PinPolicy) Option {
+	return func(s *Scanner) {
+		s.requirePinned = policy
+	}
+}
+
+// checkPinned handles the instructions of the containerfile referencing
+// unpinned images by the policy of the scanner (see WithRequirePinned).
+func (s *Scanner) checkPinned(cf containerfile.Containerfile) error {
+	if s.requirePinned == "" {
+		return nil
+	}
+	unpinned := unpinnedImages(cf)
+	if len(unpinned) == 0 {
+		return nil
+	}
+
+	if s.requirePinned == PinPolicyWarn {
+		for _, instruction := range unpinned {
+			s.warn(WarnUnpinnedImage, instruction+" references an image not pinned by digest")
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrUnpinnedImage, strings.Join(unpinned, ", "))
+}
+
+// unpinnedImages returns the instructions of the containerfile referencing an
+// image by a tag, in order, e.g. "FROM docker.io/library/fedora:42 (stage 0)".
+func unpinnedImages(cf containerfile.Containerfile) []string {
+	var res []string
+	for _, stage := range cf.Stages {
+		if cf.ResolveRef(stage.BaseRef, stage.Index) == nil && !isPinned(stage.BaseRef) {
+			res = append(res, fmt.Sprintf("FROM %s (stage %d)", stage.BaseRef, stage.Index))
+		}
+		for _, cp := range stage.Copies {
+			if cp.Type == containerfile.CopyTypeExternal && !isPinned(cp.From) {
+				res = append(res, fmt.Sprintf("COPY --from=%s (line %d)", cp.From, cp.Line))
+			}
+		}
+		for _, mount := range stage.Mounts {
+			if mount.Pullspec != "" && !isPinned(mount.Pullspec) {
+				res = append(res, fmt.Sprintf("RUN --mount=from=%s (stage %d)", mount.Pullspec, stage.Index))
+			}
+		}
+	}
+	return res
+}
+
+// isPinned reports whether the pullspec references an image by digest.
+// Special bases aren't images of a registry and count as pinned.
+func isPinned(pullspec string) bool {
+	if storageclient.IsSpecialBase(pullspec) {
+		return true
+	}
+	named, err := reference.ParseNormalizedNamed(pullspec)
+	if err != nil {
+		return false
+	}
+	_, digested := named.(reference.Digested)
+	return digested
+}
@@ -0,0 +1,185 @@
+//go:build unit
+
+package capo
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+)
+
+const (
+	fedoraDigest = "sha256:4e007f288dce23966216be81ef12ba4d4dbd9bd3c1b3ee1ed4c40e5c4c2f4b02"
+	pinnedFedora = "docker.io/library/fedora@" + fedoraDigest
+)
+
+// Containerfile referencing images by a tag in every kind of instruction:
+//
+//	FROM docker.io/library/golang:1.22 AS builder
+//	RUN --mount=from=docker.io/library/alpine:3.20,src=/etc/apk,dst=/mnt/apk make
+//	FROM builder AS test
+//	FROM scratch
+//	COPY --from=builder /out /out
+//	COPY --from=quay.io/konflux-ci/tools:latest /bin/tool /bin/tool
+var unpinnedContainerfile = containerfile.Containerfile{Stages: []containerfile.Stage{
+	{
+		Alias:   "builder",
+		Base:    "docker.io/library/golang:1.22",
+		BaseRef: "docker.io/library/golang:1.22",
+		Mounts:  []containerfile.Mount{{From: "docker.io/library/alpine:3.20", Pullspec: "docker.io/library/alpine:3.20"}},
+	},
+	{Alias: "test", Base: "builder", BaseRef: "builder", Index: 1},
+	{
+		Alias:   "2",
+		Base:    "scratch",
+		BaseRef: "scratch",
+		Index:   2,
+		Kind:    containerfile.StageKindFinal,
+		Copies: []containerfile.Copy{
+			{From: "builder", Sources: []string{"/out"}, Destination: "/out", Type: containerfile.CopyTypeBuilder, Line: 5},
+			{
+				From: "quay.io/konflux-ci/tools:latest", Sources: []string{"/bin/tool"}, Destination: "/bin/tool",
+				Type: containerfile.CopyTypeExternal, Line: 6,
+			},
+		},
+	},
+}}
+
+func TestIsPinned(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		pullspec string
+		expected bool
+	}{
+		"tag":            {pullspec: "docker.io/library/fedora:42"},
+		"implicit tag":   {pullspec: "fedora"},
+		"digest":         {pullspec: pinnedFedora, expected: true},
+		"tag and digest": {pullspec: "docker.io/library/fedora:42@" + fedoraDigest, expected: true},
+		"scratch":        {pullspec: "scratch", expected: true},
+		"oci archive":    {pullspec: "oci-archive:/tmp/base.tar", expected: true},
+		"invalid":        {pullspec: "Fedora:42"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if actual := isPinned(test.pullspec); actual != test.expected {
+				t.Errorf("isPinned(%q) = %v, want %v", test.pullspec, actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestUnpinnedImages(t *testing.T) {
+	t.Parallel()
+	pinned := containerfile.Containerfile{Stages: []containerfile.Stage{
+		{Alias: "builder", Base: pinnedFedora, BaseRef: pinnedFedora},
+		{
+			Alias: "1", Base: "scratch", BaseRef: "scratch", Index: 1, Kind: containerfile.StageKindFinal,
+			Copies: []containerfile.Copy{
+				{From: pinnedFedora, Sources: []string{"/a"}, Destination: "/a", Type: containerfile.CopyTypeExternal},
+			},
+		},
+	}}
+
+	tests := map[string]struct {
+		cf       containerfile.Containerfile
+		expected []string
+	}{
+		"unpinned": {
+			cf: unpinnedContainerfile,
+			expected: []string{
+				"FROM docker.io/library/golang:1.22 (stage 0)",
+				"RUN --mount=from=docker.io/library/alpine:3.20 (stage 0)",
+				"COPY --from=quay.io/konflux-ci/tools:latest (line 6)",
+			},
+		},
+		"pinned": {cf: pinned},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			actual := unpinnedImages(test.cf)
+			if diff := cmp.Diff(test.expected, actual, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("unpinnedImages() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCheckPinned(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		policy           PinPolicy
+		expectedErr      error
+		expectedWarnings []Warning
+	}{
+		"not required": {},
+		"fail":         {policy: PinPolicyFail, expectedErr: ErrUnpinnedImage},
+		"warn": {
+			policy: PinPolicyWarn,
+			expectedWarnings: []Warning{
+				{
+					Code:    WarnUnpinnedImage,
+					Message: "FROM docker.io/library/golang:1.22 (stage 0) references an image not pinned by digest",
+				},
+				{
+					Code:    WarnUnpinnedImage,
+					Message: "RUN --mount=from=docker.io/library/alpine:3.20 (stage 0) references an image not pinned by digest",
+				},
+				{
+					Code:    WarnUnpinnedImage,
+					Message: "COPY --from=quay.io/konflux-ci/tools:latest (line 6) references an image not pinned by digest",
+				},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			s := &Scanner{logger: slog.New(slog.NewTextHandler(io.Discard, nil)), requirePinned: test.policy}
+			err := s.checkPinned(unpinnedContainerfile)
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error wrapping %v, got: %v", test.expectedErr, err)
+			}
+			if diff := cmp.Diff(test.expectedWarnings, s.warnings, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("checkPinned() warnings mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParsePinPolicy(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		value       string
+		expected    PinPolicy
+		expectedErr error
+	}{
+		"fail":    {value: "fail", expected: PinPolicyFail},
+		"warn":    {value: "warn", expected: PinPolicyWarn},
+		"unknown": {value: "ignore", expectedErr: ErrInvalidPinPolicy},
+		"empty":   {value: "", expectedErr: ErrInvalidPinPolicy},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			actual, err := ParsePinPolicy(test.value)
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error wrapping %v, got: %v", test.expectedErr, err)
+			}
+			if actual != test.expected {
+				t.Errorf("ParsePinPolicy(%q) = %q, want %q", test.value, actual, test.expected)
+			}
+		})
+	}
+}
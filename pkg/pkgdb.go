@@ -0,0 +1,79 @@
+// Lookup of packages owning extracted content in installed package databases.
+
+package capo
+
+import (
+	"context"
+
+	"github.com/konflux-ci/capo/internal/sbom"
+	"go.podman.io/storage"
+)
+
+// foundByPackageDBLookup marks packages which own extracted content according
+// to the package database of its origin image, see lookupOwnedPackages.
+const foundByPackageDBLookup = "package-db-lookup"
+
+// Paths of installed package databases of rpm, apk and dpkg (including
+// distroless status.d).
+var packageDBSources = []string{
+	"/var/lib/rpm",
+	"/usr/lib/sysimage/rpm",
+	"/lib/apk/db/installed",
+	"/var/lib/dpkg/status",
+	"/var/lib/dpkg/status.d",
+	"/var/lib/dpkg/info",
+}
+
+// Syft catalogers of installed package databases, used for scanning content
+// saved by getPackageDBContent.
+var packageDBCatalogers = []string{"rpm-db-cataloger", "apk-db-cataloger", "dpkg-db-cataloger"}
+
+// getPackageDBContent saves the installed package databases of the image and
+// its os-release file to path, for finding the packages owning content
+// extracted from the image. Copied content often leaves the database behind
+// (e.g. COPY --from=builder /usr/bin/foo), syft then finds the files only with
+// binary classifiers, if at all.
+func (s *Scanner) getPackageDBContent(ctx context.Context, image *storage.Image, path string) error {
+	content, err := s.getImageContent(ctx, image, packageDBSources, path)
+	if err != nil {
+		return err
+	}
+	s.logContent("package database", content, image.ID)
+	if len(content) == 0 {
+		return nil
+	}
+
+	return s.copyOSRelease(ctx, image, path)
+}
+
+// lookupOwnedPackages returns the packages found in package databases (dbPkgs)
+// owning any of the extracted content paths (files or whole directories),
+// except for packages with a PURL already among the found packages.
+func lookupOwnedPackages(dbPkgs []sbom.SyftPackage, content []string, found []sbom.SyftPackage) []sbom.SyftPackage {
+	known := make(map[string]bool, len(found))
+	for _, pkg := range found {
+		known[pkg.PURL] = true
+	}
+
+	owned := make([]sbom.SyftPackage, 0)
+	for _, pkg := range dbPkgs {
+		if known[pkg.PURL] || !ownsContent(pkg, content) {
+			continue
+		}
+		known[pkg.PURL] = true
+		owned = append(owned, pkg)
+	}
+
+	return owned
+}
+
+// ownsContent reports whether any file owned by the package is one of the
+// content paths or under one of them.
+func ownsContent(pkg sbom.SyftPackage, content []string) bool {
+	for _, file := range pkg.Files {
+		if Includes(content, file) {
+			return true
+		}
+	}
+	return false
+}
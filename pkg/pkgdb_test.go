@@ -0,0 +1,62 @@
+//go:build unit
+
+package capo
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/konflux-ci/capo/internal/sbom"
+)
+
+func TestLookupOwnedPackages(t *testing.T) {
+	t.Parallel()
+	bash := sbom.SyftPackage{
+		PURL:  "pkg:rpm/redhat/bash@5.1.8-9.el9?arch=x86_64&distro=rhel-9.4",
+		Files: []string{"/usr/bin/bash", "/usr/share/doc/bash/README"},
+	}
+	curl := sbom.SyftPackage{
+		PURL:  "pkg:rpm/redhat/curl@7.76.1-29.el9?arch=x86_64&distro=rhel-9.4",
+		Files: []string{"/usr/bin/curl"},
+	}
+	filesystem := sbom.SyftPackage{
+		PURL:  "pkg:rpm/redhat/filesystem@3.16-2.el9?arch=x86_64&distro=rhel-9.4",
+		Files: []string{"/usr", "/usr/bin"},
+	}
+	dbPkgs := []sbom.SyftPackage{bash, curl, filesystem}
+
+	tests := map[string]struct {
+		content  []string
+		found    []sbom.SyftPackage
+		expected []sbom.SyftPackage
+	}{
+		"copied file": {
+			content:  []string{"/usr/bin/curl"},
+			expected: []sbom.SyftPackage{curl},
+		},
+		"copied directory": {
+			content:  []string{"/usr/share/doc"},
+			expected: []sbom.SyftPackage{bash},
+		},
+		"already found packages are skipped": {
+			content:  []string{"/usr/bin/curl", "/usr/bin/bash"},
+			found:    []sbom.SyftPackage{{PURL: curl.PURL}},
+			expected: []sbom.SyftPackage{bash},
+		},
+		"unowned content": {
+			content:  []string{"/opt/app/bin/app"},
+			expected: []sbom.SyftPackage{},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			actual := lookupOwnedPackages(dbPkgs, tc.content, tc.found)
+			if diff := cmp.Diff(tc.expected, actual); diff != "" {
+				t.Errorf("lookupOwnedPackages() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+// Policy rules evaluated on the scan output, see ReadPolicy.
+
+package capo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+var ErrPolicy = fmt.Errorf("[ERR_POLICY] invalid policy: %w", ErrParse)
+var ErrPolicyRule = fmt.Errorf("[ERR_POLICY_RULE] invalid policy rule: %w", ErrParse)
+
+// Policy is a set of rules denying packages of the scan output, e.g.:
+//
+//	rules:
+//	  - name: no-builder-gpl-tools
+//	    message: GPL-3.0 tools of builder images must not be copied
+//	    match:
+//	      origin_types: [builder, intermediate]
+//	      purls: ["pkg:rpm/*/gcc@*", "pkg:rpm/*/make@*"]
+//	  - name: no-gpl-3
+//	    match:
+//	      licenses: ["GPL-3.0*"]
+type Policy struct {
+	Rules []PolicyRule `yaml:"rules"`
+}
+
+// PolicyRule denies the packages it matches.
+type PolicyRule struct {
+	// Name of the rule, reported with its violations.
+	Name string `yaml:"name"`
+	// Message reported with violations of the rule, optional.
+	Message string `yaml:"message"`
+	// Packages violating the rule.
+	Match PolicyMatch `yaml:"match"`
+}
+
+// PolicyMatch matches packages by their fields. A package matches if it
+// matches every set field; a field set to a list matches if any of its
+// values matches. Patterns match the whole value, with "*" matching any
+// characters (including "/").
+type PolicyMatch struct {
+	// Origin types of the package (e.g. "builder").
+	OriginTypes []string `yaml:"origin_types"`
+	// Patterns of the package URL (e.g. "pkg:rpm/*/gcc@*").
+	PURLs []string `yaml:"purls"`
+	// Patterns of the pullspec of the origin (e.g. "docker.io/*").
+	Pullspecs []string `yaml:"pullspecs"`
+	// Whether the package is only in content mounted by the build.
+	BuildTime *bool `yaml:"build_time"`
+	// Severities of known vulnerabilities of the package (e.g. "Critical"),
+	// case-insensitive. Vulnerabilities are only known with a vulnerability
+	// scan hook (see GrypeHook).
+	VulnerabilitySeverities []string `yaml:"vulnerability_severities"`
+	// Patterns of the licenses of the package (e.g. "GPL-3.0*"),
+	// case-insensitive. They match a license as found by syft or any of the
+	// license identifiers of its SPDX expression, so "GPL-3.0*" matches
+	// "MIT OR GPL-3.0-or-later".
+	Licenses []string `yaml:"licenses"`
+
+	purls     []*regexp.Regexp
+	pullspecs []*regexp.Regexp
+	licenses  []*regexp.Regexp
+}
+
+// Violation is a package denied by a policy rule.
+type Violation struct {
+	Rule       string `json:"rule"`
+	Message    string `json:"message,omitempty"`
+	PackageURL string `json:"purl"`
+	OriginType string `json:"origin_type"`
+	Pullspec   string `json:"pullspec"`
+}
+
+// ReadPolicy reads the policy in the YAML file at path. Unknown keys, rules
+// without a name and rules without a match are an error, so a typo doesn't
+// silently deny nothing or everything.
+func ReadPolicy(path string) (Policy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Policy{}, err
+	}
+	defer f.Close()
+
+	var policy Policy
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&policy); err != nil && !errors.Is(err, io.EOF) {
+		return Policy{}, fmt.Errorf("%w: %s: %w", ErrPolicy, path, err)
+	}
+
+	for i := range policy.Rules {
+		if err := policy.Rules[i].compile(); err != nil {
+			return Policy{}, fmt.Errorf("%w: %s: rule %d: %w", ErrPolicy, path, i, err)
+		}
+	}
+	return policy, nil
+}
+
+// compile validates the rule and compiles its patterns.
+func (r *PolicyRule) compile() error {
+	if r.Name == "" {
+		return fmt.Errorf("%w: missing name", ErrPolicyRule)
+	}
+	m := &r.Match
+	if len(m.OriginTypes) == 0 && len(m.PURLs) == 0 && len(m.Pullspecs) == 0 &&
+		m.BuildTime == nil && len(m.VulnerabilitySeverities) == 0 && len(m.Licenses) == 0 {
+		return fmt.Errorf("%w: %q has an empty match", ErrPolicyRule, r.Name)
+	}
+	m.purls = compilePatterns(m.PURLs)
+	m.pullspecs = compilePatterns(m.Pullspecs)
+	licenses := make([]string, 0, len(m.Licenses))
+	for _, l := range m.Licenses {
+		licenses = append(licenses, strings.ToLower(l))
+	}
+	m.licenses = compilePatterns(licenses)
+	return nil
+}
+
+// compilePatterns returns the regular expressions of the patterns.
+func compilePatterns(patterns []string) []*regexp.Regexp {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		parts := strings.Split(p, "*")
+		for i := range parts {
+			parts[i] = regexp.QuoteMeta(parts[i])
+		}
+		res = append(res, regexp.MustCompile("^"+strings.Join(parts, ".*")+"$"))
+	}
+	return res
+}
+
+// Evaluate returns the violations of the policy by the packages of the scan
+// output, by package and then by rule.
+func (p Policy) Evaluate(m PackageMetadata) []Violation {
+	violations := make([]Violation, 0)
+	for _, item := range m.Packages {
+		for _, rule := range p.Rules {
+			if !rule.Match.matches(item) {
+				continue
+			}
+			violations = append(violations, Violation{
+				Rule:       rule.Name,
+				Message:    rule.Message,
+				PackageURL: item.PackageURL,
+				OriginType: item.OriginType,
+				Pullspec:   item.Pullspec,
+			})
+		}
+	}
+	return violations
+}
+
+// matches returns whether the package matches every set field.
+func (m PolicyMatch) matches(item PackageMetadataItem) bool {
+	if len(m.OriginTypes) > 0 && !slices.Contains(m.OriginTypes, item.OriginType) {
+		return false
+	}
+	if len(m.purls) > 0 && !matchesAny(m.purls, item.PackageURL) {
+		return false
+	}
+	if len(m.pullspecs) > 0 && !matchesAny(m.pullspecs, item.Pullspec) {
+		return false
+	}
+	if m.BuildTime != nil && *m.BuildTime != item.BuildTime {
+		return false
+	}
+	if len(m.VulnerabilitySeverities) > 0 && !slices.ContainsFunc(item.Vulnerabilities, func(v Vulnerability) bool {
+		return slices.ContainsFunc(m.VulnerabilitySeverities, func(s string) bool {
+			return strings.EqualFold(s, v.Severity)
+		})
+	}) {
+		return false
+	}
+	if len(m.licenses) > 0 && !slices.ContainsFunc(item.Licenses, func(l string) bool {
+		return slices.ContainsFunc(licenseIDs(l), func(id string) bool { return matchesAny(m.licenses, id) })
+	}) {
+		return false
+	}
+	return true
+}
+
+// licenseIDs returns the lowercased license and, if it's an SPDX expression,
+// the license identifiers in it.
+func licenseIDs(license string) []string {
+	license = strings.ToLower(license)
+	ids := []string{license}
+	for _, f := range strings.Fields(strings.NewReplacer("(", " ", ")", " ").Replace(license)) {
+		switch f {
+		case "and", "or", "with":
+		default:
+			if f != license {
+				ids = append(ids, f)
+			}
+		}
+	}
+	return ids
+}
+
+// matchesAny returns whether any of the regular expressions matches s.
+func matchesAny(res []*regexp.Regexp, s string) bool {
+	return slices.ContainsFunc(res, func(re *regexp.Regexp) bool { return re.MatchString(s) })
+}
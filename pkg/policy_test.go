@@ -0,0 +1,176 @@
+//go:build unit
+
+package capo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func writePolicy(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write policy: %v", err)
+	}
+	return path
+}
+
+func TestReadPolicy(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		content     string
+		expectedErr error
+	}{
+		"valid": {
+			content: "rules:\n  - name: no-gcc\n    match:\n      purls: [\"pkg:rpm/*/gcc@*\"]\n",
+		},
+		"empty file":   {content: ""},
+		"unknown key":  {content: "rules:\n  - name: no-gcc\n    match:\n      purl: gcc\n", expectedErr: ErrPolicy},
+		"missing name": {content: "rules:\n  - match:\n      origin_types: [builder]\n", expectedErr: ErrPolicy},
+		"empty match":  {content: "rules:\n  - name: everything\n", expectedErr: ErrPolicy},
+		"licenses":     {content: "rules:\n  - name: no-gpl\n    match:\n      licenses: [\"GPL-3.0*\"]\n"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			_, err := ReadPolicy(writePolicy(t, test.content))
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error wrapping %v, got: %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestPolicyEvaluate(t *testing.T) {
+	t.Parallel()
+	gcc := PackageMetadataItem{
+		PackageURL: "pkg:rpm/rhel/gcc@11.4.1-3.el9",
+		OriginType: "builder",
+		Pullspec:   "registry.access.redhat.com/ubi9/ubi@sha256:abc123",
+	}
+	makeItem := PackageMetadataItem{
+		PackageURL: "pkg:rpm/rhel/make@4.3-8.el9",
+		OriginType: "builder",
+		Pullspec:   "registry.access.redhat.com/ubi9/ubi@sha256:abc123",
+		BuildTime:  true,
+	}
+	glibc := PackageMetadataItem{
+		PackageURL:      "pkg:rpm/rhel/glibc@2.34-83.el9",
+		OriginType:      "intermediate",
+		Pullspec:        "registry.access.redhat.com/ubi9/ubi@sha256:abc123",
+		Vulnerabilities: []Vulnerability{{ID: "CVE-2024-2961", Severity: "High"}},
+	}
+	requests := PackageMetadataItem{
+		PackageURL: "pkg:pypi/requests@2.32.3",
+		OriginType: "external",
+		Pullspec:   "docker.io/library/python@sha256:def456",
+	}
+	m := PackageMetadata{Packages: []PackageMetadataItem{gcc, makeItem, glibc, requests}}
+
+	policy, err := ReadPolicy(writePolicy(t, `rules:
+  - name: no-builder-gpl-tools
+    message: GPL-3.0 tools must not be copied
+    match:
+      origin_types: [builder, intermediate]
+      purls: ["pkg:rpm/*/gcc@*", "pkg:rpm/*/make@*"]
+      build_time: false
+  - name: no-docker-hub
+    match:
+      pullspecs: ["docker.io/*"]
+  - name: no-high-vulnerabilities
+    match:
+      vulnerability_severities: [critical, high]
+`))
+	if err != nil {
+		t.Fatalf("ReadPolicy returned error: %v", err)
+	}
+
+	expected := []Violation{
+		{
+			Rule:       "no-builder-gpl-tools",
+			Message:    "GPL-3.0 tools must not be copied",
+			PackageURL: gcc.PackageURL,
+			OriginType: gcc.OriginType,
+			Pullspec:   gcc.Pullspec,
+		},
+		{
+			Rule:       "no-high-vulnerabilities",
+			PackageURL: glibc.PackageURL,
+			OriginType: glibc.OriginType,
+			Pullspec:   glibc.Pullspec,
+		},
+		{
+			Rule:       "no-docker-hub",
+			PackageURL: requests.PackageURL,
+			OriginType: requests.OriginType,
+			Pullspec:   requests.Pullspec,
+		},
+	}
+	if diff := cmp.Diff(expected, policy.Evaluate(m)); diff != "" {
+		t.Errorf("Evaluate() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPolicyEvaluateLicenses(t *testing.T) {
+	t.Parallel()
+	bash := PackageMetadataItem{
+		PackageURL: "pkg:rpm/rhel/bash@5.1.8-9.el9",
+		OriginType: "builder",
+		Licenses:   []string{"GPL-3.0-or-later"},
+	}
+	coreutils := PackageMetadataItem{
+		PackageURL: "pkg:rpm/rhel/coreutils@8.32-35.el9",
+		OriginType: "builder",
+		Licenses:   []string{"(GPL-2.0-only AND LGPL-2.1-or-later) OR gpl-3.0-only"},
+	}
+	zlib := PackageMetadataItem{
+		PackageURL: "pkg:rpm/rhel/zlib@1.2.11-40.el9",
+		OriginType: "builder",
+		Licenses:   []string{"Zlib"},
+	}
+	readline := PackageMetadataItem{
+		PackageURL: "pkg:rpm/rhel/readline@8.1-4.el9",
+		OriginType: originTypeBase,
+		Licenses:   []string{"GPL-3.0-or-later"},
+	}
+	unknown := PackageMetadataItem{
+		PackageURL: "pkg:golang/example.com/tool@v1.0.0",
+		OriginType: "builder",
+	}
+	m := PackageMetadata{Packages: []PackageMetadataItem{bash, coreutils, zlib, readline, unknown}}
+
+	policy, err := ReadPolicy(writePolicy(t, `rules:
+  - name: no-builder-gpl-3
+    message: builder GPL-3.0 packages must not be copied into the final image
+    match:
+      origin_types: [builder]
+      licenses: ["GPL-3.0*"]
+`))
+	if err != nil {
+		t.Fatalf("ReadPolicy returned error: %v", err)
+	}
+
+	expected := []Violation{
+		{
+			Rule:       "no-builder-gpl-3",
+			Message:    "builder GPL-3.0 packages must not be copied into the final image",
+			PackageURL: bash.PackageURL,
+			OriginType: bash.OriginType,
+		},
+		{
+			Rule:       "no-builder-gpl-3",
+			Message:    "builder GPL-3.0 packages must not be copied into the final image",
+			PackageURL: coreutils.PackageURL,
+			OriginType: coreutils.OriginType,
+		},
+	}
+	if diff := cmp.Diff(expected, policy.Evaluate(m)); diff != "" {
+		t.Errorf("Evaluate() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,230 @@
+// Scanning of an image built before capo was enabled, by the destinations of
+// the copies of its containerfile.
+
+package capo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.podman.io/storage"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+	"github.com/konflux-ci/capo/pkg/storageclient"
+)
+
+// ErrPostScanDigest is returned by PostScan for an image not pinned by digest,
+// whose content may not be of the build of the containerfile.
+var ErrPostScanDigest = fmt.Errorf("[ERR_POST_SCAN_DIGEST] image to post-scan isn't pinned by digest: %w", ErrParse)
+
+// originTypePostScan is the origin type of packages found by PostScan at the
+// destination of a copy from a builder stage. Their content is of the stage,
+// but whether it was added by the stage or is of its base image is unknown.
+const originTypePostScan = "post-scan"
+
+// postScanCopy is a copy of the final stage from a stage or an external
+// image, see PostScan.
+type postScanCopy struct {
+	copy containerfile.Copy
+	// absolute destination of the copy in the final image
+	dest string
+	// stage copied from, nil for external images
+	stage *containerfile.Stage
+}
+
+// PostScan scans the final image of a build of the containerfile, in local
+// storage by a pullspec pinned by digest, e.g. a historical image built
+// without intermediate images and pulled back from its registry. The content
+// at the destination of every COPY --from of the final stage is scanned and
+// its packages reported in the same PackageMetadata as by Scan, as from the
+// image or the stage copied from. Content of the final image at the
+// destinations isn't necessarily copied: the final stage base image and
+// later instructions may have added to it. Later copies to paths under the
+// destination of a copy take precedence.
+func (s *Scanner) PostScan(pullspec string, cf containerfile.Containerfile) (_ PackageMetadata, err error) {
+	if !strings.Contains(pullspec, "@sha256:") {
+		return PackageMetadata{}, fmt.Errorf("%q: %w", pullspec, ErrPostScanDigest)
+	}
+	final := cf.FinalStage()
+	if final == nil {
+		return PackageMetadata{}, fmt.Errorf("no final stage in the containerfile: %w", ErrParse)
+	}
+
+	start := time.Now()
+	var stats *ScanStats
+	s.debug = nil
+	if os.Getenv(debugEnv) != "" {
+		if s.debug, err = newDebugLayout(); err != nil {
+			return PackageMetadata{}, fmt.Errorf("failed to create debug directory: %w: %w", err, ErrIO)
+		}
+	}
+	s.mounts = newMountManager(s.store, s.logger)
+	defer func() {
+		if closeErr := s.mounts.close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		if s.debug != nil {
+			if summaryErr := s.debug.writeSummary(os.Stderr); summaryErr != nil {
+				s.logger.Warn("failed to print debug directories", "error", summaryErr)
+			}
+		}
+		if err == nil {
+			s.emit(Event{Type: EventStats, Stats: stats})
+		}
+	}()
+	s.warnings = nil
+	s.files = nil
+	s.fileOwners = nil
+	if s.fileOwnership {
+		s.fileOwners = newFileOwners()
+	}
+	s.scanMemo = newScanMemo()
+	s.matchTrace = nil
+	if s.traceMatching {
+		s.matchTrace = newMatchTracer(s.logger)
+		defer s.matchTrace.flush()
+	}
+
+	image, err := s.lookupBaseImage(pullspec, pullspec)
+	if err != nil {
+		return PackageMetadata{}, fmt.Errorf("image to post-scan, pull it first: %w", err)
+	}
+
+	baseWorkdir := "/"
+	if !storageclient.IsSpecialBase(final.Base) {
+		if cfg, err := s.sclient.GetImageConfig(final.Base); err == nil && cfg.Config.Workdir != "" {
+			baseWorkdir = cfg.Config.Workdir
+		} else {
+			s.logger.Debug("working directory of the final base image unknown, resolving destinations from /",
+				"base", final.Base)
+		}
+	}
+	var copies []postScanCopy
+	for _, cp := range final.Copies {
+		copied := postScanCopy{copy: cp, dest: resolveRelativeDestination(cp, baseWorkdir)}
+		switch cp.Type {
+		case containerfile.CopyTypeBuilder:
+			if copied.stage = cf.ResolveRef(cp.From, final.Index); copied.stage == nil {
+				continue
+			}
+		case containerfile.CopyTypeExternal:
+		default:
+			continue
+		}
+		copies = append(copies, copied)
+	}
+
+	ctx, cancel := s.scanContext()
+	defer cancel()
+	items := make([]PackageMetadataItem, 0)
+	for i, copied := range copies {
+		var later []string
+		for _, next := range copies[i+1:] {
+			later = append(later, next.dest)
+		}
+		copyItems, err := s.postScanCopy(ctx, image, copied, later)
+		if err != nil {
+			return PackageMetadata{}, err
+		}
+		s.emitPackages(copyItems)
+		items = append(items, copyItems...)
+	}
+
+	res := PackageMetadata{
+		Packages:      items,
+		Tools:         Version().Tools(),
+		StorageDriver: s.storageDriver,
+		Stages:        getStageMetadata(cf, s.redactor),
+		Warnings:      s.warnings,
+	}
+	if s.fileOwnership {
+		sortFileMetadata(s.files)
+		res.Files = append(make([]FileMetadataItem, 0, len(s.files)), s.files...)
+	}
+
+	if err := s.runPostScanHooks(context.Background(), &res); err != nil {
+		return PackageMetadata{}, err
+	}
+
+	stats = &ScanStats{
+		Packages:        len(res.Packages),
+		Warnings:        len(res.Warnings),
+		PackageSources:  len(copies),
+		DurationSeconds: time.Since(start).Seconds(),
+	}
+	return res, nil
+}
+
+// postScanOrigin returns the pullspec with the digest if the image is in
+// local storage, and the pullspec alone otherwise.
+func (s *Scanner) postScanOrigin(pullspec string) string {
+	if storageclient.IsSpecialBase(pullspec) {
+		return pullspec
+	}
+	dig, err := s.sclient.ResolveDigest(pullspec)
+	if err != nil {
+		s.logger.Debug("origin image not in local storage, reporting it without digest", "pullspec", pullspec)
+		return pullspec
+	}
+	digestBase, err := attachDigest(storageclient.StripTransport(pullspec), dig)
+	if err != nil {
+		return pullspec
+	}
+	return digestBase
+}
+
+// postScanCopy scans the content of the image at the destination of the
+// copy, without the content under the destinations of later copies.
+func (s *Scanner) postScanCopy(
+	ctx context.Context,
+	image *storage.Image,
+	copied postScanCopy,
+	later []string,
+) (_ []PackageMetadataItem, err error) {
+	origin, alias, originType := copied.copy.From, "", "external"
+	if copied.stage != nil {
+		origin, alias, originType = copied.stage.Base, copied.stage.Alias, originTypePostScan
+	}
+	digestBase := s.postScanOrigin(origin)
+
+	contentPath, err := s.contentDir(copied.copy.From, debugKindBuilder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w: %w", err, ErrIO)
+	}
+	defer func() {
+		if removeErr := s.removeContentDirs(contentPath); err == nil {
+			err = removeErr
+		}
+	}()
+
+	s.logger.Debug("scanning destination of copy in the image", "from", copied.copy.From, "dest", copied.dest)
+	included, err := s.getImageContent(ctx, image, []string{copied.dest}, contentPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: content of %q at %q: %w", ErrExtract, copied.copy.From, copied.dest, err)
+	}
+	// later copies to the destination replace its content
+	if included, err = s.removeMountContent(contentPath, included, later); err != nil {
+		return nil, err
+	}
+	if len(included) == 0 {
+		s.logger.Debug("no content at destination of copy", "from", copied.copy.From, "dest", copied.dest)
+		return nil, nil
+	}
+
+	pkgs, err := s.scanContent(ctx, s.syftScanner, scanKindContent, contentPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan content of %q at %q: %w: %w", copied.copy.From, copied.dest, err, ErrSBOMScan)
+	}
+	if err := s.recordContentFiles(contentPath, included, alias, digestBase, originType, nil, pkgs); err != nil {
+		return nil, err
+	}
+
+	res := getPackageMetadata(alias, digestBase, originType, "", nil, pkgs, nil)
+	if copied.stage != nil {
+		setStageIndex(res, copied.stage.Index)
+	}
+	return res, nil
+}
@@ -0,0 +1,55 @@
+//go:build unit
+
+package capo
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/konflux-ci/capo/internal/testutils"
+	"github.com/konflux-ci/capo/pkg/capotest"
+	"github.com/konflux-ci/capo/pkg/containerfile"
+)
+
+func TestPostScanErrors(t *testing.T) {
+	t.Parallel()
+	const pinned = "quay.io/org/app@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	cf := containerfile.Containerfile{Stages: []containerfile.Stage{
+		{Alias: "0", Index: 0, Kind: containerfile.StageKindFinal, Base: "scratch", BaseRef: "scratch"},
+	}}
+	tests := map[string]struct {
+		pullspec    string
+		cf          containerfile.Containerfile
+		expectedErr error
+	}{
+		"not pinned by digest": {
+			pullspec:    "quay.io/org/app:latest",
+			cf:          cf,
+			expectedErr: ErrPostScanDigest,
+		},
+		"no final stage": {
+			pullspec:    pinned,
+			expectedErr: ErrParse,
+		},
+		"image not in storage": {
+			pullspec:    pinned,
+			cf:          cf,
+			expectedErr: ErrImageNotFound,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			s := &Scanner{
+				logger:  slog.Default(),
+				sclient: testutils.NewTStorageClient(nil, nil),
+				store:   capotest.NewStore(),
+			}
+			if _, err := s.PostScan(tc.pullspec, tc.cf); !errors.Is(err, tc.expectedErr) {
+				t.Errorf("expected error wrapping %v, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
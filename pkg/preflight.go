@@ -6,26 +6,40 @@ package capo
 import (
 	"errors"
 	"fmt"
+	"path"
+
+	"go.podman.io/image/v5/docker/reference"
 
 	"github.com/konflux-ci/capo/pkg/containerfile"
 )
 
-var ErrUnsupportedFeature = errors.New(
-	"[ERR_UNSUPPORTED_FEATURES] some features of the containerfile are not supported for builder-content resolution",
+var ErrUnsupportedFeature = fmt.Errorf(
+	"[ERR_UNSUPPORTED_FEATURES] some features of the containerfile are not supported for builder-content resolution: %w",
+	ErrParse,
 )
-var ErrMountTypeBind = errors.New("[ERR_MOUNT_TYPE_BIND] RUN --mount with bind type in containerfile")
 
-// ErrDuplicateAlias is returned when two stages in a Containerfile share
-// the same alias. Buildah behavior for duplicate aliases is undefined
-// (see https://github.com/containers/buildah/issues/6731), so capo skips
-// builder content identification to avoid producing incorrect results.
-var ErrDuplicateAlias = errors.New("[ERR_DUPLICATE_ALIAS] duplicate stage alias")
+// ErrMountTypeBind is returned for RUN --mount=from=... bind mounts with
+// MountPolicyFail.
+var ErrMountTypeBind = fmt.Errorf("[ERR_MOUNT_TYPE_BIND] RUN --mount with bind type in containerfile: %w", ErrParse)
+
+// ErrDuplicateAlias is returned when a stage refers to a definition of a stage
+// alias that is redefined by a later stage. Intermediate images are found by
+// their io.buildah.stage.name label, which is the same for all definitions of
+// an alias, so capo can only identify content of the last definition.
+// Duplicate aliases referenced only after their last definition are
+// supported and reported as a warning instead.
+var ErrDuplicateAlias = fmt.Errorf("[ERR_DUPLICATE_ALIAS] duplicate stage alias: %w", ErrParse)
 
 // Check containerfile for unsupported features for builder content resolution.
-func preflightCheck(cf containerfile.Containerfile) error {
+// Bind mounts are only unsupported with MountPolicyFail.
+func preflightCheck(cf containerfile.Containerfile, mountPolicy MountPolicy) error {
+	var mountErr error
+	if mountPolicy == MountPolicyFail {
+		mountErr = checkRunMountTypeBind(cf)
+	}
 	joined := errors.Join(
 		checkDuplicateAlias(cf),
-		checkRunMountTypeBind(cf),
+		mountErr,
 	)
 	if joined == nil {
 		return nil
@@ -34,27 +48,148 @@ func preflightCheck(cf containerfile.Containerfile) error {
 	return fmt.Errorf("%w: %w", ErrUnsupportedFeature, joined)
 }
 
-// Check if the containerfile contains two stages with duplicate aliases.
+// Check if any stage refers to a definition of a duplicate stage alias which
+// is not the last one (see ErrDuplicateAlias).
 func checkDuplicateAlias(cf containerfile.Containerfile) error {
-	seenAliases := make(map[string]bool)
+	lastDefinition := make(map[string]int)
+	for _, dup := range cf.DuplicateAliases() {
+		lastDefinition[dup.Alias] = dup.Indexes[len(dup.Indexes)-1]
+	}
+	if len(lastDefinition) == 0 {
+		return nil
+	}
+
 	for _, stage := range cf.Stages {
-		if stage.Index != -1 && seenAliases[stage.Alias] {
-			return fmt.Errorf(
-				"stage alias %q is used more than once: %w",
-				stage.Alias, ErrDuplicateAlias,
-			)
+		for _, ref := range stageRefs(stage) {
+			target := cf.ResolveRef(ref, stage.Index)
+			if target == nil {
+				continue
+			}
+			if last, ok := lastDefinition[target.Alias]; ok && target.Index != last {
+				return fmt.Errorf(
+					"stage %d refers to stage %d with alias %q, which is redefined by stage %d: %w",
+					stage.Index, target.Index, target.Alias, last, ErrDuplicateAlias,
+				)
+			}
 		}
-		seenAliases[stage.Alias] = true
 	}
 
 	return nil
 }
 
+// duplicateAliasWarnings returns a warning for every stage alias defined more
+// than once in the containerfile.
+func duplicateAliasWarnings(cf containerfile.Containerfile) []Warning {
+	res := make([]Warning, 0)
+	for _, dup := range cf.DuplicateAliases() {
+		res = append(res, Warning{
+			Code: WarnDuplicateAlias,
+			Message: fmt.Sprintf(
+				"stage alias %q is defined by stages %v, content is attributed to the last definition (stage %d)",
+				dup.Alias, dup.Indexes, dup.Indexes[len(dup.Indexes)-1],
+			),
+		})
+	}
+	return res
+}
+
+// ambiguousAliasWarnings returns a warning for every stage alias referenced by
+// name which is also the name of an image the containerfile references (the
+// last component of its repository path), e.g. "helm" and
+// docker.io/alpine/helm. As in buildah and Docker, a reference is to the
+// stage if a stage with the name is defined before it, even if an image has
+// the same name, and to the image otherwise.
+func ambiguousAliasWarnings(cf containerfile.Containerfile) []Warning {
+	referenced := make(map[int]bool)
+	for _, stage := range cf.Stages {
+		for _, ref := range stageRefs(stage) {
+			if target := cf.ResolveRef(ref, stage.Index); target != nil && target.Alias == ref {
+				referenced[target.Index] = true
+			}
+		}
+	}
+
+	images := imageRefs(cf)
+	res := make([]Warning, 0)
+	for _, stage := range cf.Stages {
+		if !referenced[stage.Index] {
+			continue
+		}
+		for _, image := range images {
+			if imageName(image) != stage.Alias {
+				continue
+			}
+			res = append(res, Warning{
+				Code: WarnAmbiguousAlias,
+				Message: fmt.Sprintf(
+					"stage alias %q (stage %d) is also the name of image %s, references after the stage are to the stage",
+					stage.Alias, stage.Index, image,
+				),
+			})
+			break
+		}
+	}
+	return res
+}
+
+// imageRefs returns the pullspecs of the images referenced by the
+// containerfile as bases, by COPY --from and by RUN --mount, in order.
+func imageRefs(cf containerfile.Containerfile) []string {
+	var res []string
+	for _, stage := range cf.Stages {
+		if cf.ResolveRef(stage.BaseRef, stage.Index) == nil {
+			res = append(res, stage.BaseRef)
+		}
+		for _, cp := range stage.Copies {
+			if cp.Type == containerfile.CopyTypeExternal {
+				res = append(res, cp.From)
+			}
+		}
+		for _, mount := range stage.Mounts {
+			if mount.Pullspec != "" {
+				res = append(res, mount.Pullspec)
+			}
+		}
+	}
+	return res
+}
+
+// imageName returns the last component of the repository path of the image,
+// or "" if the pullspec isn't an image reference (e.g. scratch or
+// oci-archive:image.tar).
+func imageName(pullspec string) string {
+	if pullspec == "scratch" {
+		return ""
+	}
+	named, err := reference.ParseNormalizedNamed(pullspec)
+	if err != nil {
+		return ""
+	}
+	return path.Base(reference.Path(named))
+}
+
+// stageRefs returns all references from the stage that may point to other
+// stages: the FROM base and builder-type COPY --from and RUN --mount sources.
+func stageRefs(stage containerfile.Stage) []string {
+	refs := []string{stage.BaseRef}
+	for _, cp := range stage.Copies {
+		if cp.Type == containerfile.CopyTypeBuilder {
+			refs = append(refs, cp.From)
+		}
+	}
+	for _, mount := range stage.Mounts {
+		if mount.Pullspec == "" && mount.FromRaw != "" {
+			refs = append(refs, mount.FromRaw)
+		}
+	}
+	return refs
+}
+
 // Check if the containerfile utilizes a RUN --mount with the bind type.
 func checkRunMountTypeBind(cf containerfile.Containerfile) error {
 	for _, st := range cf.Stages {
 		for _, mount := range st.Mounts {
-			if mount.MountType == containerfile.MountTypeBind && mount.FromRaw != "" {
+			if isBuildMount(mount) {
 				return ErrMountTypeBind
 			}
 		}
@@ -0,0 +1,73 @@
+// Preprocessing of templated containerfiles (e.g. Containerfile.in for m4)
+// by an external command before parsing.
+
+package capo
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// ErrPreprocess is returned by Preprocess when the preprocessor can't be run
+// or fails.
+var ErrPreprocess = fmt.Errorf("[ERR_PREPROCESS] failed to preprocess the containerfile: %w", ErrParse)
+
+// Preprocessor describes the command the containerfile was piped through
+// before parsing, so the scan can be traced to the preprocessor producing it.
+type Preprocessor struct {
+	// Arguments of the command, e.g. ["m4", "-DVERSION=1.2"].
+	Command []string `json:"command"`
+
+	// Digest of the executable of the command, e.g. "sha256:3b1f...".
+	Digest string `json:"digest"`
+}
+
+// Preprocess pipes the containerfile read from r through the command and
+// returns its output. The command runs in dir (usually the directory of the
+// containerfile, so relative includes resolve) and reads the containerfile
+// from its standard input. Fails with ErrPreprocess, including what the
+// command wrote to its standard error, if it exits with an error.
+func Preprocess(command []string, r io.Reader, dir string) ([]byte, Preprocessor, error) {
+	if len(command) == 0 {
+		return nil, Preprocessor{}, fmt.Errorf("%w: empty command", ErrPreprocess)
+	}
+	path, err := exec.LookPath(command[0])
+	if err != nil {
+		return nil, Preprocessor{}, fmt.Errorf("%w: %w", ErrPreprocess, err)
+	}
+	dig, err := fileDigest(path)
+	if err != nil {
+		return nil, Preprocessor{}, fmt.Errorf("%w: %w", ErrPreprocess, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(path, command[1:]...)
+	cmd.Dir = dir
+	cmd.Stdin = r
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, Preprocessor{}, fmt.Errorf("%w: %s: %w: %s", ErrPreprocess, command[0], err, msg)
+		}
+		return nil, Preprocessor{}, fmt.Errorf("%w: %s: %w", ErrPreprocess, command[0], err)
+	}
+
+	return stdout.Bytes(), Preprocessor{Command: command, Digest: dig.String()}, nil
+}
+
+// fileDigest returns the sha256 digest of the content of the file at path.
+func fileDigest(path string) (digest.Digest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+	return digest.Canonical.FromReader(f)
+}
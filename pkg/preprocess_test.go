@@ -0,0 +1,80 @@
+//go:build unit
+
+package capo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPreprocess(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		command     []string
+		input       string
+		expected    string
+		expectedErr error
+	}{
+		"substitution": {
+			command:  []string{"sed", "s/@VERSION@/1.2/"},
+			input:    "FROM quay.io/org/base:@VERSION@\n",
+			expected: "FROM quay.io/org/base:1.2\n",
+		},
+		"include relative to the directory": {
+			command:  []string{"sh", "-c", "cat - include.in"},
+			input:    "FROM scratch\n",
+			expected: "FROM scratch\nCOPY . /app\n",
+		},
+		"failing command": {
+			command:     []string{"sh", "-c", "echo 'undefined macro' >&2; exit 1"},
+			expectedErr: ErrPreprocess,
+		},
+		"missing command": {
+			command:     []string{"capo-no-such-preprocessor"},
+			expectedErr: ErrPreprocess,
+		},
+		"empty command": {
+			expectedErr: ErrPreprocess,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "include.in"), []byte("COPY . /app\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			output, preprocessor, err := Preprocess(tt.command, strings.NewReader(tt.input), dir)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tt.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tt.expected, string(output)); diff != "" {
+				t.Errorf("output mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.command, preprocessor.Command); diff != "" {
+				t.Errorf("command mismatch (-want +got):\n%s", diff)
+			}
+			if !strings.HasPrefix(preprocessor.Digest, "sha256:") {
+				t.Errorf("expected a sha256 digest, got %q", preprocessor.Digest)
+			}
+		})
+	}
+}
+
+func TestPreprocessStderr(t *testing.T) {
+	t.Parallel()
+	_, _, err := Preprocess([]string{"sh", "-c", "echo 'undefined macro' >&2; exit 1"}, strings.NewReader(""), t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "undefined macro") {
+		t.Errorf("expected the error to include stderr, got %v", err)
+	}
+}
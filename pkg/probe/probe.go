@@ -127,12 +127,10 @@ func Probe(
 
 	cf, err := containerfile.Parse(
 		opts.containerfile,
-		containerfile.BuildOptions{
-			Args:          opts.args,
-			EnvVars:       opts.envVars,
-			Target:        opts.target,
-			BuildContexts: opts.buildContexts,
-		},
+		containerfile.WithArgs(opts.args),
+		containerfile.WithEnvVars(opts.envVars),
+		containerfile.WithTarget(opts.target),
+		containerfile.WithBuildContexts(opts.buildContexts),
 	)
 	if err != nil {
 		return meta, fmt.Errorf("%w: %w", ErrParseContainerfile, err)
@@ -170,8 +168,8 @@ func reachableStages(stages []containerfile.Stage) []containerfile.Stage {
 
 	// Map stage aliases to all their indexes. Multiple stages can share the
 	// same alias. Buildah builds all matching stages, so we must track every
-	// occurrence. The final stage has Alias==FinalStage so it won't collide
-	// with named builder stages.
+	// occurrence. The final stage is never referenced by other stages, so
+	// including it in the map is harmless.
 	stagesByAlias := make(map[string][]int)
 	for stageIndex, stage := range stages {
 		stagesByAlias[stage.Alias] = append(stagesByAlias[stage.Alias], stageIndex)
@@ -0,0 +1,165 @@
+// Mapping of pullspecs written in the containerfile to the pullspecs the
+// images were actually pulled by, see ReadPullspecMap.
+
+package capo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/opencontainers/go-digest"
+	"go.yaml.in/yaml/v3"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+	"github.com/konflux-ci/capo/pkg/storageclient"
+)
+
+var ErrPullspecMap = fmt.Errorf("[ERR_PULLSPEC_MAP] invalid pullspec map: %w", ErrParse)
+var ErrPullspecMapping = fmt.Errorf("[ERR_PULLSPEC_MAPPING] invalid pullspec mapping: %w", ErrParse)
+
+// PullspecMap maps pullspecs of images as written in the containerfile to the
+// pullspecs buildah actually pulled them by, e.g. when the registry is
+// rewritten to a mirror by registries.conf:
+//
+//	pullspecs:
+//	  registry.access.redhat.com/ubi9:latest: mirror.example.com/ubi9:latest
+//
+// Both are normalized the same as the pullspecs of the containerfile (see
+// containerfile.NormalizePullspec), so "fedora" maps the same image as
+// "docker.io/library/fedora:latest".
+type PullspecMap struct {
+	Pullspecs map[string]string `yaml:"pullspecs"`
+}
+
+// ReadPullspecMap reads the pullspec map in the YAML file at path. Unknown
+// keys, empty pullspecs, special bases (e.g. scratch) and pullspecs mapped
+// from more than one pullspec are an error.
+func ReadPullspecMap(path string) (PullspecMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return PullspecMap{}, err
+	}
+	defer f.Close()
+
+	var pm PullspecMap
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&pm); err != nil && !errors.Is(err, io.EOF) {
+		return PullspecMap{}, fmt.Errorf("%w: %s: %w", ErrPullspecMap, path, err)
+	}
+
+	normalized, err := pm.normalize()
+	if err != nil {
+		return PullspecMap{}, fmt.Errorf("%w: %s: %w", ErrPullspecMap, path, err)
+	}
+	return normalized, nil
+}
+
+// normalize returns the map with normalized pullspecs, or an error if one
+// can't be mapped.
+func (pm PullspecMap) normalize() (PullspecMap, error) {
+	res := PullspecMap{Pullspecs: make(map[string]string, len(pm.Pullspecs))}
+	mappedFrom := make(map[string]string, len(pm.Pullspecs))
+	// sorted, so the error of a map with a duplicate is stable
+	for _, original := range slices.Sorted(maps.Keys(pm.Pullspecs)) {
+		actual := pm.Pullspecs[original]
+		for _, p := range []string{original, actual} {
+			if p == "" || storageclient.IsSpecialBase(p) {
+				return PullspecMap{}, fmt.Errorf("%w: can't map %q to %q", ErrPullspecMapping, original, actual)
+			}
+		}
+
+		original = containerfile.NormalizePullspec(original)
+		actual = containerfile.NormalizePullspec(actual)
+		if other, ok := mappedFrom[actual]; ok {
+			return PullspecMap{}, fmt.Errorf(
+				"%w: %q and %q are both mapped to %q", ErrPullspecMapping, other, original, actual,
+			)
+		}
+		mappedFrom[actual] = original
+		res.Pullspecs[original] = actual
+	}
+	return res, nil
+}
+
+// Configure the scanner to look up the images of the containerfile in
+// container storage by the pullspecs they were actually pulled by (see
+// PullspecMap). Packages of mapped images report the pullspec as written in
+// the containerfile in PackageMetadataItem.OriginalPullspec, next to the
+// pullspec with digest of the actual image.
+func WithPullspecMap(pm PullspecMap) Option {
+	return func(s *Scanner) {
+		s.pullspecMap = pm
+	}
+}
+
+// mapPullspecs returns cf with the pullspecs of stage bases, external copies
+// and mounts replaced by the pullspecs they are mapped to by the scanner (see
+// WithPullspecMap). cf isn't modified.
+func (s *Scanner) mapPullspecs(cf containerfile.Containerfile) containerfile.Containerfile {
+	if len(s.pullspecMap.Pullspecs) == 0 {
+		return cf
+	}
+	mapped := func(pullspec string) string {
+		if actual, ok := s.pullspecMap.Pullspecs[pullspec]; ok {
+			return actual
+		}
+		return pullspec
+	}
+
+	res := cf
+	res.Stages = slices.Clone(cf.Stages)
+	for i := range res.Stages {
+		stage := &res.Stages[i]
+		stage.Base = mapped(stage.Base)
+		if cf.ResolveRef(stage.BaseRef, stage.Index) == nil {
+			stage.BaseRef = mapped(stage.BaseRef)
+		}
+		stage.Copies = slices.Clone(stage.Copies)
+		for j := range stage.Copies {
+			if stage.Copies[j].Type == containerfile.CopyTypeExternal {
+				stage.Copies[j].From = mapped(stage.Copies[j].From)
+			}
+		}
+		stage.Mounts = slices.Clone(stage.Mounts)
+		for j := range stage.Mounts {
+			if stage.Mounts[j].Pullspec != "" {
+				stage.Mounts[j].Pullspec = mapped(stage.Mounts[j].Pullspec)
+			}
+		}
+	}
+	return res
+}
+
+// getOriginalPullspecs maps pullspecs with digest (as in the output, see
+// attachDigest) of the resolved images to the pullspecs the scanner mapped to
+// them (see WithPullspecMap).
+func (s *Scanner) getOriginalPullspecs(digests map[string]digest.Digest) (map[string]string, error) {
+	res := make(map[string]string)
+	for original, actual := range s.pullspecMap.Pullspecs {
+		dig, ok := digests[actual]
+		if !ok {
+			continue
+		}
+		digestPullspec, err := attachDigest(storageclient.StripTransport(actual), dig)
+		if err != nil {
+			return nil, err
+		}
+		res[digestPullspec] = original
+	}
+	return res, nil
+}
+
+// setOriginalPullspecs sets the original pullspec of items with an origin
+// mapped by the scanner.
+func setOriginalPullspecs(items []PackageMetadataItem, originals map[string]string) {
+	for i := range items {
+		if original, ok := originals[items[i].Pullspec]; ok {
+			items[i].OriginalPullspec = original
+		}
+	}
+}
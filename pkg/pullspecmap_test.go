@@ -0,0 +1,133 @@
+//go:build unit
+
+package capo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+)
+
+func TestReadPullspecMap(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		content     string
+		expected    PullspecMap
+		expectedErr error
+	}{
+		"valid": {
+			content: "pullspecs:\n  fedora: mirror.example.com/fedora:42\n",
+			expected: PullspecMap{Pullspecs: map[string]string{
+				"docker.io/library/fedora:latest": "mirror.example.com/fedora:42",
+			}},
+		},
+		"empty file":  {content: ""},
+		"unknown key": {content: "mirrors:\n  fedora: mirror.example.com/fedora\n", expectedErr: ErrPullspecMap},
+		"empty":       {content: "pullspecs:\n  fedora: ''\n", expectedErr: ErrPullspecMapping},
+		"scratch":     {content: "pullspecs:\n  scratch: mirror.example.com/scratch\n", expectedErr: ErrPullspecMapping},
+		"duplicate": {
+			content:     "pullspecs:\n  fedora: mirror.example.com/fedora\n  fedora:latest: mirror.example.com/fedora\n",
+			expectedErr: ErrPullspecMapping,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			path := filepath.Join(t.TempDir(), "pullspecs.yaml")
+			if err := os.WriteFile(path, []byte(test.content), 0o644); err != nil {
+				t.Fatalf("failed to write pullspec map: %v", err)
+			}
+			pm, err := ReadPullspecMap(path)
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error wrapping %v, got: %v", test.expectedErr, err)
+			}
+			if diff := cmp.Diff(test.expected, pm, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("PullspecMap mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMapPullspecs(t *testing.T) {
+	t.Parallel()
+	const (
+		golang   = "docker.io/library/golang:1.22"
+		mirrored = "mirror.example.com/library/golang:1.22"
+		tools    = "quay.io/konflux-ci/tools:latest"
+		mirror   = "mirror.example.com/konflux-ci/tools:latest"
+		alpine   = "docker.io/library/alpine:3.20"
+	)
+	s := &Scanner{pullspecMap: PullspecMap{Pullspecs: map[string]string{golang: mirrored, tools: mirror}}}
+	mounts := []containerfile.Mount{{From: alpine, Pullspec: alpine}}
+	cf := containerfile.Containerfile{Stages: []containerfile.Stage{
+		{Alias: "builder", Base: golang, BaseRef: golang, Mounts: mounts},
+		{Alias: "test", Base: golang, BaseRef: "builder", Index: 1},
+		{
+			Alias: "2", Base: "scratch", BaseRef: "scratch", Index: 2, Kind: containerfile.StageKindFinal,
+			Copies: []containerfile.Copy{
+				{From: "builder", Destination: "/out", Type: containerfile.CopyTypeBuilder},
+				{From: tools, Destination: "/bin/tool", Type: containerfile.CopyTypeExternal},
+			},
+		},
+	}}
+
+	expected := containerfile.Containerfile{Stages: []containerfile.Stage{
+		{Alias: "builder", Base: mirrored, BaseRef: mirrored, Mounts: mounts},
+		{Alias: "test", Base: mirrored, BaseRef: "builder", Index: 1},
+		{
+			Alias: "2", Base: "scratch", BaseRef: "scratch", Index: 2, Kind: containerfile.StageKindFinal,
+			Copies: []containerfile.Copy{
+				{From: "builder", Destination: "/out", Type: containerfile.CopyTypeBuilder},
+				{From: mirror, Destination: "/bin/tool", Type: containerfile.CopyTypeExternal},
+			},
+		},
+	}}
+	if diff := cmp.Diff(expected, s.mapPullspecs(cf), cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("mapPullspecs() mismatch (-want +got):\n%s", diff)
+	}
+	if cf.Stages[0].Base != golang || cf.Stages[2].Copies[1].From != tools {
+		t.Errorf("mapPullspecs() modified the passed containerfile")
+	}
+}
+
+func TestGetOriginalPullspecs(t *testing.T) {
+	t.Parallel()
+	s := &Scanner{pullspecMap: PullspecMap{Pullspecs: map[string]string{
+		"docker.io/library/fedora:latest": "mirror.example.com/fedora:latest",
+		"docker.io/library/alpine:latest": "mirror.example.com/alpine:latest",
+	}}}
+	digests := map[string]digest.Digest{
+		"mirror.example.com/fedora:latest": fedoraDigest,
+		"quay.io/konflux-ci/tools:latest":  fedoraDigest,
+	}
+
+	originals, err := s.getOriginalPullspecs(digests)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	items := []PackageMetadataItem{
+		{PackageURL: "pkg:rpm/fedora/bash", Pullspec: "mirror.example.com/fedora@" + fedoraDigest},
+		{PackageURL: "pkg:golang/tool", Pullspec: "quay.io/konflux-ci/tools@" + fedoraDigest},
+	}
+	setOriginalPullspecs(items, originals)
+
+	expected := []PackageMetadataItem{
+		{
+			PackageURL:       "pkg:rpm/fedora/bash",
+			Pullspec:         "mirror.example.com/fedora@" + fedoraDigest,
+			OriginalPullspec: "docker.io/library/fedora:latest",
+		},
+		{PackageURL: "pkg:golang/tool", Pullspec: "quay.io/konflux-ci/tools@" + fedoraDigest},
+	}
+	if diff := cmp.Diff(expected, items); diff != "" {
+		t.Errorf("setOriginalPullspecs() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,205 @@
+// Partial SBOMs of the scan output pushed to a registry as OCI artifacts
+// referring to the built image (see PushReferrers).
+
+package capo
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.podman.io/image/v5/docker"
+	"go.podman.io/image/v5/docker/reference"
+	"go.podman.io/image/v5/pkg/blobinfocache/none"
+	"go.podman.io/image/v5/types"
+)
+
+const (
+	// ReferrerArtifactType is the artifact type of pushed partial SBOMs. Their
+	// single layer is the scan output with the packages of one origin.
+	ReferrerArtifactType = "application/vnd.konflux-ci.capo.packages.v1+json"
+	// ReferrerOriginAnnotation is the annotation of a pushed partial SBOM with
+	// the pullspec of the origin of its packages, or their origin type for
+	// packages without a pullspec (e.g. "context").
+	ReferrerOriginAnnotation = "io.konflux-ci.capo.origin"
+)
+
+var ErrPushReferrer = fmt.Errorf("[ERR_PUSH_REFERRER] failed to push partial SBOM: %w", ErrResolve)
+
+// PartialSBOM is the scan output with the packages of a single origin.
+type PartialSBOM struct {
+	// Pullspec of the origin of the packages, or their origin type for
+	// packages without a pullspec (e.g. "context").
+	Origin   string
+	Metadata PackageMetadata
+}
+
+// PartialSBOMs splits the packages of the scan output by the pullspec of their
+// origin, or their origin type if they have none, sorted by origin. Every
+// partial SBOM records the tools of the scan output.
+func PartialSBOMs(m PackageMetadata) []PartialSBOM {
+	byOrigin := make(map[string][]PackageMetadataItem)
+	for _, item := range m.Packages {
+		origin := item.Pullspec
+		if origin == "" {
+			origin = item.OriginType
+		}
+		byOrigin[origin] = append(byOrigin[origin], item)
+	}
+
+	res := make([]PartialSBOM, 0, len(byOrigin))
+	for origin, items := range byOrigin {
+		res = append(res, PartialSBOM{Origin: origin, Metadata: PackageMetadata{Packages: items, Tools: m.Tools}})
+	}
+	slices.SortFunc(res, func(a, b PartialSBOM) int { return cmp.Compare(a.Origin, b.Origin) })
+	return res
+}
+
+// artifactManifest returns the OCI image manifest of the partial SBOM of the
+// origin with the passed layer, referring to subject. The config is the empty
+// descriptor, as recommended for artifacts by the image spec.
+func artifactManifest(subject, layer imgspecv1.Descriptor, origin string) ([]byte, error) {
+	return json.Marshal(imgspecv1.Manifest{
+		Versioned:    specs.Versioned{SchemaVersion: 2},
+		MediaType:    imgspecv1.MediaTypeImageManifest,
+		ArtifactType: ReferrerArtifactType,
+		Config:       imgspecv1.DescriptorEmptyJSON,
+		Layers:       []imgspecv1.Descriptor{layer},
+		Subject:      &subject,
+		Annotations:  map[string]string{ReferrerOriginAnnotation: origin},
+	})
+}
+
+// PushReferrers pushes the partial SBOM of every origin of the packages of
+// the scan output to the repository of image, a pullspec of the built image
+// with its digest in that repository, as OCI artifacts with image as their
+// subject. Registries supporting the referrers API list them as referrers of
+// the image. Returns the pullspecs of the pushed artifacts by digest.
+//
+// Credentials are read from the usual containers auth files, sys may be nil
+// for the defaults (see SystemContext).
+func PushReferrers(
+	ctx context.Context,
+	image string,
+	m PackageMetadata,
+	sys *types.SystemContext,
+) ([]string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image reference %q: %w: %w", image, err, ErrPushReferrer)
+	}
+	canonical, ok := named.(reference.Canonical)
+	if !ok {
+		return nil, fmt.Errorf("image reference %q has no digest: %w", image, ErrPushReferrer)
+	}
+
+	subject, err := getSubject(ctx, canonical, sys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest of %q: %w: %w", image, err, ErrPushReferrer)
+	}
+
+	res := make([]string, 0)
+	for _, partial := range PartialSBOMs(m) {
+		pushed, err := pushPartialSBOM(ctx, reference.TrimNamed(named), subject, partial, sys)
+		if err != nil {
+			return res, fmt.Errorf("failed to push partial SBOM of %q: %w: %w", partial.Origin, err, ErrPushReferrer)
+		}
+		res = append(res, pushed)
+	}
+	return res, nil
+}
+
+// getSubject returns the descriptor of the manifest of the image.
+func getSubject(
+	ctx context.Context,
+	image reference.Canonical,
+	sys *types.SystemContext,
+) (imgspecv1.Descriptor, error) {
+	ref, err := docker.NewReference(image)
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	src, err := ref.NewImageSource(ctx, sys)
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	defer src.Close()
+
+	manifest, mimeType, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	return imgspecv1.Descriptor{
+		MediaType: mimeType,
+		Digest:    image.Digest(),
+		Size:      int64(len(manifest)),
+	}, nil
+}
+
+// pushPartialSBOM pushes the partial SBOM as an artifact referring to subject
+// to the repository and returns its pullspec by digest.
+func pushPartialSBOM(
+	ctx context.Context,
+	repository reference.Named,
+	subject imgspecv1.Descriptor,
+	partial PartialSBOM,
+	sys *types.SystemContext,
+) (string, error) {
+	var layer bytes.Buffer
+	if err := partial.Metadata.EncodeJSON(&layer); err != nil {
+		return "", err
+	}
+	layerDesc := imgspecv1.Descriptor{
+		MediaType:   "application/json",
+		Digest:      digest.FromBytes(layer.Bytes()),
+		Size:        int64(layer.Len()),
+		Annotations: map[string]string{imgspecv1.AnnotationTitle: "packages.json"},
+	}
+
+	manifest, err := artifactManifest(subject, layerDesc, partial.Origin)
+	if err != nil {
+		return "", fmt.Errorf("%w: manifest of the partial SBOM of %q: %w", ErrEncode, partial.Origin, err)
+	}
+	named, err := reference.WithDigest(repository, digest.FromBytes(manifest))
+	if err != nil {
+		return "", err
+	}
+	ref, err := docker.NewReference(named)
+	if err != nil {
+		return "", err
+	}
+
+	dest, err := ref.NewImageDestination(ctx, sys)
+	if err != nil {
+		return "", err
+	}
+	defer dest.Close()
+
+	blobs := []struct {
+		data     []byte
+		desc     imgspecv1.Descriptor
+		isConfig bool
+	}{
+		{data: imgspecv1.DescriptorEmptyJSON.Data, desc: imgspecv1.DescriptorEmptyJSON, isConfig: true},
+		{data: layer.Bytes(), desc: layerDesc},
+	}
+	for _, blob := range blobs {
+		info := types.BlobInfo{Digest: blob.desc.Digest, Size: blob.desc.Size}
+		if _, err := dest.PutBlob(ctx, bytes.NewReader(blob.data), info, none.NoCache, blob.isConfig); err != nil {
+			return "", err
+		}
+	}
+	if err := dest.PutManifest(ctx, manifest, nil); err != nil {
+		return "", err
+	}
+	if err := dest.Commit(ctx, nil); err != nil {
+		return "", err
+	}
+	return named.String(), nil
+}
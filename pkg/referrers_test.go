@@ -0,0 +1,99 @@
+//go:build unit
+
+package capo
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPartialSBOMs(t *testing.T) {
+	t.Parallel()
+	builder := PackageMetadataItem{
+		PackageURL: "pkg:rpm/rhel/glibc@2.34-83.el9",
+		OriginType: "builder",
+		Pullspec:   "registry.access.redhat.com/ubi9/ubi-minimal@sha256:def456",
+	}
+	intermediate := PackageMetadataItem{
+		PackageURL: "pkg:rpm/rhel/python3@3.9.18-3.el9",
+		OriginType: "intermediate",
+		Pullspec:   "registry.access.redhat.com/ubi9/ubi-minimal@sha256:def456",
+	}
+	external := PackageMetadataItem{
+		PackageURL: "pkg:golang/github.com/anchore/syft@v1.32.0",
+		OriginType: "builder",
+		Pullspec:   "ghcr.io/anchore/syft@sha256:789fed",
+	}
+	contextItem := PackageMetadataItem{
+		PackageURL: "pkg:pypi/requests@2.32.3",
+		OriginType: originTypeContext,
+	}
+
+	tools := []Tool{{Vendor: "konflux-ci", Name: "capo", Version: "v1.2.0"}}
+
+	expected := []PartialSBOM{
+		{Origin: "context", Metadata: PackageMetadata{Packages: []PackageMetadataItem{contextItem}, Tools: tools}},
+		{
+			Origin:   "ghcr.io/anchore/syft@sha256:789fed",
+			Metadata: PackageMetadata{Packages: []PackageMetadataItem{external}, Tools: tools},
+		},
+		{
+			Origin:   "registry.access.redhat.com/ubi9/ubi-minimal@sha256:def456",
+			Metadata: PackageMetadata{Packages: []PackageMetadataItem{builder, intermediate}, Tools: tools},
+		},
+	}
+
+	actual := PartialSBOMs(PackageMetadata{
+		Packages: []PackageMetadataItem{builder, external, contextItem, intermediate},
+		Tools:    tools,
+		Warnings: []Warning{{Code: WarnDuplicateAlias, Message: "stage alias defined twice"}},
+	})
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("PartialSBOMs() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestArtifactManifest(t *testing.T) {
+	t.Parallel()
+	subject := imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Digest:    testDigest("aaa111"),
+		Size:      1234,
+	}
+	layer := imgspecv1.Descriptor{
+		MediaType: "application/json",
+		Digest:    testDigest("bbb222"),
+		Size:      56,
+	}
+
+	data, err := artifactManifest(subject, layer, "ghcr.io/anchore/syft@sha256:789fed")
+	if err != nil {
+		t.Fatalf("artifactManifest returned error: %v", err)
+	}
+	var actual imgspecv1.Manifest
+	if err := json.Unmarshal(data, &actual); err != nil {
+		t.Fatalf("failed to decode manifest: %v", err)
+	}
+
+	if actual.SchemaVersion != 2 || actual.MediaType != imgspecv1.MediaTypeImageManifest {
+		t.Errorf("unexpected manifest version %d and media type %q", actual.SchemaVersion, actual.MediaType)
+	}
+	if actual.ArtifactType != ReferrerArtifactType {
+		t.Errorf("expected artifact type %q, got %q", ReferrerArtifactType, actual.ArtifactType)
+	}
+	if diff := cmp.Diff(imgspecv1.DescriptorEmptyJSON, actual.Config); diff != "" {
+		t.Errorf("config mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]imgspecv1.Descriptor{layer}, actual.Layers); diff != "" {
+		t.Errorf("layers mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(&subject, actual.Subject); diff != "" {
+		t.Errorf("subject mismatch (-want +got):\n%s", diff)
+	}
+	if origin := actual.Annotations[ReferrerOriginAnnotation]; origin != "ghcr.io/anchore/syft@sha256:789fed" {
+		t.Errorf("expected origin annotation, got %q", origin)
+	}
+}
@@ -0,0 +1,190 @@
+// Packages of external origins from SBOMs attached to them in their registry,
+// see WithReferrerSBOMs.
+
+package capo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.podman.io/image/v5/docker"
+	"go.podman.io/image/v5/docker/reference"
+	"go.podman.io/image/v5/pkg/blobinfocache/none"
+	"go.podman.io/image/v5/types"
+
+	"github.com/konflux-ci/capo/internal/sbom"
+	"github.com/konflux-ci/capo/pkg/containerfile"
+)
+
+var ErrReferrerSBOM = fmt.Errorf("[ERR_REFERRER_SBOM] no attached SBOM: %w", ErrResolve)
+
+// foundByReferrerSBOM marks packages of an SBOM attached to their origin
+// image, see WithReferrerSBOMs.
+const foundByReferrerSBOM = "referrer-sbom"
+
+// Largest attached SBOM read, larger ones are scanned by syft instead.
+const maxReferrerSBOMBytes = 256 << 20
+
+// Artifact types of referrers which are SBOMs syft can decode.
+var sbomArtifactTypes = []string{
+	"application/spdx+json",
+	"application/vnd.cyclonedx+json",
+	"application/vnd.syft+json",
+}
+
+// Configure the scanner to take the packages of content copied from external
+// images (COPY --from=image) from an SBOM attached to the image in its
+// registry, if it has one, instead of scanning the content with syft. The
+// SBOM is found by the tag of the OCI referrers tag schema (sha256-<digest>)
+// or by the tag cosign attaches SBOMs with (sha256-<digest>.sbom); signed
+// attestations aren't read. Only packages with a location under a copied
+// source are taken, so the SBOM must record locations, otherwise the content
+// is scanned. The packages are marked by FoundBy "referrer-sbom". Images
+// whose SBOM can't be fetched are scanned, and nothing is fetched in offline
+// mode (see WithOffline). Registries are authenticated with the credentials of
+// WithAuthFile and WithCertDir. Disabled by default.
+func WithReferrerSBOMs(referrerSBOMs bool) Option {
+	return func(s *Scanner) {
+		s.referrerSBOMs = referrerSBOMs
+	}
+}
+
+// referrerPackages returns the packages of the external root from the SBOM
+// attached to its image, and whether it has one with packages at their
+// locations.
+func (s *Scanner) referrerPackages(ctx context.Context, root packageSource) ([]PackageMetadataItem, bool) {
+	if !s.referrerSBOMs || s.offline || root.kind != containerfile.StageKindExternal {
+		return nil, false
+	}
+	packages, err := fetchReferrerSBOM(ctx, root.digestBase, SystemContext(s.authFile, s.certDir))
+	if err != nil {
+		s.logger.Debug("no attached SBOM, scanning content", "pullspec", root.digestBase, "error", err)
+		return nil, false
+	}
+
+	items, ok := copiedPackages(packages, root.digestBase, root.sources, foundByReferrerSBOM)
+	if !ok {
+		s.logger.Debug("attached SBOM has no locations, scanning content", "pullspec", root.digestBase)
+		return nil, false
+	}
+	s.logger.Debug("took packages from attached SBOM", "pullspec", root.digestBase, "packages", len(items))
+	return items, true
+}
+
+// fetchReferrerSBOM returns the packages of the SBOM attached to the image, a
+// pullspec with digest, found by the tag of the referrers tag schema or the
+// cosign tag. Credentials are read from the usual containers auth files, sys
+// may be nil for the defaults.
+func fetchReferrerSBOM(ctx context.Context, image string, sys *types.SystemContext) ([]sbom.SyftPackage, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil, err
+	}
+	canonical, ok := named.(reference.Canonical)
+	if !ok {
+		return nil, fmt.Errorf("%w: image reference %q has no digest", ErrReferrerSBOM, image)
+	}
+	repository := reference.TrimNamed(canonical)
+	tag := canonical.Digest().Algorithm().String() + "-" + canonical.Digest().Encoded()
+
+	data, err := referrersTagSBOM(ctx, repository, tag, sys)
+	if err != nil {
+		if data, err = cosignSBOM(ctx, repository, tag+".sbom", sys); err != nil {
+			return nil, err
+		}
+	}
+	return sbom.Decode(bytes.NewReader(data))
+}
+
+// referrersTagSBOM returns the first layer of the first SBOM listed by the
+// image index of the referrers tag schema.
+func referrersTagSBOM(
+	ctx context.Context, repository reference.Named, tag string, sys *types.SystemContext,
+) ([]byte, error) {
+	named, err := reference.WithTag(repository, tag)
+	if err != nil {
+		return nil, err
+	}
+	src, raw, err := openArtifact(ctx, named, sys)
+	if err != nil {
+		return nil, err
+	}
+	_ = src.Close()
+	var index imgspecv1.Index
+	if err := json.Unmarshal(raw, &index); err != nil {
+		return nil, err
+	}
+	for _, desc := range index.Manifests {
+		if !slices.Contains(sbomArtifactTypes, desc.ArtifactType) {
+			continue
+		}
+		referrer, err := reference.WithDigest(repository, desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		return firstLayer(ctx, referrer, sys)
+	}
+	return nil, fmt.Errorf("%w: no SBOM among the referrers of %s", ErrReferrerSBOM, named)
+}
+
+// cosignSBOM returns the first layer of the SBOM attached by cosign.
+func cosignSBOM(ctx context.Context, repository reference.Named, tag string, sys *types.SystemContext) ([]byte, error) {
+	named, err := reference.WithTag(repository, tag)
+	if err != nil {
+		return nil, err
+	}
+	return firstLayer(ctx, named, sys)
+}
+
+// openArtifact returns the source of the artifact and its manifest.
+func openArtifact(
+	ctx context.Context, named reference.Named, sys *types.SystemContext,
+) (types.ImageSource, []byte, error) {
+	ref, err := docker.NewReference(named)
+	if err != nil {
+		return nil, nil, err
+	}
+	src, err := ref.NewImageSource(ctx, sys)
+	if err != nil {
+		return nil, nil, err
+	}
+	raw, _, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		_ = src.Close()
+		return nil, nil, err
+	}
+	return src, raw, nil
+}
+
+// firstLayer returns the content of the first layer of the artifact manifest.
+func firstLayer(ctx context.Context, named reference.Named, sys *types.SystemContext) ([]byte, error) {
+	src, raw, err := openArtifact(ctx, named, sys)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	var manifest imgspecv1.Manifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return nil, err
+	}
+	if len(manifest.Layers) == 0 {
+		return nil, fmt.Errorf("%w: artifact %s has no layers", ErrReferrerSBOM, named)
+	}
+	layer := manifest.Layers[0]
+	if layer.Size > maxReferrerSBOMBytes {
+		return nil, fmt.Errorf("%w: SBOM of %s is larger than %d bytes", ErrReferrerSBOM, named, maxReferrerSBOMBytes)
+	}
+
+	blob, _, err := src.GetBlob(ctx, types.BlobInfo{Digest: layer.Digest, Size: layer.Size}, none.NoCache)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+	return io.ReadAll(io.LimitReader(blob, maxReferrerSBOMBytes))
+}
@@ -0,0 +1,38 @@
+// Credentials and certificates of requests to registries, see WithAuthFile
+// and WithCertDir.
+
+package capo
+
+import (
+	"go.podman.io/image/v5/types"
+)
+
+// Configure the scanner to read registry credentials from the auth file at
+// path, as buildah --authfile, for every request to registries (e.g. fetching
+// attached SBOMs, see WithReferrerSBOMs). Defaults to the usual containers
+// auth files, including $REGISTRY_AUTH_FILE.
+func WithAuthFile(path string) Option {
+	return func(s *Scanner) {
+		s.authFile = path
+	}
+}
+
+// Configure the scanner to verify registries with the certificates (*.crt)
+// and to authenticate with the client certificates (*.cert, *.key) in dir, as
+// buildah --cert-dir, for every request to registries. Defaults to the
+// per-registry directories of /etc/containers/certs.d.
+func WithCertDir(dir string) Option {
+	return func(s *Scanner) {
+		s.certDir = dir
+	}
+}
+
+// SystemContext returns the context of requests to registries with the auth
+// file and the certificate directory, as buildah --authfile and --cert-dir,
+// e.g. for PushReferrers. Returns nil, for the defaults, if both are empty.
+func SystemContext(authFile, certDir string) *types.SystemContext {
+	if authFile == "" && certDir == "" {
+		return nil
+	}
+	return &types.SystemContext{AuthFilePath: authFile, DockerCertPath: certDir}
+}
@@ -0,0 +1,39 @@
+//go:build unit
+
+package capo
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.podman.io/image/v5/types"
+)
+
+func TestSystemContext(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		authFile string
+		certDir  string
+		expected *types.SystemContext
+	}{
+		"defaults": {},
+		"auth file": {
+			authFile: "/run/secrets/auth.json",
+			expected: &types.SystemContext{AuthFilePath: "/run/secrets/auth.json"},
+		},
+		"auth file and cert dir": {
+			authFile: "/run/secrets/auth.json",
+			certDir:  "/etc/certs",
+			expected: &types.SystemContext{AuthFilePath: "/run/secrets/auth.json", DockerCertPath: "/etc/certs"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if diff := cmp.Diff(tc.expected, SystemContext(tc.authFile, tc.certDir)); diff != "" {
+				t.Errorf("SystemContext() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+// Content fetched from the network by ADD instructions with URL sources (ADD
+// https://... /dest), reported as packages of origin type remote-fetch.
+
+package capo
+
+import (
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+	"github.com/konflux-ci/capo/pkg/redact"
+)
+
+// remoteFetch is a remote source of an ADD instruction whose content can be in
+// the final image.
+type remoteFetch struct {
+	stage *containerfile.Stage
+	add   containerfile.RemoteAdd
+	// absolute path the content was fetched to in the stage
+	path string
+}
+
+// takeRemoteFetches returns the remote sources added in the final stage and
+// those added in builder stages at or under the sources traced to them
+// (builderStageAcc, by stage index), in the order of the stages. Traced
+// sources at or under the fetched paths are removed from builderStageAcc, as
+// their content isn't from the stage.
+func takeRemoteFetches(
+	cf containerfile.Containerfile,
+	builderStageAcc map[int][]string,
+	baseToWorkdir map[string]string,
+) []remoteFetch {
+	final := cf.FinalStage()
+	var res []remoteFetch
+	for i := range cf.Stages {
+		stage := &cf.Stages[i]
+		sources, traced := builderStageAcc[stage.Index]
+		if !traced && stage != final {
+			continue
+		}
+		baseWorkdir, ok := baseToWorkdir[stage.Base]
+		if !ok {
+			baseWorkdir = "/"
+		}
+
+		for _, add := range stage.RemoteAdds {
+			fetched := remoteFetchPath(add, baseWorkdir)
+			if stage != final && !slices.ContainsFunc(sources, func(source string) bool {
+				return isPathUnderPattern(source, fetched) || isPathUnderPattern(fetched, source)
+			}) {
+				continue
+			}
+			res = append(res, remoteFetch{stage: stage, add: add, path: fetched})
+			if traced {
+				sources = slices.DeleteFunc(sources, func(source string) bool {
+					return isPathUnderPattern(fetched, source)
+				})
+			}
+		}
+
+		if !traced {
+			continue
+		}
+		if len(sources) == 0 {
+			delete(builderStageAcc, stage.Index)
+		} else {
+			builderStageAcc[stage.Index] = sources
+		}
+	}
+	return res
+}
+
+// remoteFetchPath returns the absolute path the content of the remote source
+// is fetched to, in a stage whose base image has the working directory
+// baseWorkdir: named by the last element of the URL path under a destination
+// ending with "/", the destination itself otherwise.
+func remoteFetchPath(add containerfile.RemoteAdd, baseWorkdir string) string {
+	dest := add.Destination
+	if !path.IsAbs(dest) {
+		dest = resolveRelativePath(dest, add.Workdir, baseWorkdir)
+	}
+	if strings.HasSuffix(add.Destination, "/") {
+		return path.Join(dest, remoteName(add.URL))
+	}
+	return path.Clean(dest)
+}
+
+// remoteName returns the last element of the path of the URL, or of the whole
+// source if it isn't a URL (e.g. git@github.com:org/repo.git).
+func remoteName(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.Path != "" {
+		return path.Base(u.Path)
+	}
+	return path.Base(rawURL)
+}
+
+// remoteFetchPackages returns a package of origin type remote-fetch for every
+// fetch, with a generic purl qualified by the URL (download_url) and the
+// declared checksum, if any. Passwords in the URLs are masked by the
+// redactor.
+func remoteFetchPackages(fetches []remoteFetch, redactor *redact.Redactor) []PackageMetadataItem {
+	res := make([]PackageMetadataItem, 0, len(fetches))
+	for _, f := range fetches {
+		downloadURL := redactor.Value("", f.add.URL)
+		qualifiers := url.Values{"download_url": {downloadURL}}
+		item := PackageMetadataItem{
+			OriginType: originTypeRemoteFetch,
+			StageAlias: f.stage.Alias,
+		}
+		if f.add.Checksum != "" {
+			qualifiers.Set("checksum", f.add.Checksum)
+			item.Checksums = []string{f.add.Checksum}
+		}
+		item.PackageURL = "pkg:generic/" + url.PathEscape(remoteName(downloadURL)) + "?" + qualifiers.Encode()
+		item = withID(item, []string{f.path})
+		index := f.stage.Index
+		item.StageIndex = &index
+		res = append(res, item)
+	}
+	return res
+}
@@ -0,0 +1,159 @@
+// Retries of storage operations failing on transient contention, e.g. on
+// locks of the store held by parallel build steps.
+
+package capo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"syscall"
+	"time"
+
+	"go.podman.io/storage"
+)
+
+// ErrStorageContention is returned when a storage operation still fails with
+// a transient error after all attempts of the retry policy.
+var ErrStorageContention = fmt.Errorf("[ERR_STORAGE_CONTENTION] storage operation failed on contention: %w", ErrStorage)
+
+// RetryPolicy configures retries of storage operations failing with
+// transient errors (see IsTransientStorageError).
+type RetryPolicy struct {
+	// Attempts of an operation, including the first one. Values below 1
+	// are 1, the operation isn't retried.
+	Attempts int
+	// Wait before the first retry, doubled for every further retry up to
+	// MaxBackoff.
+	Backoff time.Duration
+	// Upper bound of the wait between retries. Non-positive values don't
+	// bound it.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy retries transient storage errors 4 times within about 3
+// seconds.
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:   5,
+	Backoff:    200 * time.Millisecond,
+	MaxBackoff: 2 * time.Second,
+}
+
+// Configure the retries of storage operations (image lookups, mounts, layer
+// diffs) failing with transient errors, e.g. on lock contention with
+// parallel steps using the same store. Defaults to DefaultRetryPolicy.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(s *Scanner) {
+		s.retryPolicy = &policy
+	}
+}
+
+// IsTransientStorageError returns whether the storage operation failed on
+// contention and may succeed when retried: on a lock or mount held by another
+// process (EAGAIN, EBUSY) or an interrupted system call (EINTR). Errors of
+// missing images and layers are not transient.
+func IsTransientStorageError(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.EINTR)
+}
+
+// retry runs op until it succeeds, fails with an error which is not
+// transient, or the attempts of the policy are used up, then wraps the error
+// with ErrStorageContention. Retries are logged with the name of the
+// operation.
+func (p RetryPolicy) retry(logger *slog.Logger, name string, sleep func(time.Duration), op func() error) error {
+	backoff := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || !IsTransientStorageError(err) {
+			return err
+		}
+		if attempt >= p.Attempts {
+			return fmt.Errorf("%w: %s after %d attempts: %w", ErrStorageContention, name, attempt, err)
+		}
+		logger.Debug("retrying storage operation", "operation", name, "attempt", attempt, "backoff", backoff, "error", err)
+		sleep(backoff)
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
+
+// retryStore retries the operations of the store capo uses by the policy.
+// Other methods are passed through.
+type retryStore struct {
+	storage.Store
+	policy RetryPolicy
+	logger *slog.Logger
+	sleep  func(time.Duration)
+}
+
+// newRetryStore wraps the store, retrying its operations by the policy.
+func newRetryStore(store storage.Store, policy RetryPolicy, logger *slog.Logger) *retryStore {
+	return &retryStore{Store: store, policy: policy, logger: logger, sleep: time.Sleep}
+}
+
+func (s *retryStore) Lookup(name string) (id string, err error) {
+	err = s.policy.retry(s.logger, "lookup", s.sleep, func() error {
+		id, err = s.Store.Lookup(name)
+		return err
+	})
+	return id, err
+}
+
+func (s *retryStore) Image(id string) (image *storage.Image, err error) {
+	err = s.policy.retry(s.logger, "image", s.sleep, func() error {
+		image, err = s.Store.Image(id)
+		return err
+	})
+	return image, err
+}
+
+func (s *retryStore) Images() (images []storage.Image, err error) {
+	err = s.policy.retry(s.logger, "images", s.sleep, func() error {
+		images, err = s.Store.Images()
+		return err
+	})
+	return images, err
+}
+
+func (s *retryStore) ImageBigData(id, key string) (data []byte, err error) {
+	err = s.policy.retry(s.logger, "image big data", s.sleep, func() error {
+		data, err = s.Store.ImageBigData(id, key)
+		return err
+	})
+	return data, err
+}
+
+func (s *retryStore) Layer(id string) (layer *storage.Layer, err error) {
+	err = s.policy.retry(s.logger, "layer", s.sleep, func() error {
+		layer, err = s.Store.Layer(id)
+		return err
+	})
+	return layer, err
+}
+
+func (s *retryStore) Diff(from, to string, options *storage.DiffOptions) (diff io.ReadCloser, err error) {
+	err = s.policy.retry(s.logger, "diff", s.sleep, func() error {
+		diff, err = s.Store.Diff(from, to, options)
+		return err
+	})
+	return diff, err
+}
+
+func (s *retryStore) MountImage(id string, mountOptions []string, mountLabel string) (path string, err error) {
+	err = s.policy.retry(s.logger, "mount", s.sleep, func() error {
+		path, err = s.Store.MountImage(id, mountOptions, mountLabel)
+		return err
+	})
+	return path, err
+}
+
+func (s *retryStore) UnmountImage(id string, force bool) (mounted bool, err error) {
+	err = s.policy.retry(s.logger, "unmount", s.sleep, func() error {
+		mounted, err = s.Store.UnmountImage(id, force)
+		return err
+	})
+	return mounted, err
+}
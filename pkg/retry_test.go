@@ -0,0 +1,115 @@
+//go:build unit
+
+package capo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.podman.io/storage"
+
+	"github.com/konflux-ci/capo/pkg/capotest"
+)
+
+// flakyStore fails the first lookups with the error.
+type flakyStore struct {
+	*capotest.Store
+	failures int
+	err      error
+}
+
+func (s *flakyStore) Lookup(name string) (string, error) {
+	if s.failures > 0 {
+		s.failures--
+		return "", fmt.Errorf("acquiring lock of the image store: %w", s.err)
+	}
+	return s.Store.Lookup(name)
+}
+
+func TestRetryStore(t *testing.T) {
+	t.Parallel()
+	policy := RetryPolicy{Attempts: 4, Backoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	tests := map[string]struct {
+		name            string
+		failures        int
+		err             error
+		expectedErr     error
+		expectedBackoff []time.Duration
+	}{
+		"no failures": {
+			name: "localhost/app:latest",
+		},
+		"transient failures": {
+			name:            "localhost/app:latest",
+			failures:        3,
+			err:             syscall.EAGAIN,
+			expectedBackoff: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond},
+		},
+		"attempts used up": {
+			name:            "localhost/app:latest",
+			failures:        4,
+			err:             syscall.EBUSY,
+			expectedErr:     ErrStorageContention,
+			expectedBackoff: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond},
+		},
+		"not transient": {
+			name:        "localhost/missing:latest",
+			expectedErr: storage.ErrImageUnknown,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			fake := capotest.NewStore().AddImage(storage.Image{ID: "app", Names: []string{"localhost/app:latest"}}, "")
+			store := newRetryStore(
+				&flakyStore{Store: fake, failures: tt.failures, err: tt.err},
+				policy,
+				slog.New(slog.NewTextHandler(io.Discard, nil)),
+			)
+			var backoff []time.Duration
+			store.sleep = func(d time.Duration) { backoff = append(backoff, d) }
+
+			id, err := store.Lookup(tt.name)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tt.expectedErr, err)
+			}
+			if err == nil && id != "app" {
+				t.Errorf("expected image app, got %q", id)
+			}
+			if diff := cmp.Diff(tt.expectedBackoff, backoff); diff != "" {
+				t.Errorf("backoff mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestIsTransientStorageError(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		err      error
+		expected bool
+	}{
+		"lock held":     {err: fmt.Errorf("locking: %w", syscall.EAGAIN), expected: true},
+		"mount busy":    {err: syscall.EBUSY, expected: true},
+		"interrupted":   {err: syscall.EINTR, expected: true},
+		"unknown image": {err: storage.ErrImageUnknown},
+		"permission":    {err: syscall.EACCES},
+		"no error":      {},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if got := IsTransientStorageError(tt.err); got != tt.expected {
+				t.Errorf("IsTransientStorageError(%v) = %v, want %v", tt.err, got, tt.expected)
+			}
+		})
+	}
+}
@@ -5,13 +5,21 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
-	"path/filepath"
+	"path"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/anchore/syft/syft/cataloging/pkgcataloging"
 	"github.com/konflux-ci/capo/internal/sbom"
+	"github.com/konflux-ci/capo/pkg/buildflags"
 	"github.com/konflux-ci/capo/pkg/containerfile"
+	"github.com/konflux-ci/capo/pkg/redact"
+	"github.com/konflux-ci/capo/pkg/stagereport"
 	"github.com/konflux-ci/capo/pkg/storageclient"
 
 	"github.com/opencontainers/go-digest"
@@ -22,8 +30,10 @@ import (
 
 // packageSource represents a root package source — either a builder stage
 // (with optional chained descendants) or an external image (COPY --from=image:tag).
-// External roots have external=true, no alias, index 0, and nil descendants.
+// External roots have kind StageKindExternal, no alias, index 0, and nil descendants.
 type packageSource struct {
+	// Kind of the source, StageKindBuilder or StageKindExternal.
+	kind containerfile.StageKind
 	// Index of this builder stage. Zero for external sources.
 	index int
 	// Stage alias. Empty for external sources.
@@ -34,11 +44,18 @@ type packageSource struct {
 	digestBase string
 	// Paths to content that should be syft-scanned.
 	sources []string
+	// Destinations of archives added from the build context in this stage.
+	// Always nil for external sources.
+	archiveDests []string
+	// Targets of cache mounts (RUN --mount=type=cache) in this stage. Always
+	// nil for external sources.
+	cacheTargets []string
+	// Targets of secret and ssh mounts in this stage. Always nil for external
+	// sources.
+	secretTargets []string
 	// Chained stages that use this stage (or its descendants) as base.
 	// Always nil for external sources.
 	descendants []*packageSourceDescendant
-	// True if this root represents an external image source, not a builder stage.
-	external bool
 }
 
 // packageSourceDescendant represents a chained builder stage - a descendant of a
@@ -50,53 +67,383 @@ type packageSourceDescendant struct {
 	alias string
 	// Paths to content that should be syft-scanned.
 	sources []string
+	// Destinations of archives added from the build context in this stage.
+	archiveDests []string
+	// Targets of cache mounts (RUN --mount=type=cache) in this stage.
+	cacheTargets []string
+	// Targets of secret and ssh mounts in this stage.
+	secretTargets []string
 	// Further chained stages.
 	descendants []*packageSourceDescendant
 }
 
+// empty reports whether no content is copied from the source or from any of
+// its chained stages, so there's nothing to scan.
+func (p packageSource) empty() bool {
+	return len(p.sources) == 0 && allEmpty(p.descendants)
+}
+
+// empty reports whether no content is copied from the stage or from any of
+// its chained stages.
+func (n *packageSourceDescendant) empty() bool {
+	return len(n.sources) == 0 && allEmpty(n.descendants)
+}
+
+func allEmpty(nodes []*packageSourceDescendant) bool {
+	for _, node := range nodes {
+		if !node.empty() {
+			return false
+		}
+	}
+	return true
+}
+
+// SkippedSource is a package source no content is copied from, neither from
+// it nor from its chained stages, so it's not scanned and has no packages.
+type SkippedSource struct {
+	// Alias of the stage, empty for external images.
+	Alias string `json:"alias,omitempty"`
+	// Pullspec of the image of the source as it appeared in the containerfile.
+	Pullspec string `json:"pullspec"`
+	// Why the source was skipped.
+	Note string `json:"note"`
+}
+
+const skippedSourceNote = "no content is copied from the source or its chained stages"
+
+// skippedSources returns the package sources skipped by the scan, in order.
+func skippedSources(sources []packageSource) []SkippedSource {
+	var res []SkippedSource
+	for _, source := range sources {
+		if source.empty() {
+			res = append(res, SkippedSource{Alias: source.alias, Pullspec: source.pullspec, Note: skippedSourceNote})
+		}
+	}
+	return res
+}
 
 type PackageMetadata struct {
 	Packages []PackageMetadataItem `json:"packages"`
+
+	// Tools which produced the output: capo and syft, see VersionInfo.Tools.
+	Tools []Tool `json:"tools,omitempty"`
+
+	// Driver of the container storage the images were read from, e.g.
+	// "overlay" or "vfs", as layer content can differ between drivers.
+	// Omitted if unknown.
+	StorageDriver string `json:"storage_driver,omitempty"`
+
+	// Problems found during the scan that did not prevent it, but may affect
+	// the attribution of packages. Omitted if there are none.
+	Warnings []Warning `json:"warnings,omitempty"`
+
+	// The buildah command used for the build, if known. Omitted otherwise.
+	Build *buildflags.Invocation `json:"build,omitempty"`
+
+	// The command the containerfile was preprocessed with, if any. Omitted
+	// otherwise.
+	Preprocessor *Preprocessor `json:"preprocessor,omitempty"`
+
+	// Owners of single files copied to the final image, sorted by origin and
+	// path. Only recorded with WithFileOwnership, omitted otherwise.
+	Files []FileMetadataItem `json:"files,omitempty"`
+
+	// Base image of the final stage. Only recorded with WithIncludeBase and
+	// omitted for special bases (e.g. scratch).
+	Base *BaseImage `json:"base,omitempty"`
+
+	// Provenance of the origin images from the labels of their configs,
+	// sorted by pullspec. Only recorded with WithOriginMetadata, omitted
+	// otherwise and if no origin image has the labels.
+	OriginMetadata []OriginMetadata `json:"origin_metadata,omitempty"`
+
+	// Effective build environment of every stage, in order.
+	Stages []StageMetadata `json:"stages,omitempty"`
+
+	// Builder stages the final stage doesn't depend on, in order. Omitted if
+	// there are none.
+	UnusedStages []UnusedStage `json:"unused_stages,omitempty"`
+
+	// Package sources which weren't scanned as no content is copied from
+	// them, in order. Omitted if there are none.
+	SkippedSources []SkippedSource `json:"skipped_sources,omitempty"`
+
+	// Labels of the final stage set by LABEL instructions, with keys selected
+	// by WithLabels. Omitted if there are none.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// How precisely the sources copied to the final image were attributed.
+	Coverage *Coverage `json:"coverage,omitempty"`
+
+	// Content of other stages and images mounted by RUN --mount=from=...
+	// instructions, in order. Only recorded with MountPolicyReport and
+	// MountPolicyScan, omitted otherwise.
+	BuildDependencies []BuildDependency `json:"build_dependencies,omitempty"`
+}
+
+// Warning is a non-fatal problem found during a scan.
+type Warning struct {
+	// Stable identifier of the kind of the problem, e.g. WarnDuplicateAlias.
+	Code string `json:"code"`
+	// Human-readable description of the problem.
+	Message string `json:"message"`
 }
 
+const (
+	// WarnDuplicateAlias is reported when a stage alias is defined more than once.
+	WarnDuplicateAlias = "WARN_DUPLICATE_ALIAS"
+	// WarnAmbiguousAlias is reported when a stage alias referenced by other
+	// stages is also the name of an image the containerfile references.
+	WarnAmbiguousAlias = "WARN_AMBIGUOUS_ALIAS"
+	// WarnUnpinnedImage is reported with PinPolicyWarn for every instruction
+	// referencing an image by a tag rather than a digest (see
+	// WithRequirePinned).
+	WarnUnpinnedImage = "WARN_UNPINNED_IMAGE"
+	// WarnSquashedImage is reported when an intermediate image doesn't build
+	// on the layers of its base image, e.g. because of buildah build --squash.
+	WarnSquashedImage = "WARN_SQUASHED_IMAGE"
+	// WarnUnusedBuildArg is reported when a build arg passed to the build
+	// isn't declared or used by any stage.
+	WarnUnusedBuildArg = "WARN_UNUSED_BUILD_ARG"
+	// WarnUnpinnedBuildArg is reported when the base image pullspec of a
+	// stage depends on build args which aren't passed to the build, so on
+	// their defaults in the containerfile.
+	WarnUnpinnedBuildArg = "WARN_UNPINNED_BUILD_ARG"
+	// WarnEmptyCopy is reported when a source copied to a scratch final
+	// stage resulted in no packages (see WithVerifyScratch).
+	WarnEmptyCopy = "WARN_EMPTY_COPY"
+	// WarnUnattributedCopy is reported when a source copied to a scratch
+	// final stage isn't attributed (see WithVerifyScratch).
+	WarnUnattributedCopy = "WARN_UNATTRIBUTED_COPY"
+	// WarnScanTimeout is reported when scanning a package source or the base
+	// image exceeded the scan timeout (see WithScanTimeout). Its packages are
+	// missing from the output.
+	WarnScanTimeout = "WARN_SCAN_TIMEOUT"
+	// WarnHintPathMissing is reported when a path of a hint doesn't exist in
+	// the intermediate image of its stage (see WithHints). The hint is
+	// skipped.
+	WarnHintPathMissing = "WARN_HINT_PATH_MISSING"
+)
+
+const (
+	originTypeSquashed       = "squashed"
+	originTypeContextArchive = "context-archive"
+	originTypeBase           = "base"
+	originTypeContext        = "context"
+	originTypeRemoteFetch    = "remote-fetch"
+)
+
 type PackageMetadataItem struct {
+	// Stable identifier of the package instance, a hash of its purl,
+	// pullspec, origin type, stage alias and the paths it was found in, which
+	// is the same across scans of unchanged origins.
+	ID string `json:"id"`
+
 	PackageURL string `json:"purl"`
 
 	// Slice of checksums, with checksum type prefixed (e.g. "sha256:deadbeef").
 	// Omitted if syft didn't provide any checksums.
 	Checksums []string `json:"checksums,omitempty"`
 
+	// Licenses of the package found by syft, as SPDX expressions (e.g.
+	// "GPL-3.0-or-later") where known. Omitted if none were found.
+	Licenses []string `json:"licenses,omitempty"`
+
 	// PURL of the package that this package is a dependency of.
 	// Used for resolution of relationships if one package is
 	// found multiple times as a dependency of different packages.
 	DependencyOfPURL string `json:"dependency_of_purl,omitempty"`
 
-	// Type of origin of this package, can be "builder", "intermediate" or "external".
+	// Type of origin of this package, can be "builder", "intermediate",
+	// "external", "squashed", "context-archive", "context", "base",
+	// "remote-fetch", "history" or "post-scan". Squashed packages
+	// come from an intermediate image built with --squash, which can't be
+	// split into builder and intermediate content. Context-archive packages
+	// were found in a stage under the destination of an archive added from the
+	// build context (ADD foo.tar.gz /opt/), their true source is unknown.
+	// Base packages were found in the final stage base image (see
+	// WithScanBase). Context packages are from the SBOM of the build context
+	// (see WithSourceSBOM). Remote-fetch packages are content ADD instructions
+	// fetched from a URL into the final stage or into builder content copied
+	// to it, with the URL and the declared checksum (ADD --checksum) as
+	// qualifiers of a generic purl. History packages were found in layers of
+	// the built image copied from a stage without an intermediate image (see
+	// WithBuiltImage). Post-scan packages were found in a built image at the
+	// destination of a copy from a builder stage (see Scanner.PostScan).
 	OriginType string `json:"origin_type"`
 
 	// Pullspec of the image with digest which is this package's origin.
 	Pullspec string `json:"pullspec"`
 
+	// Pullspec of the origin image as written in the containerfile, if the
+	// image was pulled by another pullspec (see WithPullspecMap). Omitted
+	// otherwise.
+	OriginalPullspec string `json:"original_pullspec,omitempty"`
+
 	// Alias of the stage of this package's origin.
 	// Omitted if this package is from an external image.
 	StageAlias string `json:"stage_alias,omitempty"`
+
+	// Zero-based index of the stage of this package's origin, which
+	// identifies the stage also when its alias is defined more than once.
+	// Omitted if this package is from an external image.
+	StageIndex *int `json:"stage_index,omitempty"`
+
+	// Digest of the manifest list (image index) the origin image was pulled
+	// through. The digest in Pullspec is of the platform-specific manifest
+	// then. Omitted for images pulled by their manifest directly.
+	IndexDigest string `json:"index_digest,omitempty"`
+
+	// How the package was found, if not by scanning the copied content:
+	// "package-db-lookup" for packages owning copied builder or external
+	// content according to the package database of the origin image,
+	// "source-sbom" for packages of the SBOM of the build context,
+	// "referrer-sbom" for packages of external content from the SBOM attached
+	// to the image (see WithReferrerSBOMs), "filtered-sbom" for packages
+	// selected by FilterSBOM. Omitted otherwise.
+	FoundBy string `json:"found_by,omitempty"`
+
+	// Whether the package is only in content mounted by RUN --mount=from=...
+	// instructions, which the build used but which isn't in the final image
+	// (see MountPolicyScan). Omitted if false.
+	BuildTime bool `json:"build_time,omitempty"`
+
+	// Known vulnerabilities of the package, sorted by ID. Only recorded by a
+	// vulnerability scan hook (see GrypeHook), omitted otherwise.
+	Vulnerabilities []Vulnerability `json:"vulnerabilities,omitempty"`
+
+	// Chains of COPY instructions through which content of the origin of
+	// the package was traced from the final stage, each starting with the
+	// instruction of the final stage. Only recorded with WithExplain, omitted
+	// otherwise and for packages of other origin types than builder,
+	// intermediate, squashed, context-archive and external.
+	Provenance [][]CopyStep `json:"provenance,omitempty"`
+
+	// Whether a package with the same purl is in the final stage base image
+	// (see WithBaseSBOM), so consumers merging the SBOM of the base image
+	// don't count it twice. Omitted if false.
+	DuplicateOfBase bool `json:"duplicate_of_base,omitempty"`
 }
 
-var ErrStorageSetup = errors.New("[ERR_STORAGE_SETUP] failed to set up container storage")
-var ErrPullspecResolve = errors.New("[ERR_PULLSPEC_RESOLVE] failed to resolve pullspec")
-var ErrOCIConfig = errors.New("[ERR_OCI_CONFIG] failed to get OCI image config")
-var ErrSBOMScan = errors.New("[ERR_SBOM_SCAN] SBOM scan failed")
+var ErrStorageSetup = fmt.Errorf("[ERR_STORAGE_SETUP] failed to set up container storage: %w", ErrStorage)
+var ErrPullspecResolve = fmt.Errorf("[ERR_PULLSPEC_RESOLVE] failed to resolve pullspec: %w", ErrResolve)
+var ErrOCIConfig = fmt.Errorf("[ERR_OCI_CONFIG] failed to get OCI image config: %w", ErrResolve)
+var ErrSBOMScan = fmt.Errorf("[ERR_SBOM_SCAN] SBOM scan failed: %w", ErrScan)
 
 // Scanner exposes methods used for scanning of buildah image builds, assigning
 // image origins to SBOM packages present in a built image.
 type Scanner struct {
-	logger            *slog.Logger
-	sclient           storageclient.Client
-	store             storage.Store
+	logger  *slog.Logger
+	sclient storageclient.Client
+	store   storage.Store
+	// Image mounts shared by package sources, valid for the duration of a Scan.
+	mounts *mountManager
+	// Warnings recorded during a Scan, reported in its output.
+	warnings   []Warning
+	warningsMu sync.Mutex
+	// Handler of events of a Scan, see WithEventHandler.
+	eventHandler func(Event)
+	eventsMu     sync.Mutex
+	// Hooks run on the output of a Scan, see WithPostScanHooks.
+	postScanHooks []PostScanHook
+	// Layout of kept extracted content during a Scan in debug mode, nil
+	// otherwise (see debugEnv).
+	debug *debugLayout
+	// Owners of copied files recorded during a Scan, if fileOwnership is set.
+	files         []FileMetadataItem
+	filesMu       sync.Mutex
+	fileOwnership bool
+	// owners of extracted files during a Scan, if fileOwnership is set
+	fileOwners *fileOwners
+
+	// number of catalogers run at the same time by syft, see
+	// WithSyftParallelism
+	syftParallelism int
+	// longest time of the syft scans of a source, see WithScanTimeout
+	scanTimeout time.Duration
+	// guarantee no network access during the scan
+	offline bool
+	// record the final stage base image, and scan it with scanBase
+	includeBase bool
+	scanBase    bool
+	// platform of images resolved from manifest lists
+	platform storageclient.Platform
+	// redaction of secret-looking build args in logs and output
+	redactor *redact.Redactor
+	// path to an SBOM of the build context, see WithSourceSBOM
+	sourceSBOM string
+	// path to an SBOM of the final stage base image, see WithBaseSBOM
+	baseSBOM string
+	// handling of content mounted by RUN --mount=from, see WithMountPolicy
+	mountPolicy MountPolicy
+	// path to a syft cataloger configuration file, see WithSyftConfig
+	syftConfig string
+	// patterns of paths syft doesn't walk, see WithExcludePaths
+	excludePaths []string
+	// keys of final stage labels to record, see WithLabels
+	labelPatterns []*regexp.Regexp
+	// images committed for the stages of the build, see WithStageReport
+	stageReport *stagereport.Report
+	// built image to attribute content of stages without intermediate
+	// images by, see WithBuiltImage
+	builtImage string
+	// record the provenance of packages, see WithExplain
+	explain bool
+	// log matcher decisions of extraction, see WithTraceMatching
+	traceMatching bool
+	matchTrace    *matchTracer
+	// content produced by RUN instructions, see WithHints
+	hints Hints
+	// take packages of external images from their SBOMs, see
+	// WithReferrerSBOMs
+	referrerSBOMs bool
+	// credentials and certificates of requests to registries, see
+	// WithAuthFile and WithCertDir
+	authFile string
+	certDir  string
+	// record labels of origin images, see WithOriginMetadata
+	originMetadata bool
+	// verify copies to a scratch final stage, see WithVerifyScratch
+	verifyScratch bool
+	// skip stages the final stage doesn't depend on, see WithPruneUnused
+	pruneUnused bool
+	// handling of images not pinned by digest, see WithRequirePinned
+	requirePinned PinPolicy
+	// pullspecs the images of the containerfile were pulled by, see
+	// WithPullspecMap
+	pullspecMap PullspecMap
+	// options of the container storage, see WithStoreOptions
+	storeOptions *storage.StoreOptions
+	// name of the driver of the opened store, reported in the output
+	storageDriver string
+	// retries of storage operations, see WithRetryPolicy
+	retryPolicy *RetryPolicy
+	// time to wait for the lock of the store, see WithWaitForStore
+	storeLockTimeout time.Duration
+	// packages of sources of previous scans, see WithStateFile
+	stateFile string
+	state     *scanState
+	// destination of extracted content, see WithExportContent
+	exportContent string
+	contentExport *contentExport
+	// extraction of content of images, see WithExtractor
+	extractorKind ExtractorKind
+	// run without mount privileges or a user namespace, see WithUnprivileged
+	unprivileged bool
+
+	// limits of content extracted from a single layer diff
+	maxFileBytes    int64
+	maxExtractBytes int64
+	// permission bits cleared from modes of extracted content
+	permMask os.FileMode
 
 	// syft configuration
 	syftScanner sbom.SyftScanner
-	selectCatalogers  []string
+	// scanner of package databases of origin images, see getPackageDBContent
+	packageDBScanner sbom.SyftScanner
+	// packages of content scanned during the current Scan, see scanContent
+	scanMemo             *scanMemo
+	selectCatalogers     []string
 	defaultCatalogersTag string
 }
 
@@ -127,57 +474,271 @@ func WithDefaultCatalogersTag(tag string) Option {
 	}
 }
 
+// Configure the limits of bytes written when extracting content from a layer
+// diff: maxFileBytes for a single file and maxExtractBytes for all files of
+// the diff. Non-positive values keep the defaults (DefaultMaxFileBytes and
+// DefaultMaxExtractBytes).
+func WithExtractLimits(maxFileBytes, maxExtractBytes int64) Option {
+	return func(s *Scanner) {
+		s.maxFileBytes = maxFileBytes
+		s.maxExtractBytes = maxExtractBytes
+	}
+}
+
+// Configure the mask of permission bits cleared from the original modes of
+// content extracted for scanning, like a umask. Defaults to
+// DefaultExtractPermMask. The owner permissions needed by capo to read and
+// clean up the content are kept regardless of the mask.
+func WithExtractPermMask(mask os.FileMode) Option {
+	return func(s *Scanner) {
+		s.permMask = mask.Perm()
+	}
+}
+
+// Configure the number of syft catalogers run at the same time by a scan of
+// content. Syft's default if not positive.
+func WithSyftParallelism(n int) Option {
+	return func(s *Scanner) {
+		s.syftParallelism = n
+	}
+}
+
+// Configure the longest time the syft scans of a root package source (with
+// its chained descendants) or of the base image may take. A source exceeding
+// it is reported by a WarnScanTimeout warning instead of failing or hanging
+// the scan, and left out of the output and the state file. Catalogers not
+// checking for the timeout are abandoned and finish in the background.
+// Disabled if not positive.
+func WithScanTimeout(timeout time.Duration) Option {
+	return func(s *Scanner) {
+		s.scanTimeout = timeout
+	}
+}
+
+// Configure the scanner to guarantee no network access. Capo never pulls
+// images, in offline mode the scan additionally fails with ErrOriginMissing
+// listing all origin images missing from local storage upfront, and syft
+// catalogers are kept from looking up data (e.g. licenses) remotely.
+func WithOffline(offline bool) Option {
+	return func(s *Scanner) {
+		s.offline = offline
+	}
+}
+
+// Configure the platform the images were built for (buildah --platform), used
+// to resolve images pulled through manifest lists to the platform-specific
+// manifest. Defaults to the platform capo runs on.
+func WithPlatform(platform storageclient.Platform) Option {
+	return func(s *Scanner) {
+		s.platform = platform
+	}
+}
+
+// Configure the redaction of values of secret-looking build args and
+// environment variables in logs and PackageMetadata.Stages. Defaults to
+// redact.Default().
+func WithRedactor(redactor *redact.Redactor) Option {
+	return func(s *Scanner) {
+		s.redactor = redactor
+	}
+}
+
+// Configure the scanner to record the final stage base image and its digest
+// in PackageMetadata.Base. Its content is not scanned, see WithScanBase.
+func WithIncludeBase(includeBase bool) Option {
+	return func(s *Scanner) {
+		s.includeBase = includeBase
+	}
+}
+
+// Configure the scanner to run a full syft scan of the final stage base image
+// and add its packages with the "base" origin type, so the output covers the
+// whole final image. Implies WithIncludeBase.
+func WithScanBase(scanBase bool) Option {
+	return func(s *Scanner) {
+		s.scanBase = scanBase
+		if scanBase {
+			s.includeBase = true
+		}
+	}
+}
+
+// Configure the scanner to record the owning package of every file copied to
+// the final image in PackageMetadata.Files, with the user and group owning
+// the file in its origin image.
+func WithFileOwnership(fileOwnership bool) Option {
+	return func(s *Scanner) {
+		s.fileOwnership = fileOwnership
+	}
+}
+
+// Configure the scanner to attribute content copied to the final stage from
+// the build context (COPY without --from) to the packages of the SBOM at path,
+// previously generated from the build context (e.g. by cachi2 or syft). Such
+// packages are added with the "context" origin type.
+func WithSourceSBOM(path string) Option {
+	return func(s *Scanner) {
+		s.sourceSBOM = path
+	}
+}
+
+// Configure how the scanner handles content of other stages and images
+// mounted by RUN --mount=from=... instructions, which the build uses but which
+// isn't in the final image. MountPolicyFail by default.
+func WithMountPolicy(policy MountPolicy) Option {
+	return func(s *Scanner) {
+		s.mountPolicy = policy
+	}
+}
+
+// Configure the scanner to read the configuration of syft catalogers from the
+// YAML file at path (see sbom.ReadConfigFile), e.g. to select binary
+// classifiers. In offline mode (see WithOffline), options of the file which
+// access the network are turned off.
+func WithSyftConfig(path string) Option {
+	return func(s *Scanner) {
+		s.syftConfig = path
+	}
+}
+
+// Configure glob patterns of paths syft doesn't walk in the scanned content,
+// relative to its root and starting with "./", "*/" or "**/" (e.g.
+// "./usr/share/doc/**"). Packages under the paths are missing from the output,
+// but scans of whole images (see WithScanBase and MountPolicyScan) take less
+// time and memory.
+func WithExcludePaths(patterns ...string) Option {
+	return func(s *Scanner) {
+		s.excludePaths = patterns
+	}
+}
+
+// Configure the scanner to take the intermediate images of stages from the
+// stage report of the build (see the stagereport package) instead of finding
+// them by their io.buildah.stage.name label. Stages without an image in the
+// report are still found by their label. Disabled if nil.
+func WithStageReport(report *stagereport.Report) Option {
+	return func(s *Scanner) {
+		s.stageReport = report
+	}
+}
+
+// Configure the scanner to open the containers/storage store with opts. By
+// default, the store is opened with the options buildah uses in the same
+// environment, see storageclient.DefaultStoreOptions.
+func WithStoreOptions(opts storage.StoreOptions) Option {
+	return func(s *Scanner) {
+		s.storeOptions = &opts
+	}
+}
+
 // Create a new Scanner with the specified options or fail if an error occurred
-// while trying to set up the containers/storage store.
+// while trying to set up the containers/storage store or to read the syft
+// configuration.
 func NewScanner(opts ...Option) (*Scanner, error) {
+	s := &Scanner{
+		logger:           slog.Default(),
+		selectCatalogers: []string{},
+		permMask:         DefaultExtractPermMask,
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
 	// Tech debt: Scanner uses both the storageclient (for
 	// resolving pullspecs and fetching OCIImageConfigs) that uses
 	// storage.Store internally and the raw storage.Store struct. This was
 	// done for ease of testing some features via a mock client. Ideally we
 	// would only have the storageclient implementation, so we had full control
 	// over unit testing.
-	store, err := setupStore()
+	if err := s.setupUnprivileged(); err != nil {
+		return nil, err
+	}
+	store, err := setupStore(s.storeOptions, s.unprivileged)
 	if err != nil {
 		return nil, err
 	}
-
-	sclient := storageclient.NewBuildahClient(store)
-
-	s := &Scanner{
-		logger:  slog.Default(),
-		sclient: sclient,
-		store:   store,
-		selectCatalogers: []string{},
+	if s.retryPolicy == nil {
+		policy := DefaultRetryPolicy
+		s.retryPolicy = &policy
 	}
+	s.store = newRetryStore(store, *s.retryPolicy, s.logger)
+	s.storageDriver = store.GraphDriverName()
+	s.logger.Debug("opened container storage",
+		"driver", s.storageDriver, "graphRoot", store.GraphRoot(), "runRoot", store.RunRoot())
 
-	for _, o := range opts {
-		o(s)
-	}
+	s.sclient = storageclient.NewBuildahClient(s.store, storageclient.WithPlatform(s.platform))
 
 	if s.defaultCatalogersTag == "" {
 		s.defaultCatalogersTag = pkgcataloging.ImageTag
 	}
 
-	s.syftScanner = sbom.NewSyftScanner(
+	if s.maxFileBytes <= 0 {
+		s.maxFileBytes = DefaultMaxFileBytes
+	}
+	if s.maxExtractBytes <= 0 {
+		s.maxExtractBytes = DefaultMaxExtractBytes
+	}
+	if s.redactor == nil {
+		s.redactor = redact.Default()
+	}
+	if s.mountPolicy == "" {
+		s.mountPolicy = MountPolicyFail
+	}
+	if s.extractorKind == "" {
+		s.extractorKind = ExtractorMount
+	}
+
+	syftOpts := []sbom.Option{
 		sbom.WithSelectCatalogers(s.selectCatalogers...),
 		sbom.WithDefaultCatalogersTag(s.defaultCatalogersTag),
+		sbom.WithOffline(s.offline),
+		sbom.WithParallelism(s.syftParallelism),
+		sbom.WithExcludePaths(s.excludePaths...),
+	}
+	if err := sbom.ValidateExcludePaths(s.excludePaths); err != nil {
+		return nil, err
+	}
+	if s.syftConfig != "" {
+		packagesConfig, err := sbom.ReadConfigFile(s.syftConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read syft config: %w", err)
+		}
+		syftOpts = append(syftOpts, sbom.WithPackagesConfig(packagesConfig))
+	}
+	s.syftScanner = sbom.NewSyftScanner(syftOpts...)
+	s.packageDBScanner = sbom.NewSyftScanner(
+		sbom.WithSelectCatalogers(packageDBCatalogers...),
+		sbom.WithDefaultCatalogersTag(pkgcataloging.ImageTag),
+		sbom.WithOffline(s.offline),
+		sbom.WithParallelism(s.syftParallelism),
 	)
 
 	return s, nil
 }
 
-func setupStore() (storage.Store, error) {
+// setupStore opens the containers/storage store with opts, or with the
+// options buildah uses in the same environment if nil. If unprivileged, the
+// driver is set up without mounts needing privileges, see WithUnprivileged.
+func setupStore(opts *storage.StoreOptions, unprivileged bool) (storage.Store, error) {
 	// The containers/storage library requires this to run for some operations
 	if reexec.Init() {
 		return nil, fmt.Errorf("failed to init reexec: %w", ErrStorageSetup)
 	}
 
-	opts, err := storage.DefaultStoreOptions()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create default storage options: %w: %w", err, ErrStorageSetup)
+	if opts == nil {
+		defaults, err := storageclient.DefaultStoreOptions()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create default storage options: %w: %w", err, ErrStorageSetup)
+		}
+		opts = &defaults
+	}
+	if unprivileged {
+		unprivilegedOpts := unprivilegedStoreOptions(*opts)
+		opts = &unprivilegedOpts
 	}
 
-	store, err := storage.GetStore(opts)
+	store, err := storage.GetStore(*opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create storage: %w: %w", err, ErrStorageSetup)
 	}
@@ -191,80 +752,478 @@ func setupStore() (storage.Store, error) {
 // for resolution by Mobster.
 func (s *Scanner) Scan(
 	cf containerfile.Containerfile,
-) (PackageMetadata, error) {
-	if err := preflightCheck(cf); err != nil {
+) (_ PackageMetadata, err error) {
+	if err := preflightCheck(cf, s.mountPolicy); err != nil {
+		return PackageMetadata{}, err
+	}
+	// images are looked up by the pullspecs they were pulled by, the output
+	// reports the stages as written
+	written := cf
+	cf = s.mapPullspecs(cf)
+	// base images of FROM --platform are resolved for the flag's platform
+	platforms, err := stagePlatforms(cf)
+	if err != nil {
+		return PackageMetadata{}, err
+	}
+	// every image is looked up in storage once during the scan
+	sclient := storageclient.WithCache(storageclient.WithRefPlatforms(s.sclient, platforms))
+
+	if s.storeLockTimeout > 0 {
+		unlock, err := lockStore(s.store, s.storeLockTimeout)
+		if err != nil {
+			return PackageMetadata{}, err
+		}
+		defer func() { _ = unlock() }()
+	}
+	// intermediate images are found among all images, which a concurrent
+	// build may change
+	storeImagesBefore, err := storeImages(s.store)
+	if err != nil {
 		return PackageMetadata{}, err
 	}
 
+	s.state = nil
+	if s.stateFile != "" {
+		if s.state, err = s.loadState(); err != nil {
+			return PackageMetadata{}, err
+		}
+		defer func() { s.state = nil }()
+	}
+
+	start := time.Now()
+	var stats *ScanStats
+	s.debug = nil
+	if os.Getenv(debugEnv) != "" {
+		if s.debug, err = newDebugLayout(); err != nil {
+			return PackageMetadata{}, fmt.Errorf("failed to create debug directory: %w: %w", err, ErrIO)
+		}
+	}
+	s.contentExport = nil
+	if s.exportContent != "" {
+		if s.contentExport, err = newContentExport(s.exportContent); err != nil {
+			return PackageMetadata{}, err
+		}
+	}
+	s.mounts = newMountManager(s.store, s.logger)
+	defer func() {
+		if closeErr := s.mounts.close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		if s.contentExport != nil {
+			if closeErr := s.contentExport.close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+			s.contentExport = nil
+		}
+		if s.debug != nil {
+			if summaryErr := s.debug.writeSummary(os.Stderr); summaryErr != nil {
+				s.logger.Warn("failed to print debug directories", "error", summaryErr)
+			}
+		}
+		if err == nil {
+			s.emit(Event{Type: EventStats, Stats: stats})
+		}
+	}()
+
 	res := PackageMetadata{
-		Packages: make([]PackageMetadataItem, 0),
+		Packages:      make([]PackageMetadataItem, 0),
+		Tools:         Version().Tools(),
+		StorageDriver: s.storageDriver,
+		Stages:        getStageMetadata(written, s.redactor),
+		UnusedStages:  unusedStages(cf),
+		Labels:        s.getFinalLabels(cf),
+	}
+	s.logger.Debug("parsed containerfile stages", "stages", RedactStages(cf.Stages, s.redactor))
+
+	s.warnings = nil
+	s.files = nil
+	s.fileOwners = nil
+	if s.fileOwnership {
+		s.fileOwners = newFileOwners()
+	}
+	s.scanMemo = newScanMemo()
+	s.matchTrace = nil
+	if s.traceMatching {
+		s.matchTrace = newMatchTracer(s.logger)
+		defer s.matchTrace.flush()
+	}
+	for _, w := range slices.Concat(duplicateAliasWarnings(cf), ambiguousAliasWarnings(cf), buildArgWarnings(cf)) {
+		s.warn(w.Code, w.Message)
+	}
+	if err := s.checkPinned(written); err != nil {
+		return PackageMetadata{}, err
+	}
+	if cf, err = s.applyHints(cf); err != nil {
+		return PackageMetadata{}, err
+	}
+
+	// read before the scan, so an invalid file doesn't waste it
+	var sourcePackages []sbom.SyftPackage
+	if s.sourceSBOM != "" {
+		sourcePackages, err = sbom.ReadFile(s.sourceSBOM)
+		if err != nil {
+			return PackageMetadata{}, fmt.Errorf("%w: %w", ErrSourceSBOM, err)
+		}
+	}
+	var baseSBOMPackages []sbom.SyftPackage
+	if s.baseSBOM != "" {
+		baseSBOMPackages, err = sbom.ReadFile(s.baseSBOM)
+		if err != nil {
+			return PackageMetadata{}, fmt.Errorf("%w: %w", ErrBaseSBOM, err)
+		}
+	}
+
+	if s.offline {
+		if err := checkOfflineOrigins(sclient, cf, s.includeBase); err != nil {
+			return PackageMetadata{}, err
+		}
 	}
-	s.logger.Debug("parsed containerfile stages", "stages", cf.Stages)
 
-	digests, err := getImageDigests(s.sclient, cf)
+	digests, err := getImageDigests(sclient, cf)
+	if err != nil {
+		return PackageMetadata{}, err
+	}
+	reportMounts := s.mountPolicy == MountPolicyReport || s.mountPolicy == MountPolicyScan
+	if reportMounts {
+		if err := getMountDigests(sclient, cf, digests); err != nil {
+			return PackageMetadata{}, err
+		}
+	}
+	indexDigests, err := getIndexDigests(sclient, digests)
+	if err != nil {
+		return PackageMetadata{}, err
+	}
+	originals, err := s.getOriginalPullspecs(digests)
 	if err != nil {
 		return PackageMetadata{}, err
 	}
 
-	packageSources, err := getPackageSources(s.sclient, cf, digests)
+	if s.includeBase {
+		res.Base, err = getBaseImage(sclient, cf)
+		if err != nil {
+			return PackageMetadata{}, err
+		}
+		if res.Base != nil && res.Base.Pullspec != written.FinalStage().Base {
+			res.Base.OriginalPullspec = written.FinalStage().Base
+		}
+	}
+	if s.originMetadata {
+		origins := maps.Clone(digests)
+		if res.Base != nil {
+			origins[res.Base.Pullspec] = digest.Digest(res.Base.Digest)
+		}
+		if res.OriginMetadata, err = getOriginMetadata(sclient, origins); err != nil {
+			return PackageMetadata{}, err
+		}
+	}
+
+	packageSources, fetches, coverage, err := getPackageSources(sclient, cf, digests, s.redactor)
 	if err != nil {
 		return PackageMetadata{}, err
 	}
+	res.Coverage = coverage
+	if s.sourceSBOM != "" {
+		res.Coverage = coverage.withSourceSBOM()
+	}
+	if s.pruneUnused {
+		packageSources = pruneUnused(packageSources, res.UnusedStages)
+	}
+	res.SkippedSources = skippedSources(packageSources)
 	s.logPackageSources(packageSources)
 	s.logger.Debug("syft config", "defaultTag", s.defaultCatalogersTag, "selection", s.selectCatalogers)
 
-	for _, source := range packageSources {
-		items, err := s.scanBuilderStageTree(source)
+	// the provenance is also needed to verify a scratch final stage
+	var explained *provenance
+	if s.explain || s.verifyScratch {
+		baseToWorkdir, err := getBaseWorkdirs(sclient, cf)
+		if err != nil {
+			return PackageMetadata{}, err
+		}
+		explained = explainSources(cf, baseToWorkdir, digests)
+	}
+
+	// scanned before the sources, so their packages also in the base image
+	// are marked before they're reported
+	var baseItems []PackageMetadataItem
+	if s.scanBase && res.Base != nil {
+		baseItems, err = s.scanBaseImage(*res.Base)
+		if err != nil {
+			return PackageMetadata{}, err
+		}
+	}
+	base := basePURLs(baseSBOMPackages, baseItems)
+
+	items, err := s.scanPackageSources(packageSources, func(items []PackageMetadataItem) {
+		setIndexDigests(items, indexDigests)
+		setOriginalPullspecs(items, originals)
+		if s.explain {
+			explained.apply(items)
+		}
+		markDuplicatesOfBase(items, base)
+	})
+	if err != nil {
+		return PackageMetadata{}, err
+	}
+	if s.builtImage != "" {
+		ctx, cancel := s.scanContext()
+		historyItems, err := s.scanHistoryCopies(ctx, packageSources, items, cf.FinalStage().Base)
+		cancel()
+		if err != nil {
+			return PackageMetadata{}, err
+		}
+		setIndexDigests(historyItems, indexDigests)
+		setOriginalPullspecs(historyItems, originals)
+		markDuplicatesOfBase(historyItems, base)
+		s.emitPackages(historyItems)
+		items = append(items, historyItems...)
+	}
+	if s.verifyScratch {
+		for _, w := range verifyScratch(cf.FinalStage(), explained, items, res.Coverage) {
+			s.warn(w.Code, w.Message)
+		}
+	}
+	res.Packages = append(res.Packages, items...)
+	if len(fetches) > 0 {
+		fetchItems := remoteFetchPackages(fetches, s.redactor)
+		markDuplicatesOfBase(fetchItems, base)
+		s.emitPackages(fetchItems)
+		res.Packages = append(res.Packages, fetchItems...)
+	}
+	if s.sourceSBOM != "" {
+		contextItems := contextPackages(sourcePackages, cf.FinalStage().ContextCopies)
+		markDuplicatesOfBase(contextItems, base)
+		s.emitPackages(contextItems)
+		res.Packages = append(res.Packages, contextItems...)
+	}
+
+	if reportMounts {
+		res.BuildDependencies, err = getBuildDependencies(cf, digests)
+		if err != nil {
+			return PackageMetadata{}, err
+		}
+	}
+	if s.mountPolicy == MountPolicyScan {
+		// reported to the event handler by scanPackageSources, with BuildTime set
+		mountItems, err := s.scanBuildMounts(cf, digests, indexDigests, originals)
+		if err != nil {
+			return PackageMetadata{}, err
+		}
+		res.Packages = append(res.Packages, mountItems...)
+	}
+
+	if len(baseItems) > 0 {
+		s.emitPackages(baseItems)
+		res.Packages = append(res.Packages, baseItems...)
+	}
+
+	if err := checkStoreImages(s.store, storeImagesBefore); err != nil {
+		return PackageMetadata{}, err
+	}
+
+	res.Warnings = s.warnings
+	if s.fileOwnership {
+		sortFileMetadata(s.files)
+		res.Files = append(make([]FileMetadataItem, 0, len(s.files)), s.files...)
+	}
+
+	if err := s.runPostScanHooks(context.Background(), &res); err != nil {
+		return PackageMetadata{}, err
+	}
+
+	var reusedSources int
+	if s.state != nil {
+		if err := s.state.write(s.stateFile); err != nil {
+			return PackageMetadata{}, err
+		}
+		reusedSources = s.state.reused
+	}
+
+	stats = &ScanStats{
+		Packages:        len(res.Packages),
+		Warnings:        len(res.Warnings),
+		PackageSources:  len(packageSources),
+		ReusedSources:   reusedSources,
+		DurationSeconds: time.Since(start).Seconds(),
+	}
+	return res, nil
+}
+
+// scanPackageSources scans the package sources one after another. Packages
+// are returned in the order of the sources. With a state file, packages of unchanged sources are reused instead
+// (see WithStateFile).
+func (s *Scanner) scanPackageSources(
+	packageSources []packageSource,
+	finish func([]PackageMetadataItem),
+) ([]PackageMetadataItem, error) {
+	res := make([]PackageMetadataItem, 0)
+	// keys in the state of sources scanned anew, and their unfinished packages
+	keys := make([]string, len(packageSources))
+	scanned := make([][]PackageMetadataItem, len(packageSources))
+
+	for i, source := range packageSources {
+		items, err := s.scanPackageSource(source, &keys[i], &scanned[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan source %q: %w", source.pullspec, err)
+		}
+		finish(items)
+		s.emitPackages(items)
+		res = append(res, items...)
+	}
+	for i, source := range packageSources {
+		if keys[i] != "" {
+			s.state.store(keys[i], stateEntry{Packages: scanned[i], Files: s.sourceFiles(source)})
+		}
+	}
+	return res, nil
+}
+
+// scanPackageSource returns the packages of the source, reused from the state
+// file if unchanged. The key of a source scanned anew is set in key, and its
+// packages, before they're finished, in scanned.
+func (s *Scanner) scanPackageSource(
+	source packageSource,
+	key *string,
+	scanned *[]PackageMetadataItem,
+) ([]PackageMetadataItem, error) {
+	if source.empty() {
+		s.logger.Debug("no content copied from package source, skipping its scan",
+			"alias", source.alias, "pullspec", source.pullspec)
+		return []PackageMetadataItem{}, nil
+	}
+	if s.state != nil {
+		k, items, cached, err := s.cachedPackages(source)
 		if err != nil {
-			return PackageMetadata{}, fmt.Errorf("failed to scan source %q: %w", source.pullspec, err)
+			return nil, err
 		}
-		res.Packages = append(res.Packages, items...)
+		if cached {
+			return items, nil
+		}
+		*key = k
+	}
+
+	ctx, cancel := s.scanContext()
+	defer cancel()
+	items, err := s.scanBuilderStageTree(ctx, source)
+	if errors.Is(err, context.DeadlineExceeded) {
+		s.warn(WarnScanTimeout, fmt.Sprintf(
+			"scan of source %q timed out after %s, its packages are missing", source.pullspec, s.scanTimeout,
+		))
+		*key = ""
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
 	}
+	if *key != "" {
+		// before finish, which depends on the whole scan
+		*scanned = slices.Clone(items)
+	}
+	return items, nil
+}
+
+// scanContext returns the context of the syft scans of a source, done after
+// the scan timeout if configured.
+func (s *Scanner) scanContext() (context.Context, context.CancelFunc) {
+	if s.scanTimeout > 0 {
+		return context.WithTimeout(context.Background(), s.scanTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// warn logs the warning and records it for the output of the current Scan.
+// Safe for concurrent use by scans of package sources.
+func (s *Scanner) warn(code string, message string) {
+	s.logger.Warn(message, "code", code)
+
+	s.warningsMu.Lock()
+	defer s.warningsMu.Unlock()
+	s.warnings = append(s.warnings, Warning{Code: code, Message: message})
+	s.emit(Event{Type: EventWarning, Warning: &Warning{Code: code, Message: message}})
+}
 
+// stagePlatforms maps the base images of stages with a FROM --platform flag to
+// the platform, so they are resolved from manifest lists the same as buildah
+// pulled them. Chained stages and special bases are skipped. For a base image
+// of several such stages, the platform of the first one is used.
+func stagePlatforms(cf containerfile.Containerfile) (map[string]storageclient.Platform, error) {
+	res := make(map[string]storageclient.Platform)
+	for _, stage := range cf.Stages {
+		if stage.Platform == "" || stage.BaseRef != stage.Base || storageclient.IsSpecialBase(stage.Base) {
+			continue
+		}
+		if _, ok := res[stage.Base]; ok {
+			continue
+		}
+		platform, err := storageclient.ParsePlatform(stage.Platform)
+		if err != nil {
+			return nil, fmt.Errorf("FROM --platform of stage %q: %w", stage.Alias, err)
+		}
+		res[stage.Base] = platform
+	}
 	return res, nil
 }
 
 // Map all pullspecs found in the containerfile to their current digests in
 // container storage. Chained stages are skipped (their Base is already the
-// root pullspec, resolved by the parser).
+// root pullspec, resolved by the parser). Unique pullspecs are resolved
+// concurrently, together with their manifest list digests, so a caching
+// client (see storageclient.WithCache) has them for the rest of the scan.
 func getImageDigests(
 	storageClient storageclient.Client, cf containerfile.Containerfile,
 ) (map[string]digest.Digest, error) {
-	res := make(map[string]digest.Digest)
-
+	var pullspecs []string
 	for _, stage := range cf.BuilderStages() {
 		// This deduplication check covers both duplicate pullspecs across
 		// the containerfile and implicitly skips chained stages (their root
 		// stage already resolved the shared base pullspec).
-		if _, ok := res[stage.Base]; !ok {
-			if storageclient.IsSpecialBase(stage.Base) {
-				continue
-			}
-
-			dig, err := storageClient.ResolveDigest(stage.Base)
-			if err != nil {
-				return res, fmt.Errorf("failed to resolve pullspec %q: %w: %w", stage.Base, err, ErrPullspecResolve)
-			}
-
-			res[stage.Base] = dig
+		if !storageclient.IsSpecialBase(stage.Base) && !slices.Contains(pullspecs, stage.Base) {
+			pullspecs = append(pullspecs, stage.Base)
 		}
 	}
 
 	for _, stage := range cf.Stages {
 		for _, cp := range stage.Copies {
-			if cp.Type == containerfile.CopyTypeExternal {
-				if _, ok := res[cp.From]; !ok {
-					dig, err := storageClient.ResolveDigest(cp.From)
-					if err != nil {
-						return res, fmt.Errorf("failed to resolve pullspec %q: %w: %w", cp.From, err, ErrPullspecResolve)
-					}
-
-					res[cp.From] = dig
-				}
+			if cp.Type == containerfile.CopyTypeExternal && !slices.Contains(pullspecs, cp.From) {
+				pullspecs = append(pullspecs, cp.From)
 			}
 		}
 	}
 
+	return resolveDigests(storageClient, pullspecs)
+}
+
+// Most pullspecs resolved at the same time by resolveDigests.
+const resolveConcurrency = 8
+
+// resolveDigests maps the pullspecs to their digests in container storage,
+// resolving up to resolveConcurrency of them at the same time. The error of
+// the first pullspec which failed to resolve is returned.
+func resolveDigests(storageClient storageclient.Client, pullspecs []string) (map[string]digest.Digest, error) {
+	digests := make([]digest.Digest, len(pullspecs))
+	errs := make([]error, len(pullspecs))
+	sem := make(chan struct{}, resolveConcurrency)
+	var wg sync.WaitGroup
+	for i, pullspec := range pullspecs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			digests[i], errs[i] = storageClient.ResolveDigest(pullspec)
+			if errs[i] == nil {
+				// only warms up a caching client, getIndexDigests reports errors
+				_, _ = storageClient.ResolveIndexDigest(pullspec)
+			}
+		}()
+	}
+	wg.Wait()
+
+	res := make(map[string]digest.Digest, len(pullspecs))
+	for i, pullspec := range pullspecs {
+		if errs[i] != nil {
+			return res, fmt.Errorf("failed to resolve pullspec %q: %w: %w", pullspec, errs[i], ErrPullspecResolve)
+		}
+		res[pullspec] = digests[i]
+	}
 	return res, nil
 }
 
@@ -285,61 +1244,166 @@ func attachDigest(pullspec string, dig digest.Digest) (string, error) {
 	return final.String(), nil
 }
 
+// getIndexDigests maps pullspecs with digest (as in the output, see
+// attachDigest) of images pulled through a manifest list to the digest of the
+// list.
+func getIndexDigests(
+	storageClient storageclient.Client, digests map[string]digest.Digest,
+) (map[string]digest.Digest, error) {
+	res := make(map[string]digest.Digest)
+
+	for pullspec, dig := range digests {
+		index, err := storageClient.ResolveIndexDigest(pullspec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve pullspec %q: %w: %w", pullspec, err, ErrPullspecResolve)
+		}
+		if index == "" {
+			continue
+		}
+
+		digestPullspec, err := attachDigest(storageclient.StripTransport(pullspec), dig)
+		if err != nil {
+			return nil, err
+		}
+		res[digestPullspec] = index
+	}
+
+	return res, nil
+}
+
+// setIndexDigests sets the manifest list digest of items with an origin
+// pulled through a manifest list.
+func setIndexDigests(items []PackageMetadataItem, indexDigests map[string]digest.Digest) {
+	for i := range items {
+		if index, ok := indexDigests[items[i].Pullspec]; ok {
+			items[i].IndexDigest = index.String()
+		}
+	}
+}
+
 // getPackageSources traces content origins from the final stage through builder
 // stages and returns a slice of packageSource — one per non-chained builder
 // stage (with chained stages attached as packageSourceDescendant descendants)
-// and one per external COPY --from source (with external=true).
+// and one per external COPY --from source (with kind StageKindExternal).
 // Uses the passed storageclient.Client to get OCIImageConfigs of base images
 // to get their default workdirs for relative path resolution in copy destinations.
+// Also returns the content fetched from URLs into the final image, which isn't
+// traced further, and the coverage of the sources copied to the final stage,
+// with URLs redacted by redactor.
 func getPackageSources(
 	storageClient storageclient.Client,
 	cf containerfile.Containerfile,
 	digests map[string]digest.Digest,
-) ([]packageSource, error) {
-	// mapping of bases used in the containerfile to their initial working
-	// directories
-	baseToWorkdir := make(map[string]string)
-	for _, s := range cf.BuilderStages() {
-		if storageclient.IsSpecialBase(s.Base) {
-			continue
-		}
-
-		cfg, err := storageClient.GetImageConfig(s.Base)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get OCI image config for %q: %w", s.Base, ErrOCIConfig)
-		}
-
-		baseToWorkdir[s.Base] = cfg.Config.Workdir
+	redactor *redact.Redactor,
+) ([]packageSource, []remoteFetch, *Coverage, error) {
+	baseToWorkdir, err := getBaseWorkdirs(storageClient, cf)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
 	// The following code block reads all the builder COPY-ies in the final stage
 	// and recursively traces their content to their respective origins in previous stages.
 	// Builds a map between stage indices and the source paths that originated in them.
-	final := &cf.Stages[len(cf.Stages)-1]
+	final := cf.FinalStage()
 	builderStageAcc := make(map[int][]string)
 	externalAcc := make(map[string][]string)
+	coverage := make([]CoverageSource, 0)
 
 	for _, cp := range final.Copies {
 		// TODO: resolving from named contexts is currently not supported
 		if cp.Type == containerfile.CopyTypeContext {
+			for _, source := range cp.Sources {
+				coverage = append(coverage, CoverageSource{
+					Source: source, From: cp.From, Destination: cp.Destination,
+					Status: CoverageSkipped, Reason: CoverageReasonNamedContext,
+				})
+			}
 			continue
 		}
 
 		for _, source := range cp.Sources {
+			covered := CoverageSource{
+				Source: source, From: cp.From, Destination: cp.Destination, Status: CoverageAttributed,
+			}
 			// the copy is builder type only if there's no builder stage with alias equal to the cp.from
 			// otherwise the cp.from is a pullspec and it is an external copy
 			// Multiple copies from same external image (multiple COPY instructions referencing same image,
 			// not sources) are grouped under same pullspec.
-			from := cf.StageByRef(cp.From)
+			from := cf.ResolveRef(cp.From, final.Index)
 			if from != nil {
-				traceSource(source, from.Index, cf, builderStageAcc, externalAcc, baseToWorkdir)
+				if traceSource(source, from.Index, cf, builderStageAcc, externalAcc, baseToWorkdir) {
+					covered.Status, covered.Reason = CoverageBestEffort, CoverageReasonRunMove
+				}
 			} else {
 				externalAcc[cp.From] = append(externalAcc[cp.From], source)
 			}
+			coverage = append(coverage, covered)
+		}
+	}
+	for _, cp := range final.ContextCopies {
+		for _, source := range cp.Sources {
+			coverage = append(coverage, CoverageSource{
+				Source: source, Destination: cp.Destination,
+				Status: CoverageSkipped, Reason: CoverageReasonContext,
+			})
+		}
+	}
+	for _, add := range final.ArchiveAdds {
+		for _, source := range add.Sources {
+			coverage = append(coverage, CoverageSource{
+				Source: source, Destination: add.Destination,
+				Status: CoverageSkipped, Reason: CoverageReasonContextArchive,
+			})
+		}
+	}
+	for _, add := range final.RemoteAdds {
+		coverage = append(coverage, CoverageSource{
+			Source: redactor.Value("", add.URL), Destination: add.Destination, Status: CoverageAttributed,
+		})
+	}
+
+	fetches := takeRemoteFetches(cf, builderStageAcc, baseToWorkdir)
+	packageSources, err := packageSourcesFromAcc(cf, builderStageAcc, externalAcc, digests, baseToWorkdir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return packageSources, fetches, newCoverage(coverage), nil
+}
+
+// getBaseWorkdirs maps the bases of builder stages in the containerfile to
+// their initial working directories.
+func getBaseWorkdirs(
+	storageClient storageclient.Client,
+	cf containerfile.Containerfile,
+) (map[string]string, error) {
+	baseToWorkdir := make(map[string]string)
+	for _, s := range cf.BuilderStages() {
+		if storageclient.IsSpecialBase(s.Base) {
+			continue
+		}
+
+		cfg, err := storageClient.GetImageConfig(s.Base)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get OCI image config for %q: %w", s.Base, ErrOCIConfig)
 		}
+
+		baseToWorkdir[s.Base] = cfg.Config.Workdir
 	}
+	return baseToWorkdir, nil
+}
 
-	packageSources, err := buildSourceTrees(cf, builderStageAcc, digests)
+// packageSourcesFromAcc returns the package sources of source paths traced
+// to builder stages (builderStageAcc, by stage index) and to external images
+// (externalAcc, by pullspec).
+func packageSourcesFromAcc(
+	cf containerfile.Containerfile,
+	builderStageAcc map[int][]string,
+	externalAcc map[string][]string,
+	digests map[string]digest.Digest,
+	baseToWorkdir map[string]string,
+) ([]packageSource, error) {
+	packageSources, err := buildSourceTrees(cf, builderStageAcc, digests, baseToWorkdir)
 	if err != nil {
 		return nil, err
 	}
@@ -361,7 +1425,7 @@ func getPackageSources(
 			pullspec:   pullspec,
 			digestBase: digestBase,
 			sources:    sources,
-			external:   true,
+			kind:       containerfile.StageKindExternal,
 		})
 	}
 
@@ -374,6 +1438,7 @@ func buildSourceTrees(
 	cf containerfile.Containerfile,
 	builderStageAcc map[int][]string,
 	digests map[string]digest.Digest,
+	baseToWorkdir map[string]string,
 ) ([]packageSource, error) {
 	sourceByIndex := make(map[int]*packageSource)
 	nodeByIndex := make(map[int]*packageSourceDescendant)
@@ -381,6 +1446,11 @@ func buildSourceTrees(
 	for _, builderStage := range cf.BuilderStages() {
 		isChained := builderStage.Base != builderStage.BaseRef
 		sources := builderStageAcc[builderStage.Index]
+		archiveDests := resolveArchiveDestinations(builderStage, baseToWorkdir)
+		cacheTargets := resolveMountTargets(builderStage, baseToWorkdir, containerfile.MountTypeCache)
+		secretTargets := resolveMountTargets(
+			builderStage, baseToWorkdir, containerfile.MountTypeSecret, containerfile.MountTypeSSH,
+		)
 
 		if !isChained {
 			dig, exists := digests[builderStage.Base]
@@ -396,11 +1466,16 @@ func buildSourceTrees(
 			}
 
 			source := &packageSource{
+				kind:       containerfile.StageKindBuilder,
 				index:      builderStage.Index,
 				alias:      builderStage.Alias,
 				pullspec:   builderStage.Base,
 				digestBase: digestBase,
 				sources:    sources,
+
+				archiveDests:  archiveDests,
+				cacheTargets:  cacheTargets,
+				secretTargets: secretTargets,
 			}
 			sourceByIndex[builderStage.Index] = source
 		} else {
@@ -408,11 +1483,15 @@ func buildSourceTrees(
 				index:   builderStage.Index,
 				alias:   builderStage.Alias,
 				sources: sources,
+
+				archiveDests:  archiveDests,
+				cacheTargets:  cacheTargets,
+				secretTargets: secretTargets,
 			}
 			nodeByIndex[builderStage.Index] = node
 
 			// attach to parent — parent can be a root or another node
-			parentStage := cf.StageByRef(builderStage.BaseRef)
+			parentStage := cf.ResolveRef(builderStage.BaseRef, builderStage.Index)
 
 			if parentRoot, ok := sourceByIndex[parentStage.Index]; ok {
 				parentRoot.descendants = append(parentRoot.descendants, node)
@@ -435,6 +1514,8 @@ func buildSourceTrees(
 // External COPY --from references in builder stages are collected in externalAcc.
 // baseToWorkdir is a mapping of bases of stages in the containerfile and their
 // respective initial working directories.
+// Returns true if the source was traced through RUN mv or cp commands, which
+// is heuristic.
 func traceSource(
 	source string,
 	stageIndex int,
@@ -442,7 +1523,7 @@ func traceSource(
 	acc map[int][]string,
 	externalAcc map[string][]string,
 	baseToWorkdir map[string]string,
-) {
+) bool {
 	currStage := cf.StageByIndex(stageIndex)
 
 	coversMultipleFiles := strings.HasSuffix(source, "/") || strings.ContainsAny(source, "*?[]")
@@ -453,32 +1534,43 @@ func traceSource(
 		baseWorkdir = "/"
 	}
 
+	// paths of the source before RUN mv and cp commands of the stage
+	candidates := movedSources(source, currStage, baseWorkdir)
+	moved := len(candidates) > 1
+
 	foundAncestor := false
 	for _, cp := range currStage.Copies {
 		dest := ""
-		if filepath.IsAbs(cp.Destination) {
+		if path.IsAbs(cp.Destination) {
 			dest = cp.Destination
 		} else {
 			dest = resolveRelativeDestination(cp, baseWorkdir)
 		}
 
-		sourceCoversDestination := isPathUnderPattern(source, dest)
-		destinationCoversSource := isPathUnderPattern(dest, source)
-		if sourceCoversDestination || destinationCoversSource {
+		for _, candidate := range candidates {
+			sourceCoversDestination := isPathUnderPattern(candidate, dest)
+			destinationCoversSource := isPathUnderPattern(dest, candidate)
+			if !sourceCoversDestination && !destinationCoversSource {
+				continue
+			}
 			foundAncestor = true
-			if sourceCoversDestination && source != dest {
+			if sourceCoversDestination && candidate != dest {
 				// source covers destination but is not the same path, so it covers multiple files
 				coversMultipleFiles = true
 			}
 			for _, s := range cp.Sources {
-				prevStage := cf.StageByRef(cp.From)
+				prevStage := cf.ResolveRef(cp.From, currStage.Index)
 				if prevStage != nil {
-					traceSource(s, prevStage.Index, cf, acc, externalAcc, baseToWorkdir)
+					if traceSource(s, prevStage.Index, cf, acc, externalAcc, baseToWorkdir) {
+						moved = true
+					}
 				} else {
 					// external image - add as external source
 					externalAcc[cp.From] = append(externalAcc[cp.From], s)
 				}
 			}
+			// trace each COPY once, even if several paths of the source match
+			break
 		}
 	}
 
@@ -491,10 +1583,66 @@ func traceSource(
 	}
 
 	// chained stage — propagate source to parent for builder content scanning
-	parentStage := cf.StageByRef(currStage.BaseRef)
+	parentStage := cf.ResolveRef(currStage.BaseRef, currStage.Index)
 	if parentStage != nil {
-		traceSource(source, parentStage.Index, cf, acc, externalAcc, baseToWorkdir)
+		for _, candidate := range candidates {
+			if traceSource(candidate, parentStage.Index, cf, acc, externalAcc, baseToWorkdir) {
+				moved = true
+			}
+		}
+	}
+
+	return moved
+}
+
+// resolveArchiveDestinations returns the absolute destinations of archives
+// added from the build context in the stage, or nil if there are none.
+func resolveArchiveDestinations(stage containerfile.Stage, baseToWorkdir map[string]string) []string {
+	if len(stage.ArchiveAdds) == 0 {
+		return nil
+	}
+
+	baseWorkdir, ok := baseToWorkdir[stage.Base]
+	if !ok {
+		baseWorkdir = "/"
+	}
+
+	dests := make([]string, 0, len(stage.ArchiveAdds))
+	for _, add := range stage.ArchiveAdds {
+		if path.IsAbs(add.Destination) {
+			dests = append(dests, path.Clean(add.Destination))
+		} else {
+			dests = append(dests, resolveRelativeDestination(add, baseWorkdir))
+		}
+	}
+	return dests
+}
+
+// resolveMountTargets returns the absolute targets of the RUN mounts of the
+// passed types in the stage. Relative targets are resolved the same as COPY
+// destinations.
+func resolveMountTargets(
+	stage containerfile.Stage,
+	baseToWorkdir map[string]string,
+	mountTypes ...containerfile.MountType,
+) []string {
+	baseWorkdir, ok := baseToWorkdir[stage.Base]
+	if !ok {
+		baseWorkdir = "/"
 	}
+
+	var targets []string
+	for _, m := range stage.Mounts {
+		if !slices.Contains(mountTypes, m.MountType) || m.Target == "" {
+			continue
+		}
+		if path.IsAbs(m.Target) {
+			targets = append(targets, path.Clean(m.Target))
+		} else {
+			targets = append(targets, resolveRelativePath(m.Target, m.Workdir, baseWorkdir))
+		}
+	}
+	return targets
 }
 
 // Get the true destination of a COPY command, resolving relative paths.
@@ -502,29 +1650,71 @@ func traceSource(
 // baseWorkdir is the working directory of the base image the COPY command
 // appeared in.
 func resolveRelativeDestination(cp containerfile.Copy, baseWorkdir string) string {
-	// If no WORKDIR command precedes the COPY, its destination is relative to
+	return resolveRelativePath(cp.Destination, cp.Workdir, baseWorkdir)
+}
+
+// Get the absolute path of a relative path p of an instruction with the
+// working directory workdir (see containerfile.Copy.Workdir), in a stage
+// whose base image has the working directory baseWorkdir.
+func resolveRelativePath(p, workdir, baseWorkdir string) string {
+	// If no WORKDIR command precedes the instruction, the path is relative to
 	// the base image working directory.
-	if cp.Workdir == "" {
-		return filepath.Join(baseWorkdir, cp.Destination)
+	if workdir == "" {
+		return path.Join(baseWorkdir, p)
 	}
 
-	// If an absolute WORKDIR command precedes the COPY, the destination is
+	// If an absolute WORKDIR command precedes the instruction, the path is
 	// relative to that WORKDIR.
-	if filepath.IsAbs(cp.Workdir) {
-		return filepath.Join(cp.Workdir, cp.Destination)
+	if path.IsAbs(workdir) {
+		return path.Join(workdir, p)
 	}
 
-	// If the WORKDIR command preceding the COPY contained a relative path, and
-	// the COPY's destination is relative, we join all three paths to get the
+	// If the WORKDIR command preceding the instruction contained a relative
+	// path, and the path is relative, we join all three paths to get the
 	// absolute path.
 	// This is possible because the Workdir field always contains a relative to
 	// the stage's working directory.
-	return filepath.Join(baseWorkdir, cp.Workdir, cp.Destination)
+	return path.Join(baseWorkdir, workdir, p)
+}
+
+// movedSources returns the source followed by the paths the content under it
+// had before RUN mv and cp commands of the stage moved it (see
+// containerfile.Move), so COPY destinations can be traced through renames.
+// Moves are undone from the last to the first, which follows chained moves
+// (e.g. "mv /build/out /tmp/out && mv /tmp/out /app").
+func movedSources(source string, stage *containerfile.Stage, baseWorkdir string) []string {
+	res := []string{source}
+	for _, mv := range slices.Backward(stage.Moves) {
+		src := mv.Source
+		if !path.IsAbs(src) {
+			src = resolveRelativePath(src, mv.Workdir, baseWorkdir)
+		}
+		dest := mv.Destination
+		if !path.IsAbs(dest) {
+			dest = resolveRelativePath(dest, mv.Workdir, baseWorkdir)
+		}
+		src, dest = path.Clean(src), path.Clean(dest)
+
+		for _, p := range res {
+			moved := ""
+			if rest, ok := strings.CutPrefix(path.Clean(p), dest); ok && (rest == "" || rest[0] == '/') {
+				// the source is the moved path or under it
+				moved = src + rest
+			} else if isPathUnderPattern(p, dest) {
+				// the source covers the moved path
+				moved = src
+			}
+			if moved != "" && !slices.Contains(res, moved) {
+				res = append(res, moved)
+			}
+		}
+	}
+	return res
 }
 
 func (s *Scanner) logPackageSources(roots []packageSource) {
 	for _, root := range roots {
-		if root.external {
+		if root.kind == containerfile.StageKindExternal {
 			s.logger.Debug("package source: external image",
 				"pullspec", root.pullspec,
 				"digestBase", root.digestBase,
@@ -565,36 +1755,44 @@ func (s *Scanner) logPackageSourceDescendant(node *packageSourceDescendant, pare
 // For descendants, only intermediate content is extracted (diffed against parent's
 // intermediate layer, or builder base if parent has no intermediate).
 func (s *Scanner) scanBuilderStageTree(
+	ctx context.Context,
 	root packageSource,
 ) ([]PackageMetadataItem, error) {
 	s.logger.Debug("starting root scan", "base", root.digestBase, "pullspec", root.pullspec)
 	defer s.logger.Debug("ending root scan", "base", root.digestBase, "pullspec", root.pullspec)
+	if items, ok := s.referrerPackages(ctx, root); ok {
+		return items, nil
+	}
 	res := make([]PackageMetadataItem, 0)
 
-	// root scan
-	rootItems, err := s.scanSource(root)
-	if err != nil {
-		return nil, err
+	// root scan, only content of its descendants may be copied
+	if len(root.sources) > 0 {
+		rootItems, err := s.scanSource(ctx, root)
+		if err != nil {
+			return nil, err
+		}
+		if root.kind != containerfile.StageKindExternal {
+			setStageIndex(rootItems, root.index)
+		}
+		res = append(res, rootItems...)
+	} else {
+		s.logger.Debug("no content copied from stage, skipping its scan", "alias", root.alias)
 	}
-	res = append(res, rootItems...)
 
 	// root's chain descendants scan
-	if len(root.descendants) > 0 {
+	if !allEmpty(root.descendants) {
 		// Resolve the initial diff base for descendants. Descendants diff their
 		// intermediate image against the nearest ancestor with an intermediate.
 		// If nearest ancestor has an intermediate, use it; otherwise fall back
-		// to its builder base image.
-		imgId, err := s.store.Lookup(storageclient.StripTransport(root.pullspec))
-		if err != nil {
-			imgId, err = s.store.Lookup(storageclient.StripTransport(root.digestBase))
+		// to its builder base image. Special bases (e.g. scratch) have none,
+		// all content of the descendants is then their own.
+		var builderBaseImage *storage.Image
+		if !storageclient.IsSpecialBase(root.pullspec) {
+			builderBaseImage, err = s.lookupBaseImage(root.pullspec, root.digestBase)
 			if err != nil {
-				return nil, fmt.Errorf("could not find image %q in buildah storage: %w", root.pullspec, ErrImageNotFound)
+				return nil, err
 			}
 		}
-		builderBaseImage, err := s.store.Image(imgId)
-		if err != nil {
-			return nil, fmt.Errorf("could not find image %q in buildah storage: %w", root.pullspec, ErrImageNotFound)
-		}
 
 		// root's intermediate image — use as initial diff base if it exists
 		rootDiffBase := builderBaseImage
@@ -609,7 +1807,7 @@ func (s *Scanner) scanBuilderStageTree(
 		//   FROM root AS left  - descendant1
 		//   FROM root AS right - descendant2
 		for _, desc := range root.descendants {
-			descItems, err := s.scanDescendants(desc, rootDiffBase, root.digestBase)
+			descItems, err := s.scanDescendants(ctx, desc, rootDiffBase, root.digestBase)
 			if err != nil {
 				return nil, err
 			}
@@ -624,28 +1822,48 @@ func (s *Scanner) scanBuilderStageTree(
 // only intermediate content (diffed against diffBase - the nearest ancestor's
 // intermediate image or the builder base image).
 func (s *Scanner) scanDescendants(
+	ctx context.Context,
 	node *packageSourceDescendant,
 	diffBase *storage.Image,
 	rootDigestBase string,
 ) ([]PackageMetadataItem, error) {
 	s.logger.Debug("starting descendant scan", "alias", node.alias)
 	defer s.logger.Debug("ending descendant scan", "alias", node.alias)
+	if node.empty() {
+		s.logger.Debug("no content copied from stage, skipping its scan", "alias", node.alias)
+		return []PackageMetadataItem{}, nil
+	}
+	if len(node.sources) == 0 {
+		// only content of further chained stages is copied, which is diffed
+		// against the intermediate image of this one
+		return s.scanNextDescendants(ctx, node, diffBase, rootDigestBase)
+	}
 	res := make([]PackageMetadataItem, 0)
 
-	intermediateContentPath, err := os.MkdirTemp("", "")
+	intermediateContentPath, err := s.contentDir(node.alias, debugKindIntermediate)
 	if err != nil {
 		return nil, fmt.Errorf("%w: failed to create temp directory: %w", ErrIO, err)
 	}
-	defer func() { _ = os.RemoveAll(intermediateContentPath) }()
+	defer func() { _ = s.removeContentDirs(intermediateContentPath) }()
 
 	// getDescendantContent returns the intermediate image for this node
 	// (or diffBase unchanged if node has no intermediate = empty stage)
-	nextDiffBase, intermediate, err := s.getDescendantContent(
-		node.alias, diffBase, node.sources, intermediateContentPath,
+	nextDiffBase, intermediate, squashed, err := s.getDescendantContent(
+		ctx, node.alias, diffBase, node.sources, intermediateContentPath,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: content of stage %q: %w", ErrExtract, node.alias, err)
+	}
+	intermediate, err = s.removeMountContent(
+		intermediateContentPath, intermediate, slices.Concat(node.cacheTargets, node.secretTargets),
 	)
 	if err != nil {
 		return nil, err
 	}
+	originType := "intermediate"
+	if squashed {
+		originType = originTypeSquashed
+	}
 
 	if s.logger.Enabled(context.Background(), slog.LevelDebug) {
 		if n, sizeErr := dirSize(intermediateContentPath); sizeErr != nil {
@@ -659,29 +1877,43 @@ func (s *Scanner) scanDescendants(
 	if len(intermediate) > 0 {
 		s.logContent("intermediate (chained)", intermediate, node.alias)
 
-		intermediatePkgs, err := s.syftScanner.Scan(intermediateContentPath)
+		if err := s.export(ctx, node.alias, debugKindIntermediate, intermediateContentPath); err != nil {
+			return nil, err
+		}
+		intermediatePkgs, err := s.scanContent(ctx, s.syftScanner, scanKindContent, intermediateContentPath)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan intermediate content for %q: %w", node.alias, err)
+			return nil, fmt.Errorf("failed to scan intermediate content for %q: %w: %w", node.alias, err, ErrSBOMScan)
 		}
 
 		for _, ipkg := range intermediatePkgs {
-			res = append(res, PackageMetadataItem{
+			res = append(res, withID(PackageMetadataItem{
 				Pullspec:         rootDigestBase,
 				StageAlias:       node.alias,
 				PackageURL:       ipkg.PURL,
 				DependencyOfPURL: ipkg.DependencyOfPURL,
 				Checksums:        ipkg.Checksums,
-				OriginType:       "intermediate",
-			})
+				Licenses:         ipkg.Licenses,
+				OriginType:       archiveOriginType(ipkg, node.archiveDests, originType),
+			}, ipkg.Locations))
+		}
+
+		err = s.recordContentFiles(
+			intermediateContentPath, intermediate, node.alias, rootDigestBase, originType,
+			node.archiveDests, intermediatePkgs,
+		)
+		if err != nil {
+			return nil, err
 		}
 	}
 
+	setStageIndex(res, node.index)
+
 	// recurse into further chained stages, e.g.:
 	//   FROM root AS left    ← current node
 	//   FROM left AS child1
 	//   FROM left AS child2
 	for _, child := range node.descendants {
-		childItems, err := s.scanDescendants(child, nextDiffBase, rootDigestBase)
+		childItems, err := s.scanDescendants(ctx, child, nextDiffBase, rootDigestBase)
 		if err != nil {
 			return nil, err
 		}
@@ -691,48 +1923,81 @@ func (s *Scanner) scanDescendants(
 	return res, nil
 }
 
+// scanNextDescendants scans the chained stages of a node no content is copied
+// from, diffed against its intermediate image, or diffBase if it has none.
+func (s *Scanner) scanNextDescendants(
+	ctx context.Context,
+	node *packageSourceDescendant,
+	diffBase *storage.Image,
+	rootDigestBase string,
+) ([]PackageMetadataItem, error) {
+	nextDiffBase := diffBase
+	intermediateImage, found, err := s.findIntermediateImage(node.alias)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to find intermediate image for %q: %w", ErrStorage, node.alias, err)
+	}
+	if found {
+		nextDiffBase = intermediateImage
+	}
+
+	res := make([]PackageMetadataItem, 0)
+	for _, child := range node.descendants {
+		childItems, err := s.scanDescendants(ctx, child, nextDiffBase, rootDigestBase)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, childItems...)
+	}
+	return res, nil
+}
+
 // scanSource extracts content for a stage from buildah storage, scans it
 // with syft, and returns package metadata items.
 func (s *Scanner) scanSource(
+	ctx context.Context,
 	root packageSource,
 ) (_ []PackageMetadataItem, err error) {
-	builderContentPath, err := os.MkdirTemp("", "")
+	var builderContentPath, intermediateContentPath, packageDBPath string
+	defer func() {
+		removeErr := s.removeContentDirs(builderContentPath, intermediateContentPath, packageDBPath)
+		if err == nil {
+			err = removeErr
+		}
+	}()
+
+	origin := root.alias
+	if root.kind == containerfile.StageKindExternal {
+		origin = root.digestBase
+	}
+	builderContentPath, err = s.contentDir(origin, debugKindBuilder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w: %w", err, ErrIO)
+	}
+
+	packageDBPath, err = s.contentDir(origin, debugKindPackageDB)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create temp directory: %w: %w", err, ErrIO)
 	}
 
 	originType := "external"
-	var intermediateContentPath string
-	if !root.external {
+	if root.kind != containerfile.StageKindExternal {
 		originType = "builder"
-		intermediateContentPath, err = os.MkdirTemp("", "")
+		intermediateContentPath, err = s.contentDir(origin, debugKindIntermediate)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create temp directory: %w: %w", err, ErrIO)
 		}
 	}
 
-	debugMode := os.Getenv("CAPO_DEBUG") != ""
-	if debugMode {
-		s.logger.Debug("builder content path", "pullspec", root.pullspec, "path", builderContentPath)
-		s.logger.Debug("intermediate content path", "pullspec", root.pullspec, "path", intermediateContentPath)
-	} else {
-		defer func() {
-			removeErr := errors.Join(
-				os.RemoveAll(builderContentPath),
-				os.RemoveAll(intermediateContentPath),
-			)
-			if err == nil {
-				err = removeErr
-			}
-		}()
-	}
-
-	err = s.getContent(
-		root.pullspec, root.digestBase, root.alias, root.sources,
-		builderContentPath, intermediateContentPath,
+	saved, err := s.getContent(
+		ctx, root.pullspec, root.digestBase, root.alias, root.sources, root.cacheTargets, root.secretTargets,
+		builderContentPath, intermediateContentPath, packageDBPath,
 	)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: content of %q: %w", ErrExtract, origin, err)
+	}
+	intermediateOriginType := "intermediate"
+	if saved.squashed {
+		intermediateOriginType = originTypeSquashed
 	}
 
 	if s.logger.Enabled(context.Background(), slog.LevelDebug) {
@@ -752,56 +2017,137 @@ func (s *Scanner) scanSource(
 		}
 	}
 
+	// exported before the scan, so content syft fails on can be inspected
+	if err := s.export(ctx, origin, debugKindBuilder, builderContentPath); err != nil {
+		return nil, err
+	}
+	if err := s.export(ctx, origin, debugKindIntermediate, intermediateContentPath); err != nil {
+		return nil, err
+	}
+	if len(saved.builder) > 0 {
+		if err := s.export(ctx, origin, debugKindPackageDB, packageDBPath); err != nil {
+			return nil, err
+		}
+	}
+
 	var intermediatePkgs []sbom.SyftPackage
 	if intermediateContentPath != "" {
-		intermediatePkgs, err = s.syftScanner.Scan(intermediateContentPath)
+		intermediatePkgs, err = s.scanContent(ctx, s.syftScanner, scanKindContent, intermediateContentPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan intermediate content of %q: %w: %w", origin, err, ErrSBOMScan)
+		}
+	}
+
+	builderPkgs, err := s.scanContent(ctx, s.syftScanner, scanKindContent, builderContentPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan builder content of %q: %w: %w", origin, err, ErrSBOMScan)
+	}
+
+	var dbPkgs, ownedPkgs []sbom.SyftPackage
+	if len(saved.builder) > 0 {
+		dbPkgs, err = s.scanContent(ctx, s.packageDBScanner, scanKindPackageDB, packageDBPath)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan intermediate content: %w: %w", err, ErrSBOMScan)
+			return nil, fmt.Errorf("failed to scan package databases of %q: %w: %w", origin, err, ErrSBOMScan)
 		}
+		ownedPkgs = lookupOwnedPackages(dbPkgs, saved.builder, builderPkgs)
 	}
 
-	builderPkgs, err := s.syftScanner.Scan(builderContentPath)
+	err = s.recordContentFiles(
+		builderContentPath, saved.builder, root.alias, root.digestBase, originType, nil,
+		dbPkgs, builderPkgs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	err = s.recordContentFiles(
+		intermediateContentPath, saved.intermediate, root.alias, root.digestBase, intermediateOriginType,
+		root.archiveDests, intermediatePkgs,
+	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to scan builder content: %w: %w", err, ErrSBOMScan)
+		return nil, err
+	}
+
+	res := getPackageMetadata(
+		root.alias, root.digestBase, originType, intermediateOriginType, root.archiveDests,
+		builderPkgs, intermediatePkgs,
+	)
+	for _, opkg := range ownedPkgs {
+		res = append(res, withID(PackageMetadataItem{
+			Pullspec:   root.digestBase,
+			StageAlias: root.alias,
+			PackageURL: opkg.PURL,
+			OriginType: originType,
+			FoundBy:    foundByPackageDBLookup,
+		}, opkg.Locations))
 	}
 
-	return getPackageMetadata(
-		root.alias, root.digestBase, originType, builderPkgs, intermediatePkgs,
-	), nil
+	return res, nil
 }
 
 // getPackageMetadata maps scanned packages to PackageMetadataItem structs
-// with the given origin information.
+// with the given origin information. Intermediate packages found under
+// archiveDests are attributed as context-archive (see archiveOriginType).
 func getPackageMetadata(
 	stageAlias string,
 	digestBase string,
 	builderOriginType string,
+	intermediateOriginType string,
+	archiveDests []string,
 	builderPkgs []sbom.SyftPackage,
 	intermediatePkgs []sbom.SyftPackage,
 ) []PackageMetadataItem {
 	res := make([]PackageMetadataItem, 0, len(builderPkgs)+len(intermediatePkgs))
 
 	for _, bpkg := range builderPkgs {
-		res = append(res, PackageMetadataItem{
+		res = append(res, withID(PackageMetadataItem{
 			Pullspec:         digestBase,
 			StageAlias:       stageAlias,
 			PackageURL:       bpkg.PURL,
 			DependencyOfPURL: bpkg.DependencyOfPURL,
 			Checksums:        bpkg.Checksums,
+			Licenses:         bpkg.Licenses,
 			OriginType:       builderOriginType,
-		})
+		}, bpkg.Locations))
 	}
 
 	for _, ipkg := range intermediatePkgs {
-		res = append(res, PackageMetadataItem{
+		res = append(res, withID(PackageMetadataItem{
 			Pullspec:         digestBase,
 			StageAlias:       stageAlias,
 			PackageURL:       ipkg.PURL,
 			DependencyOfPURL: ipkg.DependencyOfPURL,
 			Checksums:        ipkg.Checksums,
-			OriginType:       "intermediate",
-		})
+			Licenses:         ipkg.Licenses,
+			OriginType:       archiveOriginType(ipkg, archiveDests, intermediateOriginType),
+		}, ipkg.Locations))
 	}
 
 	return res
 }
+
+// setStageIndex sets the stage index of all passed items.
+func setStageIndex(items []PackageMetadataItem, index int) {
+	for i := range items {
+		items[i].StageIndex = &index
+	}
+}
+
+// archiveOriginType returns the context-archive origin type for a package
+// found in intermediate content under the destination of an archive added
+// from the build context, and originType otherwise. Anything else the stage
+// put under the destination is flagged as well, as the archive content can't
+// be told apart from it.
+func archiveOriginType(pkg sbom.SyftPackage, archiveDests []string, originType string) string {
+	for _, loc := range pkg.Locations {
+		if underArchiveDest(loc, archiveDests) {
+			return originTypeContextArchive
+		}
+	}
+	return originType
+}
+
+// underArchiveDest reports whether the path is under any of the destinations
+// of archives added from the build context.
+func underArchiveDest(path string, archiveDests []string) bool {
+	return Includes(archiveDests, path)
+}
@@ -0,0 +1,48 @@
+//go:build unit
+
+package capo
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+	"github.com/konflux-ci/capo/pkg/storageclient"
+
+	"github.com/konflux-ci/capo/internal/testutils"
+)
+
+func BenchmarkGetPackageSources(b *testing.B) {
+	for _, builders := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("builders=%d", builders), func(b *testing.B) {
+			cf, err := containerfile.Parse(
+				strings.NewReader(testutils.GenerateContainerfile(builders)),
+			)
+			if err != nil {
+				b.Fatalf("unexpected error: %v", err)
+			}
+
+			digests := make(map[string]digest.Digest)
+			configs := make(map[string]storageclient.OCIImageConfig)
+			for _, stage := range cf.Stages {
+				digests[stage.Base] = testDigest(fmt.Sprint(len(digests) + 1))
+				configs[stage.Base] = configWithWorkdir("/")
+				for _, cp := range stage.Copies {
+					if cp.Type == containerfile.CopyTypeExternal {
+						digests[cp.From] = testDigest(fmt.Sprint(len(digests) + 1))
+					}
+				}
+			}
+			client := testutils.NewTStorageClient(digests, configs)
+
+			for b.Loop() {
+				if _, _, _, err := getPackageSources(client, cf, digests, nil); err != nil {
+					b.Fatalf("unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
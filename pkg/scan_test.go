@@ -3,7 +3,10 @@
 package capo
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"slices"
 	"strings"
 	"testing"
 	"os"
@@ -16,6 +19,7 @@ import (
 	"github.com/konflux-ci/capo/pkg/containerfile"
 	"github.com/konflux-ci/capo/pkg/storageclient"
 
+	"github.com/konflux-ci/capo/internal/sbom"
 	"github.com/konflux-ci/capo/internal/testutils"
 )
 
@@ -46,10 +50,11 @@ func TestGetPackageSources(t *testing.T) {
 		"only external copy in final": {
 			cf: containerfile.Containerfile{Stages: []containerfile.Stage{
 				{
-					Alias:   containerfile.FinalStage,
+					Alias:   "0",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   0,
+					Kind:    containerfile.StageKindFinal,
 					Copies: []containerfile.Copy{
 						{
 							From:        "docker.io/library/fedora:latest",
@@ -69,7 +74,7 @@ func TestGetPackageSources(t *testing.T) {
 					pullspec:   "docker.io/library/fedora:latest",
 					digestBase: "docker.io/library/fedora@" + string(testDigest("abc123")),
 					sources:    []string{"/usr/bin/oras"},
-					external:   true,
+					kind:       containerfile.StageKindExternal,
 				},
 			},
 		},
@@ -90,10 +95,11 @@ func TestGetPackageSources(t *testing.T) {
 					Copies:  []containerfile.Copy{},
 				},
 				{
-					Alias:   containerfile.FinalStage,
+					Alias:   "2",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   2,
+					Kind:    containerfile.StageKindFinal,
 					Copies: []containerfile.Copy{
 						{
 							From:        "builder1",
@@ -135,6 +141,166 @@ func TestGetPackageSources(t *testing.T) {
 				},
 			},
 		},
+		"archive adds in builder stages": {
+			cf: containerfile.Containerfile{Stages: []containerfile.Stage{
+				{
+					Alias:   "builder",
+					Base:    "docker.io/library/golang:1.22",
+					BaseRef: "docker.io/library/golang:1.22",
+					Index:   0,
+					Copies:  []containerfile.Copy{},
+					ArchiveAdds: []containerfile.Copy{
+						{
+							Sources:     []string{"vendor.tar.gz"},
+							Destination: "/opt/vendor/",
+							Type:        containerfile.CopyTypeContext,
+						},
+						{
+							Sources:     []string{"src.tar"},
+							Destination: "src/",
+							Type:        containerfile.CopyTypeContext,
+						},
+					},
+				},
+				{
+					Alias:   "chained",
+					Base:    "docker.io/library/golang:1.22",
+					BaseRef: "builder",
+					Index:   1,
+					Copies:  []containerfile.Copy{},
+					ArchiveAdds: []containerfile.Copy{
+						{
+							Sources:     []string{"assets.tgz"},
+							Destination: ".",
+							Type:        containerfile.CopyTypeContext,
+							Workdir:     "/srv",
+						},
+					},
+				},
+				{
+					Alias:   "2",
+					Base:    "scratch",
+					BaseRef: "scratch",
+					Index:   2,
+					Kind:    containerfile.StageKindFinal,
+					Copies: []containerfile.Copy{
+						{
+							From:        "chained",
+							Sources:     []string{"/go/bin/app"},
+							Destination: "/usr/bin/app",
+							Type:        containerfile.CopyTypeBuilder,
+						},
+					},
+				},
+			}},
+			digests: map[string]digest.Digest{
+				"docker.io/library/golang:1.22": testDigest("a01"),
+			},
+			configs: map[string]storageclient.OCIImageConfig{
+				"docker.io/library/golang:1.22": configWithWorkdir("/go"),
+			},
+			expectedRoots: []packageSource{
+				{
+					index:        0,
+					alias:        "builder",
+					pullspec:     "docker.io/library/golang:1.22",
+					digestBase:   "docker.io/library/golang@" + string(testDigest("a01")),
+					sources:      []string{"/go/bin/app"},
+					archiveDests: []string{"/opt/vendor", "/go/src"},
+					descendants: []*packageSourceDescendant{
+						{
+							index:        1,
+							alias:        "chained",
+							sources:      []string{"/go/bin/app"},
+							archiveDests: []string{"/srv"},
+						},
+					},
+				},
+			},
+		},
+		"copies from unnamed stages by index": {
+			cf: containerfile.Containerfile{Stages: []containerfile.Stage{
+				{
+					Alias:   "0",
+					Base:    "docker.io/library/golang:1.22",
+					BaseRef: "docker.io/library/golang:1.22",
+					Index:   0,
+					Copies:  []containerfile.Copy{},
+				},
+				{
+					Alias:   "tools",
+					Base:    "docker.io/library/fedora:latest",
+					BaseRef: "docker.io/library/fedora:latest",
+					Index:   1,
+					Copies:  []containerfile.Copy{},
+				},
+				{
+					Alias:   "2",
+					Base:    "docker.io/library/golang:1.22",
+					BaseRef: "docker.io/library/golang:1.22",
+					Index:   2,
+					Copies:  []containerfile.Copy{},
+				},
+				{
+					Alias:   "3",
+					Base:    "scratch",
+					BaseRef: "scratch",
+					Index:   3,
+					Kind:    containerfile.StageKindFinal,
+					Copies: []containerfile.Copy{
+						{
+							From:        "0",
+							Sources:     []string{"/go/bin/app"},
+							Destination: "/usr/bin/app",
+							Type:        containerfile.CopyTypeBuilder,
+						},
+						{
+							From:        "tools",
+							Sources:     []string{"/usr/bin/oras"},
+							Destination: "/usr/bin/oras",
+							Type:        containerfile.CopyTypeBuilder,
+						},
+						{
+							From:        "2",
+							Sources:     []string{"/go/bin/other"},
+							Destination: "/usr/bin/other",
+							Type:        containerfile.CopyTypeBuilder,
+						},
+					},
+				},
+			}},
+			digests: map[string]digest.Digest{
+				"docker.io/library/golang:1.22":   testDigest("a01"),
+				"docker.io/library/fedora:latest": testDigest("def456"),
+			},
+			configs: map[string]storageclient.OCIImageConfig{
+				"docker.io/library/golang:1.22":   configWithWorkdir("/go"),
+				"docker.io/library/fedora:latest": configWithWorkdir("/"),
+			},
+			expectedRoots: []packageSource{
+				{
+					index:      0,
+					alias:      "0",
+					pullspec:   "docker.io/library/golang:1.22",
+					digestBase: "docker.io/library/golang@" + string(testDigest("a01")),
+					sources:    []string{"/go/bin/app"},
+				},
+				{
+					index:      1,
+					alias:      "tools",
+					pullspec:   "docker.io/library/fedora:latest",
+					digestBase: "docker.io/library/fedora@" + string(testDigest("def456")),
+					sources:    []string{"/usr/bin/oras"},
+				},
+				{
+					index:      2,
+					alias:      "2",
+					pullspec:   "docker.io/library/golang:1.22",
+					digestBase: "docker.io/library/golang@" + string(testDigest("a01")),
+					sources:    []string{"/go/bin/other"},
+				},
+			},
+		},
 		"recursive multi-stage file copy": {
 			cf: containerfile.Containerfile{Stages: []containerfile.Stage{
 				{
@@ -159,10 +325,11 @@ func TestGetPackageSources(t *testing.T) {
 					},
 				},
 				{
-					Alias:   containerfile.FinalStage,
+					Alias:   "2",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   2,
+					Kind:    containerfile.StageKindFinal,
 					Copies: []containerfile.Copy{
 						{
 							From:        "builder2",
@@ -222,10 +389,11 @@ func TestGetPackageSources(t *testing.T) {
 					},
 				},
 				{
-					Alias:   containerfile.FinalStage,
+					Alias:   "2",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   2,
+					Kind:    containerfile.StageKindFinal,
 					Copies: []containerfile.Copy{
 						{
 							From:        "builder2",
@@ -291,10 +459,11 @@ func TestGetPackageSources(t *testing.T) {
 					},
 				},
 				{
-					Alias:   containerfile.FinalStage,
+					Alias:   "2",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   2,
+					Kind:    containerfile.StageKindFinal,
 					Copies: []containerfile.Copy{
 						{
 							From:        "builder2",
@@ -354,10 +523,11 @@ func TestGetPackageSources(t *testing.T) {
 					},
 				},
 				{
-					Alias:   containerfile.FinalStage,
+					Alias:   "2",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   2,
+					Kind:    containerfile.StageKindFinal,
 					Copies: []containerfile.Copy{
 						{
 							From:        "builder2",
@@ -417,10 +587,11 @@ func TestGetPackageSources(t *testing.T) {
 					},
 				},
 				{
-					Alias:   containerfile.FinalStage,
+					Alias:   "2",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   2,
+					Kind:    containerfile.StageKindFinal,
 					Copies: []containerfile.Copy{
 						{
 							From:        "builder1",
@@ -478,10 +649,11 @@ func TestGetPackageSources(t *testing.T) {
 					Copies:  []containerfile.Copy{},
 				},
 				{
-					Alias:   containerfile.FinalStage,
+					Alias:   "1",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   1,
+					Kind:    containerfile.StageKindFinal,
 					Copies: []containerfile.Copy{
 						{
 							From:        "builder",
@@ -530,10 +702,11 @@ func TestGetPackageSources(t *testing.T) {
 					},
 				},
 				{
-					Alias:   containerfile.FinalStage,
+					Alias:   "2",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   2,
+					Kind:    containerfile.StageKindFinal,
 					Copies: []containerfile.Copy{
 						{
 							From:        "builder2",
@@ -578,10 +751,11 @@ func TestGetPackageSources(t *testing.T) {
 					Copies:  []containerfile.Copy{},
 				},
 				{
-					Alias:   containerfile.FinalStage,
+					Alias:   "1",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   1,
+					Kind:    containerfile.StageKindFinal,
 					Copies: []containerfile.Copy{
 						{
 							From:        "builder",
@@ -640,10 +814,11 @@ func TestGetPackageSources(t *testing.T) {
 					},
 				},
 				{
-					Alias:   containerfile.FinalStage,
+					Alias:   "2",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   2,
+					Kind:    containerfile.StageKindFinal,
 					Copies: []containerfile.Copy{
 						{
 							From:        "builder2",
@@ -734,10 +909,11 @@ func TestGetPackageSources(t *testing.T) {
 					},
 				},
 				{
-					Alias:   containerfile.FinalStage,
+					Alias:   "2",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   2,
+					Kind:    containerfile.StageKindFinal,
 					Copies: []containerfile.Copy{
 						{
 							From:        "builder2",
@@ -830,10 +1006,11 @@ func TestGetPackageSources(t *testing.T) {
 					},
 				},
 				{
-					Alias:   containerfile.FinalStage,
+					Alias:   "3",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   3,
+					Kind:    containerfile.StageKindFinal,
 					Copies: []containerfile.Copy{
 						{
 							From:        "builder3",
@@ -923,10 +1100,11 @@ func TestGetPackageSources(t *testing.T) {
 					},
 				},
 				{
-					Alias:   containerfile.FinalStage,
+					Alias:   "4",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   4,
+					Kind:    containerfile.StageKindFinal,
 					Copies: []containerfile.Copy{
 						{
 							From:        "go-runner",
@@ -996,10 +1174,11 @@ func TestGetPackageSources(t *testing.T) {
 					Copies:  []containerfile.Copy{},
 				},
 				{
-					Alias:   containerfile.FinalStage,
+					Alias:   "1",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   1,
+					Kind:    containerfile.StageKindFinal,
 					Copies: []containerfile.Copy{
 						{
 							From:        "0",
@@ -1026,6 +1205,71 @@ func TestGetPackageSources(t *testing.T) {
 				},
 			},
 		},
+		"copy traced through RUN mv in builder stage": {
+			cf: containerfile.Containerfile{Stages: []containerfile.Stage{
+				{
+					Alias:   "builder1",
+					Base:    "docker.io/library/fedora:latest",
+					BaseRef: "docker.io/library/fedora:latest",
+					Index:   0,
+					Copies:  []containerfile.Copy{},
+				},
+				{
+					Alias:   "builder2",
+					Base:    "docker.io/library/fedora:latest",
+					BaseRef: "docker.io/library/fedora:latest",
+					Index:   1,
+					Copies: []containerfile.Copy{
+						{
+							From:        "builder1",
+							Sources:     []string{"/out/app"},
+							Destination: "/build/app",
+							Type:        containerfile.CopyTypeBuilder,
+						},
+					},
+					Moves: []containerfile.Move{
+						{Source: "/build/app", Destination: "/usr/bin/app"},
+					},
+				},
+				{
+					Alias:   "2",
+					Base:    "scratch",
+					BaseRef: "scratch",
+					Index:   2,
+					Kind:    containerfile.StageKindFinal,
+					Copies: []containerfile.Copy{
+						{
+							From:        "builder2",
+							Sources:     []string{"/usr/bin/app"},
+							Destination: "/usr/bin/app",
+							Type:        containerfile.CopyTypeBuilder,
+						},
+					},
+				},
+			}},
+			digests: map[string]digest.Digest{
+				"docker.io/library/fedora:latest": testDigest("aaa111"),
+			},
+			configs: map[string]storageclient.OCIImageConfig{
+				"docker.io/library/fedora:latest": configWithWorkdir("/"),
+			},
+			expectedRoots: []packageSource{
+				{
+					index:      0,
+					alias:      "builder1",
+					pullspec:   "docker.io/library/fedora:latest",
+					digestBase: "docker.io/library/fedora@" + string(testDigest("aaa111")),
+					sources:    []string{"/out/app"},
+				},
+				{
+					index:      1,
+					alias:      "builder2",
+					pullspec:   "docker.io/library/fedora:latest",
+					digestBase: "docker.io/library/fedora@" + string(testDigest("aaa111")),
+					sources:    []string{},
+				},
+			},
+		},
 		"numeric index COPY --from in builder stage with aliased stages": {
 			cf: containerfile.Containerfile{Stages: []containerfile.Stage{
 				{
@@ -1050,10 +1294,11 @@ func TestGetPackageSources(t *testing.T) {
 					},
 				},
 				{
-					Alias:   containerfile.FinalStage,
+					Alias:   "2",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   2,
+					Kind:    containerfile.StageKindFinal,
 					Copies: []containerfile.Copy{
 						{
 							From:        "builder2",
@@ -1109,10 +1354,11 @@ func TestGetPackageSources(t *testing.T) {
 					Copies:  []containerfile.Copy{},
 				},
 				{
-					Alias:   containerfile.FinalStage,
+					Alias:   "2",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   2,
+					Kind:    containerfile.StageKindFinal,
 					Copies: []containerfile.Copy{
 						{
 							From:        "child",
@@ -1166,10 +1412,11 @@ func TestGetPackageSources(t *testing.T) {
 					Copies:  []containerfile.Copy{},
 				},
 				{
-					Alias:   containerfile.FinalStage,
+					Alias:   "2",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   2,
+					Kind:    containerfile.StageKindFinal,
 					Copies: []containerfile.Copy{
 						{
 							From:        "empty-child",
@@ -1231,10 +1478,11 @@ func TestGetPackageSources(t *testing.T) {
 					Copies:  []containerfile.Copy{},
 				},
 				{
-					Alias:   containerfile.FinalStage,
+					Alias:   "3",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   3,
+					Kind:    containerfile.StageKindFinal,
 					Copies: []containerfile.Copy{
 						{
 							From:        "left",
@@ -1296,10 +1544,11 @@ func TestGetPackageSources(t *testing.T) {
 					},
 				},
 				{
-					Alias:   containerfile.FinalStage,
+					Alias:   "1",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   1,
+					Kind:    containerfile.StageKindFinal,
 					Copies: []containerfile.Copy{
 						{
 							From:        "builder",
@@ -1329,7 +1578,7 @@ func TestGetPackageSources(t *testing.T) {
 					pullspec:   "docker.io/library/external:latest",
 					digestBase: "docker.io/library/external@" + string(testDigest("fff222")),
 					sources:    []string{"/ext/bin"},
-					external:   true,
+					kind:       containerfile.StageKindExternal,
 				},
 			},
 		},
@@ -1343,7 +1592,7 @@ func TestGetPackageSources(t *testing.T) {
 				test.digests, test.configs,
 			)
 
-			roots, err := getPackageSources(client, test.cf, test.digests)
+			roots, _, _, err := getPackageSources(client, test.cf, test.digests, nil)
 			if err != nil {
 				t.Fatalf("getPackageSources returned error: %v", err)
 			}
@@ -1352,8 +1601,8 @@ func TestGetPackageSources(t *testing.T) {
 				test.expectedRoots, roots,
 				cmp.AllowUnexported(packageSource{}, packageSourceDescendant{}),
 				cmpopts.SortSlices(func(a, b packageSource) bool {
-					if a.external != b.external {
-						return !a.external
+					if a.kind != b.kind {
+						return a.kind < b.kind
 					}
 					return a.index < b.index
 				}),
@@ -1401,6 +1650,149 @@ func TestResolveRelativeDestination(t *testing.T) {
 	}
 }
 
+func TestResolveMountTargets(t *testing.T) {
+	t.Parallel()
+	stage := containerfile.Stage{
+		Base: "docker.io/library/node:20",
+		Mounts: []containerfile.Mount{
+			{MountType: containerfile.MountTypeCache, Target: "/root/.npm/"},
+			{MountType: containerfile.MountTypeCache, Target: "node_modules"},
+			{MountType: containerfile.MountTypeCache, Target: "cache", Workdir: "/src"},
+			{MountType: containerfile.MountTypeCache},
+			{MountType: containerfile.MountTypeBind, FromRaw: "builder", Target: "/app"},
+			{MountType: containerfile.MountTypeTmpfs, Target: "/tmp"},
+			{MountType: containerfile.MountTypeSecret, Target: "/run/secrets/token"},
+			{MountType: containerfile.MountTypeSSH, Target: "/run/buildkit/ssh_agent.0"},
+		},
+	}
+	baseToWorkdir := map[string]string{"docker.io/library/node:20": "/home/node"}
+
+	tests := map[string]struct {
+		mountTypes []containerfile.MountType
+		expected   []string
+	}{
+		"cache mounts": {
+			mountTypes: []containerfile.MountType{containerfile.MountTypeCache},
+			expected:   []string{"/root/.npm", "/home/node/node_modules", "/src/cache"},
+		},
+		"secret and ssh mounts": {
+			mountTypes: []containerfile.MountType{containerfile.MountTypeSecret, containerfile.MountTypeSSH},
+			expected:   []string{"/run/secrets/token", "/run/buildkit/ssh_agent.0"},
+		},
+		"no types": {
+			expected: nil,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got := resolveMountTargets(stage, baseToWorkdir, test.mountTypes...)
+			if diff := cmp.Diff(test.expected, got); diff != "" {
+				t.Errorf("resolveMountTargets() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMovedSources(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		moves       []containerfile.Move
+		source      string
+		baseWorkdir string
+		expected    []string
+	}{
+		"no moves": {
+			source:   "/usr/bin/app",
+			expected: []string{"/usr/bin/app"},
+		},
+		"moved path": {
+			moves:    []containerfile.Move{{Source: "/build/out", Destination: "/app/bin"}},
+			source:   "/app/bin",
+			expected: []string{"/app/bin", "/build/out"},
+		},
+		"path under moved directory": {
+			moves:    []containerfile.Move{{Source: "/build/out", Destination: "/app/bin"}},
+			source:   "/app/bin/server",
+			expected: []string{"/app/bin/server", "/build/out/server"},
+		},
+		"sibling of moved path with common prefix": {
+			moves:    []containerfile.Move{{Source: "/build/out", Destination: "/app/bin"}},
+			source:   "/app/binaries",
+			expected: []string{"/app/binaries"},
+		},
+		"source covering moved path": {
+			moves:    []containerfile.Move{{Source: "/build/out", Destination: "/app/bin"}},
+			source:   "/app/",
+			expected: []string{"/app/", "/build/out"},
+		},
+		"chained moves": {
+			moves: []containerfile.Move{
+				{Source: "/build/out", Destination: "/tmp/out"},
+				{Source: "/tmp/out", Destination: "/app"},
+			},
+			source:   "/app/server",
+			expected: []string{"/app/server", "/tmp/out/server", "/build/out/server"},
+		},
+		"relative paths": {
+			moves:       []containerfile.Move{{Source: "out", Destination: "bin/app", Workdir: "src"}},
+			source:      "/home/src/bin/app",
+			baseWorkdir: "/home",
+			expected:    []string{"/home/src/bin/app", "/home/src/out"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			stage := &containerfile.Stage{Moves: test.moves}
+			baseWorkdir := test.baseWorkdir
+			if baseWorkdir == "" {
+				baseWorkdir = "/"
+			}
+			got := movedSources(test.source, stage, baseWorkdir)
+			if diff := cmp.Diff(test.expected, got); diff != "" {
+				t.Errorf("movedSources() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGetPackageMetadataArchiveOrigin(t *testing.T) {
+	t.Parallel()
+	builderPkgs := []sbom.SyftPackage{
+		{PURL: "pkg:rpm/fedora/glibc", Locations: []string{"/opt/vendor/var/lib/rpm/rpmdb.sqlite"}},
+	}
+	intermediatePkgs := []sbom.SyftPackage{
+		{PURL: "pkg:golang/vendored", Locations: []string{"/opt/vendor/go.mod"}},
+		{PURL: "pkg:golang/built", Locations: []string{"/go/bin/app"}},
+		{PURL: "pkg:golang/both", Locations: []string{"/go/bin/app", "/opt/vendor/sub/go.mod"}},
+		{PURL: "pkg:golang/sibling", Locations: []string{"/opt/vendored/go.mod"}},
+	}
+
+	items := getPackageMetadata(
+		"builder", "docker.io/library/golang@sha256:abc", "builder", "intermediate",
+		[]string{"/opt/vendor"}, builderPkgs, intermediatePkgs,
+	)
+
+	actual := make(map[string]string)
+	for _, item := range items {
+		actual[item.PackageURL] = item.OriginType
+	}
+	expected := map[string]string{
+		// builder content comes from the base image, not from the archive
+		"pkg:rpm/fedora/glibc": "builder",
+		"pkg:golang/vendored":  "context-archive",
+		"pkg:golang/built":     "intermediate",
+		"pkg:golang/both":      "context-archive",
+		"pkg:golang/sibling":   "intermediate",
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("getPackageMetadata() origin types mismatch (-want +got):\n%s", diff)
+	}
+}
+
 func TestGetPackageSourcesError(t *testing.T) {
 	t.Parallel()
 	tests := map[string]struct {
@@ -1419,10 +1811,11 @@ func TestGetPackageSourcesError(t *testing.T) {
 					Copies:  []containerfile.Copy{},
 				},
 				{
-					Alias:   containerfile.FinalStage,
+					Alias:   "1",
 					Base:    "docker.io/library/ubi9:latest",
 					BaseRef: "docker.io/library/ubi9:latest",
-					Index:   -1,
+					Index:   1,
+					Kind:    containerfile.StageKindFinal,
 					Copies:  []containerfile.Copy{},
 				},
 			}},
@@ -1439,7 +1832,7 @@ func TestGetPackageSourcesError(t *testing.T) {
 				test.digests, test.configs,
 			)
 
-			_, err := getPackageSources(client, test.cf, test.digests)
+			_, _, _, err := getPackageSources(client, test.cf, test.digests, nil)
 			if err == nil {
 				t.Fatal("expected error, got nil")
 			}
@@ -1450,14 +1843,117 @@ func TestGetPackageSourcesError(t *testing.T) {
 	}
 }
 
+func TestGetPackageSourcesCoverage(t *testing.T) {
+	t.Parallel()
+	cf := containerfile.Containerfile{Stages: []containerfile.Stage{
+		{
+			Alias:   "builder",
+			Base:    "docker.io/library/fedora:latest",
+			BaseRef: "docker.io/library/fedora:latest",
+			Index:   0,
+			Moves: []containerfile.Move{
+				{Source: "/build/app", Destination: "/usr/bin/app"},
+			},
+		},
+		{
+			Alias:   "1",
+			Base:    "scratch",
+			BaseRef: "scratch",
+			Index:   1,
+			Kind:    containerfile.StageKindFinal,
+			Copies: []containerfile.Copy{
+				{
+					From:        "builder",
+					Sources:     []string{"/usr/bin/app", "/etc/app.conf"},
+					Destination: "/app/",
+					Type:        containerfile.CopyTypeBuilder,
+				},
+				{
+					From:        "docker.io/library/external:latest",
+					Sources:     []string{"/ext/bin"},
+					Destination: "/ext/bin",
+					Type:        containerfile.CopyTypeExternal,
+				},
+				{
+					From:        "vendor",
+					Sources:     []string{"/deps"},
+					Destination: "/deps",
+					Type:        containerfile.CopyTypeContext,
+				},
+			},
+			ContextCopies: []containerfile.Copy{
+				{
+					Sources:     []string{"config.yaml"},
+					Destination: "/etc/app/",
+					Type:        containerfile.CopyTypeContext,
+				},
+			},
+			ArchiveAdds: []containerfile.Copy{
+				{
+					Sources:     []string{"rootfs.tar.gz"},
+					Destination: "/",
+					Type:        containerfile.CopyTypeContext,
+				},
+			},
+		},
+	}}
+	digests := map[string]digest.Digest{
+		"docker.io/library/fedora:latest":   testDigest("aaa111"),
+		"docker.io/library/external:latest": testDigest("bbb222"),
+	}
+	configs := map[string]storageclient.OCIImageConfig{
+		"docker.io/library/fedora:latest": configWithWorkdir("/"),
+	}
+
+	expected := &Coverage{
+		Attributed:         2,
+		BestEffort:         1,
+		Skipped:            3,
+		AttributedFraction: 2.0 / 6,
+		Sources: []CoverageSource{
+			{
+				Source: "/usr/bin/app", From: "builder", Destination: "/app/",
+				Status: CoverageBestEffort, Reason: CoverageReasonRunMove,
+			},
+			{Source: "/etc/app.conf", From: "builder", Destination: "/app/", Status: CoverageAttributed},
+			{
+				Source: "/ext/bin", From: "docker.io/library/external:latest", Destination: "/ext/bin",
+				Status: CoverageAttributed,
+			},
+			{
+				Source: "/deps", From: "vendor", Destination: "/deps",
+				Status: CoverageSkipped, Reason: CoverageReasonNamedContext,
+			},
+			{
+				Source: "config.yaml", Destination: "/etc/app/",
+				Status: CoverageSkipped, Reason: CoverageReasonContext,
+			},
+			{
+				Source: "rootfs.tar.gz", Destination: "/",
+				Status: CoverageSkipped, Reason: CoverageReasonContextArchive,
+			},
+		},
+	}
+
+	client := testutils.NewTStorageClient(digests, configs)
+	_, _, actual, err := getPackageSources(client, cf, digests, nil)
+	if err != nil {
+		t.Fatalf("getPackageSources returned error: %v", err)
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("getPackageSources() coverage mismatch (-want +got):\n%s", diff)
+	}
+}
+
 func TestScanPreflightErrors(t *testing.T) {
 	t.Parallel()
 	tests := map[string]struct {
 		cf         containerfile.Containerfile
+		opts       []Option
 		expectErrs []error
 		rejectErrs []error
 	}{
-		"duplicate stage alias": {
+		"duplicate stage alias referenced before redefinition": {
 			cf: containerfile.Containerfile{Stages: []containerfile.Stage{
 				{
 					Alias:   "builder",
@@ -1465,17 +1961,27 @@ func TestScanPreflightErrors(t *testing.T) {
 					BaseRef: "docker.io/library/golang:1.22",
 					Index:   0,
 				},
+				{
+					Alias:   "child",
+					Base:    "docker.io/library/golang:1.22",
+					BaseRef: "builder",
+					Index:   1,
+				},
 				{
 					Alias:   "builder",
 					Base:    "docker.io/library/node:20",
 					BaseRef: "docker.io/library/node:20",
-					Index:   1,
+					Index:   2,
 				},
 				{
-					Alias:   containerfile.FinalStage,
+					Alias:   "3",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   3,
+					Kind:    containerfile.StageKindFinal,
+					Copies: []containerfile.Copy{
+						{From: "child", Sources: []string{"/app"}, Destination: "/app"},
+					},
 				},
 			}},
 			expectErrs: []error{ErrUnsupportedFeature, ErrDuplicateAlias},
@@ -1493,15 +1999,38 @@ func TestScanPreflightErrors(t *testing.T) {
 					},
 				},
 				{
-					Alias:   containerfile.FinalStage,
+					Alias:   "1",
 					Base:    "scratch",
 					BaseRef: "scratch",
-					Index:   -1,
+					Index:   1,
+					Kind:    containerfile.StageKindFinal,
 				},
 			}},
 			expectErrs: []error{ErrUnsupportedFeature, ErrMountTypeBind},
 			rejectErrs: []error{ErrDuplicateAlias},
 		},
+		"bind mount with from and ignore mount policy": {
+			cf: containerfile.Containerfile{Stages: []containerfile.Stage{
+				{
+					Alias:   "builder",
+					Base:    "docker.io/library/golang:1.22",
+					BaseRef: "docker.io/library/golang:1.22",
+					Index:   0,
+					Mounts: []containerfile.Mount{
+						{MountType: containerfile.MountTypeBind, FromRaw: "docker.io/library/some-image:latest"},
+					},
+				},
+				{
+					Alias:   "1",
+					Base:    "scratch",
+					BaseRef: "scratch",
+					Index:   1,
+					Kind:    containerfile.StageKindFinal,
+				},
+			}},
+			opts:       []Option{WithMountPolicy(MountPolicyIgnore)},
+			rejectErrs: []error{ErrUnsupportedFeature, ErrMountTypeBind},
+		},
 		"multiple preflight errors": {
 			cf: containerfile.Containerfile{Stages: []containerfile.Stage{
 				{
@@ -1515,27 +2044,30 @@ func TestScanPreflightErrors(t *testing.T) {
 					Base:    "docker.io/library/node:20",
 					BaseRef: "docker.io/library/node:20",
 					Index:   1,
+					Mounts: []containerfile.Mount{
+						{MountType: containerfile.MountTypeBind, FromRaw: "builder"},
+					},
 				},
 				{
-					Alias:   containerfile.FinalStage,
-					Base:    "builder",
-					BaseRef: "builder",
-					Index:   -1,
+					Alias:   "2",
+					Base:    "scratch",
+					BaseRef: "scratch",
+					Index:   2,
+					Kind:    containerfile.StageKindFinal,
 				},
 			}},
-			expectErrs: []error{ErrUnsupportedFeature, ErrDuplicateAlias},
-			rejectErrs: []error{ErrMountTypeBind},
+			expectErrs: []error{ErrUnsupportedFeature, ErrDuplicateAlias, ErrMountTypeBind},
 		},
 	}
 
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
-			s, _ := NewScanner(
+			s, _ := NewScanner(append([]Option{
 				WithLogger(
 					slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})),
 				),
-			)
+			}, tc.opts...)...)
 			_, err := s.Scan(tc.cf)
 
 			for _, expected := range tc.expectErrs {
@@ -1552,3 +2084,392 @@ func TestScanPreflightErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckDuplicateAlias(t *testing.T) {
+	t.Parallel()
+	golang := containerfile.Stage{
+		Alias:   "builder",
+		Base:    "docker.io/library/golang:1.22",
+		BaseRef: "docker.io/library/golang:1.22",
+		Index:   0,
+	}
+	node := containerfile.Stage{
+		Alias:   "builder",
+		Base:    "docker.io/library/node:20",
+		BaseRef: "docker.io/library/node:20",
+		Index:   1,
+	}
+
+	tests := map[string]struct {
+		stages           []containerfile.Stage
+		expectedErr      error
+		expectedWarnings []Warning
+	}{
+		"no duplicates": {
+			stages: []containerfile.Stage{
+				golang,
+				{Alias: "1", Base: "scratch", BaseRef: "scratch", Index: 1, Kind: containerfile.StageKindFinal},
+			},
+		},
+		"last definition referenced": {
+			stages: []containerfile.Stage{
+				golang,
+				node,
+				{
+					Alias:   "2",
+					Base:    "scratch",
+					BaseRef: "scratch",
+					Index:   2,
+					Kind:    containerfile.StageKindFinal,
+					Copies: []containerfile.Copy{
+						{From: "builder", Sources: []string{"/app"}, Destination: "/app"},
+					},
+				},
+			},
+			expectedWarnings: []Warning{
+				{
+					Code:    WarnDuplicateAlias,
+					Message: `stage alias "builder" is defined by stages [0 1], content is attributed to the last definition (stage 1)`,
+				},
+			},
+		},
+		"earlier definition referenced by index": {
+			stages: []containerfile.Stage{
+				golang,
+				node,
+				{
+					Alias:   "2",
+					Base:    "scratch",
+					BaseRef: "scratch",
+					Index:   2,
+					Kind:    containerfile.StageKindFinal,
+					Copies: []containerfile.Copy{
+						{From: "0", Sources: []string{"/app"}, Destination: "/app"},
+					},
+				},
+			},
+			expectedErr: ErrDuplicateAlias,
+			expectedWarnings: []Warning{
+				{
+					Code:    WarnDuplicateAlias,
+					Message: `stage alias "builder" is defined by stages [0 1], content is attributed to the last definition (stage 1)`,
+				},
+			},
+		},
+		"earlier definition used as base before redefinition": {
+			stages: []containerfile.Stage{
+				golang,
+				{
+					Alias:   "child",
+					Base:    "docker.io/library/golang:1.22",
+					BaseRef: "builder",
+					Index:   1,
+				},
+				{
+					Alias:   "builder",
+					Base:    "docker.io/library/node:20",
+					BaseRef: "docker.io/library/node:20",
+					Index:   2,
+				},
+				{Alias: "3", Base: "scratch", BaseRef: "scratch", Index: 3, Kind: containerfile.StageKindFinal},
+			},
+			expectedErr: ErrDuplicateAlias,
+			expectedWarnings: []Warning{
+				{
+					Code:    WarnDuplicateAlias,
+					Message: `stage alias "builder" is defined by stages [0 2], content is attributed to the last definition (stage 2)`,
+				},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			cf := containerfile.Containerfile{Stages: tc.stages}
+
+			err := checkDuplicateAlias(cf)
+			if tc.expectedErr == nil && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error wrapping %v, got: %v", tc.expectedErr, err)
+			}
+
+			if diff := cmp.Diff(tc.expectedWarnings, duplicateAliasWarnings(cf), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("duplicateAliasWarnings() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAmbiguousAliasWarnings(t *testing.T) {
+	t.Parallel()
+	helm := containerfile.Stage{
+		Alias: "helm", Base: "docker.io/library/fedora:latest", BaseRef: "docker.io/library/fedora:latest", Index: 1,
+	}
+	final := func(copies ...containerfile.Copy) containerfile.Stage {
+		return containerfile.Stage{
+			Alias: "2", Base: "scratch", BaseRef: "scratch", Index: 2, Kind: containerfile.StageKindFinal, Copies: copies,
+		}
+	}
+	fromHelm := containerfile.Copy{From: "helm", Sources: []string{"/a"}, Destination: "/a", Type: containerfile.CopyTypeBuilder}
+	external := func(pullspec string) containerfile.Copy {
+		return containerfile.Copy{From: pullspec, Sources: []string{"/b"}, Destination: "/b", Type: containerfile.CopyTypeExternal}
+	}
+	tools := func(copies ...containerfile.Copy) containerfile.Stage {
+		return containerfile.Stage{
+			Alias: "tools", Base: "docker.io/library/fedora:latest", BaseRef: "docker.io/library/fedora:latest", Copies: copies,
+		}
+	}
+	ambiguous := []Warning{{
+		Code: WarnAmbiguousAlias,
+		Message: `stage alias "helm" (stage 1) is also the name of image docker.io/alpine/helm:latest, ` +
+			`references after the stage are to the stage`,
+	}}
+
+	tests := map[string]struct {
+		stages   []containerfile.Stage
+		expected []Warning
+	}{
+		"no image with the name": {
+			stages: []containerfile.Stage{tools(), helm, final(fromHelm)},
+		},
+		"image with the name copied from": {
+			stages:   []containerfile.Stage{tools(), helm, final(fromHelm, external("docker.io/alpine/helm:latest"))},
+			expected: ambiguous,
+		},
+		"image with the name copied from before the stage": {
+			stages:   []containerfile.Stage{tools(external("docker.io/alpine/helm:latest")), helm, final(fromHelm)},
+			expected: ambiguous,
+		},
+		"stage not referenced by name": {
+			stages: []containerfile.Stage{tools(), helm, final(external("docker.io/alpine/helm:latest"))},
+		},
+		"base image with the name": {
+			stages: []containerfile.Stage{
+				tools(),
+				{
+					Alias: "helm", Base: "docker.io/alpine/helm:3", BaseRef: "docker.io/alpine/helm:3", Index: 1,
+				},
+				final(fromHelm),
+			},
+			expected: []Warning{{
+				Code: WarnAmbiguousAlias,
+				Message: `stage alias "helm" (stage 1) is also the name of image docker.io/alpine/helm:3, ` +
+					`references after the stage are to the stage`,
+			}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			actual := ambiguousAliasWarnings(containerfile.Containerfile{Stages: tc.stages})
+			if diff := cmp.Diff(tc.expected, actual, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("ambiguousAliasWarnings() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestIndexDigests(t *testing.T) {
+	t.Parallel()
+	digests := map[string]digest.Digest{
+		"quay.io/konflux-ci/multiarch:latest": testDigest("a"),
+		"quay.io/konflux-ci/single:latest":    testDigest("b"),
+	}
+	client := testutils.NewTStorageClient(digests, nil).WithIndexDigests(map[string]digest.Digest{
+		"quay.io/konflux-ci/multiarch:latest": testDigest("c"),
+	})
+
+	indexDigests, err := getIndexDigests(client, digests)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedIndexDigests := map[string]digest.Digest{
+		"quay.io/konflux-ci/multiarch@" + testDigest("a").String(): testDigest("c"),
+	}
+	if diff := cmp.Diff(expectedIndexDigests, indexDigests); diff != "" {
+		t.Fatalf("getIndexDigests() mismatch (-want +got):\n%s", diff)
+	}
+
+	items := []PackageMetadataItem{
+		{PackageURL: "pkg:generic/a", Pullspec: "quay.io/konflux-ci/multiarch@" + testDigest("a").String()},
+		{PackageURL: "pkg:generic/b", Pullspec: "quay.io/konflux-ci/single@" + testDigest("b").String()},
+	}
+	setIndexDigests(items, indexDigests)
+	expected := []PackageMetadataItem{
+		{
+			PackageURL:  "pkg:generic/a",
+			Pullspec:    "quay.io/konflux-ci/multiarch@" + testDigest("a").String(),
+			IndexDigest: testDigest("c").String(),
+		},
+		{PackageURL: "pkg:generic/b", Pullspec: "quay.io/konflux-ci/single@" + testDigest("b").String()},
+	}
+	if diff := cmp.Diff(expected, items); diff != "" {
+		t.Errorf("setIndexDigests() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestStagePlatforms(t *testing.T) {
+	t.Parallel()
+	const golang = "docker.io/library/golang:1.26"
+	tests := map[string]struct {
+		stages      []containerfile.Stage
+		expected    map[string]storageclient.Platform
+		expectedErr error
+	}{
+		"build platform base": {
+			stages: []containerfile.Stage{
+				{Alias: "builder", Base: golang, BaseRef: golang, Platform: "linux/amd64"},
+				{Alias: "tests", Base: golang, BaseRef: "builder", Platform: "linux/arm64", Index: 1},
+				{Alias: "2", Base: "scratch", BaseRef: "scratch", Platform: "linux/arm64", Index: 2},
+			},
+			expected: map[string]storageclient.Platform{
+				golang: {OS: "linux", Architecture: "amd64"},
+			},
+		},
+		"first stage wins": {
+			stages: []containerfile.Stage{
+				{Alias: "a", Base: golang, BaseRef: golang, Platform: "linux/arm64/v8"},
+				{Alias: "b", Base: golang, BaseRef: golang, Platform: "linux/amd64", Index: 1},
+			},
+			expected: map[string]storageclient.Platform{
+				golang: {OS: "linux", Architecture: "arm64", Variant: "v8"},
+			},
+		},
+		"no platforms": {
+			stages:   []containerfile.Stage{{Alias: "0", Base: golang, BaseRef: golang}},
+			expected: map[string]storageclient.Platform{},
+		},
+		"invalid platform": {
+			stages:      []containerfile.Stage{{Alias: "builder", Base: golang, BaseRef: golang, Platform: "amd64"}},
+			expectedErr: storageclient.ErrInvalidPlatform,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			platforms, err := stagePlatforms(containerfile.Containerfile{Stages: tt.stages})
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tt.expectedErr, err)
+			}
+			if diff := cmp.Diff(tt.expected, platforms); diff != "" {
+				t.Errorf("stagePlatforms() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSetStageIndex(t *testing.T) {
+	t.Parallel()
+	items := []PackageMetadataItem{
+		{PackageURL: "pkg:rpm/fedora/bash@5.2", StageAlias: "builder"},
+		{PackageURL: "pkg:rpm/fedora/curl@8.6", StageAlias: "builder"},
+	}
+
+	setStageIndex(items, 2)
+
+	for _, item := range items {
+		if item.StageIndex == nil || *item.StageIndex != 2 {
+			t.Errorf("expected stage index 2 for %q, got %v", item.PackageURL, item.StageIndex)
+		}
+	}
+}
+
+func TestResolveDigests(t *testing.T) {
+	t.Parallel()
+	pullspecs := make([]string, 0, 2*resolveConcurrency)
+	digests := make(map[string]digest.Digest)
+	for i := range 2 * resolveConcurrency {
+		pullspec := fmt.Sprintf("quay.io/konflux-ci/image-%d:latest", i)
+		pullspecs = append(pullspecs, pullspec)
+		digests[pullspec] = digest.FromString(pullspec)
+	}
+	client := testutils.NewTStorageClient(digests, nil)
+
+	actual, err := resolveDigests(client, pullspecs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(digests, actual); diff != "" {
+		t.Errorf("resolveDigests() mismatch (-want +got):\n%s", diff)
+	}
+
+	_, err = resolveDigests(client, append(slices.Clone(pullspecs), "missing:1", "missing:2"))
+	if !errors.Is(err, ErrPullspecResolve) || !strings.Contains(err.Error(), `"missing:1"`) {
+		t.Errorf("expected error wrapping %v for missing:1, got: %v", ErrPullspecResolve, err)
+	}
+}
+
+func TestPackageSourceEmpty(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		source   packageSource
+		expected bool
+	}{
+		"no sources": {
+			source:   packageSource{alias: "builder"},
+			expected: true,
+		},
+		"sources": {
+			source:   packageSource{alias: "builder", sources: []string{"/app"}},
+			expected: false,
+		},
+		"descendants without sources": {
+			source: packageSource{alias: "builder", descendants: []*packageSourceDescendant{
+				{alias: "test", descendants: []*packageSourceDescendant{{alias: "lint"}}},
+			}},
+			expected: true,
+		},
+		"chained descendant with sources": {
+			source: packageSource{alias: "builder", descendants: []*packageSourceDescendant{
+				{alias: "test"},
+				{alias: "build", descendants: []*packageSourceDescendant{
+					{alias: "release", sources: []string{"/app"}},
+				}},
+			}},
+			expected: false,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if actual := tc.source.empty(); actual != tc.expected {
+				t.Errorf("empty() = %v, expected %v", actual, tc.expected)
+			}
+		})
+	}
+}
+
+func TestSkippedSources(t *testing.T) {
+	t.Parallel()
+	sources := []packageSource{
+		{kind: containerfile.StageKindBuilder, alias: "builder", pullspec: "golang:1.24", sources: []string{"/app"}},
+		{kind: containerfile.StageKindBuilder, index: 1, alias: "test", pullspec: "golang:1.24"},
+		{kind: containerfile.StageKindExternal, pullspec: "docker.io/library/alpine:3.20"},
+		{kind: containerfile.StageKindBuilder, index: 2, alias: "base", pullspec: "fedora:41",
+			descendants: []*packageSourceDescendant{{index: 3, alias: "build", sources: []string{"/out"}}}},
+	}
+
+	expected := []SkippedSource{
+		{Alias: "test", Pullspec: "golang:1.24", Note: skippedSourceNote},
+		{Pullspec: "docker.io/library/alpine:3.20", Note: skippedSourceNote},
+	}
+	if diff := cmp.Diff(expected, skippedSources(sources)); diff != "" {
+		t.Errorf("skippedSources() mismatch (-want +got):\n%s", diff)
+	}
+
+	// skipped sources are recorded in the output
+	out, err := json.Marshal(PackageMetadata{Packages: []PackageMetadataItem{}, SkippedSources: expected[:1]})
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	want := `"skipped_sources":[{"alias":"test","pullspec":"golang:1.24","note":"` + skippedSourceNote + `"}]`
+	if !strings.Contains(string(out), want) {
+		t.Errorf("expected %s in the output, got %s", want, out)
+	}
+}
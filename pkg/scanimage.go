@@ -0,0 +1,105 @@
+// Scanning of paths of an image in local storage without a containerfile.
+
+package capo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+	"github.com/konflux-ci/capo/pkg/storageclient"
+)
+
+// ErrNoPaths is returned by ScanImage when no paths to scan are passed.
+var ErrNoPaths = fmt.Errorf("[ERR_NO_PATHS] no paths of the image to scan: %w", ErrParse)
+
+// ScanImage scans the paths of the image in local storage without a
+// containerfile, e.g. for ad-hoc investigations or images built by other
+// tooling than buildah. Paths are absolute and may be directories or contain
+// wildcards, as sources of COPY instructions. The packages are reported as if
+// the paths were copied from the image (COPY --from=pullspec), with the
+// external origin type, in the same PackageMetadata as by Scan.
+func (s *Scanner) ScanImage(pullspec string, paths []string) (_ PackageMetadata, err error) {
+	if len(paths) == 0 {
+		return PackageMetadata{}, ErrNoPaths
+	}
+
+	start := time.Now()
+	var stats *ScanStats
+	s.debug = nil
+	if os.Getenv(debugEnv) != "" {
+		if s.debug, err = newDebugLayout(); err != nil {
+			return PackageMetadata{}, fmt.Errorf("failed to create debug directory: %w: %w", err, ErrIO)
+		}
+	}
+	s.mounts = newMountManager(s.store, s.logger)
+	defer func() {
+		if closeErr := s.mounts.close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		if s.debug != nil {
+			if summaryErr := s.debug.writeSummary(os.Stderr); summaryErr != nil {
+				s.logger.Warn("failed to print debug directories", "error", summaryErr)
+			}
+		}
+		if err == nil {
+			s.emit(Event{Type: EventStats, Stats: stats})
+		}
+	}()
+	s.warnings = nil
+	s.files = nil
+
+	dig, err := s.sclient.ResolveDigest(pullspec)
+	if err != nil {
+		return PackageMetadata{}, fmt.Errorf("failed to resolve pullspec %q: %w: %w", pullspec, err, ErrPullspecResolve)
+	}
+	indexDigests, err := getIndexDigests(s.sclient, map[string]digest.Digest{pullspec: dig})
+	if err != nil {
+		return PackageMetadata{}, err
+	}
+	digestBase, err := attachDigest(storageclient.StripTransport(pullspec), dig)
+	if err != nil {
+		return PackageMetadata{}, err
+	}
+
+	source := packageSource{
+		kind:       containerfile.StageKindExternal,
+		pullspec:   pullspec,
+		digestBase: digestBase,
+		sources:    paths,
+	}
+	s.logger.Debug("scanning image paths", "pullspec", digestBase, "paths", paths)
+	items, err := s.scanPackageSources([]packageSource{source}, func(items []PackageMetadataItem) {
+		setIndexDigests(items, indexDigests)
+	})
+	if err != nil {
+		return PackageMetadata{}, err
+	}
+
+	res := PackageMetadata{
+		Packages:      items,
+		Tools:         Version().Tools(),
+		StorageDriver: s.storageDriver,
+		Warnings:      s.warnings,
+	}
+	if s.fileOwnership {
+		sortFileMetadata(s.files)
+		res.Files = append(make([]FileMetadataItem, 0, len(s.files)), s.files...)
+	}
+
+	if err := s.runPostScanHooks(context.Background(), &res); err != nil {
+		return PackageMetadata{}, err
+	}
+
+	stats = &ScanStats{
+		Packages:        len(res.Packages),
+		Warnings:        len(res.Warnings),
+		PackageSources:  1,
+		DurationSeconds: time.Since(start).Seconds(),
+	}
+	return res, nil
+}
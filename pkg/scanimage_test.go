@@ -0,0 +1,45 @@
+//go:build unit
+
+package capo
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/konflux-ci/capo/internal/testutils"
+	"github.com/konflux-ci/capo/pkg/capotest"
+)
+
+func TestScanImageErrors(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		pullspec    string
+		paths       []string
+		expectedErr error
+	}{
+		"no paths": {
+			pullspec:    "quay.io/org/app:latest",
+			expectedErr: ErrNoPaths,
+		},
+		"image not in storage": {
+			pullspec:    "quay.io/org/missing:latest",
+			paths:       []string{"/usr/bin/"},
+			expectedErr: ErrPullspecResolve,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			s := &Scanner{
+				logger:  slog.Default(),
+				sclient: testutils.NewTStorageClient(nil, nil),
+				store:   capotest.NewStore(),
+			}
+			if _, err := s.ScanImage(tc.pullspec, tc.paths); !errors.Is(err, tc.expectedErr) {
+				t.Errorf("expected error wrapping %v, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
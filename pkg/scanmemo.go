@@ -0,0 +1,128 @@
+// Memoization of syft scans of identical extracted content within a scan,
+// see scanContent.
+
+package capo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/konflux-ci/capo/internal/sbom"
+)
+
+// scanMemo holds the packages syft found in the content trees scanned during
+// a Scan, by the kind of the scan and the digest of the tree (see treeDigest).
+// Safe for concurrent use.
+type scanMemo struct {
+	mu       sync.Mutex
+	packages map[scanMemoKey][]sbom.SyftPackage
+}
+
+// Kinds of scans of content, by the catalogers of their scanner.
+const (
+	scanKindContent   = "content"
+	scanKindPackageDB = "package-db"
+)
+
+type scanMemoKey struct {
+	kind   string
+	digest [sha256.Size]byte
+}
+
+func newScanMemo() *scanMemo {
+	return &scanMemo{packages: make(map[scanMemoKey][]sbom.SyftPackage)}
+}
+
+// scanContent returns the packages the scanner finds in the content at
+// contentPath. Content identical to a tree already scanned with the scanner
+// of the same kind during the Scan isn't scanned again, e.g. the package
+// databases of stages with the same base image or the same directories copied
+// by several instructions. Failed scans aren't memoized.
+func (s *Scanner) scanContent(
+	ctx context.Context, scanner sbom.SyftScanner, kind string, contentPath string,
+) ([]sbom.SyftPackage, error) {
+	if s.scanMemo == nil {
+		return scanner.ScanContext(ctx, contentPath)
+	}
+
+	digest, err := treeDigest(contentPath)
+	if err != nil {
+		return nil, err
+	}
+	key := scanMemoKey{kind: kind, digest: digest}
+	s.scanMemo.mu.Lock()
+	packages, ok := s.scanMemo.packages[key]
+	s.scanMemo.mu.Unlock()
+	if ok {
+		s.logger.Debug("content scanned before, reusing its packages", "kind", kind, "path", contentPath)
+		return slices.Clone(packages), nil
+	}
+
+	packages, err = scanner.ScanContext(ctx, contentPath)
+	if err != nil {
+		return nil, err
+	}
+	s.scanMemo.mu.Lock()
+	s.scanMemo.packages[key] = packages
+	s.scanMemo.mu.Unlock()
+	return slices.Clone(packages), nil
+}
+
+// treeDigest returns the SHA-256 digest of the tree at root: the paths, types
+// and permissions of its entries, the targets of symbolic links and the
+// content of regular files. Modification times are left out, extraction
+// doesn't preserve them. A cryptographic hash is used as trees with the same
+// digest share their packages, so a collision would attribute packages to the
+// wrong content.
+func treeDigest(root string) ([sha256.Size]byte, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		// paths can't contain NUL and other fields have a fixed or written
+		// length, so the encoding is unambiguous
+		_, _ = io.WriteString(h, rel+"\x00")
+		_, _ = h.Write(binary.LittleEndian.AppendUint32(nil, uint32(info.Mode())))
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			_, _ = io.WriteString(h, link+"\x00")
+		case info.Mode().IsRegular():
+			_, _ = h.Write(binary.LittleEndian.AppendUint64(nil, uint64(info.Size())))
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(h, f)
+			_ = f.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return [sha256.Size]byte{}, fmt.Errorf("failed to hash content %q: %w: %w", root, err, ErrIO)
+	}
+	return [sha256.Size]byte(h.Sum(nil)), nil
+}
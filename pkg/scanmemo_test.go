@@ -0,0 +1,81 @@
+//go:build unit
+
+package capo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTreeDigest(t *testing.T) {
+	t.Parallel()
+	// writeTree writes the files of the tree to a new directory, with a link
+	// to /etc
+	writeTree := func(t *testing.T, files map[string]string) string {
+		t.Helper()
+		root := t.TempDir()
+		for name, content := range files {
+			p := filepath.Join(root, name)
+			if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.Symlink("/etc", filepath.Join(root, "etc")); err != nil {
+			t.Fatal(err)
+		}
+		return root
+	}
+	files := map[string]string{
+		"app/bin/tool":       "tool",
+		"usr/lib/os-release": "ID=fedora",
+	}
+
+	tests := map[string]struct {
+		files    map[string]string
+		expected bool
+	}{
+		"identical": {
+			files:    files,
+			expected: true,
+		},
+		"other content of the same size": {
+			files: map[string]string{
+				"app/bin/tool":       "tule",
+				"usr/lib/os-release": "ID=fedora",
+			},
+			expected: false,
+		},
+		"other path": {
+			files: map[string]string{
+				"app/bin/tool2":      "tool",
+				"usr/lib/os-release": "ID=fedora",
+			},
+			expected: false,
+		},
+		"fewer files": {
+			files:    map[string]string{"app/bin/tool": "tool"},
+			expected: false,
+		},
+	}
+
+	want, err := treeDigest(writeTree(t, files))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := treeDigest(writeTree(t, tc.files))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if (got == want) != tc.expected {
+				t.Errorf("treeDigest() equal = %v, expected %v", got == want, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+// Attribution of content copied from the build context to packages of an SBOM
+// of the build context (see WithSourceSBOM).
+
+package capo
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/konflux-ci/capo/internal/sbom"
+	"github.com/konflux-ci/capo/pkg/containerfile"
+)
+
+var ErrSourceSBOM = fmt.Errorf("[ERR_SOURCE_SBOM] failed to read source SBOM: %w", ErrParse)
+
+// foundBySourceSBOM marks packages of the SBOM of the build context, see
+// contextPackages.
+const foundBySourceSBOM = "source-sbom"
+
+// contextPackages returns the packages of the SBOM of the build context which
+// are copied by the passed copies from the build context, with the "context"
+// origin type, once per PURL. A package is copied if one of its locations,
+// relative to the root of the build context, is under a source of a copy.
+// Packages without locations (e.g. from cachi2 SBOMs, which don't record
+// them) are only copied by copies of the whole build context.
+func contextPackages(packages []sbom.SyftPackage, copies []containerfile.Copy) []PackageMetadataItem {
+	sources := make([]string, 0)
+	wholeContext := false
+	for _, cp := range copies {
+		for _, src := range cp.Sources {
+			src = path.Join("/", src)
+			sources = append(sources, src)
+			if src == "/" {
+				wholeContext = true
+			}
+		}
+	}
+
+	res := make([]PackageMetadataItem, 0)
+	seen := make(map[string]bool)
+	for _, pkg := range packages {
+		if seen[pkg.PURL] || !copiedFromContext(pkg, sources, wholeContext) {
+			continue
+		}
+		seen[pkg.PURL] = true
+		res = append(res, withID(PackageMetadataItem{
+			PackageURL: pkg.PURL,
+			Checksums:  pkg.Checksums,
+			Licenses:   pkg.Licenses,
+			OriginType: originTypeContext,
+			FoundBy:    foundBySourceSBOM,
+		}, pkg.Locations))
+	}
+	return res
+}
+
+func copiedFromContext(pkg sbom.SyftPackage, sources []string, wholeContext bool) bool {
+	if wholeContext || len(pkg.Locations) == 0 {
+		return wholeContext
+	}
+	for _, loc := range pkg.Locations {
+		if Includes(sources, loc) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+//go:build unit
+
+package capo
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/konflux-ci/capo/internal/sbom"
+	"github.com/konflux-ci/capo/pkg/containerfile"
+)
+
+func TestContextPackages(t *testing.T) {
+	t.Parallel()
+	packages := []sbom.SyftPackage{
+		{PURL: "pkg:golang/github.com/example/app@v1.0.0", Locations: []string{"/go.mod"}},
+		{PURL: "pkg:golang/golang.org/x/sys@v0.30.0", Locations: []string{"/go.mod"}},
+		{PURL: "pkg:npm/left-pad@1.3.0", Locations: []string{"/web/package-lock.json"}},
+		{PURL: "pkg:npm/left-pad@1.3.0", Locations: []string{"/docs/package-lock.json"}},
+		{PURL: "pkg:pypi/requests@2.32.0", Locations: []string{"/tools/requirements.txt"}},
+		{PURL: "pkg:generic/cachi2-artifact@1.0", Locations: []string{}},
+	}
+	item := func(purl string) PackageMetadataItem {
+		return PackageMetadataItem{PackageURL: purl, OriginType: originTypeContext, FoundBy: foundBySourceSBOM}
+	}
+
+	tests := map[string]struct {
+		copies   []containerfile.Copy
+		expected []PackageMetadataItem
+	}{
+		"no copies": {
+			copies:   nil,
+			expected: []PackageMetadataItem{},
+		},
+		"copies of files and directories": {
+			copies: []containerfile.Copy{
+				{Sources: []string{"go.mod", "go.sum"}, Destination: "./"},
+				{Sources: []string{"./web/"}, Destination: "/srv/web/"},
+			},
+			expected: []PackageMetadataItem{
+				item("pkg:golang/github.com/example/app@v1.0.0"),
+				item("pkg:golang/golang.org/x/sys@v0.30.0"),
+				item("pkg:npm/left-pad@1.3.0"),
+			},
+		},
+		"wildcard source": {
+			copies: []containerfile.Copy{
+				{Sources: []string{"tools/*.txt"}, Destination: "/opt/"},
+			},
+			expected: []PackageMetadataItem{item("pkg:pypi/requests@2.32.0")},
+		},
+		"copy of the whole context includes packages without locations": {
+			copies: []containerfile.Copy{
+				{Sources: []string{"."}, Destination: "."},
+			},
+			expected: []PackageMetadataItem{
+				item("pkg:golang/github.com/example/app@v1.0.0"),
+				item("pkg:golang/golang.org/x/sys@v0.30.0"),
+				item("pkg:npm/left-pad@1.3.0"),
+				item("pkg:pypi/requests@2.32.0"),
+				item("pkg:generic/cachi2-artifact@1.0"),
+			},
+		},
+		"prefix of a directory name": {
+			copies: []containerfile.Copy{
+				{Sources: []string{"go"}, Destination: "/go"},
+			},
+			expected: []PackageMetadataItem{},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			actual := contextPackages(packages, test.copies)
+			// IDs are checked by TestWithID
+			if diff := cmp.Diff(test.expected, actual, cmpopts.IgnoreFields(PackageMetadataItem{}, "ID")); diff != "" {
+				t.Errorf("contextPackages() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
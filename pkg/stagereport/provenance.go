@@ -0,0 +1,166 @@
+package stagereport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FROM instructions in the Dockerfile of a BuildKit build, with the flags
+// (e.g. --platform) skipped.
+var dockerfileFromLine = regexp.MustCompile(`^(?i:FROM)\s+(?:--\S+\s+)*(\S+)(?:\s+(?i:AS)\s+(\S+))?`)
+
+// provenance is the SLSA provenance BuildKit attaches to images
+// (--provenance=mode=max), as an in-toto statement or its predicate alone,
+// in the v0.2 or v1 format of SLSA. Only the fields locating the steps of
+// the build in the Dockerfile and the layers of their results are read.
+type provenance struct {
+	Predicate *provenance `json:"predicate"`
+
+	// SLSA v0.2
+	Metadata struct {
+		BuildKit *buildKitMetadata `json:"https://mobyproject.org/buildkit@v1#metadata"`
+	} `json:"metadata"`
+
+	// SLSA v1
+	RunDetails struct {
+		Metadata struct {
+			BuildKit *buildKitMetadata `json:"buildkit_metadata"`
+		} `json:"metadata"`
+	} `json:"runDetails"`
+}
+
+type buildKitMetadata struct {
+	Source struct {
+		// Locations of steps ("step3") in the sources.
+		Locations map[string]struct {
+			Locations []struct {
+				SourceIndex int `json:"sourceIndex"`
+				Ranges      []struct {
+					Start struct {
+						Line int `json:"line"`
+					} `json:"start"`
+				} `json:"ranges"`
+			} `json:"locations"`
+		} `json:"locations"`
+		Infos []struct {
+			Filename string `json:"filename"`
+			Language string `json:"language"`
+			Data     []byte `json:"data"`
+		} `json:"infos"`
+	} `json:"source"`
+	// Layers of the results of steps ("step3:0"), the chains of their
+	// snapshots from the bottom layer.
+	Layers map[string][][]struct {
+		Digest string `json:"digest"`
+	} `json:"layers"`
+}
+
+// ReadProvenanceFile reads the stage report from the BuildKit provenance in
+// the file at path. See FromProvenance.
+func ReadProvenanceFile(path string) (Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Report{}, fmt.Errorf("opening provenance: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	report, err := FromProvenance(f)
+	if err != nil {
+		return Report{}, fmt.Errorf("in %s: %w", path, err)
+	}
+	return report, nil
+}
+
+// FromProvenance reads the stage report from the SLSA provenance of a
+// BuildKit build (docker buildx build --provenance=mode=max). BuildKit
+// commits no images for stages, so the stages have the digest of the top
+// layer of their snapshot (Stage.TopLayer) instead of an image ID: the
+// layer of the last step located in the lines of the stage in the
+// Dockerfile, which has the longest chain of layers among the steps of the
+// stage. Stages are read from the FROM instructions of the Dockerfile
+// embedded in the provenance. Fails with ErrInvalidReport if the provenance
+// has no Dockerfile, e.g. if it isn't of mode=max.
+func FromProvenance(r io.Reader) (Report, error) {
+	var p provenance
+	if err := json.NewDecoder(r).Decode(&p); err != nil {
+		return Report{}, fmt.Errorf("%w: %w", ErrInvalidReport, err)
+	}
+	if p.Predicate != nil {
+		p = *p.Predicate
+	}
+	metadata := p.Metadata.BuildKit
+	if metadata == nil {
+		metadata = p.RunDetails.Metadata.BuildKit
+	}
+	if metadata == nil {
+		return Report{}, fmt.Errorf("%w: no BuildKit metadata in the provenance", ErrInvalidReport)
+	}
+
+	sourceIndex := -1
+	for i, info := range metadata.Source.Infos {
+		if strings.EqualFold(info.Language, "Dockerfile") {
+			sourceIndex = i
+			break
+		}
+	}
+	if sourceIndex < 0 {
+		return Report{}, fmt.Errorf(
+			"%w: no Dockerfile in the provenance, build with --provenance=mode=max", ErrInvalidReport,
+		)
+	}
+
+	report := Report{Version: Version, Stages: make([]Stage, 0)}
+	// line of the FROM instruction of every stage
+	var fromLines []int
+	scanner := bufio.NewScanner(bytes.NewReader(metadata.Source.Infos[sourceIndex].Data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		m := dockerfileFromLine.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if m == nil {
+			continue
+		}
+		index := len(report.Stages)
+		name := m[2]
+		if name == "" {
+			name = strconv.Itoa(index)
+		}
+		report.Stages = append(report.Stages, Stage{Index: index, Name: name, Base: m[1]})
+		fromLines = append(fromLines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return Report{}, fmt.Errorf("reading Dockerfile of the provenance: %w", err)
+	}
+	if len(report.Stages) == 0 {
+		return Report{}, fmt.Errorf("%w: no stages found in the Dockerfile of the provenance", ErrInvalidReport)
+	}
+
+	// longest chain of layers among the steps of every stage
+	chains := make([]int, len(report.Stages))
+	for step, layers := range metadata.Layers {
+		if len(layers) == 0 || len(layers[0]) == 0 {
+			continue
+		}
+		stepID, _, _ := strings.Cut(step, ":")
+		for _, location := range metadata.Source.Locations[stepID].Locations {
+			if location.SourceIndex != sourceIndex || len(location.Ranges) == 0 {
+				continue
+			}
+			// stage of the line, the last one with its FROM above the line
+			index := sort.SearchInts(fromLines, location.Ranges[0].Start.Line+1) - 1
+			if index < 0 || len(layers[0]) <= chains[index] {
+				continue
+			}
+			chains[index] = len(layers[0])
+			report.Stages[index].TopLayer = layers[0][len(layers[0])-1].Digest
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,84 @@
+//go:build unit
+
+package stagereport
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFromProvenance(t *testing.T) {
+	t.Parallel()
+	dockerfile := base64.StdEncoding.EncodeToString([]byte(
+		"FROM --platform=$BUILDPLATFORM quay.io/org/go:1 AS builder\n" +
+			"RUN make\n" +
+			"\n" +
+			"FROM quay.io/org/base:1\n" +
+			"COPY --from=builder /app /app\n",
+	))
+	metadata := `{
+		"source": {
+			"locations": {
+				"step0": {"locations": [{"sourceIndex": 0, "ranges": [{"start": {"line": 1}}]}]},
+				"step1": {"locations": [{"sourceIndex": 0, "ranges": [{"start": {"line": 2}}]}]},
+				"step2": {"locations": [{"sourceIndex": 0, "ranges": [{"start": {"line": 4}}]}]},
+				"step3": {"locations": [{"sourceIndex": 0, "ranges": [{"start": {"line": 5}}]}]}
+			},
+			"infos": [{"filename": "Dockerfile", "language": "Dockerfile", "data": "` + dockerfile + `"}]
+		},
+		"layers": {
+			"step0:0": [[{"digest": "sha256:go"}]],
+			"step1:0": [[{"digest": "sha256:go"}, {"digest": "sha256:make"}]],
+			"step2:0": [[{"digest": "sha256:base"}]],
+			"step3:0": [[{"digest": "sha256:base"}, {"digest": "sha256:app"}]]
+		}
+	}`
+	expected := Report{
+		Version: Version,
+		Stages: []Stage{
+			{Index: 0, Name: "builder", Base: "quay.io/org/go:1", TopLayer: "sha256:make"},
+			{Index: 1, Name: "1", Base: "quay.io/org/base:1", TopLayer: "sha256:app"},
+		},
+	}
+
+	tests := map[string]struct {
+		content  string
+		expected Report
+		wantErr  error
+	}{
+		"SLSA v0.2 statement": {
+			content: `{"predicateType": "https://slsa.dev/provenance/v0.2", "predicate": ` +
+				`{"metadata": {"https://mobyproject.org/buildkit@v1#metadata": ` + metadata + `}}}`,
+			expected: expected,
+		},
+		"SLSA v1 predicate": {
+			content:  `{"runDetails": {"metadata": {"buildkit_metadata": ` + metadata + `}}}`,
+			expected: expected,
+		},
+		"no BuildKit metadata": {
+			content: `{"predicate": {"metadata": {}}}`,
+			wantErr: ErrInvalidReport,
+		},
+		"no Dockerfile": {
+			content: `{"runDetails": {"metadata": {"buildkit_metadata": {"source": {"infos": []}}}}}`,
+			wantErr: ErrInvalidReport,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			actual, err := FromProvenance(strings.NewReader(tc.content))
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if diff := cmp.Diff(tc.expected, actual); diff != "" {
+				t.Errorf("FromProvenance() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
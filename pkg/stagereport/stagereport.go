@@ -0,0 +1,183 @@
+// Package stagereport defines the stage report, a file listing the image
+// buildah committed for every stage of a build, and reads it from the build
+// log (buildah build --logfile) until buildah writes it itself, or from the
+// provenance of a BuildKit build. Capo prefers the images of a stage report
+// over finding intermediate images by their io.buildah.stage.name label. See
+// docs/stage-report.md for the format.
+package stagereport
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Version is the version of the stage report format read by capo.
+const Version = 1
+
+// ErrInvalidReport is returned for a stage report which can't be read, e.g.
+// of an unknown version.
+var ErrInvalidReport = errors.New("[ERR_INVALID_STAGE_REPORT] invalid stage report")
+
+// ErrImageMismatch is returned when the image of the last stage of a report
+// isn't the built image, e.g. because the build log is of another build.
+var ErrImageMismatch = errors.New("[ERR_STAGE_REPORT_MISMATCH] stage report doesn't match the built image")
+
+// Report lists the images buildah committed for the stages of a build.
+type Report struct {
+	// Version of the format, see Version.
+	Version int `json:"version"`
+	// ID of the built image, as written by buildah to --iidfile. Omitted if
+	// unknown.
+	ImageID string `json:"image_id,omitempty"`
+	// Stages in the order of the containerfile.
+	Stages []Stage `json:"stages"`
+}
+
+// Stage is a stage of a build and the image committed for it.
+type Stage struct {
+	// Zero-based index of the stage in the containerfile.
+	Index int `json:"index"`
+	// Name of the stage as in the io.buildah.stage.name label: its alias, or
+	// its index if it has none.
+	Name string `json:"name"`
+	// Base image (FROM) of the stage after expansion of build args. Omitted
+	// if unknown.
+	Base string `json:"base,omitempty"`
+	// ID of the image committed for the stage, or a unique prefix of it.
+	// Omitted if no image was committed.
+	ImageID string `json:"image_id,omitempty"`
+	// Digest of the top layer of the snapshot of the stage, for builds
+	// committing no images for stages (BuildKit, see FromProvenance). Capo
+	// takes the image with the layer on top from the storage. Omitted if
+	// ImageID is set or unknown.
+	TopLayer string `json:"top_layer,omitempty"`
+}
+
+// ReadFile reads the stage report from the file at path. See Parse.
+func ReadFile(path string) (Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Report{}, fmt.Errorf("opening stage report: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	report, err := Parse(f)
+	if err != nil {
+		return Report{}, fmt.Errorf("in %s: %w", path, err)
+	}
+	return report, nil
+}
+
+// Parse reads a stage report as JSON. Fails with ErrInvalidReport for other
+// versions than Version.
+func Parse(r io.Reader) (Report, error) {
+	var report Report
+	if err := json.NewDecoder(r).Decode(&report); err != nil {
+		return Report{}, fmt.Errorf("%w: %w", ErrInvalidReport, err)
+	}
+	if report.Version != Version {
+		return Report{}, fmt.Errorf("%w: unsupported version %d, expected %d", ErrInvalidReport, report.Version, Version)
+	}
+	return report, nil
+}
+
+// Lines of the build log starting a stage ("[1/2] STEP 1/3: FROM base AS
+// builder", without the stage counter for single-stage builds) and reporting
+// a committed image ("--> 3c1d5e8a9f02", "--> Using cache <id>").
+var (
+	fromLine   = regexp.MustCompile(`^(?:\[(\d+)/\d+\] )?STEP 1/\d+: FROM (\S+)(?:\s+(?i:AS)\s+(\S+))?`)
+	commitLine = regexp.MustCompile(`^--> (?:Using cache )?([0-9a-f]{6,64})\s*$`)
+)
+
+// FromLog reads the stage report from the output of buildah build (e.g.
+// written with --logfile). The image of a stage is the last image reported
+// as committed before the next stage starts.
+func FromLog(r io.Reader) (Report, error) {
+	report := Report{Version: Version, Stages: make([]Stage, 0)}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if m := fromLine.FindStringSubmatch(line); m != nil {
+			index := len(report.Stages)
+			if m[1] != "" {
+				n, err := strconv.Atoi(m[1])
+				if err != nil || n < 1 {
+					return Report{}, fmt.Errorf("%w: invalid stage number in %q", ErrInvalidReport, line)
+				}
+				index = n - 1
+			}
+			name := m[3]
+			if name == "" {
+				name = strconv.Itoa(index)
+			}
+			report.Stages = append(report.Stages, Stage{Index: index, Name: name, Base: m[2]})
+			continue
+		}
+		if m := commitLine.FindStringSubmatch(line); m != nil && len(report.Stages) > 0 {
+			report.Stages[len(report.Stages)-1].ImageID = m[1]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Report{}, fmt.Errorf("reading build log: %w", err)
+	}
+	if len(report.Stages) == 0 {
+		return Report{}, fmt.Errorf("%w: no stages found in the build log", ErrInvalidReport)
+	}
+	return report, nil
+}
+
+// ReadIIDFile reads the ID of the built image from the file written by
+// buildah build --iidfile, without the "sha256:" prefix.
+func ReadIIDFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading iidfile: %w", err)
+	}
+	return strings.TrimPrefix(strings.TrimSpace(string(content)), "sha256:"), nil
+}
+
+// WithImageID returns the report with the ID of the built image, checking
+// that it is the image of the last stage, if known, so a report (or a build
+// log) of another build isn't used. Fails with ErrImageMismatch otherwise.
+func (r Report) WithImageID(id string) (Report, error) {
+	if len(r.Stages) > 0 {
+		last := r.Stages[len(r.Stages)-1].ImageID
+		if last != "" && !strings.HasPrefix(id, last) && !strings.HasPrefix(last, id) {
+			return Report{}, fmt.Errorf("%w: last stage committed %s, built image is %s", ErrImageMismatch, last, id)
+		}
+	}
+	r.ImageID = id
+	return r, nil
+}
+
+// StageImage returns the ID of the image committed for the stage with the
+// name, the last one for names of more stages. Returns false if the report
+// has no image for the name.
+func (r Report) StageImage(name string) (string, bool) {
+	for i := len(r.Stages) - 1; i >= 0; i-- {
+		if r.Stages[i].Name == name {
+			return r.Stages[i].ImageID, r.Stages[i].ImageID != ""
+		}
+	}
+	return "", false
+}
+
+// StageTopLayer returns the digest of the top layer of the snapshot of the
+// stage with the name, the last one for names of more stages. Returns false
+// if the report has no top layer for the name.
+func (r Report) StageTopLayer(name string) (string, bool) {
+	for i := len(r.Stages) - 1; i >= 0; i-- {
+		if r.Stages[i].Name == name {
+			return r.Stages[i].TopLayer, r.Stages[i].TopLayer != ""
+		}
+	}
+	return "", false
+}
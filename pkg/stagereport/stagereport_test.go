@@ -0,0 +1,168 @@
+//go:build unit
+
+package stagereport
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		content  string
+		expected Report
+		wantErr  error
+	}{
+		"report": {
+			content: `{"version": 1, "image_id": "7e6d", "stages": [` +
+				`{"index": 0, "name": "builder", "base": "quay.io/org/go:1", "image_id": "9f2c"},` +
+				`{"index": 1, "name": "1", "base": "scratch", "image_id": "7e6d"}]}`,
+			expected: Report{
+				Version: 1,
+				ImageID: "7e6d",
+				Stages: []Stage{
+					{Index: 0, Name: "builder", Base: "quay.io/org/go:1", ImageID: "9f2c"},
+					{Index: 1, Name: "1", Base: "scratch", ImageID: "7e6d"},
+				},
+			},
+		},
+		"unknown version": {
+			content: `{"version": 2, "stages": []}`,
+			wantErr: ErrInvalidReport,
+		},
+		"invalid JSON": {
+			content: `{"version": 1, "stages": [`,
+			wantErr: ErrInvalidReport,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			actual, err := Parse(strings.NewReader(tc.content))
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if diff := cmp.Diff(tc.expected, actual); diff != "" {
+				t.Errorf("Parse() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFromLog(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		log      string
+		expected Report
+		wantErr  error
+	}{
+		"multi-stage build": {
+			log: "[1/3] STEP 1/2: FROM quay.io/org/go:1 AS builder\n" +
+				"[1/3] STEP 2/2: RUN make\n" +
+				"--> 9f2c1c5a8b1\n" +
+				"[2/3] STEP 1/2: FROM quay.io/org/base:1\n" +
+				"[2/3] STEP 2/2: COPY --from=builder /app /app\n" +
+				"--> Using cache 3c1d5e8a9f02aa\n" +
+				"--> 3c1d5e8a9f0\n" +
+				"[3/3] STEP 1/2: FROM scratch\n" +
+				"[3/3] STEP 2/2: COPY --from=1 /app /app\n" +
+				"[3/3] COMMIT localhost/app:latest\n" +
+				"--> 7e6d0a1b2c3\n" +
+				"Successfully tagged localhost/app:latest\n" +
+				"7e6d0a1b2c3d4e5f\n",
+			expected: Report{
+				Version: Version,
+				Stages: []Stage{
+					{Index: 0, Name: "builder", Base: "quay.io/org/go:1", ImageID: "9f2c1c5a8b1"},
+					{Index: 1, Name: "1", Base: "quay.io/org/base:1", ImageID: "3c1d5e8a9f0"},
+					{Index: 2, Name: "2", Base: "scratch", ImageID: "7e6d0a1b2c3"},
+				},
+			},
+		},
+		"single-stage build": {
+			log: "STEP 1/2: FROM scratch\nSTEP 2/2: COPY app /app\nCOMMIT app\n--> 7e6d0a1b2c3\n",
+			expected: Report{
+				Version: Version,
+				Stages:  []Stage{{Index: 0, Name: "0", Base: "scratch", ImageID: "7e6d0a1b2c3"}},
+			},
+		},
+		"lowercase as": {
+			log: "[1/2] STEP 1/1: FROM quay.io/org/go:1 as builder\n--> 9f2c1c5a8b1\n",
+			expected: Report{
+				Version: Version,
+				Stages:  []Stage{{Index: 0, Name: "builder", Base: "quay.io/org/go:1", ImageID: "9f2c1c5a8b1"}},
+			},
+		},
+		"no stages": {
+			log:     "Error: no such file\n",
+			wantErr: ErrInvalidReport,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			actual, err := FromLog(strings.NewReader(tc.log))
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if diff := cmp.Diff(tc.expected, actual); diff != "" {
+				t.Errorf("FromLog() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithImageID(t *testing.T) {
+	t.Parallel()
+	report := Report{Version: Version, Stages: []Stage{
+		{Index: 0, Name: "builder", ImageID: "9f2c1c5a8b1"},
+		{Index: 1, Name: "1", ImageID: "7e6d0a1b2c3"},
+	}}
+
+	actual, err := report.WithImageID("7e6d0a1b2c3d4e5f")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if actual.ImageID != "7e6d0a1b2c3d4e5f" {
+		t.Errorf("expected image ID to be set, got %q", actual.ImageID)
+	}
+
+	if _, err := report.WithImageID("0123456789ab"); !errors.Is(err, ErrImageMismatch) {
+		t.Errorf("expected %v, got %v", ErrImageMismatch, err)
+	}
+}
+
+func TestStageImage(t *testing.T) {
+	t.Parallel()
+	report := Report{Version: Version, Stages: []Stage{
+		{Index: 0, Name: "builder", ImageID: "old"},
+		{Index: 1, Name: "empty"},
+		{Index: 2, Name: "builder", ImageID: "new"},
+	}}
+
+	tests := map[string]struct {
+		name       string
+		expectedID string
+		expectedOK bool
+	}{
+		"redefined alias uses the last definition": {name: "builder", expectedID: "new", expectedOK: true},
+		"stage without image":                      {name: "empty"},
+		"unknown stage":                            {name: "other"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			id, ok := report.StageImage(tc.name)
+			if id != tc.expectedID || ok != tc.expectedOK {
+				t.Errorf("StageImage(%q) = %q, %v, expected %q, %v", tc.name, id, ok, tc.expectedID, tc.expectedOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+// Recording of the effective build environment of stages in the scan output.
+
+package capo
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+	"github.com/konflux-ci/capo/pkg/redact"
+)
+
+// StageMetadata is the effective build environment of a stage, so the values
+// instructions (e.g. FROM ${BASE_IMAGE}) were expanded with can be checked in
+// the output.
+type StageMetadata struct {
+	// Alias of the stage.
+	Alias string `json:"alias"`
+	// Zero-based index of the stage.
+	Index int `json:"index"`
+	// Base image pullspec of the stage, resolved through chained stages.
+	Base string `json:"base"`
+	// Effective ARG and ENV values at the end of the stage, redacted (see
+	// WithRedactor). Omitted if empty.
+	Env map[string]string `json:"env,omitempty"`
+	// Build args the base image pullspec depends on. Omitted if none.
+	PullspecArgs []string `json:"pullspec_args,omitempty"`
+	// Build args the COPY, ADD and RUN --mount instructions of the stage
+	// depend on. Omitted if none.
+	CopyArgs []string `json:"copy_args,omitempty"`
+}
+
+// getStageMetadata returns the build environment of all stages, in order.
+func getStageMetadata(cf containerfile.Containerfile, redactor *redact.Redactor) []StageMetadata {
+	res := make([]StageMetadata, 0, len(cf.Stages))
+	for _, stage := range cf.Stages {
+		res = append(res, StageMetadata{
+			Alias: stage.Alias,
+			Index: stage.Index,
+			Base:  stage.Base,
+			Env:   redactor.Env(stage.Env),
+
+			PullspecArgs: stage.PullspecArgs,
+			CopyArgs:     stage.CopyArgs,
+		})
+	}
+	return res
+}
+
+// RedactStages returns a copy of stages with their Env redacted, safe for
+// logging.
+func RedactStages(stages []containerfile.Stage, redactor *redact.Redactor) []containerfile.Stage {
+	res := slices.Clone(stages)
+	for i := range res {
+		res[i].Env = redactor.Env(res[i].Env)
+	}
+	return res
+}
+
+// buildArgWarnings returns a warning for every passed build arg no stage
+// uses and for every stage whose base image pullspec depends on build args
+// which weren't passed.
+func buildArgWarnings(cf containerfile.Containerfile) []Warning {
+	res := make([]Warning, 0)
+	for _, name := range cf.UnusedArgs {
+		res = append(res, Warning{
+			Code:    WarnUnusedBuildArg,
+			Message: fmt.Sprintf("build arg %q is passed to the build, but not used by any stage", name),
+		})
+	}
+	for _, stage := range cf.Stages {
+		if len(stage.UnpinnedArgs) == 0 {
+			continue
+		}
+		res = append(res, Warning{
+			Code: WarnUnpinnedBuildArg,
+			Message: fmt.Sprintf(
+				"base image %q of stage %d depends on build args %v, which are not passed to the build",
+				stage.Base, stage.Index, stage.UnpinnedArgs,
+			),
+		})
+	}
+	return res
+}
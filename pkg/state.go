@@ -0,0 +1,407 @@
+// Incremental scans, which reuse the packages of package sources unchanged
+// since a previous scan, see WithStateFile.
+
+package capo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+	"github.com/konflux-ci/capo/pkg/storageclient"
+
+	"go.podman.io/storage"
+)
+
+// stateVersion is the version of the state file format and of the keys of
+// package sources. State files of other versions are ignored.
+const stateVersion = 1
+
+// Configure the scanner to persist the packages of every package source to
+// the state file at path and, on subsequent scans, reuse them for sources
+// whose base and intermediate images have the same layers, so only the
+// sources of changed stages are extracted and scanned again. A missing or
+// unreadable state file is replaced. Disabled if empty.
+func WithStateFile(path string) Option {
+	return func(s *Scanner) {
+		s.stateFile = path
+	}
+}
+
+// scanState holds the packages of package sources by their key (see
+// sourceKey). Safe for concurrent use by scans of package sources.
+type scanState struct {
+	Version int                   `json:"version"`
+	Sources map[string]stateEntry `json:"sources"`
+
+	// digest of the scanner configuration, part of every key
+	config string
+	// keys of the entries of the current scan, others are pruned on write
+	used   map[string]bool
+	reused int
+	mu     sync.Mutex
+}
+
+// stateEntry holds the packages of a package source before they're finished
+// for the output of a scan (see scanPackageSources), and the owners of its
+// files.
+type stateEntry struct {
+	Packages []PackageMetadataItem `json:"packages"`
+	Files    []FileMetadataItem    `json:"files,omitempty"`
+}
+
+// loadState reads the state file of the scanner. A missing file, or one that
+// can't be read or is of another version, gives an empty state.
+func (s *Scanner) loadState() (*scanState, error) {
+	config, err := s.configKey()
+	if err != nil {
+		return nil, err
+	}
+	st := &scanState{
+		Version: stateVersion,
+		Sources: make(map[string]stateEntry),
+		config:  config,
+		used:    make(map[string]bool),
+	}
+
+	data, err := os.ReadFile(s.stateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		s.logger.Warn("failed to read state file, scanning all package sources", "path", s.stateFile, "error", err)
+		return st, nil
+	}
+	var read scanState
+	if err := json.Unmarshal(data, &read); err != nil {
+		s.logger.Warn("invalid state file, scanning all package sources", "path", s.stateFile, "error", err)
+		return st, nil
+	}
+	if read.Version != stateVersion {
+		s.logger.Info("state file of another version, scanning all package sources",
+			"path", s.stateFile, "version", read.Version)
+		return st, nil
+	}
+	if read.Sources != nil {
+		st.Sources = read.Sources
+	}
+	return st, nil
+}
+
+// lookup returns the entry of the key and marks it as used.
+func (st *scanState) lookup(key string) (stateEntry, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	entry, ok := st.Sources[key]
+	if ok {
+		st.used[key] = true
+		st.reused++
+	}
+	return entry, ok
+}
+
+// store sets the entry of the key.
+func (st *scanState) store(key string, entry stateEntry) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.Sources[key] = entry
+	st.used[key] = true
+}
+
+// write replaces the file at path with the entries of the current scan.
+func (st *scanState) write(path string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for key := range st.Sources {
+		if !st.used[key] {
+			delete(st.Sources, key)
+		}
+	}
+
+	data, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w: %w", err, ErrEncode)
+	}
+	// written next to the file and renamed, so an interrupted scan doesn't
+	// leave a partial state
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w: %w", err, ErrIO)
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write state file: %w: %w", err, ErrIO)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %w: %w", err, ErrIO)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to write state file: %w: %w", err, ErrIO)
+	}
+	return nil
+}
+
+// configKey returns the digest of the configuration of the scanner which
+// affects the packages found in the content of package sources, including
+// the build of capo (see Version).
+func (s *Scanner) configKey() (string, error) {
+	var syftConfig string
+	if s.syftConfig != "" {
+		dig, err := fileDigest(s.syftConfig)
+		if err != nil {
+			return "", fmt.Errorf("failed to read syft config %q: %w: %w", s.syftConfig, err, ErrIO)
+		}
+		syftConfig = dig.String()
+	}
+	return hashKey(struct {
+		Build                VersionInfo `json:"build"`
+		SelectCatalogers     []string    `json:"select_catalogers"`
+		DefaultCatalogersTag string      `json:"default_catalogers_tag"`
+		SyftConfig           string      `json:"syft_config"`
+		Offline              bool        `json:"offline"`
+		FileOwnership        bool        `json:"file_ownership"`
+		PermMask             fs.FileMode `json:"perm_mask"`
+		MaxFileBytes         int64       `json:"max_file_bytes"`
+		MaxExtractBytes      int64       `json:"max_extract_bytes"`
+	}{
+		Build:                Version(),
+		SelectCatalogers:     s.selectCatalogers,
+		DefaultCatalogersTag: s.defaultCatalogersTag,
+		SyftConfig:           syftConfig,
+		Offline:              s.offline,
+		FileOwnership:        s.fileOwnership,
+		PermMask:             s.permMask,
+		MaxFileBytes:         s.maxFileBytes,
+		MaxExtractBytes:      s.maxExtractBytes,
+	})
+}
+
+// stageImage is the intermediate image of a stage of a package source and the
+// image its content is diffed against, nil for the root stage of a special
+// base.
+type stageImage struct {
+	alias        string
+	intermediate *storage.Image
+	diffBase     *storage.Image
+}
+
+// sourceImages returns the base image of the package source (nil for special
+// bases) and the intermediate images of its stages, found the same way as by
+// scanBuilderStageTree.
+func (s *Scanner) sourceImages(root packageSource) (*storage.Image, []stageImage, error) {
+	var base *storage.Image
+	if !storageclient.IsSpecialBase(root.pullspec) {
+		var err error
+		base, err = s.lookupBaseImage(root.pullspec, root.digestBase)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if root.kind == containerfile.StageKindExternal {
+		return base, nil, nil
+	}
+
+	var stages []stageImage
+	diffBase := base
+	intermediate, found, err := s.findIntermediateImage(root.alias)
+	if err != nil {
+		return nil, nil, err
+	}
+	if found {
+		stages = append(stages, stageImage{alias: root.alias, intermediate: intermediate, diffBase: base})
+		diffBase = intermediate
+	}
+
+	var walk func(nodes []*packageSourceDescendant, diffBase *storage.Image) error
+	walk = func(nodes []*packageSourceDescendant, diffBase *storage.Image) error {
+		for _, node := range nodes {
+			intermediate, found, err := s.findIntermediateImage(node.alias)
+			if err != nil {
+				return err
+			}
+			next := diffBase
+			if found {
+				stages = append(stages, stageImage{alias: node.alias, intermediate: intermediate, diffBase: diffBase})
+				next = intermediate
+			}
+			if err := walk(node.descendants, next); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(root.descendants, diffBase); err != nil {
+		return nil, nil, err
+	}
+	return base, stages, nil
+}
+
+// imageKey identifies the content of an image by its top layer. The ID is
+// part of it, as it's reported by squashed image warnings.
+type imageKey struct {
+	ID       string `json:"id"`
+	TopLayer string `json:"top_layer"`
+}
+
+func newImageKey(image *storage.Image) *imageKey {
+	if image == nil {
+		return nil
+	}
+	return &imageKey{ID: image.ID, TopLayer: image.TopLayer}
+}
+
+// descendantKey is the part of the key of a package source for a chained
+// stage.
+type descendantKey struct {
+	Index         int             `json:"index"`
+	Alias         string          `json:"alias"`
+	Sources       []string        `json:"sources"`
+	ArchiveDests  []string        `json:"archive_dests"`
+	CacheTargets  []string        `json:"cache_targets"`
+	SecretTargets []string        `json:"secret_targets"`
+	Descendants   []descendantKey `json:"descendants"`
+}
+
+func newDescendantKeys(nodes []*packageSourceDescendant) []descendantKey {
+	res := make([]descendantKey, 0, len(nodes))
+	for _, node := range nodes {
+		res = append(res, descendantKey{
+			Index:         node.index,
+			Alias:         node.alias,
+			Sources:       node.sources,
+			ArchiveDests:  node.archiveDests,
+			CacheTargets:  node.cacheTargets,
+			SecretTargets: node.secretTargets,
+			Descendants:   newDescendantKeys(node.descendants),
+		})
+	}
+	return res
+}
+
+// sourceKey returns the key of the package source in the state, the digest of
+// the configuration of the scanner, the source with its chained stages, and
+// the layers of the images its content is read from.
+func sourceKey(config string, root packageSource, base *storage.Image, stages []stageImage) (string, error) {
+	type stageKey struct {
+		Alias        string    `json:"alias"`
+		Intermediate *imageKey `json:"intermediate"`
+		DiffBase     *imageKey `json:"diff_base"`
+	}
+	stageKeys := make([]stageKey, 0, len(stages))
+	for _, stage := range stages {
+		stageKeys = append(stageKeys, stageKey{
+			Alias:        stage.alias,
+			Intermediate: newImageKey(stage.intermediate),
+			DiffBase:     newImageKey(stage.diffBase),
+		})
+	}
+	return hashKey(struct {
+		Version       int                     `json:"version"`
+		Config        string                  `json:"config"`
+		Kind          containerfile.StageKind `json:"kind"`
+		Index         int                     `json:"index"`
+		Alias         string                  `json:"alias"`
+		Pullspec      string                  `json:"pullspec"`
+		DigestBase    string                  `json:"digest_base"`
+		Sources       []string                `json:"sources"`
+		ArchiveDests  []string                `json:"archive_dests"`
+		CacheTargets  []string                `json:"cache_targets"`
+		SecretTargets []string                `json:"secret_targets"`
+		Descendants   []descendantKey         `json:"descendants"`
+		Base          *imageKey               `json:"base"`
+		Stages        []stageKey              `json:"stages"`
+	}{
+		Version:       stateVersion,
+		Config:        config,
+		Kind:          root.kind,
+		Index:         root.index,
+		Alias:         root.alias,
+		Pullspec:      root.pullspec,
+		DigestBase:    root.digestBase,
+		Sources:       root.sources,
+		ArchiveDests:  root.archiveDests,
+		CacheTargets:  root.cacheTargets,
+		SecretTargets: root.secretTargets,
+		Descendants:   newDescendantKeys(root.descendants),
+		Base:          newImageKey(base),
+		Stages:        stageKeys,
+	})
+}
+
+// hashKey returns the hex sha256 digest of v encoded as JSON.
+func hashKey(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode state key: %w: %w", err, ErrEncode)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// cachedPackages returns the key of the package source in the state of the
+// current scan and its packages from a previous scan, if any. Warnings about
+// squashed intermediate images are reported again, and owners of files are
+// recorded. An empty key is returned if the images of the source can't be
+// found, it's scanned as usual then.
+func (s *Scanner) cachedPackages(root packageSource) (string, []PackageMetadataItem, bool, error) {
+	base, stages, err := s.sourceImages(root)
+	if err != nil {
+		s.logger.Debug("not reusing packages of source from state", "pullspec", root.pullspec, "error", err)
+		return "", nil, false, nil
+	}
+	key, err := sourceKey(s.state.config, root, base, stages)
+	if err != nil {
+		return "", nil, false, err
+	}
+	entry, ok := s.state.lookup(key)
+	if !ok {
+		return key, nil, false, nil
+	}
+
+	s.logger.Debug("reusing packages of unchanged source from state", "pullspec", root.pullspec, "alias", root.alias)
+	for _, stage := range stages {
+		if stage.diffBase == nil {
+			continue
+		}
+		if _, err := s.isSquashed(stage.intermediate, stage.diffBase, stage.alias); err != nil {
+			return "", nil, false, err
+		}
+	}
+	if s.fileOwnership {
+		s.recordFiles(entry.Files)
+	}
+	return key, slices.Clone(entry.Packages), true, nil
+}
+
+// sourceFiles returns the owners of files recorded for the package source by
+// the current scan.
+func (s *Scanner) sourceFiles(root packageSource) []FileMetadataItem {
+	aliases := map[string]bool{root.alias: true}
+	var walk func(nodes []*packageSourceDescendant)
+	walk = func(nodes []*packageSourceDescendant) {
+		for _, node := range nodes {
+			aliases[node.alias] = true
+			walk(node.descendants)
+		}
+	}
+	walk(root.descendants)
+
+	s.filesMu.Lock()
+	defer s.filesMu.Unlock()
+	var res []FileMetadataItem
+	for _, item := range s.files {
+		if item.Pullspec == root.digestBase && aliases[item.StageAlias] {
+			res = append(res, item)
+		}
+	}
+	return res
+}
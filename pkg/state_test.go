@@ -0,0 +1,189 @@
+//go:build unit
+
+package capo
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.podman.io/storage"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+)
+
+func TestSourceKey(t *testing.T) {
+	t.Parallel()
+	source := func() packageSource {
+		return packageSource{
+			kind:       containerfile.StageKindBuilder,
+			alias:      "builder",
+			pullspec:   "fedora:latest",
+			digestBase: "fedora@sha256:1234",
+			sources:    []string{"/usr/bin/app"},
+			descendants: []*packageSourceDescendant{
+				{index: 1, alias: "child", sources: []string{"/usr/lib/"}},
+			},
+		}
+	}
+	base := &storage.Image{ID: "base", TopLayer: "l1"}
+	stages := func() []stageImage {
+		return []stageImage{
+			{alias: "builder", intermediate: &storage.Image{ID: "i1", TopLayer: "l2"}, diffBase: base},
+			{alias: "child", intermediate: &storage.Image{ID: "i2", TopLayer: "l3"}, diffBase: base},
+		}
+	}
+
+	tests := map[string]struct {
+		config  string
+		change  func(*packageSource, *[]stageImage)
+		changed bool
+	}{
+		"unchanged": {
+			change: func(*packageSource, *[]stageImage) {},
+		},
+		"other configuration": {
+			config:  "other",
+			change:  func(*packageSource, *[]stageImage) {},
+			changed: true,
+		},
+		"other sources": {
+			change: func(s *packageSource, _ *[]stageImage) {
+				s.sources = []string{"/usr/bin/other"}
+			},
+			changed: true,
+		},
+		"other sources of a chained stage": {
+			change: func(s *packageSource, _ *[]stageImage) {
+				s.descendants[0].sources = []string{"/usr/share/"}
+			},
+			changed: true,
+		},
+		"rebuilt chained stage": {
+			change: func(_ *packageSource, st *[]stageImage) {
+				(*st)[1].intermediate = &storage.Image{ID: "i3", TopLayer: "l4"}
+			},
+			changed: true,
+		},
+		"chained stage without intermediate image": {
+			change: func(_ *packageSource, st *[]stageImage) {
+				*st = (*st)[:1]
+			},
+			changed: true,
+		},
+	}
+
+	expected, err := sourceKey("", source(), base, stages())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			s, st := source(), stages()
+			tt.change(&s, &st)
+			key, err := sourceKey(tt.config, s, base, st)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed := key != expected; changed != tt.changed {
+				t.Errorf("expected key changed %v, got %v", tt.changed, changed)
+			}
+		})
+	}
+}
+
+func TestScanState(t *testing.T) {
+	t.Parallel()
+	entry := stateEntry{
+		Packages: []PackageMetadataItem{{PackageURL: "pkg:rpm/fedora/bash@5.2", OriginType: "builder"}},
+		Files:    []FileMetadataItem{{Path: "/usr/bin/bash", PackageURL: "pkg:rpm/fedora/bash@5.2"}},
+	}
+	tests := map[string]struct {
+		content  string
+		expected map[string]stateEntry
+	}{
+		"missing": {
+			expected: map[string]stateEntry{},
+		},
+		"invalid": {
+			content:  "{",
+			expected: map[string]stateEntry{},
+		},
+		"other version": {
+			content:  `{"version": 0, "sources": {"a": {"packages": []}}}`,
+			expected: map[string]stateEntry{},
+		},
+		"previous scan": {
+			content:  mustJSON(t, &scanState{Version: stateVersion, Sources: map[string]stateEntry{"a": entry}}),
+			expected: map[string]stateEntry{"a": entry},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			path := filepath.Join(t.TempDir(), "state.json")
+			if tt.content != "" {
+				if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s := &Scanner{logger: slog.Default(), stateFile: path}
+			st, err := s.loadState()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.expected, st.Sources); diff != "" {
+				t.Errorf("unexpected sources (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestScanStateWritePrunesUnused(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "state.json")
+	entry := stateEntry{Packages: []PackageMetadataItem{{PackageURL: "pkg:rpm/fedora/bash@5.2"}}}
+	content := mustJSON(t, &scanState{
+		Version: stateVersion,
+		Sources: map[string]stateEntry{"reused": entry, "stale": entry},
+	})
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Scanner{logger: slog.Default(), stateFile: path}
+	st, err := s.loadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := st.lookup("reused"); !ok {
+		t.Fatal("expected entry of a previous scan")
+	}
+	st.store("scanned", entry)
+	if err := st.write(path); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err = s.loadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]stateEntry{"reused": entry, "scanned": entry}
+	if diff := cmp.Diff(expected, st.Sources); diff != "" {
+		t.Errorf("unexpected sources (-want +got):\n%s", diff)
+	}
+}
+
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
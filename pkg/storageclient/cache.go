@@ -0,0 +1,70 @@
+package storageclient
+
+import (
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// WithCache returns a client memoizing the results of c by reference, so
+// every image is looked up in storage once however many times it's resolved,
+// e.g. by the resolution of all pullspecs of a containerfile and by the scan
+// after it. Errors are memoized as well. Safe for concurrent use if c is.
+func WithCache(c Client) Client {
+	if _, ok := c.(*cacheClient); ok {
+		return c
+	}
+	return &cacheClient{
+		client:       c,
+		digests:      make(map[string]*cacheEntry[digest.Digest]),
+		indexDigests: make(map[string]*cacheEntry[digest.Digest]),
+		configs:      make(map[string]*cacheEntry[OCIImageConfig]),
+	}
+}
+
+// cacheEntry is a memoized result, computed once by the first caller.
+type cacheEntry[T any] struct {
+	once  sync.Once
+	value T
+	err   error
+}
+
+type cacheClient struct {
+	client Client
+
+	mu           sync.Mutex
+	digests      map[string]*cacheEntry[digest.Digest]
+	indexDigests map[string]*cacheEntry[digest.Digest]
+	configs      map[string]*cacheEntry[OCIImageConfig]
+}
+
+// cached returns the result of get for ref memoized in entries. Concurrent
+// callers for the same ref wait for the first one instead of calling get.
+func cached[T any](
+	mu *sync.Mutex, entries map[string]*cacheEntry[T], ref string, get func(string) (T, error),
+) (T, error) {
+	mu.Lock()
+	entry, ok := entries[ref]
+	if !ok {
+		entry = &cacheEntry[T]{}
+		entries[ref] = entry
+	}
+	mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.value, entry.err = get(ref)
+	})
+	return entry.value, entry.err
+}
+
+func (c *cacheClient) ResolveDigest(ref string) (digest.Digest, error) {
+	return cached(&c.mu, c.digests, ref, c.client.ResolveDigest)
+}
+
+func (c *cacheClient) ResolveIndexDigest(ref string) (digest.Digest, error) {
+	return cached(&c.mu, c.indexDigests, ref, c.client.ResolveIndexDigest)
+}
+
+func (c *cacheClient) GetImageConfig(ref string) (OCIImageConfig, error) {
+	return cached(&c.mu, c.configs, ref, c.client.GetImageConfig)
+}
@@ -0,0 +1,81 @@
+//go:build unit
+
+package storageclient
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+var errNotFound = errors.New("not found")
+
+// countingClient counts the lookups of every method, and fails to resolve
+// the references other than known.
+type countingClient struct {
+	known                          string
+	digests, indexDigests, configs atomic.Int32
+}
+
+func (c *countingClient) ResolveDigest(ref string) (digest.Digest, error) {
+	c.digests.Add(1)
+	if ref != c.known {
+		return "", errNotFound
+	}
+	return amd64Digest, nil
+}
+
+func (c *countingClient) ResolveIndexDigest(string) (digest.Digest, error) {
+	c.indexDigests.Add(1)
+	return "", nil
+}
+
+func (c *countingClient) GetImageConfig(string) (OCIImageConfig, error) {
+	c.configs.Add(1)
+	return OCIImageConfig{}, nil
+}
+
+func TestWithCache(t *testing.T) {
+	t.Parallel()
+	const known = "docker.io/library/golang:1.26"
+	counting := &countingClient{known: known}
+	client := WithCache(counting)
+	if WithCache(client) != client {
+		t.Errorf("WithCache() of a caching client isn't the client")
+	}
+
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if dig, err := client.ResolveDigest(known); err != nil || dig != amd64Digest {
+				t.Errorf("ResolveDigest(%q) = %q, %v, want %q", known, dig, err, amd64Digest)
+			}
+			if _, err := client.ResolveDigest("missing"); !errors.Is(err, errNotFound) {
+				t.Errorf("expected error wrapping %v, got: %v", errNotFound, err)
+			}
+			_, _ = client.ResolveIndexDigest(known)
+			_, _ = client.GetImageConfig(known)
+		}()
+	}
+	wg.Wait()
+
+	for name, n := range map[string]int32{
+		"ResolveDigest":      counting.digests.Load(),
+		"ResolveIndexDigest": counting.indexDigests.Load(),
+		"GetImageConfig":     counting.configs.Load(),
+	} {
+		want := int32(1)
+		if name == "ResolveDigest" {
+			// the known and the missing reference
+			want = 2
+		}
+		if n != want {
+			t.Errorf("%s looked up %d times, want %d", name, n, want)
+		}
+	}
+}
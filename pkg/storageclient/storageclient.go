@@ -6,10 +6,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
+	"slices"
 	"strings"
 
 	"github.com/opencontainers/go-digest"
 	"go.podman.io/image/v5/docker/reference"
+	"go.podman.io/image/v5/manifest"
+	"go.podman.io/image/v5/types"
 	"go.podman.io/storage"
 	"go.podman.io/storage/pkg/reexec"
 )
@@ -74,17 +78,117 @@ type OCIImageConfig struct {
 		Labels  map[string]string `json:"Labels"`
 		Workdir string            `json:"WorkingDir"`
 	} `json:"config"`
+	// History of the image, from its first instruction on.
+	History []OCIHistory `json:"history"`
+}
+
+// OCIHistory is an entry of the history of an image: an instruction of a
+// build, and whether it created a layer.
+type OCIHistory struct {
+	CreatedBy  string `json:"created_by"`
+	EmptyLayer bool   `json:"empty_layer"`
 }
 
 // Client provides methods for container image storage operations.
 type Client interface {
 	ResolveDigest(string) (digest.Digest, error)
+	ResolveIndexDigest(string) (digest.Digest, error)
 	GetImageConfig(string) (OCIImageConfig, error)
 }
 
 // BuildahClient is a Storage Client backed by a local buildah containers storage.
 type BuildahClient struct {
-	store storage.Store
+	store    storage.Store
+	platform Platform
+}
+
+// ClientOption configures a BuildahClient.
+type ClientOption func(*BuildahClient)
+
+// WithPlatform sets the platform of instances resolved from manifest lists
+// (image indexes). Defaults to the platform capo runs on, the same as buildah.
+func WithPlatform(platform Platform) ClientOption {
+	return func(c *BuildahClient) {
+		c.platform = platform
+	}
+}
+
+// ForPlatform returns a copy of the client resolving manifest lists for the
+// platform instead of the platform of the client.
+func (c *BuildahClient) ForPlatform(platform Platform) Client {
+	res := *c
+	res.platform = platform
+	return &res
+}
+
+// WithRefPlatforms returns a client resolving the references in platforms
+// (e.g. base images of FROM --platform=$BUILDPLATFORM) for their platform,
+// and other references as c. Returns c if it can't resolve other platforms
+// than its own (no BuildahClient) or platforms is empty.
+func WithRefPlatforms(c Client, platforms map[string]Platform) Client {
+	pc, ok := c.(interface{ ForPlatform(Platform) Client })
+	if !ok || len(platforms) == 0 {
+		return c
+	}
+	clients := make(map[string]Client, len(platforms))
+	for ref, platform := range platforms {
+		clients[ref] = pc.ForPlatform(platform)
+	}
+	return &refPlatformClient{Client: c, clients: clients}
+}
+
+// refPlatformClient resolves references and gets their configs by the client
+// of their platform, so both are of the same instance of a manifest list.
+type refPlatformClient struct {
+	Client
+	// clients by the references they resolve
+	clients map[string]Client
+}
+
+func (c *refPlatformClient) client(ref string) Client {
+	if client, ok := c.clients[ref]; ok {
+		return client
+	}
+	return c.Client
+}
+
+func (c *refPlatformClient) ResolveDigest(ref string) (digest.Digest, error) {
+	return c.client(ref).ResolveDigest(ref)
+}
+
+func (c *refPlatformClient) ResolveIndexDigest(ref string) (digest.Digest, error) {
+	return c.client(ref).ResolveIndexDigest(ref)
+}
+
+func (c *refPlatformClient) GetImageConfig(ref string) (OCIImageConfig, error) {
+	return c.client(ref).GetImageConfig(ref)
+}
+
+// Platform selects an instance of a manifest list. Empty fields default to
+// the platform capo runs on.
+type Platform struct {
+	OS           string
+	Architecture string
+	Variant      string
+}
+
+// ParsePlatform parses a platform in the os/arch[/variant] form of the
+// buildah --platform option. An empty string is the default platform.
+func ParsePlatform(platform string) (Platform, error) {
+	if platform == "" {
+		return Platform{}, nil
+	}
+
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || slices.Contains(parts, "") {
+		return Platform{}, fmt.Errorf("%w: %q, expected os/arch[/variant]", ErrInvalidPlatform, platform)
+	}
+
+	res := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		res.Variant = parts[2]
+	}
+	return res, nil
 }
 
 // ErrPullspecResolve is returned when a pullspec cannot be found or resolved
@@ -99,15 +203,58 @@ var ErrBuildahStorageSetup = errors.New("error while setting up buildah storage"
 // get the config object of an image.
 var ErrOCIImageConfig = errors.New("could not get config for image")
 
-// DefaultBuildahClient creates a Client using the default
-// containers/storage location.
+// ErrInvalidPlatform is returned for platforms not in the os/arch[/variant] form.
+var ErrInvalidPlatform = errors.New("[ERR_INVALID_PLATFORM] invalid platform")
+
+// ErrPlatformMismatch is returned when an image pulled through a manifest list
+// is stored for a different platform than the requested one.
+var ErrPlatformMismatch = errors.New("[ERR_PLATFORM_MISMATCH] image in storage is not for the requested platform")
+
+// Environment variables buildah reads to override the storage configuration,
+// in addition to CONTAINERS_STORAGE_CONF (the path of storage.conf), which
+// containers/storage reads itself.
+const (
+	// StorageDriverEnv overrides the storage driver, e.g. "vfs".
+	StorageDriverEnv = "STORAGE_DRIVER"
+	// StorageOptsEnv overrides the options of the storage driver, separated
+	// by commas, e.g. "overlay.mount_program=/usr/bin/fuse-overlayfs".
+	StorageOptsEnv = "STORAGE_OPTS"
+)
+
+// DefaultStoreOptions returns the containers/storage options buildah uses in
+// the same environment: those of storage.conf (or of the file in
+// CONTAINERS_STORAGE_CONF), with the driver and its options overridden by
+// STORAGE_DRIVER and STORAGE_OPTS. So capo running next to buildah opens the
+// same store without extra configuration.
+func DefaultStoreOptions() (storage.StoreOptions, error) {
+	opts, err := storage.DefaultStoreOptions()
+	if err != nil {
+		return storage.StoreOptions{}, err
+	}
+	return withStorageEnv(opts, os.Getenv), nil
+}
+
+// withStorageEnv returns opts with the driver and its options overridden by
+// the environment variables read by getenv, if set.
+func withStorageEnv(opts storage.StoreOptions, getenv func(string) string) storage.StoreOptions {
+	if driver := getenv(StorageDriverEnv); driver != "" {
+		opts.GraphDriverName = driver
+	}
+	if driverOpts := getenv(StorageOptsEnv); driverOpts != "" {
+		opts.GraphDriverOptions = strings.Split(driverOpts, ",")
+	}
+	return opts
+}
+
+// DefaultBuildahClient creates a Client using the containers/storage store
+// buildah uses in the same environment (see DefaultStoreOptions).
 func DefaultBuildahClient() (Client, error) {
 	// The containers/storage library requires this to run for some operations
 	if reexec.Init() {
 		return nil, fmt.Errorf("%w: failed to init reexec", ErrBuildahStorageSetup)
 	}
 
-	opts, err := storage.DefaultStoreOptions()
+	opts, err := DefaultStoreOptions()
 	if err != nil {
 		return nil,
 			fmt.Errorf("%w: failed to create default storage options: %w", ErrBuildahStorageSetup, err)
@@ -122,10 +269,14 @@ func DefaultBuildahClient() (Client, error) {
 }
 
 // NewBuildahClient the passed containers/storage.Store object to create a Client.
-func NewBuildahClient(store storage.Store) Client {
-	return &BuildahClient{
+func NewBuildahClient(store storage.Store, opts ...ClientOption) Client {
+	c := &BuildahClient{
 		store: store,
 	}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
 }
 
 // LookupImage looks up an image in the store by ref (tag or digest), with
@@ -188,18 +339,93 @@ func stripTagFromDigestedRef(ref string) (string, error) {
 // its content digest in the form "sha256:<hex>". Transport prefixes (e.g.
 // "docker://") are stripped before the lookup. The reference can be the
 // image's name or ID.
+// For images pulled through a manifest list (image index), the digest of the
+// platform-specific manifest is returned, not the digest of the list (see
+// ResolveIndexDigest).
 func (c *BuildahClient) ResolveDigest(ref string) (digest.Digest, error) {
+	instance, _, err := c.resolveManifests(ref)
+	return instance, err
+}
+
+// ResolveIndexDigest returns the digest of the manifest list (image index)
+// the image with the given pullspec was pulled through, or an empty digest if
+// the image was pulled by its manifest directly.
+func (c *BuildahClient) ResolveIndexDigest(ref string) (digest.Digest, error) {
+	_, index, err := c.resolveManifests(ref)
+	return index, err
+}
+
+// resolveManifests returns the digest of the platform-specific manifest of
+// the image and the digest of the manifest list it was pulled through, if any.
+//
+// Background: when an image is pulled by a tag referring to a manifest list,
+// containers/image stores the list next to the platform-specific manifest
+// and the image digest is the digest of the list.
+func (c *BuildahClient) resolveManifests(ref string) (digest.Digest, digest.Digest, error) {
 	imgId, err := c.lookupImage(ref)
 	if err != nil {
-		return "", fmt.Errorf("%w %q: %w", ErrPullspecResolve, ref, err)
+		return "", "", fmt.Errorf("%w %q: %w", ErrPullspecResolve, ref, err)
 	}
 
 	img, err := c.store.Image(imgId)
 	if err != nil {
-		return "", fmt.Errorf("%w %q: %w", ErrPullspecResolve, ref, err)
+		return "", "", fmt.Errorf("%w %q: %w", ErrPullspecResolve, ref, err)
+	}
+
+	blob, err := c.store.ImageBigData(img.ID, manifestBigDataKey(img.Digest))
+	if err != nil {
+		// e.g. images committed by buildah only store the manifest under
+		// the default key, they are never lists
+		return img.Digest, "", nil
+	}
+
+	instance, err := resolveInstance(blob, c.platform)
+	if err != nil {
+		return "", "", fmt.Errorf("%w %q: %w", ErrPullspecResolve, ref, err)
+	}
+	if instance == "" {
+		return img.Digest, "", nil
+	}
+	if !slices.Contains(img.Digests, instance) {
+		return "", "", fmt.Errorf(
+			"%w %q: %w: manifest %s of manifest list %s is not in storage, check the platform matches the build",
+			ErrPullspecResolve, ref, ErrPlatformMismatch, instance, img.Digest,
+		)
+	}
+
+	return instance, img.Digest, nil
+}
+
+// manifestBigDataKey returns the key of the image big data item with the
+// manifest with the given digest, the same as containers/image uses.
+func manifestBigDataKey(dig digest.Digest) string {
+	return storage.ImageDigestManifestBigDataNamePrefix + "-" + dig.String()
+}
+
+// resolveInstance returns the digest of the instance for the platform if the
+// manifest blob is a manifest list (image index), and an empty digest for
+// manifests of single images.
+func resolveInstance(manifestBlob []byte, platform Platform) (digest.Digest, error) {
+	mimeType := manifest.GuessMIMEType(manifestBlob)
+	if !manifest.MIMETypeIsMultiImage(mimeType) {
+		return "", nil
+	}
+
+	list, err := manifest.ListFromBlob(manifestBlob, mimeType)
+	if err != nil {
+		return "", fmt.Errorf("parsing manifest list: %w", err)
+	}
+
+	instance, err := list.ChooseInstance(&types.SystemContext{
+		OSChoice:           platform.OS,
+		ArchitectureChoice: platform.Architecture,
+		VariantChoice:      platform.Variant,
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrPlatformMismatch, err)
 	}
 
-	return img.Digest, nil
+	return instance, nil
 }
 
 // Get an OCIImageConfig struct for the passed pullspec via buildah's container
@@ -3,7 +3,12 @@
 package storageclient
 
 import (
+	"errors"
+	"slices"
 	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"go.podman.io/storage"
 )
 
 func TestIsSpecialBase(t *testing.T) {
@@ -103,3 +108,250 @@ func TestStripTransport(t *testing.T) {
 		})
 	}
 }
+
+func TestParsePlatform(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		input   string
+		want    Platform
+		wantErr error
+	}{
+		"empty is the default": {
+			input: "",
+			want:  Platform{},
+		},
+		"os and arch": {
+			input: "linux/amd64",
+			want:  Platform{OS: "linux", Architecture: "amd64"},
+		},
+		"with variant": {
+			input: "linux/arm64/v8",
+			want:  Platform{OS: "linux", Architecture: "arm64", Variant: "v8"},
+		},
+		"arch only": {
+			input:   "amd64",
+			wantErr: ErrInvalidPlatform,
+		},
+		"empty arch": {
+			input:   "linux/",
+			wantErr: ErrInvalidPlatform,
+		},
+		"too many parts": {
+			input:   "linux/arm/v7/extra",
+			wantErr: ErrInvalidPlatform,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParsePlatform(tc.input)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error wrapping %v, got: %v", tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Errorf("ParsePlatform(%q) = %+v, want %+v", tc.input, got, tc.want)
+			}
+		})
+	}
+}
+
+const (
+	amd64Digest = digest.Digest("sha256:1111111111111111111111111111111111111111111111111111111111111111")
+	arm64Digest = digest.Digest("sha256:2222222222222222222222222222222222222222222222222222222222222222")
+)
+
+var testIndex = []byte(`{
+  "schemaVersion": 2,
+  "mediaType": "application/vnd.oci.image.index.v1+json",
+  "manifests": [
+    {
+      "mediaType": "application/vnd.oci.image.manifest.v1+json",
+      "digest": "` + amd64Digest.String() + `",
+      "size": 1000,
+      "platform": {"architecture": "amd64", "os": "linux"}
+    },
+    {
+      "mediaType": "application/vnd.oci.image.manifest.v1+json",
+      "digest": "` + arm64Digest.String() + `",
+      "size": 1000,
+      "platform": {"architecture": "arm64", "os": "linux", "variant": "v8"}
+    }
+  ]
+}`)
+
+var testManifest = []byte(`{
+  "schemaVersion": 2,
+  "mediaType": "application/vnd.oci.image.manifest.v1+json",
+  "config": {
+    "mediaType": "application/vnd.oci.image.config.v1+json",
+    "digest": "` + amd64Digest.String() + `",
+    "size": 100
+  },
+  "layers": []
+}`)
+
+func TestResolveInstance(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		blob     []byte
+		platform Platform
+		want     digest.Digest
+		wantErr  error
+	}{
+		"index amd64": {
+			blob:     testIndex,
+			platform: Platform{OS: "linux", Architecture: "amd64"},
+			want:     amd64Digest,
+		},
+		"index arm64": {
+			blob:     testIndex,
+			platform: Platform{OS: "linux", Architecture: "arm64", Variant: "v8"},
+			want:     arm64Digest,
+		},
+		"index without the platform": {
+			blob:     testIndex,
+			platform: Platform{OS: "linux", Architecture: "s390x"},
+			wantErr:  ErrPlatformMismatch,
+		},
+		"single image manifest": {
+			blob:     testManifest,
+			platform: Platform{OS: "linux", Architecture: "amd64"},
+			want:     "",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := resolveInstance(tc.blob, tc.platform)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error wrapping %v, got: %v", tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Errorf("resolveInstance() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWithStorageEnv(t *testing.T) {
+	t.Parallel()
+	defaults := storage.StoreOptions{
+		GraphDriverName:    "overlay",
+		GraphDriverOptions: []string{"overlay.mountopt=nodev"},
+		GraphRoot:          "/var/lib/containers/storage",
+	}
+	tests := map[string]struct {
+		env  map[string]string
+		want storage.StoreOptions
+	}{
+		"no overrides": {
+			want: defaults,
+		},
+		"driver": {
+			env: map[string]string{StorageDriverEnv: "vfs"},
+			want: storage.StoreOptions{
+				GraphDriverName:    "vfs",
+				GraphDriverOptions: defaults.GraphDriverOptions,
+				GraphRoot:          defaults.GraphRoot,
+			},
+		},
+		"driver options": {
+			env: map[string]string{
+				StorageOptsEnv: "overlay.mount_program=/usr/bin/fuse-overlayfs,overlay.mountopt=nodev,metacopy=on",
+			},
+			want: storage.StoreOptions{
+				GraphDriverName: "overlay",
+				GraphDriverOptions: []string{
+					"overlay.mount_program=/usr/bin/fuse-overlayfs", "overlay.mountopt=nodev", "metacopy=on",
+				},
+				GraphRoot: defaults.GraphRoot,
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got := withStorageEnv(defaults, func(key string) string { return tc.env[key] })
+			if got.GraphDriverName != tc.want.GraphDriverName ||
+				!slices.Equal(got.GraphDriverOptions, tc.want.GraphDriverOptions) ||
+				got.GraphRoot != tc.want.GraphRoot {
+				t.Errorf("withStorageEnv() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+// platformClient resolves every reference to the instance of testIndex for
+// its platform, and returns configs labeled with the instance.
+type platformClient struct {
+	platform Platform
+}
+
+func (c platformClient) ResolveDigest(string) (digest.Digest, error) {
+	return resolveInstance(testIndex, c.platform)
+}
+
+func (c platformClient) ResolveIndexDigest(string) (digest.Digest, error) {
+	return "", nil
+}
+
+func (c platformClient) GetImageConfig(string) (OCIImageConfig, error) {
+	instance, err := resolveInstance(testIndex, c.platform)
+	if err != nil {
+		return OCIImageConfig{}, err
+	}
+	var config OCIImageConfig
+	config.Config.Labels = map[string]string{"instance": instance.String()}
+	return config, nil
+}
+
+func (c platformClient) ForPlatform(platform Platform) Client {
+	return platformClient{platform: platform}
+}
+
+func TestWithRefPlatforms(t *testing.T) {
+	t.Parallel()
+	target := Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	build := Platform{OS: "linux", Architecture: "amd64"}
+	client := WithRefPlatforms(platformClient{platform: target}, map[string]Platform{
+		"docker.io/library/golang:1.26": build,
+	})
+
+	tests := map[string]struct {
+		ref  string
+		want digest.Digest
+	}{
+		"ref with a platform": {
+			ref:  "docker.io/library/golang:1.26",
+			want: amd64Digest,
+		},
+		"other ref": {
+			ref:  "registry.access.redhat.com/ubi9/ubi-micro:latest",
+			want: arm64Digest,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := client.ResolveDigest(tc.ref)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("ResolveDigest(%q) = %q, want %q", tc.ref, got, tc.want)
+			}
+
+			config, err := client.GetImageConfig(tc.ref)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if instance := config.Config.Labels["instance"]; instance != tc.want.String() {
+				t.Errorf("GetImageConfig(%q) is of instance %q, want %q", tc.ref, instance, tc.want)
+			}
+		})
+	}
+}
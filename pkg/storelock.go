@@ -0,0 +1,122 @@
+// Sharing the container storage with concurrent builds: an advisory lock
+// held during a scan and detection of images changed while scanning.
+
+package capo
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.podman.io/storage"
+)
+
+// ErrStoreLocked is returned when the lock of the store isn't released by
+// the builds holding it within the timeout of WithWaitForStore.
+var ErrStoreLocked = fmt.Errorf(
+	"[ERR_STORE_LOCKED] timed out waiting for the lock of the container storage: %w", ErrStorage,
+)
+
+// ErrStoreModified is returned when images of the store were added, removed
+// or renamed during a scan, e.g. by a concurrent build, so intermediate
+// images may have been found among the wrong images.
+var ErrStoreModified = fmt.Errorf("[ERR_STORE_MODIFIED] container storage was modified during the scan: %w", ErrStorage)
+
+// StoreLockFile is the name of the advisory lock file in the run root of the
+// store, see WithWaitForStore.
+const StoreLockFile = "capo.lock"
+
+// Interval of attempts to take the lock of the store.
+const storeLockPoll = 100 * time.Millisecond
+
+// Configure the scanner to hold a shared advisory lock (flock) of the
+// StoreLockFile in the run root of the store during a scan, waiting at most
+// the timeout for builds holding it exclusively, e.g. run with
+// "flock <runroot>/capo.lock buildah build ...". Scans fail with
+// ErrStoreLocked after the timeout. Non-positive timeouts disable the lock,
+// the default.
+func WithWaitForStore(timeout time.Duration) Option {
+	return func(s *Scanner) {
+		s.storeLockTimeout = timeout
+	}
+}
+
+// lockStore takes a shared lock of the StoreLockFile of the store, created if
+// missing, waiting at most the timeout. Returns the function releasing the
+// lock.
+func lockStore(store storage.Store, timeout time.Duration) (func() error, error) {
+	path := filepath.Join(store.RunRoot(), StoreLockFile)
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock file: %w: %w", err, ErrIO)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			_ = f.Close()
+			return nil, fmt.Errorf("locking %s: %w: %w", path, err, ErrIO)
+		}
+		if time.Now().After(deadline) {
+			_ = f.Close()
+			return nil, fmt.Errorf("%w: %s is held after %s", ErrStoreLocked, path, timeout)
+		}
+		time.Sleep(storeLockPoll)
+	}
+
+	// closing the file releases the lock
+	return f.Close, nil
+}
+
+// storeImages returns the sorted names of the images in the store by their
+// IDs, among which intermediate images are found.
+func storeImages(store storage.Store) (map[string][]string, error) {
+	images, err := store.Images()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w: %w", err, ErrStorage)
+	}
+	res := make(map[string][]string, len(images))
+	for _, image := range images {
+		res[image.ID] = slices.Sorted(slices.Values(image.Names))
+	}
+	return res, nil
+}
+
+// checkStoreImages fails with ErrStoreModified listing the images added,
+// removed or renamed since the passed listing of storeImages.
+func checkStoreImages(store storage.Store, before map[string][]string) error {
+	after, err := storeImages(store)
+	if err != nil {
+		return err
+	}
+
+	changes := make([]string, 0)
+	for _, id := range slices.Sorted(maps.Keys(after)) {
+		names, ok := before[id]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("added image %s %v", id, after[id]))
+		case !slices.Equal(names, after[id]):
+			changes = append(changes, fmt.Sprintf("renamed image %s from %v to %v", id, names, after[id]))
+		}
+	}
+	for _, id := range slices.Sorted(maps.Keys(before)) {
+		if _, ok := after[id]; !ok {
+			changes = append(changes, fmt.Sprintf("removed image %s %v", id, before[id]))
+		}
+	}
+	if len(changes) > 0 {
+		return fmt.Errorf("%w: %s", ErrStoreModified, strings.Join(changes, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+//go:build unit
+
+package capo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.podman.io/storage"
+
+	"github.com/konflux-ci/capo/pkg/capotest"
+)
+
+// runRootStore is a store with the run root at a directory of the test.
+type runRootStore struct {
+	storage.Store
+	runRoot string
+}
+
+func (s runRootStore) RunRoot() string {
+	return s.runRoot
+}
+
+func TestLockStore(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		held        int
+		expectedErr error
+	}{
+		"not held": {},
+		"held shared by another scan": {
+			held: syscall.LOCK_SH,
+		},
+		"held exclusively by a build": {
+			held:        syscall.LOCK_EX,
+			expectedErr: ErrStoreLocked,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			store := runRootStore{runRoot: t.TempDir()}
+			if tt.held != 0 {
+				f, err := os.Create(filepath.Join(store.runRoot, StoreLockFile))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				if err := syscall.Flock(int(f.Fd()), tt.held); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			unlock, err := lockStore(store, 2*storeLockPoll)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tt.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if err := unlock(); err != nil {
+				t.Errorf("unexpected error releasing the lock: %v", err)
+			}
+		})
+	}
+}
+
+func TestLockStoreWaits(t *testing.T) {
+	t.Parallel()
+	store := runRootStore{runRoot: t.TempDir()}
+	f, err := os.Create(filepath.Join(store.runRoot, StoreLockFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		t.Fatal(err)
+	}
+	// the build finishes while the scan waits
+	time.AfterFunc(2*storeLockPoll, func() { _ = f.Close() })
+
+	unlock, err := lockStore(store, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = unlock()
+}
+
+func TestCheckStoreImages(t *testing.T) {
+	t.Parallel()
+	before := map[string][]string{
+		"base":   {"quay.io/org/base:latest"},
+		"stage":  nil,
+		"target": {"localhost/app:latest"},
+	}
+	tests := map[string]struct {
+		images      []storage.Image
+		expectedErr error
+	}{
+		"unchanged": {
+			images: []storage.Image{
+				{ID: "base", Names: []string{"quay.io/org/base:latest"}},
+				{ID: "stage"},
+				{ID: "target", Names: []string{"localhost/app:latest"}},
+			},
+		},
+		"added intermediate image": {
+			images: []storage.Image{
+				{ID: "base", Names: []string{"quay.io/org/base:latest"}},
+				{ID: "stage"},
+				{ID: "other-stage"},
+				{ID: "target", Names: []string{"localhost/app:latest"}},
+			},
+			expectedErr: ErrStoreModified,
+		},
+		"retagged image": {
+			images: []storage.Image{
+				{ID: "base", Names: []string{"quay.io/org/base:latest"}},
+				{ID: "stage"},
+				{ID: "target"},
+			},
+			expectedErr: ErrStoreModified,
+		},
+		"removed image": {
+			images: []storage.Image{
+				{ID: "base", Names: []string{"quay.io/org/base:latest"}},
+				{ID: "target", Names: []string{"localhost/app:latest"}},
+			},
+			expectedErr: ErrStoreModified,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			store := capotest.NewStore()
+			for _, image := range tt.images {
+				store.AddImage(image, "")
+			}
+			if err := checkStoreImages(store, before); !errors.Is(err, tt.expectedErr) {
+				t.Errorf("expected error %v, got %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
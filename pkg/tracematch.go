@@ -0,0 +1,104 @@
+// Tracing of the decisions of the matcher of extracted paths against the
+// sources of a copy, see WithTraceMatching.
+
+package capo
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// most decisions traced per second, further ones in the same second
+	// are counted and dropped
+	traceMatchingRate = 200
+	// most decisions traced during a Scan
+	traceMatchingMax = 10000
+)
+
+// Reasons of paths excluded from extraction, see matchTracer.
+const (
+	traceExcludedNoSource  = "not under any source"
+	traceExcludedDevice    = "device or fifo"
+	traceExcludedWhiteout  = "whiteout"
+	traceExcludedTraversal = "outside of the extraction root"
+	traceExcludedTooLong   = "name too long"
+)
+
+// Configure the scanner to log at debug level, for every tar entry and image
+// path considered for extraction, the source pattern it matched or why it was
+// excluded. At most traceMatchingRate decisions are logged per second and
+// traceMatchingMax per scan, the number of dropped ones is logged at the end
+// of the scan.
+func WithTraceMatching(traceMatching bool) Option {
+	return func(s *Scanner) {
+		s.traceMatching = traceMatching
+	}
+}
+
+// matchTracer logs matcher decisions with a rate limit. A nil matchTracer
+// logs nothing. Safe for concurrent use.
+type matchTracer struct {
+	logger *slog.Logger
+	now    func() time.Time
+
+	mu sync.Mutex
+	// start of the current second and the decisions logged in it
+	window   time.Time
+	inWindow int
+	total    int
+	dropped  int
+}
+
+func newMatchTracer(logger *slog.Logger) *matchTracer {
+	return &matchTracer{logger: logger, now: time.Now}
+}
+
+// allow reports whether a decision may be logged, counting it as dropped
+// otherwise.
+func (t *matchTracer) allow() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	if now.Sub(t.window) >= time.Second {
+		t.window, t.inWindow = now, 0
+	}
+	if t.total >= traceMatchingMax || t.inWindow >= traceMatchingRate {
+		t.dropped++
+		return false
+	}
+	t.inWindow++
+	t.total++
+	return true
+}
+
+// matched logs that p was included by the source pattern.
+func (t *matchTracer) matched(p, pattern string) {
+	if t == nil || !t.allow() {
+		return
+	}
+	t.logger.Debug("trace matching: included", "path", p, "source", pattern)
+}
+
+// excluded logs that p was excluded for the reason.
+func (t *matchTracer) excluded(p, reason string) {
+	if t == nil || !t.allow() {
+		return
+	}
+	t.logger.Debug("trace matching: excluded", "path", p, "reason", reason)
+}
+
+// flush logs the number of dropped decisions, if any.
+func (t *matchTracer) flush() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.dropped > 0 {
+		t.logger.Debug("trace matching: decisions dropped by the rate limit",
+			"logged", t.total, "dropped", t.dropped)
+	}
+}
@@ -0,0 +1,92 @@
+//go:build unit
+
+package capo
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchingPattern(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		patterns []string
+		path     string
+		expected string
+		found    bool
+	}{
+		"literal":            {patterns: []string{"/usr/bin/go"}, path: "usr/bin/go", expected: "/usr/bin/go", found: true},
+		"descendant":         {patterns: []string{"/opt", "/app"}, path: "/app/main.go", expected: "/app", found: true},
+		"first of several":   {patterns: []string{"/app*", "/app"}, path: "/app/x", expected: "/app*", found: true},
+		"segment boundary":   {patterns: []string{"/app"}, path: "/application"},
+		"no patterns":        {path: "/app"},
+		"root covers all":    {patterns: []string{"/"}, path: "etc/os-release", expected: "/", found: true},
+		"wildcard ancestor":  {patterns: []string{"/opt/app*"}, path: "/opt/app1/go.mod", expected: "/opt/app*", found: true},
+		"wildcard no ascend": {patterns: []string{"/opt/app*"}, path: "/opt/other/app1"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			actual, found := matchingPattern(tc.patterns, tc.path)
+			if actual != tc.expected || found != tc.found {
+				t.Errorf("matchingPattern() = %q, %t, want %q, %t", actual, found, tc.expected, tc.found)
+			}
+			if Includes(tc.patterns, tc.path) != tc.found {
+				t.Errorf("Includes() = %t, want %t", !tc.found, tc.found)
+			}
+		})
+	}
+}
+
+func TestMatchTracerRateLimit(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	tracer := newMatchTracer(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	now := time.Unix(0, 0)
+	tracer.now = func() time.Time { return now }
+
+	for range traceMatchingRate + 5 {
+		tracer.matched("/app/main.go", "/app")
+	}
+	now = now.Add(time.Second)
+	tracer.excluded("/etc/passwd", traceExcludedNoSource)
+	tracer.flush()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != traceMatchingRate+2 {
+		t.Fatalf("logged %d lines, want %d", len(lines), traceMatchingRate+2)
+	}
+	if !strings.Contains(lines[traceMatchingRate], "reason=\"not under any source\"") {
+		t.Errorf("decision of the next second not logged, got %q", lines[traceMatchingRate])
+	}
+	if !strings.Contains(lines[len(lines)-1], "dropped=5") {
+		t.Errorf("dropped decisions not logged, got %q", lines[len(lines)-1])
+	}
+
+	var nilTracer *matchTracer
+	nilTracer.matched("/app", "/app")
+	nilTracer.flush()
+}
+
+func TestMatchTracerMax(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	tracer := newMatchTracer(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	now := time.Unix(0, 0)
+	// every decision in its own second, so only the cap applies
+	tracer.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	for range traceMatchingMax + 1 {
+		tracer.excluded("/dev/null", traceExcludedDevice)
+	}
+	if tracer.total != traceMatchingMax || tracer.dropped != 1 {
+		t.Errorf("logged %d and dropped %d decisions, want %d and 1", tracer.total, tracer.dropped, traceMatchingMax)
+	}
+}
@@ -0,0 +1,102 @@
+// Scans without mount privileges or a user namespace, see WithUnprivileged.
+
+package capo
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"go.podman.io/storage"
+	"go.podman.io/storage/pkg/archive"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+)
+
+// Configure the scanner to run without mount privileges (CAP_SYS_ADMIN) or a
+// user namespace, e.g. in clusters refusing "buildah unshare": content is
+// extracted with ExtractorLayers, reading layer diffs directly from the store,
+// and the overlay driver is set up without making its home directory a
+// private mount. Files of the layers must be readable by the user running
+// capo, e.g. the owner of a rootful store. Creating the scanner fails if the
+// extractor is set to ExtractorMount.
+func WithUnprivileged(unprivileged bool) Option {
+	return func(s *Scanner) {
+		s.unprivileged = unprivileged
+	}
+}
+
+// setupUnprivileged selects the extractor of unprivileged mode, or fails if
+// the configured one needs mount privileges.
+func (s *Scanner) setupUnprivileged() error {
+	if !s.unprivileged {
+		return nil
+	}
+	if s.extractorKind == ExtractorMount {
+		return fmt.Errorf("%w: the %s extractor needs mount privileges, unprivileged mode uses %s",
+			ErrInvalidExtractor, ExtractorMount, ExtractorLayers)
+	}
+	s.extractorKind = ExtractorLayers
+	return nil
+}
+
+// Option of the overlay driver skipping the private mount of its home
+// directory, which needs mount privileges.
+const overlaySkipMountHome = "overlay.skip_mount_home"
+
+// unprivilegedStoreOptions returns opts with the overlay driver configured to
+// skip mounts needing privileges. Options of other drivers are kept as is.
+func unprivilegedStoreOptions(opts storage.StoreOptions) storage.StoreOptions {
+	if opts.GraphDriverName != "overlay" {
+		return opts
+	}
+	for _, o := range opts.GraphDriverOptions {
+		if strings.HasPrefix(o, overlaySkipMountHome+"=") {
+			return opts
+		}
+	}
+	opts.GraphDriverOptions = append(
+		append([]string{}, opts.GraphDriverOptions...), overlaySkipMountHome+"=true",
+	)
+	return opts
+}
+
+// checkLayers reads the top layer diff of an image, the base of the first
+// stage of the containerfile if present in the storage or else any image, to
+// check that the layers extractor can read layers as the current user. Skipped
+// with ExtractorMount.
+func (s *Scanner) checkLayers(cf *containerfile.Containerfile) Check {
+	if s.extractorKind != ExtractorLayers {
+		return Check{Name: CheckLayers, Status: CheckSkipped, Message: "layers are read from mounts by the mount extractor"}
+	}
+	image, err := s.doctorImage(cf)
+	if err != nil {
+		return Check{Name: CheckLayers, Status: CheckFailed, Message: err.Error()}
+	}
+	if image == nil {
+		return Check{Name: CheckLayers, Status: CheckWarning, Message: "no image in storage to read"}
+	}
+	if image.TopLayer == "" {
+		return Check{Name: CheckLayers, Status: CheckOK, Message: fmt.Sprintf("image %s has no layers", image.ID)}
+	}
+
+	compression := archive.Uncompressed
+	diff, err := s.store.Diff("", image.TopLayer, &storage.DiffOptions{Compression: &compression})
+	if err == nil {
+		_, err = io.Copy(io.Discard, diff)
+		if closeErr := diff.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return Check{
+			Name:   CheckLayers,
+			Status: CheckFailed,
+			Message: fmt.Sprintf(
+				"could not read layer %s of image %s (run capo as the owner of the storage): %v",
+				image.TopLayer, image.ID, err,
+			),
+		}
+	}
+	return Check{Name: CheckLayers, Status: CheckOK, Message: fmt.Sprintf("read layer %s", image.TopLayer)}
+}
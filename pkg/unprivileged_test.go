@@ -0,0 +1,133 @@
+//go:build unit
+
+package capo
+
+import (
+	"archive/tar"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.podman.io/storage"
+
+	"github.com/konflux-ci/capo/pkg/capotest"
+)
+
+func TestSetupUnprivileged(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		unprivileged bool
+		kind         ExtractorKind
+		expected     ExtractorKind
+		expectedErr  error
+	}{
+		"privileged default":   {expected: ""},
+		"privileged mount":     {kind: ExtractorMount, expected: ExtractorMount},
+		"unprivileged default": {unprivileged: true, expected: ExtractorLayers},
+		"unprivileged layers":  {unprivileged: true, kind: ExtractorLayers, expected: ExtractorLayers},
+		"unprivileged mount": {
+			unprivileged: true,
+			kind:         ExtractorMount,
+			expected:     ExtractorMount,
+			expectedErr:  ErrInvalidExtractor,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			s := &Scanner{unprivileged: tt.unprivileged, extractorKind: tt.kind}
+			if err := s.setupUnprivileged(); !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tt.expectedErr, err)
+			}
+			if s.extractorKind != tt.expected {
+				t.Errorf("expected extractor %q, got %q", tt.expected, s.extractorKind)
+			}
+		})
+	}
+}
+
+func TestUnprivilegedStoreOptions(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		opts     storage.StoreOptions
+		expected []string
+	}{
+		"overlay": {
+			opts: storage.StoreOptions{
+				GraphDriverName:    "overlay",
+				GraphDriverOptions: []string{"overlay.mountopt=nodev"},
+			},
+			expected: []string{"overlay.mountopt=nodev", "overlay.skip_mount_home=true"},
+		},
+		"overlay skip mount home set": {
+			opts: storage.StoreOptions{
+				GraphDriverName:    "overlay",
+				GraphDriverOptions: []string{"overlay.skip_mount_home=false"},
+			},
+			expected: []string{"overlay.skip_mount_home=false"},
+		},
+		"vfs": {
+			opts:     storage.StoreOptions{GraphDriverName: "vfs"},
+			expected: nil,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			opts := unprivilegedStoreOptions(tt.opts)
+			if diff := cmp.Diff(tt.expected, opts.GraphDriverOptions); diff != "" {
+				t.Errorf("unexpected driver options (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCheckLayers(t *testing.T) {
+	t.Parallel()
+	diff := buildTar(t, []tarEntry{{name: "etc/os-release", typeflag: tar.TypeReg, content: []byte("ID=fedora")}})
+	tests := map[string]struct {
+		kind     ExtractorKind
+		store    *capotest.Store
+		expected CheckStatus
+	}{
+		"mount extractor": {
+			kind:     ExtractorMount,
+			store:    capotest.NewStore(),
+			expected: CheckSkipped,
+		},
+		"readable layer": {
+			kind: ExtractorLayers,
+			store: capotest.NewStore().
+				AddImage(storage.Image{ID: "image", TopLayer: "l1"}, "").
+				AddLayer(storage.Layer{ID: "l1"}).
+				SetDiff("", "l1", diff),
+			expected: CheckOK,
+		},
+		"unreadable layer": {
+			kind: ExtractorLayers,
+			store: capotest.NewStore().
+				AddImage(storage.Image{ID: "image", TopLayer: "l1"}, "").
+				AddLayer(storage.Layer{ID: "l1"}),
+			expected: CheckFailed,
+		},
+		"no image": {
+			kind:     ExtractorLayers,
+			store:    capotest.NewStore(),
+			expected: CheckWarning,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			s := &Scanner{logger: slog.Default(), store: tt.store, extractorKind: tt.kind}
+			check := s.checkLayers(nil)
+			if check.Name != CheckLayers || check.Status != tt.expected {
+				t.Errorf("expected %s check %s, got %+v", CheckLayers, tt.expected, check)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+// Detection of builder stages the final stage doesn't depend on, see
+// WithPruneUnused.
+
+package capo
+
+import (
+	"github.com/konflux-ci/capo/pkg/containerfile"
+)
+
+// UnusedStage is a builder stage the final stage doesn't depend on: no
+// content is copied from it, from its chained stages or from stages copying
+// from it, it's not mounted by RUN --mount=from and it's not the base of such
+// a stage. Buildah skips building these stages by default.
+type UnusedStage struct {
+	// Alias of the stage.
+	Alias string `json:"alias"`
+	// Zero-based index of the stage.
+	Index int `json:"index"`
+}
+
+// Configure the scanner to skip builder stages the final stage doesn't depend
+// on (see UnusedStage), which have no content to scan, instead of mounting
+// their images to find nothing. The stages are reported in the output either
+// way. Disabled by default.
+func WithPruneUnused(prune bool) Option {
+	return func(s *Scanner) {
+		s.pruneUnused = prune
+	}
+}
+
+// unusedStages returns the builder stages of cf the final stage doesn't
+// depend on, in order.
+func unusedStages(cf containerfile.Containerfile) []UnusedStage {
+	final := cf.FinalStage()
+	if final == nil {
+		return nil
+	}
+
+	used := map[int]bool{final.Index: true}
+	queue := []*containerfile.Stage{final}
+	visit := func(ref string, index int) {
+		if stage := cf.ResolveRef(ref, index); stage != nil && !used[stage.Index] {
+			used[stage.Index] = true
+			queue = append(queue, stage)
+		}
+	}
+	for len(queue) > 0 {
+		stage := queue[0]
+		queue = queue[1:]
+		if stage.BaseRef != stage.Base {
+			visit(stage.BaseRef, stage.Index)
+		}
+		for _, cp := range stage.Copies {
+			if cp.Type != containerfile.CopyTypeContext {
+				visit(cp.From, stage.Index)
+			}
+		}
+		for _, mount := range stage.Mounts {
+			if mount.FromRaw != "" && mount.Pullspec == "" {
+				visit(mount.FromRaw, stage.Index)
+			}
+		}
+	}
+
+	var res []UnusedStage
+	for _, stage := range cf.BuilderStages() {
+		if !used[stage.Index] {
+			res = append(res, UnusedStage{Alias: stage.Alias, Index: stage.Index})
+		}
+	}
+	return res
+}
+
+// pruneUnused returns the package sources without the unused stages, also
+// among the chained stages of the sources. The sources aren't modified.
+func pruneUnused(sources []packageSource, unused []UnusedStage) []packageSource {
+	if len(unused) == 0 {
+		return sources
+	}
+	isUnused := make(map[int]bool, len(unused))
+	for _, stage := range unused {
+		isUnused[stage.Index] = true
+	}
+
+	res := make([]packageSource, 0, len(sources))
+	for _, source := range sources {
+		if source.kind != containerfile.StageKindExternal && isUnused[source.index] {
+			continue
+		}
+		source.descendants = pruneUnusedDescendants(source.descendants, isUnused)
+		res = append(res, source)
+	}
+	return res
+}
+
+func pruneUnusedDescendants(
+	descendants []*packageSourceDescendant, isUnused map[int]bool,
+) []*packageSourceDescendant {
+	res := make([]*packageSourceDescendant, 0, len(descendants))
+	for _, node := range descendants {
+		if isUnused[node.index] {
+			continue
+		}
+		pruned := *node
+		pruned.descendants = pruneUnusedDescendants(node.descendants, isUnused)
+		res = append(res, &pruned)
+	}
+	return res
+}
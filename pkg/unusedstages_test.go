@@ -0,0 +1,100 @@
+//go:build unit
+
+package capo
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+)
+
+func TestUnusedStages(t *testing.T) {
+	t.Parallel()
+	const ubi = "registry.access.redhat.com/ubi9/ubi:latest"
+	tests := map[string]struct {
+		stages   []containerfile.Stage
+		expected []UnusedStage
+	}{
+		"all stages used": {
+			stages: []containerfile.Stage{
+				{Alias: "deps", Index: 0, Base: ubi, BaseRef: ubi},
+				{Alias: "builder", Index: 1, Base: ubi, BaseRef: "deps"},
+				{Alias: "tools", Index: 2, Base: ubi, BaseRef: ubi},
+				{
+					Alias: "3", Index: 3, Base: ubi, BaseRef: ubi,
+					Copies: []containerfile.Copy{{From: "builder", Sources: []string{"/app"}}},
+					Mounts: []containerfile.Mount{{FromRaw: "tools", MountType: containerfile.MountTypeBind}},
+				},
+			},
+		},
+		"unused stages": {
+			stages: []containerfile.Stage{
+				{Alias: "builder", Index: 0, Base: ubi, BaseRef: ubi},
+				{
+					Alias: "test", Index: 1, Base: ubi, BaseRef: "builder",
+					Copies: []containerfile.Copy{{From: "fixtures", Sources: []string{"/data"}}},
+				},
+				{Alias: "fixtures", Index: 2, Base: ubi, BaseRef: ubi},
+				{
+					Alias: "3", Index: 3, Base: ubi, BaseRef: ubi,
+					Copies: []containerfile.Copy{
+						{From: "builder", Sources: []string{"/app"}},
+						{From: "context", Sources: []string{"/src"}, Type: containerfile.CopyTypeContext},
+						{From: ubi, Sources: []string{"/etc"}, Type: containerfile.CopyTypeExternal},
+					},
+				},
+			},
+			expected: []UnusedStage{
+				{Alias: "test", Index: 1},
+				{Alias: "fixtures", Index: 2},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			actual := unusedStages(containerfile.Containerfile{Stages: tc.stages})
+			if diff := cmp.Diff(tc.expected, actual); diff != "" {
+				t.Errorf("unusedStages() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPruneUnused(t *testing.T) {
+	t.Parallel()
+	sources := []packageSource{
+		{
+			kind: containerfile.StageKindBuilder, index: 0, alias: "builder",
+			descendants: []*packageSourceDescendant{
+				{index: 1, alias: "test"},
+				{index: 3, alias: "release", sources: []string{"/app"}},
+			},
+		},
+		{kind: containerfile.StageKindBuilder, index: 2, alias: "fixtures"},
+		{kind: containerfile.StageKindExternal, pullspec: "quay.io/konflux-ci/tools@sha256:abc"},
+	}
+	unused := []UnusedStage{{Alias: "test", Index: 1}, {Alias: "fixtures", Index: 2}}
+
+	expected := []packageSource{
+		{
+			kind: containerfile.StageKindBuilder, index: 0, alias: "builder",
+			descendants: []*packageSourceDescendant{
+				{index: 3, alias: "release", sources: []string{"/app"}},
+			},
+		},
+		{kind: containerfile.StageKindExternal, pullspec: "quay.io/konflux-ci/tools@sha256:abc"},
+	}
+	actual := pruneUnused(sources, unused)
+	opts := []cmp.Option{cmp.AllowUnexported(packageSource{}, packageSourceDescendant{}), cmpopts.EquateEmpty()}
+	if diff := cmp.Diff(expected, actual, opts...); diff != "" {
+		t.Errorf("pruneUnused() mismatch (-want +got):\n%s", diff)
+	}
+	if len(sources[0].descendants) != 2 {
+		t.Errorf("pruneUnused() modified the descendants of the passed sources")
+	}
+}
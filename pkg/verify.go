@@ -0,0 +1,121 @@
+// Verification that every source copied to a scratch final stage resulted in
+// attributed packages, see WithVerifyScratch.
+
+package capo
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+)
+
+// ErrScratchVerification is returned by ScratchVerificationFailed when the
+// scan of a scratch final stage found copies without packages.
+var ErrScratchVerification = fmt.Errorf(
+	"[ERR_SCRATCH_VERIFICATION] scratch final stage verification failed: %w", ErrScan,
+)
+
+// Configure the scanner to verify, for a final stage based on scratch, that
+// every source copied to it was attributed and resulted in packages. All
+// content of such an image is copied, so a COPY without packages likely is a
+// gap of the catalogers (e.g. an unrecognized binary) rather than content
+// without packages, and would go unnoticed as an empty SBOM. Problems are
+// reported as WarnEmptyCopy and WarnUnattributedCopy warnings, see
+// ScratchVerificationFailed. Packages are matched to copies by their origin,
+// so a copy is reported only if no package of its origin was copied through
+// it. Does nothing for other final stages. Disabled by default.
+func WithVerifyScratch(verify bool) Option {
+	return func(s *Scanner) {
+		s.verifyScratch = verify
+	}
+}
+
+// copyKey identifies the source of a COPY instruction of the final stage.
+type copyKey struct {
+	line        int
+	from        string
+	source      string
+	destination string
+}
+
+// verifyScratch returns a warning for every source copied to the scratch
+// final stage without packages traced through it by p, and for every source
+// the coverage reports as skipped. Returns nil for other final stages.
+func verifyScratch(
+	final *containerfile.Stage,
+	p *provenance,
+	items []PackageMetadataItem,
+	coverage *Coverage,
+) []Warning {
+	if final == nil || final.Base != "scratch" {
+		return nil
+	}
+
+	packages := make(map[copyKey]int)
+	for _, item := range items {
+		// every package is counted once per instruction it was copied through
+		seen := make(map[copyKey]bool)
+		for _, chain := range p.chains(item) {
+			key := copyKey{chain[0].Line, chain[0].From, chain[0].Source, chain[0].Destination}
+			if !seen[key] {
+				seen[key] = true
+				packages[key]++
+			}
+		}
+	}
+
+	res := make([]Warning, 0)
+	for _, cp := range final.Copies {
+		// named contexts aren't traced, they are reported by the coverage
+		if cp.Type == containerfile.CopyTypeContext {
+			continue
+		}
+		for _, source := range cp.Sources {
+			if packages[copyKey{cp.Line, cp.From, source, cp.Destination}] > 0 {
+				continue
+			}
+			res = append(res, Warning{
+				Code: WarnEmptyCopy,
+				Message: fmt.Sprintf(
+					"COPY --from=%s %s %s (line %d) in the scratch final stage resulted in no packages, "+
+						"the catalogers may not recognize its content",
+					cp.From, source, cp.Destination, cp.Line,
+				),
+			})
+		}
+	}
+	if coverage != nil {
+		for _, src := range coverage.Sources {
+			if src.Status != CoverageSkipped {
+				continue
+			}
+			res = append(res, Warning{
+				Code: WarnUnattributedCopy,
+				Message: fmt.Sprintf(
+					"source %s copied to %s in the scratch final stage is not attributed (%s)",
+					src.Source, src.Destination, src.Reason,
+				),
+			})
+		}
+	}
+	return res
+}
+
+// ScratchVerificationFailed returns an ErrScratchVerification error listing
+// the warnings of the scratch final stage verification (see
+// WithVerifyScratch) in the scan output, or nil if there are none.
+func ScratchVerificationFailed(res PackageMetadata) error {
+	messages := make([]string, 0)
+	for _, w := range res.Warnings {
+		if w.Code == WarnEmptyCopy || w.Code == WarnUnattributedCopy {
+			messages = append(messages, w.Message)
+		}
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	slices.Sort(messages)
+	return fmt.Errorf("%w: %s", ErrScratchVerification, strings.Join(messages, "; "))
+}
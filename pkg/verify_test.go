@@ -0,0 +1,109 @@
+//go:build unit
+
+package capo
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/konflux-ci/capo/pkg/containerfile"
+)
+
+func TestVerifyScratch(t *testing.T) {
+	t.Parallel()
+	builder := containerfile.Stage{
+		Alias:   "builder",
+		Base:    "docker.io/library/golang:1.26",
+		BaseRef: "docker.io/library/golang:1.26",
+	}
+	final := func(base string) containerfile.Stage {
+		return containerfile.Stage{
+			Alias:   "1",
+			Base:    base,
+			BaseRef: base,
+			Index:   1,
+			Kind:    containerfile.StageKindFinal,
+			Copies: []containerfile.Copy{
+				{
+					Sources: []string{"/app/bin/app"}, Destination: "/usr/bin/app",
+					From: "builder", Type: containerfile.CopyTypeBuilder, Line: 4,
+				},
+				{
+					Sources: []string{"/app/config.yaml"}, Destination: "/etc/app/",
+					From: "quay.io/org/config:v1", Type: containerfile.CopyTypeExternal, Line: 5,
+				},
+			},
+		}
+	}
+	index := func(i int) *int { return &i }
+	appPackage := PackageMetadataItem{
+		PackageURL: "pkg:golang/example.com/app@v1.0.0", OriginType: "builder", StageIndex: index(0),
+	}
+	skipped := &Coverage{Sources: []CoverageSource{
+		{Source: "LICENSE", Destination: "/licenses/", Status: CoverageSkipped, Reason: CoverageReasonContext},
+		{Source: "/app/bin/app", From: "builder", Destination: "/usr/bin/app", Status: CoverageAttributed},
+	}}
+
+	tests := map[string]struct {
+		base     string
+		items    []PackageMetadataItem
+		coverage *Coverage
+		expected []Warning
+	}{
+		"copy without packages": {
+			base:  "scratch",
+			items: []PackageMetadataItem{appPackage},
+			expected: []Warning{{
+				Code: WarnEmptyCopy,
+				Message: "COPY --from=quay.io/org/config:v1 /app/config.yaml /etc/app/ (line 5) in the scratch " +
+					"final stage resulted in no packages, the catalogers may not recognize its content",
+			}},
+		},
+		"unattributed copy": {
+			base: "scratch",
+			items: []PackageMetadataItem{appPackage, {
+				PackageURL: "pkg:generic/config@1", OriginType: "external", Pullspec: "quay.io/org/config:v1",
+			}},
+			coverage: skipped,
+			expected: []Warning{{
+				Code:    WarnUnattributedCopy,
+				Message: "source LICENSE copied to /licenses/ in the scratch final stage is not attributed (context)",
+			}},
+		},
+		"not scratch": {
+			base:     "registry.access.redhat.com/ubi9/ubi-micro:latest",
+			coverage: skipped,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			cf := containerfile.Containerfile{Stages: []containerfile.Stage{builder, final(tt.base)}}
+			p := explainSources(cf, nil, nil)
+
+			warnings := verifyScratch(cf.FinalStage(), p, tt.items, tt.coverage)
+			if diff := cmp.Diff(tt.expected, warnings, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("warnings mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestScratchVerificationFailed(t *testing.T) {
+	t.Parallel()
+	res := PackageMetadata{Warnings: []Warning{
+		{Code: WarnDuplicateAlias, Message: "alias defined twice"},
+	}}
+	if err := ScratchVerificationFailed(res); err != nil {
+		t.Errorf("expected no error for other warnings, got %v", err)
+	}
+
+	res.Warnings = append(res.Warnings, Warning{Code: WarnEmptyCopy, Message: "COPY resulted in no packages"})
+	if err := ScratchVerificationFailed(res); !errors.Is(err, ErrScratchVerification) {
+		t.Errorf("expected %v, got %v", ErrScratchVerification, err)
+	}
+}
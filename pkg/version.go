@@ -0,0 +1,118 @@
+// Versions of capo, the libraries it scans with and the formats it supports,
+// for the provenance of its output.
+
+package capo
+
+import (
+	"runtime/debug"
+
+	"github.com/konflux-ci/capo/pkg/stagereport"
+)
+
+// SchemaVersion is the version of the format of the output of a scan
+// (PackageMetadata, also in events, see WithEventHandler). Incremented on
+// incompatible changes.
+const SchemaVersion = 1
+
+// Modules of the libraries whose versions are reported by VersionInfo.
+const (
+	syftModule    = "github.com/anchore/syft"
+	storageModule = "go.podman.io/storage"
+)
+
+// VersionInfo describes the build of capo.
+type VersionInfo struct {
+	// Version of the capo module, "(devel)" if built from a checkout.
+	Version string `json:"version"`
+	// VCS revision the binary was built from and whether the checkout had
+	// uncommitted changes. Omitted if unknown, e.g. with "go run".
+	Revision string `json:"revision,omitempty"`
+	Modified bool   `json:"modified,omitempty"`
+	// Version of the Go toolchain the binary was built with.
+	GoVersion string `json:"go_version"`
+	// Versions of the syft library packages are found with and of the
+	// containers/storage library images are read with.
+	Syft    string `json:"syft"`
+	Storage string `json:"storage"`
+	// Versions of the formats read and written by capo.
+	Schemas SchemaVersions `json:"schemas"`
+}
+
+// SchemaVersions are the supported versions of the formats read and written
+// by capo.
+type SchemaVersions struct {
+	// Output of a scan, see SchemaVersion.
+	Output []int `json:"output"`
+	// Stage reports of builds, see stagereport.Version.
+	StageReport []int `json:"stage_report"`
+	// State files of incremental scans, see WithStateFile.
+	State []int `json:"state"`
+}
+
+// Version returns the versions of the build of capo, from the build
+// information of the running binary. Versions are empty if unknown.
+func Version() VersionInfo {
+	info, _ := debug.ReadBuildInfo()
+	return versionFromBuildInfo(info)
+}
+
+// versionFromBuildInfo returns the versions of the build described by info,
+// which may be nil.
+func versionFromBuildInfo(info *debug.BuildInfo) VersionInfo {
+	res := VersionInfo{
+		Schemas: SchemaVersions{
+			Output:      []int{SchemaVersion},
+			StageReport: []int{stagereport.Version},
+			State:       []int{stateVersion},
+		},
+	}
+	if info == nil {
+		return res
+	}
+
+	res.Version = info.Main.Version
+	res.GoVersion = info.GoVersion
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			res.Revision = setting.Value
+		case "vcs.modified":
+			res.Modified = setting.Value == "true"
+		}
+	}
+	for _, dep := range info.Deps {
+		version := dep.Version
+		// replaced modules are built from the replacement
+		if dep.Replace != nil {
+			version = dep.Replace.Version
+			if version == "" {
+				version = dep.Replace.Path
+			}
+		}
+		switch dep.Path {
+		case syftModule:
+			res.Syft = version
+		case storageModule:
+			res.Storage = version
+		}
+	}
+	return res
+}
+
+// Tool is a tool producing the output of a scan, recorded under tools for the
+// creation info of SBOMs assembled from it (e.g. SPDX creationInfo creators).
+type Tool struct {
+	Vendor string `json:"vendor"`
+	Name   string `json:"name"`
+	// Module version of the tool, e.g. "v1.46.0". Empty if unknown.
+	Version string `json:"version"`
+}
+
+// Tools returns the tools producing the output of a scan with the build: capo
+// and the syft library packages are found with.
+func (v VersionInfo) Tools() []Tool {
+	return []Tool{
+		{Vendor: "konflux-ci", Name: "capo", Version: v.Version},
+		{Vendor: "anchore", Name: "syft", Version: v.Syft},
+	}
+}
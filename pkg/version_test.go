@@ -0,0 +1,104 @@
+//go:build unit
+
+package capo
+
+import (
+	"runtime/debug"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/konflux-ci/capo/pkg/stagereport"
+)
+
+func TestVersionFromBuildInfo(t *testing.T) {
+	t.Parallel()
+	schemas := SchemaVersions{
+		Output:      []int{SchemaVersion},
+		StageReport: []int{stagereport.Version},
+		State:       []int{stateVersion},
+	}
+	tests := map[string]struct {
+		info     *debug.BuildInfo
+		expected VersionInfo
+	}{
+		"unknown": {
+			expected: VersionInfo{Schemas: schemas},
+		},
+		"release": {
+			info: &debug.BuildInfo{
+				GoVersion: "go1.26.0",
+				Main:      debug.Module{Path: "github.com/konflux-ci/capo", Version: "v1.2.0"},
+				Deps: []*debug.Module{
+					{Path: "github.com/anchore/syft", Version: "v1.46.0"},
+					{Path: "go.podman.io/storage", Version: "v1.63.1"},
+					{Path: "github.com/google/go-cmp", Version: "v0.7.0"},
+				},
+				Settings: []debug.BuildSetting{
+					{Key: "vcs.revision", Value: "4e0a50b"},
+					{Key: "vcs.modified", Value: "false"},
+				},
+			},
+			expected: VersionInfo{
+				Version:   "v1.2.0",
+				Revision:  "4e0a50b",
+				GoVersion: "go1.26.0",
+				Syft:      "v1.46.0",
+				Storage:   "v1.63.1",
+				Schemas:   schemas,
+			},
+		},
+		"modified checkout with replaced modules": {
+			info: &debug.BuildInfo{
+				GoVersion: "go1.26.0",
+				Main:      debug.Module{Path: "github.com/konflux-ci/capo", Version: "(devel)"},
+				Deps: []*debug.Module{
+					{
+						Path:    "github.com/anchore/syft",
+						Version: "v1.46.0",
+						Replace: &debug.Module{Path: "../syft"},
+					},
+					{
+						Path:    "go.podman.io/storage",
+						Version: "v1.63.1",
+						Replace: &debug.Module{Path: "github.com/example/storage", Version: "v1.63.2"},
+					},
+				},
+				Settings: []debug.BuildSetting{
+					{Key: "vcs.revision", Value: "4e0a50b"},
+					{Key: "vcs.modified", Value: "true"},
+				},
+			},
+			expected: VersionInfo{
+				Version:   "(devel)",
+				Revision:  "4e0a50b",
+				Modified:  true,
+				GoVersion: "go1.26.0",
+				Syft:      "../syft",
+				Storage:   "v1.63.2",
+				Schemas:   schemas,
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if diff := cmp.Diff(tt.expected, versionFromBuildInfo(tt.info)); diff != "" {
+				t.Errorf("unexpected version (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestVersionInfoTools(t *testing.T) {
+	t.Parallel()
+	info := VersionInfo{Version: "v1.2.0", Syft: "v1.46.0", Storage: "v1.63.1"}
+	expected := []Tool{
+		{Vendor: "konflux-ci", Name: "capo", Version: "v1.2.0"},
+		{Vendor: "anchore", Name: "syft", Version: "v1.46.0"},
+	}
+	if diff := cmp.Diff(expected, info.Tools()); diff != "" {
+		t.Errorf("unexpected tools (-want +got):\n%s", diff)
+	}
+}
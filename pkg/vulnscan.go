@@ -0,0 +1,149 @@
+// Vulnerability pre-check of the scanned packages with grype, see GrypeHook.
+
+package capo
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// Vulnerability is a known vulnerability of a package.
+type Vulnerability struct {
+	// ID of the vulnerability, e.g. "CVE-2024-2961".
+	ID string `json:"id"`
+	// Severity of the vulnerability as reported by the DB, e.g. "High".
+	Severity string `json:"severity,omitempty"`
+	// Versions of the package fixing the vulnerability.
+	FixedIn []string `json:"fixed_in,omitempty"`
+	// URL of the source of the vulnerability data.
+	DataSource string `json:"data_source,omitempty"`
+}
+
+// GrypeHook is a PostScanHook matching the package URLs of the scanned
+// packages against the vulnerability DB of grype and recording the matches
+// in the Vulnerabilities of the packages.
+type GrypeHook struct {
+	// Path of the grype binary, looked up in PATH if empty.
+	Path string
+	// Don't update the vulnerability DB, use the one already on disk.
+	Offline bool
+}
+
+func (h GrypeHook) Name() string {
+	return "grype"
+}
+
+func (h GrypeHook) Run(ctx context.Context, m *PackageMetadata) error {
+	purls := make([]string, 0, len(m.Packages))
+	for _, item := range m.Packages {
+		if item.PackageURL != "" {
+			purls = append(purls, item.PackageURL)
+		}
+	}
+	slices.Sort(purls)
+	purls = slices.Compact(purls)
+	if len(purls) == 0 {
+		return nil
+	}
+
+	f, err := os.CreateTemp("", "capo-purls-*.txt")
+	if err != nil {
+		return fmt.Errorf("failed to create package URL file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(strings.Join(purls, "\n") + "\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write package URL file: %w", err)
+	}
+
+	path := h.Path
+	if path == "" {
+		path = "grype"
+	}
+	cmd := exec.CommandContext(ctx, path, "purl:"+f.Name(), "-o", "json", "--quiet")
+	cmd.Env = append(os.Environ(), "GRYPE_CHECK_FOR_APP_UPDATE=false")
+	if h.Offline {
+		cmd.Env = append(cmd.Env, "GRYPE_DB_AUTO_UPDATE=false")
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("failed to run grype: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	matches, err := parseGrypeMatches(out)
+	if err != nil {
+		return err
+	}
+	applyVulnerabilities(m, matches)
+	return nil
+}
+
+// grypeReport is the subset of the grype JSON report used by GrypeHook.
+type grypeReport struct {
+	Matches []struct {
+		Vulnerability struct {
+			ID         string `json:"id"`
+			Severity   string `json:"severity"`
+			DataSource string `json:"dataSource"`
+			Fix        struct {
+				Versions []string `json:"versions"`
+			} `json:"fix"`
+		} `json:"vulnerability"`
+		Artifact struct {
+			PURL string `json:"purl"`
+		} `json:"artifact"`
+	} `json:"matches"`
+}
+
+// parseGrypeMatches returns the vulnerabilities of the grype JSON report by
+// package URL, deduplicated and sorted by ID.
+func parseGrypeMatches(data []byte) (map[string][]Vulnerability, error) {
+	var report grypeReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse grype report: %w", err)
+	}
+
+	res := make(map[string][]Vulnerability)
+	for _, match := range report.Matches {
+		purl := match.Artifact.PURL
+		vuln := match.Vulnerability
+		if purl == "" || vuln.ID == "" {
+			continue
+		}
+		if slices.ContainsFunc(res[purl], func(v Vulnerability) bool { return v.ID == vuln.ID }) {
+			continue
+		}
+		res[purl] = append(res[purl], Vulnerability{
+			ID:         vuln.ID,
+			Severity:   vuln.Severity,
+			FixedIn:    vuln.Fix.Versions,
+			DataSource: vuln.DataSource,
+		})
+	}
+	for _, vulns := range res {
+		slices.SortFunc(vulns, func(a, b Vulnerability) int { return cmp.Compare(a.ID, b.ID) })
+	}
+	return res, nil
+}
+
+// applyVulnerabilities records the vulnerabilities of every package by its
+// package URL.
+func applyVulnerabilities(m *PackageMetadata, byPURL map[string][]Vulnerability) {
+	for i := range m.Packages {
+		if vulns, ok := byPURL[m.Packages[i].PackageURL]; ok {
+			m.Packages[i].Vulnerabilities = slices.Clone(vulns)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+//go:build unit
+
+package capo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const grypeReportFixture = `{
+  "matches": [
+    {
+      "vulnerability": {
+        "id": "CVE-2024-33599",
+        "severity": "High",
+        "dataSource": "https://access.redhat.com/security/cve/CVE-2024-33599",
+        "fix": {"versions": ["2.34-100.el9_4.2"], "state": "fixed"}
+      },
+      "artifact": {"name": "glibc", "purl": "pkg:rpm/rhel/glibc@2.34-83.el9"}
+    },
+    {
+      "vulnerability": {
+        "id": "CVE-2024-2961",
+        "severity": "High",
+        "dataSource": "https://access.redhat.com/security/cve/CVE-2024-2961",
+        "fix": {"versions": ["2.34-100.el9_4.1"], "state": "fixed"}
+      },
+      "artifact": {"name": "glibc", "purl": "pkg:rpm/rhel/glibc@2.34-83.el9"}
+    },
+    {
+      "vulnerability": {
+        "id": "CVE-2024-2961",
+        "severity": "High",
+        "dataSource": "https://access.redhat.com/security/cve/CVE-2024-2961",
+        "fix": {"versions": ["2.34-100.el9_4.1"], "state": "fixed"}
+      },
+      "artifact": {"name": "glibc", "purl": "pkg:rpm/rhel/glibc@2.34-83.el9"}
+    },
+    {
+      "vulnerability": {"id": "GHSA-9wx4-h78v-vm56", "severity": "Medium", "fix": {"versions": [], "state": "not-fixed"}},
+      "artifact": {"name": "requests", "purl": "pkg:pypi/requests@2.32.3"}
+    }
+  ]
+}`
+
+func TestParseGrypeMatches(t *testing.T) {
+	t.Parallel()
+	expected := map[string][]Vulnerability{
+		"pkg:rpm/rhel/glibc@2.34-83.el9": {
+			{
+				ID:         "CVE-2024-2961",
+				Severity:   "High",
+				FixedIn:    []string{"2.34-100.el9_4.1"},
+				DataSource: "https://access.redhat.com/security/cve/CVE-2024-2961",
+			},
+			{
+				ID:         "CVE-2024-33599",
+				Severity:   "High",
+				FixedIn:    []string{"2.34-100.el9_4.2"},
+				DataSource: "https://access.redhat.com/security/cve/CVE-2024-33599",
+			},
+		},
+		"pkg:pypi/requests@2.32.3": {
+			{ID: "GHSA-9wx4-h78v-vm56", Severity: "Medium", FixedIn: []string{}},
+		},
+	}
+
+	actual, err := parseGrypeMatches([]byte(grypeReportFixture))
+	if err != nil {
+		t.Fatalf("parseGrypeMatches returned error: %v", err)
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("parseGrypeMatches() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := parseGrypeMatches([]byte("not json")); err == nil {
+		t.Error("expected error for invalid report")
+	}
+}
+
+func TestApplyVulnerabilities(t *testing.T) {
+	t.Parallel()
+	vulns := []Vulnerability{{ID: "CVE-2024-2961", Severity: "High"}}
+	m := PackageMetadata{Packages: []PackageMetadataItem{
+		{PackageURL: "pkg:rpm/rhel/glibc@2.34-83.el9", OriginType: "builder"},
+		{PackageURL: "pkg:rpm/rhel/python3@3.9.18-3.el9", OriginType: "intermediate"},
+	}}
+
+	applyVulnerabilities(&m, map[string][]Vulnerability{"pkg:rpm/rhel/glibc@2.34-83.el9": vulns})
+
+	expected := []PackageMetadataItem{
+		{PackageURL: "pkg:rpm/rhel/glibc@2.34-83.el9", OriginType: "builder", Vulnerabilities: vulns},
+		{PackageURL: "pkg:rpm/rhel/python3@3.9.18-3.el9", OriginType: "intermediate"},
+	}
+	if diff := cmp.Diff(expected, m.Packages); diff != "" {
+		t.Errorf("packages mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// hookFunc is a PostScanHook calling a function.
+type hookFunc func(context.Context, *PackageMetadata) error
+
+func (f hookFunc) Name() string {
+	return "test"
+}
+
+func (f hookFunc) Run(ctx context.Context, m *PackageMetadata) error {
+	return f(ctx, m)
+}
+
+func TestRunPostScanHooks(t *testing.T) {
+	t.Parallel()
+	var calls []string
+	record := func(name string, err error) PostScanHook {
+		return hookFunc(func(context.Context, *PackageMetadata) error {
+			calls = append(calls, name)
+			return err
+		})
+	}
+	s := &Scanner{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	WithPostScanHooks(record("first", nil), record("second", errors.New("boom")), record("third", nil))(s)
+
+	err := s.runPostScanHooks(context.Background(), &PackageMetadata{})
+	if !errors.Is(err, ErrPostScanHook) {
+		t.Fatalf("expected error wrapping %v, got: %v", ErrPostScanHook, err)
+	}
+	if diff := cmp.Diff([]string{"first", "second"}, calls); diff != "" {
+		t.Errorf("hook calls mismatch (-want +got):\n%s", diff)
+	}
+}